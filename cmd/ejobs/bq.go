@@ -0,0 +1,215 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/analysis"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+	"google.golang.org/api/iterator"
+)
+
+// A cannedQuery is a parameterized BigQuery query that "ejobs bq" runs
+// by name, so that its users need not know the table schemas.
+type cannedQuery struct {
+	name string
+	// args names the arguments of the query. Each becomes a query
+	// parameter of the same name; those named in intArgs must be integers.
+	args    []string
+	intArgs []string
+	desc    string
+	// columns are the columns of the result, in the order printed.
+	columns []string
+	// query returns the text of the query, given a function that returns
+	// the full name of a table.
+	query func(fullTableName func(tableID string) string) string
+}
+
+var cannedQueries = []*cannedQuery{
+	{
+		name:    "latest",
+		args:    []string{"binary", "limit"},
+		intArgs: []string{"limit"},
+		desc:    "the latest result of each module analyzed with BINARY, at most LIMIT of them",
+		columns: []string{"module_path", "version", "created_at", "error_category", "diagnostics"},
+		query: func(table func(string) string) string {
+			return `SELECT module_path, version, created_at, error_category, ARRAY_LENGTH(diagnostic) AS diagnostics
+FROM ` + "`" + table(analysis.TableName) + "`" + `
+WHERE binary_name = @binary
+QUALIFY ROW_NUMBER() OVER (PARTITION BY module_path ORDER BY created_at DESC) = 1
+ORDER BY module_path
+LIMIT @limit`
+		},
+	},
+	{
+		name:    "errors",
+		args:    []string{"jobid"},
+		desc:    "the number of results of job JOBID with each error category",
+		columns: []string{"error_category", "results"},
+		query: func(table func(string) string) string {
+			return `SELECT IF(error_category = '', 'NONE', error_category) AS error_category, COUNT(*) AS results
+FROM ` + "`" + table(analysis.TableName) + "`" + `
+WHERE job_id = @jobid
+GROUP BY 1
+ORDER BY results DESC`
+		},
+	},
+	{
+		name:    "scantime",
+		args:    []string{"days"},
+		intArgs: []string{"days"},
+		desc:    "percentiles of govulncheck scan times in the last DAYS days, by scan mode",
+		columns: []string{"scan_mode", "scans", "p50", "p90", "p99", "max"},
+		query: func(table func(string) string) string {
+			return `SELECT scan_mode, COUNT(*) AS scans,
+  APPROX_QUANTILES(scan_seconds, 100)[OFFSET(50)] AS p50,
+  APPROX_QUANTILES(scan_seconds, 100)[OFFSET(90)] AS p90,
+  APPROX_QUANTILES(scan_seconds, 100)[OFFSET(99)] AS p99,
+  MAX(scan_seconds) AS max
+FROM ` + "`" + table(govulncheck.TableName) + "`" + `
+WHERE created_at > TIMESTAMP_SUB(CURRENT_TIMESTAMP(), INTERVAL @days DAY) AND error = ''
+GROUP BY scan_mode
+ORDER BY scan_mode`
+		},
+	},
+}
+
+// lookupCannedQuery returns the canned query with the given name, or nil
+// if there is none.
+func lookupCannedQuery(name string) *cannedQuery {
+	for _, q := range cannedQueries {
+		if q.name == name {
+			return q
+		}
+	}
+	return nil
+}
+
+// usage returns the arguments of the query as they are written on the
+// command line.
+func (q *cannedQuery) usage() string {
+	var b strings.Builder
+	for _, a := range q.args {
+		b.WriteString(" " + strings.ToUpper(a))
+	}
+	return b.String()
+}
+
+// params returns the query parameters for the command-line arguments args.
+func (q *cannedQuery) params(args []string) ([]bigquery.Param, error) {
+	if len(args) != len(q.args) {
+		return nil, fmt.Errorf("wrong number of args for %s: want%s", q.name, q.usage())
+	}
+	var params []bigquery.Param
+	for i, name := range q.args {
+		var value any = args[i]
+		for _, n := range q.intArgs {
+			if n == name {
+				v, err := strconv.Atoi(args[i])
+				if err != nil || v <= 0 {
+					return nil, fmt.Errorf("%s: %s must be a positive integer", q.name, strings.ToUpper(name))
+				}
+				value = v
+			}
+		}
+		params = append(params, bigquery.Param{Name: name, Value: value})
+	}
+	return params, nil
+}
+
+func doBQ(ctx context.Context, args []string) error {
+	if bqList {
+		return listCannedQueries(os.Stdout)
+	}
+	if len(args) == 0 {
+		return errors.New("wrong number of args: want QUERYNAME [ARGS...]; see -list")
+	}
+	q := lookupCannedQuery(args[0])
+	if q == nil {
+		return fmt.Errorf("unknown query %q; see -list", args[0])
+	}
+	params, err := q.params(args[1:])
+	if err != nil {
+		return err
+	}
+	if bqDataset == "" {
+		return errors.New("need -dataset or GO_ECOSYSTEM_BIGQUERY_DATASET")
+	}
+	if *dryRun {
+		fmt.Printf("would run query in %s.%s with %v:\n%s\n", *projectID, bqDataset, params,
+			q.query(func(t string) string { return *projectID + "." + bqDataset + "." + t }))
+		return nil
+	}
+	// The client uses the application default credentials, usually
+	// those set up by "gcloud auth application-default login".
+	c, err := bigquery.NewClient(ctx, *projectID, bqDataset)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return runCannedQuery(ctx, c, os.Stdout, q, params)
+}
+
+// runCannedQuery runs q on db with params and prints the result to w as a
+// table.
+func runCannedQuery(ctx context.Context, db bigquery.DB, w io.Writer, q *cannedQuery, params []bigquery.Param) error {
+	iter, err := db.Query(ctx, q.query(db.FullTableName), params...)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 2, 8, 1, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(q.columns, "\t"))
+	for {
+		var row map[string]bq.Value
+		err := iter.Next(&row)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		var fields []string
+		for _, c := range q.columns {
+			fields = append(fields, formatValue(row[c]))
+		}
+		fmt.Fprintln(tw, strings.Join(fields, "\t"))
+	}
+	return tw.Flush()
+}
+
+// formatValue formats a value of a query result for printing.
+func formatValue(v bq.Value) string {
+	switch v := v.(type) {
+	case nil:
+		return "-"
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// listCannedQueries prints the names, arguments and descriptions of the
+// canned queries to w.
+func listCannedQueries(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 2, 8, 1, ' ', 0)
+	for _, q := range cannedQueries {
+		fmt.Fprintf(tw, "%s%s\t%s\n", q.name, q.usage(), q.desc)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,94 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+)
+
+func TestCannedQueryParams(t *testing.T) {
+	for _, test := range []struct {
+		query   string
+		args    []string
+		wantErr string
+	}{
+		{"latest", []string{"nilness", "10"}, ""},
+		{"latest", []string{"nilness"}, "want BINARY LIMIT"},
+		{"latest", []string{"nilness", "ten"}, "LIMIT must be a positive integer"},
+		{"errors", []string{"job1"}, ""},
+		{"scantime", []string{"0"}, "DAYS must be a positive integer"},
+	} {
+		q := lookupCannedQuery(test.query)
+		_, err := q.params(test.args)
+		if test.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s %v: %v", test.query, test.args, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("%s %v: got error %v, want one containing %q", test.query, test.args, err, test.wantErr)
+		}
+	}
+}
+
+func TestRunCannedQuery(t *testing.T) {
+	ctx := context.Background()
+	// Every query refers to exactly the parameters named by its args.
+	for _, q := range cannedQueries {
+		args := make([]string, len(q.args))
+		for i := range args {
+			args[i] = "1"
+		}
+		params, err := q.params(args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := runCannedQuery(ctx, bigquery.NewFake(), &strings.Builder{}, q, params); err != nil {
+			t.Errorf("%s: %v", q.name, err)
+		}
+	}
+
+	db := bigquery.NewFake()
+	db.QueryFunc = func(q string, params []bigquery.Param) ([]any, error) {
+		return []any{
+			map[string]bq.Value{"error_category": "NONE", "results": int64(12)},
+			map[string]bq.Value{"error_category": "LOAD", "results": int64(3)},
+		}, nil
+	}
+	var out strings.Builder
+	q := lookupCannedQuery("errors")
+	if err := runCannedQuery(ctx, db, &out, q, []bigquery.Param{{Name: "jobid", Value: "job1"}}); err != nil {
+		t.Fatal(err)
+	}
+	want := `error_category results
+NONE           12
+LOAD           3
+`
+	if got := out.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	for _, test := range []struct {
+		in   bq.Value
+		want string
+	}{
+		{nil, "-"},
+		{int64(3), "3"},
+		{1.5, "1.5"},
+		{time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC), "2023-05-01T10:00:00Z"},
+		{"x", "x"},
+	} {
+		if got := formatValue(test.in); got != test.want {
+			t.Errorf("formatValue(%v) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
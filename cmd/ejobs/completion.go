@@ -0,0 +1,117 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/pkgsite-metrics/internal/jobs"
+)
+
+func init() {
+	// Added here instead of in the commands literal to avoid an
+	// initialization cycle: the completion script is built from commands.
+	commands = append(commands, command{"completion", "bash|zsh",
+		"print a shell completion script",
+		doCompletion, nil})
+}
+
+// jobIDCacheFile returns the path of the file that holds the IDs of
+// recent jobs, one per line. It is written by "ejobs list" and read
+// by the shell completion scripts.
+func jobIDCacheFile() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ejobs", "jobids"), nil
+}
+
+// cacheJobIDs writes the IDs of js to the job ID cache file.
+func cacheJobIDs(js []jobs.Job) error {
+	file, err := jobIDCacheFile()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	var b strings.Builder
+	for _, j := range js {
+		fmt.Fprintln(&b, j.ID())
+	}
+	return os.WriteFile(file, []byte(b.String()), 0o644)
+}
+
+func doCompletion(_ context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("wrong number of args: want bash or zsh")
+	}
+	cacheFile, err := jobIDCacheFile()
+	if err != nil {
+		return err
+	}
+	switch args[0] {
+	case "bash":
+		writeBashCompletion(os.Stdout, cacheFile)
+	case "zsh":
+		// zsh can use bash completion scripts.
+		fmt.Println("autoload -U +X bashcompinit && bashcompinit")
+		writeBashCompletion(os.Stdout, cacheFile)
+	default:
+		return fmt.Errorf("unknown shell %q: want bash or zsh", args[0])
+	}
+	return nil
+}
+
+// commandFlags returns the flags of cmd, each preceded by a hyphen.
+func commandFlags(cmd command) []string {
+	var flags []string
+	if cmd.flagdefs != nil {
+		fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+		cmd.flagdefs(fs)
+		fs.VisitAll(func(f *flag.Flag) { flags = append(flags, "-"+f.Name) })
+	}
+	return flags
+}
+
+// writeBashCompletion writes a bash completion script for ejobs to w.
+// Commands that take job IDs complete them from cacheFile.
+func writeBashCompletion(w io.Writer, cacheFile string) {
+	var names []string
+	for _, cmd := range commands {
+		names = append(names, cmd.name)
+	}
+	var common []string
+	flag.VisitAll(func(f *flag.Flag) { common = append(common, "-"+f.Name) })
+
+	fmt.Fprintf(w, "_ejobs() {\n")
+	fmt.Fprintf(w, "\tlocal cur=${COMP_WORDS[COMP_CWORD]}\n")
+	fmt.Fprintf(w, "\tlocal cmd i\n")
+	fmt.Fprintf(w, "\tfor ((i=1; i<COMP_CWORD; i++)); do\n")
+	fmt.Fprintf(w, "\t\tcase ${COMP_WORDS[i]} in -*) ;; *) cmd=${COMP_WORDS[i]}; break;; esac\n")
+	fmt.Fprintf(w, "\tdone\n")
+	fmt.Fprintf(w, "\tcase $cmd in\n")
+	fmt.Fprintf(w, "\t'') COMPREPLY=($(compgen -W %q -- \"$cur\"));;\n", strings.Join(append(names, common...), " "))
+	for _, cmd := range commands {
+		words := strings.Join(commandFlags(cmd), " ")
+		if strings.Contains(cmd.argdoc, "JOBID") {
+			fmt.Fprintf(w, "\t%s) COMPREPLY=($(compgen -W \"%s $(cat %q 2>/dev/null)\" -- \"$cur\"));;\n",
+				cmd.name, words, cacheFile)
+		} else {
+			fmt.Fprintf(w, "\t%s) COMPREPLY=($(compgen -o default -W %q -- \"$cur\"));;\n", cmd.name, words)
+		}
+	}
+	fmt.Fprintf(w, "\tesac\n")
+	fmt.Fprintf(w, "}\n")
+	fmt.Fprintf(w, "complete -F _ejobs ejobs\n")
+}
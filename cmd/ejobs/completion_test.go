@@ -0,0 +1,184 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/pkgsite-metrics/internal/jobs"
+)
+
+func TestWriteBashCompletion(t *testing.T) {
+	var buf bytes.Buffer
+	writeBashCompletion(&buf, "/cache/jobids")
+	script := buf.String()
+
+	for _, test := range []struct {
+		name string
+		want string // line of the script
+	}{
+		// Without a command, complete commands and common flags.
+		{"commands", `	'') COMPREPLY=($(compgen -W "`},
+		// Commands that take job IDs complete them from the cache.
+		{"job IDs", `	show) COMPREPLY=($(compgen -W "-format $(cat "/cache/jobids" 2>/dev/null)" -- "$cur"));;`},
+		{"no flags", `	cancel) COMPREPLY=($(compgen -W " $(cat "/cache/jobids" 2>/dev/null)" -- "$cur"));;`},
+		// Other commands complete their flags and file names.
+		{"files", `	completion) COMPREPLY=($(compgen -o default -W "" -- "$cur"));;`},
+		{"registration", `complete -F _ejobs ejobs`},
+	} {
+		if !strings.Contains(script, test.want) {
+			t.Errorf("%s: script does not contain %q:\n%s", test.name, test.want, script)
+		}
+	}
+
+	// The first completion offers every command and common flag.
+	_, after, _ := strings.Cut(script, `'') COMPREPLY=($(compgen -W "`)
+	words, _, _ := strings.Cut(after, `"`)
+	got := strings.Fields(words)
+	for _, want := range []string{"list", "show", "start", "completion", "-env", "-n"} {
+		if !slices.Contains(got, want) {
+			t.Errorf("commands: got %v, want %s among them", got, want)
+		}
+	}
+	// The flags of a command are offered for it.
+	_, after, _ = strings.Cut(script, "\tstart) ")
+	line, _, _ := strings.Cut(after, "\n")
+	for _, want := range []string{"-noshare", "-owntable", "-corpusdir"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("start: completion %q does not offer %s", line, want)
+		}
+	}
+}
+
+func TestCacheJobIDs(t *testing.T) {
+	// os.UserCacheDir uses XDG_CACHE_HOME on Unix systems.
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	file, err := jobIDCacheFile()
+	if err != nil {
+		t.Skipf("no cache directory: %v", err)
+	}
+	tm := time.Date(2023, 3, 11, 1, 2, 3, 0, time.UTC)
+	for _, test := range []struct {
+		name string
+		jobs []jobs.Job
+		want string
+	}{
+		{"two", []jobs.Job{
+			*jobs.NewJob("a", tm, "url", "bin", "h", ""),
+			*jobs.NewJob("b", tm.Add(time.Hour), "url", "bin", "h", ""),
+		}, "a-230311-010203\nb-230311-020203\n"},
+		// Each list replaces the cached IDs.
+		{"one", []jobs.Job{*jobs.NewJob("c", tm, "url", "bin", "h", "")}, "c-230311-010203\n"},
+		{"none", nil, ""},
+	} {
+		if err := cacheJobIDs(test.jobs); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		got, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if string(got) != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestWriteJobList(t *testing.T) {
+	tm := time.Date(2023, 3, 11, 1, 2, 3, 0, time.UTC)
+	j := jobs.NewJob("u", tm, "url", "bin", "h", "")
+	j.NumEnqueued = 3
+	j.NumStarted = 2
+	j.NumSucceeded = 1
+
+	for _, test := range []struct {
+		name   string
+		jobs   []jobs.Job
+		asJSON bool
+		want   []string // lines of a text output, without trailing spaces
+	}{
+		{"text", []jobs.Job{*j}, false, []string{
+			"ID              User Start Time           Started Finished Total Canceled Stale",
+			"u-230311-010203 u    2023-03-11T01:02:03Z 2       1        3     false    false",
+		}},
+		{"no jobs", nil, false, []string{
+			"ID User Start Time Started Finished Total Canceled Stale",
+		}},
+		// JSON outputs hold the jobs as the worker returned them.
+		{"json", []jobs.Job{*j}, true, nil},
+		{"json no jobs", nil, true, nil},
+	} {
+		var buf bytes.Buffer
+		if err := writeJobList(&buf, test.jobs, test.asJSON); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if test.asJSON {
+			var got []jobs.Job
+			if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+			if diff := cmp.Diff(test.jobs, got); diff != "" {
+				t.Errorf("%s: mismatch (-want, +got):\n%s", test.name, diff)
+			}
+			continue
+		}
+		got := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+		for i := range got {
+			got[i] = strings.TrimRight(got[i], " ")
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("%s: mismatch (-want, +got):\n%s", test.name, diff)
+		}
+	}
+}
+
+func TestWriteDescription(t *testing.T) {
+	tm := time.Date(2023, 3, 11, 1, 2, 3, 0, time.UTC)
+	d := &jobs.Description{
+		Job:           jobs.NewJob("u", tm, "url", "bin", "h", "args"),
+		Rows:          &jobs.RowCounts{Rows: 3, Succeeded: 2, Errored: 1},
+		Discrepancies: []string{"1 task has no row"},
+	}
+	for _, test := range []struct {
+		format string
+		want   []string // beginnings of lines of the output
+	}{
+		{"text", []string{"User: u", "Binary: bin", "BinaryArgs: args", "RowsRows: 3", "RowsSucceeded: 2", "WARNING: 1 task has no row"}},
+		{"json", []string{`"User": "u"`, `"Succeeded": 2`, `"Discrepancies": [`}},
+	} {
+		var buf bytes.Buffer
+		if err := writeDescription(&buf, d, test.format); err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(buf.String(), "\n")
+		for _, want := range test.want {
+			if !slices.ContainsFunc(lines, func(l string) bool {
+				return strings.HasPrefix(strings.TrimSpace(l), want)
+			}) {
+				t.Errorf("%s: output does not have a line %q:\n%s", test.format, want, buf.String())
+			}
+		}
+	}
+	// The JSON output decodes to the description.
+	var buf bytes.Buffer
+	if err := writeDescription(&buf, d, "json"); err != nil {
+		t.Fatal(err)
+	}
+	var got jobs.Description
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(d, &got); diff != "" {
+		t.Errorf("json: mismatch (-want, +got):\n%s", diff)
+	}
+}
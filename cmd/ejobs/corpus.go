@@ -0,0 +1,215 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	modzip "golang.org/x/mod/zip"
+	"golang.org/x/pkgsite-metrics/internal/modules"
+	"golang.org/x/pkgsite-metrics/internal/version"
+	"google.golang.org/api/option"
+)
+
+// A private corpus is a set of modules that are not on the proxy, which a
+// job scans instead of the server's corpus. It is uploaded to the directory
+// private-corpora/USER/NAME of the project's bucket, laid out like a Go
+// module proxy, with an index file listing its modules.
+const (
+	privateCorporaDir  = "private-corpora"
+	privateCorpusIndex = "modules.txt"
+)
+
+// A corpusModule is a module of a private corpus.
+type corpusModule struct {
+	mod     module.Version
+	zipFile string // module zip, if the module is one
+	dir     string // module root directory, if the module is a go.mod tree
+}
+
+// readCorpusDir returns the modules in dir: module zips, in the format
+// served by Go module proxies, and subdirectories containing a go.mod file.
+// If dir itself contains a go.mod file, it is the only module. Modules read
+// from directories are given version treeVersion.
+func readCorpusDir(dir, treeVersion string) ([]corpusModule, error) {
+	if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+		m, err := readModuleTree(dir, treeVersion)
+		if err != nil {
+			return nil, err
+		}
+		return []corpusModule{m}, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var mods []corpusModule
+	for _, e := range entries {
+		p := filepath.Join(dir, e.Name())
+		switch {
+		case e.IsDir():
+			if _, err := os.Stat(filepath.Join(p, "go.mod")); err != nil {
+				continue
+			}
+			m, err := readModuleTree(p, treeVersion)
+			if err != nil {
+				return nil, err
+			}
+			mods = append(mods, m)
+		case strings.HasSuffix(e.Name(), ".zip"):
+			m, err := readModuleZip(p)
+			if err != nil {
+				return nil, err
+			}
+			mods = append(mods, m)
+		}
+	}
+	if len(mods) == 0 {
+		return nil, fmt.Errorf("no module zips or go.mod trees in %s", dir)
+	}
+	return mods, nil
+}
+
+// readModuleTree returns the module rooted at dir, with the given version.
+func readModuleTree(dir, version string) (corpusModule, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		return corpusModule{}, err
+	}
+	modPath := modfile.ModulePath(data)
+	if modPath == "" {
+		return corpusModule{}, fmt.Errorf("%s: no module path in go.mod", dir)
+	}
+	mv := module.Version{Path: modPath, Version: version}
+	if err := module.Check(mv.Path, mv.Version); err != nil {
+		return corpusModule{}, fmt.Errorf("%s: %v", dir, err)
+	}
+	return corpusModule{mod: mv, dir: dir}, nil
+}
+
+// readModuleZip returns the module in the zip file, whose files must all
+// be in a directory MODULE@VERSION.
+func readModuleZip(file string) (corpusModule, error) {
+	zr, err := zip.OpenReader(file)
+	if err != nil {
+		return corpusModule{}, err
+	}
+	defer zr.Close()
+	if len(zr.File) == 0 {
+		return corpusModule{}, fmt.Errorf("%s: empty zip", file)
+	}
+	// Module paths cannot contain "@", and versions cannot contain "/".
+	modPath, rest, ok := strings.Cut(zr.File[0].Name, "@")
+	version, _, ok2 := strings.Cut(rest, "/")
+	if !ok || !ok2 {
+		return corpusModule{}, fmt.Errorf("%s: files are not in a MODULE@VERSION directory", file)
+	}
+	mv := module.Version{Path: modPath, Version: version}
+	// CheckZip also checks that all files are in that directory.
+	if _, err := modzip.CheckZip(mv, file); err != nil {
+		return corpusModule{}, fmt.Errorf("%s: %v", file, err)
+	}
+	return corpusModule{mod: mv, zipFile: file}, nil
+}
+
+// writeZip writes the zip of m to w.
+func (m corpusModule) writeZip(w io.Writer) error {
+	if m.dir != "" {
+		return modzip.CreateFromDir(w, m.mod, m.dir)
+	}
+	f, err := os.Open(m.zipFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// corpusIndex returns the index of a private corpus of mods, in the format
+// of a corpus file.
+func corpusIndex(mods []corpusModule) []byte {
+	var buf bytes.Buffer
+	for _, m := range mods {
+		fmt.Fprintf(&buf, "%s %s 0\n", m.mod.Path, m.mod.Version)
+	}
+	return buf.Bytes()
+}
+
+// treeVersion returns the version of modules read from go.mod trees that
+// are uploaded at time t. Each upload has a new version, so scans of
+// changed modules are not skipped as duplicates.
+func treeVersion(t time.Time) string {
+	return version.Pseudo(t, "private")
+}
+
+// uploadPrivateCorpus uploads the modules in dir as the private corpus
+// USER/BASE, where BASE is the base name of dir, and returns the name of
+// the corpus.
+func uploadPrivateCorpus(ctx context.Context, dir string) (name string, err error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	name = os.Getenv("USER") + "/" + filepath.Base(abs)
+	mods, err := readCorpusDir(abs, treeVersion(time.Now()))
+	if err != nil {
+		return "", err
+	}
+	corpusDir := path.Join(privateCorporaDir, name)
+	if *dryRun {
+		for _, m := range mods {
+			fmt.Printf("dryrun: upload %s to %s\n", m.mod, corpusDir)
+		}
+		return name, nil
+	}
+	ts, err := accessTokenSource(ctx)
+	if err != nil {
+		return "", err
+	}
+	c, err := storage.NewClient(ctx, option.WithTokenSource(ts))
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+	bucket := c.Bucket(*projectID)
+	for _, m := range mods {
+		zipName, err := modules.ZipFile(m.mod.Path, m.mod.Version)
+		if err != nil {
+			return "", err
+		}
+		fmt.Printf("Uploading %s.\n", m.mod)
+		w := bucket.Object(path.Join(corpusDir, zipName)).NewWriter(ctx)
+		if err := m.writeZip(w); err != nil {
+			w.Close()
+			return "", fmt.Errorf("%s: %v", m.mod, err)
+		}
+		if err := w.Close(); err != nil {
+			return "", err
+		}
+	}
+	// Write the index last, so that it only lists uploaded modules.
+	w := bucket.Object(path.Join(corpusDir, privateCorpusIndex)).NewWriter(ctx)
+	if _, err := w.Write(corpusIndex(mods)); err != nil {
+		w.Close()
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return name, nil
+}
@@ -0,0 +1,129 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/mod/module"
+)
+
+func writeTestFile(t *testing.T, name, contents string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTestZip(t *testing.T, name string, files map[string]string) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for n, contents := range files {
+		w, err := zw.Create(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, name, buf.String())
+}
+
+func TestReadCorpusDir(t *testing.T) {
+	dir := t.TempDir()
+	writeTestZip(t, filepath.Join(dir, "a.zip"), map[string]string{
+		"example.com/a@v1.0.0/go.mod": "module example.com/a\n",
+		"example.com/a@v1.0.0/a.go":   "package a\n",
+	})
+	writeTestFile(t, filepath.Join(dir, "b", "go.mod"), "module example.com/b\n")
+	writeTestFile(t, filepath.Join(dir, "b", "b.go"), "package b\n")
+	writeTestFile(t, filepath.Join(dir, "notes", "README"), "not a module\n")
+
+	version := treeVersion(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	if want := "v0.0.0-20240102030405-private"; version != want {
+		t.Errorf("treeVersion: got %q, want %q", version, want)
+	}
+	mods, err := readCorpusDir(dir, version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []module.Version
+	for _, m := range mods {
+		got = append(got, m.mod)
+	}
+	want := []module.Version{
+		{Path: "example.com/a", Version: "v1.0.0"},
+		{Path: "example.com/b", Version: version},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	wantIndex := "example.com/a v1.0.0 0\nexample.com/b " + version + " 0\n"
+	if got := string(corpusIndex(mods)); got != wantIndex {
+		t.Errorf("index: got %q, want %q", got, wantIndex)
+	}
+
+	// The zip of the go.mod tree is a module zip.
+	var buf bytes.Buffer
+	if err := mods[1].writeZip(&buf); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	prefix := "example.com/b@" + version + "/"
+	if diff := cmp.Diff([]string{prefix + "b.go", prefix + "go.mod"}, names); diff != "" {
+		t.Errorf("zip files mismatch (-want, +got):\n%s", diff)
+	}
+
+	// A directory with a go.mod file is a single module.
+	mods, err = readCorpusDir(filepath.Join(dir, "b"), version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mods) != 1 || mods[0].mod.Path != "example.com/b" {
+		t.Errorf("got %v, want only example.com/b", mods)
+	}
+}
+
+func TestReadModuleZipErrors(t *testing.T) {
+	dir := t.TempDir()
+	for name, files := range map[string]map[string]string{
+		"noversion.zip": {"example.com/a/go.mod": "module example.com/a\n"},
+		"mixed.zip": {
+			"example.com/a@v1.0.0/go.mod": "module example.com/a\n",
+			"example.com/b@v1.0.0/b.go":   "package b\n",
+		},
+	} {
+		file := filepath.Join(dir, name)
+		writeTestZip(t, file, files)
+		if _, err := readModuleZip(file); err == nil {
+			t.Errorf("%s: got nil, want error", name)
+		}
+	}
+	if _, err := readCorpusDir(t.TempDir(), "v1.0.0"); err == nil {
+		t.Error("empty directory: got nil, want error")
+	}
+}
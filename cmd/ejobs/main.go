@@ -9,11 +9,14 @@ import (
 	"bytes"
 	"context"
 	"crypto/md5"
+	"crypto/sha256"
 	"debug/buildinfo"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"hash"
 	"io"
 	"net/http"
 	"net/url"
@@ -21,70 +24,215 @@ import (
 	"path"
 	"path/filepath"
 	"reflect"
+	"runtime/debug"
 	"strings"
 	"text/tabwriter"
 	"time"
-	"unicode"
 
 	"cloud.google.com/go/storage"
 	"golang.org/x/oauth2"
 	"golang.org/x/pkgsite-metrics/internal/analysis"
+	"golang.org/x/pkgsite-metrics/internal/audit"
+	"golang.org/x/pkgsite-metrics/internal/config"
 	"golang.org/x/pkgsite-metrics/internal/jobs"
 	"google.golang.org/api/impersonate"
 	"google.golang.org/api/option"
 )
 
-const (
-	projectID           = "go-ecosystem"
-	uploaderMetadataKey = "uploader"
-)
+const uploaderMetadataKey = "uploader"
 
 // Common flags
 var (
-	env    = flag.String("env", "prod", "worker environment (dev or prod)")
-	dryRun = flag.Bool("n", false, "print actions but do not execute them")
+	env       = flag.String("env", "prod", "worker environment (dev or prod)")
+	dryRun    = flag.Bool("n", false, "print actions but do not execute them")
+	projectID = flag.String("project", config.GetEnv("GO_ECOSYSTEM_PROJECT", "go-ecosystem"),
+		"Google Cloud project ID, also the name of the analysis binary bucket (default from GO_ECOSYSTEM_PROJECT)")
+	impersonateFlag = flag.String("impersonate", os.Getenv("GO_ECOSYSTEM_IMPERSONATE"),
+		"service account to impersonate (default from GO_ECOSYSTEM_IMPERSONATE, else impersonate@PROJECT.iam.gserviceaccount.com)")
 )
 
 var (
-	minImporters int           // for start
+	minImporters int           // for start and plan
+	analyzers    string        // for start and run
+	priority     string        // for start
+	buildTags    string        // for start and run
+	goflags      string        // for start and run
+	goVersion    string        // for start and run
+	depSnapshot  bool          // for start and run
+	batchSize    int           // for start and run
 	waitInterval time.Duration // for wait
 	force        bool          // for results
-	outfile      string        // for results
+	refresh      bool          // for results
+	resultsMod   string        // for results
+	resultsCat   string        // for results
+	resultsAna   string        // for results
+	merge        bool          // for results
+	outfile      string        // for results and query
+	shardSize    string        // for results
+	splitBy      string        // for results
+	jsonOutput   bool          // for list, plan, summary, top and audit
+	summaryBy    string        // for summary
+	corpusFile   string        // for plan
+	corpusDir    string        // for start
+	noShare      bool          // for start
+	ownTable     bool          // for start
+	notify       string        // for start
+	labels       string        // for start
+	topInterval  time.Duration // for top
+	showFormat   string        // for show
+	auditLimit   int           // for audit
+	listUser     string        // for list
+	listState    string        // for list
+	listSince    string        // for list
+	listUntil    string        // for list
+	listLimit    int           // for list
+	bqDataset    string        // for bq
+	bqList       bool          // for bq
 )
 
 var commands = []command{
-	{"list", "",
-		"list jobs",
-		doList, nil},
-	{"show", "JOBID...",
+	{"list", "[-user USER] [-state STATE] [-since DATE] [-until DATE] [-n N] [-json]",
+		"list jobs, most recent first",
+		doList,
+		func(fs *flag.FlagSet) {
+			fs.StringVar(&listUser, "user", "", "only jobs started by this user")
+			fs.StringVar(&listState, "state", "", "only jobs in this state: running, finished, canceled or stale")
+			fs.StringVar(&listSince, "since", "", "only jobs started on or after this date, YYYY-MM-DD (default a week ago)")
+			fs.StringVar(&listUntil, "until", "", "only jobs started before this date, YYYY-MM-DD")
+			fs.IntVar(&listLimit, "n", 0, "list at most N jobs (0: all)")
+			fs.BoolVar(&jsonOutput, "json", false, "output jobs as JSON")
+		},
+	},
+	{"show", "[-format text|json] JOBID...",
 		"display information about jobs in the last 7 days",
-		doShow, nil},
+		doShow,
+		func(fs *flag.FlagSet) {
+			fs.StringVar(&showFormat, "format", "text", "output format: text or json")
+		},
+	},
 	{"cancel", "JOBID...",
 		"cancel the jobs",
 		doCancel, nil},
-	{"start", "[-min MIN_IMPORTERS] BINARY ARGS...",
+	{"merge", "JOBID...",
+		"copy the results of jobs started with -owntable to the shared table, and drop the jobs' tables",
+		doMerge, nil},
+	{"droptable", "JOBID...",
+		"drop the tables of jobs started with -owntable, with their results",
+		doDropTable, nil},
+	{"start", "[-min MIN_IMPORTERS] [-corpusdir DIR] [-analyzers A1,A2,...] [-priority P] [-tags T1,T2,...] [-goflags FLAGS] [-go VERSION] [-depsnapshot] [-batch N] [-noshare] [-owntable] [-notify URL] [-labels K1:V1,K2:V2] BINARY ARGS...",
 		"start a job",
 		doStart,
 		func(fs *flag.FlagSet) {
 			fs.IntVar(&minImporters, "min", -1,
 				"run on modules with at least this many importers (<0: use server default of 10)")
+			fs.StringVar(&analyzers, "analyzers", "",
+				"comma-separated analyzers for a multi-analyzer binary to run (empty: binary's default)")
+			fs.StringVar(&priority, "priority", "",
+				"task priority: high, normal or low (empty: normal)")
+			fs.StringVar(&corpusDir, "corpusdir", "",
+				"upload the module zips and go.mod trees in this directory and run on them instead of the server's corpus")
+			fs.BoolVar(&noShare, "noshare", false,
+				"scan every module, even those that unfinished jobs with the same work are scanning, instead of using their results")
+			fs.BoolVar(&ownTable, "owntable", false,
+				"write the results to a table of the job's own, to be merged into the shared table or dropped when done; implies -noshare")
+			fs.StringVar(&notify, "notify", "",
+				"when the job is done, POST its summary to this https webhook URL, or email it to a mailto: address")
+			fs.StringVar(&labels, "labels", "",
+				"comma-separated experiment labels of the form KEY:VALUE to record on every result row")
+			addBuildFlags(fs)
+		},
+	},
+	{"plan", "[-min MIN_IMPORTERS] [-file FILE] [-json]",
+		"list the modules that start would scan, without starting a job",
+		doPlan,
+		func(fs *flag.FlagSet) {
+			fs.IntVar(&minImporters, "min", -1,
+				"include modules with at least this many importers (<0: use server default of 10)")
+			fs.StringVar(&corpusFile, "file", "",
+				"file, glob or gs:// URL of modules on the worker (empty: use the server's corpus)")
+			fs.BoolVar(&jsonOutput, "json", false, "output the plan as JSON")
+		},
+	},
+	{"run", "[-analyzers A1,A2,...] [-tags T1,T2,...] [-goflags FLAGS] [-go VERSION] [-depsnapshot] [-batch N] MODULE@VERSION BINARY ARGS...",
+		"scan a single module synchronously and print the result",
+		doRun,
+		func(fs *flag.FlagSet) {
+			fs.StringVar(&analyzers, "analyzers", "",
+				"comma-separated analyzers for a multi-analyzer binary to run (empty: binary's default)")
+			addBuildFlags(fs)
 		},
 	},
-	{"wait", "JOBID",
-		"do not exit until JOBID is done",
+	{"wait", "[-i DURATION] JOBID...",
+		"do not exit until all the jobs are done",
 		doWait,
 		func(fs *flag.FlagSet) {
 			fs.DurationVar(&waitInterval, "i", 0, "display updates at this interval")
 		},
 	},
-	{"results", "[-f] [-o FILE.json] JOBID",
-		"download results as JSON",
+	{"results", "[-f] [-refresh] [-module PREFIX] [-category CAT] [-analyzer NAME] [-o FILE.json [-shard-size SIZE]] [-split-by analyzer [-o DIR]] [-merge] JOBID...",
+		"download results as JSON; results of finished jobs are cached, unless filtered",
 		doResults,
 		func(fs *flag.FlagSet) {
 			fs.BoolVar(&force, "f", false, "download even if unfinished")
+			fs.BoolVar(&refresh, "refresh", false, "download even if cached")
+			fs.StringVar(&resultsMod, "module", "", "only results of modules with this path prefix")
+			fs.StringVar(&resultsCat, "category", "", "only results with this error category")
+			fs.StringVar(&resultsAna, "analyzer", "", "only diagnostics of this analyzer")
+			fs.BoolVar(&merge, "merge", false, "merge the results of several jobs, keeping the latest result for each module and binary")
+			fs.StringVar(&outfile, "o", "", "output filename")
+			fs.StringVar(&shardSize, "shard-size", "",
+				"split the output into files FILE-0001.json, ... of about this size, like 500MB, described by FILE-manifest.json")
+			fs.StringVar(&splitBy, "split-by", "",
+				"with \"analyzer\", write the results of each analyzer to ANALYZER.json in the directory -o (default: the current directory)")
+		},
+	},
+	{"query", "[-o FILE.json] JOBID 'FIELD=VALUE ...'",
+		"filter cached results by module (path prefix), analyzer or category",
+		doQuery,
+		func(fs *flag.FlagSet) {
 			fs.StringVar(&outfile, "o", "", "output filename")
 		},
 	},
+	{"top", "[-i DURATION] [-json]",
+		"show the health of the worker and its task queues",
+		doTop,
+		func(fs *flag.FlagSet) {
+			fs.DurationVar(&topInterval, "i", 0, "refresh at this interval (0: show once)")
+			fs.BoolVar(&jsonOutput, "json", false, "output the health and queue statistics as JSON")
+		},
+	},
+	{"summary", "[-by analyzer|category|severity] [-json] JOBID",
+		"count the diagnostics in cached results, grouped by analyzer, category or severity",
+		doSummary,
+		func(fs *flag.FlagSet) {
+			fs.StringVar(&summaryBy, "by", "analyzer", "group diagnostics by analyzer, category or severity")
+			fs.BoolVar(&jsonOutput, "json", false, "output the summary as JSON")
+		},
+	},
+	{"diff", "[-json] BINARY OLD_HASH NEW_HASH",
+		"summarize how the diagnostics of a binary changed between two of its versions",
+		doDiff,
+		func(fs *flag.FlagSet) {
+			fs.BoolVar(&jsonOutput, "json", false, "output the diff as JSON")
+		},
+	},
+	{"audit", "[-n N] [-json]",
+		"list recent administrative actions: enqueues, cancellations and skip-list changes",
+		doAudit,
+		func(fs *flag.FlagSet) {
+			fs.IntVar(&auditLimit, "n", 50, "list at most N actions")
+			fs.BoolVar(&jsonOutput, "json", false, "output the actions as JSON")
+		},
+	},
+	{"bq", "[-dataset DATASET] [-list] QUERYNAME ARGS...",
+		"run a canned BigQuery query with your application default credentials and print the result as a table",
+		doBQ,
+		func(fs *flag.FlagSet) {
+			fs.StringVar(&bqDataset, "dataset", os.Getenv("GO_ECOSYSTEM_BIGQUERY_DATASET"),
+				"BigQuery dataset (default from GO_ECOSYSTEM_BIGQUERY_DATASET)")
+			fs.BoolVar(&bqList, "list", false, "list the canned queries and their arguments")
+		},
+	},
 }
 
 type command struct {
@@ -148,6 +296,9 @@ func run(ctx context.Context) error {
 }
 
 func doShow(ctx context.Context, args []string) error {
+	if showFormat != "text" && showFormat != "json" {
+		return fmt.Errorf("unknown format %q: want text or json", showFormat)
+	}
 	ts, err := identityTokenSource(ctx)
 	if err != nil {
 		return err
@@ -161,78 +312,258 @@ func doShow(ctx context.Context, args []string) error {
 }
 
 func showJob(ctx context.Context, jobID string, ts oauth2.TokenSource) error {
-	job, err := requestJSON[jobs.Job](ctx, "jobs/describe?jobid="+jobID, ts)
+	d, err := requestJSON[jobs.Description](ctx, "jobs/describe?jobid="+jobID, ts)
 	if err != nil {
 		return err
 	}
 	if *dryRun {
 		return nil
 	}
-	rj := reflect.ValueOf(job).Elem()
-	rt := rj.Type()
+	return writeDescription(os.Stdout, d, showFormat)
+}
+
+// writeDescription writes the description of a job to w, in the format
+// of "ejobs show": text or json.
+func writeDescription(w io.Writer, d *jobs.Description, format string) error {
+	if format == "json" {
+		return writeJSON(w, d)
+	}
+	printFields(w, d.Job, "")
+	if d.Rows != nil {
+		printFields(w, d.Rows, "Rows")
+	}
+	for _, m := range d.Discrepancies {
+		fmt.Fprintf(w, "WARNING: %s\n", m)
+	}
+	return nil
+}
+
+// printFields prints the exported fields of the struct that p points to
+// to w, one per line, prefixing their names with prefix.
+func printFields(w io.Writer, p any, prefix string) {
+	rv := reflect.ValueOf(p).Elem()
+	rt := rv.Type()
 	for i := 0; i < rt.NumField(); i++ {
 		f := rt.Field(i)
 		if f.IsExported() {
-			v := rj.FieldByIndex(f.Index)
+			v := rv.FieldByIndex(f.Index)
 			name, _ := strings.CutPrefix(f.Name, "Num")
-			fmt.Printf("%s: %v\n", name, v.Interface())
+			fmt.Fprintf(w, "%s%s: %v\n", prefix, name, v.Interface())
 		}
 	}
-	return nil
 }
 
-func doList(ctx context.Context, _ []string) error {
+func doList(ctx context.Context, args []string) error {
+	if len(args) != 0 {
+		return errors.New("wrong number of args: want [-user USER] [-state STATE] [-since DATE] [-until DATE] [-n N] [-json]")
+	}
 	ts, err := identityTokenSource(ctx)
 	if err != nil {
 		return err
 	}
-	joblist, err := requestJSON[[]jobs.Job](ctx, "jobs/list", ts)
+	since := listSince
+	if since == "" {
+		since = time.Now().AddDate(0, 0, -7).Format(time.DateOnly)
+	}
+	recent, err := listJobs(ctx, listQuery(listUser, listState, since, listUntil), listLimit, ts)
 	if err != nil {
 		return err
 	}
 	if *dryRun {
 		return nil
 	}
-	d7 := -time.Hour * 24 * 7
-	weekBefore := time.Now().Add(d7)
-	tw := tabwriter.NewWriter(os.Stdout, 2, 8, 1, ' ', 0)
-	fmt.Fprintf(tw, "ID\tUser\tStart Time\tStarted\tFinished\tTotal\tCanceled\n")
-	for _, j := range *joblist {
-		if j.StartedAt.After(weekBefore) {
-			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%d\t%t\n",
-				j.ID(), j.User, j.StartedAt.Format(time.RFC3339),
-				j.NumStarted,
-				j.NumSkipped+j.NumFailed+j.NumErrored+j.NumSucceeded,
-				j.NumEnqueued,
-				j.Canceled)
-		}
+	if err := cacheJobIDs(recent); err != nil {
+		// Only completion depends on the cache, so don't fail.
+		fmt.Fprintf(os.Stderr, "warning: caching job IDs: %v\n", err)
+	}
+	return writeJobList(os.Stdout, recent, jsonOutput)
+}
+
+// writeJobList writes the jobs to w, as JSON if asJSON is true, or else
+// as a table.
+func writeJobList(w io.Writer, js []jobs.Job, asJSON bool) error {
+	if asJSON {
+		return writeJSON(w, js)
+	}
+	tw := tabwriter.NewWriter(w, 2, 8, 1, ' ', 0)
+	fmt.Fprintf(tw, "ID\tUser\tStart Time\tStarted\tFinished\tTotal\tCanceled\tStale\n")
+	for _, j := range js {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%d\t%t\t%t\n",
+			j.ID(), j.User, j.StartedAt.Format(time.RFC3339),
+			j.NumStarted,
+			j.NumSkipped+j.NumFailed+j.NumErrored+j.NumSucceeded,
+			j.NumEnqueued,
+			j.Canceled,
+			j.StaleReason != "")
 	}
 	return tw.Flush()
 }
 
+// listPageSize is the number of jobs requested at a time by listJobs.
+const listPageSize = 100
+
+// listJobs returns the jobs that match the jobs/list query, most recent
+// first, requesting them a page at a time. If limit is positive, it
+// returns at most limit jobs.
+func listJobs(ctx context.Context, query url.Values, limit int, ts oauth2.TokenSource) ([]jobs.Job, error) {
+	var all []jobs.Job
+	for {
+		pageSize := listPageSize
+		if limit > 0 {
+			pageSize = min(pageSize, limit-len(all))
+		}
+		query.Set("limit", fmt.Sprint(pageSize))
+		page, err := requestJSON[jobs.Page](ctx, "jobs/list?"+query.Encode(), ts)
+		if err != nil {
+			return nil, err
+		}
+		if page == nil { // dry run
+			return nil, nil
+		}
+		for _, j := range page.Jobs {
+			all = append(all, *j)
+		}
+		if page.NextPageToken == "" || (limit > 0 && len(all) >= limit) {
+			return all, nil
+		}
+		query.Set("pagetoken", page.NextPageToken)
+	}
+}
+
+// listQuery returns the query of a jobs/list request for the jobs that
+// match the given values, which are ignored if empty.
+func listQuery(user, state, since, until string) url.Values {
+	v := url.Values{}
+	for name, value := range map[string]string{
+		"user":  user,
+		"state": state,
+		"since": since,
+		"until": until,
+	} {
+		if value != "" {
+			v.Set(name, value)
+		}
+	}
+	return v
+}
+
 func doCancel(ctx context.Context, args []string) error {
 	ts, err := identityTokenSource(ctx)
 	if err != nil {
 		return err
 	}
 	for _, jobID := range args {
-		url := workerURL + "/jobs/cancel?jobid=" + jobID
+		url := workerURL + "/jobs/cancel?jobid=" + jobID + "&user=" + os.Getenv("USER")
 		if *dryRun {
 			fmt.Printf("dryrun: GET %s\n", url)
 			continue
 		}
-		if _, err := httpGet(ctx, url, ts); err != nil {
+		body, err := httpGet(ctx, url, ts)
+		if err != nil {
 			return fmt.Errorf("canceling %q: %w", jobID, err)
 		}
+		fmt.Printf("%s: canceled, %s", jobID, body)
 	}
 	return nil
 }
 
+func doMerge(ctx context.Context, args []string) error {
+	return releaseJobTables(ctx, "merge", args)
+}
+
+func doDropTable(ctx context.Context, args []string) error {
+	return releaseJobTables(ctx, "droptable", args)
+}
+
+// releaseJobTables calls the jobs endpoint that merges or drops the table
+// of each job.
+func releaseJobTables(ctx context.Context, endpoint string, jobIDs []string) error {
+	if len(jobIDs) == 0 {
+		return errors.New("wrong number of args: want JOBID...")
+	}
+	ts, err := identityTokenSource(ctx)
+	if err != nil {
+		return err
+	}
+	for _, jobID := range jobIDs {
+		url := workerURL + "/jobs/" + endpoint + "?jobid=" + jobID + "&user=" + os.Getenv("USER")
+		if *dryRun {
+			fmt.Printf("dryrun: GET %s\n", url)
+			continue
+		}
+		body, err := httpGet(ctx, url, ts)
+		if err != nil {
+			return fmt.Errorf("%s %q: %w", endpoint, jobID, err)
+		}
+		fmt.Printf("%s: %s", jobID, body)
+	}
+	return nil
+}
+
+func doAudit(ctx context.Context, args []string) error {
+	if len(args) != 0 {
+		return errors.New("wrong number of args: want [-n N] [-json]")
+	}
+	if auditLimit <= 0 {
+		return errors.New("-n must be positive")
+	}
+	ts, err := identityTokenSource(ctx)
+	if err != nil {
+		return err
+	}
+	actions, err := requestJSON[[]audit.Action](ctx, fmt.Sprintf("audit?limit=%d", auditLimit), ts)
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		return nil
+	}
+	if jsonOutput {
+		return writeJSON(os.Stdout, *actions)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 2, 8, 1, ' ', 0)
+	fmt.Fprintf(tw, "Time\tAction\tUser\tJob\tDetails\tURL\n")
+	for _, a := range *actions {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			a.CreatedAt.Format(time.RFC3339), a.Action, a.User,
+			a.JobID.StringVal, a.Details.StringVal, a.URL)
+	}
+	return tw.Flush()
+}
+
+func doDiff(ctx context.Context, args []string) error {
+	if len(args) != 3 {
+		return errors.New("wrong number of args: want [-json] BINARY OLD_HASH NEW_HASH")
+	}
+	ts, err := identityTokenSource(ctx)
+	if err != nil {
+		return err
+	}
+	q := url.Values{"binary": {args[0]}, "old": {args[1]}, "new": {args[2]}}
+	d, err := requestJSON[analysis.BinaryDiff](ctx, "analysis/diff?"+q.Encode(), ts)
+	if err != nil || d == nil { // d is nil on a dry run
+		return err
+	}
+	if jsonOutput {
+		return writeJSON(os.Stdout, d)
+	}
+	fmt.Printf("%s: %d modules compared, %d diagnostics added, %d removed, %d unchanged\n",
+		d.Binary, d.NumModules, d.NumAdded, d.NumRemoved, d.NumPersisting)
+	if len(d.Analyzers) == 0 {
+		return nil
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 2, 8, 1, ' ', 0)
+	fmt.Fprintf(tw, "Analyzer\tCategory\tAdded\tRemoved\tUnchanged\n")
+	for _, a := range d.Analyzers {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\n", a.AnalyzerName, a.Category, a.NumAdded, a.NumRemoved, a.NumPersisting)
+	}
+	return tw.Flush()
+}
+
 func doWait(ctx context.Context, args []string) error {
-	if len(args) != 1 {
-		return errors.New("wrong number of args: want [-i DURATION] JOB_ID")
+	if len(args) == 0 {
+		return errors.New("wrong number of args: want [-i DURATION] JOB_ID...")
 	}
-	jobID := args[0]
 	sleepInterval := waitInterval
 	displayUpdates := sleepInterval != 0
 	if sleepInterval < time.Second {
@@ -243,23 +574,38 @@ func doWait(ctx context.Context, args []string) error {
 		return err
 	}
 	start := time.Now()
+	pending := args
 	for {
-		job, err := requestJSON[jobs.Job](ctx, "jobs/describe?jobid="+jobID, ts)
-		if err != nil {
-			return err
-		}
-		done := job.NumFinished()
-		if done >= job.NumEnqueued {
-			break
+		var still []string
+		for _, jobID := range pending {
+			job, err := requestJSON[jobs.Job](ctx, "jobs/describe?jobid="+jobID, ts)
+			if err != nil {
+				return err
+			}
+			if job == nil { // dry run
+				continue
+			}
+			switch done := job.NumFinished(); {
+			case job.Canceled:
+				fmt.Printf("Job %s was canceled.\n", jobID)
+			case job.StaleReason != "":
+				fmt.Printf("Job %s is stale: %s.\n", jobID, job.StaleReason)
+			case job.Finished():
+				fmt.Printf("Job %s finished.\n", jobID)
+			default:
+				if displayUpdates {
+					fmt.Printf("%s: %s: %d/%d completed (%d%%)\n",
+						time.Since(start).Round(time.Second), jobID, done, job.NumExpected(), done*100/max(job.NumExpected(), 1))
+				}
+				still = append(still, jobID)
+			}
 		}
-		if displayUpdates {
-			fmt.Printf("%s: %d/%d completed (%d%%)\n",
-				time.Since(start).Round(time.Second), done, job.NumEnqueued, done*100/job.NumEnqueued)
+		if len(still) == 0 {
+			return nil
 		}
+		pending = still
 		time.Sleep(sleepInterval)
 	}
-	fmt.Printf("Job %s finished.\n", jobID)
-	return nil
 }
 
 func doStart(ctx context.Context, args []string) error {
@@ -268,22 +614,15 @@ func doStart(ctx context.Context, args []string) error {
 		return errors.New("wrong number of args: want [-min N] BINARY [ARG1 ARG2 ...]")
 	}
 	binaryFile := args[0]
-	if fi, err := os.Stat(binaryFile); err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return fmt.Errorf("%s does not exist", binaryFile)
-		}
-		return err
-	} else if fi.IsDir() {
-		return fmt.Errorf("%s is a directory, not a file", binaryFile)
-	} else if err := checkIsLinuxAmd64(binaryFile); err != nil {
+	binaryArgs := args[1:]
+	if err := checkBinaryArgs(binaryFile); err != nil {
 		return err
 	}
-	// Check args to binary for whitespace, which we don't support.
-	binaryArgs := args[1:]
-	for _, arg := range binaryArgs {
-		if strings.IndexFunc(arg, unicode.IsSpace) >= 0 {
-			return fmt.Errorf("arg %q contains whitespace: not supported", arg)
-		}
+	// Record the hash of the binary, so the worker can check that the
+	// job runs this binary even if another is uploaded in the meantime.
+	binaryHash, err := fileSHA256(binaryFile)
+	if err != nil {
+		return err
 	}
 	// Copy binary to GCS if it's not already there.
 	if canceled, err := uploadAnalysisBinary(ctx, binaryFile); err != nil {
@@ -291,18 +630,64 @@ func doStart(ctx context.Context, args []string) error {
 	} else if canceled {
 		return nil
 	}
+	privateCorpus := ""
+	if corpusDir != "" {
+		privateCorpus, err = uploadPrivateCorpus(ctx, corpusDir)
+		if err != nil {
+			return err
+		}
+	}
 	// Ask the server to enqueue scan tasks.
 	its, err := identityTokenSource(ctx)
 	if err != nil {
 		return err
 	}
-	u := fmt.Sprintf("%s/analysis/enqueue?binary=%s&user=%s", workerURL, filepath.Base(binaryFile), os.Getenv("USER"))
+	u := fmt.Sprintf("%s/analysis/enqueue?binary=%s&user=%s&binarysha256=%s", workerURL, filepath.Base(binaryFile), os.Getenv("USER"), binaryHash)
+	if v := clientVersion(); v != "" {
+		u += fmt.Sprintf("&clientversion=%s", url.QueryEscape(v))
+	}
 	if len(binaryArgs) > 0 {
-		u += fmt.Sprintf("&args=%s", url.QueryEscape(strings.Join(binaryArgs, " ")))
+		u += fmt.Sprintf("&args=%s", url.QueryEscape(analysis.JoinArgs(binaryArgs)))
 	}
 	if minImporters >= 0 {
 		u += fmt.Sprintf("&min=%d", minImporters)
 	}
+	if privateCorpus != "" {
+		u += fmt.Sprintf("&privatecorpus=%s", url.QueryEscape(privateCorpus))
+	}
+	if analyzers != "" {
+		u += fmt.Sprintf("&analyzers=%s", url.QueryEscape(analyzers))
+	}
+	if priority != "" {
+		u += fmt.Sprintf("&priority=%s", url.QueryEscape(priority))
+	}
+	if buildTags != "" {
+		u += fmt.Sprintf("&buildtags=%s", url.QueryEscape(buildTags))
+	}
+	if goflags != "" {
+		u += fmt.Sprintf("&goflags=%s", url.QueryEscape(goflags))
+	}
+	if goVersion != "" {
+		u += fmt.Sprintf("&go=%s", url.QueryEscape(goVersion))
+	}
+	if depSnapshot {
+		u += "&depsnapshot=true"
+	}
+	if batchSize > 0 {
+		u += fmt.Sprintf("&batchsize=%d", batchSize)
+	}
+	if noShare {
+		u += "&noshare=true"
+	}
+	if ownTable {
+		u += "&owntable=true"
+	}
+	if notify != "" {
+		u += fmt.Sprintf("&notify=%s", url.QueryEscape(notify))
+	}
+	if labels != "" {
+		u += fmt.Sprintf("&labels=%s", url.QueryEscape(labels))
+	}
 	if *dryRun {
 		fmt.Printf("dryrun: GET %s\n", u)
 		return nil
@@ -315,6 +700,125 @@ func doStart(ctx context.Context, args []string) error {
 	return nil
 }
 
+func doPlan(ctx context.Context, args []string) error {
+	if len(args) != 0 {
+		return errors.New("wrong number of args: want none")
+	}
+	its, err := identityTokenSource(ctx)
+	if err != nil {
+		return err
+	}
+	q := url.Values{}
+	if minImporters >= 0 {
+		q.Set("min", fmt.Sprint(minImporters))
+	}
+	if corpusFile != "" {
+		q.Set("file", corpusFile)
+	}
+	plan, err := requestJSON[analysis.Plan](ctx, "analysis/plan?"+q.Encode(), its)
+	if err != nil || plan == nil { // plan is nil on a dry run
+		return err
+	}
+	if jsonOutput {
+		return writeJSON(os.Stdout, plan)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 2, 8, 1, ' ', 0)
+	fmt.Fprintf(tw, "Module\tVersion\tImported By\n")
+	for _, m := range plan.Modules {
+		fmt.Fprintf(tw, "%s\t%s\t%d\n", m.Path, m.Version, m.ImportedBy)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("%d modules would be scanned.\n", plan.NumModules)
+	return nil
+}
+
+// addBuildFlags adds the flags for the build configuration of a scan, and
+// for how it runs the binary, to fs.
+func addBuildFlags(fs *flag.FlagSet) {
+	fs.StringVar(&buildTags, "tags", "",
+		"comma-separated build tags to build modules with")
+	fs.StringVar(&goflags, "goflags", "",
+		"flags for the go command, as in GOFLAGS; only -mod, -buildvcs and -trimpath (use -tags for build tags)")
+	fs.StringVar(&goVersion, "go", "",
+		"Go toolchain for the binary to run, like 1.22.3 (empty: the worker's)")
+	fs.BoolVar(&depSnapshot, "depsnapshot", false,
+		"run the binary on a read-only snapshot of each module's dependencies")
+	fs.IntVar(&batchSize, "batch", 0,
+		"run the binary on batches of this many packages of each module (0: only for large modules)")
+}
+
+func doRun(ctx context.Context, args []string) error {
+	if len(args) < 2 {
+		return errors.New("wrong number of args: want MODULE@VERSION BINARY [ARG1 ARG2 ...]")
+	}
+	modulePath, version, ok := strings.Cut(args[0], "@")
+	if !ok || modulePath == "" || version == "" {
+		return fmt.Errorf("%q is not of the form MODULE@VERSION", args[0])
+	}
+	binaryFile := args[1]
+	binaryArgs := args[2:]
+	if err := checkBinaryArgs(binaryFile); err != nil {
+		return err
+	}
+	if canceled, err := uploadAnalysisBinary(ctx, binaryFile); err != nil {
+		return err
+	} else if canceled {
+		return nil
+	}
+	its, err := identityTokenSource(ctx)
+	if err != nil {
+		return err
+	}
+	q := url.Values{}
+	q.Set("module", modulePath)
+	q.Set("version", version)
+	q.Set("binary", filepath.Base(binaryFile))
+	q.Set("serve", "true")
+	if len(binaryArgs) > 0 {
+		q.Set("args", analysis.JoinArgs(binaryArgs))
+	}
+	if analyzers != "" {
+		q.Set("analyzers", analyzers)
+	}
+	if buildTags != "" {
+		q.Set("buildtags", buildTags)
+	}
+	if goflags != "" {
+		q.Set("goflags", goflags)
+	}
+	if goVersion != "" {
+		q.Set("go", goVersion)
+	}
+	if depSnapshot {
+		q.Set("depsnapshot", "true")
+	}
+	if batchSize > 0 {
+		q.Set("batchsize", fmt.Sprint(batchSize))
+	}
+	result, err := requestJSON[analysis.Result](ctx, "analysis/run?"+q.Encode(), its)
+	if err != nil || result == nil { // result is nil on a dry run
+		return err
+	}
+	return writeJSON(os.Stdout, result)
+}
+
+// checkBinaryArgs checks that binaryFile is a linux/amd64 binary.
+func checkBinaryArgs(binaryFile string) error {
+	if fi, err := os.Stat(binaryFile); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%s does not exist", binaryFile)
+		}
+		return err
+	} else if fi.IsDir() {
+		return fmt.Errorf("%s is a directory, not a file", binaryFile)
+	} else if err := checkIsLinuxAmd64(binaryFile); err != nil {
+		return err
+	}
+	return nil
+}
+
 // checkIsLinuxAmd64 checks if binaryFile is a linux/amd64 Go
 // binary. If not, returns an error with appropriate message.
 // Otherwise, returns nil.
@@ -357,7 +861,7 @@ func uploadAnalysisBinary(ctx context.Context, binaryFile string) (canceled bool
 		fmt.Printf("dryrun: upload analysis binary %s\n", binaryFile)
 		return false, nil
 	}
-	const bucketName = projectID
+	bucketName := *projectID
 	binaryName := filepath.Base(binaryFile)
 	objectName := path.Join("analysis-binaries", binaryName)
 
@@ -425,16 +929,50 @@ func uploadAnalysisBinary(ctx context.Context, binaryFile string) (canceled bool
 
 // fileMD5 computes the MD5 checksum of the given file.
 func fileMD5(filename string) ([]byte, error) {
+	return fileHash(filename, md5.New())
+}
+
+// fileSHA256 returns the hex-encoded SHA-256 hash of the given file,
+// which the worker uses as the version of an analysis binary.
+func fileSHA256(filename string) (string, error) {
+	h, err := fileHash(filename, sha256.New())
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h), nil
+}
+
+// fileHash returns the hash of the contents of the given file.
+func fileHash(filename string, h hash.Hash) ([]byte, error) {
 	f, err := os.Open(filename)
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
-	hash := md5.New()
-	if _, err := io.Copy(hash, f); err != nil {
+	if _, err := io.Copy(h, f); err != nil {
 		return nil, err
 	}
-	return hash.Sum(nil)[:], nil
+	return h.Sum(nil)[:], nil
+}
+
+// clientVersion returns the version of this program, from its build
+// information: the module version, followed by the VCS revision and
+// whether the working tree was modified, if known.
+func clientVersion() string {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	words := []string{bi.Main.Version}
+	for _, s := range bi.Settings {
+		switch {
+		case s.Key == "vcs.revision":
+			words = append(words, s.Value)
+		case s.Key == "vcs.modified" && s.Value == "true":
+			words = append(words, "modified")
+		}
+	}
+	return strings.Join(words, " ")
 }
 
 // copyToLocalFile copies the filename to the GCS object.
@@ -452,26 +990,132 @@ func copyToGCS(ctx context.Context, object *storage.ObjectHandle, filename strin
 }
 
 func doResults(ctx context.Context, args []string) (err error) {
-	if len(args) == 0 {
-		return errors.New("wrong number of args: want [-f] [-o FILE.json] JOB_ID")
+	if len(args) == 0 || (len(args) > 1 && !merge) {
+		return errors.New("wrong number of args: want [-f] [-refresh] [-module PREFIX] [-category CAT] [-analyzer NAME] [-o FILE.json] JOB_ID, or -merge JOB_ID...")
 	}
-	jobID := args[0]
-	ts, err := identityTokenSource(ctx)
+	if splitBy != "" {
+		if splitBy != splitByAnalyzer {
+			return fmt.Errorf("-split-by: want %q", splitByAnalyzer)
+		}
+		if shardSize != "" {
+			return errors.New("-split-by cannot be used with -shard-size")
+		}
+	}
+	var size int64
+	if shardSize != "" {
+		if outfile == "" {
+			return errors.New("-shard-size requires -o")
+		}
+		size, err = parseSize(shardSize)
+		if err != nil {
+			return err
+		}
+	}
+	filter := analysis.ResultFilter{ModulePrefix: resultsMod, Category: resultsCat, Analyzer: resultsAna}
+	var sets [][]*analysis.Result
+	for _, jobID := range args {
+		results, err := jobResults(ctx, jobID, filter)
+		if err != nil {
+			return fmt.Errorf("%s: %w", jobID, err)
+		}
+		sets = append(sets, results)
+	}
+	results := sets[0]
+	if len(sets) > 1 {
+		results = mergeResults(sets)
+	}
+	if splitBy != "" {
+		dir := outfile
+		if dir == "" {
+			dir = "."
+		}
+		files, err := writeSplit(dir, results)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("wrote the results of %d analyzers to %s\n", len(files), dir)
+		return nil
+	}
+	if size == 0 {
+		return writeOutput(results)
+	}
+	m, err := writeShards(outfile, results, size)
 	if err != nil {
 		return err
 	}
+	fmt.Printf("wrote %d results to %d shards, described by %s\n", m.NumResults, len(m.Shards), manifestName(outfile))
+	return nil
+}
+
+// jobResults returns the results of the job that match filter, from the
+// cache if they were cached when the job had as many finished tasks as it
+// has now.
+func jobResults(ctx context.Context, jobID string, filter analysis.ResultFilter) ([]*analysis.Result, error) {
+	ts, err := identityTokenSource(ctx)
+	if err != nil {
+		return nil, err
+	}
 	job, err := requestJSON[jobs.Job](ctx, "jobs/describe?jobid="+jobID, ts)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	if job == nil { // dry run
+		return nil, nil
+	}
+	if !refresh {
+		results, err := readCachedResults(jobID, job.NumFinished())
+		if err != nil {
+			return nil, err
+		}
+		if results != nil {
+			// Filtering the complete results is cheaper than downloading.
+			return filter.Apply(results), nil
+		}
+	}
+	return downloadResults(ctx, ts, jobID, job, filter)
+}
+
+// downloadResults requests the results of the job that match filter from
+// the worker, which filters them in BigQuery. If the job is finished and
+// the results are not filtered, they are cached.
+func downloadResults(ctx context.Context, ts oauth2.TokenSource, jobID string, job *jobs.Job, filter analysis.ResultFilter) ([]*analysis.Result, error) {
+	// No more results are expected for stale jobs.
 	done := job.NumFinished()
-	if !force && done < job.NumEnqueued {
-		return fmt.Errorf("job not finished (%d/%d completed); use -f for partial results", done, job.NumEnqueued)
+	complete := job.Finished() || job.StaleReason != ""
+	if !force && !complete {
+		return nil, fmt.Errorf("job not finished (%d/%d completed); use -f for partial results", done, job.NumExpected())
 	}
-	results, err := requestJSON[[]*analysis.Result](ctx, "jobs/results?jobid="+jobID, ts)
+	results, err := requestJSON[[]*analysis.Result](ctx, "jobs/results?"+resultsQuery(jobID, filter), ts)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	if complete && !job.Canceled && filter == (analysis.ResultFilter{}) {
+		if err := cacheResults(jobID, done, *results); err != nil {
+			// The results are still good, so don't fail.
+			fmt.Fprintf(os.Stderr, "warning: caching results: %v\n", err)
+		}
+	}
+	return *results, nil
+}
+
+// resultsQuery returns the query of a jobs/results request for the
+// results of the job that match filter.
+func resultsQuery(jobID string, filter analysis.ResultFilter) string {
+	v := url.Values{"jobid": {jobID}}
+	for name, value := range map[string]string{
+		"module":   filter.ModulePrefix,
+		"category": filter.Category,
+		"analyzer": filter.Analyzer,
+	} {
+		if value != "" {
+			v.Set(name, value)
+		}
+	}
+	return v.Encode()
+}
+
+// writeOutput writes v as JSON to outfile, or to stdout if it is empty.
+func writeOutput(v any) (err error) {
 	out := os.Stdout
 	if outfile != "" {
 		out, err = os.Create(outfile)
@@ -480,9 +1124,14 @@ func doResults(ctx context.Context, args []string) (err error) {
 		}
 		defer func() { err = errors.Join(err, out.Close()) }()
 	}
-	enc := json.NewEncoder(out)
+	return writeJSON(out, v)
+}
+
+// writeJSON writes v to w as indented JSON.
+func writeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
 	enc.SetIndent("", "\t")
-	return enc.Encode(results)
+	return enc.Encode(v)
 }
 
 // requestJSON requests the path from the worker, then reads the returned body
@@ -531,18 +1180,26 @@ func httpGet(ctx context.Context, url string, ts oauth2.TokenSource) (body []byt
 	return body, nil
 }
 
-var serviceAccountEmail = fmt.Sprintf("impersonate@%s.iam.gserviceaccount.com", projectID)
+// serviceAccountEmail returns the email of the service account to
+// impersonate: the value of the -impersonate flag, or else the
+// impersonation account of the project.
+func serviceAccountEmail() string {
+	if *impersonateFlag != "" {
+		return *impersonateFlag
+	}
+	return fmt.Sprintf("impersonate@%s.iam.gserviceaccount.com", *projectID)
+}
 
 func accessTokenSource(ctx context.Context) (oauth2.TokenSource, error) {
 	return impersonate.CredentialsTokenSource(ctx, impersonate.CredentialsConfig{
-		TargetPrincipal: serviceAccountEmail,
+		TargetPrincipal: serviceAccountEmail(),
 		Scopes:          []string{"https://www.googleapis.com/auth/cloud-platform"},
 	})
 }
 
 func identityTokenSource(ctx context.Context) (oauth2.TokenSource, error) {
 	return impersonate.IDTokenSource(ctx, impersonate.IDTokenConfig{
-		TargetPrincipal: serviceAccountEmail,
+		TargetPrincipal: serviceAccountEmail(),
 		Audience:        workerURL,
 		IncludeEmail:    true,
 	})
@@ -0,0 +1,214 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/pkgsite-metrics/internal/analysis"
+)
+
+// resultsCacheDir returns the directory holding cached job results.
+func resultsCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ejobs", "results"), nil
+}
+
+// resultsCacheFile returns the path of the file that holds the cached
+// results of the job when numFinished of its tasks had finished. Only the
+// complete results of finished jobs are cached, but a finished job can
+// still gain results, as when a stale job's lost tasks run after all, and
+// each of them is counted as a finished task.
+func resultsCacheFile(jobID string, numFinished int) (string, error) {
+	dir, err := resultsCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, fmt.Sprintf("%s.%d.json", url.PathEscape(jobID), numFinished)), nil
+}
+
+// cachedResultsFiles returns the files holding cached results of the job,
+// by the number of finished tasks they were cached at.
+func cachedResultsFiles(jobID string) (map[int]string, error) {
+	dir, err := resultsCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	prefix := url.PathEscape(jobID) + "."
+	files, err := filepath.Glob(filepath.Join(dir, prefix+"*.json"))
+	if err != nil {
+		return nil, err
+	}
+	m := map[int]string{}
+	for _, f := range files {
+		// The glob also matches the files of job IDs with a dot after
+		// this one, which have a dot in their numbers.
+		n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(filepath.Base(f), prefix), ".json"))
+		if err == nil {
+			m[n] = f
+		}
+	}
+	return m, nil
+}
+
+// readCachedResults returns the results of the job cached when
+// numFinished of its tasks had finished.
+// It returns nil, nil if there are none.
+func readCachedResults(jobID string, numFinished int) ([]*analysis.Result, error) {
+	file, err := resultsCacheFile(jobID, numFinished)
+	if err != nil {
+		return nil, err
+	}
+	return readResultsFile(file)
+}
+
+// readLatestCachedResults returns the most recently cached results of the
+// job, without asking the worker whether the job has gained results since.
+// It returns nil, nil if there are none.
+func readLatestCachedResults(jobID string) ([]*analysis.Result, error) {
+	files, err := cachedResultsFiles(jobID)
+	if err != nil {
+		return nil, err
+	}
+	latest := -1
+	for n := range files {
+		latest = max(latest, n)
+	}
+	if latest < 0 {
+		return nil, nil
+	}
+	return readResultsFile(files[latest])
+}
+
+// readResultsFile returns the results in file.
+// It returns nil, nil if there is no file.
+func readResultsFile(file string) ([]*analysis.Result, error) {
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var results []*analysis.Result
+	if err := json.Unmarshal(data, &results); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return results, nil
+}
+
+// cacheResults writes the results of the job, when numFinished of its
+// tasks had finished, to the results cache, replacing those cached before.
+func cacheResults(jobID string, numFinished int, results []*analysis.Result) error {
+	old, err := cachedResultsFiles(jobID)
+	if err != nil {
+		return err
+	}
+	file, err := resultsCacheFile(jobID, numFinished)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(results)
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file and rename, so an interrupted write
+	// doesn't leave a truncated cache entry.
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		return err
+	}
+	for n, f := range old {
+		if n != numFinished {
+			os.Remove(f)
+		}
+	}
+	return nil
+}
+
+func doQuery(ctx context.Context, args []string) error {
+	if len(args) < 1 {
+		return errors.New("wrong number of args: want JOBID [FIELD=VALUE...]")
+	}
+	jobID := args[0]
+	var terms []string
+	for _, a := range args[1:] {
+		terms = append(terms, strings.Fields(a)...)
+	}
+	q, err := parseQuery(terms)
+	if err != nil {
+		return err
+	}
+	results, err := readLatestCachedResults(jobID)
+	if err != nil {
+		return err
+	}
+	if results == nil {
+		return fmt.Errorf("no cached results for job %s; run 'ejobs results %[1]s' first", jobID)
+	}
+	return writeOutput(q.Apply(results))
+}
+
+// parseQuery parses terms of the form FIELD=VALUE into a filter.
+// The fields are module, analyzer and category.
+func parseQuery(terms []string) (analysis.ResultFilter, error) {
+	var f analysis.ResultFilter
+	for _, t := range terms {
+		field, value, ok := strings.Cut(t, "=")
+		if !ok || value == "" {
+			return f, fmt.Errorf("bad query term %q: want FIELD=VALUE", t)
+		}
+		switch field {
+		case "module":
+			f.ModulePrefix = value
+		case "analyzer":
+			f.Analyzer = value
+		case "category":
+			f.Category = value
+		default:
+			return f, fmt.Errorf("unknown query field %q: want module, analyzer or category", field)
+		}
+	}
+	return f, nil
+}
+
+// mergeResults merges the results of several jobs, like the shards of
+// one experiment. A module version scanned with the same binary by more
+// than one job has a single result, the latest.
+func mergeResults(sets [][]*analysis.Result) []*analysis.Result {
+	type key struct{ modulePath, version, binary string }
+	index := map[key]int{} // index of the result in merged
+	var merged []*analysis.Result
+	for _, results := range sets {
+		for _, r := range results {
+			k := key{r.ModulePath, r.Version, r.BinaryName}
+			i, ok := index[k]
+			if !ok {
+				index[k] = len(merged)
+				merged = append(merged, r)
+			} else if r.CreatedAt.After(merged[i].CreatedAt) {
+				merged[i] = r
+			}
+		}
+	}
+	return merged
+}
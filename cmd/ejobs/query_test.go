@@ -0,0 +1,164 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/pkgsite-metrics/internal/analysis"
+)
+
+func TestQueryFilter(t *testing.T) {
+	results := []*analysis.Result{
+		{ModulePath: "example.com/a", Diagnostics: []*analysis.Diagnostic{
+			{AnalyzerName: "printf", Message: "m1"},
+			{AnalyzerName: "shadow", Message: "m2"},
+		}},
+		{ModulePath: "example.com/a/v2", Diagnostics: []*analysis.Diagnostic{
+			{AnalyzerName: "shadow", Message: "m3"},
+		}},
+		{ModulePath: "example.com/ab", ErrorCategory: "LOAD"},
+	}
+
+	for _, test := range []struct {
+		query string
+		want  []*analysis.Result
+	}{
+		{"", results},
+		{"module=example.com/a", results[:2]},
+		{"category=LOAD", results[2:]},
+		{"module=example.com/a analyzer=printf", []*analysis.Result{
+			{ModulePath: "example.com/a", Diagnostics: []*analysis.Diagnostic{
+				{AnalyzerName: "printf", Message: "m1"},
+			}},
+		}},
+		{"analyzer=printf category=LOAD", nil},
+	} {
+		q, err := parseQuery(strings.Fields(test.query))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := q.Apply(results)
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("%q: mismatch (-want, +got):\n%s", test.query, diff)
+		}
+	}
+
+	for _, bad := range []string{"module", "module=", "version=v1"} {
+		if _, err := parseQuery([]string{bad}); err == nil {
+			t.Errorf("%q: got nil error", bad)
+		}
+	}
+}
+
+func TestResultsQuery(t *testing.T) {
+	for _, test := range []struct {
+		filter analysis.ResultFilter
+		want   string
+	}{
+		{analysis.ResultFilter{}, "jobid=j1"},
+		{analysis.ResultFilter{ModulePrefix: "example.com/a", Analyzer: "printf"},
+			"analyzer=printf&jobid=j1&module=example.com%2Fa"},
+		{analysis.ResultFilter{Category: "LOAD"}, "category=LOAD&jobid=j1"},
+	} {
+		if got := resultsQuery("j1", test.filter); got != test.want {
+			t.Errorf("%+v: got %q, want %q", test.filter, got, test.want)
+		}
+	}
+}
+
+func TestMergeResults(t *testing.T) {
+	t1 := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Hour)
+	a1 := &analysis.Result{ModulePath: "example.com/a", BinaryName: "b", CreatedAt: t1}
+	a2 := &analysis.Result{ModulePath: "example.com/a", BinaryName: "b", CreatedAt: t2}
+	ac := &analysis.Result{ModulePath: "example.com/a", BinaryName: "c", CreatedAt: t1}
+	av := &analysis.Result{ModulePath: "example.com/a", Version: "v1.1.0", BinaryName: "b", CreatedAt: t1}
+	b := &analysis.Result{ModulePath: "example.com/b", BinaryName: "b", CreatedAt: t1}
+
+	for _, test := range []struct {
+		name string
+		sets [][]*analysis.Result
+		want []*analysis.Result
+	}{
+		{"none", nil, nil},
+		{"disjoint", [][]*analysis.Result{{a1}, {b}}, []*analysis.Result{a1, b}},
+		{"later wins", [][]*analysis.Result{{a1, b}, {a2}}, []*analysis.Result{a2, b}},
+		{"earlier loses", [][]*analysis.Result{{a2}, {b, a1}}, []*analysis.Result{a2, b}},
+		{"other binary", [][]*analysis.Result{{a1}, {ac}}, []*analysis.Result{a1, ac}},
+		{"other version", [][]*analysis.Result{{a2}, {av}}, []*analysis.Result{a2, av}},
+	} {
+		got := mergeResults(test.sets)
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("%s: mismatch (-want, +got):\n%s", test.name, diff)
+		}
+	}
+}
+
+func TestResultsCache(t *testing.T) {
+	// os.UserCacheDir uses XDG_CACHE_HOME on Unix systems.
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	if _, err := resultsCacheDir(); err != nil {
+		t.Skipf("no cache directory: %v", err)
+	}
+	r1 := []*analysis.Result{{ModulePath: "example.com/a"}}
+	r2 := []*analysis.Result{{ModulePath: "example.com/a"}, {ModulePath: "example.com/b"}}
+
+	read := func(n int) []*analysis.Result {
+		t.Helper()
+		got, err := readCachedResults("j", n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	readLatest := func(jobID string) []*analysis.Result {
+		t.Helper()
+		got, err := readLatestCachedResults(jobID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	if err := cacheResults("j", 1, r1); err != nil {
+		t.Fatal(err)
+	}
+	// Another job whose ID begins with the same characters.
+	if err := cacheResults("j.x", 5, r2); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(r1, read(1)); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+	// Results cached with fewer finished tasks are not used.
+	if got := read(2); got != nil {
+		t.Errorf("got %v cached for 2 finished tasks, want none", got)
+	}
+	if diff := cmp.Diff(r1, readLatest("j")); diff != "" {
+		t.Errorf("latest: mismatch (-want, +got):\n%s", diff)
+	}
+
+	// Caching newer results replaces the older ones.
+	if err := cacheResults("j", 2, r2); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(1); got != nil {
+		t.Errorf("got %v cached for 1 finished task, want none", got)
+	}
+	if diff := cmp.Diff(r2, readLatest("j")); diff != "" {
+		t.Errorf("latest: mismatch (-want, +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(r2, readLatest("j.x")); diff != "" {
+		t.Errorf("other job: mismatch (-want, +got):\n%s", diff)
+	}
+	if got := readLatest("k"); got != nil {
+		t.Errorf("got %v for uncached job, want none", got)
+	}
+}
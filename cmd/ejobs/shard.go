@@ -0,0 +1,153 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/pkgsite-metrics/internal/analysis"
+)
+
+// A shardManifest describes the shards of the results written by
+// "ejobs results -shard-size". It is written next to them, to
+// FILE-manifest.json.
+//
+// The results are sorted by module path, so that the shards holding the
+// results of a module can be found from their module ranges.
+type shardManifest struct {
+	NumResults int
+	Shards     []*shardInfo
+}
+
+// shardInfo describes one shard.
+type shardInfo struct {
+	File        string // base name of the shard file
+	NumResults  int
+	Size        int64  // size of the file in bytes
+	FirstModule string // the module path of the first result
+	LastModule  string // the module path of the last result
+}
+
+// shardName returns the name of the shard file with number n (from 1)
+// for output file outfile: FILE-0001.json for FILE.json.
+func shardName(outfile string, n int) string {
+	ext := filepath.Ext(outfile)
+	return fmt.Sprintf("%s-%04d%s", strings.TrimSuffix(outfile, ext), n, ext)
+}
+
+// manifestName returns the name of the manifest for output file outfile.
+func manifestName(outfile string) string {
+	ext := filepath.Ext(outfile)
+	return strings.TrimSuffix(outfile, ext) + "-manifest" + ext
+}
+
+// writeShards writes results to files named after outfile, as shardName
+// does, each holding a JSON array of results formatted as by writeJSON
+// and at most about shardSize bytes long. A result larger than shardSize
+// is a shard by itself. Then it writes the manifest describing the
+// shards, and returns it.
+func writeShards(outfile string, results []*analysis.Result, shardSize int64) (*shardManifest, error) {
+	if shardSize <= 0 {
+		return nil, errors.New("shard size must be positive")
+	}
+	results = append([]*analysis.Result(nil), results...)
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].ModulePath < results[j].ModulePath
+	})
+
+	m := &shardManifest{NumResults: len(results)}
+	var (
+		buf   bytes.Buffer
+		shard *shardInfo
+	)
+	flush := func() error {
+		if shard == nil {
+			return nil
+		}
+		buf.WriteString("\n]\n")
+		shard.Size = int64(buf.Len())
+		if err := os.WriteFile(shardName(outfile, len(m.Shards)), buf.Bytes(), 0o644); err != nil {
+			return err
+		}
+		buf.Reset()
+		shard = nil
+		return nil
+	}
+	for _, r := range results {
+		// Encode the result as writeJSON encodes the elements of a slice,
+		// so that shards look like unsharded output.
+		data, err := json.MarshalIndent(r, "\t", "\t")
+		if err != nil {
+			return nil, err
+		}
+		if shard != nil && int64(buf.Len()+len(data)+len(",\n\t\n]\n")) > shardSize {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+		if shard == nil {
+			shard = &shardInfo{FirstModule: r.ModulePath}
+			m.Shards = append(m.Shards, shard)
+			shard.File = filepath.Base(shardName(outfile, len(m.Shards)))
+			buf.WriteString("[\n\t")
+		} else {
+			buf.WriteString(",\n\t")
+		}
+		buf.Write(data)
+		shard.NumResults++
+		shard.LastModule = r.ModulePath
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	f, err := os.Create(manifestName(outfile))
+	if err != nil {
+		return nil, err
+	}
+	if err := writeJSON(f, m); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return m, f.Close()
+}
+
+// parseSize parses a size in bytes, optionally followed by one of the
+// units K, M or G, which may be followed by "B", as in 500MB. The units
+// are decimal: 1MB is 1,000,000 bytes. The binary units KiB, MiB and GiB
+// are powers of 1024.
+func parseSize(s string) (int64, error) {
+	num := strings.ToUpper(s)
+	base := int64(1000)
+	if n, ok := strings.CutSuffix(num, "IB"); ok {
+		num, base = n, 1024
+	} else {
+		num = strings.TrimSuffix(num, "B")
+	}
+	unit := int64(1)
+	if num != "" {
+		if i := strings.IndexByte("KMG", num[len(num)-1]); i >= 0 {
+			for range i + 1 {
+				unit *= base
+			}
+			num = num[:len(num)-1]
+		} else if base == 1024 {
+			// "iB" without a unit.
+			return 0, fmt.Errorf("invalid size %q", s)
+		}
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * unit, nil
+}
@@ -0,0 +1,111 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/pkgsite-metrics/internal/analysis"
+)
+
+func TestWriteShards(t *testing.T) {
+	var results []*analysis.Result
+	for _, m := range []string{"c.com/m", "a.com/m", "b.com/m", "a.com/m"} {
+		results = append(results, &analysis.Result{ModulePath: m, Version: "v1.0.0", BinaryName: "bin"})
+	}
+	outfile := filepath.Join(t.TempDir(), "out.json")
+
+	// With a large size, there is one shard, the same as unsharded
+	// output of the sorted results.
+	m, err := writeShards(outfile, results, 1<<30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Shards) != 1 || m.Shards[0].File != "out-0001.json" || m.Shards[0].NumResults != 4 {
+		t.Fatalf("got shards %+v, want one shard of 4", m.Shards)
+	}
+	got, err := os.ReadFile(shardName(outfile, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sorted := []*analysis.Result{results[1], results[3], results[2], results[0]}
+	var want bytes.Buffer
+	if err := writeJSON(&want, sorted); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want.String(), string(got)); diff != "" {
+		t.Errorf("shard mismatch (-want, +got):\n%s", diff)
+	}
+
+	// With a small size, each result is a shard.
+	m, err = writeShards(outfile, results, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Shards) != 4 || m.NumResults != 4 {
+		t.Fatalf("got %d shards of %d results, want 4 of 4", len(m.Shards), m.NumResults)
+	}
+	for i, s := range m.Shards {
+		data, err := os.ReadFile(filepath.Join(filepath.Dir(outfile), s.File))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rs []*analysis.Result
+		if err := json.Unmarshal(data, &rs); err != nil {
+			t.Fatal(err)
+		}
+		if len(rs) != 1 || rs[0].ModulePath != sorted[i].ModulePath || int64(len(data)) != s.Size {
+			t.Errorf("shard %d: got %d results of size %d, want %s of size %d", i+1, len(rs), len(data), sorted[i].ModulePath, s.Size)
+		}
+	}
+	data, err := os.ReadFile(manifestName(outfile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotm shardManifest
+	if err := json.Unmarshal(data, &gotm); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(m, &gotm); diff != "" {
+		t.Errorf("manifest mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want int64
+	}{
+		{"100", 100},
+		{"100B", 100},
+		{"2K", 2000},
+		{"500MB", 500_000_000},
+		{"500MiB", 500 << 20},
+		{"1GiB", 1 << 30},
+		{"1g", 1_000_000_000},
+		{"2kib", 2 << 10},
+		{"1iB", 0},
+		{"", 0},
+		{"0", 0},
+		{"-1M", 0},
+		{"1T", 0},
+	} {
+		got, err := parseSize(test.in)
+		if test.want == 0 {
+			if err == nil {
+				t.Errorf("%q: got %d, want error", test.in, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("%q: got %d, %v, want %d", test.in, got, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"golang.org/x/pkgsite-metrics/internal/analysis"
+)
+
+// splitByAnalyzer is the value of -split-by that writes the results of
+// each analyzer to a file of its own.
+const splitByAnalyzer = "analyzer"
+
+// analyzerNames returns the names of the analyzers with diagnostics in
+// results, sorted.
+func analyzerNames(results []*analysis.Result) []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, r := range results {
+		for _, d := range r.Diagnostics {
+			if !seen[d.AnalyzerName] {
+				seen[d.AnalyzerName] = true
+				names = append(names, d.AnalyzerName)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// writeSplit writes the results of each analyzer with diagnostics in
+// results to ANALYZER.json in dir, as "ejobs results -analyzer ANALYZER"
+// would, and returns the names of the files in order.
+func writeSplit(dir string, results []*analysis.Result) (files []string, err error) {
+	names := analyzerNames(results)
+	if len(names) == 0 {
+		return nil, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if name == "" || filepath.Base(name) != name || name == "." || name == ".." {
+			return files, fmt.Errorf("analyzer name %q is not a valid file name", name)
+		}
+		file := filepath.Join(dir, name+".json")
+		if err := writeJSONFile(file, analysis.ResultFilter{Analyzer: name}.Apply(results)); err != nil {
+			return files, err
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// writeJSONFile writes v as JSON to file.
+func writeJSONFile(file string, v any) (err error) {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer func() { err = errors.Join(err, f.Close()) }()
+	return writeJSON(f, v)
+}
@@ -0,0 +1,64 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/pkgsite-metrics/internal/analysis"
+)
+
+func TestWriteSplit(t *testing.T) {
+	diag := func(analyzer, msg string) *analysis.Diagnostic {
+		return &analysis.Diagnostic{AnalyzerName: analyzer, Message: msg}
+	}
+	results := []*analysis.Result{
+		{ModulePath: "a.com/m", Diagnostics: []*analysis.Diagnostic{diag("nilness", "n1"), diag("findcall", "f1")}},
+		{ModulePath: "b.com/m", Diagnostics: []*analysis.Diagnostic{diag("findcall", "f2")}},
+		{ModulePath: "c.com/m", Error: "bad module"},
+	}
+	dir := filepath.Join(t.TempDir(), "out")
+	files, err := writeSplit(dir, results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantFiles := []string{filepath.Join(dir, "findcall.json"), filepath.Join(dir, "nilness.json")}
+	if !cmp.Equal(files, wantFiles) {
+		t.Fatalf("got files %q, want %q", files, wantFiles)
+	}
+	read := func(file string) map[string][]string {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rs []*analysis.Result
+		if err := json.Unmarshal(data, &rs); err != nil {
+			t.Fatal(err)
+		}
+		// Map each module to the messages of its diagnostics.
+		m := map[string][]string{}
+		for _, r := range rs {
+			for _, d := range r.Diagnostics {
+				m[r.ModulePath] = append(m[r.ModulePath], d.Message)
+			}
+		}
+		return m
+	}
+	if diff := cmp.Diff(map[string][]string{"a.com/m": {"f1"}, "b.com/m": {"f2"}}, read(files[0])); diff != "" {
+		t.Errorf("findcall mismatch (-want, +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(map[string][]string{"a.com/m": {"n1"}}, read(files[1])); diff != "" {
+		t.Errorf("nilness mismatch (-want, +got):\n%s", diff)
+	}
+
+	bad := []*analysis.Result{{ModulePath: "a.com/m", Diagnostics: []*analysis.Diagnostic{diag("../x", "m")}}}
+	if _, err := writeSplit(dir, bad); err == nil {
+		t.Error("analyzer name with slashes: got nil, want error")
+	}
+}
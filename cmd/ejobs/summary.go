@@ -0,0 +1,116 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"golang.org/x/pkgsite-metrics/internal/analysis"
+)
+
+func doSummary(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("wrong number of args: want [-by analyzer|category|severity] JOBID")
+	}
+	key, err := summaryKey(summaryBy)
+	if err != nil {
+		return err
+	}
+	jobID := args[0]
+	results, err := readLatestCachedResults(jobID)
+	if err != nil {
+		return err
+	}
+	if results == nil {
+		return fmt.Errorf("no cached results for job %s; run 'ejobs results %[1]s' first", jobID)
+	}
+	return writeSummary(os.Stdout, summaryBy, summarize(results, key, summaryBy == "severity"))
+}
+
+// A summaryRow counts the diagnostics in one group, and the modules
+// that have them.
+type summaryRow struct {
+	Group          string
+	NumDiagnostics int
+	NumModules     int
+}
+
+// summaryKey returns the function that groups diagnostics for the -by flag.
+func summaryKey(by string) (func(*analysis.Diagnostic) string, error) {
+	switch by {
+	case "analyzer":
+		return func(d *analysis.Diagnostic) string { return d.AnalyzerName }, nil
+	case "category":
+		return func(d *analysis.Diagnostic) string { return d.Category }, nil
+	case "severity":
+		return func(d *analysis.Diagnostic) string { return d.Severity.StringVal }, nil
+	default:
+		return nil, fmt.Errorf("bad -by value %q: want analyzer, category or severity", by)
+	}
+}
+
+// summarize groups the diagnostics of results by key. Analysis errors are
+// not counted. The groups are ordered by number of diagnostics, most
+// first, or by severity if bySeverity is true.
+func summarize(results []*analysis.Result, key func(*analysis.Diagnostic) string, bySeverity bool) []*summaryRow {
+	rows := map[string]*summaryRow{}
+	for _, r := range results {
+		seen := map[string]bool{}
+		for _, d := range r.Diagnostics {
+			if d.Error != "" {
+				continue
+			}
+			k := key(d)
+			row := rows[k]
+			if row == nil {
+				row = &summaryRow{Group: k}
+				rows[k] = row
+			}
+			row.NumDiagnostics++
+			if !seen[k] {
+				seen[k] = true
+				row.NumModules++
+			}
+		}
+	}
+	var s []*summaryRow
+	for _, row := range rows {
+		s = append(s, row)
+	}
+	sort.Slice(s, func(i, j int) bool {
+		if bySeverity {
+			ri, rj := analysis.SeverityRank(s[i].Group), analysis.SeverityRank(s[j].Group)
+			if ri != rj {
+				return ri < rj
+			}
+		} else if s[i].NumDiagnostics != s[j].NumDiagnostics {
+			return s[i].NumDiagnostics > s[j].NumDiagnostics
+		}
+		return s[i].Group < s[j].Group
+	})
+	return s
+}
+
+func writeSummary(w io.Writer, by string, rows []*summaryRow) error {
+	if jsonOutput {
+		return writeJSON(w, rows)
+	}
+	tw := tabwriter.NewWriter(w, 2, 8, 1, ' ', 0)
+	fmt.Fprintf(tw, "%s\tDiagnostics\tModules\n", by)
+	for _, r := range rows {
+		group := r.Group
+		if group == "" {
+			group = "(none)"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\n", group, r.NumDiagnostics, r.NumModules)
+	}
+	return tw.Flush()
+}
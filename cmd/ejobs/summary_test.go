@@ -0,0 +1,72 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/pkgsite-metrics/internal/analysis"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+)
+
+func TestSummarize(t *testing.T) {
+	warning := bigquery.NullString(analysis.SeverityWarning)
+	errSev := bigquery.NullString(analysis.SeverityError)
+	results := []*analysis.Result{
+		{ModulePath: "a", Diagnostics: []*analysis.Diagnostic{
+			{AnalyzerName: "printf", Severity: warning},
+			{AnalyzerName: "printf", Severity: warning},
+			{AnalyzerName: "shadow"},
+		}},
+		{ModulePath: "b", Diagnostics: []*analysis.Diagnostic{
+			{AnalyzerName: "shadow", Severity: errSev},
+			{AnalyzerName: "printf", Error: "failed"},
+		}},
+	}
+
+	for _, test := range []struct {
+		by   string
+		want []*summaryRow
+	}{
+		{"analyzer", []*summaryRow{
+			{Group: "printf", NumDiagnostics: 2, NumModules: 1},
+			{Group: "shadow", NumDiagnostics: 2, NumModules: 2},
+		}},
+		{"severity", []*summaryRow{
+			{Group: "error", NumDiagnostics: 1, NumModules: 1},
+			{Group: "warning", NumDiagnostics: 2, NumModules: 1},
+			{Group: "", NumDiagnostics: 1, NumModules: 1},
+		}},
+	} {
+		key, err := summaryKey(test.by)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := summarize(results, key, test.by == "severity")
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("%s: mismatch (-want, +got):\n%s", test.by, diff)
+		}
+	}
+
+	if _, err := summaryKey("module"); err == nil {
+		t.Error("got nil, want error for bad -by")
+	}
+}
+
+func TestWriteSummary(t *testing.T) {
+	var buf bytes.Buffer
+	rows := []*summaryRow{{Group: "warning", NumDiagnostics: 3, NumModules: 2}, {NumDiagnostics: 1, NumModules: 1}}
+	if err := writeSummary(&buf, "severity", rows); err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Fields(buf.String())
+	want := strings.Fields("severity Diagnostics Modules warning 3 2 (none) 1 1")
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/observe"
+	"golang.org/x/pkgsite-metrics/internal/queue"
+)
+
+// topOutput is the JSON output of "ejobs top".
+type topOutput struct {
+	*observe.Health
+	Queues []*queue.Stats `json:",omitempty"`
+}
+
+func doTop(ctx context.Context, args []string) error {
+	if len(args) != 0 {
+		return errors.New("wrong number of args: want none")
+	}
+	ts, err := identityTokenSource(ctx)
+	if err != nil {
+		return err
+	}
+	for {
+		h, err := requestJSON[observe.Health](ctx, "health", ts)
+		if err != nil || h == nil { // h is nil on a dry run
+			return err
+		}
+		// Workers that predate the queue statistics don't serve them.
+		var qs []*queue.Stats
+		if stats, err := requestJSON[[]*queue.Stats](ctx, "queue/stats", ts); err != nil {
+			fmt.Fprintf(os.Stderr, "queue statistics: %v\n", err)
+		} else {
+			qs = *stats
+		}
+		if jsonOutput {
+			err = writeJSON(os.Stdout, topOutput{h, qs})
+		} else {
+			err = writeHealth(os.Stdout, h)
+			if err == nil && len(qs) > 0 {
+				fmt.Println()
+				err = writeQueueStats(os.Stdout, qs, h.Now)
+			}
+		}
+		if err != nil || topInterval <= 0 {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(topInterval):
+		}
+		fmt.Println()
+	}
+}
+
+// writeHealth writes h to w as a table.
+func writeHealth(w io.Writer, h *observe.Health) error {
+	tw := tabwriter.NewWriter(w, 2, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "Version:\t%s\n", h.VersionID)
+	fmt.Fprintf(tw, "Uptime:\t%s (started %s)\n", h.Uptime().Round(time.Second), h.Started.Format(time.RFC3339))
+	fmt.Fprintf(tw, "Scans:\t%d active, %d stalled\n", h.ActiveScans, h.StalledScans)
+	if h.RequestLimit > 0 {
+		fmt.Fprintf(tw, "Requests:\t%d, restarting after %d\n", h.Requests, h.RequestLimit)
+	} else {
+		fmt.Fprintf(tw, "Requests:\t%d\n", h.Requests)
+	}
+	mem := "heap " + formatBytes(int64(h.HeapBytes))
+	if h.ContainerMemoryLimitBytes > 0 {
+		mem += fmt.Sprintf(", container %s of %s (%.0f%%)",
+			formatBytes(h.ContainerMemoryBytes), formatBytes(h.ContainerMemoryLimitBytes),
+			100*float64(h.ContainerMemoryBytes)/float64(h.ContainerMemoryLimitBytes))
+	}
+	fmt.Fprintf(tw, "Memory:\t%s\n", mem)
+	fmt.Fprintf(tw, "Queue backlog:\t~%d tasks\n", h.QueueBacklog)
+	fmt.Fprintf(tw, "Instance starts:\t%d in the last hour, %d in the last day\n", h.StartsLastHour, h.StartsLastDay)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	// Requests are served by any instance, so the values for one
+	// instance may change from one request to the next.
+	_, err := fmt.Fprintln(w, "Scans, requests, memory and uptime are of the instance that served the request.")
+	return err
+}
+
+// writeQueueStats writes the statistics of the queues to w as a table.
+// The age of the oldest task is relative to now.
+func writeQueueStats(w io.Writer, stats []*queue.Stats, now time.Time) error {
+	tw := tabwriter.NewWriter(w, 2, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "Queue\tPriorities\tTasks\tRunning\tOldest\tLast minute\tRate/s\tErrors")
+	for _, s := range stats {
+		oldest := "-"
+		if !s.OldestTask.IsZero() {
+			oldest = now.Sub(s.OldestTask).Round(time.Second).String()
+		}
+		errs := "-"
+		if s.AttemptedTasks > 0 {
+			errs = fmt.Sprintf("%.0f%% of %d", 100*s.ErrorRate(), s.AttemptedTasks)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\t%d\t%g\t%s\n",
+			path.Base(s.Name), strings.Join(s.Priorities, ","), s.Tasks, s.Running,
+			oldest, s.ExecutedLastMinute, s.DispatchRate, errs)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintln(w, "Errors are of the attempted tasks among the first of each queue.")
+	return err
+}
+
+// formatBytes formats n bytes with a binary unit, like "1.5GiB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,100 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/pkgsite-metrics/internal/observe"
+	"golang.org/x/pkgsite-metrics/internal/queue"
+)
+
+func TestWriteHealth(t *testing.T) {
+	start := time.Date(2023, 3, 12, 10, 0, 0, 0, time.UTC)
+	h := &observe.Health{
+		VersionID:                 "v1",
+		Started:                   start,
+		Now:                       start.Add(90 * time.Minute),
+		ActiveScans:               3,
+		StalledScans:              1,
+		Requests:                  40,
+		RequestLimit:              500,
+		HeapBytes:                 300 << 20,
+		ContainerMemoryBytes:      2 << 30,
+		ContainerMemoryLimitBytes: 8 << 30,
+		QueueBacklog:              1234,
+		StartsLastHour:            2,
+		StartsLastDay:             9,
+	}
+	var buf bytes.Buffer
+	if err := writeHealth(&buf, h); err != nil {
+		t.Fatal(err)
+	}
+	want := `Version:          v1
+Uptime:           1h30m0s (started 2023-03-12T10:00:00Z)
+Scans:            3 active, 1 stalled
+Requests:         40, restarting after 500
+Memory:           heap 300.0MiB, container 2.0GiB of 8.0GiB (25%)
+Queue backlog:    ~1234 tasks
+Instance starts:  2 in the last hour, 9 in the last day
+Scans, requests, memory and uptime are of the instance that served the request.
+`
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestWriteQueueStats(t *testing.T) {
+	now := time.Date(2023, 3, 12, 10, 0, 0, 0, time.UTC)
+	stats := []*queue.Stats{
+		{
+			Name:               "projects/p/locations/us-central1/queues/q",
+			Priorities:         []string{queue.PriorityHigh, queue.PriorityNormal},
+			Tasks:              1200,
+			Running:            40,
+			OldestTask:         now.Add(-90 * time.Minute),
+			ExecutedLastMinute: 300,
+			DispatchRate:       5,
+			AttemptedTasks:     200,
+			FailingTasks:       50,
+		},
+		{
+			Name:       "projects/p/locations/us-central1/queues/low",
+			Priorities: []string{queue.PriorityLow},
+		},
+	}
+	var buf bytes.Buffer
+	if err := writeQueueStats(&buf, stats, now); err != nil {
+		t.Fatal(err)
+	}
+	want := `Queue  Priorities   Tasks  Running  Oldest   Last minute  Rate/s  Errors
+q      high,normal  1200   40       1h30m0s  300          5       25% of 200
+low    low          0      0        -        0            0       -
+Errors are of the attempted tasks among the first of each queue.
+`
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	for _, test := range []struct {
+		n    int64
+		want string
+	}{
+		{0, "0B"},
+		{1023, "1023B"},
+		{1024, "1.0KiB"},
+		{3 << 19, "1.5MiB"},
+		{5 << 40, "5.0TiB"},
+	} {
+		if got := formatBytes(test.n); got != test.want {
+			t.Errorf("formatBytes(%d) = %q, want %q", test.n, got, test.want)
+		}
+	}
+}
@@ -12,6 +12,9 @@ package main
 //    errors and others               (-o -e)
 //    all entries                     (-a)
 //
+// Each line includes the severity of its diagnostic, if any. Lines can be
+// grouped by severity, most severe first (-s).
+//
 // Optionally, instead of printing multiple diagnostics or errors for a build,
 // only print the first one (-1) -- this can be combined with any other flag
 // combination.
@@ -24,11 +27,12 @@ import (
 )
 
 func main() {
-	var errors, others, all, one bool
+	var errors, others, all, one, bySeverity bool
 	flag.BoolVar(&errors, "e", errors, "print non-empty errors instead of messages")
 	flag.BoolVar(&others, "o", others, "print other lines, non-error, non-message")
 	flag.BoolVar(&all, "a", all, "print all lines")
 	flag.BoolVar(&one, "1", all, "print only the first line for messages or errors")
+	flag.BoolVar(&bySeverity, "s", bySeverity, "group lines by severity, most severe first")
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
 		flag.PrintDefaults()
@@ -37,9 +41,10 @@ func main() {
 The default is to only output lines for modules whose analysis produced a diagnostic,
 but there are options to print errors (-e), non-error/diagnostic (-o), and all (-a).
 Combining -e and -o prints everything except diagnostic messages.
+Lines can be grouped by the severity of their diagnostics (-s).
 `)
 	}
 	flag.Parse()
 
-	ejson2csv.Process(os.Stdin, os.Stdout, errors, others, all, one)
+	ejson2csv.Process(os.Stdin, os.Stdout, errors, others, all, one, bySeverity)
 }
@@ -19,21 +19,32 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
 
 	"golang.org/x/pkgsite-metrics/internal/buildbinary"
 	"golang.org/x/pkgsite-metrics/internal/govulncheck"
 )
 
+var (
+	maxBinaries = flag.Int("max", 0, "build at most this many binaries, choosing the likeliest programs first (0: all)")
+	packages    = flag.String("pkgs", "", "comma-separated import paths of the main packages to build (default: all)")
+	cacheDir    = flag.String("cache", "", "directory of binaries of the module built earlier, to use instead of building them and to add the binaries built to")
+)
+
 // govulncheck compare accepts three inputs in the following order
 //   - path to govulncheck
 //   - input module to scan
 //   - full path to the vulnerability database
 func main() {
 	flag.Parse()
-	run(os.Stdout, flag.Args())
+	sel := buildbinary.Selection{Max: *maxBinaries}
+	if *packages != "" {
+		sel.Packages = strings.Split(*packages, ",")
+	}
+	run(os.Stdout, flag.Args(), sel, *cacheDir)
 }
 
-func run(w io.Writer, args []string) {
+func run(w io.Writer, args []string, sel buildbinary.Selection, cacheDir string) {
 	fail := func(err error) {
 		fmt.Fprintf(w, `{"Error": %q}`, err)
 		fmt.Fprintln(w)
@@ -46,7 +57,16 @@ func run(w io.Writer, args []string) {
 	modulePath := args[1]
 	vulndbPath := args[2]
 
-	binaries, err := buildbinary.FindAndBuildBinaries(modulePath)
+	var cache *buildbinary.Cache
+	if cacheDir != "" {
+		goVersion, err := buildbinary.GoVersion()
+		if err != nil {
+			fail(err)
+			return
+		}
+		cache = &buildbinary.Cache{Dir: cacheDir, GoVersion: goVersion}
+	}
+	binaries, numFound, err := buildbinary.FindAndBuildBinaries(modulePath, sel, cache)
 	if err != nil {
 		fail(err)
 		return
@@ -54,7 +74,8 @@ func run(w io.Writer, args []string) {
 	defer removeBinaries(binaries)
 
 	response := govulncheck.CompareResponse{
-		FindingsForMod: make(map[string]*govulncheck.ComparePair),
+		FindingsForMod:  make(map[string]*govulncheck.ComparePair),
+		NumMainPackages: numFound,
 	}
 	for _, binary := range binaries {
 		pair, err := runComparison(binary, govulncheckPath, modulePath, vulndbPath)
@@ -76,9 +97,13 @@ func run(w io.Writer, args []string) {
 
 // runComparison runs both a source mode and an binary mode comparison,
 // and returns a govulncheck.ComparePair on success. Otherwise, returns an error.
+// If the binary failed to build, the pair has the error and the statistics
+// of the build.
 func runComparison(binary *buildbinary.BinaryInfo, govulncheckPath, modulePath, vulndbPath string) (*govulncheck.ComparePair, error) {
 	if binary.Error != nil { // there was an error in building the binary
-		return nil, binary.Error
+		pair := &govulncheck.ComparePair{Error: binary.Error.Error()}
+		setBuildStats(&pair.BinaryResults.Stats, binary)
+		return pair, nil
 	}
 
 	srcResp, err := govulncheck.RunGovulncheckCmd(govulncheckPath, govulncheck.FlagSource, binary.ImportPath, modulePath, vulndbPath)
@@ -94,12 +119,25 @@ func runComparison(binary *buildbinary.BinaryInfo, govulncheckPath, modulePath,
 		SourceResults: *srcResp,
 		BinaryResults: *binResp,
 	}
-	pair.BinaryResults.Stats.BuildTime = binary.BuildTime
+	setBuildStats(&pair.BinaryResults.Stats, binary)
 	return pair, nil
 }
 
+// setBuildStats records the statistics of the build of binary in stats.
+func setBuildStats(stats *govulncheck.ScanStats, binary *buildbinary.BinaryInfo) {
+	stats.BuildTime = binary.BuildTime
+	stats.BuildMemory = binary.BuildMemory
+	stats.BinarySize = binary.BinarySize
+	stats.BuildArtifactsSize = binary.ArtifactsSize
+	stats.BinaryCached = binary.Cached
+}
+
+// removeBinaries removes the binaries that were built, leaving those in
+// the cache.
 func removeBinaries(binaryPaths []*buildbinary.BinaryInfo) {
 	for _, bin := range binaryPaths {
-		os.Remove(bin.BinaryPath)
+		if !bin.Cached {
+			os.Remove(bin.BinaryPath)
+		}
 	}
 }
@@ -14,6 +14,8 @@ import (
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	"golang.org/x/exp/maps"
+	"golang.org/x/pkgsite-metrics/internal/buildbinary"
 	"golang.org/x/pkgsite-metrics/internal/buildtest"
 	"golang.org/x/pkgsite-metrics/internal/govulncheck"
 	"golang.org/x/pkgsite-metrics/internal/govulncheckapi"
@@ -42,7 +44,7 @@ func Test(t *testing.T) {
 	}
 
 	t.Run("basicComparison", func(t *testing.T) {
-		resp, err := runTest([]string{govulncheckPath, filepath.Join(testData, "module"), vulndb})
+		resp, err := runTest([]string{govulncheckPath, filepath.Join(testData, "module"), vulndb}, buildbinary.Selection{})
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -51,13 +53,26 @@ func Test(t *testing.T) {
 	})
 
 	t.Run("multipleComparison", func(t *testing.T) {
-		resp, err := runTest([]string{govulncheckPath, filepath.Join(testData, "multipleBinModule"), vulndb})
+		resp, err := runTest([]string{govulncheckPath, filepath.Join(testData, "multipleBinModule"), vulndb}, buildbinary.Selection{})
 		if err != nil {
 			t.Fatal(err)
 		}
 
 		compareSameFindings(t, resp)
 	})
+
+	t.Run("selectedComparison", func(t *testing.T) {
+		resp, err := runTest([]string{govulncheckPath, filepath.Join(testData, "multipleBinModule"), vulndb}, buildbinary.Selection{Max: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.NumMainPackages != 3 {
+			t.Errorf("got %d main packages, want 3", resp.NumMainPackages)
+		}
+		if _, ok := resp.FindingsForMod["example.com/test"]; !ok || len(resp.FindingsForMod) != 1 {
+			t.Errorf("got binaries %v, want only example.com/test", maps.Keys(resp.FindingsForMod))
+		}
+	})
 }
 
 func compareSameFindings(t *testing.T, resp *govulncheck.CompareResponse) {
@@ -74,8 +89,8 @@ func compareSameFindings(t *testing.T, resp *govulncheck.CompareResponse) {
 	}
 }
 
-func runTest(args []string) (*govulncheck.CompareResponse, error) {
+func runTest(args []string, sel buildbinary.Selection) (*govulncheck.CompareResponse, error) {
 	var buf bytes.Buffer
-	run(&buf, args)
+	run(&buf, args, sel, "")
 	return govulncheck.UnmarshalCompareResponse(buf.Bytes())
 }
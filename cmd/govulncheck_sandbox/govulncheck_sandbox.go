@@ -9,6 +9,9 @@
 // Unless it panics, this program always terminates with exit code 0.
 // If there is an error, it writes a JSON object with field "Error".
 // Otherwise, it writes a internal/govulncheck.SandboxResponse as JSON.
+//
+// While govulncheck runs, this program periodically writes progress
+// events to standard error; see internal/govulncheck.Progress.
 package main
 
 import (
@@ -28,6 +31,8 @@ import (
 //   - govulncheck mode
 //   - input module or binary to analyze
 //   - full path to the vulnerability database
+//
+// With -test, the tests of the packages are scanned too.
 func main() {
 	flag.Parse()
 	run(os.Stdout, flag.Args())
@@ -66,6 +71,12 @@ func run(w io.Writer, args []string) {
 	fmt.Println()
 }
 
+var tests = flag.Bool("test", false, "also scan the tests of the packages")
+
 func runGovulncheck(govulncheckPath, modeFlag, filePath, vulnDBDir string) (*govulncheck.AnalysisResponse, error) {
-	return govulncheck.RunGovulncheckCmd(govulncheckPath, modeFlag, "./...", filePath, vulnDBDir)
+	return govulncheck.RunGovulncheckCmdWithProgress(govulncheckPath, modeFlag, "./...", filePath, vulnDBDir, *tests, nil,
+		func(p govulncheck.Progress) {
+			// Progress is best effort; ignore write errors.
+			_ = govulncheck.WriteProgress(os.Stderr, p)
+		})
 }
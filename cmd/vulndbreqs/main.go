@@ -58,20 +58,27 @@ func run(ctx context.Context) error {
 	defer client.Close()
 
 	keyName := "projects/" + cfg.ProjectID + "/secrets/vulndb-hmac-key"
-	var hmacKey []byte
+	var (
+		hmacKey []byte
+		ex      *vulndbreqs.Exclusions
+	)
 	if flag.Arg(0) == "add" || flag.Arg(0) == "compute" {
 		hk, err := internal.GetSecret(ctx, keyName)
 		if err != nil {
 			return err
 		}
 		hmacKey = []byte(hk)
+		ex, err = vulndbreqs.ReadExclusions(ctx, cfg.VulnDBExclusions)
+		if err != nil {
+			return err
+		}
 	}
 
 	switch flag.Arg(0) {
 	case "add":
-		err = doAdd(ctx, cfg.VulnDBBucketProjectID, client, hmacKey, flag.Arg(1))
+		err = doAdd(ctx, cfg.VulnDBBucketProjectID, client, hmacKey, ex, flag.Arg(1))
 	case "compute":
-		err = doCompute(ctx, cfg.VulnDBBucketProjectID, hmacKey)
+		err = doCompute(ctx, cfg.VulnDBBucketProjectID, hmacKey, ex)
 	case "show":
 		err = doShow(ctx, client)
 	default:
@@ -80,33 +87,37 @@ func run(ctx context.Context) error {
 	return err
 }
 
-func doAdd(ctx context.Context, projectID string, client *bigquery.Client, hmacKey []byte, date string) error {
+func doAdd(ctx context.Context, projectID string, client bigquery.DB, hmacKey []byte, ex *vulndbreqs.Exclusions, date string) error {
 	if date == "" {
-		return vulndbreqs.ComputeAndStore(ctx, projectID, client, hmacKey)
+		return vulndbreqs.ComputeAndStore(ctx, projectID, client, hmacKey, ex)
 	}
 	d, err := civil.ParseDate(date)
 	if err != nil {
 		return err
 	}
-	return vulndbreqs.ComputeAndStoreDate(ctx, projectID, client, hmacKey, d)
+	return vulndbreqs.ComputeAndStoreDate(ctx, projectID, client, hmacKey, ex, d)
 }
 
-func doCompute(ctx context.Context, projectID string, hmacKey []byte) error {
+func doCompute(ctx context.Context, projectID string, hmacKey []byte, ex *vulndbreqs.Exclusions) error {
 	d, err := civil.ParseDate(*date)
 	if err != nil {
 		return err
 	}
-	rcs, err := vulndbreqs.Compute(ctx, projectID, d, hmacKey)
+	rcs, crcs, raw, err := vulndbreqs.Compute(ctx, projectID, d, hmacKey, ex)
 	if err != nil {
 		return err
 	}
+	fmt.Printf("%s\t%d\traw\n", d, raw)
 	for _, rc := range rcs {
 		fmt.Printf("%s\t%d\t%s\n", rc.Date, rc.Count, rc.IP)
 	}
+	for _, crc := range crcs {
+		fmt.Printf("%s\t%d\t%s\n", crc.Date, crc.Count, crc.Country)
+	}
 	return nil
 }
 
-func doShow(ctx context.Context, client *bigquery.Client) error {
+func doShow(ctx context.Context, client bigquery.DB) error {
 	counts, err := vulndbreqs.ReadRequestCountsFromBigQuery(ctx, client)
 	if err != nil {
 		return err
@@ -7,9 +7,11 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/signal"
 	"syscall"
@@ -17,7 +19,10 @@ import (
 
 	"golang.org/x/exp/slog"
 	"golang.org/x/pkgsite-metrics/internal/config"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
 	"golang.org/x/pkgsite-metrics/internal/log"
+	"golang.org/x/pkgsite-metrics/internal/proxy"
+	"golang.org/x/pkgsite-metrics/internal/proxy/proxytest"
 	"golang.org/x/pkgsite-metrics/internal/worker"
 )
 
@@ -27,6 +32,12 @@ var (
 	port     = flag.String("port", config.GetEnv("PORT", "8080"), "port to listen to")
 	dataset  = flag.String("dataset", "", "dataset (overrides GO_ECOSYSTEM_BIGQUERY_DATASET env var); use 'disable' for no BQ")
 	insecure = flag.Bool("insecure", false, "bypass sandbox in order to compare with old code")
+	attempts = flag.Int("attempts", 0, "maximum number of attempts per task, when running locally (0: default)")
+	debugMax = flag.Int("debugmax", 100, "maximum number of debug log records per second (<=0: no limit)")
+	logLevel = flag.String("loglevel", config.GetEnv("GO_ECOSYSTEM_LOG_LEVEL", "debug"), "minimum level of log records: debug, info, warn or error")
+	errRates = flag.String("errorsampling", config.GetEnv("GO_ECOSYSTEM_ERROR_SAMPLING", "LOAD=100"), "comma-separated CODE=N: log only one in N errors with error code CODE, or a code that extends it like CODE_X, at error level, the rest at debug level")
+	localDir = flag.String("local", "", "run end to end locally, writing results to and reading binaries from this directory; requires the Firestore emulator")
+	modDir   = flag.String("modules", "", "in local mode, serve the .txtar modules in this directory from a local proxy")
 	// flag used in call to safehtml/template.TrustedSourceFromFlag
 	_ = flag.String("static", "static", "path to folder containing static files served")
 )
@@ -48,6 +59,11 @@ func main() {
 	} else {
 		h = log.NewLineHandler(os.Stderr)
 	}
+	h, err := severityHandler(log.NewSamplingHandler(h, *debugMax))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 	slog.SetDefault(slog.New(h))
 	if err := runServer(ctx); err != nil {
 		log.Error(ctx, "failed to start the server", err)
@@ -57,17 +73,41 @@ func main() {
 	}
 }
 
+// severityHandler returns a handler that passes records to h according
+// to the -loglevel and -errorsampling flags.
+func severityHandler(h slog.Handler) (slog.Handler, error) {
+	level, err := log.ParseLevel(*logLevel)
+	if err != nil {
+		return nil, fmt.Errorf("-loglevel: %v", err)
+	}
+	rates, err := log.ParseErrorSampling(*errRates)
+	if err != nil {
+		return nil, fmt.Errorf("-errorsampling: %v", err)
+	}
+	return log.NewSeverityHandler(h, log.SeverityOptions{
+		MinLevel:      level,
+		Categorize:    func(err error) string { return derrors.CodeOf(err).String() },
+		ErrorSampling: rates,
+	}), nil
+}
+
 func runServer(ctx context.Context) error {
 	cfg, err := config.Init(ctx)
 	if err != nil {
 		return err
 	}
 	cfg.LocalQueueWorkers = *workers
+	cfg.LocalQueueMaxAttempts = *attempts
 	cfg.DevMode = *devMode
 	if *dataset != "" {
 		cfg.BigQueryDataset = *dataset
 	}
 	cfg.Insecure = *insecure
+	if *localDir != "" {
+		if err := configureLocal(cfg); err != nil {
+			return err
+		}
+	}
 	cfg.Dump(os.Stdout)
 	log.Infof(ctx, "config: project=%s, dataset=%s", cfg.ProjectID, cfg.BigQueryDataset)
 
@@ -76,19 +116,70 @@ func runServer(ctx context.Context) error {
 		return err
 	}
 	go monitor(ctx, s)
+	go logProxyStats(ctx)
 
 	addr := ":" + *port
 	log.Infof(ctx, "Listening on addr http://localhost%s", addr)
 	return fmt.Errorf("listening: %v", http.ListenAndServe(addr, nil))
 }
 
+// configureLocal configures cfg to run the worker end to end on the local
+// machine, with the Firestore emulator, the in-memory queue, results in
+// files, and optionally a local module proxy.
+func configureLocal(cfg *config.Config) error {
+	if os.Getenv("FIRESTORE_EMULATOR_HOST") == "" {
+		return errors.New("-local requires the Firestore emulator: run 'gcloud emulators firestore start' and set FIRESTORE_EMULATOR_HOST")
+	}
+	if config.OnCloudRun() {
+		return errors.New("-local cannot be used on Cloud Run")
+	}
+	if cfg.ProjectID == "" {
+		// The emulator accepts any project ID.
+		cfg.ProjectID = "local-project"
+	}
+	cfg.LocalDir = *localDir
+	cfg.LocalURL = "http://localhost:" + *port
+	if *modDir != "" {
+		// The server is never shut down; it lives as long as the worker.
+		ps := httptest.NewServer(proxytest.NewServer(proxytest.LoadTestModules(*modDir)))
+		cfg.ProxyURL = ps.URL
+	}
+	return nil
+}
+
 // monitor measures details of server execution from
 // the moment is starts listening to the moment it
-// gets a SIGTERM signal.
+// gets a SIGTERM signal, when it writes the results
+// waiting to be uploaded.
 func monitor(ctx context.Context, s *worker.Server) {
 	start := time.Now()
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, syscall.SIGTERM)
 	<-signals
 	log.Infof(ctx, "server stopped listening after: %v\n%s", time.Since(start), s.Info())
+	if err := s.FlushUploads(ctx); err != nil {
+		log.Errorf(ctx, err, "flushing uploads")
+	}
+}
+
+// proxyStatsInterval is how often a summary of the module proxy requests
+// is logged.
+const proxyStatsInterval = 10 * time.Minute
+
+// logProxyStats periodically logs a summary of the module proxy requests
+// made since the previous summary, so that proxy slowness shows up in the
+// logs next to the scans it slows down. It returns when ctx is done.
+func logProxyStats(ctx context.Context) {
+	ticker := time.NewTicker(proxyStatsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, s := range proxy.TakeStats() {
+				log.Infof(ctx, "proxy requests in the last %s: %s", proxyStatsInterval, s)
+			}
+		}
+	}
 }
@@ -8,10 +8,16 @@ package analysis
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	goversion "go/version"
 	"net/http"
+	"regexp"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -19,6 +25,7 @@ import (
 	"golang.org/x/exp/maps"
 	"golang.org/x/pkgsite-metrics/internal/bigquery"
 	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/jobs"
 	"golang.org/x/pkgsite-metrics/internal/queue"
 	"golang.org/x/pkgsite-metrics/internal/scan"
 )
@@ -31,23 +38,130 @@ type ScanRequest struct {
 type ScanParams struct {
 	Binary        string // name of analysis binary to run
 	BinaryVersion string // hex-encoded binary hash
-	Args          string // command-line arguments to binary; split on whitespace
+	Args          string // command-line arguments to binary, as joined by JoinArgs
+	Analyzers     string // comma-separated analyzers to enable; if empty, the binary's default
 	ImportedBy    int    // imported-by count of module in path
 	Insecure      bool   // if true, run outside sandbox
 	Serve         bool   // serve results back to client instead of writing them to BigQuery
 	JobID         string // ID of job, if non-empty
 	SkipInit      bool   // if true, do not initialize non-module Go projects
+	BuildTags     string // comma-separated build tags to build the module with
+	GoFlags       string // flags for the go command, as in GOFLAGS; split on whitespace
+	DepSnapshot   bool   // if true, run the binary on a read-only snapshot of the module's dependencies
+	BatchSize     int    // if positive, run the binary on batches of this many packages; if zero, decide by module size
+	PrivateCorpus string // if non-empty, read the module from this private corpus instead of the proxy
+	Go            string // Go toolchain to run, like "go1.22.3"; if empty, the worker's
+	Table         string // job table to write results to, instead of the analysis table; see JobTableName
+	Subdir        string // directory of the module to scan within the download, for repos whose Go module is not at the root; see scan.CheckSubdir
+	// Labels are recorded on the result row; see scan.ParseLabels.
+	Labels []string
+	// Share is true if other jobs may share the work of the request, so
+	// that its outcome must be recorded for them. See DB.ClaimWork.
+	Share bool
+}
+
+// RunParams are the parameters for a single, synchronous scan that
+// bypasses jobs and the task queue.
+type RunParams struct {
+	Module      string // module path
+	Version     string // module version
+	Binary      string // name of analysis binary to run
+	Args        string // command-line arguments to binary, as joined by JoinArgs
+	Analyzers   string // comma-separated analyzers to enable; if empty, the binary's default
+	Insecure    bool   // if true, run outside sandbox
+	Serve       bool   // serve results back to client instead of writing them to BigQuery
+	BuildTags   string // comma-separated build tags to build the module with
+	GoFlags     string // flags for the go command, as in GOFLAGS; split on whitespace
+	DepSnapshot bool   // if true, run the binary on a read-only snapshot of the module's dependencies
+	BatchSize   int    // if positive, run the binary on batches of this many packages; if zero, decide by module size
+	Go          string // Go toolchain to run, like "go1.22.3"; if empty, the worker's
+	Subdir      string // directory of the module to scan within the download; see scan.CheckSubdir
+}
+
+// ScanRequest returns the ScanRequest corresponding to p.
+func (p *RunParams) ScanRequest() *ScanRequest {
+	return &ScanRequest{
+		ModuleURLPath: scan.ModuleURLPath{Module: p.Module, Version: p.Version},
+		ScanParams: ScanParams{
+			Binary:      p.Binary,
+			Args:        p.Args,
+			Analyzers:   p.Analyzers,
+			Insecure:    p.Insecure,
+			Serve:       p.Serve,
+			BuildTags:   p.BuildTags,
+			GoFlags:     p.GoFlags,
+			DepSnapshot: p.DepSnapshot,
+			BatchSize:   p.BatchSize,
+			Go:          p.Go,
+			Subdir:      p.Subdir,
+		},
+	}
 }
 
 type EnqueueParams struct {
-	Binary   string // name of analysis binary to run
-	Args     string // command-line arguments to binary; split on whitespace
-	Insecure bool   // if true, run outside sandbox
-	Min      int    // minimum import-by count for a module to be included
-	File     string // path to file containing modules; if missing, use DB
-	Suffix   string // appended to task queue IDs to generate unique tasks
-	User     string // user initiating enqueue
-	SkipInit bool   // if true, do not initialize non-module Go projects
+	Binary      string // name of analysis binary to run
+	Args        string // command-line arguments to binary, as joined by JoinArgs
+	Analyzers   string // comma-separated analyzers to enable; if empty, the binary's default
+	Insecure    bool   // if true, run outside sandbox
+	Min         int    // minimum import-by count for a module to be included
+	File        string // file, glob or gs:// URL of modules; if missing, use DB
+	CorpusQuery string // BigQuery table/view of modules, one of the worker's corpus tables; used instead of DB if File is missing
+	Suffix      string // appended to task queue IDs to generate unique tasks
+	User        string // user initiating enqueue
+	SkipInit    bool   // if true, do not initialize non-module Go projects
+	Priority    string // task priority: high, normal or low; if empty, normal
+	BuildTags   string // comma-separated build tags to build modules with
+	GoFlags     string // flags for the go command, as in GOFLAGS; split on whitespace
+	DepSnapshot bool   // if true, run binaries on read-only snapshots of the modules' dependencies
+	BatchSize   int    // if positive, run binaries on batches of this many packages; if zero, decide by module size
+	Go          string // Go toolchain to run, like "go1.22.3"; if empty, the worker's
+	// BinarySHA256 is the hex-encoded SHA-256 hash of the binary that the
+	// client uploaded. If non-empty, the enqueue fails unless the binary
+	// has that hash, so that the job runs the binary the client expects.
+	BinarySHA256  string
+	ClientVersion string // version of the client, recorded in the job
+	// PrivateCorpus is the name of a private corpus uploaded by
+	// "ejobs start -corpusdir", of the form USER/NAME. If non-empty, its
+	// modules are scanned instead of those of File or CorpusQuery, and they
+	// are read from it instead of the proxy.
+	PrivateCorpus string
+	// NoShare disables sharing tasks with other jobs. Unless it is set,
+	// modules that an unfinished job with the same work is already
+	// scanning are not enqueued again, and the tasks of that job record
+	// their results for this one too. Only jobs share tasks, so requests
+	// without a User don't. See ScanRequest.WorkKey.
+	NoShare bool
+	// OwnTable writes the job's results to a table of its own, named by
+	// JobTableName, instead of the analysis table, so that they can be
+	// merged into the analysis table or dropped when the job is done. It
+	// requires User, and implies NoShare.
+	OwnTable bool
+	// Notify is where to send the summary of the job when it is
+	// finalized: the https URL of a webhook, or an email address after
+	// "mailto:". It requires User.
+	Notify string
+	// Labels are experiment labels of the form KEY:VALUE, recorded on
+	// every result row of the job; see scan.ParseLabels.
+	Labels []string
+	// IdempotencyKey identifies the enqueue, so that it can be safely
+	// retried: repeating an enqueue with the same key reports the job that
+	// the first one started instead of starting another. It requires User.
+	IdempotencyKey string
+}
+
+// PlanParams are the parameters for planning an enqueue: they select
+// the corpus as the EnqueueParams of the same names do.
+type PlanParams struct {
+	Min         int    // minimum import-by count for a module to be included
+	File        string // file, glob or gs:// URL of modules; if missing, use DB
+	CorpusQuery string // BigQuery table/view of modules, one of the worker's corpus tables; used instead of DB if File is missing
+}
+
+// A Plan describes the modules that an enqueue with the same corpus
+// parameters would scan.
+type Plan struct {
+	NumModules int
+	Modules    []scan.ModuleSpec
 }
 
 // Request implements queue.Task so it can be put on a TaskQueue.
@@ -61,6 +175,30 @@ func (r *ScanRequest) Params() string {
 	return scan.FormatParams(r.ScanParams)
 }
 
+// WorkKey returns a key that identifies the work of r: two requests
+// with the same key produce the same result rows, except for their job
+// IDs, whatever job they belong to. So the key covers every parameter that
+// affects the scan or is recorded on the row. Requests for the modules of
+// a private corpus have no key, since their source is not that of the
+// proxy, and neither do requests that write to a job table, since other
+// jobs cannot read their results. WorkKey returns "" for them.
+func (r *ScanRequest) WorkKey() string {
+	if r.PrivateCorpus != "" || r.Table != "" {
+		return ""
+	}
+	labels := slices.Clone(r.Labels)
+	slices.Sort(labels)
+	h := sha256.New()
+	for _, s := range []string{r.Module, r.Version, r.Binary, r.BinaryVersion, r.Args,
+		r.Analyzers, r.BuildTags, r.GoFlags, r.Go, strconv.FormatBool(r.SkipInit), r.Subdir,
+		strconv.FormatBool(r.Insecure), strconv.FormatBool(r.DepSnapshot),
+		strconv.Itoa(r.ImportedBy), strings.Join(labels, ",")} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
 func ParseScanRequest(r *http.Request, prefix string) (*ScanRequest, error) {
 	mp, err := scan.ParseModuleURLPath(strings.TrimPrefix(r.URL.Path, prefix))
 	if err != nil {
@@ -71,6 +209,12 @@ func ParseScanRequest(r *http.Request, prefix string) (*ScanRequest, error) {
 	if err := scan.ParseParams(r, &ap); err != nil {
 		return nil, err
 	}
+	if err := scan.CheckSubdir(ap.Subdir); err != nil {
+		return nil, err
+	}
+	if _, err := scan.ParseLabels(ap.Labels); err != nil {
+		return nil, err
+	}
 	return &ScanRequest{
 		ModuleURLPath: mp,
 		ScanParams:    ap,
@@ -91,6 +235,10 @@ type JSONDiagnostic struct {
 	Posn           string             `json:"posn"`
 	Message        string             `json:"message"`
 	SuggestedFixes []JSONSuggestedFix `json:"suggested_fixes,omitempty"`
+	// Severity and URL are not emitted by all analysis drivers.
+	// See diagnosticSeverity and diagnosticURL.
+	Severity string `json:"severity,omitempty"`
+	URL      string `json:"url,omitempty"`
 }
 
 // A JSONSuggestedFix describes an edit that should be applied as a whole or not
@@ -138,6 +286,7 @@ const TableName = "analysis"
 //   - dropping a column
 //   - changing a column from required to nullable.
 // See https://cloud.google.com/bigquery/docs/managing-table-schemas for details.
+// TestSchemaChanges in internal/bigquery enforces this.
 
 // Result is a row in the BigQuery analysis table. It corresponds to a
 // result from the output for an analysis.
@@ -152,9 +301,74 @@ type Result struct {
 	BinaryName    string `bigquery:"binary_name"`
 	Error         string `bigquery:"error"`
 	ErrorCategory string `bigquery:"error_category"`
-	WorkVersion          // InferSchema flattens embedded fields
+	// ErrorCode is the stable code of the error; see derrors.ErrorCode.
+	ErrorCode bq.NullInt64 `bigquery:"error_code"`
+	// CrashOutput is the panic message and stack trace of an analysis
+	// binary that crashed, truncated. It is null otherwise.
+	CrashOutput bq.NullString `bigquery:"crash_output"`
+	// ImportedBy is the number of importers of the module, as provided
+	// in the scan request.
+	ImportedBy bq.NullInt64 `bigquery:"imported_by"`
+	// Licenses are the types of the module's top-level license files,
+	// as detected by modules.DetectLicenses.
+	Licenses []string `bigquery:"licenses"`
+	// Redistributable reports whether the module's licenses permit
+	// redistribution, and so quoting the Source of its diagnostics.
+	// It is null if the module could not be examined.
+	Redistributable bq.NullBool `bigquery:"redistributable"`
+	// JobID is the ID of the job whose task wrote the row, if any.
+	JobID       bq.NullString `bigquery:"job_id"`
+	WorkVersion               // InferSchema flattens embedded fields
 
 	Diagnostics []*Diagnostic `bigquery:"diagnostic"`
+
+	// DriverProtocol is the version of the driver protocol that the
+	// binary speaks. It is null if the binary was not run.
+	DriverProtocol bq.NullInt64 `bigquery:"driver_protocol"`
+	// BinaryMetadata is the JSON-encoded Metadata of a binary that
+	// speaks version 2 or later of the driver protocol.
+	BinaryMetadata bq.NullString `bigquery:"binary_metadata"`
+	// ChecksumVerification is the result of verifying the module zip
+	// against the checksum database, like "verified"; see
+	// modules.Verified and related constants. It is null if the module
+	// was not downloaded.
+	ChecksumVerification bq.NullString `bigquery:"checksum_verification"`
+	// Network is the network access the binary had: NetworkOff in the
+	// sandbox, or NetworkHost outside it. It is null if the binary was
+	// not run.
+	Network bq.NullString `bigquery:"network"`
+	// GoEnv, GoSumHash and ModuleHash fingerprint the environment of the
+	// scan, so that it can be reproduced; see Fingerprint. They are null
+	// if the module was not prepared for scanning.
+	GoEnv      bq.NullString `bigquery:"go_env"`
+	GoSumHash  bq.NullString `bigquery:"go_sum_hash"`
+	ModuleHash bq.NullString `bigquery:"module_hash"`
+	// Subdir is the directory of the download that was scanned, for
+	// repos whose Go module is not at the root, or null if it was the
+	// root.
+	Subdir bq.NullString `bigquery:"subdir"`
+	// Labels are the experiment labels of the enqueue that requested the
+	// scan; see scan.ParseLabels.
+	Labels []*scan.Label `bigquery:"labels"`
+	// PrepStrategy is how the requirements of a module without a go.mod
+	// file were added after "go mod init": "tidy", or one of the
+	// fallbacks tried when "go mod tidy" fails, "tidy -e" or "-mod=mod".
+	// It is null if the module had a go.mod file or was not prepared.
+	PrepStrategy bq.NullString `bigquery:"prep_strategy"`
+}
+
+// SetMetadata records the binary's metadata in the Result.
+func (r *Result) SetMetadata(m *Metadata) error {
+	r.DriverProtocol = bq.NullInt64{Int64: int64(m.ProtocolVersion), Valid: true}
+	if m.ProtocolVersion < ProtocolV2 {
+		return nil
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	r.BinaryMetadata = bq.NullString{StringVal: string(data), Valid: true}
+	return nil
 }
 
 func (r *Result) AddError(err error) {
@@ -162,7 +376,9 @@ func (r *Result) AddError(err error) {
 		return
 	}
 	r.Error = err.Error()
-	r.ErrorCategory = derrors.CategorizeError(err)
+	code := derrors.CodeOf(err)
+	r.ErrorCategory = code.Category()
+	r.ErrorCode = bq.NullInt64{Int64: int64(code), Valid: true}
 }
 
 func (r *Result) SetUploadTime(t time.Time) { r.CreatedAt = t }
@@ -174,6 +390,20 @@ type WorkVersion struct {
 	// A hash of the  binary executed.
 	BinaryVersion string `bigquery:"binary_version"`
 	BinaryArgs    string `bigquery:"binary_args"` // args passed to binary
+	// The canonical form of the analyzers enabled for the binary,
+	// as returned by CanonicalAnalyzers. Null means the binary's default.
+	Analyzers bq.NullString `bigquery:"analyzers"`
+	// The build configuration of the module: the canonical build tags,
+	// as returned by CanonicalBuildTags, and the canonical go command
+	// flags, as returned by CanonicalGoFlags. Null means the default.
+	BuildTags bq.NullString `bigquery:"build_tags"`
+	GoFlags   bq.NullString `bigquery:"goflags"`
+	// The Go toolchain that ran the binary, as returned by
+	// CanonicalGoVersion. Null means the worker's default.
+	GoVersion bq.NullString `bigquery:"go_version"`
+	// DepSnapshot is true if the binary ran on a read-only snapshot of
+	// the module's dependencies. Null means it did not.
+	DepSnapshot bq.NullBool `bigquery:"dep_snapshot"`
 	// The version of the currently running code. This tracks changes in the
 	// logic of module scanning and processing.
 	WorkerVersion string `bigquery:"worker_version"`
@@ -192,6 +422,14 @@ type Diagnostic struct {
 	Position string        `bigquery:"position"`
 	Message  string        `bigquery:"message"`
 	Source   bq.NullString `bigquery:"source"`
+	// Severity is one of the Severity constants, or null if the analyzer
+	// did not report one. DocURL is the location of documentation for the
+	// diagnostic, or null.
+	Severity bq.NullString `bigquery:"severity"`
+	DocURL   bq.NullString `bigquery:"doc_url"`
+	// Fingerprint identifies the diagnostic across scans; see SetFingerprints.
+	// It is null for errors and for rows written before fingerprints existed.
+	Fingerprint bq.NullString `bigquery:"fingerprint"`
 }
 
 // SchemaVersion changes whenever the analysis schema changes.
@@ -206,25 +444,197 @@ func init() {
 	bigquery.AddTable(TableName, s)
 }
 
+// The network access of analysis binaries.
+const (
+	// NetworkOff is no network access. Binaries in the sandbox have none.
+	NetworkOff = "off"
+	// NetworkHost is the network access of the worker itself, which
+	// binaries run outside the sandbox, as for insecure scans, have.
+	NetworkHost = "host"
+)
+
+// jobTablePrefix begins the IDs of job tables.
+const jobTablePrefix = TableName + "_"
+
+// JobTableName returns the ID of the table that holds the results of the
+// job with the given ID, when the job does not write to the analysis
+// table. Characters that cannot appear in table IDs are replaced by
+// underscores.
+func JobTableName(jobID string) string {
+	return jobTablePrefix + jobTableRegexp.ReplaceAllString(jobID, "_")
+}
+
+var jobTableRegexp = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// IsJobTable reports whether tableID is the ID of a job table, as
+// returned by JobTableName.
+func IsJobTable(tableID string) bool {
+	rest, ok := strings.CutPrefix(tableID, jobTablePrefix)
+	return ok && rest != "" && !jobTableRegexp.MatchString(rest)
+}
+
+// RegisterJobTable registers the schema of the job table, which is that of
+// the analysis table, so that the table can be created and written to.
+func RegisterJobTable(tableID string) {
+	bigquery.AddTable(tableID, bigquery.TableSchema(TableName))
+}
+
+// CanonicalAnalyzers returns a canonical form of a comma-separated
+// list of analyzer names: sorted, without duplicates or surrounding
+// space. Two lists that select the same analyzers have the same
+// canonical form.
+func CanonicalAnalyzers(list string) (string, error) {
+	var names []string
+	seen := map[string]bool{}
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !analyzerNameRegexp.MatchString(name) {
+			return "", fmt.Errorf("invalid analyzer name %q", name)
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, ","), nil
+}
+
+var analyzerNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// CanonicalBuildTags returns a canonical form of a comma-separated
+// list of build tags: sorted, without duplicates or surrounding space.
+func CanonicalBuildTags(list string) (string, error) {
+	var tags []string
+	seen := map[string]bool{}
+	for _, tag := range strings.Split(list, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			continue
+		}
+		if !buildTagRegexp.MatchString(tag) {
+			return "", fmt.Errorf("invalid build tag %q", tag)
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+	return strings.Join(tags, ","), nil
+}
+
+// Build tags are made of letters, digits, underscores and dots.
+var buildTagRegexp = regexp.MustCompile(`^[A-Za-z0-9_.]+$`)
+
+// allowedGoFlags maps the names of the go flags that scans accept to
+// their allowed values, or to nil for boolean flags. The go command runs
+// with these flags on the host, outside the sandbox, to prepare modules
+// and list their packages, so flags that name files or programs, like
+// -modfile, -overlay and -toolexec, are not allowed.
+var allowedGoFlags = map[string][]string{
+	"mod":      {"mod", "readonly", "vendor"},
+	"buildvcs": {"true", "false", "auto"},
+	"trimpath": nil,
+}
+
+// CanonicalGoFlags returns a canonical form of flags for the go command:
+// the whitespace-separated flags, each beginning with a single dash,
+// sorted and joined by a single space. Since the last of repeated flags
+// wins, only that one is kept. Only the flags of allowedGoFlags are
+// allowed. Build tags must be given separately, with the buildtags
+// parameter, so the -tags flag is not allowed.
+func CanonicalGoFlags(flags string) (string, error) {
+	fields := strings.Fields(flags)
+	byName := map[string]string{}
+	for _, f := range fields {
+		if !strings.HasPrefix(f, "-") {
+			return "", fmt.Errorf("invalid go flag %q: must begin with a dash", f)
+		}
+		name, value, hasValue := strings.Cut(strings.TrimPrefix(strings.TrimPrefix(f, "-"), "-"), "=")
+		if name == "tags" {
+			return "", fmt.Errorf("invalid go flag %q: use build tags instead", f)
+		}
+		values, ok := allowedGoFlags[name]
+		if !ok {
+			return "", fmt.Errorf("go flag %q is not allowed", f)
+		}
+		if values == nil {
+			if hasValue && value != "true" && value != "false" {
+				return "", fmt.Errorf("invalid go flag %q: value must be true or false", f)
+			}
+		} else if !slices.Contains(values, value) {
+			return "", fmt.Errorf("invalid go flag %q: value must be one of %s", f, strings.Join(values, ", "))
+		}
+		f = "-" + name
+		if hasValue {
+			f += "=" + value
+		}
+		byName[name] = f
+	}
+	fields = fields[:0]
+	for _, f := range byName {
+		fields = append(fields, f)
+	}
+	slices.Sort(fields)
+	return strings.Join(fields, " "), nil
+}
+
+// CanonicalGoVersion returns the canonical name of a Go toolchain, like
+// "go1.22.3", given its name or version, like "1.22.3". It returns the
+// empty string for the empty string.
+func CanonicalGoVersion(v string) (string, error) {
+	if v == "" {
+		return "", nil
+	}
+	if !strings.HasPrefix(v, "go") {
+		v = "go" + v
+	}
+	if !goversion.IsValid(v) {
+		return "", fmt.Errorf("invalid Go version %q", v)
+	}
+	return v, nil
+}
+
+// GoFlagsEnv returns the value of the GOFLAGS environment variable
+// for building with the given canonical build tags and go flags,
+// or the empty string if both are empty.
+func GoFlagsEnv(buildTags, goflags string) string {
+	var fields []string
+	if goflags != "" {
+		fields = append(fields, goflags)
+	}
+	if buildTags != "" {
+		fields = append(fields, "-tags="+buildTags)
+	}
+	return strings.Join(fields, " ")
+}
+
 // WorkVersionKey is the key for a WorkVersion.
 // Always compare two WorkVersions with the same key.
 type WorkVersionKey struct {
 	Module  string
 	Version string
 	Binary  string
+	// Labels are the experiment labels of the scan, as formatted by
+	// scan.FormatLabels. Scans with other labels don't count, so that
+	// every module has rows with the labels of a job.
+	Labels string
 }
 
 // ReadWorkVersion reads the most recent WorkVersion in the analysis table
-// for module_path at version for binary.
-func ReadWorkVersion(ctx context.Context, c *bigquery.Client, module_path, version, binary string) (wv *WorkVersion, err error) {
+// for the module version, binary and labels of key.
+func ReadWorkVersion(ctx context.Context, c bigquery.DB, key WorkVersionKey) (wv *WorkVersion, err error) {
 	defer derrors.Wrap(&err, "ReadWorkVersion")
 
-	const qf = `
-                SELECT binary_version, binary_args, worker_version, schema_version
-                FROM %s WHERE module_path="%s" AND version="%s" AND binary_name="%s" ORDER BY created_at DESC LIMIT 1
-        `
-	query := fmt.Sprintf(qf, "`"+c.FullTableName(TableName)+"`", module_path, version, binary)
-	iter, err := c.Query(ctx, query)
+	iter, err := c.Query(ctx, workVersionQuery(c.FullTableName(TableName)),
+		bigquery.Param{Name: "module_path", Value: key.Module},
+		bigquery.Param{Name: "version", Value: key.Version},
+		bigquery.Param{Name: "binary_name", Value: key.Binary},
+		bigquery.Param{Name: "labels", Value: key.Labels})
 	if err != nil {
 		return nil, err
 	}
@@ -239,6 +649,19 @@ func ReadWorkVersion(ctx context.Context, c *bigquery.Client, module_path, versi
 	return wv, nil
 }
 
+// workVersionQuery returns the query used by ReadWorkVersion.
+// It ignores the rows of scans of subdirectories. The labels of a row are
+// formatted as by scan.FormatLabels to compare them.
+func workVersionQuery(fullTableName string) string {
+	const qf = `
+                SELECT binary_version, binary_args, analyzers, build_tags, goflags, go_version, dep_snapshot, worker_version, schema_version
+                FROM %s WHERE module_path=@module_path AND version=@version AND binary_name=@binary_name AND subdir IS NULL
+                AND ARRAY_TO_STRING(ARRAY(SELECT CONCAT(l.key, ':', l.value) FROM UNNEST(labels) AS l ORDER BY l.key), ',')=@labels
+                ORDER BY created_at DESC LIMIT 1
+        `
+	return fmt.Sprintf(qf, "`"+fullTableName+"`")
+}
+
 // JSONTreeToDiagnostics converts a jsonTree to a list of diagnostics for BigQuery.
 // It ignores the suggested fixes of the diagnostics.
 func JSONTreeToDiagnostics(jsonTree JSONTree) []*Diagnostic {
@@ -260,12 +683,15 @@ func JSONTreeToDiagnostics(jsonTree JSONTree) []*Diagnostic {
 				})
 			} else {
 				for _, jd := range diagsOrErr.Diagnostics {
+					sev, url := diagnosticSeverity(jd), diagnosticURL(jd)
 					diags = append(diags, &Diagnostic{
 						PackageID:    pkgID,
 						AnalyzerName: aName,
 						Category:     jd.Category,
 						Position:     jd.Posn,
 						Message:      jd.Message,
+						Severity:     bq.NullString{StringVal: sev, Valid: sev != ""},
+						DocURL:       bq.NullString{StringVal: url, Valid: url != ""},
 					})
 				}
 			}
@@ -274,16 +700,66 @@ func JSONTreeToDiagnostics(jsonTree JSONTree) []*Diagnostic {
 	return diags
 }
 
-func ReadResults(ctx context.Context, c *bigquery.Client, binaryName, binaryVersion, binaryArgs string) (_ []*Result, err error) {
-	defer derrors.Wrap(&err, "ReadResults")
-	q := bigquery.PartitionQuery{
-		From:        c.FullTableName(TableName),
-		PartitionOn: "module_path, version",
-		Where: fmt.Sprintf("binary_name='%s' AND binary_version='%s' AND binary_args='%s'",
-			binaryName, binaryVersion, binaryArgs),
-		OrderBy: "created_at DESC",
+// A ResultFilter selects analysis results. Empty fields match everything.
+type ResultFilter struct {
+	// ModulePrefix matches a module path and the module paths under it.
+	ModulePrefix string
+	// Category matches the error category of a result.
+	Category string
+	// Analyzer matches results with diagnostics of the analyzer, and
+	// keeps only those diagnostics.
+	Analyzer string
+}
+
+// matchModule reports whether modulePath is, or is under, f.ModulePrefix.
+func (f ResultFilter) matchModule(modulePath string) bool {
+	p := f.ModulePrefix
+	return p == "" || modulePath == p || strings.HasPrefix(modulePath, moduleDirPrefix(p))
+}
+
+// moduleDirPrefix returns the prefix of the module paths under p.
+func moduleDirPrefix(p string) string {
+	return strings.TrimSuffix(p, "/") + "/"
+}
+
+// Apply returns the results that match f, as ReadResults does.
+func (f ResultFilter) Apply(results []*Result) []*Result {
+	var matched []*Result
+	for _, r := range results {
+		if !f.matchModule(r.ModulePath) {
+			continue
+		}
+		if f.Category != "" && r.ErrorCategory != f.Category {
+			continue
+		}
+		if f.Analyzer != "" {
+			var ds []*Diagnostic
+			for _, d := range r.Diagnostics {
+				if d.AnalyzerName == f.Analyzer {
+					ds = append(ds, d)
+				}
+			}
+			if len(ds) == 0 {
+				continue
+			}
+			c := *r
+			c.Diagnostics = ds
+			r = &c
+		}
+		matched = append(matched, r)
 	}
-	iter, err := c.Query(ctx, q.String())
+	return matched
+}
+
+// ReadResults reads the most recent results for each module version that
+// was analyzed with the given binary, args, analyzers, build configuration
+// and Go toolchain, and returns those that match the filter. The results are
+// read from the table tableID: the analysis table or a job table.
+func ReadResults(ctx context.Context, c bigquery.DB, tableID string, job *jobs.Job, filter ResultFilter) (_ []*Result, err error) {
+	defer derrors.Wrap(&err, "ReadResults")
+	q := resultsQuery(c.FullTableName(tableID), job)
+	query, params := filteredResultsQuery(q, filter)
+	iter, err := c.Query(ctx, query, params...)
 	if err != nil {
 		return nil, err
 	}
@@ -297,3 +773,207 @@ func ReadResults(ctx context.Context, c *bigquery.Client, binaryName, binaryVers
 	}
 	return res, nil
 }
+
+// resultsQuery returns the query used by ReadResults, before filtering.
+// The results of a job with a table of its own are only its rows, even
+// once the table has been merged into the analysis table.
+func resultsQuery(fullTableName string, job *jobs.Job) bigquery.PartitionQuery {
+	q := bigquery.PartitionQuery{
+		From:        "`" + fullTableName + "`",
+		PartitionOn: "module_path, version",
+		Where: "binary_name=@binary_name AND binary_version=@binary_version AND binary_args=@binary_args" +
+			" AND IFNULL(analyzers, '')=@analyzers" +
+			" AND IFNULL(build_tags, '')=@build_tags AND IFNULL(goflags, '')=@goflags" +
+			" AND IFNULL(go_version, '')=@go_version AND IFNULL(dep_snapshot, FALSE)=@dep_snapshot",
+		OrderBy: "created_at DESC",
+		Params: []bigquery.Param{
+			{Name: "binary_name", Value: job.Binary},
+			{Name: "binary_version", Value: job.BinaryVersion},
+			{Name: "binary_args", Value: job.BinaryArgs},
+			{Name: "analyzers", Value: job.Analyzers},
+			{Name: "build_tags", Value: job.BuildTags},
+			{Name: "goflags", Value: job.GoFlags},
+			{Name: "go_version", Value: job.GoVersion},
+			{Name: "dep_snapshot", Value: job.DepSnapshot},
+		},
+	}
+	if job.Table != "" {
+		q.Where += " AND job_id=@job_id"
+		q.Params = append(q.Params, bigquery.Param{Name: "job_id", Value: job.ID()})
+	}
+	return q
+}
+
+// MatchesJob reports whether r is a result of the work of job: a scan with
+// the same binary, args, analyzers, build configuration, Go toolchain and
+// dependency mode, by the job itself if it has a table of its own. Those
+// are the results that ReadResults reads.
+func (r *Result) MatchesJob(job *jobs.Job) bool {
+	if job.Table != "" && r.JobID.StringVal != job.ID() {
+		return false
+	}
+	return r.BinaryName == job.Binary && r.BinaryVersion == job.BinaryVersion &&
+		r.BinaryArgs == job.BinaryArgs && r.Analyzers.StringVal == job.Analyzers &&
+		r.BuildTags.StringVal == job.BuildTags && r.GoFlags.StringVal == job.GoFlags &&
+		r.GoVersion.StringVal == job.GoVersion && r.DepSnapshot.Bool == job.DepSnapshot
+}
+
+// filteredResultsQuery returns the query of q restricted to the results
+// that match f. The module filter applies to all rows of a module version,
+// so it restricts q itself. The others apply to the most recent result
+// only, so they restrict the rows q selects.
+func filteredResultsQuery(q bigquery.PartitionQuery, f ResultFilter) (string, []bigquery.Param) {
+	params := append([]bigquery.Param(nil), q.Params...)
+	if f.ModulePrefix != "" {
+		q.Where += " AND (module_path=@module_prefix OR STARTS_WITH(module_path, @module_dir_prefix))"
+		params = append(params,
+			bigquery.Param{Name: "module_prefix", Value: f.ModulePrefix},
+			bigquery.Param{Name: "module_dir_prefix", Value: moduleDirPrefix(f.ModulePrefix)})
+	}
+	if f.Category == "" && f.Analyzer == "" {
+		return q.String(), params
+	}
+	cols := "*"
+	var conds []string
+	if f.Category != "" {
+		conds = append(conds, "error_category=@category")
+		params = append(params, bigquery.Param{Name: "category", Value: f.Category})
+	}
+	if f.Analyzer != "" {
+		const analyzerDiags = "SELECT d FROM UNNEST(diagnostic) d WHERE d.analyzer_name=@analyzer"
+		cols = "* REPLACE (ARRAY(" + analyzerDiags + ") AS diagnostic)"
+		conds = append(conds, "EXISTS("+analyzerDiags+")")
+		params = append(params, bigquery.Param{Name: "analyzer", Value: f.Analyzer})
+	}
+	return fmt.Sprintf("SELECT %s FROM (%s) WHERE %s", cols, q.String(), strings.Join(conds, " AND ")), params
+}
+
+// ReadJobRowCounts counts the rows written by the tasks of the job to the
+// table tableID.
+func ReadJobRowCounts(ctx context.Context, c bigquery.DB, tableID, jobID string) (_ *jobs.RowCounts, err error) {
+	defer derrors.Wrap(&err, "ReadJobRowCounts(%q)", jobID)
+	iter, err := c.Query(ctx, jobRowCountsQuery(c.FullTableName(tableID)),
+		bigquery.Param{Name: "job_id", Value: jobID})
+	if err != nil {
+		return nil, err
+	}
+	rcs, err := bigquery.All[jobs.RowCounts](iter)
+	if err != nil {
+		return nil, err
+	}
+	if len(rcs) == 0 {
+		return &jobs.RowCounts{}, nil
+	}
+	return rcs[0], nil
+}
+
+// jobRowCountsQuery returns the query used by ReadJobRowCounts.
+// A module version counts once, however many rows retried tasks wrote for it.
+func jobRowCountsQuery(fullTableName string) string {
+	const qf = `
+		SELECT COUNT(*) AS num_rows,
+			COUNT(DISTINCT IF(error = '', CONCAT(module_path, '@', version), NULL)) AS num_succeeded,
+			COUNT(DISTINCT IF(error != '', CONCAT(module_path, '@', version), NULL)) AS num_errored
+		FROM %s WHERE job_id=@job_id
+	`
+	return fmt.Sprintf(qf, "`"+fullTableName+"`")
+}
+
+// A Fingerprint describes the environment in which a module was scanned,
+// so that a surprising diagnostic can be reproduced exactly.
+type Fingerprint struct {
+	ModulePath    string    `bigquery:"module_path"`
+	Version       string    `bigquery:"version"`
+	CreatedAt     time.Time `bigquery:"created_at"`
+	BinaryName    string    `bigquery:"binary_name"`
+	BinaryVersion string    `bigquery:"binary_version"`
+	// GoEnv is the JSON object of the Go environment variables relevant
+	// to loading packages, like GOFLAGS and GOVERSION, that the binary ran
+	// with.
+	GoEnv bq.NullString `bigquery:"go_env"`
+	// GoSumHash is the SHA-256 hash of the module's go.sum file after it
+	// was prepared, which pins the versions of its dependencies.
+	GoSumHash bq.NullString `bigquery:"go_sum_hash"`
+	// ModuleHash is the hash of the module's files as downloaded, in the
+	// format of go.sum, like "h1:...".
+	ModuleHash bq.NullString `bigquery:"module_hash"`
+}
+
+// ReadFingerprint returns the fingerprint of the most recent scan of
+// modulePath by the job, read from the table tableID. It returns an error
+// wrapping derrors.NotFound if the job has not scanned the module.
+func ReadFingerprint(ctx context.Context, c bigquery.DB, tableID, jobID, modulePath string) (_ *Fingerprint, err error) {
+	defer derrors.Wrap(&err, "ReadFingerprint(%q, %q)", jobID, modulePath)
+	iter, err := c.Query(ctx, fingerprintQuery(c.FullTableName(tableID)),
+		bigquery.Param{Name: "job_id", Value: jobID},
+		bigquery.Param{Name: "module_path", Value: modulePath})
+	if err != nil {
+		return nil, err
+	}
+	fps, err := bigquery.All[Fingerprint](iter)
+	if err != nil {
+		return nil, err
+	}
+	if len(fps) == 0 {
+		return nil, fmt.Errorf("%w: no result for module", derrors.NotFound)
+	}
+	return fps[0], nil
+}
+
+// fingerprintQuery returns the query used by ReadFingerprint.
+func fingerprintQuery(fullTableName string) string {
+	const qf = `
+		SELECT module_path, version, created_at, binary_name, binary_version, go_env, go_sum_hash, module_hash
+		FROM %s WHERE job_id=@job_id AND module_path=@module_path
+		ORDER BY created_at DESC LIMIT 1
+	`
+	return fmt.Sprintf(qf, "`"+fullTableName+"`")
+}
+
+// A DiagnosticRank summarizes the modules affected by a diagnostic
+// message. Ranks are ordered by ecosystem impact: the total number of
+// importers of the affected modules.
+type DiagnosticRank struct {
+	AnalyzerName    string `bigquery:"analyzer_name"`
+	Category        string `bigquery:"category"`
+	Message         string `bigquery:"message"`
+	NumModules      int    `bigquery:"num_modules"`       // number of distinct module versions with the diagnostic
+	TotalImportedBy int    `bigquery:"total_imported_by"` // sum of importers of those module versions
+}
+
+// ReadDiagnosticRanks reads the most recent results of the job's work, as
+// with ReadResults, and returns the limit diagnostic messages whose modules
+// have the most importers.
+func ReadDiagnosticRanks(ctx context.Context, c bigquery.DB, tableID string, job *jobs.Job, limit int) (_ []*DiagnosticRank, err error) {
+	defer derrors.Wrap(&err, "ReadDiagnosticRanks")
+	q, params := diagnosticRanksQuery(c.FullTableName(tableID), job, limit)
+	iter, err := c.Query(ctx, q, params...)
+	if err != nil {
+		return nil, err
+	}
+	return bigquery.All[DiagnosticRank](iter)
+}
+
+// diagnosticRanksQuery returns the query and parameters used by ReadDiagnosticRanks.
+// A module version counts once per message, however many times the message
+// appears in it. Modules without an imported-by count contribute zero.
+func diagnosticRanksQuery(fullTableName string, job *jobs.Job, limit int) (string, []bigquery.Param) {
+	rq := resultsQuery(fullTableName, job)
+	const qf = `
+		WITH results AS (%s),
+		affected AS (
+			SELECT DISTINCT r.module_path, r.version, IFNULL(r.imported_by, 0) AS imported_by,
+				d.analyzer_name, d.category, d.message
+			FROM results r, UNNEST(r.diagnostic) d
+			WHERE IFNULL(d.error, '') = ''
+		)
+		SELECT analyzer_name, category, message,
+			COUNT(*) AS num_modules, SUM(imported_by) AS total_imported_by
+		FROM affected
+		GROUP BY analyzer_name, category, message
+		ORDER BY total_imported_by DESC, num_modules DESC, analyzer_name, message
+		LIMIT @limit
+	`
+	params := append(rq.Params, bigquery.Param{Name: "limit", Value: limit})
+	return fmt.Sprintf(qf, rq.String()), params
+}
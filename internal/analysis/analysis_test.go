@@ -5,9 +5,13 @@
 package analysis
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/jobs"
+	"golang.org/x/pkgsite-metrics/internal/scan"
 )
 
 func TestJSONTreeToDiagnostics(t *testing.T) {
@@ -20,7 +24,10 @@ func TestJSONTreeToDiagnostics(t *testing.T) {
 				},
 			},
 			"b": {
-				Diagnostics: []JSONDiagnostic{{Category: "c3", Posn: "pos3", Message: "m3"}},
+				Diagnostics: []JSONDiagnostic{
+					{Category: "c3", Posn: "pos3", Message: "m3"},
+					{Category: "warning:c4", Posn: "pos4", Message: "m4", URL: "https://doc"},
+				},
 			},
 		},
 		"pkg2": {
@@ -34,9 +41,319 @@ func TestJSONTreeToDiagnostics(t *testing.T) {
 		{PackageID: "pkg1", AnalyzerName: "a", Category: "c1", Position: "pos1", Message: "m1"},
 		{PackageID: "pkg1", AnalyzerName: "a", Category: "c2", Position: "pos2", Message: "m2"},
 		{PackageID: "pkg1", AnalyzerName: "b", Category: "c3", Position: "pos3", Message: "m3"},
+		{PackageID: "pkg1", AnalyzerName: "b", Category: "warning:c4", Position: "pos4", Message: "m4",
+			Severity: bigquery.NullString(SeverityWarning), DocURL: bigquery.NullString("https://doc")},
 		{PackageID: "pkg2", AnalyzerName: "c", Error: "fail"},
 	}
 	if diff := cmp.Diff(want, got); diff != "" {
 		t.Errorf("mismatch (-want, +got)\n%s", diff)
 	}
 }
+
+func TestQueries(t *testing.T) {
+	clean := func(s string) string {
+		return strings.Join(strings.Fields(s), " ")
+	}
+
+	got := clean(workVersionQuery("p.d.analysis"))
+	want := "SELECT binary_version, binary_args, analyzers, build_tags, goflags, go_version, dep_snapshot, worker_version, schema_version FROM `p.d.analysis` " +
+		"WHERE module_path=@module_path AND version=@version AND binary_name=@binary_name AND subdir IS NULL " +
+		"AND ARRAY_TO_STRING(ARRAY(SELECT CONCAT(l.key, ':', l.value) FROM UNNEST(labels) AS l ORDER BY l.key), ',')=@labels " +
+		"ORDER BY created_at DESC LIMIT 1"
+	if got != want {
+		t.Errorf("workVersionQuery:\ngot  %s\nwant %s", got, want)
+	}
+
+	job := &jobs.Job{
+		Binary:        "bin",
+		BinaryVersion: "v1",
+		BinaryArgs:    "-x 'y'",
+		Analyzers:     "nilness",
+		BuildTags:     "integration",
+		GoFlags:       "-mod=mod",
+		GoVersion:     "go1.22.3",
+		DepSnapshot:   true,
+	}
+	q := resultsQuery("p.d.analysis", job)
+	got = clean(q.String())
+	want = "SELECT * EXCEPT (rownum) FROM ( SELECT *, ROW_NUMBER() OVER ( PARTITION BY module_path, version ORDER BY created_at DESC ) AS rownum " +
+		"FROM `p.d.analysis` WHERE binary_name=@binary_name AND binary_version=@binary_version AND binary_args=@binary_args AND IFNULL(analyzers, '')=@analyzers " +
+		"AND IFNULL(build_tags, '')=@build_tags AND IFNULL(goflags, '')=@goflags AND IFNULL(go_version, '')=@go_version AND IFNULL(dep_snapshot, FALSE)=@dep_snapshot ) WHERE rownum = 1"
+	if got != want {
+		t.Errorf("resultsQuery:\ngot  %s\nwant %s", got, want)
+	}
+	// The arguments must not appear in the query text.
+	if strings.Contains(got, "'y'") {
+		t.Errorf("resultsQuery: args formatted into query: %s", got)
+	}
+	if len(q.Params) != 8 || q.Params[2].Value != "-x 'y'" || q.Params[7].Value != true {
+		t.Errorf("resultsQuery: got params %v", q.Params)
+	}
+
+	// The results of a job with a table of its own are its rows only.
+	ownJob := *job
+	ownJob.Table = JobTableName(job.ID())
+	oq := resultsQuery("p.d.analysis", &ownJob)
+	if !strings.HasSuffix(oq.Where, " AND job_id=@job_id") || oq.Params[len(oq.Params)-1].Value != job.ID() {
+		t.Errorf("resultsQuery for job with own table: got %s, %v", oq.Where, oq.Params)
+	}
+
+	fq, fparams := filteredResultsQuery(q, ResultFilter{ModulePrefix: "example.com/a", Category: "LOAD", Analyzer: "printf"})
+	got = clean(fq)
+	analyzerDiags := "SELECT d FROM UNNEST(diagnostic) d WHERE d.analyzer_name=@analyzer"
+	want = "SELECT * REPLACE (ARRAY(" + analyzerDiags + ") AS diagnostic) FROM ( " +
+		strings.Replace(clean(q.String()), " ) WHERE rownum = 1",
+			" AND (module_path=@module_prefix OR STARTS_WITH(module_path, @module_dir_prefix)) ) WHERE rownum = 1", 1) +
+		" ) WHERE error_category=@category AND EXISTS(" + analyzerDiags + ")"
+	if got != want {
+		t.Errorf("filteredResultsQuery:\ngot  %s\nwant %s", got, want)
+	}
+	wantParams := append(q.Params,
+		bigquery.Param{Name: "module_prefix", Value: "example.com/a"},
+		bigquery.Param{Name: "module_dir_prefix", Value: "example.com/a/"},
+		bigquery.Param{Name: "category", Value: "LOAD"},
+		bigquery.Param{Name: "analyzer", Value: "printf"})
+	if diff := cmp.Diff(wantParams, fparams); diff != "" {
+		t.Errorf("filteredResultsQuery params mismatch (-want, +got):\n%s", diff)
+	}
+	if fq, _ := filteredResultsQuery(q, ResultFilter{}); fq != q.String() {
+		t.Errorf("filteredResultsQuery with no filter:\ngot  %s\nwant %s", fq, q.String())
+	}
+
+	rq, params := diagnosticRanksQuery("p.d.analysis", job, 10)
+	got = clean(rq)
+	want = "WITH results AS ( " + clean(q.String()) + " ), " +
+		"affected AS ( SELECT DISTINCT r.module_path, r.version, IFNULL(r.imported_by, 0) AS imported_by, " +
+		"d.analyzer_name, d.category, d.message FROM results r, UNNEST(r.diagnostic) d WHERE IFNULL(d.error, '') = '' ) " +
+		"SELECT analyzer_name, category, message, COUNT(*) AS num_modules, SUM(imported_by) AS total_imported_by " +
+		"FROM affected GROUP BY analyzer_name, category, message " +
+		"ORDER BY total_imported_by DESC, num_modules DESC, analyzer_name, message LIMIT @limit"
+	if got != want {
+		t.Errorf("diagnosticRanksQuery:\ngot  %s\nwant %s", got, want)
+	}
+	if len(params) != 9 || params[8] != (bigquery.Param{Name: "limit", Value: 10}) {
+		t.Errorf("diagnosticRanksQuery: got params %v", params)
+	}
+
+	got = clean(jobRowCountsQuery("p.d.analysis"))
+	want = "SELECT COUNT(*) AS num_rows, " +
+		"COUNT(DISTINCT IF(error = '', CONCAT(module_path, '@', version), NULL)) AS num_succeeded, " +
+		"COUNT(DISTINCT IF(error != '', CONCAT(module_path, '@', version), NULL)) AS num_errored " +
+		"FROM `p.d.analysis` WHERE job_id=@job_id"
+	if got != want {
+		t.Errorf("jobRowCountsQuery:\ngot  %s\nwant %s", got, want)
+	}
+
+	got = clean(fingerprintQuery("p.d.analysis"))
+	want = "SELECT module_path, version, created_at, binary_name, binary_version, go_env, go_sum_hash, module_hash " +
+		"FROM `p.d.analysis` WHERE job_id=@job_id AND module_path=@module_path ORDER BY created_at DESC LIMIT 1"
+	if got != want {
+		t.Errorf("fingerprintQuery:\ngot  %s\nwant %s", got, want)
+	}
+
+	fullTableName := func(id string) string { return "p.d." + id }
+	latest := clean(latestViewQuery(fullTableName))
+	want = "SELECT * EXCEPT (rownum) FROM ( SELECT *, ROW_NUMBER() OVER ( PARTITION BY module_path, version, binary_name ORDER BY created_at DESC ) AS rownum " +
+		"FROM `p.d.analysis` ) WHERE rownum = 1"
+	if latest != want {
+		t.Errorf("latestViewQuery:\ngot  %s\nwant %s", latest, want)
+	}
+	got = clean(errorCountsViewQuery(fullTableName))
+	want = "SELECT binary_name, error_category, COUNT(*) AS num_results FROM ( " + latest + " ) " +
+		"WHERE error != '' GROUP BY binary_name, error_category"
+	if got != want {
+		t.Errorf("errorCountsViewQuery:\ngot  %s\nwant %s", got, want)
+	}
+}
+
+func TestCanonicalAnalyzers(t *testing.T) {
+	for _, test := range []struct {
+		in, want string
+		wantErr  bool
+	}{
+		{"", "", false},
+		{"printf", "printf", false},
+		{"printf,nilness", "nilness,printf", false},
+		{" nilness , printf,nilness,", "nilness,printf", false},
+		{"nil ness", "", true},
+		{"-printf", "", true},
+	} {
+		got, err := CanonicalAnalyzers(test.in)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%q: got error %v, want error: %t", test.in, err, test.wantErr)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%q: got %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestCanonicalBuildTags(t *testing.T) {
+	for _, test := range []struct {
+		in, want string
+		wantErr  bool
+	}{
+		{"", "", false},
+		{"integration", "integration", false},
+		{" linux,integration,linux, go1.21", "go1.21,integration,linux", false},
+		{"a b", "", true},
+		{"!linux", "", true},
+	} {
+		got, err := CanonicalBuildTags(test.in)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%q: got error %v, want error: %t", test.in, err, test.wantErr)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%q: got %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestCanonicalGoFlags(t *testing.T) {
+	for _, test := range []struct {
+		in, want string
+		wantErr  bool
+	}{
+		{"", "", false},
+		{"  -mod=mod   -trimpath ", "-mod=mod -trimpath", false},
+		{"--mod=readonly -buildvcs=false -trimpath=true", "-buildvcs=false -mod=readonly -trimpath=true", false},
+		// The same flags in any order have the same canonical form.
+		{"-trimpath -mod=mod", "-mod=mod -trimpath", false},
+		// The last of repeated flags wins.
+		{"-mod=vendor -trimpath -mod=mod", "-mod=mod -trimpath", false},
+		{"mod=mod", "", true},
+		{"-tags=integration", "", true},
+		{"--tags", "", true},
+		{"-mod=other", "", true},
+		{"-mod", "", true},
+		{"-trimpath=yes", "", true},
+		{"-modfile=/etc/go.mod", "", true},
+		{"-overlay=/tmp/overlay.json", "", true},
+		{"-toolexec=/bin/sh", "", true},
+		{"---mod=mod", "", true},
+	} {
+		got, err := CanonicalGoFlags(test.in)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%q: got error %v, want error: %t", test.in, err, test.wantErr)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%q: got %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestCanonicalGoVersion(t *testing.T) {
+	for _, test := range []struct {
+		in, want string
+		wantErr  bool
+	}{
+		{"", "", false},
+		{"1.22.3", "go1.22.3", false},
+		{"go1.22.3", "go1.22.3", false},
+		{"go1.23rc1", "go1.23rc1", false},
+		{"1.22.x", "", true},
+		{"latest", "", true},
+	} {
+		got, err := CanonicalGoVersion(test.in)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%q: got error %v, want error: %t", test.in, err, test.wantErr)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%q: got %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestGoFlagsEnv(t *testing.T) {
+	for _, test := range []struct {
+		tags, flags, want string
+	}{
+		{"", "", ""},
+		{"a,b", "", "-tags=a,b"},
+		{"", "-mod=mod", "-mod=mod"},
+		{"a", "-mod=mod -trimpath", "-mod=mod -trimpath -tags=a"},
+	} {
+		if got := GoFlagsEnv(test.tags, test.flags); got != test.want {
+			t.Errorf("GoFlagsEnv(%q, %q) = %q, want %q", test.tags, test.flags, got, test.want)
+		}
+	}
+}
+
+func TestWorkKey(t *testing.T) {
+	req := func(f func(*ScanRequest)) *ScanRequest {
+		r := &ScanRequest{
+			ModuleURLPath: scan.ModuleURLPath{Module: "a.com/m", Version: "v1.0.0"},
+			ScanParams: ScanParams{Binary: "bin", BinaryVersion: "h", Args: "-x", JobID: "j1", ImportedBy: 3,
+				Labels: []string{"a:1", "b:2"}},
+		}
+		if f != nil {
+			f(r)
+		}
+		return r
+	}
+	key := req(nil).WorkKey()
+	// The job, and how the packages are batched, don't change the work.
+	for _, r := range []*ScanRequest{
+		req(func(r *ScanRequest) { r.JobID = "j2" }),
+		req(func(r *ScanRequest) { r.BatchSize = 5 }),
+		req(func(r *ScanRequest) { r.Share = true }),
+		req(func(r *ScanRequest) { r.Labels = []string{"b:2", "a:1"} }),
+	} {
+		if got := r.WorkKey(); got != key {
+			t.Errorf("%+v: got key %q, want %q", r.ScanParams, got, key)
+		}
+	}
+	// What is scanned, how it is analyzed, and what is recorded on the
+	// row, do.
+	for _, r := range []*ScanRequest{
+		req(func(r *ScanRequest) { r.Version = "v1.0.1" }),
+		req(func(r *ScanRequest) { r.BinaryVersion = "h2" }),
+		req(func(r *ScanRequest) { r.Args = "-y" }),
+		req(func(r *ScanRequest) { r.Analyzers = "printf" }),
+		req(func(r *ScanRequest) { r.GoFlags = "-mod=mod" }),
+		req(func(r *ScanRequest) { r.Go = "go1.22.3" }),
+		req(func(r *ScanRequest) { r.Subdir = "go" }),
+		req(func(r *ScanRequest) { r.BuildTags = "integration" }),
+		req(func(r *ScanRequest) { r.SkipInit = true }),
+		req(func(r *ScanRequest) { r.Insecure = true }),
+		req(func(r *ScanRequest) { r.DepSnapshot = true }),
+		req(func(r *ScanRequest) { r.ImportedBy = 10 }),
+		req(func(r *ScanRequest) { r.Labels = []string{"a:1"} }),
+	} {
+		if got := r.WorkKey(); got == key {
+			t.Errorf("%s@%s %+v: got the same key", r.Module, r.Version, r.ScanParams)
+		}
+	}
+	if got := req(func(r *ScanRequest) { r.PrivateCorpus = "u/c" }).WorkKey(); got != "" {
+		t.Errorf("private corpus: got key %q, want none", got)
+	}
+	if got := req(func(r *ScanRequest) { r.Table = "analysis_j1" }).WorkKey(); got != "" {
+		t.Errorf("job table: got key %q, want none", got)
+	}
+}
+
+func TestJobTableName(t *testing.T) {
+	for _, test := range []struct {
+		jobID, want string
+	}{
+		{"jba-231015-120304", "analysis_jba_231015_120304"},
+		{"a.b@c", "analysis_a_b_c"},
+	} {
+		got := JobTableName(test.jobID)
+		if got != test.want {
+			t.Errorf("%q: got %q, want %q", test.jobID, got, test.want)
+		}
+		if !IsJobTable(got) {
+			t.Errorf("IsJobTable(%q) = false, want true", got)
+		}
+	}
+	for _, id := range []string{"analysis", "analysis_", "audit_x", "analysis_a-b", "analysis_a.b"} {
+		if IsJobTable(id) {
+			t.Errorf("IsJobTable(%q) = true, want false", id)
+		}
+	}
+}
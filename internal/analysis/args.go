@@ -0,0 +1,63 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package analysis
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// JoinArgs joins the args of an analysis binary into the single string
+// of the args param, which SplitArgs splits. Args are separated by
+// spaces; those that contain whitespace or begin with a double quote,
+// and empty ones, are quoted as Go strings. So args that need no quoting
+// are joined as they always were.
+func JoinArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		if a == "" || strings.HasPrefix(a, `"`) || strings.IndexFunc(a, unicode.IsSpace) >= 0 {
+			a = strconv.Quote(a)
+		}
+		quoted[i] = a
+	}
+	return strings.Join(quoted, " ")
+}
+
+// SplitArgs splits a string made by JoinArgs into the args of an analysis
+// binary. Args are separated by whitespace, and those that begin with a
+// double quote are unquoted as Go strings.
+func SplitArgs(s string) ([]string, error) {
+	var args []string
+	for {
+		s = strings.TrimLeftFunc(s, unicode.IsSpace)
+		if s == "" {
+			return args, nil
+		}
+		if s[0] == '"' {
+			q, err := strconv.QuotedPrefix(s)
+			if err != nil {
+				return nil, fmt.Errorf("bad quoted arg in %q", s)
+			}
+			a, err := strconv.Unquote(q)
+			if err != nil {
+				return nil, err
+			}
+			s = s[len(q):]
+			if s != "" && !unicode.IsSpace(rune(s[0])) {
+				return nil, fmt.Errorf("quoted arg %s is followed by %q", q, s)
+			}
+			args = append(args, a)
+			continue
+		}
+		end := strings.IndexFunc(s, unicode.IsSpace)
+		if end < 0 {
+			end = len(s)
+		}
+		args = append(args, s[:end])
+		s = s[end:]
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package analysis
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestJoinSplitArgs(t *testing.T) {
+	for _, test := range []struct {
+		args   []string
+		joined string
+	}{
+		{nil, ""},
+		{[]string{"-name", "Fact"}, "-name Fact"},
+		// Args without whitespace are joined as they were before quoting.
+		{[]string{`-re=a\d'b`, `x"y`}, `-re=a\d'b x"y`},
+		{[]string{"-msg", "two words", "tab\there"}, `-msg "two words" "tab\there"`},
+		{[]string{"", `"quoted"`}, `"" "\"quoted\""`},
+	} {
+		got := JoinArgs(test.args)
+		if got != test.joined {
+			t.Errorf("JoinArgs(%q) = %q, want %q", test.args, got, test.joined)
+		}
+		split, err := SplitArgs(got)
+		if err != nil {
+			t.Fatalf("SplitArgs(%q): %v", got, err)
+		}
+		if diff := cmp.Diff(test.args, split); diff != "" {
+			t.Errorf("SplitArgs(%q): mismatch (-want, +got):\n%s", got, diff)
+		}
+	}
+
+	// Extra whitespace between args is ignored, as it was before.
+	got, err := SplitArgs("  -a \t b  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"-a", "b"}, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	for _, s := range []string{`"unterminated`, `"a"b`} {
+		if _, err := SplitArgs(s); err == nil {
+			t.Errorf("SplitArgs(%q): got nil, want error", s)
+		}
+	}
+}
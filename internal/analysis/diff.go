@@ -0,0 +1,152 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package analysis
+
+import (
+	"context"
+	"fmt"
+
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+)
+
+// DiffParams are the parameters of a diff between two versions of an
+// analysis binary.
+type DiffParams struct {
+	Binary string // name of the analysis binary
+	Old    string // hash of the older version of the binary
+	New    string // hash of the newer version of the binary
+}
+
+// A BinaryDiff summarizes how the diagnostics of an analysis binary
+// changed between two of its versions.
+//
+// Only the modules analyzed without error by both versions are compared,
+// using the most recent result of each module version, whatever the
+// arguments and build configuration. Diagnostics are matched by module
+// and fingerprint, as with CompareJobDiagnostics; those without a
+// fingerprint are ignored.
+type BinaryDiff struct {
+	Binary        string
+	OldVersion    string
+	NewVersion    string
+	NumModules    int // number of modules compared
+	NumAdded      int // diagnostics only in the new version
+	NumRemoved    int // diagnostics only in the old version
+	NumPersisting int // diagnostics in both versions
+	// Analyzers breaks the counts down by analyzer and category, most
+	// changed first.
+	Analyzers []*AnalyzerDiff
+}
+
+// An AnalyzerDiff counts the diagnostic changes of one analyzer and
+// category between two versions of a binary.
+type AnalyzerDiff struct {
+	AnalyzerName  string `bigquery:"analyzer_name"`
+	Category      string `bigquery:"category"`
+	NumAdded      int    `bigquery:"num_added"`
+	NumRemoved    int    `bigquery:"num_removed"`
+	NumPersisting int    `bigquery:"num_persisting"`
+}
+
+// ReadBinaryDiff computes the diff of the results of the binary between
+// the versions oldVersion and newVersion.
+func ReadBinaryDiff(ctx context.Context, c bigquery.DB, binary, oldVersion, newVersion string) (_ *BinaryDiff, err error) {
+	defer derrors.Wrap(&err, "ReadBinaryDiff(%q, %q, %q)", binary, oldVersion, newVersion)
+	fullTableName := c.FullTableName(TableName)
+	params := binaryDiffParams(binary, oldVersion, newVersion)
+
+	iter, err := c.Query(ctx, binaryDiffModulesQuery(fullTableName), params...)
+	if err != nil {
+		return nil, err
+	}
+	counts, err := bigquery.All[moduleCount](iter)
+	if err != nil {
+		return nil, err
+	}
+	iter, err = c.Query(ctx, binaryDiffQuery(fullTableName), params...)
+	if err != nil {
+		return nil, err
+	}
+	ads, err := bigquery.All[AnalyzerDiff](iter)
+	if err != nil {
+		return nil, err
+	}
+	d := &BinaryDiff{
+		Binary:     binary,
+		OldVersion: oldVersion,
+		NewVersion: newVersion,
+		Analyzers:  ads,
+	}
+	if len(counts) > 0 {
+		d.NumModules = counts[0].NumModules
+	}
+	if d.Analyzers == nil {
+		d.Analyzers = []*AnalyzerDiff{}
+	}
+	for _, a := range ads {
+		d.NumAdded += a.NumAdded
+		d.NumRemoved += a.NumRemoved
+		d.NumPersisting += a.NumPersisting
+	}
+	return d, nil
+}
+
+// moduleCount is the row of the query that counts the modules compared.
+type moduleCount struct {
+	NumModules int `bigquery:"num_modules"`
+}
+
+// binaryDiffParams returns the parameters of the queries used by ReadBinaryDiff.
+func binaryDiffParams(binary, oldVersion, newVersion string) []bigquery.Param {
+	return []bigquery.Param{
+		{Name: "binary_name", Value: binary},
+		{Name: "old_version", Value: oldVersion},
+		{Name: "new_version", Value: newVersion},
+	}
+}
+
+// binaryDiffPrelude returns the WITH clause shared by the queries used by
+// ReadBinaryDiff.
+func binaryDiffPrelude(fullTableName string) string {
+	versionResults := func(param string) string {
+		return bigquery.PartitionQuery{
+			From:        "`" + fullTableName + "`",
+			PartitionOn: "module_path, version",
+			Where:       "binary_name=@binary_name AND binary_version=@" + param,
+			OrderBy:     "created_at DESC",
+		}.String()
+	}
+	return compareDiagnosticsPrelude(versionResults("old_version"), versionResults("new_version"))
+}
+
+// binaryDiffModulesQuery returns the query that counts the modules compared by ReadBinaryDiff.
+func binaryDiffModulesQuery(fullTableName string) string {
+	return binaryDiffPrelude(fullTableName) + "SELECT COUNT(*) AS num_modules FROM modules"
+}
+
+// binaryDiffQuery returns the query that counts the diagnostic changes
+// for ReadBinaryDiff, by analyzer and category.
+func binaryDiffQuery(fullTableName string) string {
+	const qf = `
+		%s,
+		changes AS (
+			SELECT COALESCE(n.analyzer_name, o.analyzer_name) AS analyzer_name,
+				COALESCE(n.category, o.category) AS category,
+				o.fingerprint IS NULL AS added,
+				n.fingerprint IS NULL AS removed
+			FROM old_diags o FULL OUTER JOIN new_diags n
+			ON o.module_path = n.module_path AND o.fingerprint = n.fingerprint
+		)
+		SELECT analyzer_name, category,
+			COUNTIF(added) AS num_added,
+			COUNTIF(removed) AS num_removed,
+			COUNTIF(NOT added AND NOT removed) AS num_persisting
+		FROM changes
+		GROUP BY analyzer_name, category
+		ORDER BY num_added + num_removed DESC, analyzer_name, category
+	`
+	return fmt.Sprintf(qf, binaryDiffPrelude(fullTableName))
+}
@@ -0,0 +1,69 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package analysis
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+)
+
+func TestReadBinaryDiff(t *testing.T) {
+	db := bigquery.NewFake()
+	db.QueryFunc = func(q string, params []bigquery.Param) ([]any, error) {
+		if strings.Contains(q, "num_modules") {
+			return []any{&moduleCount{NumModules: 3}}, nil
+		}
+		return []any{
+			&AnalyzerDiff{AnalyzerName: "printf", NumAdded: 4, NumRemoved: 1, NumPersisting: 10},
+			&AnalyzerDiff{AnalyzerName: "nilness", NumRemoved: 2, NumPersisting: 5},
+		}, nil
+	}
+	got, err := ReadBinaryDiff(context.Background(), db, "bin", "h1", "h2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &BinaryDiff{
+		Binary:        "bin",
+		OldVersion:    "h1",
+		NewVersion:    "h2",
+		NumModules:    3,
+		NumAdded:      4,
+		NumRemoved:    3,
+		NumPersisting: 15,
+		Analyzers: []*AnalyzerDiff{
+			{AnalyzerName: "printf", NumAdded: 4, NumRemoved: 1, NumPersisting: 10},
+			{AnalyzerName: "nilness", NumRemoved: 2, NumPersisting: 5},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+	if n := len(db.Queries()); n != 2 {
+		t.Errorf("got %d queries, want 2", n)
+	}
+}
+
+func TestBinaryDiffQuery(t *testing.T) {
+	q := strings.Join(strings.Fields(binaryDiffQuery("p.d.analysis")), " ")
+	for _, s := range []string{
+		"WHERE binary_name=@binary_name AND binary_version=@old_version",
+		"WHERE binary_name=@binary_name AND binary_version=@new_version",
+		"SELECT module_path FROM old_results WHERE error = '' INTERSECT DISTINCT SELECT module_path FROM new_results WHERE error = ''",
+		"FROM old_diags o FULL OUTER JOIN new_diags n ON o.module_path = n.module_path AND o.fingerprint = n.fingerprint",
+		"GROUP BY analyzer_name, category",
+	} {
+		if !strings.Contains(q, s) {
+			t.Errorf("query does not contain %q:\n%s", s, q)
+		}
+	}
+	mq := strings.Join(strings.Fields(binaryDiffModulesQuery("p.d.analysis")), " ")
+	if !strings.HasSuffix(mq, ") SELECT COUNT(*) AS num_modules FROM modules") {
+		t.Errorf("modules query: got %s", mq)
+	}
+}
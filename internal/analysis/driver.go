@@ -0,0 +1,116 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package analysis
+
+// The driver protocol is how the worker runs analysis binaries.
+//
+// Version 1 binaries are run in the module's directory as
+//
+//	BINARY -json [-analyzers=A1,A2,...] ARGS... ./...
+//
+// and print a JSONTree on standard output. Binaries built with
+// golang.org/x/tools/go/analysis/singlechecker or multichecker speak
+// version 1.
+//
+// Version 2 binaries are run in the same way, but also describe
+// themselves: run with MetadataFlag as their only argument, they print
+// their Metadata as JSON and exit successfully. The worker reads the
+// metadata before running the binary on a module, and adapts: it rejects
+// analyzers that the binary does not provide, and gives binaries that
+// need the source of the module's dependencies a snapshot of them.
+// A binary that exits unsuccessfully when asked for its metadata is
+// taken to speak version 1.
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Versions of the driver protocol.
+const (
+	ProtocolV1 = 1
+	ProtocolV2 = 2
+
+	// MaxProtocolVersion is the newest version that the worker speaks.
+	MaxProtocolVersion = ProtocolV2
+)
+
+// MetadataFlag is the flag that asks a binary for its Metadata.
+const MetadataFlag = "-metadata"
+
+// OutputJSONTree is the name of the output schema of binaries that
+// print a JSONTree. It is the only schema the worker reads.
+const OutputJSONTree = "jsontree"
+
+// Metadata describes the capabilities of an analysis binary.
+type Metadata struct {
+	// ProtocolVersion is the version of the driver protocol the binary speaks.
+	ProtocolVersion int `json:"protocol_version"`
+	// Analyzers are the names of the analyzers the binary provides.
+	// If empty, the binary does not say, and any may be requested.
+	Analyzers []string `json:"analyzers,omitempty"`
+	// NeedsDeps reports whether the binary loads the source of the
+	// module's dependencies, which then must not change during the scan.
+	NeedsDeps bool `json:"needs_deps,omitempty"`
+	// OutputSchema names the format of the binary's output.
+	// If empty, it is OutputJSONTree.
+	OutputSchema string `json:"output_schema,omitempty"`
+}
+
+// V1Metadata returns the Metadata of a binary that speaks version 1
+// of the protocol.
+func V1Metadata() *Metadata {
+	return &Metadata{ProtocolVersion: ProtocolV1, OutputSchema: OutputJSONTree}
+}
+
+// ParseMetadata parses the output of a binary run with MetadataFlag,
+// and checks that the worker can run the binary.
+func ParseMetadata(data []byte) (*Metadata, error) {
+	var m Metadata
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("parsing binary metadata: %v", err)
+	}
+	if m.ProtocolVersion < ProtocolV2 {
+		return nil, fmt.Errorf("binary metadata has protocol version %d; want at least %d", m.ProtocolVersion, ProtocolV2)
+	}
+	if m.ProtocolVersion > MaxProtocolVersion {
+		return nil, fmt.Errorf("binary speaks protocol version %d; the worker speaks at most %d", m.ProtocolVersion, MaxProtocolVersion)
+	}
+	if m.OutputSchema == "" {
+		m.OutputSchema = OutputJSONTree
+	}
+	if m.OutputSchema != OutputJSONTree {
+		return nil, fmt.Errorf("unsupported output schema %q", m.OutputSchema)
+	}
+	for _, a := range m.Analyzers {
+		if !analyzerNameRegexp.MatchString(a) {
+			return nil, fmt.Errorf("invalid analyzer name %q in binary metadata", a)
+		}
+	}
+	return &m, nil
+}
+
+// CheckAnalyzers returns an error if the binary described by m does not
+// provide all the analyzers in the comma-separated list.
+func (m *Metadata) CheckAnalyzers(list string) error {
+	if len(m.Analyzers) == 0 || list == "" {
+		return nil
+	}
+	provided := map[string]bool{}
+	for _, a := range m.Analyzers {
+		provided[a] = true
+	}
+	var missing []string
+	for _, a := range strings.Split(list, ",") {
+		if !provided[a] {
+			missing = append(missing, a)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("binary does not provide analyzers %s", strings.Join(missing, ","))
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package analysis
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseMetadata(t *testing.T) {
+	got, err := ParseMetadata([]byte(`{"protocol_version": 2, "analyzers": ["a", "b"], "needs_deps": true, "future": 1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Metadata{
+		ProtocolVersion: ProtocolV2,
+		Analyzers:       []string{"a", "b"},
+		NeedsDeps:       true,
+		OutputSchema:    OutputJSONTree,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	for _, in := range []string{
+		`usage: analyzer [flags]`,
+		`{}`,
+		`{"protocol_version": 1}`,
+		`{"protocol_version": 99}`,
+		`{"protocol_version": 2, "output_schema": "sarif"}`,
+		`{"protocol_version": 2, "analyzers": ["bad name"]}`,
+	} {
+		if _, err := ParseMetadata([]byte(in)); err == nil {
+			t.Errorf("%s: got nil error", in)
+		}
+	}
+}
+
+func TestCheckAnalyzers(t *testing.T) {
+	m := &Metadata{ProtocolVersion: ProtocolV2, Analyzers: []string{"a", "b"}}
+	for _, test := range []struct {
+		list string
+		ok   bool
+	}{
+		{"", true},
+		{"a", true},
+		{"a,b", true},
+		{"a,c", false},
+	} {
+		err := m.CheckAnalyzers(test.list)
+		if (err == nil) != test.ok {
+			t.Errorf("%q: got %v, want ok=%t", test.list, err, test.ok)
+		}
+	}
+	// A binary that does not list its analyzers accepts any.
+	if err := V1Metadata().CheckAnalyzers("c"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSetMetadata(t *testing.T) {
+	var r Result
+	if err := r.SetMetadata(V1Metadata()); err != nil {
+		t.Fatal(err)
+	}
+	if r.DriverProtocol.Int64 != ProtocolV1 || r.BinaryMetadata.Valid {
+		t.Errorf("v1: got %v, %v", r.DriverProtocol, r.BinaryMetadata)
+	}
+	if err := r.SetMetadata(&Metadata{ProtocolVersion: ProtocolV2, OutputSchema: OutputJSONTree}); err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"protocol_version":2,"output_schema":"jsontree"}`; r.DriverProtocol.Int64 != ProtocolV2 || r.BinaryMetadata.StringVal != want {
+		t.Errorf("v2: got %v, %v; want metadata %s", r.DriverProtocol, r.BinaryMetadata, want)
+	}
+}
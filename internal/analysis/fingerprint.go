@@ -0,0 +1,169 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package analysis
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+
+	bq "cloud.google.com/go/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+)
+
+// A diagnostic's fingerprint identifies it across scans, so that the
+// diagnostics of two jobs can be correlated even if the module changed
+// between them. It is a hash of the analyzer name, the category, the
+// message with its variable parts removed, and the source around the
+// diagnostic with its whitespace normalized. Positions are not part of
+// it: they change whenever lines are added above the diagnostic.
+
+// SetFingerprints sets the Fingerprint of each diagnostic in ds that
+// is not an error. It should be called after the Source of the
+// diagnostics has been set.
+func SetFingerprints(ds []*Diagnostic) {
+	for _, d := range ds {
+		if d.Error != "" {
+			continue
+		}
+		d.Fingerprint = bq.NullString{StringVal: fingerprint(d), Valid: true}
+	}
+}
+
+// fingerprint returns the fingerprint of d.
+func fingerprint(d *Diagnostic) string {
+	h := sha256.New()
+	for _, s := range []string{d.AnalyzerName, d.Category, messageTemplate(d.Message), normalizeSource(d.Source.StringVal)} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// variableRegexp matches the parts of a diagnostic message that
+// typically vary between occurrences of the same finding: quoted
+// strings and numbers.
+var variableRegexp = regexp.MustCompile("\"(?:[^\"\\\\]|\\\\.)*\"|`[^`]*`|'(?:[^'\\\\]|\\\\.)*'|\\b\\d+(?:\\.\\d+)?\\b")
+
+// messageTemplate returns msg with its quoted strings replaced by "_"
+// and its numbers replaced by "N".
+func messageTemplate(msg string) string {
+	return variableRegexp.ReplaceAllStringFunc(msg, func(s string) string {
+		switch s[0] {
+		case '"', '`', '\'':
+			return "_"
+		default:
+			return "N"
+		}
+	})
+}
+
+// normalizeSource returns src with blank lines removed and the space
+// within each line collapsed, so that reformatting does not change it.
+func normalizeSource(src string) string {
+	var lines []string
+	for _, line := range strings.Split(src, "\n") {
+		if fs := strings.Fields(line); len(fs) > 0 {
+			lines = append(lines, strings.Join(fs, " "))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Statuses of a DiagnosticChange.
+const (
+	DiagnosticNew        = "new"        // only in the newer job
+	DiagnosticFixed      = "fixed"      // only in the older job
+	DiagnosticPersisting = "persisting" // in both jobs
+)
+
+// A DiagnosticChange describes a diagnostic, identified by its module
+// and fingerprint, in the results of two jobs.
+type DiagnosticChange struct {
+	ModulePath   string `bigquery:"module_path"`
+	Fingerprint  string `bigquery:"fingerprint"`
+	AnalyzerName string `bigquery:"analyzer_name"`
+	Category     string `bigquery:"category"`
+	Message      string `bigquery:"message"` // from the newer job, if present
+	Status       string `bigquery:"status"`
+}
+
+// CompareJobDiagnostics reports which diagnostics with a fingerprint are
+// new, fixed or persisting between the job oldJobID and the later job
+// newJobID, typically of the same binary. Only the modules analyzed
+// without error by both jobs are compared, whatever their versions.
+func CompareJobDiagnostics(ctx context.Context, c bigquery.DB, oldJobID, newJobID string) (_ []*DiagnosticChange, err error) {
+	defer derrors.Wrap(&err, "CompareJobDiagnostics(%q, %q)", oldJobID, newJobID)
+	q, params := compareJobsQuery(c.FullTableName(TableName), oldJobID, newJobID)
+	iter, err := c.Query(ctx, q, params...)
+	if err != nil {
+		return nil, err
+	}
+	return bigquery.All[DiagnosticChange](iter)
+}
+
+// compareJobsQuery returns the query and parameters used by CompareJobDiagnostics.
+func compareJobsQuery(fullTableName, oldJobID, newJobID string) (string, []bigquery.Param) {
+	jobResults := func(param string) string {
+		return bigquery.PartitionQuery{
+			From:        "`" + fullTableName + "`",
+			PartitionOn: "module_path, version",
+			Where:       "job_id=@" + param,
+			OrderBy:     "created_at DESC",
+		}.String()
+	}
+	const qf = `
+		%s
+		SELECT COALESCE(n.module_path, o.module_path) AS module_path,
+			COALESCE(n.fingerprint, o.fingerprint) AS fingerprint,
+			COALESCE(n.analyzer_name, o.analyzer_name) AS analyzer_name,
+			COALESCE(n.category, o.category) AS category,
+			COALESCE(n.message, o.message) AS message,
+			CASE WHEN o.fingerprint IS NULL THEN '%s' WHEN n.fingerprint IS NULL THEN '%s' ELSE '%s' END AS status
+		FROM old_diags o FULL OUTER JOIN new_diags n
+		ON o.module_path = n.module_path AND o.fingerprint = n.fingerprint
+		ORDER BY status, module_path, analyzer_name, message
+	`
+	q := fmt.Sprintf(qf, compareDiagnosticsPrelude(jobResults("old_job_id"), jobResults("new_job_id")),
+		DiagnosticNew, DiagnosticFixed, DiagnosticPersisting)
+	return q, []bigquery.Param{
+		{Name: "old_job_id", Value: oldJobID},
+		{Name: "new_job_id", Value: newJobID},
+	}
+}
+
+// compareDiagnosticsPrelude returns the WITH clause of the queries that
+// compare two sets of results, selected by the queries oldResults and
+// newResults. It defines modules, the modules analyzed without error in
+// both, and old_diags and new_diags, the diagnostics with a fingerprint of
+// those modules in each set, one row per module and fingerprint.
+func compareDiagnosticsPrelude(oldResults, newResults string) string {
+	const diagsf = `
+		SELECT r.module_path, d.fingerprint,
+			ANY_VALUE(d.analyzer_name) AS analyzer_name,
+			ANY_VALUE(d.category) AS category,
+			ANY_VALUE(d.message) AS message
+		FROM %s r, UNNEST(r.diagnostic) d
+		WHERE d.fingerprint IS NOT NULL AND r.module_path IN (SELECT module_path FROM modules)
+		GROUP BY r.module_path, d.fingerprint
+	`
+	const qf = `
+		WITH old_results AS (%s),
+		new_results AS (%s),
+		modules AS (
+			SELECT module_path FROM old_results WHERE error = ''
+			INTERSECT DISTINCT
+			SELECT module_path FROM new_results WHERE error = ''
+		),
+		old_diags AS (%s),
+		new_diags AS (%s)
+	`
+	return fmt.Sprintf(qf, oldResults, newResults,
+		fmt.Sprintf(diagsf, "old_results"), fmt.Sprintf(diagsf, "new_results"))
+}
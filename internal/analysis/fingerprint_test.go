@@ -0,0 +1,91 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package analysis
+
+import (
+	"strings"
+	"testing"
+
+	bq "cloud.google.com/go/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+)
+
+func TestMessageTemplate(t *testing.T) {
+	for _, test := range []struct {
+		in, want string
+	}{
+		{"call of G(...)", "call of G(...)"},
+		{`unused variable "x"`, "unused variable _"},
+		{`fmt.Printf format %d has arg "s" of wrong type string`, "fmt.Printf format %d has arg _ of wrong type string"},
+		{"loop variable i captured at line 12", "loop variable i captured at line N"},
+		{"x1 and 'a' and `b` and 3.5", "x1 and _ and _ and N"},
+		{`escaped "a \"quoted\" string"`, "escaped _"},
+	} {
+		if got := messageTemplate(test.in); got != test.want {
+			t.Errorf("messageTemplate(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestSetFingerprints(t *testing.T) {
+	diag := func(analyzer, msg, src string) *Diagnostic {
+		return &Diagnostic{
+			AnalyzerName: analyzer,
+			Position:     "a.go:1:1",
+			Message:      msg,
+			Source:       bq.NullString{StringVal: src, Valid: true},
+		}
+	}
+	ds := []*Diagnostic{
+		diag("findcall", "call of G at line 3", "func F() {\n\tG()\n}"),
+		// Same finding, moved and reformatted.
+		diag("findcall", "call of G at line 7", "\nfunc F()  {\n    G()\n}\n"),
+		// Different analyzer.
+		diag("other", "call of G at line 3", "func F() {\n\tG()\n}"),
+		// Different source.
+		diag("findcall", "call of G at line 3", "func H() {\n\tG()\n}"),
+		{AnalyzerName: "findcall", Error: "failed"},
+	}
+	ds[1].Position = "a.go:5:1"
+	SetFingerprints(ds)
+
+	for i, d := range ds[:4] {
+		if !d.Fingerprint.Valid || len(d.Fingerprint.StringVal) != 32 {
+			t.Fatalf("diagnostic %d: bad fingerprint %v", i, d.Fingerprint)
+		}
+	}
+	if ds[0].Fingerprint != ds[1].Fingerprint {
+		t.Errorf("fingerprints of the same finding differ: %s, %s", ds[0].Fingerprint.StringVal, ds[1].Fingerprint.StringVal)
+	}
+	for _, i := range []int{2, 3} {
+		if ds[0].Fingerprint == ds[i].Fingerprint {
+			t.Errorf("diagnostic %d has the fingerprint of diagnostic 0", i)
+		}
+	}
+	if ds[4].Fingerprint.Valid {
+		t.Errorf("error diagnostic has fingerprint %s", ds[4].Fingerprint.StringVal)
+	}
+}
+
+func TestCompareJobsQuery(t *testing.T) {
+	q, params := compareJobsQuery("p.d.analysis", "old'", "new")
+	if strings.Contains(q, "old'") {
+		t.Errorf("job ID formatted into query: %s", q)
+	}
+	want := []bigquery.Param{{Name: "old_job_id", Value: "old'"}, {Name: "new_job_id", Value: "new"}}
+	if len(params) != 2 || params[0] != want[0] || params[1] != want[1] {
+		t.Errorf("got params %v, want %v", params, want)
+	}
+	for _, s := range []string{
+		"WHERE job_id=@old_job_id",
+		"WHERE job_id=@new_job_id",
+		"FROM old_diags o FULL OUTER JOIN new_diags n ON o.module_path = n.module_path AND o.fingerprint = n.fingerprint",
+		"CASE WHEN o.fingerprint IS NULL THEN 'new' WHEN n.fingerprint IS NULL THEN 'fixed' ELSE 'persisting' END AS status",
+	} {
+		if !strings.Contains(strings.Join(strings.Fields(q), " "), s) {
+			t.Errorf("query does not contain %q:\n%s", s, q)
+		}
+	}
+}
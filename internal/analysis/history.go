@@ -0,0 +1,62 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package analysis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+)
+
+// A HistoryEntry summarizes an analysis result for a module, for
+// following the module's results over time.
+type HistoryEntry struct {
+	CreatedAt      time.Time     `bigquery:"created_at"`
+	Version        string        `bigquery:"version"`
+	BinaryName     string        `bigquery:"binary_name"`
+	JobID          bq.NullString `bigquery:"job_id"`
+	WorkVersion                  // InferSchema flattens embedded fields
+	Error          string        `bigquery:"error"`
+	ErrorCategory  string        `bigquery:"error_category"`
+	NumDiagnostics int           `bigquery:"num_diagnostics"`
+}
+
+// ReadHistory reads the results for modulePath created since the given
+// time, most recent first, at most limit of them. Of the results for the
+// same version, binary and work version, only the most recent is read,
+// so retried scans appear once.
+func ReadHistory(ctx context.Context, c bigquery.DB, modulePath string, since time.Time, limit int) (_ []*HistoryEntry, err error) {
+	defer derrors.Wrap(&err, "analysis.ReadHistory(%q)", modulePath)
+	q, params := historyQuery(c.FullTableName(TableName), modulePath, since, limit)
+	iter, err := c.Query(ctx, q, params...)
+	if err != nil {
+		return nil, err
+	}
+	return bigquery.All[HistoryEntry](iter)
+}
+
+// historyQuery returns the query and parameters used by ReadHistory.
+func historyQuery(fullTableName, modulePath string, since time.Time, limit int) (string, []bigquery.Param) {
+	pq := bigquery.PartitionQuery{
+		From: "`" + fullTableName + "`",
+		Columns: "created_at, version, binary_name, job_id, " +
+			"binary_version, binary_args, analyzers, build_tags, goflags, go_version, dep_snapshot, worker_version, schema_version, " +
+			"error, error_category, ARRAY_LENGTH(diagnostic) AS num_diagnostics",
+		PartitionOn: "version, binary_name, " +
+			"binary_version, binary_args, analyzers, build_tags, goflags, go_version, dep_snapshot, worker_version, schema_version",
+		Where:   "module_path=@module_path AND created_at >= @since",
+		OrderBy: "created_at DESC",
+	}
+	q := fmt.Sprintf("SELECT * FROM (%s) ORDER BY created_at DESC LIMIT @limit", pq.String())
+	return q, []bigquery.Param{
+		{Name: "module_path", Value: modulePath},
+		{Name: "since", Value: since},
+		{Name: "limit", Value: limit},
+	}
+}
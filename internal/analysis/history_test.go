@@ -0,0 +1,64 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package analysis
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+)
+
+func TestHistoryQuery(t *testing.T) {
+	since := time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC)
+	q, params := historyQuery("p.d.analysis", "example.com/m'", since, 10)
+	got := strings.Join(strings.Fields(q), " ")
+	want := "SELECT * FROM ( SELECT * EXCEPT (rownum) FROM ( SELECT created_at, version, binary_name, job_id, " +
+		"binary_version, binary_args, analyzers, build_tags, goflags, go_version, dep_snapshot, worker_version, schema_version, " +
+		"error, error_category, ARRAY_LENGTH(diagnostic) AS num_diagnostics, ROW_NUMBER() OVER ( " +
+		"PARTITION BY version, binary_name, binary_version, binary_args, analyzers, build_tags, goflags, go_version, dep_snapshot, worker_version, schema_version " +
+		"ORDER BY created_at DESC ) AS rownum FROM `p.d.analysis` WHERE module_path=@module_path AND created_at >= @since ) " +
+		"WHERE rownum = 1 ) ORDER BY created_at DESC LIMIT @limit"
+	if got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+	wantParams := []bigquery.Param{
+		{Name: "module_path", Value: "example.com/m'"},
+		{Name: "since", Value: since},
+		{Name: "limit", Value: 10},
+	}
+	if diff := cmp.Diff(wantParams, params); diff != "" {
+		t.Errorf("params mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestReadHistorySnapshots(t *testing.T) {
+	// Scans of the same work with and without a snapshot of the
+	// dependencies are different entries.
+	want := []*HistoryEntry{
+		{Version: "v1.1.0", BinaryName: "bin", NumDiagnostics: 1,
+			WorkVersion: WorkVersion{BinaryVersion: "h", DepSnapshot: bq.NullBool{Bool: true, Valid: true}}},
+		{Version: "v1.1.0", BinaryName: "bin", NumDiagnostics: 2,
+			WorkVersion: WorkVersion{BinaryVersion: "h"}},
+	}
+	fake := bigquery.NewFake()
+	fake.QueryFunc = func(q string, params []bigquery.Param) ([]any, error) {
+		if !strings.Contains(q, "PARTITION BY version, binary_name, binary_version, binary_args, analyzers, build_tags, goflags, go_version, dep_snapshot,") {
+			return []any{want[0]}, nil
+		}
+		return []any{want[0], want[1]}, nil
+	}
+	got, err := ReadHistory(context.Background(), fake, "example.com/m", time.Time{}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package analysis
+
+import (
+	"strconv"
+	"strings"
+
+	"golang.org/x/pkgsite-metrics/internal/sarif"
+)
+
+// SARIFLog returns results as a SARIF log, with a run for each analysis
+// binary. Each analyzer is a rule of its binary's run, and each diagnostic
+// that is not an error is a result. Results whose module could not be
+// analyzed are reported as failed invocations.
+func SARIFLog(results []*Result) *sarif.Log {
+	var runs []*sarif.Run
+	byBinary := map[string]*sarif.Run{}
+	for _, r := range results {
+		run := byBinary[r.BinaryName]
+		if run == nil {
+			run = sarif.NewRun(sarif.Driver{Name: r.BinaryName, Version: r.BinaryVersion})
+			byBinary[r.BinaryName] = run
+			runs = append(runs, run)
+		}
+		if r.Error != "" {
+			run.Invocations = append(run.Invocations, &sarif.Invocation{
+				ToolExecutionNotifications: []*sarif.Notification{{
+					Level:   sarif.LevelError,
+					Message: sarif.Message{Text: r.ModulePath + "@" + r.Version + ": " + r.Error},
+				}},
+			})
+		}
+		for _, d := range r.Diagnostics {
+			if d.Error != "" {
+				continue
+			}
+			run.AddRule(&sarif.Rule{ID: d.AnalyzerName, HelpURI: d.DocURL.StringVal})
+			run.Results = append(run.Results, sarifResult(r, d))
+		}
+	}
+	return sarif.NewLog(runs...)
+}
+
+// sarifResult converts a diagnostic of r to a SARIF result.
+func sarifResult(r *Result, d *Diagnostic) *sarif.Result {
+	loc := &sarif.Location{
+		LogicalLocations: []*sarif.LogicalLocation{{FullyQualifiedName: d.PackageID, Kind: "package"}},
+	}
+	if d.PackageID == "" {
+		loc.LogicalLocations[0] = &sarif.LogicalLocation{FullyQualifiedName: r.ModulePath, Kind: "module"}
+	}
+	loc.PhysicalLocation = physicalLocation(d.Position, r.ModulePath+"@"+r.Version)
+	res := &sarif.Result{
+		RuleID:    d.AnalyzerName,
+		Level:     sarifLevel(d.Severity.StringVal),
+		Message:   sarif.Message{Text: d.Message},
+		Locations: []*sarif.Location{loc},
+	}
+	if d.Fingerprint.Valid {
+		res.PartialFingerprints = map[string]string{"diagnostic/v1": d.Fingerprint.StringVal}
+	}
+	return res
+}
+
+// sarifLevel returns the SARIF level of a diagnostic severity.
+// Diagnostics without a severity are warnings.
+func sarifLevel(severity string) string {
+	switch severity {
+	case SeverityError:
+		return sarif.LevelError
+	case SeverityInfo, SeverityHint:
+		return sarif.LevelNote
+	default:
+		return sarif.LevelWarning
+	}
+}
+
+// modViewerPrefix is the prefix of the source URLs that the worker
+// stores as the positions of diagnostics.
+const modViewerPrefix = "https://go-mod-viewer.appspot.com/"
+
+// physicalLocation returns the SARIF location of a diagnostic position,
+// which is either a go-mod-viewer URL or a position of the form
+// file:line:col. Files in the module version mv, of the form
+// "path@version", are made relative to sarif.SrcRoot. It returns nil if
+// pos is empty or can't be parsed.
+func physicalLocation(pos, mv string) *sarif.PhysicalLocation {
+	var file string
+	var line, col int
+	if rest, ok := strings.CutPrefix(pos, modViewerPrefix); ok {
+		f, l, ok := strings.Cut(rest, "#L")
+		if !ok {
+			return nil
+		}
+		n, err := strconv.Atoi(l)
+		if err != nil {
+			return nil
+		}
+		file, line = f, n
+	} else {
+		i := strings.LastIndexByte(pos, ':')
+		j := strings.LastIndexByte(pos[:max(i, 0)], ':')
+		if j < 0 {
+			return nil
+		}
+		var err1, err2 error
+		line, err1 = strconv.Atoi(pos[j+1 : i])
+		col, err2 = strconv.Atoi(pos[i+1:])
+		if err1 != nil || err2 != nil {
+			return nil
+		}
+		file = pos[:j]
+	}
+	al := sarif.ArtifactLocation{URI: file}
+	if _, rel, ok := strings.Cut(file, mv+"/"); ok {
+		al = sarif.ArtifactLocation{URI: rel, URIBaseID: sarif.SrcRoot}
+	}
+	return &sarif.PhysicalLocation{
+		ArtifactLocation: al,
+		Region:           &sarif.Region{StartLine: line, StartColumn: col},
+	}
+}
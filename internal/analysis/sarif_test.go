@@ -0,0 +1,94 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package analysis
+
+import (
+	"testing"
+
+	bq "cloud.google.com/go/bigquery"
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/pkgsite-metrics/internal/sarif"
+)
+
+func TestSARIFLog(t *testing.T) {
+	results := []*Result{
+		{
+			ModulePath:  "example.com/m",
+			Version:     "v1.0.0",
+			BinaryName:  "b",
+			WorkVersion: WorkVersion{BinaryVersion: "h1"},
+			Diagnostics: []*Diagnostic{
+				{
+					PackageID:    "example.com/m/p",
+					AnalyzerName: "a1",
+					Position:     "https://go-mod-viewer.appspot.com/example.com/m@v1.0.0/p/p.go#L12",
+					Message:      "m1",
+					Severity:     bq.NullString{StringVal: SeverityInfo, Valid: true},
+					DocURL:       bq.NullString{StringVal: "https://example.com/a1", Valid: true},
+					Fingerprint:  bq.NullString{StringVal: "f1", Valid: true},
+				},
+				{
+					AnalyzerName: "a2",
+					Position:     "/elsewhere/x.go:3:4",
+					Message:      "m2",
+				},
+				{PackageID: "example.com/m/q", Error: "type errors"},
+			},
+		},
+		{
+			ModulePath: "example.com/n",
+			Version:    "v0.1.0",
+			BinaryName: "b",
+			Error:      "load failed",
+		},
+	}
+	got := SARIFLog(results)
+	want := sarif.NewLog(&sarif.Run{
+		Tool: sarif.Tool{Driver: sarif.Driver{
+			Name:    "b",
+			Version: "h1",
+			Rules: []*sarif.Rule{
+				{ID: "a1", HelpURI: "https://example.com/a1"},
+				{ID: "a2"},
+			},
+		}},
+		Invocations: []*sarif.Invocation{{
+			ToolExecutionNotifications: []*sarif.Notification{{
+				Level:   sarif.LevelError,
+				Message: sarif.Message{Text: "example.com/n@v0.1.0: load failed"},
+			}},
+		}},
+		Results: []*sarif.Result{
+			{
+				RuleID:  "a1",
+				Level:   sarif.LevelNote,
+				Message: sarif.Message{Text: "m1"},
+				Locations: []*sarif.Location{{
+					PhysicalLocation: &sarif.PhysicalLocation{
+						ArtifactLocation: sarif.ArtifactLocation{URI: "p/p.go", URIBaseID: sarif.SrcRoot},
+						Region:           &sarif.Region{StartLine: 12},
+					},
+					LogicalLocations: []*sarif.LogicalLocation{{FullyQualifiedName: "example.com/m/p", Kind: "package"}},
+				}},
+				PartialFingerprints: map[string]string{"diagnostic/v1": "f1"},
+			},
+			{
+				RuleID:  "a2",
+				Level:   sarif.LevelWarning,
+				Message: sarif.Message{Text: "m2"},
+				Locations: []*sarif.Location{{
+					PhysicalLocation: &sarif.PhysicalLocation{
+						ArtifactLocation: sarif.ArtifactLocation{URI: "/elsewhere/x.go"},
+						Region:           &sarif.Region{StartLine: 3, StartColumn: 4},
+					},
+					LogicalLocations: []*sarif.LogicalLocation{{FullyQualifiedName: "example.com/m", Kind: "module"}},
+				}},
+			},
+		},
+	})
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package analysis
+
+import (
+	"net/url"
+	"strings"
+)
+
+// The severities of diagnostics, from most to least severe.
+// They are those of LSP diagnostics.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+	SeverityInfo    = "info"
+	SeverityHint    = "hint"
+)
+
+var severities = []string{SeverityError, SeverityWarning, SeverityInfo, SeverityHint}
+
+// SeverityRank returns the position of severity in order from most to least
+// severe. Unknown and empty severities come last.
+func SeverityRank(severity string) int {
+	for i, s := range severities {
+		if s == severity {
+			return i
+		}
+	}
+	return len(severities)
+}
+
+// diagnosticSeverity returns the severity of jd: its severity field if set,
+// or else the prefix of a category of the form "SEVERITY:rest", like
+// "warning:deprecated". It returns the empty string if jd has no known
+// severity.
+func diagnosticSeverity(jd JSONDiagnostic) string {
+	if jd.Severity != "" {
+		return knownSeverity(jd.Severity)
+	}
+	if prefix, _, ok := strings.Cut(jd.Category, ":"); ok {
+		return knownSeverity(prefix)
+	}
+	return ""
+}
+
+// knownSeverity returns s as one of the Severity constants, or the empty
+// string if it isn't one.
+func knownSeverity(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if SeverityRank(s) < len(severities) {
+		return s
+	}
+	return ""
+}
+
+// diagnosticURL returns the documentation URL of jd: its url field if set,
+// or else its category if that is an absolute http(s) URL. Analyzers may
+// also use a category as a fragment of their own documentation URL, but
+// that URL isn't known here.
+func diagnosticURL(jd JSONDiagnostic) string {
+	if jd.URL != "" {
+		return jd.URL
+	}
+	u, err := url.Parse(jd.Category)
+	if err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != "" {
+		return jd.Category
+	}
+	return ""
+}
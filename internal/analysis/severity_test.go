@@ -0,0 +1,57 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package analysis
+
+import "testing"
+
+func TestDiagnosticSeverity(t *testing.T) {
+	for _, test := range []struct {
+		jd   JSONDiagnostic
+		want string
+	}{
+		{JSONDiagnostic{}, ""},
+		{JSONDiagnostic{Severity: "warning"}, SeverityWarning},
+		{JSONDiagnostic{Severity: " Error "}, SeverityError},
+		{JSONDiagnostic{Severity: "fatal"}, ""},
+		{JSONDiagnostic{Category: "hint:simplify"}, SeverityHint},
+		{JSONDiagnostic{Category: "info:style", Severity: "error"}, SeverityError},
+		{JSONDiagnostic{Category: "deprecated"}, ""},
+		{JSONDiagnostic{Category: "https://example.com/doc"}, ""},
+	} {
+		if got := diagnosticSeverity(test.jd); got != test.want {
+			t.Errorf("%+v: got %q, want %q", test.jd, got, test.want)
+		}
+	}
+}
+
+func TestDiagnosticURL(t *testing.T) {
+	for _, test := range []struct {
+		jd   JSONDiagnostic
+		want string
+	}{
+		{JSONDiagnostic{}, ""},
+		{JSONDiagnostic{URL: "https://pkg.go.dev/x#a"}, "https://pkg.go.dev/x#a"},
+		{JSONDiagnostic{Category: "https://example.com/doc"}, "https://example.com/doc"},
+		{JSONDiagnostic{Category: "https://example.com/doc", URL: "https://other"}, "https://other"},
+		{JSONDiagnostic{Category: "warning:deprecated"}, ""},
+		{JSONDiagnostic{Category: "unusedresult"}, ""},
+	} {
+		if got := diagnosticURL(test.jd); got != test.want {
+			t.Errorf("%+v: got %q, want %q", test.jd, got, test.want)
+		}
+	}
+}
+
+func TestSeverityRank(t *testing.T) {
+	if !(SeverityRank(SeverityError) < SeverityRank(SeverityWarning) &&
+		SeverityRank(SeverityWarning) < SeverityRank(SeverityInfo) &&
+		SeverityRank(SeverityInfo) < SeverityRank(SeverityHint) &&
+		SeverityRank(SeverityHint) < SeverityRank("")) {
+		t.Error("severities are out of order")
+	}
+	if SeverityRank("") != SeverityRank("unknown") {
+		t.Error("empty and unknown severities should rank the same")
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package analysis
+
+import (
+	"fmt"
+
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+)
+
+const (
+	// LatestViewName is the name of the view holding the most recent
+	// result for each module version and binary.
+	LatestViewName = "analysis-latest"
+	// ErrorCountsViewName is the name of the view counting the most
+	// recent results of each binary that are errors, by category.
+	ErrorCountsViewName = "analysis-error-counts"
+)
+
+func init() {
+	bigquery.AddView(LatestViewName, latestViewQuery)
+	bigquery.AddView(ErrorCountsViewName, errorCountsViewQuery)
+}
+
+// latestViewQuery returns the query defining the latest view.
+func latestViewQuery(fullTableName func(string) string) string {
+	return bigquery.PartitionQuery{
+		From:        "`" + fullTableName(TableName) + "`",
+		PartitionOn: "module_path, version, binary_name",
+		OrderBy:     "created_at DESC",
+	}.String()
+}
+
+// errorCountsViewQuery returns the query defining the error counts view.
+// It repeats the latest view's query instead of selecting from the view,
+// because views are created in name order and this one sorts first.
+func errorCountsViewQuery(fullTableName func(string) string) string {
+	const qf = `
+		SELECT binary_name, error_category, COUNT(*) AS num_results
+		FROM (%s)
+		WHERE error != ''
+		GROUP BY binary_name, error_category
+	`
+	return fmt.Sprintf(qf, latestViewQuery(fullTableName))
+}
@@ -0,0 +1,86 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package audit records the administrative actions taken on the worker,
+// such as enqueuing and canceling jobs, in a BigQuery table.
+package audit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+)
+
+// TableName is the BigQuery table of administrative actions.
+const TableName = "audit"
+
+// The kinds of actions.
+const (
+	Enqueue     = "enqueue"      // modules enqueued for scanning
+	Cancel      = "cancel"       // a job canceled
+	SkipModules = "skip-modules" // the skip list of the dynamic configuration changed
+	MergeTable  = "merge-table"  // the rows of a job's own table merged into the shared table
+	DropTable   = "drop-table"   // a job's own table dropped
+	WorkStates  = "work-states"  // govulncheck work states deleted
+)
+
+// Note: before modifying Action, make sure the change
+// is a valid schema modification.
+// The only supported changes are:
+//   - adding a nullable or repeated column
+//   - dropping a column
+//   - changing a column from required to nullable.
+// See https://cloud.google.com/bigquery/docs/managing-table-schemas for details.
+// TestSchemaChanges in internal/bigquery enforces this.
+
+// Action is a row in the BigQuery audit table. It records one
+// administrative action: what was done, by whom, when and with what
+// parameters.
+type Action struct {
+	CreatedAt time.Time `bigquery:"created_at" json:"created_at"`
+	Action    string    `bigquery:"action" json:"action"`
+	// User is the authenticated account that made the request, or empty
+	// if unknown.
+	User string `bigquery:"user" json:"user"`
+	// RequestedFor is the user named by the request's "user" parameter,
+	// if any. It is not authenticated.
+	RequestedFor bq.NullString `bigquery:"requested_for" json:"requested_for"`
+	// URL is the path and query of the request.
+	URL   string        `bigquery:"url" json:"url"`
+	JobID bq.NullString `bigquery:"job_id" json:"job_id"`
+	// Details describes the effect of the action, for example the
+	// number of tasks enqueued.
+	Details bq.NullString `bigquery:"details" json:"details"`
+}
+
+func (a *Action) SetUploadTime(t time.Time) { a.CreatedAt = t }
+
+func init() {
+	s, err := bigquery.InferSchema(Action{})
+	if err != nil {
+		panic(err)
+	}
+	bigquery.AddTable(TableName, s)
+}
+
+// ReadRecent returns the most recent limit actions, newest first.
+func ReadRecent(ctx context.Context, c bigquery.DB, limit int) (_ []*Action, err error) {
+	defer derrors.Wrap(&err, "audit.ReadRecent")
+	q, params := recentQuery(c.FullTableName(TableName), limit)
+	iter, err := c.Query(ctx, q, params...)
+	if err != nil {
+		return nil, err
+	}
+	return bigquery.All[Action](iter)
+}
+
+// recentQuery returns the query and parameters used by ReadRecent.
+func recentQuery(fullTableName string, limit int) (string, []bigquery.Param) {
+	q := fmt.Sprintf("SELECT * FROM `%s` ORDER BY created_at DESC LIMIT @limit", fullTableName)
+	return q, []bigquery.Param{{Name: "limit", Value: limit}}
+}
@@ -0,0 +1,48 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package audit
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+)
+
+func TestReadRecent(t *testing.T) {
+	ctx := context.Background()
+	want := []*Action{
+		{Action: Cancel, User: "u", URL: "/jobs/cancel?jobid=j", JobID: bigquery.NullString("j")},
+		{Action: Enqueue, User: "u", URL: "/analysis/enqueue?user=u"},
+	}
+	db := bigquery.NewFake()
+	var gotLimit any
+	db.QueryFunc = func(q string, params []bigquery.Param) ([]any, error) {
+		gotLimit = params[0].Value
+		var rows []any
+		for _, a := range want {
+			rows = append(rows, a)
+		}
+		return rows, nil
+	}
+	got, err := ReadRecent(ctx, db, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+	if gotLimit != 2 {
+		t.Errorf("limit param: got %v, want 2", gotLimit)
+	}
+	q := db.Queries()[0]
+	for _, s := range []string{"`fake-project.fake-dataset.audit`", "ORDER BY created_at DESC", "LIMIT @limit"} {
+		if !strings.Contains(q, s) {
+			t.Errorf("query %q does not contain %q", q, s)
+		}
+	}
+}
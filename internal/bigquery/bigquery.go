@@ -12,23 +12,82 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"regexp"
 	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	bq "cloud.google.com/go/bigquery"
+	"cloud.google.com/go/bigquery/storage/managedwriter"
 	"cloud.google.com/go/civil"
 	"golang.org/x/pkgsite-metrics/internal/derrors"
 	"google.golang.org/api/googleapi"
 	"google.golang.org/api/iterator"
 )
 
+// DB is the interface to BigQuery used by the rest of the program.
+// It is implemented by Client, which talks to BigQuery, and by Fake,
+// which holds tables in memory for tests.
+type DB interface {
+	// FullTableName returns the fully-qualified name of the table,
+	// suitable for use in queries.
+	FullTableName(tableID string) string
+	// CreateOrUpdateTable creates a table if it does not exist, or updates
+	// it if it does. It returns true if it created the table.
+	CreateOrUpdateTable(ctx context.Context, tableID string) (bool, error)
+	// CreateOrUpdateView creates a view if it does not exist, or updates
+	// it if its definition changed. It returns true if it created the view.
+	CreateOrUpdateView(ctx context.Context, viewID string) (bool, error)
+	// Upload inserts a row into the table.
+	Upload(ctx context.Context, tableID string, row Row) error
+	// Query runs the query q with the given named parameters and returns
+	// an iterator over its results.
+	Query(ctx context.Context, q string, params ...Param) (RowIterator, error)
+	// CountRowsBefore returns the number of rows of the table that were
+	// uploaded before cutoff.
+	CountRowsBefore(ctx context.Context, tableID string, cutoff time.Time) (int64, error)
+	// DeleteRowsBefore deletes the rows of the table that were uploaded
+	// before cutoff, and returns how many it deleted.
+	DeleteRowsBefore(ctx context.Context, tableID string, cutoff time.Time) (int64, error)
+	// DeleteTable deletes the table. It is not an error if the table
+	// does not exist.
+	DeleteTable(ctx context.Context, tableID string) error
+	// ReplaceRows deletes the rows of the table dstTableID whose column
+	// has the value key, then inserts all the rows of the table srcTableID
+	// into it, and returns how many it inserted. The rows of srcTableID
+	// should all have that value, so that repeating ReplaceRows does not
+	// duplicate them. The columns of the schema registered for srcTableID
+	// must all be in dstTableID.
+	ReplaceRows(ctx context.Context, srcTableID, dstTableID, column, key string) (int64, error)
+	Close() error
+
+	// write writes rows to the table atomically. See UploadMany.
+	write(ctx context.Context, tableID string, rows []Row, chunkSize int) error
+}
+
+// A RowIterator iterates over the results of a query. Next loads the next
+// row into dst, which should be a struct pointer. It returns iterator.Done
+// when there are no more rows.
+type RowIterator interface {
+	Next(dst any) error
+}
+
+var (
+	_ DB          = (*Client)(nil)
+	_ RowIterator = (*bq.RowIterator)(nil)
+)
+
 // Client is a client for connecting to BigQuery.
 type Client struct {
 	client               *bq.Client
 	dataset              *bq.Dataset
 	deleteDatasetOnClose bool
+
+	// Storage Write API client, created on first upload.
+	writeOnce sync.Once
+	writer    *managedwriter.Client
+	writeErr  error
 }
 
 // NewClientCreate creates a new client for connecting to BigQuery, referring
@@ -40,6 +99,12 @@ func NewClientCreate(ctx context.Context, projectID, datasetID string) (_ *Clien
 	return newClient(ctx, projectID, datasetID)
 }
 
+// NewClient creates a new client for connecting to BigQuery, referring
+// to a single existing dataset.
+func NewClient(ctx context.Context, projectID, datasetID string) (*Client, error) {
+	return newClient(ctx, projectID, datasetID)
+}
+
 func newClient(ctx context.Context, projectID, datasetID string) (_ *Client, err error) {
 	defer derrors.Wrap(&err, "New(ctx, %q, %q)", projectID, datasetID)
 	client, err := bq.NewClient(ctx, projectID)
@@ -61,6 +126,9 @@ func (c *Client) Close() (err error) {
 	if c.deleteDatasetOnClose {
 		err = c.dataset.DeleteWithContents(context.Background())
 	}
+	if c.writer != nil {
+		err = errors.Join(err, c.writer.Close())
+	}
 	return errors.Join(err, c.client.Close())
 }
 
@@ -166,6 +234,11 @@ func (c *Client) CreateOrUpdateTable(ctx context.Context, tableID string) (creat
 		return false, nil
 	}
 
+	// BigQuery would reject most unsupported changes, but with
+	// less helpful errors.
+	if err := CheckSchemaChange(meta.Schema, schema); err != nil {
+		return false, fmt.Errorf("unsupported schema change:\n%w", err)
+	}
 	_, err = c.Table(tableID).Update(ctx, bq.TableMetadataToUpdate{Schema: schema}, meta.ETag)
 	// There is a race condition if multiple threads of control call this function concurrently:
 	// The table may have changed since Metadata was called above. This error is harmless: it
@@ -177,6 +250,91 @@ func (c *Client) CreateOrUpdateTable(ctx context.Context, tableID string) (creat
 	return false, err
 }
 
+// viewVersionLabel is the label of a view holding the ViewVersion of its
+// query.
+const viewVersionLabel = "view_version"
+
+// DeleteTable deletes the table. It is not an error if the table does not
+// exist.
+func (c *Client) DeleteTable(ctx context.Context, tableID string) (err error) {
+	defer derrors.Wrap(&err, "DeleteTable(%q)", tableID)
+	if err := c.Table(tableID).Delete(ctx); err != nil && !isNotFoundError(err) {
+		return err
+	}
+	return nil
+}
+
+// ReplaceRows implements DB.ReplaceRows. The rows are deleted and inserted
+// by separate statements, so readers may see neither the old rows nor the
+// new ones in between; if the insert fails, ReplaceRows can be repeated.
+func (c *Client) ReplaceRows(ctx context.Context, srcTableID, dstTableID, column, key string) (_ int64, err error) {
+	defer derrors.Wrap(&err, "ReplaceRows(%q, %q, %s)", srcTableID, dstTableID, column)
+	schema := TableSchema(srcTableID)
+	if schema == nil {
+		return 0, fmt.Errorf("no schema registered for table %q", srcTableID)
+	}
+	del, ins := replaceRowsQueries(c.FullTableName(srcTableID), c.FullTableName(dstTableID), column, schema)
+	if _, err := c.runDML(ctx, del, bq.QueryParameter{Name: "key", Value: key}); err != nil {
+		return 0, err
+	}
+	return c.runDML(ctx, ins)
+}
+
+// replaceRowsQueries returns the statements used by ReplaceRows: the
+// delete, whose @key parameter is the value of column, and the insert.
+func replaceRowsQueries(fullSrcName, fullDstName, column string, schema bq.Schema) (del, ins string) {
+	var cols []string
+	for _, f := range schema {
+		cols = append(cols, "`"+f.Name+"`")
+	}
+	cs := strings.Join(cols, ", ")
+	del = fmt.Sprintf("DELETE FROM `%s` WHERE `%s` = @key", fullDstName, column)
+	ins = fmt.Sprintf("INSERT INTO `%s` (%s) SELECT %s FROM `%s`", fullDstName, cs, cs, fullSrcName)
+	return del, ins
+}
+
+// CreateOrUpdateView creates a view if it does not exist, or updates it if
+// its query is not the one registered with AddView.
+// It returns true if it created the view.
+func (c *Client) CreateOrUpdateView(ctx context.Context, viewID string) (created bool, err error) {
+	defer derrors.Wrap(&err, "CreateOrUpdateView(%q)", viewID)
+	vq := ViewQueryFunc(viewID)
+	if vq == nil {
+		return false, fmt.Errorf("no query registered for view %q", viewID)
+	}
+	query := vq(c.FullTableName)
+	version := ViewVersion(query)
+
+	meta, err := c.Table(viewID).Metadata(ctx) // check if the view already exists
+	if err != nil {
+		if !isNotFoundError(err) {
+			return false, err
+		}
+		err := c.Table(viewID).Create(ctx, &bq.TableMetadata{
+			ViewQuery: query,
+			Labels:    map[string]string{viewVersionLabel: version},
+		})
+		if isAlreadyExistsError(err) {
+			// Another instance created it first.
+			return false, nil
+		}
+		return err == nil, err
+	}
+	if meta.Labels[viewVersionLabel] == version {
+		// As with tables, avoid updates that count towards quota limits
+		// for metadata updates.
+		return false, nil
+	}
+	update := bq.TableMetadataToUpdate{ViewQuery: query}
+	update.SetLabel(viewVersionLabel, version)
+	_, err = c.Table(viewID).Update(ctx, update, meta.ETag)
+	// As with tables, someone else may have updated the view first.
+	if isAlreadyExistsError(err) || isRaceChangeError(err) {
+		return false, nil
+	}
+	return false, err
+}
+
 // A Row is something that can be uploaded to BigQuery.
 type Row interface {
 	SetUploadTime(time.Time)
@@ -185,54 +343,35 @@ type Row interface {
 // Upload inserts a row into the table.
 func (c *Client) Upload(ctx context.Context, tableID string, row Row) (err error) {
 	defer derrors.Wrap(&err, "Upload(ctx, %q)", tableID)
-	u := c.Table(tableID).Inserter()
 	row.SetUploadTime(time.Now())
-	return u.Put(ctx, row)
+	return c.write(ctx, tableID, []Row{row}, 0)
 }
 
 // UploadMany inserts multiple rows into the table.
-// Each row should be a struct pointer.
-// The chunkSize parameter limits the number of rows sent in a single request; this may
-// be necessary to avoid reaching the maximum size of a request.
-// If chunkSize is <= 0, all rows will be sent in one request.
-func UploadMany[T Row](ctx context.Context, client *Client, tableID string, rows []T, chunkSize int) (err error) {
+// Each row should be a struct pointer, and the table's schema must have
+// been registered with AddTable.
+// The rows are written with the Storage Write API to a single pending
+// stream, which is committed only after all rows are appended: either
+// all the rows are written or none are.
+// The chunkSize parameter limits the number of rows sent in a single append request.
+// If chunkSize is <= 0, rows are split only as needed to keep requests under
+// the maximum request size.
+func UploadMany[T Row](ctx context.Context, client DB, tableID string, rows []T, chunkSize int) (err error) {
 	defer derrors.Wrap(&err, "UploadMany(%q), %d rows, chunkSize=%d", tableID, len(rows), chunkSize)
 
 	now := time.Now()
+	rs := make([]Row, len(rows))
 	// Set upload time.
-	for _, r := range rows {
+	for i, r := range rows {
 		r.SetUploadTime(now)
+		rs[i] = r
 	}
-
-	ins := client.Table(tableID).Inserter()
-	if chunkSize <= 0 {
-		return ins.Put(ctx, rows)
-	}
-	start := 0
-	for start < len(rows) {
-		end := start + chunkSize
-		if end > len(rows) {
-			end = len(rows)
-		}
-		for {
-			if err := ins.Put(ctx, rows[start:end]); err == nil {
-				break
-			} else if hasCode(err, http.StatusRequestEntityTooLarge) && end-start > 1 {
-				// Request too large; reduce this chunk size by half.
-				end = start + (end-start)/2
-				continue
-			} else {
-				return err
-			}
-		}
-		start = end
-	}
-	return nil
+	return client.write(ctx, tableID, rs, chunkSize)
 }
 
 // ForEachRow calls f for each row in the given iterator.
 // It returns as soon as f returns false.
-func ForEachRow[T any](iter *bq.RowIterator, f func(*T) bool) error {
+func ForEachRow[T any](iter RowIterator, f func(*T) bool) error {
 	for {
 		var row T
 		err := iter.Next(&row)
@@ -250,7 +389,7 @@ func ForEachRow[T any](iter *bq.RowIterator, f func(*T) bool) error {
 }
 
 // All returns all rows returned by iter.
-func All[T any](iter *bq.RowIterator) ([]*T, error) {
+func All[T any](iter RowIterator) ([]*T, error) {
 	var ts []*T
 	err := ForEachRow(iter, func(t *T) bool {
 		ts = append(ts, t)
@@ -262,8 +401,65 @@ func All[T any](iter *bq.RowIterator) ([]*T, error) {
 	return ts, nil
 }
 
-func (c *Client) Query(ctx context.Context, q string) (*bq.RowIterator, error) {
-	return c.client.Query(q).Read(ctx)
+// A Param is a named query parameter. The query text refers to it
+// as @Name. Value should be a Go value of a type that the BigQuery
+// client knows how to convert, such as a string, int, time.Time or civil.Date.
+type Param struct {
+	Name  string
+	Value any
+}
+
+// Query runs the query q with the given named parameters and returns an
+// iterator over its results.
+//
+// Values that come from outside the program, like module paths or user
+// input, should always be passed as parameters instead of being formatted
+// into q.
+func (c *Client) Query(ctx context.Context, q string, params ...Param) (_ RowIterator, err error) {
+	if err := checkParams(q, params); err != nil {
+		return nil, err
+	}
+	defer derrors.Wrap(&err, "Query")
+	query := c.client.Query(q)
+	for _, p := range params {
+		query.Parameters = append(query.Parameters, bq.QueryParameter{Name: p.Name, Value: p.Value})
+	}
+	iter, err := query.Read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return iter, nil
+}
+
+var paramRegexp = regexp.MustCompile(`@([A-Za-z_][A-Za-z0-9_]*)`)
+
+// checkParams reports an error if the @-references in q do not
+// correspond exactly to the names of params.
+func checkParams(q string, params []Param) error {
+	have := map[string]bool{}
+	for _, p := range params {
+		if paramRegexp.FindString("@"+p.Name) != "@"+p.Name {
+			return fmt.Errorf("invalid query parameter name %q", p.Name)
+		}
+		if have[p.Name] {
+			return fmt.Errorf("duplicate query parameter %q", p.Name)
+		}
+		have[p.Name] = true
+	}
+	used := map[string]bool{}
+	for _, m := range paramRegexp.FindAllStringSubmatch(q, -1) {
+		name := m[1]
+		if !have[name] {
+			return fmt.Errorf("query refers to missing parameter @%s", name)
+		}
+		used[name] = true
+	}
+	for _, p := range params {
+		if !used[p.Name] {
+			return fmt.Errorf("query parameter %q is not used in query", p.Name)
+		}
+	}
+	return nil
 }
 
 // NullFloat constructs a bq.NullFloat64
@@ -328,6 +524,43 @@ func SchemaString(schema bq.Schema) string {
 	return b.String()
 }
 
+// CheckSchemaChange returns an error describing each change from schema old
+// to schema new that BigQuery doesn't support on a table with data.
+// The supported changes are:
+//   - adding a nullable or repeated column
+//   - dropping a column
+//   - changing a column from required to nullable.
+func CheckSchemaChange(old, new bq.Schema) error {
+	return errors.Join(schemaChangeErrors("", old, new)...)
+}
+
+func schemaChangeErrors(prefix string, old, new bq.Schema) []error {
+	oldFields := map[string]*bq.FieldSchema{}
+	for _, f := range old {
+		oldFields[f.Name] = f
+	}
+	var errs []error
+	for _, nf := range new {
+		name := prefix + nf.Name
+		of := oldFields[nf.Name]
+		switch {
+		case of == nil:
+			if nf.Required {
+				errs = append(errs, fmt.Errorf("column %s: added as required", name))
+			}
+		case of.Type != nf.Type:
+			errs = append(errs, fmt.Errorf("column %s: type changed from %s to %s", name, of.Type, nf.Type))
+		case of.Repeated != nf.Repeated:
+			errs = append(errs, fmt.Errorf("column %s: repeated changed from %t to %t", name, of.Repeated, nf.Repeated))
+		case !of.Required && nf.Required:
+			errs = append(errs, fmt.Errorf("column %s: changed from nullable to required", name))
+		case nf.Type == bq.RecordFieldType:
+			errs = append(errs, schemaChangeErrors(name+".", of.Schema, nf.Schema)...)
+		}
+	}
+	return errs
+}
+
 var (
 	tableMu sync.Mutex
 	tables  = map[string]bq.Schema{}
@@ -340,6 +573,18 @@ func AddTable(tableID string, s bq.Schema) {
 	tables[tableID] = s
 }
 
+// TableNames returns the names of the tables recorded with AddTable, sorted.
+func TableNames() []string {
+	tableMu.Lock()
+	defer tableMu.Unlock()
+	var names []string
+	for name := range tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // TableSchema returns the schema associated with the given table,
 // or nil if there is none.
 func TableSchema(tableID string) bq.Schema {
@@ -348,6 +593,55 @@ func TableSchema(tableID string) bq.Schema {
 	return tables[tableID]
 }
 
+// A ViewQuery returns the SQL query defining a view, given a function
+// that returns the fully-qualified names of tables, as DB.FullTableName
+// does.
+type ViewQuery func(fullTableName func(tableID string) string) string
+
+var (
+	viewMu sync.Mutex
+	views  = map[string]ViewQuery{}
+)
+
+// AddView records the query defining a view, so view creation just needs
+// the name. Views are created after tables, in name order, so their queries
+// may refer to any table recorded with AddTable, and to views whose names
+// sort before theirs.
+func AddView(viewID string, q ViewQuery) {
+	viewMu.Lock()
+	defer viewMu.Unlock()
+	views[viewID] = q
+}
+
+// ViewNames returns the names of the views recorded with AddView, sorted.
+func ViewNames() []string {
+	viewMu.Lock()
+	defer viewMu.Unlock()
+	var names []string
+	for name := range views {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ViewQueryFunc returns the query associated with the given view,
+// or nil if there is none.
+func ViewQueryFunc(viewID string) ViewQuery {
+	viewMu.Lock()
+	defer viewMu.Unlock()
+	return views[viewID]
+}
+
+// ViewVersion computes a short string from a view query, such that
+// different queries result in different strings with high probability.
+// Differences in white space are ignored. The string is short enough to
+// be the value of a label.
+func ViewVersion(query string) string {
+	hash := sha256.Sum256([]byte(strings.Join(strings.Fields(query), " ")))
+	return hex.EncodeToString(hash[:16])
+}
+
 // PartitionQuery describes a query that returns one row for each distinct value
 // of the partition columns in the given table.
 //
@@ -365,6 +659,9 @@ func TableSchema(tableID string) bq.Schema {
 // will construct a query returning the student in each class whose name is
 // alphabetically first.
 //
+// Values in the Where clause should be written as @-parameters and
+// supplied in Params; pass both to Client.Query.
+//
 // (BigQuery SQL has no DISTINCT ON feature and doesn't allow columns of type RECORD
 // in queries with DISTINCT, so we have to take this approach.)
 type PartitionQuery struct {
@@ -374,6 +671,7 @@ type PartitionQuery struct {
 	Where       string // WHERE clause
 	OrderBy     string // text after ORDER BY: comma-separated columns, each
 	// optionally followed by DESC or ASC
+	Params []Param // parameters referred to in Where
 }
 
 func (q PartitionQuery) String() string {
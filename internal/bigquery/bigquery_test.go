@@ -62,12 +62,55 @@ func TestPartitionQuery(t *testing.T) {
 				 WHERE name = 'foo' AND args = 'bar baz'
 				) WHERE rownum  = 1`,
 		},
+		{
+			PartitionQuery{
+				From:        "full.table",
+				PartitionOn: "p",
+				OrderBy:     "o",
+				Where:       "name = @name AND args = @args",
+				Params:      []Param{{"name", "foo"}, {"args", "bar baz"}},
+			},
+			`SELECT * EXCEPT (rownum)
+				 FROM ( SELECT *, ROW_NUMBER() OVER ( PARTITION BY p ORDER BY o ) AS rownum
+				 FROM full.table
+				 WHERE name = @name AND args = @args
+				) WHERE rownum  = 1`,
+		},
 	} {
 		got := clean(test.q.String())
 		want := clean(test.want)
 		if got != want {
 			t.Errorf("#%d:\ngot  %s\nwant %s", i, got, want)
 		}
+		if err := checkParams(got, test.q.Params); err != nil {
+			t.Errorf("#%d: %v", i, err)
+		}
+	}
+}
+
+func TestCheckParams(t *testing.T) {
+	for _, test := range []struct {
+		q       string
+		params  []Param
+		wantErr string // substring of error; empty if no error expected
+	}{
+		{"SELECT * FROM t", nil, ""},
+		{"SELECT * FROM t WHERE a = @a AND b = @b_2", []Param{{"a", 1}, {"b_2", "x"}}, ""},
+		{"SELECT * FROM t WHERE a = @a OR @a IS NULL", []Param{{"a", 1}}, ""},
+		{"SELECT * FROM t WHERE a = @a", nil, "missing parameter @a"},
+		{"SELECT * FROM t", []Param{{"a", 1}}, "not used"},
+		{"SELECT * FROM t WHERE a = @a", []Param{{"a", 1}, {"a", 2}}, "duplicate"},
+		{"SELECT * FROM t WHERE a = @a", []Param{{"a b", 1}}, "invalid"},
+		{"SELECT * FROM t WHERE a = @a", []Param{{"", 1}}, "invalid"},
+	} {
+		err := checkParams(test.q, test.params)
+		if test.wantErr == "" {
+			if err != nil {
+				t.Errorf("%q: got %v, want no error", test.q, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("%q: got %v, want error containing %q", test.q, err, test.wantErr)
+		}
 	}
 }
 
@@ -110,3 +153,101 @@ func TestSchemaString(t *testing.T) {
 		t.Errorf("\ngot  %q\nwant %q", got, want)
 	}
 }
+
+func TestCheckSchemaChange(t *testing.T) {
+	field := func(name string, typ bq.FieldType, req, rep bool, sub ...*bq.FieldSchema) *bq.FieldSchema {
+		return &bq.FieldSchema{Name: name, Type: typ, Required: req, Repeated: rep, Schema: sub}
+	}
+	old := bq.Schema{
+		field("a", bq.StringFieldType, true, false),
+		field("b", bq.IntegerFieldType, false, false),
+		field("r", bq.RecordFieldType, false, true,
+			field("x", bq.StringFieldType, true, false)),
+	}
+	for _, test := range []struct {
+		name    string
+		new     bq.Schema
+		wantErr string // substring of error; empty if none
+	}{
+		{"same", old, ""},
+		{"add nullable", append(old[:3:3], field("c", bq.StringFieldType, false, false)), ""},
+		{"add repeated", append(old[:3:3], field("c", bq.StringFieldType, false, true)), ""},
+		{"drop", old[1:], ""},
+		{"required to nullable", bq.Schema{field("a", bq.StringFieldType, false, false), old[1], old[2]}, ""},
+		{"add required", append(old[:3:3], field("c", bq.StringFieldType, true, false)), "column c: added as required"},
+		{"nullable to required", bq.Schema{old[0], field("b", bq.IntegerFieldType, true, false), old[2]}, "column b: changed from nullable to required"},
+		{"type", bq.Schema{old[0], field("b", bq.StringFieldType, false, false), old[2]}, "column b: type changed from INTEGER to STRING"},
+		{"repeated", bq.Schema{old[0], field("b", bq.IntegerFieldType, false, true), old[2]}, "column b: repeated changed from false to true"},
+		{
+			"nested",
+			bq.Schema{old[0], old[1], field("r", bq.RecordFieldType, false, true,
+				field("x", bq.StringFieldType, true, false), field("y", bq.BooleanFieldType, true, false))},
+			"column r.y: added as required",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := CheckSchemaChange(old, test.new)
+			switch {
+			case test.wantErr == "" && err != nil:
+				t.Errorf("got %v, want no error", err)
+			case test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)):
+				t.Errorf("got %v, want error containing %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestViewVersion(t *testing.T) {
+	v := ViewVersion("SELECT a FROM t")
+	if got := ViewVersion("\n\tSELECT  a\n\tFROM t\n"); got != v {
+		t.Errorf("white space changed the version: got %s, want %s", got, v)
+	}
+	if got := ViewVersion("SELECT b FROM t"); got == v {
+		t.Error("different queries have the same version")
+	}
+	// Versions are label values, which are at most 63 characters.
+	if len(v) > 63 {
+		t.Errorf("version %q is too long for a label", v)
+	}
+}
+
+func TestCheckRetention(t *testing.T) {
+	AddTable("retention-with-created-at", bq.Schema{
+		{Name: "created_at", Type: bq.TimestampFieldType},
+		{Name: "x", Type: bq.IntegerFieldType},
+	})
+	AddTable("retention-without-created-at", bq.Schema{
+		{Name: "x", Type: bq.IntegerFieldType},
+	})
+	defer func() {
+		tableMu.Lock()
+		delete(tables, "retention-with-created-at")
+		delete(tables, "retention-without-created-at")
+		tableMu.Unlock()
+	}()
+
+	if err := checkRetention("retention-with-created-at"); err != nil {
+		t.Errorf("got %v, want nil", err)
+	}
+	for _, table := range []string{"retention-without-created-at", "no-such-table"} {
+		if err := checkRetention(table); err == nil {
+			t.Errorf("%s: got nil, want error", table)
+		}
+	}
+}
+
+func TestReplaceRowsQueries(t *testing.T) {
+	schema := bq.Schema{
+		{Name: "created_at", Type: bq.TimestampFieldType},
+		{Name: "user", Type: bq.StringFieldType},
+	}
+	del, ins := replaceRowsQueries("p.d.src", "p.d.dst", "user", schema)
+	want := "DELETE FROM `p.d.dst` WHERE `user` = @key"
+	if del != want {
+		t.Errorf("delete: got\n%s\nwant\n%s", del, want)
+	}
+	want = "INSERT INTO `p.d.dst` (`created_at`, `user`) SELECT `created_at`, `user` FROM `p.d.src`"
+	if ins != want {
+		t.Errorf("insert: got\n%s\nwant\n%s", ins, want)
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bigquery
+
+// Buffering uploads.
+//
+// A Buffer collects the rows uploaded by concurrent requests and writes
+// them in batches, one pending stream per table, instead of one stream per
+// upload. An upload waits until the batch holding its rows has been
+// written, and fails if they could not be, so that callers record that a
+// scan's rows were written only once they are. Rows that could not be
+// written are not retried by the Buffer; the upload fails, and so the task
+// that made it is retried. Rows are encoded when they are uploaded, so bad
+// rows fail the upload before it waits.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/log"
+)
+
+// BufferOptions configure a Buffer.
+type BufferOptions struct {
+	// MaxRows is the number of buffered rows that triggers a flush.
+	MaxRows int
+	// MaxAge is how long a row can be buffered before it is flushed.
+	// It bounds how long an upload waits for other rows to write with.
+	MaxAge time.Duration
+}
+
+// flushTimeout bounds the time spent writing a batch in the background.
+const flushTimeout = 2 * time.Minute
+
+// A Buffer is a DB whose uploads are written to BigQuery in batches.
+// Upload and UploadMany return once the batch holding the rows has been
+// written, with the error writing the rows to their table, if any.
+// Other methods are those of the underlying Client.
+type Buffer struct {
+	*Client
+	opts BufferOptions
+	// writeData writes encoded rows to a table. It is Client.writeData,
+	// except in tests.
+	writeData func(ctx context.Context, tableID string, data [][]byte) error
+
+	flushMu sync.Mutex // held while flushing, so batches are written in order
+
+	mu     sync.Mutex
+	batch  *batch      // the rows waiting to be written
+	timer  *time.Timer // flushes the batch after MaxAge
+	closed bool
+}
+
+// A batch is a set of rows written together.
+type batch struct {
+	rows map[string][][]byte // table ID to encoded rows
+	n    int                 // number of rows
+	done chan struct{}       // closed once the rows have been written, or not
+	errs map[string]error    // table ID to error writing its rows; set before done is closed
+}
+
+func newBatch() *batch {
+	return &batch{rows: map[string][][]byte{}, done: make(chan struct{})}
+}
+
+// NewBuffer returns a Buffer that writes rows to the tables of c.
+func NewBuffer(c *Client, opts BufferOptions) (*Buffer, error) {
+	return newBuffer(c, func(ctx context.Context, tableID string, data [][]byte) error {
+		return c.writeData(ctx, tableID, data, 0)
+	}, opts)
+}
+
+func newBuffer(c *Client, writeData func(context.Context, string, [][]byte) error, opts BufferOptions) (_ *Buffer, err error) {
+	defer derrors.Wrap(&err, "newBuffer(%+v)", opts)
+
+	if opts.MaxRows <= 0 {
+		return nil, errors.New("MaxRows must be positive")
+	}
+	if opts.MaxAge <= 0 {
+		return nil, errors.New("MaxAge must be positive")
+	}
+	return &Buffer{
+		Client:    c,
+		opts:      opts,
+		writeData: writeData,
+		batch:     newBatch(),
+	}, nil
+}
+
+// Upload writes the row to the table in the next batch.
+func (b *Buffer) Upload(ctx context.Context, tableID string, row Row) (err error) {
+	defer derrors.Wrap(&err, "Buffer.Upload(ctx, %q)", tableID)
+	row.SetUploadTime(time.Now())
+	return b.write(ctx, tableID, []Row{row}, 0)
+}
+
+// write writes the rows to the table in the next batch. Rows uploaded
+// together are written in the same batch. The chunk size is ignored:
+// batches are split only to keep requests under the maximum request size.
+// If ctx is done before the batch is written, write returns ctx.Err(), but
+// the rows may still be written.
+func (b *Buffer) write(ctx context.Context, tableID string, rows []Row, _ int) (err error) {
+	defer derrors.Wrap(&err, "Buffer.write(%q)", tableID)
+
+	if len(rows) == 0 {
+		return nil
+	}
+	data, err := encodeRows(tableID, rows)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return errors.New("buffer is closed")
+	}
+	bt := b.batch
+	bt.rows[tableID] = append(bt.rows[tableID], data...)
+	bt.n += len(data)
+	if bt.n >= b.opts.MaxRows {
+		go b.flushInBackground()
+	} else if b.timer == nil {
+		b.timer = time.AfterFunc(b.opts.MaxAge, b.flushInBackground)
+	}
+	b.mu.Unlock()
+
+	select {
+	case <-bt.done:
+		return bt.errs[tableID]
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Flush writes the buffered rows, and returns the errors writing them.
+func (b *Buffer) Flush(ctx context.Context) (err error) {
+	defer derrors.Wrap(&err, "Buffer.Flush")
+
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	b.mu.Lock()
+	bt := b.batch
+	b.batch = newBatch()
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	b.mu.Unlock()
+
+	defer close(bt.done)
+	if bt.n == 0 {
+		return nil
+	}
+	tables := make([]string, 0, len(bt.rows))
+	for t := range bt.rows {
+		tables = append(tables, t)
+	}
+	sort.Strings(tables)
+	bt.errs = map[string]error{}
+	var errs []error
+	for _, t := range tables {
+		if err := b.writeData(ctx, t, bt.rows[t]); err != nil {
+			bt.errs[t] = err
+			errs = append(errs, fmt.Errorf("%s: %d rows: %w", t, len(bt.rows[t]), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (b *Buffer) flushInBackground() {
+	ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancel()
+	if err := b.Flush(ctx); err != nil {
+		log.Errorf(ctx, err, "flushing BigQuery upload buffer")
+	}
+}
+
+// Close flushes the buffer and closes the underlying Client, if any.
+func (b *Buffer) Close() error {
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancel()
+	err := b.Flush(ctx)
+	if b.Client != nil {
+		err = errors.Join(err, b.Client.Close())
+	}
+	return err
+}
@@ -0,0 +1,145 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bigquery
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A testWriter records the rows written by a Buffer.
+type testWriter struct {
+	mu   sync.Mutex
+	rows map[string]int // table ID to number of rows written
+	err  error          // if non-nil, returned by every write
+}
+
+func newTestWriter() *testWriter {
+	return &testWriter{rows: map[string]int{}}
+}
+
+func (w *testWriter) write(_ context.Context, tableID string, data [][]byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	w.rows[tableID] += len(data)
+	return nil
+}
+
+func (w *testWriter) count(tableID string) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.rows[tableID]
+}
+
+func TestBufferFlushByCount(t *testing.T) {
+	ctx := context.Background()
+	table := addFakeTestTable(t)
+	w := newTestWriter()
+	b, err := newBuffer(nil, w.write, BufferOptions{MaxRows: 3, MaxAge: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first upload waits for the batch to fill.
+	errc := make(chan error, 1)
+	go func() { errc <- b.Upload(ctx, table, &fakeTestRow{Name: "a"}) }()
+	select {
+	case err := <-errc:
+		t.Fatalf("Upload returned %v before the batch was written", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	if err := UploadMany(ctx, b, table, []*fakeTestRow{{Name: "b"}, {Name: "c"}}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if got := w.count(table); got != 3 {
+		t.Errorf("got %d rows written, want 3", got)
+	}
+	// Close flushes the rest.
+	go func() { errc <- b.Upload(ctx, table, &fakeTestRow{Name: "d"}) }()
+	time.Sleep(10 * time.Millisecond)
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if got := w.count(table); got != 4 {
+		t.Errorf("after Close: got %d rows written, want 4", got)
+	}
+	if err := b.Upload(ctx, table, &fakeTestRow{Name: "e"}); err == nil {
+		t.Error("Upload after Close: got nil, want error")
+	}
+}
+
+func TestBufferFlushByAge(t *testing.T) {
+	ctx := context.Background()
+	table := addFakeTestTable(t)
+	w := newTestWriter()
+	b, err := newBuffer(nil, w.write, BufferOptions{MaxRows: 100, MaxAge: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Upload(ctx, table, &fakeTestRow{Name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.count(table); got != 1 {
+		t.Errorf("got %d rows written, want 1", got)
+	}
+}
+
+func TestBufferWriteError(t *testing.T) {
+	ctx := context.Background()
+	table := addFakeTestTable(t)
+	w := newTestWriter()
+	w.err = errors.New("unavailable")
+	b, err := newBuffer(nil, w.write, BufferOptions{MaxRows: 1, MaxAge: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The upload fails, and its rows are not retried.
+	if err := b.Upload(ctx, table, &fakeTestRow{Name: "a"}); !errors.Is(err, w.err) {
+		t.Fatalf("got %v, want %v", err, w.err)
+	}
+	w.mu.Lock()
+	w.err = nil
+	w.mu.Unlock()
+	if err := b.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.count(table); got != 0 {
+		t.Errorf("got %d rows written, want 0", got)
+	}
+}
+
+func TestBufferContextDone(t *testing.T) {
+	table := addFakeTestTable(t)
+	b, err := newBuffer(nil, newTestWriter().write, BufferOptions{MaxRows: 100, MaxAge: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := b.Upload(ctx, table, &fakeTestRow{Name: "a"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want DeadlineExceeded", err)
+	}
+}
+
+func TestBufferBadRow(t *testing.T) {
+	b, err := newBuffer(nil, newTestWriter().write, BufferOptions{MaxRows: 1, MaxAge: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Upload(context.Background(), "no-such-table", &fakeTestRow{}); err == nil {
+		t.Error("got nil, want error")
+	}
+}
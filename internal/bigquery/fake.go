@@ -0,0 +1,295 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bigquery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"google.golang.org/api/iterator"
+)
+
+// A Fake is an in-memory implementation of DB, for tests that should not
+// depend on BigQuery.
+//
+// As with BigQuery, tables must be registered with AddTable and created with
+// CreateOrUpdateTable before rows are uploaded to them, and rows must match
+// the table's schema. A Fake cannot run SQL: the results of queries come
+// from QueryFunc.
+type Fake struct {
+	// QueryFunc, if non-nil, returns the rows resulting from the query q
+	// with the given parameters. Each row must be a value or pointer of the
+	// type the caller passes to RowIterator.Next. If QueryFunc is nil,
+	// queries return no rows.
+	QueryFunc func(q string, params []Param) ([]any, error)
+
+	mu      sync.Mutex
+	tables  map[string][]Row
+	views   map[string]string // view ID to query
+	queries []string
+}
+
+var _ DB = (*Fake)(nil)
+
+// NewFake returns a Fake with no tables.
+func NewFake() *Fake {
+	return &Fake{tables: map[string][]Row{}, views: map[string]string{}}
+}
+
+// FullTableName implements DB.FullTableName.
+func (f *Fake) FullTableName(tableID string) string {
+	return "fake-project.fake-dataset." + tableID
+}
+
+// CreateOrUpdateTable implements DB.CreateOrUpdateTable.
+func (f *Fake) CreateOrUpdateTable(ctx context.Context, tableID string) (created bool, err error) {
+	defer derrors.Wrap(&err, "CreateOrUpdateTable(%q)", tableID)
+	if TableSchema(tableID) == nil {
+		return false, fmt.Errorf("no schema registered for table %q", tableID)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.tables[tableID]; ok {
+		return false, nil
+	}
+	f.tables[tableID] = nil
+	return true, nil
+}
+
+// CreateOrUpdateView implements DB.CreateOrUpdateView.
+func (f *Fake) CreateOrUpdateView(ctx context.Context, viewID string) (created bool, err error) {
+	defer derrors.Wrap(&err, "CreateOrUpdateView(%q)", viewID)
+	vq := ViewQueryFunc(viewID)
+	if vq == nil {
+		return false, fmt.Errorf("no query registered for view %q", viewID)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.views[viewID]
+	f.views[viewID] = vq(f.FullTableName)
+	return !ok, nil
+}
+
+// ViewQuery returns the query of the view as created by
+// CreateOrUpdateView, or the empty string if it has not been created.
+func (f *Fake) ViewQuery(viewID string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.views[viewID]
+}
+
+// Upload implements DB.Upload.
+func (f *Fake) Upload(ctx context.Context, tableID string, row Row) (err error) {
+	defer derrors.Wrap(&err, "Upload(ctx, %q)", tableID)
+	row.SetUploadTime(time.Now())
+	return f.write(ctx, tableID, []Row{row}, 0)
+}
+
+func (f *Fake) write(ctx context.Context, tableID string, rows []Row, chunkSize int) (err error) {
+	defer derrors.Wrap(&err, "write(%q)", tableID)
+
+	if len(rows) == 0 {
+		return nil
+	}
+	schema := TableSchema(tableID)
+	if schema == nil {
+		return fmt.Errorf("no schema registered for table %q", tableID)
+	}
+	// Encode the rows as Client.write does, to catch the same errors.
+	conv, err := newProtoConverter(schema)
+	if err != nil {
+		return err
+	}
+	for i, r := range rows {
+		if _, err := conv.encode(r); err != nil {
+			return fmt.Errorf("row %d: %w", i, err)
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.tables[tableID]; !ok {
+		return fmt.Errorf("%w: table %q does not exist", derrors.NotFound, tableID)
+	}
+	f.tables[tableID] = append(f.tables[tableID], rows...)
+	return nil
+}
+
+// Rows returns the rows uploaded to the table, in order.
+func (f *Fake) Rows(tableID string) []Row {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Row(nil), f.tables[tableID]...)
+}
+
+// Query implements DB.Query. It records q, and returns the rows
+// from f.QueryFunc.
+func (f *Fake) Query(ctx context.Context, q string, params ...Param) (_ RowIterator, err error) {
+	if err := checkParams(q, params); err != nil {
+		return nil, err
+	}
+	defer derrors.Wrap(&err, "Query")
+	f.mu.Lock()
+	f.queries = append(f.queries, q)
+	f.mu.Unlock()
+	if f.QueryFunc == nil {
+		return &fakeIterator{}, nil
+	}
+	rows, err := f.QueryFunc(q, params)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeIterator{rows: rows}, nil
+}
+
+// Queries returns the queries run by f, in order.
+func (f *Fake) Queries() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.queries...)
+}
+
+// CountRowsBefore implements DB.CountRowsBefore.
+func (f *Fake) CountRowsBefore(ctx context.Context, tableID string, cutoff time.Time) (_ int64, err error) {
+	defer derrors.Wrap(&err, "CountRowsBefore(%q, %s)", tableID, cutoff)
+	old, _, err := f.partitionRows(tableID, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(old)), nil
+}
+
+// DeleteRowsBefore implements DB.DeleteRowsBefore.
+func (f *Fake) DeleteRowsBefore(ctx context.Context, tableID string, cutoff time.Time) (_ int64, err error) {
+	defer derrors.Wrap(&err, "DeleteRowsBefore(%q, %s)", tableID, cutoff)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	old, keep, err := f.partitionRowsLocked(tableID, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	f.tables[tableID] = keep
+	return int64(len(old)), nil
+}
+
+// DeleteTable implements DB.DeleteTable.
+func (f *Fake) DeleteTable(ctx context.Context, tableID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.tables, tableID)
+	return nil
+}
+
+// ReplaceRows implements DB.ReplaceRows. The rows are copied as they are,
+// so the two tables should be registered with the same schema.
+func (f *Fake) ReplaceRows(ctx context.Context, srcTableID, dstTableID, column, key string) (_ int64, err error) {
+	defer derrors.Wrap(&err, "ReplaceRows(%q, %q, %s)", srcTableID, dstTableID, column)
+	if TableSchema(srcTableID) == nil {
+		return 0, fmt.Errorf("no schema registered for table %q", srcTableID)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, id := range []string{srcTableID, dstTableID} {
+		if _, ok := f.tables[id]; !ok {
+			return 0, fmt.Errorf("%w: table %q does not exist", derrors.NotFound, id)
+		}
+	}
+	schema := TableSchema(dstTableID)
+	var keep []Row
+	for _, r := range f.tables[dstTableID] {
+		vals, _, err := (&bq.StructSaver{Struct: r, Schema: schema}).Save()
+		if err != nil {
+			return 0, err
+		}
+		if !valueIs(vals[column], key) {
+			keep = append(keep, r)
+		}
+	}
+	rows := f.tables[srcTableID]
+	f.tables[dstTableID] = append(keep, rows...)
+	return int64(len(rows)), nil
+}
+
+// valueIs reports whether v, a value saved from a row, is the string s.
+func valueIs(v bq.Value, s string) bool {
+	switch v := v.(type) {
+	case string:
+		return v == s
+	case bq.NullString:
+		return v.Valid && v.StringVal == s
+	}
+	return false
+}
+
+func (f *Fake) partitionRows(tableID string, cutoff time.Time) (old, keep []Row, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.partitionRowsLocked(tableID, cutoff)
+}
+
+// partitionRowsLocked separates the rows of the table that were uploaded
+// before cutoff from the others. f.mu must be held.
+func (f *Fake) partitionRowsLocked(tableID string, cutoff time.Time) (old, keep []Row, err error) {
+	if err := checkRetention(tableID); err != nil {
+		return nil, nil, err
+	}
+	rows, ok := f.tables[tableID]
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: table %q does not exist", derrors.NotFound, tableID)
+	}
+	schema := TableSchema(tableID)
+	for _, r := range rows {
+		vals, _, err := (&bq.StructSaver{Struct: r, Schema: schema}).Save()
+		if err != nil {
+			return nil, nil, err
+		}
+		t, ok := vals[createdAtColumn].(time.Time)
+		if !ok {
+			return nil, nil, fmt.Errorf("row has %s of type %T", createdAtColumn, vals[createdAtColumn])
+		}
+		if t.Before(cutoff) {
+			old = append(old, r)
+		} else {
+			keep = append(keep, r)
+		}
+	}
+	return old, keep, nil
+}
+
+// Close implements DB.Close. It does nothing.
+func (f *Fake) Close() error {
+	return nil
+}
+
+// A fakeIterator is a RowIterator over a slice of rows.
+type fakeIterator struct {
+	rows []any
+}
+
+func (it *fakeIterator) Next(dst any) error {
+	if len(it.rows) == 0 {
+		return iterator.Done
+	}
+	row := it.rows[0]
+	dv := reflect.ValueOf(dst)
+	if dv.Kind() != reflect.Pointer || dv.IsNil() {
+		return errors.New("Next: dst must be a non-nil pointer")
+	}
+	rv := reflect.ValueOf(row)
+	if rv.Kind() == reflect.Pointer && !rv.Type().AssignableTo(dv.Elem().Type()) {
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() || !rv.Type().AssignableTo(dv.Elem().Type()) {
+		return fmt.Errorf("Next: cannot load row of type %T into %T", row, dst)
+	}
+	dv.Elem().Set(rv)
+	it.rows = it.rows[1:]
+	return nil
+}
@@ -0,0 +1,246 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bigquery
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+)
+
+type fakeTestRow struct {
+	CreatedAt time.Time `bigquery:"created_at"`
+	Name      string    `bigquery:"name"`
+	Count     int       `bigquery:"count"`
+}
+
+func (r *fakeTestRow) SetUploadTime(t time.Time) { r.CreatedAt = t }
+
+func addFakeTestTable(t *testing.T) string {
+	const table = "fake-test"
+	schema, err := InferSchema(fakeTestRow{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	AddTable(table, schema)
+	t.Cleanup(func() {
+		tableMu.Lock()
+		delete(tables, table)
+		tableMu.Unlock()
+	})
+	return table
+}
+
+func TestFakeUpload(t *testing.T) {
+	ctx := context.Background()
+	table := addFakeTestTable(t)
+	f := NewFake()
+
+	row := &fakeTestRow{Name: "a", Count: 1}
+	if err := f.Upload(ctx, table, row); !errors.Is(err, derrors.NotFound) {
+		t.Fatalf("upload before create: got %v, want NotFound", err)
+	}
+	for _, want := range []bool{true, false} {
+		created, err := f.CreateOrUpdateTable(ctx, table)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if created != want {
+			t.Errorf("CreateOrUpdateTable: got %t, want %t", created, want)
+		}
+	}
+	if _, err := f.CreateOrUpdateTable(ctx, "no-such-table"); err == nil {
+		t.Error("CreateOrUpdateTable of unregistered table: got nil, want error")
+	}
+
+	if err := f.Upload(ctx, table, row); err != nil {
+		t.Fatal(err)
+	}
+	if row.CreatedAt.IsZero() {
+		t.Error("Upload did not set the upload time")
+	}
+	more := []*fakeTestRow{{Name: "b", Count: 2}, {Name: "c", Count: 3}}
+	if err := UploadMany(ctx, f, table, more, 0); err != nil {
+		t.Fatal(err)
+	}
+	got := f.Rows(table)
+	want := []Row{row, more[0], more[1]}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestFakeQuery(t *testing.T) {
+	ctx := context.Background()
+	f := NewFake()
+
+	// With no QueryFunc, there are no rows.
+	iter, err := f.Query(ctx, "SELECT * FROM t WHERE x = @x", Param{Name: "x", Value: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := All[fakeTestRow](iter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 0 {
+		t.Errorf("got %d rows, want none", len(rows))
+	}
+
+	if _, err := f.Query(ctx, "SELECT * FROM t WHERE x = @x"); err == nil {
+		t.Error("missing parameter: got nil, want error")
+	}
+
+	want := []*fakeTestRow{{Name: "a", Count: 1}, {Name: "b", Count: 2}}
+	f.QueryFunc = func(q string, params []Param) ([]any, error) {
+		// Rows may be values or pointers.
+		return []any{want[0], *want[1]}, nil
+	}
+	iter, err = f.Query(ctx, "SELECT * FROM t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := All[fakeTestRow](iter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	// A row of the wrong type is an error.
+	iter, err = f.Query(ctx, "SELECT * FROM t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := All[struct{ N int }](iter); err == nil {
+		t.Error("wrong row type: got nil, want error")
+	}
+
+	wantQueries := []string{"SELECT * FROM t WHERE x = @x", "SELECT * FROM t", "SELECT * FROM t"}
+	if diff := cmp.Diff(wantQueries, f.Queries()); diff != "" {
+		t.Errorf("queries mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestFakeRetention(t *testing.T) {
+	ctx := context.Background()
+	table := addFakeTestTable(t)
+	f := NewFake()
+	if _, err := f.CreateOrUpdateTable(ctx, table); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for i, name := range []string{"a", "b", "c"} {
+		// Upload sets the upload time, so write directly.
+		row := &fakeTestRow{CreatedAt: now.Add(time.Duration(i-2) * time.Hour), Name: name}
+		if err := f.write(ctx, table, []Row{row}, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cutoff := now.Add(-30 * time.Minute)
+	n, err := f.CountRowsBefore(ctx, table, cutoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("CountRowsBefore: got %d, want 2", n)
+	}
+	n, err = f.DeleteRowsBefore(ctx, table, cutoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("DeleteRowsBefore: got %d, want 2", n)
+	}
+	rows := f.Rows(table)
+	if len(rows) != 1 || rows[0].(*fakeTestRow).Name != "c" {
+		t.Errorf("after delete: got %v, want only row c", rows)
+	}
+}
+
+func TestFakeReplaceRows(t *testing.T) {
+	ctx := context.Background()
+	src := addFakeTestTable(t)
+	const dst = "fake-test-copy"
+	AddTable(dst, TableSchema(src))
+	t.Cleanup(func() {
+		tableMu.Lock()
+		delete(tables, dst)
+		tableMu.Unlock()
+	})
+	f := NewFake()
+	if _, err := f.CreateOrUpdateTable(ctx, src); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.ReplaceRows(ctx, src, dst, "name", "j1"); !errors.Is(err, derrors.NotFound) {
+		t.Errorf("missing destination: got %v, want NotFound", err)
+	}
+	if _, err := f.CreateOrUpdateTable(ctx, dst); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Upload(ctx, dst, &fakeTestRow{Name: "j0"}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 2; i++ {
+		if err := f.Upload(ctx, src, &fakeTestRow{Name: "j1", Count: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Replacing again does not duplicate the rows.
+	for i := 0; i < 2; i++ {
+		n, err := f.ReplaceRows(ctx, src, dst, "name", "j1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 2 || len(f.Rows(dst)) != 3 {
+			t.Errorf("got %d rows copied, %d in destination; want 2, 3", n, len(f.Rows(dst)))
+		}
+	}
+
+	if err := f.DeleteTable(ctx, src); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Upload(ctx, src, &fakeTestRow{Name: "c"}); !errors.Is(err, derrors.NotFound) {
+		t.Errorf("upload after delete: got %v, want NotFound", err)
+	}
+	// Deleting a missing table is not an error.
+	if err := f.DeleteTable(ctx, src); err != nil {
+		t.Errorf("second delete: got %v, want nil", err)
+	}
+}
+
+func TestFakeViews(t *testing.T) {
+	ctx := context.Background()
+	const view = "fake-test-view"
+	AddView(view, func(fullTableName func(string) string) string {
+		return "SELECT name FROM `" + fullTableName("fake-test") + "`"
+	})
+	t.Cleanup(func() {
+		viewMu.Lock()
+		delete(views, view)
+		viewMu.Unlock()
+	})
+	f := NewFake()
+	for _, want := range []bool{true, false} {
+		created, err := f.CreateOrUpdateView(ctx, view)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if created != want {
+			t.Errorf("CreateOrUpdateView: got %t, want %t", created, want)
+		}
+	}
+	if got, want := f.ViewQuery(view), "SELECT name FROM `fake-project.fake-dataset.fake-test`"; got != want {
+		t.Errorf("got query %q, want %q", got, want)
+	}
+	if _, err := f.CreateOrUpdateView(ctx, "no-such-view"); err == nil {
+		t.Error("CreateOrUpdateView of unregistered view: got nil, want error")
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bigquery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+)
+
+// createdAtColumn is the column holding the time a row was uploaded,
+// as set by SetUploadTime. Retention is based on it.
+const createdAtColumn = "created_at"
+
+// CountRowsBefore returns the number of rows of the table that were
+// uploaded before cutoff.
+func (c *Client) CountRowsBefore(ctx context.Context, tableID string, cutoff time.Time) (_ int64, err error) {
+	defer derrors.Wrap(&err, "CountRowsBefore(%q, %s)", tableID, cutoff)
+
+	q, err := c.retentionQuery("SELECT COUNT(*) AS n FROM", tableID)
+	if err != nil {
+		return 0, err
+	}
+	iter, err := c.Query(ctx, q, Param{Name: "cutoff", Value: cutoff})
+	if err != nil {
+		return 0, err
+	}
+	var row struct {
+		N int64 `bigquery:"n"`
+	}
+	if err := iter.Next(&row); err != nil {
+		return 0, err
+	}
+	return row.N, nil
+}
+
+// DeleteRowsBefore deletes the rows of the table that were uploaded
+// before cutoff, and returns how many it deleted.
+func (c *Client) DeleteRowsBefore(ctx context.Context, tableID string, cutoff time.Time) (_ int64, err error) {
+	defer derrors.Wrap(&err, "DeleteRowsBefore(%q, %s)", tableID, cutoff)
+
+	q, err := c.retentionQuery("DELETE FROM", tableID)
+	if err != nil {
+		return 0, err
+	}
+	return c.runDML(ctx, q, bq.QueryParameter{Name: "cutoff", Value: cutoff})
+}
+
+// runDML runs the data-manipulation statement q with the given parameters,
+// waits for it to finish, and returns the number of rows it affected.
+func (c *Client) runDML(ctx context.Context, q string, params ...bq.QueryParameter) (int64, error) {
+	query := c.client.Query(q)
+	query.Parameters = params
+	job, err := query.Run(ctx)
+	if err != nil {
+		return 0, err
+	}
+	status, err := job.Wait(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if err := status.Err(); err != nil {
+		return 0, err
+	}
+	stats, ok := status.Statistics.Details.(*bq.QueryStatistics)
+	if !ok {
+		return 0, errors.New("missing query statistics")
+	}
+	return stats.NumDMLAffectedRows, nil
+}
+
+// retentionQuery returns a query that starts with verb and applies to
+// the rows of the table uploaded before the @cutoff parameter.
+func (c *Client) retentionQuery(verb, tableID string) (string, error) {
+	if err := checkRetention(tableID); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s `%s` WHERE %s < @cutoff", verb, c.FullTableName(tableID), createdAtColumn), nil
+}
+
+// checkRetention returns an error if retention cannot be applied to the
+// table: if it was not recorded with AddTable, or has no created_at column.
+func checkRetention(tableID string) error {
+	s := TableSchema(tableID)
+	if s == nil {
+		return fmt.Errorf("unknown table %q", tableID)
+	}
+	for _, f := range s {
+		if f.Name == createdAtColumn && f.Type == bq.TimestampFieldType {
+			return nil
+		}
+	}
+	return fmt.Errorf("table %q has no %s timestamp column", tableID, createdAtColumn)
+}
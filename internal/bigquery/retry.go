@@ -0,0 +1,154 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bigquery
+
+// Retrying uploads.
+//
+// An upload that fails with a transient error, like an unavailable service
+// or an exhausted quota, is retried with exponential backoff and jitter.
+// An upload appends its rows to a pending stream and then commits the
+// stream, and the two steps are retried separately. A failed append is
+// retried with a new stream; the stream of the failed attempt is never
+// committed. A failed commit is retried with the same stream, and only
+// after checking that the stream is still uncommitted, since a commit
+// whose response was lost may have succeeded. Either way, no retry writes
+// rows twice.
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"golang.org/x/exp/event"
+	"golang.org/x/pkgsite-metrics/internal/log"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const metricNamespace = "ecosystem/bigquery"
+
+var (
+	// retriedRowCounter counts the rows of uploads that were retried,
+	// once per retry.
+	retriedRowCounter = event.NewCounter("upload-rows-retried", &event.MetricOptions{Namespace: metricNamespace})
+	// droppedRowCounter counts the rows of uploads that failed, after any
+	// retries.
+	droppedRowCounter = event.NewCounter("upload-rows-dropped", &event.MetricOptions{Namespace: metricNamespace})
+)
+
+// A retryPolicy describes how uploads that fail with a transient error
+// are retried.
+type retryPolicy struct {
+	// maxAttempts is the maximum number of times an upload is attempted.
+	// Values less than 1 mean 1.
+	maxAttempts int
+	// minBackoff is the wait before the first retry. Subsequent waits
+	// double, up to maxBackoff. A random fraction of up to half of each
+	// wait is removed, so that uploads that failed together are not
+	// retried together.
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+// uploadRetryPolicy is the retryPolicy of Client uploads.
+var uploadRetryPolicy = retryPolicy{
+	maxAttempts: 5,
+	minBackoff:  time.Second,
+	maxBackoff:  30 * time.Second,
+}
+
+// backoff returns the wait before the given retry (1 for the first retry).
+func (p retryPolicy) backoff(retry int) time.Duration {
+	d := p.minBackoff
+	for i := 1; i < retry && d < p.maxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, p.maxBackoff)
+	if d <= 0 {
+		return 0
+	}
+	return d - rand.N(d/2+1)
+}
+
+// do calls write, which uploads n rows to the table, until it succeeds,
+// fails with an error that is not transient, or has been called
+// p.maxAttempts times. It returns the last error of write.
+func (p retryPolicy) do(ctx context.Context, tableID string, n int, write func() error) error {
+	tableLabel := event.String("table", tableID)
+	for attempt := 1; ; attempt++ {
+		err := write()
+		if err == nil {
+			return nil
+		}
+		if !isTransientError(err) || attempt >= p.maxAttempts || ctx.Err() != nil {
+			droppedRowCounter.Record(ctx, int64(n), tableLabel)
+			return err
+		}
+		d := p.backoff(attempt)
+		log.Warnf(ctx, "uploading %d rows to %s: attempt %d failed: %v; retrying in %s", n, tableID, attempt, err, d)
+		timer := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			droppedRowCounter.Record(ctx, int64(n), tableLabel)
+			return errors.Join(err, ctx.Err())
+		case <-timer.C:
+		}
+		retriedRowCounter.Record(ctx, int64(n), tableLabel)
+	}
+}
+
+// commitOnce is like do, for a commit that must not be repeated once it
+// has succeeded. Before each retry of commit, it calls committed, which
+// reports whether an earlier attempt succeeded although it returned an
+// error, as when its response was lost. Errors from committed are retried
+// like those from commit.
+func (p retryPolicy) commitOnce(ctx context.Context, tableID string, n int, commit func() error, committed func() (bool, error)) error {
+	first := true
+	return p.do(ctx, tableID, n, func() error {
+		if !first {
+			done, err := committed()
+			if err != nil {
+				return err
+			}
+			if done {
+				return nil
+			}
+		}
+		first = false
+		return commit()
+	})
+}
+
+// isTransientError reports whether err, from a BigQuery API, is a failure
+// that retrying may fix: the service is unavailable or overloaded, or a
+// quota or rate limit is exhausted.
+func isTransientError(err error) bool {
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) {
+		switch gerr.Code {
+		case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+			http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		// BigQuery reports some exceeded quotas as Forbidden.
+		for _, e := range gerr.Errors {
+			if e.Reason == "rateLimitExceeded" || e.Reason == "quotaExceeded" || e.Reason == "backendError" {
+				return true
+			}
+		}
+		return false
+	}
+	if s, ok := status.FromError(err); ok {
+		switch s.Code() {
+		case codes.Unavailable, codes.ResourceExhausted, codes.Aborted, codes.Internal, codes.DeadlineExceeded:
+			return true
+		}
+	}
+	return false
+}
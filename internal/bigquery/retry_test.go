@@ -0,0 +1,127 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bigquery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestIsTransientError(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		want bool
+	}{
+		{status.Error(codes.Unavailable, "down"), true},
+		{fmt.Errorf("appending: %w", status.Error(codes.ResourceExhausted, "quota")), true},
+		{status.Error(codes.InvalidArgument, "bad row"), false},
+		{&googleapi.Error{Code: http.StatusServiceUnavailable}, true},
+		{&googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "quotaExceeded"}}}, true},
+		{&googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "accessDenied"}}}, false},
+		{&googleapi.Error{Code: http.StatusNotFound}, false},
+		{errors.New("no schema registered"), false},
+	} {
+		if got := isTransientError(test.err); got != test.want {
+			t.Errorf("isTransientError(%v) = %t, want %t", test.err, got, test.want)
+		}
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := retryPolicy{minBackoff: time.Second, maxBackoff: 10 * time.Second}
+	for _, test := range []struct {
+		retry int
+		max   time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{10, 10 * time.Second},
+	} {
+		for i := 0; i < 20; i++ {
+			if got := p.backoff(test.retry); got < test.max/2 || got > test.max {
+				t.Fatalf("backoff(%d) = %s, want between %s and %s", test.retry, got, test.max/2, test.max)
+			}
+		}
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	ctx := context.Background()
+	p := retryPolicy{maxAttempts: 3, minBackoff: time.Millisecond, maxBackoff: time.Millisecond}
+	unavailable := status.Error(codes.Unavailable, "down")
+	for _, test := range []struct {
+		name         string
+		errs         []error // returned by successive attempts; nil after
+		wantAttempts int
+		wantErr      bool
+	}{
+		{"success", nil, 1, false},
+		{"transient", []error{unavailable, unavailable}, 3, false},
+		{"gives up", []error{unavailable, unavailable, unavailable}, 3, true},
+		{"permanent", []error{errors.New("bad row")}, 1, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			attempts := 0
+			err := p.do(ctx, "table", 2, func() error {
+				attempts++
+				if attempts <= len(test.errs) {
+					return test.errs[attempts-1]
+				}
+				return nil
+			})
+			if attempts != test.wantAttempts {
+				t.Errorf("got %d attempts, want %d", attempts, test.wantAttempts)
+			}
+			if (err != nil) != test.wantErr {
+				t.Errorf("got error %v, want error: %t", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyCommitOnce(t *testing.T) {
+	ctx := context.Background()
+	p := retryPolicy{maxAttempts: 3, minBackoff: time.Millisecond, maxBackoff: time.Millisecond}
+	unavailable := status.Error(codes.Unavailable, "down")
+	for _, test := range []struct {
+		name        string
+		lostCommit  bool // whether the first commit succeeds despite its error
+		wantCommits int
+	}{
+		{"lost response", true, 1},
+		{"failed commit", false, 2},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			commits := 0
+			isCommitted := false
+			err := p.commitOnce(ctx, "table", 2,
+				func() error {
+					commits++
+					if commits == 1 {
+						isCommitted = test.lostCommit
+						return unavailable
+					}
+					isCommitted = true
+					return nil
+				},
+				func() (bool, error) { return isCommitted, nil })
+			if err != nil {
+				t.Fatal(err)
+			}
+			if commits != test.wantCommits {
+				t.Errorf("got %d commits, want %d", commits, test.wantCommits)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bigquery_test
+
+import (
+	"errors"
+	"flag"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	bq "cloud.google.com/go/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+
+	// Imported to register their tables.
+	_ "golang.org/x/pkgsite-metrics/internal/analysis"
+	_ "golang.org/x/pkgsite-metrics/internal/audit"
+	_ "golang.org/x/pkgsite-metrics/internal/govulncheck"
+	_ "golang.org/x/pkgsite-metrics/internal/jobs"
+	_ "golang.org/x/pkgsite-metrics/internal/vulndb"
+	_ "golang.org/x/pkgsite-metrics/internal/vulndbreqs"
+)
+
+var updateSchemas = flag.Bool("update-schemas", false,
+	"record the current table schemas in testdata/schemas, if the changes are supported")
+
+// TestSchemaChanges checks the schema of each table against the one
+// recorded in testdata/schemas, which is the last released schema.
+// Changes that BigQuery doesn't support on tables with data fail the test.
+// Supported changes fail it too, until they are recorded with -update-schemas.
+func TestSchemaChanges(t *testing.T) {
+	for _, name := range bigquery.TableNames() {
+		t.Run(name, func(t *testing.T) {
+			schema := bigquery.TableSchema(name)
+			golden := filepath.Join("testdata", "schemas", name+".json")
+			data, err := os.ReadFile(golden)
+			if errors.Is(err, fs.ErrNotExist) {
+				if *updateSchemas {
+					writeSchema(t, golden, schema)
+					return
+				}
+				t.Fatalf("no recorded schema for new table; run 'go test -run TestSchemaChanges -update-schemas' in this directory")
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			released, err := bq.SchemaFromJSON(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := bigquery.CheckSchemaChange(released, schema); err != nil {
+				t.Fatalf("unsupported change to the released schema (see the comment above the row type):\n%v", err)
+			}
+			if bigquery.SchemaVersion(released) == bigquery.SchemaVersion(schema) {
+				return
+			}
+			if *updateSchemas {
+				writeSchema(t, golden, schema)
+				return
+			}
+			t.Errorf("schema changed from\n%s\nto\n%s\nRun 'go test -run TestSchemaChanges -update-schemas' in this directory to record it.",
+				bigquery.SchemaString(released), bigquery.SchemaString(schema))
+		})
+	}
+}
+
+func writeSchema(t *testing.T, filename string, schema bq.Schema) {
+	t.Helper()
+	data, err := schema.ToJSONFields()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filename, append(data, '\n'), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("wrote %s", filename)
+}
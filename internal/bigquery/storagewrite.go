@@ -0,0 +1,413 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bigquery
+
+// Uploading rows with the BigQuery Storage Write API.
+//
+// Each upload writes to its own pending stream. Rows are appended at explicit
+// offsets, so a retried append cannot write a row twice, and they become
+// visible only when the stream is committed, so a failed upload writes
+// nothing. Rows are converted to protocol buffers using a descriptor derived
+// from the table schema registered with AddTable.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	"cloud.google.com/go/bigquery/storage/apiv1/storagepb"
+	"cloud.google.com/go/bigquery/storage/managedwriter"
+	"cloud.google.com/go/bigquery/storage/managedwriter/adapt"
+	"cloud.google.com/go/civil"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// maxAppendBytes bounds the size of a single AppendRows request.
+// The API limit is 10MB; leave room for the request overhead.
+const maxAppendBytes = 9 << 20
+
+// writeClient returns the Storage Write API client, creating it on first use.
+func (c *Client) writeClient() (*managedwriter.Client, error) {
+	c.writeOnce.Do(func() {
+		// The client outlives any single request, so don't tie it to a
+		// request's context.
+		c.writer, c.writeErr = managedwriter.NewClient(context.Background(), c.dataset.ProjectID)
+	})
+	return c.writer, c.writeErr
+}
+
+// write uploads rows to the table in a single pending stream, and commits
+// the stream once all rows have been appended. Either all rows are written
+// or none are. Appends and commits that fail with a transient error are
+// retried according to uploadRetryPolicy; see writeData.
+func (c *Client) write(ctx context.Context, tableID string, rows []Row, chunkSize int) (err error) {
+	defer derrors.Wrap(&err, "write(%q)", tableID)
+
+	if len(rows) == 0 {
+		return nil
+	}
+	// Encode all rows before opening a stream, so bad rows fail early.
+	data, err := encodeRows(tableID, rows)
+	if err != nil {
+		return err
+	}
+	return c.writeData(ctx, tableID, data, chunkSize)
+}
+
+// tableConverter returns a protoConverter for the schema registered for
+// the table.
+func tableConverter(tableID string) (*protoConverter, error) {
+	schema := TableSchema(tableID)
+	if schema == nil {
+		return nil, fmt.Errorf("no schema registered for table %q", tableID)
+	}
+	return newProtoConverter(schema)
+}
+
+// encodeRows encodes rows as protocol buffers for the table.
+func encodeRows(tableID string, rows []Row) ([][]byte, error) {
+	conv, err := tableConverter(tableID)
+	if err != nil {
+		return nil, err
+	}
+	data := make([][]byte, len(rows))
+	for i, r := range rows {
+		data[i], err = conv.encode(r)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", i, err)
+		}
+	}
+	return data, nil
+}
+
+// writeData is like write, but for rows already encoded by encodeRows.
+func (c *Client) writeData(ctx context.Context, tableID string, data [][]byte, chunkSize int) (err error) {
+	if len(data) == 0 {
+		return nil
+	}
+	conv, err := tableConverter(tableID)
+	if err != nil {
+		return err
+	}
+	wc, err := c.writeClient()
+	if err != nil {
+		return err
+	}
+	var stream string
+	err = uploadRetryPolicy.do(ctx, tableID, len(data), func() (err error) {
+		stream, err = c.appendToPendingStream(ctx, wc, conv, tableID, data, chunkSize)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return uploadRetryPolicy.commitOnce(ctx, tableID, len(data),
+		func() error { return commitStream(ctx, wc, stream) },
+		func() (bool, error) {
+			ws, err := wc.GetWriteStream(ctx, &storagepb.GetWriteStreamRequest{Name: stream})
+			if err != nil {
+				return false, err
+			}
+			return ws.GetCommitTime() != nil, nil
+		})
+}
+
+// appendToPendingStream appends data to a new pending stream of the table,
+// finalizes the stream and returns its name.
+func (c *Client) appendToPendingStream(ctx context.Context, wc *managedwriter.Client, conv *protoConverter, tableID string, data [][]byte, chunkSize int) (_ string, err error) {
+	ms, err := wc.NewManagedStream(ctx,
+		managedwriter.WithDestinationTable(managedwriter.TableParentFromParts(c.dataset.ProjectID, c.dataset.DatasetID, tableID)),
+		managedwriter.WithType(managedwriter.PendingStream),
+		managedwriter.WithSchemaDescriptor(conv.descProto),
+		managedwriter.EnableWriteRetries(true))
+	if err != nil {
+		return "", err
+	}
+	defer derrors.Cleanup(&err, ms.Close)
+
+	var results []*managedwriter.AppendResult
+	for _, chunk := range chunkData(data, chunkSize, maxAppendBytes) {
+		res, err := ms.AppendRows(ctx, chunk.rows, managedwriter.WithOffset(chunk.offset))
+		if err != nil {
+			return "", err
+		}
+		results = append(results, res)
+	}
+	for _, res := range results {
+		if _, err := res.GetResult(ctx); err != nil {
+			return "", err
+		}
+	}
+	if _, err := ms.Finalize(ctx); err != nil {
+		return "", err
+	}
+	return ms.StreamName(), nil
+}
+
+// commitStream commits the finalized pending stream.
+func commitStream(ctx context.Context, wc *managedwriter.Client, stream string) error {
+	resp, err := wc.BatchCommitWriteStreams(ctx, &storagepb.BatchCommitWriteStreamsRequest{
+		Parent:       managedwriter.TableParentFromStreamName(stream),
+		WriteStreams: []string{stream},
+	})
+	if err != nil {
+		return err
+	}
+	if serrs := resp.GetStreamErrors(); len(serrs) > 0 {
+		var errs []error
+		for _, se := range serrs {
+			errs = append(errs, fmt.Errorf("%s: %s: %s", se.GetEntity(), se.GetCode(), se.GetErrorMessage()))
+		}
+		return fmt.Errorf("committing stream: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// A dataChunk is a group of encoded rows sent in one AppendRows request,
+// along with the stream offset of its first row.
+type dataChunk struct {
+	offset int64
+	rows   [][]byte
+}
+
+// chunkData splits data into chunks of at most maxRows rows and at most
+// maxBytes bytes. A single row larger than maxBytes gets its own chunk.
+// If maxRows is <= 0, chunks are limited only by size.
+func chunkData(data [][]byte, maxRows, maxBytes int) []dataChunk {
+	var (
+		chunks []dataChunk
+		cur    dataChunk
+		size   int
+	)
+	for i, d := range data {
+		full := (maxRows > 0 && len(cur.rows) >= maxRows) || (len(cur.rows) > 0 && size+len(d) > maxBytes)
+		if full {
+			chunks = append(chunks, cur)
+			cur = dataChunk{offset: int64(i)}
+			size = 0
+		}
+		cur.rows = append(cur.rows, d)
+		size += len(d)
+	}
+	if len(cur.rows) > 0 {
+		chunks = append(chunks, cur)
+	}
+	return chunks
+}
+
+// A protoConverter converts rows of a table to protocol buffers
+// for the Storage Write API.
+type protoConverter struct {
+	schema    bq.Schema
+	desc      protoreflect.MessageDescriptor
+	descProto *descriptorpb.DescriptorProto
+}
+
+func newProtoConverter(schema bq.Schema) (_ *protoConverter, err error) {
+	defer derrors.Wrap(&err, "newProtoConverter")
+	ss, err := adapt.BQSchemaToStorageTableSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+	d, err := adapt.StorageSchemaToProto2Descriptor(ss, "root")
+	if err != nil {
+		return nil, err
+	}
+	md, ok := d.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("got descriptor of type %T, want message descriptor", d)
+	}
+	dp, err := adapt.NormalizeDescriptor(md)
+	if err != nil {
+		return nil, err
+	}
+	return &protoConverter{schema: schema, desc: md, descProto: dp}, nil
+}
+
+// encode converts row, which should be a struct pointer, to a serialized
+// protocol buffer. Struct fields are matched to schema columns as for
+// bq.StructSaver.
+func (pc *protoConverter) encode(row Row) ([]byte, error) {
+	vals, _, err := (&bq.StructSaver{Struct: row, Schema: pc.schema}).Save()
+	if err != nil {
+		return nil, err
+	}
+	msg := dynamicpb.NewMessage(pc.desc)
+	if err := setFields(msg, pc.schema, vals); err != nil {
+		return nil, err
+	}
+	return proto.Marshal(msg)
+}
+
+// setFields sets the fields of msg from vals, which maps the names of the
+// columns in schema to their values. Missing and null values are not set.
+func setFields(msg protoreflect.Message, schema bq.Schema, vals map[string]bq.Value) error {
+	fields := msg.Descriptor().Fields()
+	for _, fs := range schema {
+		v := vals[fs.Name]
+		if v == nil {
+			continue
+		}
+		// Proto field names are lower-cased column names.
+		fd := fields.ByName(protoreflect.Name(strings.ToLower(fs.Name)))
+		if fd == nil {
+			return fmt.Errorf("no proto field for column %q", fs.Name)
+		}
+		if fs.Repeated {
+			rv := reflect.ValueOf(v)
+			if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+				return fmt.Errorf("column %q: repeated value has type %T", fs.Name, v)
+			}
+			list := msg.Mutable(fd).List()
+			for i := 0; i < rv.Len(); i++ {
+				pv, ok, err := protoValue(fs, rv.Index(i).Interface(), func() protoreflect.Message {
+					return list.NewElement().Message()
+				})
+				if err != nil {
+					return fmt.Errorf("column %q[%d]: %w", fs.Name, i, err)
+				}
+				if ok {
+					list.Append(pv)
+				}
+			}
+			continue
+		}
+		pv, ok, err := protoValue(fs, v, func() protoreflect.Message {
+			return msg.NewField(fd).Message()
+		})
+		if err != nil {
+			return fmt.Errorf("column %q: %w", fs.Name, err)
+		}
+		if ok {
+			msg.Set(fd, pv)
+		}
+	}
+	return nil
+}
+
+// civilEpoch is the start of the day count for DATE columns.
+var civilEpoch = civil.Date{Year: 1970, Month: time.January, Day: 1}
+
+// protoValue converts a single (non-repeated) value of the column described
+// by fs to a proto value. Record values are converted to messages created
+// by newMessage. It returns false if v is null.
+func protoValue(fs *bq.FieldSchema, v bq.Value, newMessage func() protoreflect.Message) (protoreflect.Value, bool, error) {
+	switch x := v.(type) {
+	case nil:
+		return protoreflect.Value{}, false, nil
+	case bq.NullString:
+		if !x.Valid {
+			return protoreflect.Value{}, false, nil
+		}
+		v = x.StringVal
+	case bq.NullInt64:
+		if !x.Valid {
+			return protoreflect.Value{}, false, nil
+		}
+		v = x.Int64
+	case bq.NullFloat64:
+		if !x.Valid {
+			return protoreflect.Value{}, false, nil
+		}
+		v = x.Float64
+	case bq.NullBool:
+		if !x.Valid {
+			return protoreflect.Value{}, false, nil
+		}
+		v = x.Bool
+	case bq.NullTimestamp:
+		if !x.Valid {
+			return protoreflect.Value{}, false, nil
+		}
+		v = x.Timestamp
+	case bq.NullDate:
+		if !x.Valid {
+			return protoreflect.Value{}, false, nil
+		}
+		v = x.Date
+	case bq.NullTime:
+		if !x.Valid {
+			return protoreflect.Value{}, false, nil
+		}
+		v = x.Time
+	}
+
+	bad := func() (protoreflect.Value, bool, error) {
+		return protoreflect.Value{}, false, fmt.Errorf("cannot convert %T to %s", v, fs.Type)
+	}
+	rv := reflect.ValueOf(v)
+	switch fs.Type {
+	case bq.RecordFieldType:
+		m, ok := v.(map[string]bq.Value)
+		if !ok {
+			return bad()
+		}
+		msg := newMessage()
+		if err := setFields(msg, fs.Schema, m); err != nil {
+			return protoreflect.Value{}, false, err
+		}
+		return protoreflect.ValueOfMessage(msg), true, nil
+	case bq.StringFieldType:
+		if rv.Kind() != reflect.String {
+			return bad()
+		}
+		return protoreflect.ValueOfString(rv.String()), true, nil
+	case bq.IntegerFieldType:
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return protoreflect.ValueOfInt64(rv.Int()), true, nil
+		case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+			return protoreflect.ValueOfInt64(int64(rv.Uint())), true, nil
+		}
+		return bad()
+	case bq.FloatFieldType:
+		switch rv.Kind() {
+		case reflect.Float32, reflect.Float64:
+			return protoreflect.ValueOfFloat64(rv.Float()), true, nil
+		}
+		return bad()
+	case bq.BooleanFieldType:
+		if rv.Kind() != reflect.Bool {
+			return bad()
+		}
+		return protoreflect.ValueOfBool(rv.Bool()), true, nil
+	case bq.TimestampFieldType:
+		t, ok := v.(time.Time)
+		if !ok {
+			return bad()
+		}
+		// Timestamps are microseconds since the Unix epoch.
+		return protoreflect.ValueOfInt64(t.UnixMicro()), true, nil
+	case bq.DateFieldType:
+		d, ok := v.(civil.Date)
+		if !ok {
+			return bad()
+		}
+		// Dates are days since the Unix epoch.
+		return protoreflect.ValueOfInt32(int32(d.DaysSince(civilEpoch))), true, nil
+	case bq.TimeFieldType:
+		t, ok := v.(civil.Time)
+		if !ok {
+			return bad()
+		}
+		return protoreflect.ValueOfInt64(encodeCivilTime(t)), true, nil
+	default:
+		return protoreflect.Value{}, false, fmt.Errorf("unsupported column type %s", fs.Type)
+	}
+}
+
+// encodeCivilTime encodes t in the packed 64-bit format the Storage Write API
+// uses for TIME columns: hour, minute, second and microsecond in bit fields.
+func encodeCivilTime(t civil.Time) int64 {
+	return int64(t.Hour)<<32 | int64(t.Minute)<<26 | int64(t.Second)<<20 | int64(t.Nanosecond/1000)
+}
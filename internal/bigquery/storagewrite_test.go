@@ -0,0 +1,130 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bigquery
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+type testInner struct {
+	Name  string `bigquery:"name"`
+	Count int    `bigquery:"count"`
+}
+
+type testRow struct {
+	Str       string        `bigquery:"str"`
+	Int       int           `bigquery:"int"`
+	Float     float64       `bigquery:"float"`
+	Bool      bool          `bigquery:"bool"`
+	Created   time.Time     `bigquery:"created"`
+	Date      civil.Date    `bigquery:"date"`
+	NullStr   bq.NullString `bigquery:"null_str"`
+	NullInt   bq.NullInt64  `bigquery:"null_int"`
+	Strs      []string      `bigquery:"strs"`
+	Inner     testInner     `bigquery:"inner"`
+	Inners    []*testInner  `bigquery:"inners"`
+	EmptyList []*testInner  `bigquery:"empty_list"`
+}
+
+func (r *testRow) SetUploadTime(t time.Time) { r.Created = t }
+
+func TestProtoConverter(t *testing.T) {
+	schema, err := InferSchema(testRow{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc, err := newProtoConverter(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := &testRow{
+		Str:     "s",
+		Int:     7,
+		Float:   1.5,
+		Bool:    true,
+		Created: time.Date(1970, time.January, 1, 0, 0, 1, 500000, time.UTC),
+		Date:    civil.Date{Year: 1970, Month: time.January, Day: 3},
+		NullInt: NullInt(3),
+		Strs:    []string{"a", "b"},
+		Inner:   testInner{Name: "i", Count: 1},
+		Inners:  []*testInner{{Name: "x", Count: 2}, {Name: "y"}},
+	}
+	data, err := pc.encode(row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := dynamicpb.NewMessage(pc.desc)
+	if err := proto.Unmarshal(data, msg); err != nil {
+		t.Fatal(err)
+	}
+	js, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got, want map[string]any
+	if err := json.Unmarshal(js, &got); err != nil {
+		t.Fatal(err)
+	}
+	// protojson writes 64-bit integers as strings.
+	if err := json.Unmarshal([]byte(`{
+		"str": "s",
+		"int": "7",
+		"float": 1.5,
+		"bool": true,
+		"created": "1000500",
+		"date": 2,
+		"null_int": "3",
+		"strs": ["a", "b"],
+		"inner": {"name": "i", "count": "1"},
+		"inners": [{"name": "x", "count": "2"}, {"name": "y", "count": "0"}]
+	}`), &want); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestEncodeCivilTime(t *testing.T) {
+	got := encodeCivilTime(civil.Time{Hour: 12, Minute: 34, Second: 56, Nanosecond: 789000})
+	const want = 12<<32 | 34<<26 | 56<<20 | 789
+	if got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
+
+func TestChunkData(t *testing.T) {
+	data := [][]byte{[]byte("aa"), []byte("bb"), []byte("cccc"), []byte("d"), []byte("e")}
+	sizes := func(chunks []dataChunk) [][]int64 {
+		var r [][]int64
+		for _, c := range chunks {
+			r = append(r, []int64{c.offset, int64(len(c.rows))})
+		}
+		return r
+	}
+	for _, test := range []struct {
+		maxRows, maxBytes int
+		want              [][]int64 // offset, number of rows
+	}{
+		{0, 100, [][]int64{{0, 5}}},
+		{2, 100, [][]int64{{0, 2}, {2, 2}, {4, 1}}},
+		{0, 4, [][]int64{{0, 2}, {2, 1}, {3, 2}}},
+		{0, 1, [][]int64{{0, 1}, {1, 1}, {2, 1}, {3, 1}, {4, 1}}},
+	} {
+		got := sizes(chunkData(data, test.maxRows, test.maxBytes))
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("maxRows=%d, maxBytes=%d: mismatch (-want, +got):\n%s", test.maxRows, test.maxBytes, diff)
+		}
+	}
+}
@@ -5,9 +5,15 @@
 package buildbinary
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io/fs"
+	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
 	"time"
 
@@ -18,43 +24,227 @@ type BinaryInfo struct {
 	BinaryPath string
 	ImportPath string
 	BuildTime  time.Duration
-	Error      error
+	// BuildMemory is the peak memory (RSS) used by the go command, in KB.
+	// It includes the compiler and linker processes that the go command ran.
+	BuildMemory uint64
+	// BinarySize is the size of the binary, in bytes.
+	BinarySize int64
+	// ArtifactsSize is the size of the intermediate artifacts, like
+	// compiled packages, that the build wrote to its work directory, in
+	// bytes. Artifacts reused from the build cache are not included.
+	ArtifactsSize int64
+	// Cached reports whether the binary was taken from a Cache instead of
+	// being built. The build statistics of a cached binary are zero,
+	// except for its size.
+	Cached bool
+	Error  error
 }
 
-// FindAndBuildBinaries finds and builds all possible binaries from a given module.
-func FindAndBuildBinaries(modulePath string) (binaries []*BinaryInfo, err error) {
+// A Selection limits the binaries that FindAndBuildBinaries builds.
+// The zero Selection selects all of them.
+type Selection struct {
+	// Packages, if not empty, are the import paths of the main packages
+	// to build. Those that are not main packages of the module are ignored.
+	Packages []string
+	// Max, if positive, is the maximum number of binaries to build.
+	// The most likely to be real programs are chosen first; see rankBinaries.
+	Max int
+}
+
+// String describes s, for recording alongside results. It returns the empty
+// string for the zero Selection.
+func (s Selection) String() string {
+	var parts []string
+	if len(s.Packages) > 0 {
+		parts = append(parts, "packages="+strings.Join(s.Packages, ","))
+	}
+	if s.Max > 0 {
+		parts = append(parts, fmt.Sprintf("max=%d", s.Max))
+	}
+	return strings.Join(parts, " ")
+}
+
+// Select returns the import paths of the main packages in targets
+// that s selects.
+func (s Selection) Select(targets []string) []string {
+	if len(s.Packages) > 0 {
+		var sel []string
+		for _, t := range targets {
+			if slices.Contains(s.Packages, t) {
+				sel = append(sel, t)
+			}
+		}
+		targets = sel
+	}
+	if s.Max > 0 && len(targets) > s.Max {
+		targets = rankBinaries(targets)[:s.Max]
+	}
+	return targets
+}
+
+// FindAndBuildBinaries finds the binaries of a given module and builds
+// those that sel selects. It also returns the number of binaries found.
+// If cache is not nil, binaries in it are used instead of being built, and
+// the binaries that are built are added to it.
+func FindAndBuildBinaries(modulePath string, sel Selection, cache *Cache) (binaries []*BinaryInfo, numFound int, err error) {
 	defer derrors.Wrap(&err, "FindAndBuildBinaries")
 	buildTargets, err := findBinaries(modulePath)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
+	numFound = len(buildTargets)
 
-	for i, target := range buildTargets {
-		path, buildTime, err := runBuild(modulePath, target, i)
-		b := &BinaryInfo{
-			BinaryPath: path,
-			ImportPath: target,
-			BuildTime:  buildTime,
-		}
-		if err != nil {
-			b.Error = err
+	for i, target := range sel.Select(buildTargets) {
+		b := cache.get(target)
+		if b == nil {
+			b, err = runBuild(modulePath, target, i)
+			if err != nil {
+				if b == nil {
+					b = &BinaryInfo{}
+				}
+				b.Error = err
+			} else {
+				// A binary that cannot be cached is just built again
+				// next time.
+				_ = cache.put(target, b.BinaryPath)
+			}
 		}
+		b.ImportPath = target
 		binaries = append(binaries, b)
 	}
-	return binaries, nil
+	return binaries, numFound, nil
+}
+
+// nonProgramElems are path elements that suggest a main package is not a
+// real program of its module, but an example, test helper or tool for
+// developing the module.
+var nonProgramElems = map[string]bool{
+	"bench":      true,
+	"benchmark":  true,
+	"benchmarks": true,
+	"demo":       true,
+	"demos":      true,
+	"example":    true,
+	"examples":   true,
+	"_example":   true,
+	"_examples":  true,
+	"hack":       true,
+	"internal":   true,
+	"sample":     true,
+	"samples":    true,
+	"scripts":    true,
+	"test":       true,
+	"testdata":   true,
+	"testing":    true,
+	"tests":      true,
+	"tools":      true,
+}
+
+// rankBinaries returns the import paths of main packages sorted from the
+// most to the least likely to be real programs: first by the number of
+// their path elements that suggest otherwise, then by depth, so that
+// the module root and cmd/X come before deeper packages, then by path.
+func rankBinaries(importPaths []string) []string {
+	penalty := func(p string) int {
+		n := 0
+		for _, e := range strings.Split(p, "/") {
+			if nonProgramElems[e] {
+				n++
+			}
+		}
+		return n
+	}
+	ranked := slices.Clone(importPaths)
+	sort.Slice(ranked, func(i, j int) bool {
+		pi, pj := ranked[i], ranked[j]
+		if a, b := penalty(pi), penalty(pj); a != b {
+			return a < b
+		}
+		if a, b := strings.Count(pi, "/"), strings.Count(pj, "/"); a != b {
+			return a < b
+		}
+		return pi < pj
+	})
+	return ranked
 }
 
-// runBuild takes a given module and import path and attempts to build a binary
-func runBuild(modulePath, importPath string, i int) (binaryPath string, buildTime time.Duration, err error) {
+// runBuild takes a given module and import path and attempts to build a binary.
+// It returns the path of the binary and statistics about the build.
+// If the build fails, it returns the statistics of the failed build with
+// the error.
+func runBuild(modulePath, importPath string, i int) (_ *BinaryInfo, err error) {
 	binName := fmt.Sprintf("bin%d", i)
-	cmd := exec.Command("go", "build", "-C", modulePath, "-o", binName, importPath)
+	// With -work, the go command prints the location of its work
+	// directory and does not remove it, so we can measure it.
+	cmd := exec.Command("go", "build", "-C", modulePath, "-work", "-o", binName, importPath)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
 	start := time.Now()
-	if err = cmd.Run(); err != nil {
-		return "", 0, err
+	err = cmd.Run()
+	buildTime := time.Since(start)
+	workDir := parseWorkDir(stderr.Bytes())
+	if workDir != "" {
+		defer os.RemoveAll(workDir)
+	}
+	if err != nil {
+		return &BinaryInfo{BuildTime: buildTime, BuildMemory: getMemoryUsage(cmd)}, err
+	}
+	binaryPath := filepath.Join(modulePath, binName)
+	info, err := os.Stat(binaryPath)
+	if err != nil {
+		return nil, err
 	}
-	buildTime = time.Since(start)
-	binaryPath = filepath.Join(modulePath, binName)
-	return binaryPath, buildTime, nil
+	b := &BinaryInfo{
+		BinaryPath:  binaryPath,
+		BuildTime:   buildTime,
+		BuildMemory: getMemoryUsage(cmd),
+		BinarySize:  info.Size(),
+	}
+	if workDir != "" {
+		b.ArtifactsSize, err = dirSize(workDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// getMemoryUsage is overridden with a Unix-specific function.
+var getMemoryUsage = func(c *exec.Cmd) uint64 {
+	return 0
+}
+
+// parseWorkDir returns the work directory that the go command printed
+// to stderr when run with -work, or "" if there is none.
+func parseWorkDir(stderr []byte) string {
+	for _, line := range bytes.Split(stderr, []byte("\n")) {
+		if dir, ok := bytes.CutPrefix(line, []byte("WORK=")); ok {
+			return string(bytes.TrimSpace(dir))
+		}
+	}
+	return ""
+}
+
+// dirSize returns the total size of the regular files under dir.
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
 }
 
 // findBinaries finds all packages that compile to binaries in a given directory
@@ -7,6 +7,7 @@ package buildbinary
 import (
 	"os"
 	"path/filepath"
+	"runtime"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -69,7 +70,7 @@ func TestRunBuild(t *testing.T) {
 		name       string
 		modulePath string
 		importPath string
-		want       string
+		want       string // empty if the build fails
 		wantErr    bool
 	}{
 		{
@@ -84,22 +85,99 @@ func TestRunBuild(t *testing.T) {
 			importPath: "example.com/test/multipleBinModule",
 			want:       filepath.Join(localTestData, "multipleBinModule", "bin1"),
 		},
+		{
+			name:       "build error",
+			modulePath: filepath.Join(localTestData, "module"),
+			importPath: "./nosuchdir",
+			wantErr:    true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, _, err := runBuild(tt.modulePath, tt.importPath, 1)
-			defer os.Remove(got)
+			b, err := runBuild(tt.modulePath, tt.importPath, 1)
 			if (err != nil) != tt.wantErr {
 				t.Fatalf("got error=%v; wantErr=%v", err, tt.wantErr)
 			}
+			// Failed builds have their statistics too.
+			if b.BuildTime == 0 {
+				t.Error("got zero build time")
+			}
+			if tt.wantErr {
+				return
+			}
+			got := b.BinaryPath
+			defer os.Remove(got)
 
 			if diff := cmp.Diff(tt.want, got); diff != "" {
 				t.Errorf("mismatch (-want, +got):%s", diff)
 			}
-			_, err = os.Stat(got)
+			info, err := os.Stat(got)
 			if err != nil && os.IsNotExist(err) {
-				t.Errorf("did not produce the expected binary")
+				t.Fatalf("did not produce the expected binary")
+			}
+			if b.BinarySize != info.Size() {
+				t.Errorf("got binary size %d, want %d", b.BinarySize, info.Size())
+			}
+			if runtime.GOOS == "linux" && b.BuildMemory == 0 {
+				t.Error("got zero build memory")
 			}
 		})
 	}
 }
+
+func TestParseWorkDir(t *testing.T) {
+	for _, tt := range []struct {
+		stderr string
+		want   string
+	}{
+		{"WORK=/tmp/go-build123\n", "/tmp/go-build123"},
+		{"go: downloading example.com/m v1.0.0\nWORK=/tmp/go-build4\n", "/tmp/go-build4"},
+		{"", ""},
+		{"error\n", ""},
+	} {
+		if got := parseWorkDir([]byte(tt.stderr)); got != tt.want {
+			t.Errorf("parseWorkDir(%q) = %q, want %q", tt.stderr, got, tt.want)
+		}
+	}
+}
+
+func TestSelection(t *testing.T) {
+	targets := []string{
+		"example.com/m/examples/hello",
+		"example.com/m/cmd/tool",
+		"example.com/m",
+		"example.com/m/internal/gen",
+		"example.com/m/cmd/other/sub",
+		"example.com/m/cmd/another",
+	}
+	for _, tt := range []struct {
+		sel     Selection
+		want    []string
+		wantStr string
+	}{
+		{Selection{}, targets, ""},
+		{
+			Selection{Max: 3},
+			[]string{"example.com/m", "example.com/m/cmd/another", "example.com/m/cmd/tool"},
+			"max=3",
+		},
+		{
+			Selection{Packages: []string{"example.com/m/cmd/tool", "example.com/m/internal/gen", "example.com/other"}},
+			[]string{"example.com/m/cmd/tool", "example.com/m/internal/gen"},
+			"packages=example.com/m/cmd/tool,example.com/m/internal/gen,example.com/other",
+		},
+		{
+			Selection{Packages: []string{"example.com/m/examples/hello", "example.com/m/cmd/other/sub"}, Max: 1},
+			[]string{"example.com/m/cmd/other/sub"},
+			"packages=example.com/m/examples/hello,example.com/m/cmd/other/sub max=1",
+		},
+		{Selection{Max: 10}, targets, "max=10"},
+	} {
+		if diff := cmp.Diff(tt.want, tt.sel.Select(targets)); diff != "" {
+			t.Errorf("%+v: mismatch (-want, +got):%s", tt.sel, diff)
+		}
+		if got := tt.sel.String(); got != tt.wantStr {
+			t.Errorf("%+v: String() = %q, want %q", tt.sel, got, tt.wantStr)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build unix
+
+package buildbinary
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+func init() {
+	// The Maxrss of a process reported by wait4 is the largest of its
+	// own and those of its waited-for descendants.
+	getMemoryUsage = func(c *exec.Cmd) uint64 {
+		return uint64(c.ProcessState.SysUsage().(*syscall.Rusage).Maxrss)
+	}
+}
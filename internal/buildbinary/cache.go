@@ -0,0 +1,101 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package buildbinary
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// A Cache holds binaries of a module that were built earlier, so that they
+// need not be built again. Each binary is a file of Dir named by CacheKey.
+// The binaries of different modules, or versions of a module, must be
+// cached in different directories.
+type Cache struct {
+	Dir string
+	// GoVersion is the version of the go command that builds the
+	// binaries, like "go1.22.3".
+	GoVersion string
+}
+
+// CacheKey returns the name of the file that caches the binary of the main
+// package importPath built with goVersion.
+func CacheKey(importPath, goVersion string) string {
+	h := sha256.Sum256([]byte(importPath + "\x00" + goVersion))
+	return hex.EncodeToString(h[:16])
+}
+
+// GoVersion returns the version of the go command, like "go1.22.3".
+func GoVersion() (string, error) {
+	out, err := exec.Command("go", "env", "GOVERSION").Output()
+	if err != nil {
+		return "", err
+	}
+	v := strings.TrimSpace(string(out))
+	if v == "" {
+		return "", errors.New("go env GOVERSION: empty output")
+	}
+	return v, nil
+}
+
+// file returns the name of the file that caches the binary of importPath.
+func (c *Cache) file(importPath string) string {
+	return filepath.Join(c.Dir, CacheKey(importPath, c.GoVersion))
+}
+
+// get returns the cached binary of importPath, or nil if there is none.
+func (c *Cache) get(importPath string) *BinaryInfo {
+	if c == nil {
+		return nil
+	}
+	file := c.file(importPath)
+	info, err := os.Stat(file)
+	if err != nil || !info.Mode().IsRegular() {
+		return nil
+	}
+	return &BinaryInfo{
+		BinaryPath: file,
+		BinarySize: info.Size(),
+		Cached:     true,
+	}
+}
+
+// put adds the binary of importPath at binaryPath to the cache.
+func (c *Cache) put(importPath, binaryPath string) (err error) {
+	if c == nil {
+		return nil
+	}
+	src, err := os.Open(binaryPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if err := os.MkdirAll(c.Dir, 0o755); err != nil {
+		return err
+	}
+	// Write to a temporary file and rename, so that a failed copy doesn't
+	// leave a truncated binary in the cache.
+	tmp, err := os.CreateTemp(c.Dir, "tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}()
+	_, err = io.Copy(tmp, src)
+	err = errors.Join(err, tmp.Chmod(0o755), tmp.Close())
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.file(importPath))
+}
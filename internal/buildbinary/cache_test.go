@@ -0,0 +1,78 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package buildbinary
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCacheKey(t *testing.T) {
+	k := CacheKey("example.com/cmd/x", "go1.22.3")
+	if k != CacheKey("example.com/cmd/x", "go1.22.3") {
+		t.Error("key is not deterministic")
+	}
+	for _, other := range []string{CacheKey("example.com/cmd/y", "go1.22.3"), CacheKey("example.com/cmd/x", "go1.22.4")} {
+		if other == k {
+			t.Errorf("got the same key %s for a different package or Go version", k)
+		}
+	}
+}
+
+func TestCache(t *testing.T) {
+	c := &Cache{Dir: filepath.Join(t.TempDir(), "cache"), GoVersion: "go1.22.3"}
+	if b := c.get("example.com/cmd/x"); b != nil {
+		t.Fatalf("got %+v from empty cache, want nil", b)
+	}
+	bin := filepath.Join(t.TempDir(), "bin0")
+	if err := os.WriteFile(bin, []byte("binary"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.put("example.com/cmd/x", bin); err != nil {
+		t.Fatal(err)
+	}
+	b := c.get("example.com/cmd/x")
+	if b == nil || !b.Cached || b.BinarySize != int64(len("binary")) {
+		t.Fatalf("got %+v, want the cached binary", b)
+	}
+	if data, err := os.ReadFile(b.BinaryPath); err != nil || string(data) != "binary" {
+		t.Errorf("got %q, %v; want the binary's contents", data, err)
+	}
+	// The binary of another Go version is not cached.
+	other := &Cache{Dir: c.Dir, GoVersion: "go1.23.0"}
+	if b := other.get("example.com/cmd/x"); b != nil {
+		t.Errorf("got %+v for another Go version, want nil", b)
+	}
+	// A nil Cache holds nothing.
+	var nilCache *Cache
+	if b := nilCache.get("example.com/cmd/x"); b != nil || nilCache.put("example.com/cmd/x", bin) != nil {
+		t.Error("nil Cache is not empty")
+	}
+}
+
+func TestFindAndBuildBinariesCache(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test that builds binaries in short mode")
+	}
+	c := &Cache{Dir: t.TempDir(), GoVersion: "go1.22.3"}
+	dir := filepath.Join(localTestData, "module")
+	for _, wantCached := range []bool{false, true} {
+		bins, _, err := FindAndBuildBinaries(dir, Selection{}, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(bins) != 1 || bins[0].Error != nil {
+			t.Fatalf("got %+v, want one binary", bins)
+		}
+		b := bins[0]
+		if !b.Cached {
+			os.Remove(b.BinaryPath)
+		}
+		if b.Cached != wantCached || b.BinarySize == 0 {
+			t.Errorf("got %+v, want cached %t", b, wantCached)
+		}
+	}
+}
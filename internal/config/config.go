@@ -17,6 +17,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/safehtml/template"
 	"golang.org/x/net/context/ctxhttp"
@@ -58,6 +59,20 @@ type Config struct {
 	// QueueName is the name of the Cloud Tasks queue.
 	QueueName string
 
+	// HighPriorityQueueName and LowPriorityQueueName are the names of the
+	// Cloud Tasks queues for high- and low-priority tasks. If empty,
+	// those tasks go on QueueName.
+	HighPriorityQueueName string
+	LowPriorityQueueName  string
+
+	// Queues, if non-empty, are the Cloud Tasks queues for normal-priority
+	// tasks, possibly in several locations, used instead of QueueName.
+	// Tasks are spread over them in proportion to their weights, so that
+	// large jobs are not throttled by the capacity of a single region.
+	// High- and low-priority tasks without queues of their own go on them
+	// as well.
+	Queues []QueueConfig
+
 	// QueueURL is the URL that the Cloud Tasks queue should send requests to.
 	// It should be used when the worker is not on AppEngine.
 	QueueURL string
@@ -66,6 +81,10 @@ type Config struct {
 	// when running locally.
 	LocalQueueWorkers int
 
+	// LocalQueueMaxAttempts is the maximum number of times a task is run,
+	// when running locally. If zero, queue.DefaultRetryPolicy is used.
+	LocalQueueMaxAttempts int
+
 	// MonitoredResource represents the resource that is running the current binary.
 	// It might be a Google AppEngine app, a Cloud Run service, or a Kubernetes pod.
 	// See https://cloud.google.com/monitoring/api/resources for more details:
@@ -77,10 +96,26 @@ type Config struct {
 	// DevMode indicates whether the server is running in development mode.
 	DevMode bool
 
+	// LocalDir, if non-empty, runs the worker end to end on the local
+	// machine. Results are written under LocalDir instead of to BigQuery,
+	// analysis binaries are read from LocalDir instead of BinaryBucket,
+	// and enqueued tasks are sent to LocalURL. Firestore must be provided
+	// by the emulator.
+	LocalDir string
+
+	// LocalURL is the URL of the worker in local mode.
+	LocalURL string
+
 	// VulnDBBucketProjectID is the project ID for the vuln DB bucket and its
 	// associated load balancer.
 	VulnDBBucketProjectID string
 
+	// VulnDBExclusions is a local file or gs:// URL listing the user agents
+	// and IP ranges of requests that are not counted as vuln DB requests,
+	// like those of monitoring probes and crawlers. If empty, all requests
+	// are counted.
+	VulnDBExclusions string
+
 	// BinaryBucket holds binaries for govulncheck scanning.
 	BinaryBucket string
 
@@ -90,6 +125,23 @@ type Config struct {
 	// VulnDBDir is the local directory of the vulnerability database.
 	VulnDBDir string
 
+	// VulnDBSnapshots is the gs:// URL of the directory holding dated
+	// snapshots of the vulnerability database, like
+	// gs://go-vulndb-snapshots. A scan can ask for the snapshot of a date,
+	// like 2024-01-01, instead of the database in VulnDBDir.
+	VulnDBSnapshots string
+
+	// CompareBinaryCache is the gs:// URL of the directory that caches
+	// the binaries built by compare-mode govulncheck scans, so that scans
+	// of the same module version do not build them again. If empty,
+	// binaries are not cached.
+	CompareBinaryCache string
+
+	// CorpusTables are the full names of the BigQuery tables and views,
+	// like project.dataset.view, that enqueues can read their modules
+	// from with the corpusquery parameter. Enqueues cannot name others.
+	CorpusTables []string
+
 	// PkgsiteDBHost is the host of the pkgsite db used to find modules to scan.
 	PkgsiteDBHost string
 	// PkgsiteDBPort is the port of the pkgsite db used to find modules to scan.
@@ -106,6 +158,136 @@ type Config struct {
 
 	// ProxyURL is the url for the Go module proxy.
 	ProxyURL string
+
+	// SumDBURL is the url of the checksum database, sum.golang.org, or of
+	// a proxy for it, against which downloaded modules are verified.
+	// If "off", modules are not verified.
+	SumDBURL string
+
+	// ScanDiskQuotaMB is the disk space, in megabytes, that a single
+	// scan may use. If zero, there is no limit.
+	ScanDiskQuotaMB int
+
+	// AnalysisBatchThresholdMB is the size, in megabytes, of a module's
+	// source above which analysis binaries are run on batches of its
+	// packages instead of all of them at once. If zero, modules are never
+	// split automatically.
+	AnalysisBatchThresholdMB int
+
+	// GoBuildCacheLimitMB and GoModCacheLimitMB are the sizes, in
+	// megabytes, above which the Go build and module caches are cleaned
+	// when no scans are running. If zero, the cache is cleaned whenever
+	// no scans are running.
+	GoBuildCacheLimitMB int
+	GoModCacheLimitMB   int
+
+	// MaxAnalysisScans and MaxGovulncheckScans are the maximum numbers of
+	// analysis and govulncheck scans that run concurrently on an instance.
+	// Scan requests beyond them are rejected, to be retried later. If zero,
+	// there is no limit other than the dynamic MaxConcurrency.
+	MaxAnalysisScans    int
+	MaxGovulncheckScans int
+
+	// UploadBufferRows and UploadBufferAge control the batching of result
+	// rows written to BigQuery: the rows collected by the worker are
+	// written when there are UploadBufferRows of them, or when the oldest
+	// is UploadBufferAge old, and requests wait for their rows to be
+	// written. If UploadBufferRows is zero, rows are written by the
+	// request that computes them.
+	UploadBufferRows int
+	UploadBufferAge  time.Duration
+
+	// ProxyCacheDir is the local directory where the .info and .mod
+	// responses of the module proxy are cached, so that they survive the
+	// frequent restarts of the worker. If empty, the default, they are not
+	// cached on disk. The cache is kept below ProxyCacheLimitMB megabytes,
+	// and its entries expire after ProxyCacheTTL. On Cloud Run the local
+	// file system, including /tmp, is in memory, so the cache takes up to
+	// ProxyCacheLimitMB of the worker's memory, which the scans share.
+	ProxyCacheDir     string
+	ProxyCacheLimitMB int
+	ProxyCacheTTL     time.Duration
+
+	// SoftSkipAfter is the number of consecutive pathological failures,
+	// like timeouts, running out of memory and panics, of the scans of a
+	// module version by an analysis binary after which jobs running that
+	// binary skip it for SoftSkipFor. If zero, the default, modules are
+	// never skipped for failing.
+	SoftSkipAfter int
+	SoftSkipFor   time.Duration
+
+	// NotifyEmailURL is the URL of the email provider that job completion
+	// notifications to mailto: addresses are POSTed to. If empty, jobs
+	// cannot notify email addresses.
+	NotifyEmailURL string
+
+	// JobStaleAfter is how long a job can go without updates before
+	// /jobs/reap marks it stale and finalizes it.
+	JobStaleAfter time.Duration
+
+	// Retention maps BigQuery table names to how long their rows are kept.
+	// Rows of other tables are kept forever.
+	Retention map[string]time.Duration
+}
+
+// A QueueConfig describes one of several Cloud Tasks queues.
+type QueueConfig struct {
+	Location string // location (region) of the queue
+	Name     string
+	Weight   int // relative share of tasks; at least 1
+}
+
+// ParseQueues parses a comma-separated list of queues, each of the form
+// LOCATION/NAME or LOCATION/NAME:WEIGHT. The default weight is 1.
+func ParseQueues(s string) (_ []QueueConfig, err error) {
+	defer derrors.Wrap(&err, "ParseQueues(%q)", s)
+	var qs []QueueConfig
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		q := QueueConfig{Weight: 1}
+		f, weight, ok := strings.Cut(f, ":")
+		if ok {
+			q.Weight, err = strconv.Atoi(weight)
+			if err != nil || q.Weight < 1 {
+				return nil, fmt.Errorf("bad weight %q for queue %s", weight, f)
+			}
+		}
+		q.Location, q.Name, ok = strings.Cut(f, "/")
+		if !ok || q.Location == "" || q.Name == "" || strings.Contains(q.Name, "/") {
+			return nil, fmt.Errorf("queue %q is not of the form LOCATION/NAME", f)
+		}
+		qs = append(qs, q)
+	}
+	return qs, nil
+}
+
+// ParseRetention parses a comma-separated list of retention periods, each
+// of the form TABLE:DAYS.
+func ParseRetention(s string) (_ map[string]time.Duration, err error) {
+	defer derrors.Wrap(&err, "ParseRetention(%q)", s)
+	r := map[string]time.Duration{}
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		table, days, ok := strings.Cut(f, ":")
+		if !ok || table == "" {
+			return nil, fmt.Errorf("retention %q is not of the form TABLE:DAYS", f)
+		}
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("bad number of days %q for table %s", days, table)
+		}
+		if _, ok := r[table]; ok {
+			return nil, fmt.Errorf("duplicate retention for table %s", table)
+		}
+		r[table] = time.Duration(n) * 24 * time.Hour
+	}
+	return r, nil
 }
 
 // Init resolves all configuration values provided by the config package. It
@@ -120,24 +302,56 @@ func Init(ctx context.Context) (_ *Config, err error) {
 		ts = template.TrustedSourceFromFlag(f.Value)
 	}
 	cfg := &Config{
-		ProjectID:             os.Getenv("GOOGLE_CLOUD_PROJECT"),
-		ServiceID:             os.Getenv("GO_ECOSYSTEM_SERVICE_ID"),
-		VersionID:             os.Getenv("DOCKER_IMAGE"),
-		LocationID:            "us-central1",
-		StaticPath:            ts,
-		BigQueryDataset:       GetEnv("GO_ECOSYSTEM_BIGQUERY_DATASET", "disable"),
-		QueueName:             os.Getenv("GO_ECOSYSTEM_QUEUE_NAME"),
-		QueueURL:              os.Getenv("GO_ECOSYSTEM_QUEUE_URL"),
-		VulnDBBucketProjectID: os.Getenv("GO_ECOSYSTEM_VULNDB_BUCKET_PROJECT"),
-		BinaryBucket:          os.Getenv("GO_ECOSYSTEM_BINARY_BUCKET"),
-		BinaryDir:             GetEnv("GO_ECOSYSTEM_BINARY_DIR", "/tmp/binaries"),
-		VulnDBDir:             GetEnv("GO_ECOSYSTEM_VULNDB_DIR", "/tmp/go-vulndb"),
-		PkgsiteDBHost:         GetEnv("GO_ECOSYSTEM_PKGSITE_DB_HOST", "localhost"),
-		PkgsiteDBPort:         GetEnv("GO_ECOSYSTEM_PKGSITE_DB_PORT", "5432"),
-		PkgsiteDBName:         GetEnv("GO_ECOSYSTEM_PKGSITE_DB_NAME", "discovery-db"),
-		PkgsiteDBUser:         GetEnv("GO_ECOSYSTEM_PKGSITE_DB_USER", "postgres"),
-		PkgsiteDBSecret:       os.Getenv("GO_ECOSYSTEM_PKGSITE_DB_SECRET"),
-		ProxyURL:              GetEnv("GO_MODULE_PROXY_URL", "https://proxy.golang.org"),
+		ProjectID:                os.Getenv("GOOGLE_CLOUD_PROJECT"),
+		ServiceID:                os.Getenv("GO_ECOSYSTEM_SERVICE_ID"),
+		VersionID:                os.Getenv("DOCKER_IMAGE"),
+		LocationID:               "us-central1",
+		StaticPath:               ts,
+		BigQueryDataset:          GetEnv("GO_ECOSYSTEM_BIGQUERY_DATASET", "disable"),
+		QueueName:                os.Getenv("GO_ECOSYSTEM_QUEUE_NAME"),
+		HighPriorityQueueName:    os.Getenv("GO_ECOSYSTEM_QUEUE_NAME_HIGH"),
+		LowPriorityQueueName:     os.Getenv("GO_ECOSYSTEM_QUEUE_NAME_LOW"),
+		QueueURL:                 os.Getenv("GO_ECOSYSTEM_QUEUE_URL"),
+		VulnDBBucketProjectID:    os.Getenv("GO_ECOSYSTEM_VULNDB_BUCKET_PROJECT"),
+		VulnDBExclusions:         os.Getenv("GO_ECOSYSTEM_VULNDB_EXCLUSIONS"),
+		BinaryBucket:             os.Getenv("GO_ECOSYSTEM_BINARY_BUCKET"),
+		BinaryDir:                GetEnv("GO_ECOSYSTEM_BINARY_DIR", "/tmp/binaries"),
+		VulnDBDir:                GetEnv("GO_ECOSYSTEM_VULNDB_DIR", "/tmp/go-vulndb"),
+		VulnDBSnapshots:          os.Getenv("GO_ECOSYSTEM_VULNDB_SNAPSHOTS"),
+		CompareBinaryCache:       os.Getenv("GO_ECOSYSTEM_COMPARE_BINARY_CACHE"),
+		PkgsiteDBHost:            GetEnv("GO_ECOSYSTEM_PKGSITE_DB_HOST", "localhost"),
+		PkgsiteDBPort:            GetEnv("GO_ECOSYSTEM_PKGSITE_DB_PORT", "5432"),
+		PkgsiteDBName:            GetEnv("GO_ECOSYSTEM_PKGSITE_DB_NAME", "discovery-db"),
+		PkgsiteDBUser:            GetEnv("GO_ECOSYSTEM_PKGSITE_DB_USER", "postgres"),
+		PkgsiteDBSecret:          os.Getenv("GO_ECOSYSTEM_PKGSITE_DB_SECRET"),
+		ProxyURL:                 GetEnv("GO_MODULE_PROXY_URL", "https://proxy.golang.org"),
+		SumDBURL:                 GetEnv("GO_ECOSYSTEM_SUMDB_URL", "https://sum.golang.org"),
+		ProxyCacheDir:            os.Getenv("GO_ECOSYSTEM_PROXY_CACHE_DIR"),
+		ProxyCacheLimitMB:        GetEnvInt("GO_ECOSYSTEM_PROXY_CACHE_LIMIT_MB", "256", 256),
+		ProxyCacheTTL:            time.Duration(GetEnvInt("GO_ECOSYSTEM_PROXY_CACHE_TTL_HOURS", "24", 24)) * time.Hour,
+		ScanDiskQuotaMB:          GetEnvInt("GO_ECOSYSTEM_SCAN_DISK_QUOTA_MB", "0", 0),
+		AnalysisBatchThresholdMB: GetEnvInt("GO_ECOSYSTEM_ANALYSIS_BATCH_THRESHOLD_MB", "200", 200),
+		GoBuildCacheLimitMB:      GetEnvInt("GO_ECOSYSTEM_GOCACHE_LIMIT_MB", "0", 0),
+		GoModCacheLimitMB:        GetEnvInt("GO_ECOSYSTEM_GOMODCACHE_LIMIT_MB", "0", 0),
+		MaxAnalysisScans:         GetEnvInt("GO_ECOSYSTEM_MAX_ANALYSIS_SCANS", "0", 0),
+		MaxGovulncheckScans:      GetEnvInt("GO_ECOSYSTEM_MAX_GOVULNCHECK_SCANS", "0", 0),
+		UploadBufferRows:         GetEnvInt("GO_ECOSYSTEM_UPLOAD_BUFFER_ROWS", "0", 0),
+		UploadBufferAge:          time.Duration(GetEnvInt("GO_ECOSYSTEM_UPLOAD_BUFFER_SECONDS", "30", 30)) * time.Second,
+		JobStaleAfter:            time.Duration(GetEnvInt("GO_ECOSYSTEM_JOB_STALE_HOURS", "24", 24)) * time.Hour,
+		NotifyEmailURL:           os.Getenv("GO_ECOSYSTEM_NOTIFY_EMAIL_URL"),
+		SoftSkipAfter:            GetEnvInt("GO_ECOSYSTEM_SOFT_SKIP_AFTER", "0", 0),
+		SoftSkipFor:              time.Duration(GetEnvInt("GO_ECOSYSTEM_SOFT_SKIP_HOURS", "168", 168)) * time.Hour,
+	}
+	if ts := os.Getenv("GO_ECOSYSTEM_CORPUS_TABLES"); ts != "" {
+		cfg.CorpusTables = strings.Split(ts, ",")
+	}
+	cfg.Queues, err = ParseQueues(os.Getenv("GO_ECOSYSTEM_QUEUES"))
+	if err != nil {
+		return nil, err
+	}
+	cfg.Retention, err = ParseRetention(os.Getenv("GO_ECOSYSTEM_RETENTION"))
+	if err != nil {
+		return nil, err
 	}
 	if OnCloudRun() {
 		sa, err := gceMetadata(ctx, "instance/service-accounts/default/email")
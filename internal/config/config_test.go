@@ -0,0 +1,72 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseQueues(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want []QueueConfig
+	}{
+		{"", nil},
+		{"us-central1/q", []QueueConfig{{Location: "us-central1", Name: "q", Weight: 1}}},
+		{
+			"us-central1/q:3, us-east1/r",
+			[]QueueConfig{
+				{Location: "us-central1", Name: "q", Weight: 3},
+				{Location: "us-east1", Name: "r", Weight: 1},
+			},
+		},
+	} {
+		got, err := ParseQueues(test.in)
+		if err != nil {
+			t.Fatalf("%q: %v", test.in, err)
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("%q: mismatch (-want, +got):\n%s", test.in, diff)
+		}
+	}
+
+	for _, in := range []string{"q", "/q", "us-central1/", "a/b/c", "us-central1/q:0", "us-central1/q:x"} {
+		if _, err := ParseQueues(in); err == nil {
+			t.Errorf("%q: got nil error", in)
+		}
+	}
+}
+
+func TestParseRetention(t *testing.T) {
+	const day = 24 * time.Hour
+	for _, test := range []struct {
+		in   string
+		want map[string]time.Duration
+	}{
+		{"", map[string]time.Duration{}},
+		{"analysis:30", map[string]time.Duration{"analysis": 30 * day}},
+		{
+			"analysis:30, govulncheck:365",
+			map[string]time.Duration{"analysis": 30 * day, "govulncheck": 365 * day},
+		},
+	} {
+		got, err := ParseRetention(test.in)
+		if err != nil {
+			t.Fatalf("%q: %v", test.in, err)
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("%q: mismatch (-want, +got):\n%s", test.in, diff)
+		}
+	}
+
+	for _, in := range []string{"analysis", ":30", "analysis:0", "analysis:x", "analysis:30,analysis:40"} {
+		if _, err := ParseRetention(in); err == nil {
+			t.Errorf("%q: got nil error", in)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"golang.org/x/pkgsite-metrics/internal/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Dynamic holds the configuration that can change while the server is
+// running, without a redeploy. It is read from a Firestore document; see
+// DynamicConfig.Watch. Fields missing from the document have their
+// default values.
+type Dynamic struct {
+	// RequestLimit is the number of scan requests after which the worker
+	// restarts, to work around a process leak.
+	RequestLimit int `firestore:"request_limit" json:"request_limit"`
+
+	// MaxConcurrency is the maximum number of scans the worker runs at
+	// once. Further scan requests are rejected, so that they are retried
+	// later or by another instance. If zero, there is no limit.
+	MaxConcurrency int `firestore:"max_concurrency" json:"max_concurrency"`
+
+	// SkipModules lists the module paths that are never enqueued for
+	// scanning. A path ending in "/..." also matches all module paths
+	// it is a prefix of.
+	SkipModules []string `firestore:"skip_modules" json:"skip_modules"`
+
+	// MinImportedBy is the minimum imported-by count for a module to be
+	// enqueued, if the enqueue request does not specify one.
+	MinImportedBy int `firestore:"min_imported_by" json:"min_imported_by"`
+}
+
+// DefaultDynamic returns the dynamic configuration used when there is no
+// Firestore document.
+func DefaultDynamic() *Dynamic {
+	return &Dynamic{
+		RequestLimit:  250, // experimentally shown to be a good threshold
+		MinImportedBy: 10,
+	}
+}
+
+// Validate reports whether d's values are usable.
+func (d *Dynamic) Validate() error {
+	if d.RequestLimit <= 0 {
+		return errors.New("request_limit must be positive")
+	}
+	if d.MaxConcurrency < 0 {
+		return errors.New("max_concurrency must not be negative")
+	}
+	if d.MinImportedBy < 0 {
+		return errors.New("min_imported_by must not be negative")
+	}
+	return nil
+}
+
+// Skip reports whether modulePath matches d.SkipModules.
+func (d *Dynamic) Skip(modulePath string) bool {
+	for _, s := range d.SkipModules {
+		if s == modulePath {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(s, "/..."); ok && (modulePath == prefix || strings.HasPrefix(modulePath, prefix+"/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// A DynamicConfig holds the current dynamic configuration.
+// It is safe for concurrent use. A nil DynamicConfig holds the default values.
+type DynamicConfig struct {
+	mu      sync.Mutex
+	cur     *Dynamic
+	source  string    // path of the Firestore document, if watched
+	updated time.Time // when cur was last read from Firestore
+}
+
+// NewDynamicConfig returns a DynamicConfig holding the default values.
+func NewDynamicConfig() *DynamicConfig {
+	return &DynamicConfig{cur: DefaultDynamic()}
+}
+
+// Get returns the current configuration. The caller must not modify it.
+func (c *DynamicConfig) Get() *Dynamic {
+	if c == nil {
+		return DefaultDynamic()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cur
+}
+
+// Set replaces the current configuration with d, which was read at time
+// updated.
+func (c *DynamicConfig) Set(d *Dynamic, updated time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cur = d
+	c.updated = updated
+}
+
+// A DynamicState describes a DynamicConfig, for display.
+type DynamicState struct {
+	Source  string    `json:"source,omitempty"` // Firestore document, if watched
+	Updated time.Time `json:"updated"`          // when the document was last read; zero if never
+	Values  *Dynamic  `json:"values"`
+}
+
+// State returns the state of c.
+func (c *DynamicConfig) State() DynamicState {
+	if c == nil {
+		return DynamicState{Values: DefaultDynamic()}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return DynamicState{Source: c.source, Updated: c.updated, Values: c.cur}
+}
+
+// Backoff bounds for reconnecting to the dynamic config document.
+const (
+	minWatchBackoff = time.Second
+	maxWatchBackoff = 5 * time.Minute
+)
+
+// Watch applies the contents of the Firestore document doc to c whenever
+// it changes, until ctx is done. If the document does not exist, the
+// default values are used. A document with invalid values is ignored.
+// If watching fails, the current values are kept and Watch reconnects
+// with exponential backoff.
+func (c *DynamicConfig) Watch(ctx context.Context, doc *firestore.DocumentRef) {
+	c.mu.Lock()
+	c.source = doc.Path
+	c.mu.Unlock()
+
+	wait := minWatchBackoff
+	for {
+		read, err := c.watchOnce(ctx, doc)
+		if ctx.Err() != nil || status.Code(err) == codes.Canceled {
+			return
+		}
+		if read {
+			wait = minWatchBackoff
+		}
+		log.Errorf(ctx, err, "watching dynamic config %s; keeping the current values, retrying in %s", doc.Path, wait)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		wait = min(2*wait, maxWatchBackoff)
+	}
+}
+
+// watchOnce applies the snapshots of doc to c until the stream of
+// snapshots fails. It reports whether it read any snapshot.
+func (c *DynamicConfig) watchOnce(ctx context.Context, doc *firestore.DocumentRef) (read bool, err error) {
+	iter := doc.Snapshots(ctx)
+	defer iter.Stop()
+	for {
+		snap, err := iter.Next()
+		if err != nil {
+			return read, err
+		}
+		read = true
+		d, err := decodeDynamic(snap)
+		if err != nil {
+			log.Errorf(ctx, err, "ignoring dynamic config %s", doc.Path)
+			continue
+		}
+		c.Set(d, snap.ReadTime)
+		log.Infof(ctx, "dynamic config: %+v", *d)
+	}
+}
+
+// decodeDynamic returns the configuration in snap, with default values
+// for missing fields.
+func decodeDynamic(snap *firestore.DocumentSnapshot) (*Dynamic, error) {
+	d := DefaultDynamic()
+	if !snap.Exists() {
+		return d, nil
+	}
+	if err := snap.DataTo(d); err != nil {
+		return nil, err
+	}
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// EditSkipModules returns skip with the paths in add appended, if not
+// already present, and those in remove deleted.
+func EditSkipModules(skip, add, remove []string) []string {
+	var res []string
+	for _, s := range append(append([]string(nil), skip...), add...) {
+		if !slices.Contains(res, s) && !slices.Contains(remove, s) {
+			res = append(res, s)
+		}
+	}
+	return res
+}
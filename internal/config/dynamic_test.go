@@ -0,0 +1,81 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDynamicSkip(t *testing.T) {
+	d := &Dynamic{SkipModules: []string{"example.com/a", "example.com/b/..."}}
+	for _, test := range []struct {
+		path string
+		want bool
+	}{
+		{"example.com/a", true},
+		{"example.com/a/v2", false},
+		{"example.com/b", true},
+		{"example.com/b/c", true},
+		{"example.com/bc", false},
+		{"golang.org/x/net", false},
+	} {
+		if got := d.Skip(test.path); got != test.want {
+			t.Errorf("Skip(%q) = %t, want %t", test.path, got, test.want)
+		}
+	}
+}
+
+func TestEditSkipModules(t *testing.T) {
+	for _, test := range []struct {
+		skip, add, remove, want []string
+	}{
+		{nil, nil, nil, nil},
+		{nil, []string{"a", "b"}, nil, []string{"a", "b"}},
+		{[]string{"a", "b"}, []string{"b", "c"}, nil, []string{"a", "b", "c"}},
+		{[]string{"a", "b"}, nil, []string{"a", "x"}, []string{"b"}},
+		{[]string{"a"}, []string{"b"}, []string{"b"}, []string{"a"}},
+	} {
+		got := EditSkipModules(test.skip, test.add, test.remove)
+		if !cmp.Equal(got, test.want) {
+			t.Errorf("EditSkipModules(%q, %q, %q) = %q, want %q", test.skip, test.add, test.remove, got, test.want)
+		}
+	}
+}
+
+func TestDynamicValidate(t *testing.T) {
+	if err := DefaultDynamic().Validate(); err != nil {
+		t.Fatalf("default: %v", err)
+	}
+	for _, d := range []*Dynamic{
+		{RequestLimit: 0},
+		{RequestLimit: 1, MaxConcurrency: -1},
+		{RequestLimit: 1, MinImportedBy: -1},
+	} {
+		if err := d.Validate(); err == nil {
+			t.Errorf("%+v: got nil error", *d)
+		}
+	}
+}
+
+func TestDynamicConfig(t *testing.T) {
+	var nilc *DynamicConfig
+	if got := nilc.Get(); got.RequestLimit != DefaultDynamic().RequestLimit {
+		t.Errorf("nil DynamicConfig: got %+v, want defaults", *got)
+	}
+
+	c := NewDynamicConfig()
+	if got := c.Get(); got.MinImportedBy != DefaultDynamic().MinImportedBy {
+		t.Errorf("got %+v, want defaults", *got)
+	}
+	now := time.Now()
+	c.Set(&Dynamic{RequestLimit: 5, MaxConcurrency: 2}, now)
+	st := c.State()
+	if st.Values.RequestLimit != 5 || st.Values.MaxConcurrency != 2 || !st.Updated.Equal(now) {
+		t.Errorf("got state %+v", st)
+	}
+}
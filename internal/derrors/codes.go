@@ -0,0 +1,183 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package derrors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// An ErrorCode is a stable, machine-readable identifier for a category of
+// error. Codes are written to result tables alongside the human-readable
+// category, so that downstream consumers do not depend on category strings.
+//
+// Codes must never be renumbered or reused. To retire a code, leave its
+// entry in the registry and stop returning it from CodeOf.
+type ErrorCode int
+
+// Error codes are grouped by the hundreds:
+//
+//	1xx: loading packages
+//	2xx: the scan environment (OS, sandbox, resource limits)
+//	3xx: govulncheck
+//	4xx: external services
+//	5xx: synthetic modules
+//	6xx: analysis binaries
+const (
+	CodeMisc ErrorCode = 1
+
+	CodeLoad                  ErrorCode = 100
+	CodeLoadWrongGoVersion    ErrorCode = 101
+	CodeLoadNoGoMod           ErrorCode = 102
+	CodeLoadNoGoSum           ErrorCode = 103
+	CodeLoadNoRequiredModule  ErrorCode = 104
+	CodeLoadNoGoSumEntry      ErrorCode = 105
+	CodeLoadLocalReplace      ErrorCode = 106
+	CodeLoadSyntheticModule   ErrorCode = 107
+	CodeLoadVendor            ErrorCode = 108
+	CodeOS                    ErrorCode = 200
+	CodePanic                 ErrorCode = 201
+	CodeMemLimitExceeded      ErrorCode = 202
+	CodeTooManyOpenFiles      ErrorCode = 203
+	CodeSandboxMisc           ErrorCode = 204
+	CodeDiskLimitExceeded     ErrorCode = 205
+	CodeToolchainUnavailable  ErrorCode = 206
+	CodeTimeout               ErrorCode = 207
+	CodeVulncheckMisc         ErrorCode = 300
+	CodeVulncheckDBConnection ErrorCode = 301
+	CodeProxy                 ErrorCode = 400
+	CodeBigQuery              ErrorCode = 401
+	CodeChecksumMismatch      ErrorCode = 402
+	CodeSyntheticModuleMisc   ErrorCode = 500
+	CodeAnalysisBinaryPanic   ErrorCode = 600
+)
+
+// codeInfo describes an ErrorCode.
+type codeInfo struct {
+	name     string // stable symbolic name, e.g. "LOAD_NO_GOMOD"
+	category string // human-readable category, as returned by CategorizeError
+}
+
+// codes is the registry of all error codes.
+var codes = map[ErrorCode]codeInfo{
+	CodeMisc:                  {"MISC", "MISC"},
+	CodeLoad:                  {"LOAD", "LOAD"},
+	CodeLoadWrongGoVersion:    {"LOAD_WRONG_GO_VERSION", "LOAD - WRONG GO VERSION"},
+	CodeLoadNoGoMod:           {"LOAD_NO_GOMOD", "LOAD - NO GO.MOD"},
+	CodeLoadNoGoSum:           {"LOAD_NO_GOSUM", "LOAD - NO GO.SUM"},
+	CodeLoadNoRequiredModule:  {"LOAD_NO_REQUIRED_MODULE", "LOAD - NO REQUIRED MODULE"},
+	CodeLoadNoGoSumEntry:      {"LOAD_NO_GOSUM_ENTRY", "LOAD - NO GO.SUM ENTRY"},
+	CodeLoadLocalReplace:      {"LOAD_LOCAL_REPLACE", "LOAD - GO.MOD REPLACES WITH A LOCAL PATH"},
+	CodeLoadSyntheticModule:   {"LOAD_SYNTHETIC_MODULE", "LOAD - SYNTHETIC MODULE"},
+	CodeLoadVendor:            {"LOAD_VENDOR", "VENDOR"},
+	CodeOS:                    {"OS", "OS"},
+	CodePanic:                 {"PANIC", "PANIC"},
+	CodeMemLimitExceeded:      {"MEM_LIMIT_EXCEEDED", "MEM LIMIT EXCEEDED"},
+	CodeTooManyOpenFiles:      {"TOO_MANY_OPEN_FILES", "TOO MANY OPEN FILES"},
+	CodeSandboxMisc:           {"SANDBOX_MISC", "SANDBOX MISC"},
+	CodeDiskLimitExceeded:     {"DISK_LIMIT_EXCEEDED", "DISK LIMIT EXCEEDED"},
+	CodeToolchainUnavailable:  {"TOOLCHAIN_UNAVAILABLE", "TOOLCHAIN UNAVAILABLE"},
+	CodeTimeout:               {"TIMEOUT", "TIMEOUT"},
+	CodeVulncheckMisc:         {"VULNCHECK_MISC", "VULNCHECK - MISC"},
+	CodeVulncheckDBConnection: {"VULNCHECK_DB_CONNECTION", "VULNCHECK - DB CONNECTION"},
+	CodeProxy:                 {"PROXY", "PROXY"},
+	CodeBigQuery:              {"BIGQUERY", "BIGQUERY"},
+	CodeChecksumMismatch:      {"CHECKSUM_MISMATCH", "CHECKSUM MISMATCH"},
+	CodeSyntheticModuleMisc:   {"SYNTHETIC_MISC", "SYNTHETIC - MISC"},
+	CodeAnalysisBinaryPanic:   {"ANALYSIS_BINARY_PANIC", "ANALYSIS BINARY PANIC"},
+}
+
+// CodeOf returns the error code for err.
+// It returns CodeMisc for errors that are not otherwise classified.
+func CodeOf(err error) ErrorCode {
+	switch {
+	case errors.Is(err, ScanModuleGovulncheckError):
+		return CodeVulncheckMisc
+	case errors.Is(err, ScanModuleGovulncheckDBConnectionError):
+		return CodeVulncheckDBConnection
+	case errors.Is(err, LoadPackagesError):
+		return CodeLoad
+	case errors.Is(err, LoadPackagesSyntheticError):
+		return CodeLoadSyntheticModule
+	case errors.Is(err, LoadPackagesGoVersionError):
+		return CodeLoadWrongGoVersion
+	case errors.Is(err, LoadPackagesNoGoModError):
+		return CodeLoadNoGoMod
+	case errors.Is(err, LoadPackagesNoGoSumError):
+		return CodeLoadNoGoSum
+	case errors.Is(err, LoadPackagesNoRequiredModuleError):
+		return CodeLoadNoRequiredModule
+	case errors.Is(err, LoadPackagesMissingGoSumEntryError):
+		return CodeLoadNoGoSumEntry
+	case errors.Is(err, LoadPackagesImportedLocalError):
+		return CodeLoadLocalReplace
+	case errors.Is(err, LoadVendorError):
+		return CodeLoadVendor
+	case errors.Is(err, ScanModuleOSError):
+		return CodeOS
+	case errors.Is(err, ScanModulePanicError):
+		return CodePanic
+	case errors.Is(err, AnalysisBinaryPanicError):
+		return CodeAnalysisBinaryPanic
+	case errors.Is(err, ScanModuleMemoryLimitExceeded):
+		return CodeMemLimitExceeded
+	case errors.Is(err, ScanModuleTooManyOpenFiles):
+		return CodeTooManyOpenFiles
+	case errors.Is(err, ScanModuleDiskLimitExceeded):
+		return CodeDiskLimitExceeded
+	case errors.Is(err, ToolchainUnavailable):
+		return CodeToolchainUnavailable
+	case errors.Is(err, ScanModuleSandboxError):
+		return CodeSandboxMisc
+	case errors.Is(err, ProxyError):
+		return CodeProxy
+	case errors.Is(err, BigQueryError):
+		return CodeBigQuery
+	case errors.Is(err, ChecksumMismatch):
+		return CodeChecksumMismatch
+	case errors.Is(err, ScanSyntheticModuleError):
+		return CodeSyntheticModuleMisc
+	case errors.Is(err, context.DeadlineExceeded):
+		// Before CodeTimeout existed, these errors were CodeMisc, so rows
+		// written then still have MISC as their category and 1 as their
+		// code. To count them as timeouts, backfill them with
+		//
+		//	UPDATE table SET error_category = 'TIMEOUT', error_code = 207
+		//	WHERE error_code = 1 AND error LIKE '%context deadline exceeded%'
+		return CodeTimeout
+	}
+	return CodeMisc
+}
+
+// String returns the stable symbolic name of the code, like "LOAD_NO_GOMOD".
+func (c ErrorCode) String() string {
+	if ci, ok := codes[c]; ok {
+		return ci.name
+	}
+	return fmt.Sprintf("ErrorCode(%d)", int(c))
+}
+
+// Category returns the human-readable category for the code.
+// It is provided for compatibility with consumers of the category strings.
+// Unknown codes map to "MISC".
+func (c ErrorCode) Category() string {
+	if ci, ok := codes[c]; ok {
+		return ci.category
+	}
+	return codes[CodeMisc].category
+}
+
+// CodeForCategory returns the error code for a category string, as returned
+// by CategorizeError. It can be used to assign codes to rows written before
+// codes existed. It returns false if the category is unknown.
+func CodeForCategory(category string) (ErrorCode, bool) {
+	for c, ci := range codes {
+		if ci.category == category {
+			return c, true
+		}
+	}
+	return 0, false
+}
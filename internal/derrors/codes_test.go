@@ -0,0 +1,61 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package derrors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestCodeOf(t *testing.T) {
+	for _, test := range []struct {
+		err          error
+		wantCode     ErrorCode
+		wantCategory string
+	}{
+		{errors.New("boom"), CodeMisc, "MISC"},
+		{fmt.Errorf("x: %w", LoadPackagesNoGoModError), CodeLoadNoGoMod, "LOAD - NO GO.MOD"},
+		{fmt.Errorf("%v: %w", "y", ScanModuleMemoryLimitExceeded), CodeMemLimitExceeded, "MEM LIMIT EXCEEDED"},
+		{ScanSyntheticModuleError, CodeSyntheticModuleMisc, "SYNTHETIC - MISC"},
+		{fmt.Errorf("z: %w", ScanModuleDiskLimitExceeded), CodeDiskLimitExceeded, "DISK LIMIT EXCEEDED"},
+		{fmt.Errorf("w: %w", AnalysisBinaryPanicError), CodeAnalysisBinaryPanic, "ANALYSIS BINARY PANIC"},
+		{fmt.Errorf("v: %w", ToolchainUnavailable), CodeToolchainUnavailable, "TOOLCHAIN UNAVAILABLE"},
+		{fmt.Errorf("u: %w", ChecksumMismatch), CodeChecksumMismatch, "CHECKSUM MISMATCH"},
+		{fmt.Errorf("t: %w", context.DeadlineExceeded), CodeTimeout, "TIMEOUT"},
+	} {
+		gotCode := CodeOf(test.err)
+		if gotCode != test.wantCode {
+			t.Errorf("CodeOf(%v) = %s, want %s", test.err, gotCode, test.wantCode)
+		}
+		if got := CategorizeError(test.err); got != test.wantCategory {
+			t.Errorf("CategorizeError(%v) = %q, want %q", test.err, got, test.wantCategory)
+		}
+	}
+	if got, want := int(CodeLoadNoGoMod), 102; got != want {
+		t.Errorf("CodeLoadNoGoMod = %d, want %d", got, want)
+	}
+}
+
+func TestCodeRegistry(t *testing.T) {
+	names := map[string]bool{}
+	for c, ci := range codes {
+		if names[ci.name] {
+			t.Errorf("duplicate name %q", ci.name)
+		}
+		names[ci.name] = true
+		got, ok := CodeForCategory(ci.category)
+		if !ok || got != c {
+			t.Errorf("CodeForCategory(%q) = %s, %t, want %s, true", ci.category, got, ok, c)
+		}
+	}
+	if _, ok := CodeForCategory("NO SUCH CATEGORY"); ok {
+		t.Error("CodeForCategory of unknown category succeeded")
+	}
+	if got, want := ErrorCode(999).Category(), "MISC"; got != want {
+		t.Errorf("unknown code category = %q, want %q", got, want)
+	}
+}
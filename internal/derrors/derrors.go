@@ -41,6 +41,10 @@ var (
 	// ProxyError is used to capture non-actionable server errors returned from the proxy.
 	ProxyError = errors.New("proxy error")
 
+	// ChecksumMismatch indicates that a module zip served by the proxy does
+	// not have the hash recorded in the checksum database.
+	ChecksumMismatch = errors.New("checksum mismatch")
+
 	// BigQueryError is used to capture server errors returned by BigQuery.
 	BigQueryError = errors.New("BigQuery error")
 
@@ -117,6 +121,19 @@ var (
 
 	// ScanModuleTooManyOpenFiles occurs when there are too many files open while scanning.
 	ScanModuleTooManyOpenFiles = errors.New("scan module too many open files")
+
+	// ScanModuleDiskLimitExceeded occurs when scanning uses more than its
+	// quota of disk space.
+	ScanModuleDiskLimitExceeded = errors.New("scan module disk limit exceeded")
+
+	// ToolchainUnavailable occurs when the Go toolchain requested for a
+	// scan is neither provisioned on the worker nor downloadable.
+	ToolchainUnavailable = errors.New("requested Go toolchain unavailable")
+
+	// AnalysisBinaryPanicError occurs when an analysis binary panics or
+	// otherwise crashes with a stack trace. Unlike ScanModulePanicError,
+	// it is a problem with the analysis, not with the scan.
+	AnalysisBinaryPanicError = errors.New("analysis binary panic")
 )
 
 // Wrap adds context to the error and allows
@@ -194,48 +211,9 @@ func Report(err error) {
 }
 
 // CategorizeError returns the category for a given error.
+// Prefer CodeOf for values that are consumed by programs.
 func CategorizeError(err error) string {
-	switch {
-	case errors.Is(err, ScanModuleGovulncheckError):
-		return "VULNCHECK - MISC"
-	case errors.Is(err, ScanModuleGovulncheckDBConnectionError):
-		return "VULNCHECK - DB CONNECTION"
-	case errors.Is(err, LoadPackagesError):
-		return "LOAD"
-	case errors.Is(err, LoadPackagesSyntheticError):
-		return "LOAD - SYNTHETIC MODULE"
-	case errors.Is(err, LoadPackagesGoVersionError):
-		return "LOAD - WRONG GO VERSION"
-	case errors.Is(err, LoadPackagesNoGoModError):
-		return "LOAD - NO GO.MOD"
-	case errors.Is(err, LoadPackagesNoGoSumError):
-		return "LOAD - NO GO.SUM"
-	case errors.Is(err, LoadPackagesNoRequiredModuleError):
-		return "LOAD - NO REQUIRED MODULE"
-	case errors.Is(err, LoadPackagesMissingGoSumEntryError):
-		return "LOAD - NO GO.SUM ENTRY"
-	case errors.Is(err, LoadPackagesImportedLocalError):
-		return "LOAD - GO.MOD REPLACES WITH A LOCAL PATH"
-	case errors.Is(err, LoadVendorError):
-		return "VENDOR"
-	case errors.Is(err, ScanModuleOSError):
-		return "OS"
-	case errors.Is(err, ScanModulePanicError):
-		return "PANIC"
-	case errors.Is(err, ScanModuleMemoryLimitExceeded):
-		return "MEM LIMIT EXCEEDED"
-	case errors.Is(err, ScanModuleTooManyOpenFiles):
-		return "TOO MANY OPEN FILES"
-	case errors.Is(err, ScanModuleSandboxError):
-		return "SANDBOX MISC"
-	case errors.Is(err, ProxyError):
-		return "PROXY"
-	case errors.Is(err, BigQueryError):
-		return "BIGQUERY"
-	case errors.Is(err, ScanSyntheticModuleError):
-		return "SYNTHETIC - MISC"
-	}
-	return "MISC"
+	return CodeOf(err).Category()
 }
 
 func IsGoVersionMismatchError(msg string) bool {
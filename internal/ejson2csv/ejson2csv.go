@@ -8,7 +8,10 @@ import (
 	"encoding/csv"
 	"encoding/json"
 	"io"
+	"sort"
 	"strconv"
+
+	"golang.org/x/pkgsite-metrics/internal/analysis"
 )
 
 func must(err error) {
@@ -17,7 +20,7 @@ func must(err error) {
 	}
 }
 
-// sliceOf converts string/int args into a 5-element slice of string,
+// sliceOf converts string/int args into a 6-element slice of string,
 // reusing the input slice (pointer) s.
 func sliceOf(s *[]string, args ...any) []string {
 	*s = (*s)[:0]
@@ -29,7 +32,7 @@ func sliceOf(s *[]string, args ...any) []string {
 			*s = append(*s, x)
 		}
 	}
-	for len(*s) < 5 {
+	for len(*s) < 6 {
 		*s = append(*s, "")
 	}
 	return *s
@@ -41,7 +44,9 @@ func sliceOf(s *[]string, args ...any) []string {
 // others specifies all lines that are neither error nor diagnostics,
 // and all means all.  One limits the output to the first line (error,
 // diagnostic, or neither) from each module in the JSON stream.
-func Process(r io.Reader, w io.Writer, errors, others, all, one bool) {
+// BySeverity groups the lines by the severity of their diagnostic, most
+// severe first; lines without a severity come last.
+func Process(r io.Reader, w io.Writer, errors, others, all, one, bySeverity bool) {
 	var stuff any
 
 	buf, err := io.ReadAll(r)
@@ -55,9 +60,20 @@ func Process(r io.Reader, w io.Writer, errors, others, all, one bool) {
 	out := csv.NewWriter(w)
 
 	var line []string
-	line = sliceOf(&line, "ModulePath", "mpIndex", "Message/error", "meIndex", "Position")
+	line = sliceOf(&line, "ModulePath", "mpIndex", "Message/error", "meIndex", "Position", "Severity")
 	out.Write(line)
 
+	// Lines are buffered, so they can be grouped by severity.
+	type record struct {
+		fields   []string
+		severity string
+	}
+	var records []record
+	write := func(severity string, args ...any) {
+		var fields []string
+		records = append(records, record{sliceOf(&fields, args...), severity})
+	}
+
 outer:
 	for i, a := range slice {
 		ma := a.(map[string]any)
@@ -69,10 +85,11 @@ outer:
 
 			for j, d := range s {
 				md := d.(map[string]any)
+				severity, _ := md["Severity"].(string)
 				if m, ok := md["Error"].(string); ok && m != "" {
 					// error messages print if errors or all.
 					if errors || all {
-						out.Write(sliceOf(&line, ma["ModulePath"], i, m, j, md["Position"]))
+						write(severity, ma["ModulePath"], i, m, j, md["Position"], severity)
 						if one {
 							continue outer
 						}
@@ -82,7 +99,7 @@ outer:
 				if m, ok := md["Message"].(string); ok && m != "" {
 					// diagnostic messages print if present and either all or not-errors-and-not-others
 					if !errors && !others || all {
-						out.Write(sliceOf(&line, ma["ModulePath"], i, m, j, md["Position"]))
+						write(severity, ma["ModulePath"], i, m, j, md["Position"], severity)
 						if one {
 							continue outer
 						}
@@ -96,8 +113,16 @@ outer:
 		}
 		// Here if no diagnostic message or error lines were printed.
 		if others || all {
-			out.Write(sliceOf(&line, ma["ModulePath"], i))
+			write("", ma["ModulePath"], i)
 		}
 	}
+	if bySeverity {
+		sort.SliceStable(records, func(i, j int) bool {
+			return analysis.SeverityRank(records[i].severity) < analysis.SeverityRank(records[j].severity)
+		})
+	}
+	for _, r := range records {
+		out.Write(r.fields)
+	}
 	out.Flush()
 }
@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"golang.org/x/pkgsite-metrics/internal/ejson2csv"
@@ -22,7 +23,7 @@ func must(err error) {
 func run(pb *bytes.Buffer, errors, others, all, one bool) {
 	in, err := os.Open(filepath.Join("testdata", "sample.json"))
 	must(err)
-	ejson2csv.Process(in, pb, errors, others, all, one)
+	ejson2csv.Process(in, pb, errors, others, all, one, false)
 }
 
 var nl []byte = []byte{'\n'}
@@ -91,3 +92,27 @@ func TestAllOne(t *testing.T) {
 	run(&b, false, false, true, true)
 	expect(t, &b, 23)
 }
+
+func TestBySeverity(t *testing.T) {
+	const in = `[
+	{"ModulePath": "a", "Diagnostics": [
+		{"Message": "m0", "Position": "p0", "Severity": null},
+		{"Message": "m1", "Position": "p1", "Severity": "info"}
+	]},
+	{"ModulePath": "b", "Diagnostics": [
+		{"Message": "m2", "Position": "p2", "Severity": "error"},
+		{"Message": "m3", "Position": "p3", "Severity": "info"}
+	]}
+]`
+	var b bytes.Buffer
+	ejson2csv.Process(strings.NewReader(in), &b, false, false, false, false, true)
+	want := `ModulePath,mpIndex,Message/error,meIndex,Position,Severity
+b,1,m2,0,p2,error
+a,0,m1,1,p1,info
+b,1,m3,1,p3,info
+a,0,m0,0,p0,
+`
+	if got := b.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
@@ -10,9 +10,11 @@ package fstore
 import (
 	"context"
 	"errors"
+	"time"
 
 	"cloud.google.com/go/firestore"
 	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"google.golang.org/api/iterator"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
@@ -76,6 +78,43 @@ func Set[T any](ctx context.Context, dr *firestore.DocumentRef, value *T) (err e
 	return convertError(err)
 }
 
+// DeleteUpdatedBefore deletes the documents of the collection that were
+// last updated before cutoff, and returns how many there were.
+// If dryRun is true, it only counts them.
+func DeleteUpdatedBefore(ctx context.Context, coll *firestore.CollectionRef, cutoff time.Time, dryRun bool) (n int, err error) {
+	defer derrors.Wrap(&err, "fstore.DeleteUpdatedBefore(%q, %s)", coll.Path, cutoff)
+	return DeleteMatching(ctx, coll, func(ds *firestore.DocumentSnapshot) bool {
+		return ds.UpdateTime.Before(cutoff)
+	}, dryRun)
+}
+
+// DeleteMatching deletes the documents of the collection for which match
+// returns true, and returns how many there were.
+// If dryRun is true, it only counts them.
+func DeleteMatching(ctx context.Context, coll *firestore.CollectionRef, match func(*firestore.DocumentSnapshot) bool, dryRun bool) (n int, err error) {
+	defer derrors.Wrap(&err, "fstore.DeleteMatching(%q)", coll.Path)
+	iter := coll.Documents(ctx)
+	defer iter.Stop()
+	for {
+		docsnap, err := iter.Next()
+		if err == iterator.Done {
+			return n, nil
+		}
+		if err != nil {
+			return n, convertError(err)
+		}
+		if !match(docsnap) {
+			continue
+		}
+		if !dryRun {
+			if _, err := docsnap.Ref.Delete(ctx); err != nil {
+				return n, convertError(err)
+			}
+		}
+		n++
+	}
+}
+
 // Decode decodes a DocumentSnapshot into a value of type T.
 func Decode[T any](ds *firestore.DocumentSnapshot) (*T, error) {
 	var t T
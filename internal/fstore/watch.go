@@ -0,0 +1,133 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fstore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// StopWatch is returned by the function passed to Watch to stop watching
+// without an error.
+var StopWatch = errors.New("stop watching")
+
+// Backoff bounds for reconnecting a watch.
+const (
+	minWatchBackoff = time.Second
+	maxWatchBackoff = time.Minute
+)
+
+// maxWatchFailures is the number of consecutive failures to reconnect
+// after which Watch gives up.
+const maxWatchFailures = 10
+
+// Watch calls f with the contents of the document at dr, decoded into a
+// value of type T, and then again each time the document changes, along
+// with the time the contents were read. If the stream of snapshots fails,
+// Watch reconnects to it with exponential backoff.
+//
+// Watch returns when ctx is done, or the document does not exist, or f
+// returns a non-nil error. If that error is StopWatch, Watch returns nil;
+// otherwise it returns the error.
+func Watch[T any](ctx context.Context, dr *firestore.DocumentRef, f func(_ *T, readTime time.Time) error) (err error) {
+	defer derrors.Wrap(&err, "fstore.Watch(%q)", dr.Path)
+	open := func() snapshotIterator { return dr.Snapshots(ctx) }
+	return watch(ctx, open, func(ds *firestore.DocumentSnapshot) error {
+		if !ds.Exists() {
+			return derrors.NotFound
+		}
+		t, err := Decode[T](ds)
+		if err != nil {
+			return err
+		}
+		return f(t, ds.ReadTime)
+	}, minWatchBackoff)
+}
+
+// A snapshotIterator is the part of a *firestore.DocumentSnapshotIterator
+// used by watch.
+type snapshotIterator interface {
+	Next() (*firestore.DocumentSnapshot, error)
+	Stop()
+}
+
+// watch calls f on the snapshots of the iterators returned by open,
+// opening a new one after waiting for backoff, doubled on each
+// consecutive failure, when the current one fails.
+func watch(ctx context.Context, open func() snapshotIterator, f func(*firestore.DocumentSnapshot) error, backoff time.Duration) error {
+	failures := 0
+	wait := backoff
+	for {
+		err := watchOnce(open(), f, func() { failures, wait = 0, backoff })
+		switch {
+		case errors.Is(err, StopWatch):
+			return nil
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case !retryable(err):
+			return err
+		}
+		failures++
+		if failures >= maxWatchFailures {
+			return err
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		wait = min(2*wait, maxWatchBackoff)
+	}
+}
+
+// watchOnce calls f on the snapshots of iter until it or f fails, calling
+// ok after each snapshot that f accepts.
+func watchOnce(iter snapshotIterator, f func(*firestore.DocumentSnapshot) error, ok func()) error {
+	defer iter.Stop()
+	for {
+		ds, err := iter.Next()
+		if err != nil {
+			return &streamError{code: status.Code(err), err: convertError(err)}
+		}
+		if err := f(ds); err != nil {
+			return err
+		}
+		ok()
+	}
+}
+
+// A streamError is an error from the stream of snapshots, as opposed to
+// an error from the function called on them.
+type streamError struct {
+	code codes.Code // gRPC status code of the error
+	err  error
+}
+
+func (e *streamError) Error() string { return e.err.Error() }
+func (e *streamError) Unwrap() error { return e.err }
+
+// retryable reports whether err is a failure of the stream of snapshots
+// that reconnecting may fix.
+func retryable(err error) bool {
+	var serr *streamError
+	if !errors.As(err, &serr) {
+		return false
+	}
+	switch serr.code {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted,
+		codes.Internal, codes.Aborted, codes.Unknown:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fstore
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeIterator returns its snapshots, then err.
+type fakeIterator struct {
+	snaps   []*firestore.DocumentSnapshot
+	err     error
+	stopped bool
+}
+
+func (it *fakeIterator) Next() (*firestore.DocumentSnapshot, error) {
+	if len(it.snaps) == 0 {
+		return nil, it.err
+	}
+	ds := it.snaps[0]
+	it.snaps = it.snaps[1:]
+	return ds, nil
+}
+
+func (it *fakeIterator) Stop() { it.stopped = true }
+
+func TestWatchReconnect(t *testing.T) {
+	ctx := context.Background()
+	snap := &firestore.DocumentSnapshot{}
+	unavailable := status.Error(codes.Unavailable, "unavailable")
+	permanent := status.Error(codes.PermissionDenied, "denied")
+
+	for _, test := range []struct {
+		name      string
+		iters     []*fakeIterator
+		stopAfter int // number of snapshots after which f returns StopWatch
+		wantErr   error
+		wantSnaps int
+	}{
+		{
+			name:      "stop",
+			iters:     []*fakeIterator{{snaps: []*firestore.DocumentSnapshot{snap, snap}}},
+			stopAfter: 2,
+			wantSnaps: 2,
+		},
+		{
+			name: "reconnect",
+			iters: []*fakeIterator{
+				{snaps: []*firestore.DocumentSnapshot{snap}, err: unavailable},
+				{err: unavailable},
+				{snaps: []*firestore.DocumentSnapshot{snap}},
+			},
+			stopAfter: 2,
+			wantSnaps: 2,
+		},
+		{
+			name: "permanent",
+			iters: []*fakeIterator{
+				{snaps: []*firestore.DocumentSnapshot{snap}, err: permanent},
+			},
+			wantErr:   permanent,
+			wantSnaps: 1,
+		},
+		{
+			name:    "not found",
+			iters:   []*fakeIterator{{err: status.Error(codes.NotFound, "no doc")}},
+			wantErr: derrors.NotFound,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var opened []*fakeIterator
+			open := func() snapshotIterator {
+				it := test.iters[len(opened)]
+				opened = append(opened, it)
+				return it
+			}
+			n := 0
+			err := watch(ctx, open, func(*firestore.DocumentSnapshot) error {
+				n++
+				if n == test.stopAfter {
+					return StopWatch
+				}
+				return nil
+			}, time.Millisecond)
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("got error %v, want %v", err, test.wantErr)
+			}
+			if n != test.wantSnaps {
+				t.Errorf("got %d snapshots, want %d", n, test.wantSnaps)
+			}
+			for i, it := range opened {
+				if !it.stopped {
+					t.Errorf("iterator %d not stopped", i)
+				}
+			}
+		})
+	}
+}
+
+func TestWatchGivesUp(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "unavailable")
+	opens := 0
+	open := func() snapshotIterator {
+		opens++
+		return &fakeIterator{err: unavailable}
+	}
+	err := watch(context.Background(), open, func(*firestore.DocumentSnapshot) error { return nil }, time.Microsecond)
+	if !errors.Is(err, unavailable) {
+		t.Errorf("got %v, want %v", err, unavailable)
+	}
+	if opens != maxWatchFailures {
+		t.Errorf("opened %d streams, want %d", opens, maxWatchFailures)
+	}
+}
+
+func TestWatchEmulator(t *testing.T) {
+	if os.Getenv("FIRESTORE_EMULATOR_HOST") == "" {
+		t.Skip("needs the Firestore emulator: set FIRESTORE_EMULATOR_HOST")
+	}
+	ctx := context.Background()
+	// The emulator accepts any project ID.
+	ns, err := OpenNamespace(ctx, "test-project", "testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ns.Close()
+
+	type doc struct{ N int }
+	dr := ns.Collection("Watch").Doc(t.Name())
+	if err := Set(ctx, dr, &doc{N: 0}); err != nil {
+		t.Fatal(err)
+	}
+	// Each value seen causes the next write, until N is 3.
+	var got []int
+	err = Watch(ctx, dr, func(d *doc, _ time.Time) error {
+		got = append(got, d.N)
+		if d.N == 3 {
+			return StopWatch
+		}
+		return Set(ctx, dr, &doc{N: d.N + 1})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]int{0, 1, 2, 3}, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	if _, err := dr.Delete(ctx); err != nil {
+		t.Fatal(err)
+	}
+	err = Watch(ctx, dr, func(*doc, time.Time) error { return nil })
+	if !errors.Is(err, derrors.NotFound) {
+		t.Errorf("watching a missing document: got %v, want NotFound", err)
+	}
+}
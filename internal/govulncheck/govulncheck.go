@@ -11,15 +11,20 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"sort"
 	"strings"
 	"time"
 
 	bq "cloud.google.com/go/bigquery"
+	"cloud.google.com/go/firestore"
 
 	"golang.org/x/pkgsite-metrics/internal/bigquery"
 	"golang.org/x/pkgsite-metrics/internal/derrors"
@@ -46,10 +51,26 @@ const (
 
 // EnqueueQueryParams for govulncheck/enqueue.
 type EnqueueQueryParams struct {
-	Suffix string // appended to task queue IDs to generate unique tasks
-	Mode   string // type of analysis to run
-	Min    int    // minimum import-by count for a module to be included
-	File   string // path to file containing modules; if missing, use DB
+	Suffix      string   // appended to task queue IDs to generate unique tasks
+	Mode        string   // type of analysis to run
+	Min         int      // minimum import-by count for a module to be included
+	File        string   // file, glob or gs:// URL of modules; if missing, use DB
+	CorpusQuery string   // BigQuery table/view of modules, one of the worker's corpus tables; used instead of DB if File is missing
+	Priority    string   // task priority: high, normal or low; if empty, normal
+	VulnDB      string   // vuln DB snapshot to scan with: a date like 2024-01-01 or a gs:// URL; if empty, the worker's DB
+	Platforms   []string // GOOS/GOARCH pairs to scan for, like linux/amd64; if empty, the worker's platform
+	MaxBinaries int      // in compare mode, build at most this many binaries per module; if zero, all of them
+	Binaries    []string // in compare mode, the import paths of the main packages to build; if empty, all of them
+	Labels      []string // experiment labels of the form KEY:VALUE, recorded on every result row; see scan.ParseLabels
+	Unaffected  bool     // in govulncheck mode, also record the OSVs that were evaluated but not found; see Result.Unaffected
+	Tests       bool     // in govulncheck mode, also scan the tests of the packages; see QueryParams.Tests
+}
+
+// EnqueueOSVParams for govulncheck/enqueue-osv.
+type EnqueueOSVParams struct {
+	ID       string // ID of the OSV entry, like GO-2024-1234
+	Min      int    // minimum import-by count for a module to be included
+	Priority string // task priority: high, normal or low; if empty, normal
 }
 
 // Request contains information passed to a scan endpoint.
@@ -64,6 +85,32 @@ type QueryParams struct {
 	Mode       string // govulncheck mode
 	Insecure   bool   // if true, run outside sandbox
 	Serve      bool   // serve results back to client instead of writing them to BigQuery
+	OSV        string // ID of the OSV entry that prompted the scan, if any; such scans are never skipped
+	VulnDB     string // gs:// URL of the vuln DB snapshot to scan with; if empty, the worker's DB
+	// Platforms are the GOOS/GOARCH pairs to scan the module for, like
+	// linux/amd64. Each has its own rows. If empty, the module is scanned
+	// once, for the worker's platform.
+	Platforms []string
+	// Format is the format of served results: FormatJSON, the default,
+	// or FormatSARIF.
+	Format string
+	// MaxBinaries and Binaries limit the binaries built in compare
+	// mode, as buildbinary.Selection does.
+	MaxBinaries int
+	Binaries    []string
+	// Subdir is the directory of the module to scan within the download,
+	// for repos whose Go module is not at the root; see scan.CheckSubdir.
+	// Scans of a subdirectory are not recorded in the work state.
+	Subdir string
+	// Labels are recorded on the result rows; see scan.ParseLabels.
+	Labels []string
+	// Unaffected records the OSVs that were evaluated but not found at
+	// the level of each row; see Result.Unaffected.
+	Unaffected bool
+	// Tests scans the tests of the packages too, and records which
+	// findings are only in tests; see Vuln.TestOnly. Scans of tests are
+	// not recorded in the work state.
+	Tests bool
 }
 
 // The below methods implement queue.Task.
@@ -98,12 +145,47 @@ func ParseRequest(r *http.Request, prefix string) (*Request, error) {
 	if rp.ImportedBy < 0 {
 		return nil, errors.New(`missing or negative "importedby" query param`)
 	}
+	for _, p := range rp.Platforms {
+		if _, err := PlatformEnv(p); err != nil {
+			return nil, err
+		}
+	}
+	if rp.MaxBinaries < 0 {
+		return nil, errors.New(`negative "maxbinaries" query param`)
+	}
+	if err := scan.CheckSubdir(rp.Subdir); err != nil {
+		return nil, err
+	}
+	if _, err := scan.ParseLabels(rp.Labels); err != nil {
+		return nil, err
+	}
+	switch rp.Format {
+	case "", FormatJSON:
+	case FormatSARIF:
+		if !rp.Serve {
+			return nil, errors.New(`format "sarif" requires "serve"`)
+		}
+	default:
+		return nil, fmt.Errorf("unknown format %q: want %s or %s", rp.Format, FormatJSON, FormatSARIF)
+	}
 	return &Request{
 		ModuleURLPath: mp,
 		QueryParams:   rp,
 	}, nil
 }
 
+// PlatformEnv returns the environment variables that select the platform p,
+// of the form GOOS/GOARCH.
+func PlatformEnv(p string) ([]string, error) {
+	goos, goarch, ok := strings.Cut(p, "/")
+	if !ok || !platformPartRegexp.MatchString(goos) || !platformPartRegexp.MatchString(goarch) {
+		return nil, fmt.Errorf("bad platform %q: want GOOS/GOARCH", p)
+	}
+	return []string{"GOOS=" + goos, "GOARCH=" + goarch}, nil
+}
+
+var platformPartRegexp = regexp.MustCompile(`^[a-z][a-z0-9]*$`)
+
 // ConvertGovulncheckFinding takes a finding from govulncheck and converts it to
 // a bigquery vuln.
 func ConvertGovulncheckFinding(f *govulncheckapi.Finding, o *osv.Entry) *Vuln {
@@ -113,17 +195,22 @@ func ConvertGovulncheckFinding(f *govulncheckapi.Finding, o *osv.Entry) *Vuln {
 		reviewed = o.DatabaseSpecific.ReviewStatus.String()
 	}
 	return &Vuln{
-		ID:          f.OSV,
-		PackagePath: vulnerableFrame.Package,
-		ModulePath:  vulnerableFrame.Module,
-		Version:     vulnerableFrame.Version,
-		ReviewStatus: bq.NullString{
-			StringVal: reviewed,
-			Valid:     reviewed != "",
-		},
+		ID:           f.OSV,
+		PackagePath:  vulnerableFrame.Package,
+		ModulePath:   vulnerableFrame.Module,
+		Version:      vulnerableFrame.Version,
+		ReviewStatus: nullString(reviewed),
+		FixedVersion: nullString(f.FixedVersion),
+		Symbol:       nullString(vulnerableFrame.Symbol()),
+		Position:     nullString(vulnerableFrame.Position.String()),
 	}
 }
 
+// nullString returns s as a bq.NullString that is null if s is empty.
+func nullString(s string) bq.NullString {
+	return bq.NullString{StringVal: s, Valid: s != ""}
+}
+
 const TableName = "govulncheck"
 
 // Note: before modifying Result or Vuln, make sure the change
@@ -133,6 +220,7 @@ const TableName = "govulncheck"
 //   - dropping a column
 //   - changing a column from required to nullable.
 // See https://cloud.google.com/bigquery/docs/managing-table-schemas for details.
+// TestSchemaChanges in internal/bigquery enforces this.
 
 // Result is a row in the BigQuery govulncheck table.
 type Result struct {
@@ -144,14 +232,86 @@ type Result struct {
 	ImportedBy    int       `bigquery:"imported_by"`
 	Error         string    `bigquery:"error"`
 	ErrorCategory string    `bigquery:"error_category"`
-	CommitTime    time.Time `bigquery:"commit_time"`
-	ScanSeconds   float64   `bigquery:"scan_seconds"`
-	// BinaryBuildSeconds is populated only in COMPARE - BINARY mode
+	// ErrorCode is the stable code of the error; see derrors.ErrorCode.
+	ErrorCode   bq.NullInt64 `bigquery:"error_code"`
+	CommitTime  time.Time    `bigquery:"commit_time"`
+	ScanSeconds float64      `bigquery:"scan_seconds"`
+	// BinaryBuildSeconds, BinaryBuildMemory (in kb), BinarySize and
+	// BuildArtifactsSize (in bytes) are populated only in COMPARE - BINARY mode.
 	BinaryBuildSeconds bq.NullFloat64 `bigquery:"build_seconds"`
-	ScanMemory         int64          `bigquery:"scan_memory"`
-	ScanMode           string         `bigquery:"scan_mode"`
-	WorkVersion                       // InferSchema flattens embedded fields
-	Vulns              []*Vuln        `bigquery:"vulns"`
+	BinaryBuildMemory  bq.NullInt64   `bigquery:"build_memory"`
+	BinarySize         bq.NullInt64   `bigquery:"binary_size"`
+	BuildArtifactsSize bq.NullInt64   `bigquery:"build_artifacts_size"`
+	// BinaryCached reports whether the binary was taken from the cache of
+	// binaries built earlier, so that the build statistics other than
+	// BinarySize are null. It is null except in COMPARE - BINARY mode.
+	BinaryCached bq.NullBool `bigquery:"binary_cached"`
+	ScanMemory   int64       `bigquery:"scan_memory"`
+	ScanMode     string      `bigquery:"scan_mode"`
+	WorkVersion              // InferSchema flattens embedded fields
+	Vulns        []*Vuln     `bigquery:"vulns"`
+	// OSV is the ID of the OSV entry whose publication prompted the
+	// scan, for scans enqueued by govulncheck/enqueue-osv.
+	OSV bq.NullString `bigquery:"osv_id"`
+	// ModuleSize is the size in bytes of the module zip. NumDirectDeps
+	// and NumIndirectDeps are the numbers of direct and indirect
+	// requirements in the module's go.mod file, after go mod tidy for
+	// modules without one. They are missing if the module could not be
+	// downloaded or prepared for scanning.
+	ModuleSize      bq.NullInt64 `bigquery:"module_size"`
+	NumDirectDeps   bq.NullInt64 `bigquery:"num_direct_deps"`
+	NumIndirectDeps bq.NullInt64 `bigquery:"num_indirect_deps"`
+	// Platform is the GOOS/GOARCH the module was scanned for, or null for
+	// the worker's platform.
+	Platform bq.NullString `bigquery:"platform"`
+	// BinarySelection describes how the binaries of a compare-mode scan
+	// were chosen, like "max=5", or is null if all were built.
+	BinarySelection bq.NullString `bigquery:"binary_selection"`
+	// ChecksumVerification is the result of verifying the module zip
+	// against the checksum database, like "verified"; see
+	// modules.Verified and related constants. It is null if the module
+	// was not downloaded.
+	ChecksumVerification bq.NullString `bigquery:"checksum_verification"`
+	// PackagesAnalyzed and DependentModules are the statistics
+	// govulncheck reported in its progress messages; see ProgressStats.
+	// Each is null if it was not reported.
+	PackagesAnalyzed bq.NullInt64 `bigquery:"packages_analyzed"`
+	DependentModules bq.NullInt64 `bigquery:"dependent_modules"`
+	// Subdir is the directory of the download that was scanned, for
+	// repos whose Go module is not at the root, or null if it was the
+	// root.
+	Subdir bq.NullString `bigquery:"subdir"`
+	// Labels are the experiment labels of the enqueue that requested the
+	// scan; see scan.ParseLabels.
+	Labels []*scan.Label `bigquery:"labels"`
+	// Unaffected are the OSVs that govulncheck evaluated for the module,
+	// because it requires a module they affect, but did not find at the
+	// level of ScanMode. It is empty unless the scan was requested with
+	// QueryParams.Unaffected.
+	Unaffected []*UnaffectedOSV `bigquery:"unaffected"`
+	// PrepStrategy is how the requirements of a module without a go.mod
+	// file were added after "go mod init": "tidy", or one of the
+	// fallbacks tried when "go mod tidy" fails, "tidy -e" or "-mod=mod".
+	// It is null if the module had a go.mod file or was not prepared.
+	PrepStrategy bq.NullString `bigquery:"prep_strategy"`
+}
+
+// An UnaffectedOSV is an OSV entry that govulncheck evaluated for a module
+// but did not find at the level of a scan mode.
+type UnaffectedOSV struct {
+	ID string `bigquery:"id"`
+	// FoundLevel is the most precise level, less precise than that of
+	// the scan mode, at which govulncheck found the vulnerability, like
+	// "module" for a vulnerable module version whose vulnerable symbols
+	// are not reachable. It is null if govulncheck did not find it at
+	// all, as when no required version of the module is affected.
+	FoundLevel bq.NullString `bigquery:"found_level"`
+}
+
+// SetProgress records the progress statistics p in r.
+func (r *Result) SetProgress(p ProgressStats) {
+	r.PackagesAnalyzed = p.PackagesAnalyzed
+	r.DependentModules = p.DependentModules
 }
 
 // WorkState returns a WorkState for the Result.
@@ -159,6 +319,7 @@ func (r *Result) WorkState() *WorkState {
 	return &WorkState{
 		WorkVersion:   &r.WorkVersion,
 		ErrorCategory: r.ErrorCategory,
+		Labels:        scan.FormatLabels(r.Labels),
 	}
 }
 
@@ -176,6 +337,12 @@ type WorkVersion struct {
 	SchemaVersion string ` bigquery:"schema_version"`
 	// When the vuln DB was last modified.
 	VulnDBLastModified time.Time `bigquery:"vulndb_last_modified"`
+	// Hash of the govulncheck binary. This tracks changes to govulncheck
+	// that are not changes to the worker, such as a new binary in the image.
+	GovulncheckHash bq.NullString `bigquery:"govulncheck_hash"`
+	// The gs:// URL of the vuln DB snapshot used instead of the worker's
+	// vuln DB, if any.
+	VulnDBSnapshot bq.NullString `bigquery:"vulndb_snapshot"`
 }
 
 func (v1 *WorkVersion) Equal(v2 *WorkVersion) bool {
@@ -185,7 +352,9 @@ func (v1 *WorkVersion) Equal(v2 *WorkVersion) bool {
 	return v1.GoVersion == v2.GoVersion &&
 		v1.WorkerVersion == v2.WorkerVersion &&
 		v1.SchemaVersion == v2.SchemaVersion &&
-		v1.VulnDBLastModified.Equal(v2.VulnDBLastModified)
+		v1.VulnDBLastModified.Equal(v2.VulnDBLastModified) &&
+		v1.GovulncheckHash == v2.GovulncheckHash &&
+		v1.VulnDBSnapshot == v2.VulnDBSnapshot
 }
 
 func (vr *Result) SetUploadTime(t time.Time) { vr.CreatedAt = t }
@@ -195,7 +364,9 @@ func (vr *Result) AddError(err error) {
 		return
 	}
 	vr.Error = err.Error()
-	vr.ErrorCategory = derrors.CategorizeError(err)
+	code := derrors.CodeOf(err)
+	vr.ErrorCategory = code.Category()
+	vr.ErrorCode = bq.NullInt64{Int64: int64(code), Valid: true}
 }
 
 // Vuln is a record in Result.
@@ -210,6 +381,136 @@ type Vuln struct {
 	// that do not exist in ecosystem metrics, we
 	// just put the review status here instead.
 	ReviewStatus bq.NullString `bigquery:"review_status"`
+	// FixedVersion is the version of the module in which the
+	// vulnerability is fixed, if there is one.
+	FixedVersion bq.NullString `bigquery:"fixed_version"`
+	// Symbol is the vulnerable function or method, for symbol-level
+	// findings.
+	Symbol bq.NullString `bigquery:"symbol"`
+	// Position is the source position of the vulnerable symbol in the
+	// trace, as file:line:column, if known.
+	Position bq.NullString `bigquery:"position"`
+	// TestOnly reports whether the vulnerability is only reachable from
+	// the tests of the module and their dependencies. It is null if that
+	// is not known, as for binaries and rows written before it existed.
+	TestOnly bq.NullBool `bigquery:"test_only"`
+}
+
+// Key returns the fields of v that identify a vulnerable package,
+// ignoring details that vary between findings of the same package,
+// like the symbol.
+func (v *Vuln) Key() Vuln {
+	return Vuln{ID: v.ID, PackagePath: v.PackagePath, ModulePath: v.ModulePath, Version: v.Version}
+}
+
+// CompareSummaryTableName is the name of the table holding
+// CompareSummary rows.
+const CompareSummaryTableName = "govulncheck-compare-summary"
+
+// CompareSummary is a row in the BigQuery govulncheck compare summary
+// table. It describes how well the findings of govulncheck in binary
+// mode agree with those in source mode, over all binaries of a module
+// version.
+//
+// A finding is identified by the binary and the OSV ID.
+type CompareSummary struct {
+	CreatedAt   time.Time `bigquery:"created_at"`
+	ModulePath  string    `bigquery:"module_path"`
+	Version     string    `bigquery:"version"`
+	SortVersion string    `bigquery:"sort_version"`
+	ImportedBy  int       `bigquery:"imported_by"`
+	CommitTime  time.Time `bigquery:"commit_time"`
+	NumBinaries int       `bigquery:"num_binaries"`
+	BinaryOnly  int       `bigquery:"binary_only"` // findings only in binary mode
+	SourceOnly  int       `bigquery:"source_only"` // findings only in source mode
+	Both        int       `bigquery:"both"`        // findings in both modes
+	// Precision and recall of binary mode, treating source mode as
+	// the ground truth. They are null when undefined.
+	Precision bq.NullFloat64 `bigquery:"precision"`
+	Recall    bq.NullFloat64 `bigquery:"recall"`
+	// The cost of building the binaries, for comparison with that of
+	// scanning the source: the total build time, the peak memory of any
+	// build in kb, and the total sizes of the binaries and of the
+	// intermediate artifacts, in bytes.
+	BuildSeconds       bq.NullFloat64 `bigquery:"build_seconds"`
+	BuildMemory        bq.NullInt64   `bigquery:"build_memory"`
+	BinarySize         bq.NullInt64   `bigquery:"binary_size"`
+	BuildArtifactsSize bq.NullInt64   `bigquery:"build_artifacts_size"`
+	// CachedBinaries is the number of binaries that were taken from the
+	// cache of binaries built earlier instead of being built. Only their
+	// sizes count in the build costs.
+	CachedBinaries bq.NullInt64 `bigquery:"cached_binaries"`
+	// NumMainPackages is the number of main packages in the module, of
+	// which NumBinaries were built as BinarySelection describes.
+	NumMainPackages bq.NullInt64  `bigquery:"num_main_packages"`
+	BinarySelection bq.NullString `bigquery:"binary_selection"`
+	WorkVersion                   // InferSchema flattens embedded fields
+}
+
+func (s *CompareSummary) SetUploadTime(t time.Time) { s.CreatedAt = t }
+
+// NewCompareSummary returns a CompareSummary for the module version
+// described by base, with no findings.
+func NewCompareSummary(base *Result) *CompareSummary {
+	return &CompareSummary{
+		ModulePath:      base.ModulePath,
+		Version:         base.Version,
+		SortVersion:     base.SortVersion,
+		ImportedBy:      base.ImportedBy,
+		CommitTime:      base.CommitTime,
+		BinarySelection: base.BinarySelection,
+		WorkVersion:     base.WorkVersion,
+	}
+}
+
+// Add adds the binary and source mode vulns for a single binary to s.
+func (s *CompareSummary) Add(binaryVulns, sourceVulns []*Vuln) {
+	ids := func(vs []*Vuln) map[string]bool {
+		m := map[string]bool{}
+		for _, v := range vs {
+			m[v.ID] = true
+		}
+		return m
+	}
+	bin := ids(binaryVulns)
+	src := ids(sourceVulns)
+	for id := range bin {
+		if src[id] {
+			s.Both++
+		} else {
+			s.BinaryOnly++
+		}
+	}
+	for id := range src {
+		if !bin[id] {
+			s.SourceOnly++
+		}
+	}
+	s.NumBinaries++
+
+	ratio := func(n, d int) bq.NullFloat64 {
+		if d == 0 {
+			return bq.NullFloat64{}
+		}
+		return bigquery.NullFloat(float64(n) / float64(d))
+	}
+	s.Precision = ratio(s.Both, s.Both+s.BinaryOnly)
+	s.Recall = ratio(s.Both, s.Both+s.SourceOnly)
+}
+
+// AddBuild adds the cost of building a single binary, as recorded in
+// the stats of its binary-mode scan, to s.
+func (s *CompareSummary) AddBuild(stats ScanStats) {
+	if stats.BinaryCached {
+		s.CachedBinaries = bigquery.NullInt(int(s.CachedBinaries.Int64 + 1))
+		s.BinarySize = bigquery.NullInt(int(s.BinarySize.Int64 + stats.BinarySize))
+		return
+	}
+	s.CachedBinaries = bigquery.NullInt(int(s.CachedBinaries.Int64))
+	s.BuildSeconds = bigquery.NullFloat(s.BuildSeconds.Float64 + stats.BuildTime.Seconds())
+	s.BuildMemory = bigquery.NullInt(int(max(s.BuildMemory.Int64, int64(stats.BuildMemory))))
+	s.BinarySize = bigquery.NullInt(int(s.BinarySize.Int64 + stats.BinarySize))
+	s.BuildArtifactsSize = bigquery.NullInt(int(s.BuildArtifactsSize.Int64 + stats.BuildArtifactsSize))
 }
 
 // SchemaVersion changes whenever the govulncheck schema changes.
@@ -222,11 +523,24 @@ func init() {
 	}
 	SchemaVersion = bigquery.SchemaVersion(s)
 	bigquery.AddTable(TableName, s)
+
+	s, err = bigquery.InferSchema(CompareSummary{})
+	if err != nil {
+		panic(err)
+	}
+	bigquery.AddTable(CompareSummaryTableName, s)
 }
 
 type WorkState struct {
 	WorkVersion   *WorkVersion
 	ErrorCategory string
+	// ContentHash is the hash of the module's contents, as computed by
+	// modules.ContentHash. It is empty if the hash was not computed.
+	ContentHash string
+	// Labels are the experiment labels of the scan, as formatted by
+	// scan.FormatLabels. A scan is not skipped for an earlier one with
+	// other labels, so that every module has rows with its labels.
+	Labels string
 }
 
 // ScanStats contains monitoring information for a govulncheck run.
@@ -239,6 +553,19 @@ type ScanStats struct {
 	// *BEFORE* scanning it with govulncheck.
 	// This is only used in COMPARE - BINARY mode
 	BuildTime time.Duration
+	// BuildMemory is the peak memory used by the go build command, in kb,
+	// BinarySize is the size of the binary it built, and
+	// BuildArtifactsSize is the size of the intermediate artifacts it wrote,
+	// both in bytes. Like BuildTime, these are only used in COMPARE - BINARY mode.
+	BuildMemory        uint64
+	BinarySize         int64
+	BuildArtifactsSize int64
+	// BinaryCached reports whether the binary was taken from the cache of
+	// binaries built earlier, in which case the build statistics other
+	// than BinarySize are zero. It is only used in COMPARE - BINARY mode.
+	BinaryCached bool
+	// Progress holds the statistics govulncheck reported while scanning.
+	Progress ProgressStats
 }
 
 // AnalysisResponse contains the raw govulncheck result
@@ -246,9 +573,117 @@ type ScanStats struct {
 // govulncheck on source code or a binary. Used when
 // running govulncheck inside and outside of a sandbox.
 type AnalysisResponse struct {
+	Config   *govulncheckapi.Config `json:",omitempty"`
 	Findings []*govulncheckapi.Finding
 	OSVs     map[string]*osv.Entry
 	Stats    ScanStats
+	// Production is the package graph of the scanned packages without
+	// their tests, or nil if it is not known. See TestOnly.
+	Production *PackageGraph `json:",omitempty"`
+	// ProductionError is the error listing the packages of Production,
+	// if there was one.
+	ProductionError string `json:",omitempty"`
+}
+
+// A PackageGraph lists the packages that some packages depend on,
+// including themselves, and the modules that provide them. Both lists
+// are sorted.
+type PackageGraph struct {
+	Packages []string
+	Modules  []string
+}
+
+// TestOnly reports whether the vulnerability of f is only reachable from
+// tests: its package is imported only by tests, or its module only
+// provides packages for tests. The second result is false if that is not
+// known.
+//
+// A finding's trace is only one of the paths to its symbol, so a trace
+// that enters from a test does not show that the symbol is unreachable
+// from the packages themselves. A symbol-level finding is therefore only
+// test-only if its package is.
+func (r *AnalysisResponse) TestOnly(f *govulncheckapi.Finding) (testOnly, known bool) {
+	if r.Production == nil || len(f.Trace) == 0 {
+		return false, false
+	}
+	has := func(list []string, s string) bool {
+		i := sort.SearchStrings(list, s)
+		return i < len(list) && list[i] == s
+	}
+	vuln := f.Trace[0]
+	switch f.Level() {
+	case govulncheckapi.ScanLevelSymbol, govulncheckapi.ScanLevelPackage:
+		return !has(r.Production.Packages, vuln.Package), true
+	default:
+		if vuln.Module == osv.GoStdModulePath {
+			// Every program uses the standard library.
+			return false, true
+		}
+		return !has(r.Production.Modules, vuln.Module), true
+	}
+}
+
+// levelRanks orders the scan levels from the least to the most precise.
+var levelRanks = map[govulncheckapi.ScanLevel]int{
+	govulncheckapi.ScanLevelModule:  1,
+	govulncheckapi.ScanLevelPackage: 2,
+	govulncheckapi.ScanLevelSymbol:  3,
+}
+
+// Unaffected returns the OSV entries of r that govulncheck did not find at
+// the given level, sorted by ID.
+func (r *AnalysisResponse) Unaffected(level govulncheckapi.ScanLevel) []*UnaffectedOSV {
+	found := map[string]govulncheckapi.ScanLevel{}
+	for _, f := range r.Findings {
+		if l := f.Level(); levelRanks[l] > levelRanks[found[f.OSV]] {
+			found[f.OSV] = l
+		}
+	}
+	var us []*UnaffectedOSV
+	for id := range r.OSVs {
+		l := found[id]
+		if levelRanks[l] >= levelRanks[level] {
+			continue
+		}
+		us = append(us, &UnaffectedOSV{ID: id, FoundLevel: nullString(string(l))})
+	}
+	sort.Slice(us, func(i, j int) bool { return us[i].ID < us[j].ID })
+	return us
+}
+
+// listProduction returns the package graph of the packages matching
+// pattern in dir, without their tests.
+func listProduction(dir, pattern string, env []string) (_ *PackageGraph, err error) {
+	defer derrors.Wrap(&err, "listProduction(%q, %q)", dir, pattern)
+	cmd := exec.Command("go", "list", "-e", "-deps", "-json=ImportPath,Module", pattern)
+	cmd.Dir = dir
+	cmd.Env = append(cmd.Environ(), env...)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errors.New(derrors.IncludeStderr(err))
+	}
+	var g PackageGraph
+	mods := map[string]bool{}
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for {
+		var p struct {
+			ImportPath string
+			Module     *struct{ Path string }
+		}
+		if err := dec.Decode(&p); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		g.Packages = append(g.Packages, p.ImportPath)
+		if p.Module != nil && !mods[p.Module.Path] {
+			mods[p.Module.Path] = true
+			g.Modules = append(g.Modules, p.Module.Path)
+		}
+	}
+	sort.Strings(g.Packages)
+	sort.Strings(g.Modules)
+	return &g, nil
 }
 
 func UnmarshalAnalysisResponse(output []byte) (*AnalysisResponse, error) {
@@ -271,6 +706,9 @@ func UnmarshalAnalysisResponse(output []byte) (*AnalysisResponse, error) {
 type CompareResponse struct {
 	// Map from package import path to pair of binary & source mode findings
 	FindingsForMod map[string]*ComparePair
+	// NumMainPackages is the number of main packages found in the module,
+	// including those not selected for building.
+	NumMainPackages int
 }
 
 type ComparePair struct {
@@ -295,6 +733,26 @@ func UnmarshalCompareResponse(output []byte) (*CompareResponse, error) {
 }
 
 func RunGovulncheckCmd(govulncheckPath, modeFlag, pattern, moduleDir, vulndbDir string) (*AnalysisResponse, error) {
+	return runGovulncheckCmd(govulncheckPath, modeFlag, pattern, moduleDir, vulndbDir, false, nil, nil)
+}
+
+// RunGovulncheckCmdWithProgress is like RunGovulncheckCmd, but if progress is
+// non-nil, it calls progress when the scan starts and every ProgressInterval
+// while it runs. The variables in env, like those of PlatformEnv, are added
+// to the environment of govulncheck.
+//
+// If tests is true, which it may only be in source mode, the tests of the
+// packages are scanned too, and the response records the packages without
+// their tests, so that the findings only in tests can be told apart; see
+// AnalysisResponse.TestOnly.
+func RunGovulncheckCmdWithProgress(govulncheckPath, modeFlag, pattern, moduleDir, vulndbDir string, tests bool, env []string, progress func(Progress)) (*AnalysisResponse, error) {
+	if tests && modeFlag != FlagSource {
+		return nil, fmt.Errorf("tests can only be scanned in %s mode", FlagSource)
+	}
+	return runGovulncheckCmd(govulncheckPath, modeFlag, pattern, moduleDir, vulndbDir, tests, env, progress)
+}
+
+func runGovulncheckCmd(govulncheckPath, modeFlag, pattern, moduleDir, vulndbDir string, tests bool, env []string, progress func(Progress)) (*AnalysisResponse, error) {
 	stdOut := bytes.Buffer{}
 	stdErr := bytes.Buffer{}
 	uri := "file://" + vulndbDir
@@ -302,17 +760,32 @@ func RunGovulncheckCmd(govulncheckPath, modeFlag, pattern, moduleDir, vulndbDir
 		uri = "file:///" + filepath.ToSlash(vulndbDir)
 	}
 	args := []string{"-mode", modeFlag, "-json", "-db", uri}
+	if tests {
+		args = append(args, "-test")
+	}
 	if moduleDir != "" {
 		args = append(args, "-C", moduleDir)
 	}
 	args = append(args, pattern)
 	govulncheckCmd := exec.Command(govulncheckPath, args...)
+	if len(env) > 0 {
+		govulncheckCmd.Env = append(govulncheckCmd.Environ(), env...)
+	}
 
 	govulncheckCmd.Stdout = &stdOut
 	govulncheckCmd.Stderr = &stdErr
 
 	start := time.Now()
-	if err := govulncheckCmd.Run(); err != nil {
+	if err := govulncheckCmd.Start(); err != nil {
+		return nil, err
+	}
+	if progress != nil {
+		progress(Progress{Phase: PhaseScan})
+		stop := make(chan struct{})
+		defer close(stop)
+		go watchProgress(govulncheckCmd, start, progress, stop)
+	}
+	if err := govulncheckCmd.Wait(); err != nil {
 		return nil, errors.New(stdErr.String())
 	}
 	end := time.Now()
@@ -322,14 +795,26 @@ func RunGovulncheckCmd(govulncheckPath, modeFlag, pattern, moduleDir, vulndbDir
 	if err != nil {
 		return nil, err
 	}
-	return &AnalysisResponse{
+	resp := &AnalysisResponse{
+		Config:   handler.ScanConfig(),
 		Findings: handler.Findings(),
 		OSVs:     handler.OSVs(),
 		Stats: ScanStats{
 			ScanSeconds: end.Sub(start).Seconds(),
 			ScanMemory:  getMemoryUsage(govulncheckCmd),
+			Progress:    handler.ProgressStats(),
 		},
-	}, nil
+	}
+	if tests {
+		// If the packages can't be listed, which findings are only in
+		// tests is unknown, but the findings are still valid.
+		var err error
+		resp.Production, err = listProduction(moduleDir, pattern, env)
+		if err != nil {
+			resp.ProductionError = err.Error()
+		}
+	}
+	return resp, nil
 }
 
 // getMemoryUsage is overridden with a Unix-specific function on Linux.
@@ -337,7 +822,10 @@ var getMemoryUsage = func(c *exec.Cmd) uint64 {
 	return 0
 }
 
-const collName = "GovulncheckWorkStates"
+const (
+	collName        = "GovulncheckWorkStates"
+	contentCollName = "GovulncheckContentWorkStates"
+)
 
 // SetWorkState writes the work state for modulePath@version.
 func SetWorkState(ctx context.Context, ns *fstore.Namespace, modulePath, version string, ws *WorkState) (err error) {
@@ -356,8 +844,96 @@ func GetWorkState(ctx context.Context, ns *fstore.Namespace, modulePath, version
 	}()
 
 	defer derrors.Wrap(&err, "ReadWorkState(%q, %q)", modulePath, version)
-	dr := ns.Collection(collName).Doc(docName(modulePath, version))
-	ws, err = fstore.Get[WorkState](ctx, dr)
+	return getWorkState(ctx, ns.Collection(collName).Doc(docName(modulePath, version)))
+}
+
+// SetContentWorkState writes the work state for the version of modulePath
+// whose content hash is ws.ContentHash, which must be non-empty.
+func SetContentWorkState(ctx context.Context, ns *fstore.Namespace, modulePath string, ws *WorkState) (err error) {
+	defer func() {
+		log.Debugf(ctx, "SetContentWorkState(%s, %+v) => %v", modulePath, ws, err)
+	}()
+	if ws.ContentHash == "" {
+		return errors.New("SetContentWorkState: empty content hash")
+	}
+	dr := ns.Collection(contentCollName).Doc(docName(modulePath, ws.ContentHash))
+	return fstore.Set[WorkState](ctx, dr, ws)
+}
+
+// GetContentWorkState reads the work state for the version of modulePath
+// whose content hash is contentHash, regardless of the version string.
+// If there is none, it returns (nil, nil).
+func GetContentWorkState(ctx context.Context, ns *fstore.Namespace, modulePath, contentHash string) (ws *WorkState, err error) {
+	defer func() {
+		log.Debugf(ctx, "GetContentWorkState(%s, %s) => (%+v, %v)", modulePath, contentHash, ws, err)
+	}()
+
+	defer derrors.Wrap(&err, "GetContentWorkState(%q, %q)", modulePath, contentHash)
+	return getWorkState(ctx, ns.Collection(contentCollName).Doc(docName(modulePath, contentHash)))
+}
+
+// A WorkStateFilter selects work states to delete. A work state must
+// match all the non-zero fields.
+type WorkStateFilter struct {
+	// Before selects the work states last written before it.
+	Before time.Time
+	// WorkerVersion selects the work states written by that version of
+	// the worker.
+	WorkerVersion string
+}
+
+// IsZero reports whether f has no criteria, and so would select every
+// work state.
+func (f *WorkStateFilter) IsZero() bool {
+	return f.Before.IsZero() && f.WorkerVersion == ""
+}
+
+// Match reports whether f selects ws, last written at updated.
+func (f *WorkStateFilter) Match(ws *WorkState, updated time.Time) bool {
+	if !f.Before.IsZero() && !updated.Before(f.Before) {
+		return false
+	}
+	if f.WorkerVersion != "" && (ws.WorkVersion == nil || ws.WorkVersion.WorkerVersion != f.WorkerVersion) {
+		return false
+	}
+	return true
+}
+
+// DeleteWorkStates deletes the work states, both by version and by content
+// hash, that f selects, and returns how many there were. If dryRun is
+// true, it only counts them. The modules of the deleted work states are
+// scanned again the next time they are enqueued.
+//
+// It is an error for f to have no criteria.
+func DeleteWorkStates(ctx context.Context, ns *fstore.Namespace, f *WorkStateFilter, dryRun bool) (n int, err error) {
+	defer derrors.Wrap(&err, "DeleteWorkStates(%+v, %t)", f, dryRun)
+	if f.IsZero() {
+		return 0, fmt.Errorf("%w: empty filter", derrors.InvalidArgument)
+	}
+	match := func(ds *firestore.DocumentSnapshot) bool {
+		if f.WorkerVersion == "" {
+			// Avoid decoding when only the update time matters.
+			return f.Match(&WorkState{}, ds.UpdateTime)
+		}
+		ws, err := fstore.Decode[WorkState](ds)
+		if err != nil {
+			log.Warnf(ctx, "DeleteWorkStates: %s: %v", ds.Ref.ID, err)
+			return false
+		}
+		return f.Match(ws, ds.UpdateTime)
+	}
+	for _, name := range []string{collName, contentCollName} {
+		m, err := fstore.DeleteMatching(ctx, ns.Collection(name), match, dryRun)
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func getWorkState(ctx context.Context, dr *firestore.DocumentRef) (*WorkState, error) {
+	ws, err := fstore.Get[WorkState](ctx, dr)
 	if errors.Is(err, derrors.NotFound) {
 		return nil, nil
 	}
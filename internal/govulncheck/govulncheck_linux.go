@@ -0,0 +1,43 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+func init() {
+	getCurrentMemoryUsage = func(pid int) uint64 {
+		data, err := os.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
+		if err != nil {
+			return 0
+		}
+		return parseVmRSS(data)
+	}
+}
+
+// parseVmRSS returns the value of the VmRSS line of a /proc/PID/status
+// file, in KB, or 0 if there is none.
+func parseVmRSS(status []byte) uint64 {
+	for _, line := range bytes.Split(status, []byte("\n")) {
+		rest, ok := bytes.CutPrefix(line, []byte("VmRSS:"))
+		if !ok {
+			continue
+		}
+		fields := bytes.Fields(rest) // e.g. "1234 kB"
+		if len(fields) == 0 {
+			return 0
+		}
+		n, err := strconv.ParseUint(string(fields[0]), 10, 64)
+		if err != nil {
+			return 0
+		}
+		return n
+	}
+	return 0
+}
@@ -0,0 +1,23 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"os"
+	"testing"
+)
+
+func TestParseVmRSS(t *testing.T) {
+	status := []byte("Name:\tgovulncheck\nVmPeak:\t  20000 kB\nVmRSS:\t   1234 kB\nThreads:\t8\n")
+	if got, want := parseVmRSS(status), uint64(1234); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+	if got := parseVmRSS([]byte("Name:\tx\n")); got != 0 {
+		t.Errorf("no VmRSS: got %d, want 0", got)
+	}
+	if got := getCurrentMemoryUsage(os.Getpid()); got == 0 {
+		t.Error("got 0 RSS for the current process")
+	}
+}
@@ -7,6 +7,8 @@ package govulncheck
 import (
 	"context"
 	"fmt"
+	"io"
+	"strings"
 	"testing"
 	"time"
 
@@ -15,6 +17,7 @@ import (
 	"golang.org/x/pkgsite-metrics/internal/bigquery"
 	"golang.org/x/pkgsite-metrics/internal/fstore"
 	"golang.org/x/pkgsite-metrics/internal/govulncheckapi"
+	"golang.org/x/pkgsite-metrics/internal/osv"
 	test "golang.org/x/pkgsite-metrics/internal/testing"
 	"google.golang.org/api/iterator"
 )
@@ -23,14 +26,16 @@ func TestConvertGovulncheckFinding(t *testing.T) {
 	var (
 		osvID = "GO-YYYY-XXXX"
 		vuln1 = &govulncheckapi.Finding{
-			OSV: osvID,
+			OSV:          osvID,
+			FixedVersion: "v0.0.2",
 			Trace: []*govulncheckapi.Frame{
 				{
 					Module:   "example.com/repo/module",
 					Version:  "v0.0.1",
 					Package:  "example.com/repo/module/package",
 					Function: "func",
-					Position: &govulncheckapi.Position{},
+					Receiver: "T",
+					Position: &govulncheckapi.Position{Filename: "f.go", Line: 10, Column: 2},
 				},
 			},
 		}
@@ -57,10 +62,13 @@ func TestConvertGovulncheckFinding(t *testing.T) {
 			name: "called",
 			vuln: vuln1,
 			wantVuln: &Vuln{
-				ID:          "GO-YYYY-XXXX",
-				PackagePath: "example.com/repo/module/package",
-				ModulePath:  "example.com/repo/module",
-				Version:     "v0.0.1",
+				ID:           "GO-YYYY-XXXX",
+				PackagePath:  "example.com/repo/module/package",
+				ModulePath:   "example.com/repo/module",
+				Version:      "v0.0.1",
+				FixedVersion: bq.NullString{StringVal: "v0.0.2", Valid: true},
+				Symbol:       bq.NullString{StringVal: "T.func", Valid: true},
+				Position:     bq.NullString{StringVal: "f.go:10:2", Valid: true},
 			},
 		},
 		{
@@ -83,6 +91,203 @@ func TestConvertGovulncheckFinding(t *testing.T) {
 	}
 }
 
+func TestCompareSummary(t *testing.T) {
+	vulns := func(ids ...string) []*Vuln {
+		var vs []*Vuln
+		for _, id := range ids {
+			vs = append(vs, &Vuln{ID: id})
+		}
+		return vs
+	}
+	base := &Result{ModulePath: "m", Version: "v1.0.0", ImportedBy: 3}
+	got := NewCompareSummary(base)
+	// Duplicate IDs within a mode are counted once.
+	got.Add(vulns("A", "B", "B"), vulns("B", "C"))
+	got.Add(vulns("A"), vulns("A"))
+	got.AddBuild(ScanStats{BuildTime: 2 * time.Second, BuildMemory: 300, BinarySize: 1000, BuildArtifactsSize: 50})
+	got.AddBuild(ScanStats{BuildTime: time.Second, BuildMemory: 200, BinarySize: 500, BuildArtifactsSize: 0})
+	// Only the size of a cached binary counts.
+	got.AddBuild(ScanStats{BinarySize: 700, BinaryCached: true})
+	want := &CompareSummary{
+		ModulePath:         "m",
+		Version:            "v1.0.0",
+		ImportedBy:         3,
+		NumBinaries:        2,
+		BinaryOnly:         1,
+		SourceOnly:         1,
+		Both:               2,
+		Precision:          bigquery.NullFloat(2.0 / 3),
+		Recall:             bigquery.NullFloat(2.0 / 3),
+		BuildSeconds:       bigquery.NullFloat(3),
+		BuildMemory:        bigquery.NullInt(300),
+		BinarySize:         bigquery.NullInt(2200),
+		BuildArtifactsSize: bigquery.NullInt(50),
+		CachedBinaries:     bigquery.NullInt(1),
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	// With no findings, precision and recall are undefined.
+	got = NewCompareSummary(base)
+	got.Add(nil, nil)
+	if got.Precision.Valid || got.Recall.Valid {
+		t.Errorf("got precision %v, recall %v; want both null", got.Precision, got.Recall)
+	}
+}
+
+func TestWorkVersionEqual(t *testing.T) {
+	tm := time.Date(2022, 7, 21, 0, 0, 0, 0, time.UTC)
+	v := WorkVersion{
+		GoVersion:          "go1.21.0",
+		WorkerVersion:      "1",
+		SchemaVersion:      "s",
+		VulnDBLastModified: tm,
+		GovulncheckHash:    bigquery.NullString("h"),
+	}
+	v2 := v
+	if !v.Equal(&v2) {
+		t.Errorf("%+v not equal to itself", v)
+	}
+	v2.GovulncheckHash = bigquery.NullString("h2")
+	if v.Equal(&v2) {
+		t.Error("work versions with different govulncheck binaries are equal")
+	}
+	v2 = v
+	v2.VulnDBSnapshot = bigquery.NullString("gs://b/2024-01-01")
+	if v.Equal(&v2) {
+		t.Error("work versions with different vuln DB snapshots are equal")
+	}
+	if v.Equal(nil) {
+		t.Error("work version equal to nil")
+	}
+}
+
+func TestWorkStateFilterMatch(t *testing.T) {
+	cutoff := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	ws := &WorkState{WorkVersion: &WorkVersion{WorkerVersion: "w1"}}
+	for _, test := range []struct {
+		name    string
+		filter  WorkStateFilter
+		ws      *WorkState
+		updated time.Time
+		want    bool
+	}{
+		{"before", WorkStateFilter{Before: cutoff}, ws, cutoff.Add(-time.Hour), true},
+		{"at cutoff", WorkStateFilter{Before: cutoff}, ws, cutoff, false},
+		{"version", WorkStateFilter{WorkerVersion: "w1"}, ws, cutoff, true},
+		{"other version", WorkStateFilter{WorkerVersion: "w2"}, ws, cutoff, false},
+		{"no version", WorkStateFilter{WorkerVersion: "w1"}, &WorkState{}, cutoff, false},
+		{"both", WorkStateFilter{Before: cutoff, WorkerVersion: "w1"}, ws, cutoff.Add(-time.Hour), true},
+		{"both, after", WorkStateFilter{Before: cutoff, WorkerVersion: "w1"}, ws, cutoff.Add(time.Hour), false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.filter.Match(test.ws, test.updated); got != test.want {
+				t.Errorf("got %t, want %t", got, test.want)
+			}
+		})
+	}
+	if !(&WorkStateFilter{}).IsZero() {
+		t.Error("empty filter is not zero")
+	}
+}
+
+func TestPlatformEnv(t *testing.T) {
+	got, err := PlatformEnv("darwin/arm64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"GOOS=darwin", "GOARCH=arm64"}; !cmp.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	for _, p := range []string{"", "linux", "linux/", "/amd64", "linux/amd64/v2", "Linux/amd64", "linux/amd64 GOFLAGS=x"} {
+		if _, err := PlatformEnv(p); err == nil {
+			t.Errorf("%q: got nil, want error", p)
+		}
+	}
+}
+
+func TestTestOnly(t *testing.T) {
+	frame := func(mod, pkg, fn, file string) *govulncheckapi.Frame {
+		f := &govulncheckapi.Frame{Module: mod, Package: pkg, Function: fn}
+		if file != "" {
+			f.Position = &govulncheckapi.Position{Filename: file, Line: 1}
+		}
+		return f
+	}
+	resp := &AnalysisResponse{
+		Production: &PackageGraph{
+			Packages: []string{"example.com/m", "example.com/v/p", "fmt"},
+			Modules:  []string{"example.com/m", "example.com/v"},
+		},
+	}
+	for _, test := range []struct {
+		name  string
+		trace []*govulncheckapi.Frame
+		want  bool
+	}{
+		{"symbol", []*govulncheckapi.Frame{
+			frame("example.com/v", "example.com/v/p", "F", ""),
+			frame("example.com/m", "example.com/m", "G", "/m/m.go"),
+		}, false},
+		// The package of F is imported by example.com/m, which may call F
+		// on another path than this one.
+		{"symbol from test", []*govulncheckapi.Frame{
+			frame("example.com/v", "example.com/v/p", "F", ""),
+			frame("example.com/m", "example.com/m", "TestG", "/m/m_test.go"),
+		}, false},
+		{"symbol in test package", []*govulncheckapi.Frame{
+			frame("example.com/t", "example.com/t/q", "F", ""),
+			frame("example.com/m", "example.com/m", "G", "/m/m.go"),
+		}, true},
+		{"package", []*govulncheckapi.Frame{frame("example.com/v", "example.com/v/p", "", "")}, false},
+		{"test package", []*govulncheckapi.Frame{frame("example.com/v", "example.com/v/q", "", "")}, true},
+		{"module", []*govulncheckapi.Frame{frame("example.com/v", "", "", "")}, false},
+		{"test module", []*govulncheckapi.Frame{frame("example.com/t", "", "", "")}, true},
+		{"stdlib", []*govulncheckapi.Frame{frame("stdlib", "", "", "")}, false},
+	} {
+		got, known := resp.TestOnly(&govulncheckapi.Finding{Trace: test.trace})
+		if !known || got != test.want {
+			t.Errorf("%s: got %t, %t; want %t, true", test.name, got, known, test.want)
+		}
+	}
+	if _, known := (&AnalysisResponse{}).TestOnly(&govulncheckapi.Finding{Trace: []*govulncheckapi.Frame{frame("m", "", "", "")}}); known {
+		t.Error("without a package graph: got known")
+	}
+}
+
+func TestUnaffected(t *testing.T) {
+	finding := func(id string, fr *govulncheckapi.Frame) *govulncheckapi.Finding {
+		return &govulncheckapi.Finding{OSV: id, Trace: []*govulncheckapi.Frame{fr}}
+	}
+	mod := &govulncheckapi.Frame{Module: "example.com/v"}
+	pkg := &govulncheckapi.Frame{Module: "example.com/v", Package: "example.com/v/p"}
+	sym := &govulncheckapi.Frame{Module: "example.com/v", Package: "example.com/v/p", Function: "F"}
+	resp := &AnalysisResponse{
+		Findings: []*govulncheckapi.Finding{
+			finding("GO-2", mod),
+			finding("GO-3", mod), finding("GO-3", pkg),
+			finding("GO-4", mod), finding("GO-4", pkg), finding("GO-4", sym),
+		},
+		OSVs: map[string]*osv.Entry{"GO-1": nil, "GO-2": nil, "GO-3": nil, "GO-4": nil},
+	}
+	u := func(id, level string) *UnaffectedOSV {
+		return &UnaffectedOSV{ID: id, FoundLevel: nullString(level)}
+	}
+	for _, test := range []struct {
+		level govulncheckapi.ScanLevel
+		want  []*UnaffectedOSV
+	}{
+		{govulncheckapi.ScanLevelSymbol, []*UnaffectedOSV{u("GO-1", ""), u("GO-2", "module"), u("GO-3", "package")}},
+		{govulncheckapi.ScanLevelPackage, []*UnaffectedOSV{u("GO-1", ""), u("GO-2", "module")}},
+		{govulncheckapi.ScanLevelModule, []*UnaffectedOSV{u("GO-1", "")}},
+	} {
+		if diff := cmp.Diff(test.want, resp.Unaffected(test.level)); diff != "" {
+			t.Errorf("%s: mismatch (-want, +got):\n%s", test.level, diff)
+		}
+	}
+}
+
 func TestIntegration(t *testing.T) {
 	test.NeedsIntegrationEnv(t)
 
@@ -119,6 +324,7 @@ func TestIntegration(t *testing.T) {
 			WorkerVersion:      "1",
 			SchemaVersion:      "s",
 			VulnDBLastModified: tm,
+			GovulncheckHash:    bigquery.NullString("h"),
 		},
 		ErrorCategory: "SOME ERROR",
 	}
@@ -193,3 +399,80 @@ func readTable[T any](ctx context.Context, table *bq.Table, newT func() *T) ([]*
 	}
 	return ts, nil
 }
+
+func TestProgressWriter(t *testing.T) {
+	var got []Progress
+	w := NewProgressWriter(func(p Progress) { got = append(got, p) })
+	var buf strings.Builder
+	buf.WriteString("runner: starting\n")
+	if err := WriteProgress(&buf, Progress{Phase: PhaseScan}); err != nil {
+		t.Fatal(err)
+	}
+	buf.WriteString("some other output\n")
+	if err := WriteProgress(&buf, Progress{Phase: PhaseScan, Elapsed: 30, RSS: 1024}); err != nil {
+		t.Fatal(err)
+	}
+	// Write in pieces that split lines.
+	s := buf.String()
+	for len(s) > 0 {
+		n := min(7, len(s))
+		if _, err := io.WriteString(w, s[:n]); err != nil {
+			t.Fatal(err)
+		}
+		s = s[n:]
+	}
+	want := []Progress{{Phase: PhaseScan}, {Phase: PhaseScan, Elapsed: 30, RSS: 1024}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestMetricsHandlerProgress(t *testing.T) {
+	h := NewMetricsHandler()
+	for _, msg := range []string{
+		"Scanning your code and 12 packages across 2 dependent modules for known vulnerabilities...",
+		"Fetching vulnerabilities from the database...",
+		"Checking the code against the vulnerabilities...",
+	} {
+		if err := h.Progress(&govulncheckapi.Progress{Message: msg}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := h.ProgressStats()
+	want := ProgressStats{
+		PackagesAnalyzed: bq.NullInt64{Int64: 12, Valid: true},
+		DependentModules: bq.NullInt64{Int64: 2, Valid: true},
+	}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// A reported zero is recorded; an unreported count is null.
+	h = NewMetricsHandler()
+	if err := h.Progress(&govulncheckapi.Progress{Message: "Scanning your code and 0 packages across 0 dependent modules for known vulnerabilities..."}); err != nil {
+		t.Fatal(err)
+	}
+	var row Result
+	row.SetProgress(h.ProgressStats())
+	if !row.PackagesAnalyzed.Valid || row.PackagesAnalyzed.Int64 != 0 || !row.DependentModules.Valid {
+		t.Errorf("SetProgress: got %v, %v", row.PackagesAnalyzed, row.DependentModules)
+	}
+	row.SetProgress(ProgressStats{})
+	if row.PackagesAnalyzed.Valid || row.DependentModules.Valid {
+		t.Errorf("SetProgress of no stats: got %v, %v", row.PackagesAnalyzed, row.DependentModules)
+	}
+
+	// Without a progress message, dependent modules are counted from the SBOM.
+	h = NewMetricsHandler()
+	if err := h.SBOM(&govulncheckapi.SBOM{
+		Modules: []*govulncheckapi.Module{{Path: "example.com/main"}, {Path: "golang.org/x/text"}, {Path: "stdlib"}},
+		Roots:   []string{"example.com/main/cmd/m"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	got = h.ProgressStats()
+	want = ProgressStats{DependentModules: bq.NullInt64{Int64: 2, Valid: true}}
+	if got != want {
+		t.Errorf("from SBOM: got %+v, want %+v", got, want)
+	}
+}
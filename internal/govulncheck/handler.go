@@ -5,6 +5,12 @@
 package govulncheck
 
 import (
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	bq "cloud.google.com/go/bigquery"
 	"golang.org/x/pkgsite-metrics/internal/govulncheckapi"
 	"golang.org/x/pkgsite-metrics/internal/osv"
 )
@@ -18,15 +24,51 @@ func NewMetricsHandler() *MetricsHandler {
 }
 
 type MetricsHandler struct {
+	config   *govulncheckapi.Config
+	sbom     *govulncheckapi.SBOM
 	findings []*govulncheckapi.Finding
 	osvs     map[string]*osv.Entry
+	progress ProgressStats
+}
+
+// ProgressStats are the statistics that govulncheck reports in its
+// progress message "Scanning your code and 12 packages across 2 dependent
+// modules for known vulnerabilities...". Each is null if govulncheck did
+// not report it.
+type ProgressStats struct {
+	// PackagesAnalyzed is the number of packages that the scanned code
+	// depends on. It does not count the packages of the scanned code.
+	PackagesAnalyzed bq.NullInt64
+	// DependentModules is the number of modules that the scanned code
+	// depends on.
+	DependentModules bq.NullInt64
 }
 
+var (
+	packagesRegexp = regexp.MustCompile(`\b(\d+) packages?\b`)
+	modulesRegexp  = regexp.MustCompile(`\b(\d+) dependent modules?\b`)
+)
+
 func (h *MetricsHandler) Config(c *govulncheckapi.Config) error {
+	h.config = c
 	return nil
 }
 
 func (h *MetricsHandler) Progress(p *govulncheckapi.Progress) error {
+	count := func(re *regexp.Regexp, n *bq.NullInt64) {
+		if m := re.FindStringSubmatch(p.Message); m != nil {
+			if c, err := strconv.ParseInt(m[1], 10, 64); err == nil {
+				*n = bq.NullInt64{Int64: c, Valid: true}
+			}
+		}
+	}
+	count(packagesRegexp, &h.progress.PackagesAnalyzed)
+	count(modulesRegexp, &h.progress.DependentModules)
+	return nil
+}
+
+func (h *MetricsHandler) SBOM(s *govulncheckapi.SBOM) error {
+	h.sbom = s
 	return nil
 }
 
@@ -40,6 +82,38 @@ func (h *MetricsHandler) Finding(finding *govulncheckapi.Finding) error {
 	return nil
 }
 
+// ScanConfig returns the configuration of the scan, or nil if
+// govulncheck did not report it.
+func (h *MetricsHandler) ScanConfig() *govulncheckapi.Config {
+	return h.config
+}
+
+// ProgressStats returns the statistics reported in the progress messages
+// of the scan. Progress messages are informational, so if they do not
+// report the number of dependent modules, it is counted from the SBOM.
+func (h *MetricsHandler) ProgressStats() ProgressStats {
+	p := h.progress
+	if !p.DependentModules.Valid && h.sbom != nil {
+		p.DependentModules = bq.NullInt64{Int64: int64(dependentModules(h.sbom)), Valid: true}
+	}
+	return p
+}
+
+// dependentModules returns the number of modules of s that provide none
+// of its roots, counting the standard library, as govulncheck does in
+// its progress message.
+func dependentModules(s *govulncheckapi.SBOM) int {
+	n := 0
+	for _, m := range s.Modules {
+		if !slices.ContainsFunc(s.Roots, func(root string) bool {
+			return root == m.Path || strings.HasPrefix(root, m.Path+"/")
+		}) {
+			n++
+		}
+	}
+	return n
+}
+
 func (h *MetricsHandler) Findings() []*govulncheckapi.Finding {
 	return h.findings
 }
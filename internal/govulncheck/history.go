@@ -0,0 +1,63 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+)
+
+// A HistoryEntry summarizes a govulncheck result for a module, for
+// following the module's results over time.
+type HistoryEntry struct {
+	CreatedAt     time.Time `bigquery:"created_at"`
+	Version       string    `bigquery:"version"`
+	Suffix        string    `bigquery:"suffix"`
+	ScanMode      string    `bigquery:"scan_mode"`
+	WorkVersion             // InferSchema flattens embedded fields
+	Error         string    `bigquery:"error"`
+	ErrorCategory string    `bigquery:"error_category"`
+	NumVulns      int       `bigquery:"num_vulns"`
+	ScanSeconds   float64   `bigquery:"scan_seconds"`
+	ScanMemory    int64     `bigquery:"scan_memory"`
+}
+
+// ReadHistory reads the results for modulePath created since the given
+// time, most recent first, at most limit of them. Of the results for the
+// same version, scan mode and work version, only the most recent is read,
+// so retried scans appear once.
+func ReadHistory(ctx context.Context, c bigquery.DB, modulePath string, since time.Time, limit int) (_ []*HistoryEntry, err error) {
+	defer derrors.Wrap(&err, "govulncheck.ReadHistory(%q)", modulePath)
+	q, params := historyQuery(c.FullTableName(TableName), modulePath, since, limit)
+	iter, err := c.Query(ctx, q, params...)
+	if err != nil {
+		return nil, err
+	}
+	return bigquery.All[HistoryEntry](iter)
+}
+
+// historyQuery returns the query and parameters used by ReadHistory.
+func historyQuery(fullTableName, modulePath string, since time.Time, limit int) (string, []bigquery.Param) {
+	pq := bigquery.PartitionQuery{
+		From: "`" + fullTableName + "`",
+		Columns: "created_at, version, suffix, scan_mode, " +
+			"go_version, worker_version, schema_version, vulndb_last_modified, govulncheck_hash, vulndb_snapshot, " +
+			"error, error_category, ARRAY_LENGTH(vulns) AS num_vulns, scan_seconds, scan_memory",
+		PartitionOn: "version, suffix, scan_mode, " +
+			"go_version, worker_version, schema_version, vulndb_last_modified, govulncheck_hash, vulndb_snapshot",
+		Where:   "module_path=@module_path AND created_at >= @since",
+		OrderBy: "created_at DESC",
+	}
+	q := fmt.Sprintf("SELECT * FROM (%s) ORDER BY created_at DESC LIMIT @limit", pq.String())
+	return q, []bigquery.Param{
+		{Name: "module_path", Value: modulePath},
+		{Name: "since", Value: since},
+		{Name: "limit", Value: limit},
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+)
+
+func TestHistoryQuery(t *testing.T) {
+	since := time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC)
+	q, params := historyQuery("p.d.govulncheck", "example.com/m'", since, 10)
+	got := strings.Join(strings.Fields(q), " ")
+	want := "SELECT * FROM ( SELECT * EXCEPT (rownum) FROM ( SELECT created_at, version, suffix, scan_mode, " +
+		"go_version, worker_version, schema_version, vulndb_last_modified, govulncheck_hash, vulndb_snapshot, " +
+		"error, error_category, ARRAY_LENGTH(vulns) AS num_vulns, scan_seconds, scan_memory, ROW_NUMBER() OVER ( " +
+		"PARTITION BY version, suffix, scan_mode, go_version, worker_version, schema_version, vulndb_last_modified, govulncheck_hash, vulndb_snapshot " +
+		"ORDER BY created_at DESC ) AS rownum FROM `p.d.govulncheck` WHERE module_path=@module_path AND created_at >= @since ) " +
+		"WHERE rownum = 1 ) ORDER BY created_at DESC LIMIT @limit"
+	if got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+	wantParams := []bigquery.Param{
+		{Name: "module_path", Value: "example.com/m'"},
+		{Name: "since", Value: since},
+		{Name: "limit", Value: 10},
+	}
+	if diff := cmp.Diff(wantParams, params); diff != "" {
+		t.Errorf("params mismatch (-want, +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+)
+
+// Migration of the legacy vulncheck table.
+//
+// Before the worker ran the govulncheck binary, it ran the vulncheck
+// library and wrote its results to the vulncheck table. A row of that
+// table has the columns of a Result up to vulndb_last_modified, but its
+// vulns are vulncheck's: each has an id, symbol, package_path and
+// module_path, and the call_sink, import_sink and require_sink through
+// which vulncheck reached it, each null if it was not reached that way.
+//
+// The migration copies those rows to the govulncheck table, turning each
+// vuln into a finding at the most precise level it was reached at: a call
+// sink makes a symbol-level finding, an import sink a package-level one,
+// and a require sink a module-level one. Vulns that were not reached are
+// dropped, since govulncheck does not report them. The rows keep their
+// scan modes, like VTA or IMPORTS, which govulncheck does not use.
+//
+// Each copied row is recorded in the vulncheck-migrated table, so that
+// running the migration again copies only the rows it has not copied.
+
+const (
+	// LegacyTableName is the name of the table that the worker wrote
+	// before it ran govulncheck.
+	LegacyTableName = "vulncheck"
+
+	// MigratedTableName is the name of the table recording the rows of
+	// the legacy table that were copied to the govulncheck table.
+	MigratedTableName = "vulncheck-migrated"
+)
+
+// Migrated records a row of the legacy table that was copied to the
+// govulncheck table. The row is identified by its module path, version,
+// scan mode and creation time.
+type Migrated struct {
+	CreatedAt  time.Time `bigquery:"created_at"` // when the row was copied
+	ModulePath string    `bigquery:"module_path"`
+	Version    string    `bigquery:"version"`
+	ScanMode   string    `bigquery:"scan_mode"`
+	// LegacyCreatedAt is the creation time of the legacy row, which its
+	// copy keeps.
+	LegacyCreatedAt time.Time `bigquery:"legacy_created_at"`
+}
+
+func init() {
+	s, err := bigquery.InferSchema(Migrated{})
+	if err != nil {
+		panic(err)
+	}
+	bigquery.AddTable(MigratedTableName, s)
+}
+
+// legacyColumns maps the columns of the govulncheck table, other than
+// vulns, to the expressions that compute them from a legacy row l.
+// Nullable columns that are missing are left null.
+var legacyColumns = map[string]string{
+	"created_at":           "l.created_at",
+	"module_path":          "l.module_path",
+	"version":              "l.version",
+	"suffix":               "l.suffix",
+	"sort_version":         "l.sort_version",
+	"imported_by":          "l.imported_by",
+	"error":                "l.error",
+	"error_category":       "l.error_category",
+	"commit_time":          "l.commit_time",
+	"scan_seconds":         "l.scan_seconds",
+	"scan_memory":          "l.scan_memory",
+	"scan_mode":            "l.scan_mode",
+	"go_version":           "l.go_version",
+	"worker_version":       "l.worker_version",
+	"schema_version":       "l.schema_version",
+	"vulndb_last_modified": "l.vulndb_last_modified",
+}
+
+// legacyVulnFields maps the fields of a govulncheck vuln to the
+// expressions that compute them from a legacy vuln v.
+var legacyVulnFields = map[string]string{
+	"id":           "v.id",
+	"package_path": "IF(v.call_sink IS NULL AND v.import_sink IS NULL, '', v.package_path)",
+	"module_path":  "v.module_path",
+	// vulncheck did not record the version of the vulnerable module.
+	"version": "''",
+	"symbol":  "IF(v.call_sink IS NULL, NULL, v.symbol)",
+}
+
+// notMigratedCondition is the condition on a legacy row l that it is not
+// recorded in the migrated table, whose name is the format argument.
+const notMigratedCondition = `NOT EXISTS (
+		SELECT 1 FROM %s AS m
+		WHERE m.module_path = l.module_path AND m.version = l.version
+			AND m.scan_mode = l.scan_mode AND m.legacy_created_at = l.created_at
+	)`
+
+// LegacyPendingQuery returns the query counting the rows of the legacy
+// table that have not been migrated, as column n.
+func LegacyPendingQuery(fullTableName func(string) string) string {
+	return fmt.Sprintf("SELECT COUNT(*) AS n FROM `%s` AS l WHERE "+notMigratedCondition,
+		fullTableName(LegacyTableName), "`"+fullTableName(MigratedTableName)+"`")
+}
+
+// LegacyMigrationQuery returns the script that copies the rows of the
+// legacy table that have not been migrated to the govulncheck table, and
+// records them in the migrated table, in one transaction. The script
+// returns the number of rows it copied, as column n.
+func LegacyMigrationQuery(fullTableName func(string) string) (string, error) {
+	cols, exprs, err := legacyInsert(bigquery.TableSchema(TableName))
+	if err != nil {
+		return "", err
+	}
+	const qf = `
+		CREATE TEMP TABLE pending AS
+		SELECT * FROM %[1]s AS l WHERE %[4]s;
+
+		BEGIN TRANSACTION;
+		INSERT INTO %[2]s (%[5]s)
+		SELECT %[6]s FROM pending AS l;
+		INSERT INTO %[3]s (created_at, module_path, version, scan_mode, legacy_created_at)
+		SELECT CURRENT_TIMESTAMP(), module_path, version, scan_mode, created_at FROM pending;
+		COMMIT TRANSACTION;
+
+		SELECT COUNT(*) AS n FROM pending;
+	`
+	migrated := "`" + fullTableName(MigratedTableName) + "`"
+	return fmt.Sprintf(qf,
+		"`"+fullTableName(LegacyTableName)+"`",
+		"`"+fullTableName(TableName)+"`",
+		migrated,
+		fmt.Sprintf(notMigratedCondition, migrated),
+		strings.Join(cols, ", "),
+		strings.Join(exprs, ",\n\t\t\t"),
+	), nil
+}
+
+// legacyInsert returns the columns of the govulncheck table with the given
+// schema that a migration sets, and the expressions that compute them from
+// a legacy row l. It fails if a required column has no legacy value.
+func legacyInsert(schema bq.Schema) (cols, exprs []string, err error) {
+	for _, f := range schema {
+		if f.Name == "vulns" {
+			e, err := legacyVulns(f.Schema)
+			if err != nil {
+				return nil, nil, err
+			}
+			cols = append(cols, f.Name)
+			exprs = append(exprs, e)
+			continue
+		}
+		e, ok := legacyColumns[f.Name]
+		if !ok {
+			if f.Required {
+				return nil, nil, fmt.Errorf("required column %s has no legacy value", f.Name)
+			}
+			continue
+		}
+		cols = append(cols, f.Name)
+		exprs = append(exprs, e)
+	}
+	return cols, exprs, nil
+}
+
+// legacyVulns returns the expression that computes the vulns of a
+// govulncheck row, whose fields have the given schema, from those of
+// a legacy row l.
+func legacyVulns(schema bq.Schema) (string, error) {
+	var fields []string
+	for _, f := range schema {
+		e, ok := legacyVulnFields[f.Name]
+		if !ok {
+			if f.Required || f.Repeated {
+				return "", fmt.Errorf("vuln field %s has no legacy value", f.Name)
+			}
+			t, err := sqlType(f.Type)
+			if err != nil {
+				return "", fmt.Errorf("vuln field %s: %w", f.Name, err)
+			}
+			e = "CAST(NULL AS " + t + ")"
+		}
+		fields = append(fields, e+" AS "+f.Name)
+	}
+	return fmt.Sprintf("ARRAY(SELECT AS STRUCT %s FROM UNNEST(l.vulns) AS v "+
+		"WHERE COALESCE(v.call_sink, v.import_sink, v.require_sink) IS NOT NULL)",
+		strings.Join(fields, ", ")), nil
+}
+
+// sqlType returns the name of the type of a BigQuery scalar field in
+// GoogleSQL.
+func sqlType(t bq.FieldType) (string, error) {
+	switch t {
+	case bq.StringFieldType:
+		return "STRING", nil
+	case bq.IntegerFieldType:
+		return "INT64", nil
+	case bq.FloatFieldType:
+		return "FLOAT64", nil
+	case bq.BooleanFieldType:
+		return "BOOL", nil
+	case bq.TimestampFieldType:
+		return "TIMESTAMP", nil
+	default:
+		return "", fmt.Errorf("unsupported type %s", t)
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"strings"
+	"testing"
+
+	bq "cloud.google.com/go/bigquery"
+)
+
+func TestLegacyMigrationQuery(t *testing.T) {
+	fullTableName := func(t string) string { return "p.d." + t }
+	got, err := LegacyMigrationQuery(fullTableName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"SELECT * FROM `p.d.vulncheck` AS l WHERE NOT EXISTS",
+		"SELECT 1 FROM `p.d.vulncheck-migrated` AS m",
+		"INSERT INTO `p.d.govulncheck` (created_at, module_path, version, ",
+		"vulndb_last_modified, vulns)",
+		"ARRAY(SELECT AS STRUCT v.id AS id, ",
+		"'' AS version, CAST(NULL AS STRING) AS review_status, ",
+		"IF(v.call_sink IS NULL, NULL, v.symbol) AS symbol",
+		"INSERT INTO `p.d.vulncheck-migrated` (created_at, module_path, version, scan_mode, legacy_created_at)",
+		"SELECT COUNT(*) AS n FROM pending;",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("query does not contain %q:\n%s", want, got)
+		}
+	}
+	// Nullable columns without legacy values are left null.
+	if strings.Contains(got, "osv_id") {
+		t.Errorf("query sets osv_id:\n%s", got)
+	}
+
+	if got, want := LegacyPendingQuery(fullTableName), "SELECT COUNT(*) AS n FROM `p.d.vulncheck` AS l WHERE NOT EXISTS"; !strings.HasPrefix(got, want) {
+		t.Errorf("got pending query\n%s\nwant prefix %q", got, want)
+	}
+}
+
+func TestLegacyInsertMissing(t *testing.T) {
+	for _, schema := range []bq.Schema{
+		{{Name: "new_column", Type: bq.StringFieldType, Required: true}},
+		{{Name: "vulns", Type: bq.RecordFieldType, Repeated: true, Schema: bq.Schema{
+			{Name: "new_field", Type: bq.StringFieldType, Required: true},
+		}}},
+		{{Name: "vulns", Type: bq.RecordFieldType, Repeated: true, Schema: bq.Schema{
+			{Name: "new_field", Type: bq.RecordFieldType},
+		}}},
+	} {
+		if _, _, err := legacyInsert(schema); err == nil {
+			t.Errorf("%s: got no error", schema[0].Name)
+		}
+	}
+}
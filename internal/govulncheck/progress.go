@@ -0,0 +1,114 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// Phases of a scan, reported in Progress events.
+const (
+	PhaseDownload = "download" // downloading the module
+	PhaseBuild    = "build"    // downloading dependencies, or go mod init and tidy
+	PhaseScan     = "scan"     // running govulncheck
+)
+
+// A Progress is a progress event for a scan.
+//
+// The sandbox programs write Progress events periodically to standard error,
+// one per line, so that the worker can tell a slow scan from a stuck one.
+type Progress struct {
+	Phase   string
+	Elapsed float64 // seconds since the phase started
+	RSS     uint64  // resident set size of the scanning process in KB, or 0 if unknown
+}
+
+// progressPrefix begins each line holding a Progress event.
+const progressPrefix = "progress: "
+
+// ProgressInterval is how often Progress events are written during a scan.
+var ProgressInterval = 30 * time.Second
+
+// WriteProgress writes p to w as a single line.
+func WriteProgress(w io.Writer, p Progress) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s%s\n", progressPrefix, b)
+	return err
+}
+
+// ParseProgress parses a line written by WriteProgress.
+// It reports false if the line does not hold a Progress event.
+func ParseProgress(line []byte) (Progress, bool) {
+	var p Progress
+	_, rest, found := bytes.Cut(line, []byte(progressPrefix))
+	if !found || json.Unmarshal(bytes.TrimSpace(rest), &p) != nil {
+		return Progress{}, false
+	}
+	return p, true
+}
+
+// NewProgressWriter returns a writer that calls f for each Progress event
+// written to it. Other lines are ignored, so the standard error of a
+// sandbox, which also holds log messages, can be written to it.
+func NewProgressWriter(f func(Progress)) io.Writer {
+	return &progressWriter{f: f}
+}
+
+type progressWriter struct {
+	mu  sync.Mutex
+	buf []byte // partial line
+	f   func(Progress)
+}
+
+func (w *progressWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, b...)
+	for {
+		line, rest, found := bytes.Cut(w.buf, []byte("\n"))
+		if !found {
+			break
+		}
+		if p, ok := ParseProgress(line); ok {
+			w.f(p)
+		}
+		w.buf = rest
+	}
+	return len(b), nil
+}
+
+// watchProgress calls progress every ProgressInterval with the elapsed time
+// and memory use of cmd, which must have been started, until stop is closed.
+func watchProgress(cmd *exec.Cmd, start time.Time, progress func(Progress), stop <-chan struct{}) {
+	ticker := time.NewTicker(ProgressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			progress(Progress{
+				Phase:   PhaseScan,
+				Elapsed: time.Since(start).Seconds(),
+				RSS:     getCurrentMemoryUsage(cmd.Process.Pid),
+			})
+		}
+	}
+}
+
+// getCurrentMemoryUsage returns the resident set size in KB of the running
+// process with the given pid. It is overridden on Linux.
+var getCurrentMemoryUsage = func(pid int) uint64 {
+	return 0
+}
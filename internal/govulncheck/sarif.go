@@ -0,0 +1,121 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/pkgsite-metrics/internal/govulncheckapi"
+	"golang.org/x/pkgsite-metrics/internal/sarif"
+)
+
+// Output formats of served scan results.
+const (
+	FormatJSON  = "json"  // rows of the govulncheck table
+	FormatSARIF = "sarif" // a SARIF log; see package sarif
+)
+
+// NewSARIFRun returns a SARIF run of govulncheck with no results.
+func NewSARIFRun() *sarif.Run {
+	return sarif.NewRun(sarif.Driver{
+		Name:           "govulncheck",
+		InformationURI: "https://pkg.go.dev/golang.org/x/vuln/cmd/govulncheck",
+	})
+}
+
+// SARIFRun returns the findings of r as a SARIF run. There is a rule for
+// each vulnerability, and a result for each finding. File positions under
+// moduleDir are made relative to sarif.SrcRoot.
+func (r *AnalysisResponse) SARIFRun(moduleDir string) *sarif.Run {
+	run := NewSARIFRun()
+	if r.Config != nil {
+		run.Tool.Driver.Version = r.Config.ScannerVersion
+	}
+	run.Invocations = []*sarif.Invocation{{ExecutionSuccessful: true}}
+	for _, f := range r.Findings {
+		if len(f.Trace) == 0 {
+			continue
+		}
+		rule := &sarif.Rule{
+			ID:      f.OSV,
+			HelpURI: "https://pkg.go.dev/vuln/" + f.OSV,
+		}
+		if e := r.OSVs[f.OSV]; e != nil && e.Details != "" {
+			first, _, _ := strings.Cut(e.Details, "\n")
+			rule.ShortDescription = &sarif.Message{Text: first}
+			rule.FullDescription = &sarif.Message{Text: e.Details}
+		}
+		run.AddRule(rule)
+		run.Results = append(run.Results, sarifResult(f, moduleDir))
+	}
+	return run
+}
+
+// sarifResult converts a finding to a SARIF result. The vulnerable
+// module, package or symbol is its logical location, and the position in
+// the scanned module from which it is called, if known, is its physical
+// location.
+func sarifResult(f *govulncheckapi.Finding, moduleDir string) *sarif.Result {
+	vuln := f.Trace[0]
+	var level, kind, name string
+	switch f.Level() {
+	case govulncheckapi.ScanLevelSymbol:
+		level, kind, name = sarif.LevelError, "function", vuln.Package+"."+vuln.Symbol()
+	case govulncheckapi.ScanLevelPackage:
+		level, kind, name = sarif.LevelWarning, "package", vuln.Package
+	default:
+		level, kind, name = sarif.LevelNote, "module", vuln.Module
+	}
+	msg := fmt.Sprintf("%s@%s is vulnerable to %s", vuln.Module, vuln.Version, f.OSV)
+	if name != vuln.Module {
+		msg += ", through " + name
+	}
+	if f.FixedVersion != "" {
+		msg += fmt.Sprintf(". Fixed in %s@%s", vuln.Module, f.FixedVersion)
+	}
+	loc := &sarif.Location{
+		LogicalLocations: []*sarif.LogicalLocation{{FullyQualifiedName: name, Kind: kind}},
+	}
+	// The last frame of a trace is the entry point in the scanned module.
+	if entry := f.Trace[len(f.Trace)-1]; len(f.Trace) > 1 && entry.Position.String() != "" {
+		loc.PhysicalLocation = physicalLocation(entry.Position, moduleDir)
+	}
+	return &sarif.Result{
+		RuleID:    f.OSV,
+		Level:     level,
+		Message:   sarif.Message{Text: msg},
+		Locations: []*sarif.Location{loc},
+	}
+}
+
+// physicalLocation returns a SARIF location for pos, relative to
+// sarif.SrcRoot if it is under moduleDir.
+func physicalLocation(pos *govulncheckapi.Position, moduleDir string) *sarif.PhysicalLocation {
+	al := sarif.ArtifactLocation{URI: filepath.ToSlash(pos.Filename)}
+	if moduleDir != "" {
+		if rel, err := filepath.Rel(moduleDir, pos.Filename); err == nil && !strings.HasPrefix(rel, "..") {
+			al = sarif.ArtifactLocation{URI: filepath.ToSlash(rel), URIBaseID: sarif.SrcRoot}
+		}
+	}
+	return &sarif.PhysicalLocation{
+		ArtifactLocation: al,
+		Region:           &sarif.Region{StartLine: pos.Line, StartColumn: pos.Column},
+	}
+}
+
+// FailedSARIFRun returns a SARIF run of govulncheck that failed with err.
+func FailedSARIFRun(err error) *sarif.Run {
+	run := NewSARIFRun()
+	run.Invocations = []*sarif.Invocation{{
+		ExecutionSuccessful: false,
+		ToolExecutionNotifications: []*sarif.Notification{{
+			Level:   sarif.LevelError,
+			Message: sarif.Message{Text: err.Error()},
+		}},
+	}}
+	return run
+}
@@ -0,0 +1,111 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/pkgsite-metrics/internal/govulncheckapi"
+	"golang.org/x/pkgsite-metrics/internal/osv"
+	"golang.org/x/pkgsite-metrics/internal/sarif"
+)
+
+func TestSARIFRun(t *testing.T) {
+	resp := &AnalysisResponse{
+		Config: &govulncheckapi.Config{ScannerVersion: "v1.0.0"},
+		OSVs: map[string]*osv.Entry{
+			"GO-1": {ID: "GO-1", Details: "Summary.\nMore details."},
+		},
+		Findings: []*govulncheckapi.Finding{
+			{
+				OSV:          "GO-1",
+				FixedVersion: "v1.2.0",
+				Trace: []*govulncheckapi.Frame{
+					{Module: "example.com/v", Version: "v1.1.0", Package: "example.com/v/p", Function: "F"},
+					{Module: "example.com/m", Package: "example.com/m", Function: "main",
+						Position: &govulncheckapi.Position{Filename: "/mod/example.com/m@v1.0.0/main.go", Line: 7, Column: 3}},
+				},
+			},
+			{
+				OSV:   "GO-2",
+				Trace: []*govulncheckapi.Frame{{Module: "example.com/w", Version: "v0.1.0"}},
+			},
+			// Findings without traces are skipped.
+			{OSV: "GO-3"},
+		},
+	}
+	got := resp.SARIFRun("/mod/example.com/m@v1.0.0")
+	want := &sarif.Run{
+		Tool: sarif.Tool{Driver: sarif.Driver{
+			Name:           "govulncheck",
+			Version:        "v1.0.0",
+			InformationURI: "https://pkg.go.dev/golang.org/x/vuln/cmd/govulncheck",
+			Rules: []*sarif.Rule{
+				{
+					ID:               "GO-1",
+					ShortDescription: &sarif.Message{Text: "Summary."},
+					FullDescription:  &sarif.Message{Text: "Summary.\nMore details."},
+					HelpURI:          "https://pkg.go.dev/vuln/GO-1",
+				},
+				{ID: "GO-2", HelpURI: "https://pkg.go.dev/vuln/GO-2"},
+			},
+		}},
+		Invocations: []*sarif.Invocation{{ExecutionSuccessful: true}},
+		Results: []*sarif.Result{
+			{
+				RuleID:  "GO-1",
+				Level:   sarif.LevelError,
+				Message: sarif.Message{Text: "example.com/v@v1.1.0 is vulnerable to GO-1, through example.com/v/p.F. Fixed in example.com/v@v1.2.0"},
+				Locations: []*sarif.Location{{
+					PhysicalLocation: &sarif.PhysicalLocation{
+						ArtifactLocation: sarif.ArtifactLocation{URI: "main.go", URIBaseID: sarif.SrcRoot},
+						Region:           &sarif.Region{StartLine: 7, StartColumn: 3},
+					},
+					LogicalLocations: []*sarif.LogicalLocation{{FullyQualifiedName: "example.com/v/p.F", Kind: "function"}},
+				}},
+			},
+			{
+				RuleID:  "GO-2",
+				Level:   sarif.LevelNote,
+				Message: sarif.Message{Text: "example.com/w@v0.1.0 is vulnerable to GO-2"},
+				Locations: []*sarif.Location{{
+					LogicalLocations: []*sarif.LogicalLocation{{FullyQualifiedName: "example.com/w", Kind: "module"}},
+				}},
+			},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	failed := FailedSARIFRun(errors.New("boom"))
+	if inv := failed.Invocations[0]; inv.ExecutionSuccessful || inv.ToolExecutionNotifications[0].Message.Text != "boom" {
+		t.Errorf("FailedSARIFRun: got invocation %+v", inv)
+	}
+}
+
+func TestParseRequestFormat(t *testing.T) {
+	for _, test := range []struct {
+		query   string
+		wantErr bool
+	}{
+		{"importedby=0", false},
+		{"importedby=0&format=json", false},
+		{"importedby=0&format=sarif&serve=true", false},
+		{"importedby=0&format=sarif", true},
+		{"importedby=0&format=xml&serve=true", true},
+		{"importedby=0&subdir=sdk/go", false},
+		{"importedby=0&subdir=../go", true},
+	} {
+		r := httptest.NewRequest("GET", "/scan/example.com/m@v1.0.0?"+test.query, nil)
+		_, err := ParseRequest(r, "/scan")
+		if gotErr := err != nil; gotErr != test.wantErr {
+			t.Errorf("%q: got error %v, want error: %t", test.query, err, test.wantErr)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheck
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestViewQueries(t *testing.T) {
+	clean := func(s string) string {
+		return strings.Join(strings.Fields(s), " ")
+	}
+	fullTableName := func(id string) string { return "p.d." + id }
+
+	latest := clean(latestViewQuery(fullTableName))
+	want := "SELECT * EXCEPT (rownum) FROM ( SELECT *, ROW_NUMBER() OVER ( PARTITION BY module_path, version, scan_mode, platform ORDER BY created_at DESC ) AS rownum " +
+		"FROM `p.d.govulncheck` ) WHERE rownum = 1"
+	if latest != want {
+		t.Errorf("latestViewQuery:\ngot  %s\nwant %s", latest, want)
+	}
+	got := clean(errorCountsViewQuery(fullTableName))
+	want = "SELECT scan_mode, error_category, COUNT(*) AS num_results FROM ( " + latest + " ) " +
+		"WHERE error != '' GROUP BY scan_mode, error_category"
+	if got != want {
+		t.Errorf("errorCountsViewQuery:\ngot  %s\nwant %s", got, want)
+	}
+}
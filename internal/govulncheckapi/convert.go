@@ -0,0 +1,64 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheckapi
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/pkgsite-metrics/internal/osv"
+)
+
+// Level returns the scan level of the finding: symbol if its vulnerable
+// frame has a function, package if it has a package, and module otherwise.
+func (f *Finding) Level() ScanLevel {
+	if len(f.Trace) == 0 {
+		return ScanLevelModule
+	}
+	fr := f.Trace[0]
+	switch {
+	case fr.Function != "":
+		return ScanLevelSymbol
+	case fr.Package != "":
+		return ScanLevelPackage
+	default:
+		return ScanLevelModule
+	}
+}
+
+// Symbol returns the name of the frame's function, prefixed with its
+// receiver type if it is a method, as in the Symbols field of an OSV entry.
+// It returns the empty string if the frame has no function.
+func (fr *Frame) Symbol() string {
+	if fr.Receiver == "" {
+		return fr.Function
+	}
+	// OSV entries don't distinguish pointer receivers.
+	return strings.TrimPrefix(fr.Receiver, "*") + "." + fr.Function
+}
+
+// String returns the position in the form "file:line:column",
+// or the empty string if it is not valid.
+func (p *Position) String() string {
+	if p == nil || p.Line <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d:%d", p.Filename, p.Line, p.Column)
+}
+
+// AffectedRanges returns the version ranges of modulePath that are
+// affected by the vulnerability described by e.
+func AffectedRanges(e *osv.Entry, modulePath string) []osv.Range {
+	if e == nil {
+		return nil
+	}
+	var ranges []osv.Range
+	for _, a := range e.Affected {
+		if a.Module.Path == modulePath {
+			ranges = append(ranges, a.Ranges...)
+		}
+	}
+	return ranges
+}
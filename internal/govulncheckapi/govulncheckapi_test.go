@@ -0,0 +1,150 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package govulncheckapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/pkgsite-metrics/internal/osv"
+)
+
+const streamFile = "testdata/stream.json"
+
+// TestRoundTrip checks that every field of the govulncheck output in the
+// golden file is retained: decoding a message and encoding it again must
+// produce the original JSON. When govulncheck's JSON format changes,
+// regenerate the golden file with
+//
+//	govulncheck -json -db file:///path/to/vulndb ./... > testdata/stream.json
+//
+// on a module that uses golang.org/x/text/language.Parse, and update the
+// types in this package until this test passes.
+func TestRoundTrip(t *testing.T) {
+	data, err := os.ReadFile(streamFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Decode once into generic values, once into Messages.
+	generic := json.NewDecoder(bytes.NewReader(data))
+	typed := json.NewDecoder(bytes.NewReader(data))
+	typed.DisallowUnknownFields()
+	n := 0
+	for generic.More() {
+		var want any
+		if err := generic.Decode(&want); err != nil {
+			t.Fatal(err)
+		}
+		var msg Message
+		if err := typed.Decode(&msg); err != nil {
+			t.Fatalf("message %d: %v", n, err)
+		}
+		enc, err := json.Marshal(&msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got any
+		if err := json.Unmarshal(enc, &got); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("message %d lost or changed fields (-want, +got):\n%s", n, diff)
+		}
+		n++
+	}
+	if n == 0 {
+		t.Fatal("no messages")
+	}
+}
+
+type recordingHandler struct {
+	config   *Config
+	sbom     *SBOM
+	progress []*Progress
+	osvs     []*osv.Entry
+	findings []*Finding
+}
+
+func (h *recordingHandler) Config(c *Config) error { h.config = c; return nil }
+func (h *recordingHandler) Progress(p *Progress) error {
+	h.progress = append(h.progress, p)
+	return nil
+}
+func (h *recordingHandler) SBOM(s *SBOM) error       { h.sbom = s; return nil }
+func (h *recordingHandler) OSV(e *osv.Entry) error   { h.osvs = append(h.osvs, e); return nil }
+func (h *recordingHandler) Finding(f *Finding) error { h.findings = append(h.findings, f); return nil }
+
+func TestHandleJSON(t *testing.T) {
+	f, err := os.Open(streamFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var h recordingHandler
+	if err := HandleJSON(f, &h); err != nil {
+		t.Fatal(err)
+	}
+	if h.config == nil || h.config.ScanLevel != ScanLevelSymbol || h.config.ScanMode != ScanModeSource {
+		t.Errorf("got config %+v, want symbol-level source scan", h.config)
+	}
+	if h.sbom == nil || len(h.sbom.Modules) != 3 || len(h.sbom.Roots) != 1 {
+		t.Errorf("got SBOM %+v, want 3 modules and 1 root", h.sbom)
+	}
+	if len(h.progress) != 1 || len(h.osvs) != 1 || len(h.findings) != 3 {
+		t.Fatalf("got %d progress, %d OSV, %d finding messages; want 1, 1, 3",
+			len(h.progress), len(h.osvs), len(h.findings))
+	}
+
+	var levels []ScanLevel
+	for _, f := range h.findings {
+		levels = append(levels, f.Level())
+	}
+	if want := []ScanLevel{ScanLevelModule, ScanLevelPackage, ScanLevelSymbol}; !cmp.Equal(levels, want) {
+		t.Errorf("got levels %v, want %v", levels, want)
+	}
+	fr := h.findings[2].Trace[0]
+	if got, want := fr.Symbol(), "Parse"; got != want {
+		t.Errorf("got symbol %q, want %q", got, want)
+	}
+	if got, want := fr.Position.String(), "language/parse.go:228:6"; got != want {
+		t.Errorf("got position %q, want %q", got, want)
+	}
+	wantRanges := []osv.Range{{Type: "SEMVER", Events: []osv.RangeEvent{{Introduced: "0"}, {Fixed: "0.3.7"}}}}
+	if got := AffectedRanges(h.osvs[0], "golang.org/x/text"); !cmp.Equal(got, wantRanges) {
+		t.Errorf("got ranges %v, want %v", got, wantRanges)
+	}
+	if got := AffectedRanges(h.osvs[0], "example.com/main"); got != nil {
+		t.Errorf("got ranges %v for unaffected module, want none", got)
+	}
+}
+
+func TestFrameSymbol(t *testing.T) {
+	for _, test := range []struct {
+		frame Frame
+		want  string
+	}{
+		{Frame{}, ""},
+		{Frame{Function: "F"}, "F"},
+		{Frame{Function: "M", Receiver: "*T"}, "T.M"},
+		{Frame{Function: "M", Receiver: "T"}, "T.M"},
+	} {
+		if got := test.frame.Symbol(); got != test.want {
+			t.Errorf("%+v: got %q, want %q", test.frame, got, test.want)
+		}
+	}
+}
+
+func TestPositionString(t *testing.T) {
+	var nilPos *Position
+	if got := nilPos.String(); got != "" {
+		t.Errorf("nil: got %q", got)
+	}
+	if got := (&Position{Filename: "f.go"}).String(); got != "" {
+		t.Errorf("no line: got %q", got)
+	}
+}
@@ -22,6 +22,9 @@ type Handler interface {
 	// Progress is called to display a progress message.
 	Progress(progress *Progress) error
 
+	// SBOM is called with the modules and roots of the scan.
+	SBOM(sbom *SBOM) error
+
 	// OSV is invoked for each osv Entry in the stream.
 	OSV(entry *osv.Entry) error
 
@@ -47,6 +50,9 @@ func HandleJSON(from io.Reader, to Handler) error {
 		if msg.Progress != nil {
 			err = to.Progress(msg.Progress)
 		}
+		if msg.SBOM != nil {
+			err = to.SBOM(msg.SBOM)
+		}
 		if msg.OSV != nil {
 			err = to.OSV(msg.OSV)
 		}
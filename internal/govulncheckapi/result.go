@@ -17,6 +17,7 @@ import (
 type Message struct {
 	Config   *Config    `json:"config,omitempty"`
 	Progress *Progress  `json:"progress,omitempty"`
+	SBOM     *SBOM      `json:"SBOM,omitempty"`
 	OSV      *osv.Entry `json:"osv,omitempty"`
 	Finding  *Finding   `json:"finding,omitempty"`
 }
@@ -51,6 +52,35 @@ type Config struct {
 	// ScanLevel instructs govulncheck to analyze at a specific level of detail.
 	// Valid values include module, package and symbol.
 	ScanLevel ScanLevel `json:"scan_level,omitempty"`
+
+	// ScanMode instructs govulncheck how to interpret the input and
+	// what to do with it. Valid values are source, binary, query,
+	// and extract.
+	ScanMode ScanMode `json:"scan_mode,omitempty"`
+}
+
+// SBOM contains minimal information about the artifacts govulncheck is scanning.
+type SBOM struct {
+	// The go version used by govulncheck when scanning, which also defines
+	// the version of the standard library used for detecting vulns.
+	GoVersion string `json:"go_version,omitempty"`
+
+	// The set of modules included in the scan.
+	Modules []*Module `json:"modules,omitempty"`
+
+	// The roots of the scan, as package paths.
+	// For binaries, this will be the main package.
+	// For source code, this will be the packages matching the provided package patterns.
+	Roots []string `json:"roots,omitempty"`
+}
+
+// Module is a module in an SBOM.
+type Module struct {
+	// The full module path.
+	Path string `json:"path,omitempty"`
+
+	// The version if there is one.
+	Version string `json:"version,omitempty"`
 }
 
 // Progress messages are informational only, intended to allow users to monitor
@@ -152,6 +182,20 @@ type Position struct {
 // "package" level, that determination cannot be made.
 type ScanLevel string
 
+// ScanMode represents the mode in which a scan occurred. This can
+// be necessary to correctly interpret findings. For instance,
+// a binary can be checked for vulnerabilities or the user just wants
+// to extract minimal data necessary for the vulnerability check.
+type ScanMode string
+
+const (
+	ScanModeSource  ScanMode = "source"
+	ScanModeBinary  ScanMode = "binary"
+	ScanModeConvert ScanMode = "convert"
+	ScanModeQuery   ScanMode = "query"
+	ScanModeExtract ScanMode = "extract"
+)
+
 const (
 	ScanLevelModule  = "module"
 	ScanLevelPackage = "package"
@@ -6,15 +6,27 @@ package jobs
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
 	"time"
 
 	"cloud.google.com/go/firestore"
 	"golang.org/x/pkgsite-metrics/internal/derrors"
 	"golang.org/x/pkgsite-metrics/internal/fstore"
 	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
-const jobCollection = "Jobs"
+const (
+	jobCollection        = "Jobs"
+	claimCollection      = "Claims"
+	enqueueKeyCollection = "EnqueueKeys"
+	streakCollection     = "ModuleStreaks"
+)
 
 type DB struct {
 	ns *fstore.Namespace
@@ -76,20 +88,65 @@ func (d *DB) UpdateJob(ctx context.Context, id string, f func(*Job) error) (err
 // Increment value named name by n.
 func (d *DB) Increment(ctx context.Context, id, name string, n int) (err error) {
 	defer derrors.Wrap(&err, "job.DB.Increment(%s)", id)
-	docref := d.jobRef(id)
-	_, err = docref.Update(ctx, []firestore.Update{
-		{Path: name, Value: firestore.Increment(n)}, // name will incremented by n
-	})
+	var in Increments
+	in.Add(name, n)
+	return d.BatchIncrement(ctx, id, &in)
+}
+
+// IncrementErrorCategory increments the count of the job's tasks with
+// the given error category.
+func (d *DB) IncrementErrorCategory(ctx context.Context, id, category string) (err error) {
+	defer derrors.Wrap(&err, "job.DB.IncrementErrorCategory(%s, %s)", id, category)
+	var in Increments
+	in.AddErrorCategory(category, 1)
+	return d.BatchIncrement(ctx, id, &in)
+}
+
+// BatchIncrement adds in to the job's counters in a single write, so
+// either all of them change or none do.
+func (d *DB) BatchIncrement(ctx context.Context, id string, in *Increments) (err error) {
+	defer derrors.Wrap(&err, "job.DB.BatchIncrement(%s)", id)
+	if in.empty() {
+		return nil
+	}
+	_, err = d.jobRef(id).Update(ctx, in.updates())
 	return err
 }
 
-// ListJobs calls f on each job in the DB, most recently started first.
+// ListJobs calls f on each job in the DB that matches filter, most
+// recently started first. A nil filter matches all jobs.
 // f is also passed the time that the job was last updated.
 // If f returns a non-nil error, the iteration stops and returns that error.
-func (d *DB) ListJobs(ctx context.Context, f func(_ *Job, lastUpdate time.Time) error) (err error) {
+func (d *DB) ListJobs(ctx context.Context, filter *Filter, f func(_ *Job, lastUpdate time.Time) error) (err error) {
 	defer derrors.Wrap(&err, "job.DB.ListJobs()")
 
+	if filter == nil {
+		filter = &Filter{}
+	}
 	q := d.ns.Collection(jobCollection).OrderBy("StartedAt", firestore.Desc)
+	// The state of a job is computed from several fields, so it is
+	// matched after reading the job.
+	if filter.User != "" {
+		// This needs the composite index on User and StartedAt
+		// descending that terraform/environment/worker.tf creates.
+		q = q.Where("User", "==", filter.User)
+	}
+	if !filter.Since.IsZero() {
+		q = q.Where("StartedAt", ">=", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		q = q.Where("StartedAt", "<", filter.Until)
+	}
+	if filter.After != "" {
+		docsnap, err := d.jobRef(filter.After).Get(ctx)
+		if status.Code(err) == codes.NotFound {
+			return fmt.Errorf("%w: bad page token %q: no such job", derrors.InvalidArgument, filter.After)
+		}
+		if err != nil {
+			return err
+		}
+		q = q.StartAfter(docsnap)
+	}
 	iter := q.Documents(ctx)
 	defer iter.Stop()
 	for {
@@ -104,6 +161,9 @@ func (d *DB) ListJobs(ctx context.Context, f func(_ *Job, lastUpdate time.Time)
 		if err != nil {
 			return err
 		}
+		if !filter.Match(job) {
+			continue
+		}
 		if err := f(job, docsnap.UpdateTime); err != nil {
 			return err
 		}
@@ -111,6 +171,365 @@ func (d *DB) ListJobs(ctx context.Context, f func(_ *Job, lastUpdate time.Time)
 	return nil
 }
 
+// WatchJob calls f on the job with the given ID, and then again each time
+// the job changes, until f returns a non-nil error. If that error is
+// fstore.StopWatch, WatchJob returns nil; otherwise it returns the error.
+// It returns an error wrapping derrors.NotFound if the job does not exist.
+func (d *DB) WatchJob(ctx context.Context, id string, f func(*Job) error) (err error) {
+	defer derrors.Wrap(&err, "job.DB.WatchJob(%s)", id)
+	return fstore.Watch(ctx, d.jobRef(id), func(j *Job, _ time.Time) error {
+		return f(j)
+	})
+}
+
+// A claim records the job that enqueued a task for some work, and the
+// jobs that share the work instead of enqueuing tasks of their own.
+type claim struct {
+	JobID     string
+	ClaimedAt time.Time
+	// Request is the request of the task for the work, to enqueue again
+	// if the claim is handed to another job. See ReleaseClaims.
+	Request []byte
+	// Sharers are the IDs of the jobs that share the work, in the order
+	// they claimed it.
+	Sharers []string
+	// Finished is true once the task has finished, and its outcome has
+	// been recorded for the sharers.
+	Finished bool
+}
+
+// ClaimWork claims the work identified by key, which must be a valid
+// document ID, for the job with ID jobID, whose task for the work has the
+// given request. If another job holds the claim,
+// is neither done nor canceled, and its task for the work has not
+// finished, the job with ID jobID is added to the sharers of the work
+// instead. ClaimWork returns the ID of the job that holds the claim
+// afterwards: jobID if the claim succeeded, or the ID of the job to share
+// the work with.
+func (d *DB) ClaimWork(ctx context.Context, key, jobID string, request []byte) (owner string, err error) {
+	defer derrors.Wrap(&err, "job.DB.ClaimWork(%s, %s)", key, jobID)
+	err = d.ns.Client().RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		owner = jobID
+		ref := d.claimRef(key)
+		docsnap, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err == nil {
+			c, err := fstore.Decode[claim](docsnap)
+			if err != nil {
+				return err
+			}
+			if c.JobID != jobID && !c.Finished {
+				active, err := d.jobActive(tx, c.JobID)
+				if err != nil {
+					return err
+				}
+				if active {
+					owner = c.JobID
+					if slices.Contains(c.Sharers, jobID) {
+						return nil
+					}
+					c.Sharers = append(c.Sharers, jobID)
+					return tx.Set(ref, c)
+				}
+			}
+		}
+		return tx.Set(ref, &claim{JobID: jobID, ClaimedAt: time.Now(), Request: request})
+	})
+	if err != nil {
+		return "", err
+	}
+	return owner, nil
+}
+
+// FinishClaim marks the work identified by key, claimed by the job with ID
+// jobID, as finished, and returns the IDs of the jobs that share it. It
+// returns them only the first time it is called, so that the outcome of
+// a retried task is recorded for the sharers once.
+func (d *DB) FinishClaim(ctx context.Context, key, jobID string) (sharers []string, err error) {
+	defer derrors.Wrap(&err, "job.DB.FinishClaim(%s, %s)", key, jobID)
+	err = d.ns.Client().RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		sharers = nil
+		ref := d.claimRef(key)
+		docsnap, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		c, err := fstore.Decode[claim](docsnap)
+		if err != nil {
+			return err
+		}
+		if c.JobID != jobID || c.Finished {
+			return nil
+		}
+		sharers = c.Sharers
+		c.Finished = true
+		return tx.Set(ref, c)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sharers, nil
+}
+
+// A ClaimTransfer is a claim on unfinished work that was handed from a job
+// that will not do it to a job that shares it.
+type ClaimTransfer struct {
+	JobID   string // ID of the job that holds the claim now
+	Request []byte // request of the task for the work, as given to ClaimWork
+}
+
+// ReleaseClaims releases the claims of the job with ID jobID, once it is
+// finalized or canceled, so that its tasks will not finish the work it
+// claimed. The claims of work that is finished, or that no other job
+// shares, are deleted. The claims of unfinished work are handed to the
+// first active job that shares the work, and returned, so that the caller
+// can enqueue a task for that job; the other jobs keep sharing the work.
+func (d *DB) ReleaseClaims(ctx context.Context, jobID string) (_ []*ClaimTransfer, err error) {
+	defer derrors.Wrap(&err, "job.DB.ReleaseClaims(%s)", jobID)
+	iter := d.ns.Collection(claimCollection).Where("JobID", "==", jobID).Documents(ctx)
+	defer iter.Stop()
+	var transfers []*ClaimTransfer
+	for {
+		docsnap, err := iter.Next()
+		if err == iterator.Done {
+			return transfers, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var t *ClaimTransfer
+		err = d.ns.Client().RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+			t = nil
+			ds, err := tx.Get(docsnap.Ref)
+			if status.Code(err) == codes.NotFound {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			c, err := fstore.Decode[claim](ds)
+			if err != nil {
+				return err
+			}
+			if c.JobID != jobID {
+				return nil
+			}
+			if !c.Finished {
+				for i, id := range c.Sharers {
+					active, err := d.jobActive(tx, id)
+					if err != nil {
+						return err
+					}
+					if active {
+						t = &ClaimTransfer{JobID: id, Request: c.Request}
+						return tx.Set(docsnap.Ref, &claim{
+							JobID:     id,
+							ClaimedAt: time.Now(),
+							Request:   c.Request,
+							Sharers:   c.Sharers[i+1:],
+						})
+					}
+				}
+			}
+			return tx.Delete(docsnap.Ref)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if t != nil {
+			transfers = append(transfers, t)
+		}
+	}
+}
+
+// jobActive reports whether the job with ID id exists, and is neither
+// done nor canceled.
+func (d *DB) jobActive(tx *firestore.Transaction, id string) (bool, error) {
+	jsnap, err := tx.Get(d.jobRef(id))
+	if status.Code(err) == codes.NotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	j, err := fstore.Decode[Job](jsnap)
+	if err != nil {
+		return false, err
+	}
+	return !j.Done() && !j.Canceled, nil
+}
+
+// claimRef returns the DocumentRef for the claim of the work identified by
+// key.
+func (d *DB) claimRef(key string) *firestore.DocumentRef {
+	return d.ns.Collection(claimCollection).Doc(key)
+}
+
+// An EnqueueRecord records an enqueue made with an idempotency key, so
+// that repeating the enqueue with the same key does not start another job.
+type EnqueueRecord struct {
+	JobID       string
+	CreatedAt   time.Time
+	Done        bool // whether the tasks have been enqueued
+	NumEnqueued int  // number of tasks enqueued, once Done
+}
+
+// staleEnqueueReservation is how long an idempotency key stays reserved by
+// an enqueue that neither finished nor released it, for example because
+// the worker crashed. After that the key can be reserved again.
+const staleEnqueueReservation = time.Hour
+
+// ReserveEnqueueKey reserves user's idempotency key for an enqueue that
+// will start the job with ID jobID. If the key was already reserved, it
+// returns the record of the earlier enqueue and changes nothing, unless
+// that enqueue never finished and its reservation is stale.
+// Otherwise it returns nil, and the caller must later either call
+// FinishEnqueueKey or, if the enqueue failed, ReleaseEnqueueKey.
+func (d *DB) ReserveEnqueueKey(ctx context.Context, user, key, jobID string) (_ *EnqueueRecord, err error) {
+	defer derrors.Wrap(&err, "job.DB.ReserveEnqueueKey(%q, %q, %s)", user, key, jobID)
+	var prev *EnqueueRecord
+	err = d.ns.Client().RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		prev = nil
+		ref := d.enqueueKeyRef(user, key)
+		docsnap, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		now := time.Now()
+		if err == nil {
+			r, err := fstore.Decode[EnqueueRecord](docsnap)
+			if err != nil {
+				return err
+			}
+			if r.Done || now.Sub(r.CreatedAt) < staleEnqueueReservation {
+				prev = r
+				return nil
+			}
+		}
+		return tx.Set(ref, &EnqueueRecord{JobID: jobID, CreatedAt: now})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return prev, nil
+}
+
+// FinishEnqueueKey records that the enqueue with user's idempotency key
+// enqueued n tasks.
+func (d *DB) FinishEnqueueKey(ctx context.Context, user, key string, n int) (err error) {
+	defer derrors.Wrap(&err, "job.DB.FinishEnqueueKey(%q, %q, %d)", user, key, n)
+	_, err = d.enqueueKeyRef(user, key).Update(ctx, []firestore.Update{
+		{Path: "Done", Value: true},
+		{Path: "NumEnqueued", Value: n},
+	})
+	return err
+}
+
+// ReleaseEnqueueKey deletes the record of user's idempotency key, so that
+// the enqueue can be retried with it.
+func (d *DB) ReleaseEnqueueKey(ctx context.Context, user, key string) (err error) {
+	defer derrors.Wrap(&err, "job.DB.ReleaseEnqueueKey(%q, %q)", user, key)
+	_, err = d.enqueueKeyRef(user, key).Delete(ctx)
+	return err
+}
+
+// enqueueKeyRef returns the DocumentRef for user's idempotency key. Keys
+// are chosen by clients, so the document ID is a hash of the user and key
+// rather than the key itself, which may not be a valid ID. Including the
+// user keeps users who pick the same key from seeing each other's jobs.
+func (d *DB) enqueueKeyRef(user, key string) *firestore.DocumentRef {
+	h := sha256.Sum256([]byte(user + "\x00" + key))
+	return d.ns.Collection(enqueueKeyCollection).Doc(hex.EncodeToString(h[:]))
+}
+
+// GetModuleStreak returns the streak of pathological failures with the
+// key, or nil if there is none.
+func (d *DB) GetModuleStreak(ctx context.Context, key StreakKey) (_ *ModuleStreak, err error) {
+	defer derrors.Wrap(&err, "job.DB.GetModuleStreak(%+v)", key)
+	s, err := fstore.Get[ModuleStreak](ctx, d.streakRef(key))
+	if errors.Is(err, derrors.NotFound) {
+		return nil, nil
+	}
+	return s, err
+}
+
+// AddModuleFailure records a pathological failure of a scan for the streak
+// with the key, as ModuleStreak.AddFailure does, and returns the streak
+// afterwards.
+func (d *DB) AddModuleFailure(ctx context.Context, key StreakKey, code, jobID string, limit int, skipFor time.Duration) (_ *ModuleStreak, err error) {
+	defer derrors.Wrap(&err, "job.DB.AddModuleFailure(%+v, %s)", key, code)
+	var s *ModuleStreak
+	err = d.ns.Client().RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		ref := d.streakRef(key)
+		docsnap, err := tx.Get(ref)
+		switch {
+		case err == nil:
+			s, err = fstore.Decode[ModuleStreak](docsnap)
+			if err != nil {
+				return err
+			}
+		case status.Code(err) == codes.NotFound:
+			s = &ModuleStreak{Module: key.Module, Version: key.Version, BinaryVersion: key.BinaryVersion}
+		default:
+			return err
+		}
+		s.AddFailure(code, jobID, time.Now(), limit, skipFor)
+		return tx.Set(ref, s)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// ResetModuleStreak ends the streak with the key, after a scan that did
+// not fail pathologically.
+func (d *DB) ResetModuleStreak(ctx context.Context, key StreakKey) (err error) {
+	defer derrors.Wrap(&err, "job.DB.ResetModuleStreak(%+v)", key)
+	_, err = d.streakRef(key).Delete(ctx)
+	return err
+}
+
+// ListSkippedModules returns the streaks of the binary with the given hash
+// whose module versions are skipped at time now.
+func (d *DB) ListSkippedModules(ctx context.Context, binaryVersion string, now time.Time) (_ []*ModuleStreak, err error) {
+	defer derrors.Wrap(&err, "job.DB.ListSkippedModules(%q)", binaryVersion)
+	// Filtering on the binary here rather than in the query avoids the
+	// need for a composite index.
+	iter := d.ns.Collection(streakCollection).Where("SkipUntil", ">", now).Documents(ctx)
+	defer iter.Stop()
+	var ss []*ModuleStreak
+	for {
+		docsnap, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		s, err := fstore.Decode[ModuleStreak](docsnap)
+		if err != nil {
+			return nil, err
+		}
+		if s.BinaryVersion == binaryVersion {
+			ss = append(ss, s)
+		}
+	}
+	return ss, nil
+}
+
+// streakRef returns the DocumentRef for the streak with the key. Module
+// paths contain slashes, so the document ID is a hash of the key.
+func (d *DB) streakRef(key StreakKey) *firestore.DocumentRef {
+	h := sha256.Sum256([]byte(key.Module + "@" + key.Version + " " + key.BinaryVersion))
+	return d.ns.Collection(streakCollection).Doc(hex.EncodeToString(h[:]))
+}
+
 // jobRef returns the DocumentRef for a job with the given ID.
 func (d *DB) jobRef(id string) *firestore.DocumentRef {
 	return d.ns.Collection(jobCollection).Doc(id)
@@ -76,7 +76,7 @@ func TestDB(t *testing.T) {
 	must(db.CreateJob(ctx, job2))
 
 	var got2 []*Job
-	must(db.ListJobs(ctx, func(j *Job, _ time.Time) error {
+	must(db.ListJobs(ctx, nil, func(j *Job, _ time.Time) error {
 		got2 = append(got2, j)
 		return nil
 	}))
@@ -85,4 +85,123 @@ func TestDB(t *testing.T) {
 	if diff := cmp.Diff(want2, got2); diff != "" {
 		t.Errorf("mismatch (-want, +got)\n%s", diff)
 	}
+	for _, test := range []struct {
+		filter Filter
+		want   []*Job
+	}{
+		{Filter{User: "user2"}, []*Job{job2}},
+		{Filter{State: StateCanceled}, nil},
+		{Filter{Since: tm.Add(time.Hour)}, []*Job{job2}},
+		{Filter{Until: tm.Add(time.Hour)}, []*Job{job}},
+		{Filter{After: job2.ID()}, []*Job{job}},
+	} {
+		var got []*Job
+		must(db.ListJobs(ctx, &test.filter, func(j *Job, _ time.Time) error {
+			got = append(got, j)
+			return nil
+		}))
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("%+v: mismatch (-want, +got)\n%s", test.filter, diff)
+		}
+	}
+
+	// The second job to claim work shares it. Releasing the owner's
+	// claims hands the unfinished work to the sharer.
+	const workKey = "test-work"
+	if _, err := db.claimRef(workKey).Delete(ctx); err != nil {
+		t.Fatal(err)
+	}
+	request := []byte(`{"Module":"a.com/m"}`)
+	for _, j := range []*Job{job, job2} {
+		owner, err := db.ClaimWork(ctx, workKey, j.ID(), request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if owner != job.ID() {
+			t.Errorf("claim by %s: got owner %s, want %s", j.ID(), owner, job.ID())
+		}
+	}
+	transfers, err := db.ReleaseClaims(ctx, job.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]*ClaimTransfer{{JobID: job2.ID(), Request: request}}, transfers); diff != "" {
+		t.Errorf("ReleaseClaims mismatch (-want, +got):\n%s", diff)
+	}
+	sharers, err := db.FinishClaim(ctx, workKey, job2.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sharers) != 0 {
+		t.Errorf("FinishClaim: got sharers %v, want none", sharers)
+	}
+	transfers, err = db.ReleaseClaims(ctx, job2.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transfers) != 0 {
+		t.Errorf("ReleaseClaims of finished work: got %+v, want none", transfers)
+	}
+
+	// Reserve an idempotency key, then try again.
+	const key = "test key"
+	must(db.ReleaseEnqueueKey(ctx, "user", key))
+	prev, err := db.ReserveEnqueueKey(ctx, "user", key, job.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prev != nil {
+		t.Fatalf("first reservation: got %+v, want nil", prev)
+	}
+	must(db.FinishEnqueueKey(ctx, "user", key, 7))
+	prev, err = db.ReserveEnqueueKey(ctx, "user", key, job2.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prev == nil || prev.JobID != job.ID() || !prev.Done || prev.NumEnqueued != 7 {
+		t.Errorf("second reservation: got %+v, want job %s with 7 tasks", prev, job.ID())
+	}
+	// The same key of another user is separate.
+	must(db.ReleaseEnqueueKey(ctx, "user2", key))
+	prev, err = db.ReserveEnqueueKey(ctx, "user2", key, job2.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prev != nil {
+		t.Errorf("reservation by another user: got %+v, want nil", prev)
+	}
+	must(db.ReleaseEnqueueKey(ctx, "user", key))
+	must(db.ReleaseEnqueueKey(ctx, "user2", key))
+
+	// A module version is skipped after two pathological failures.
+	streakKey := StreakKey{Module: "example.com/streak", Version: "v1.0.0", BinaryVersion: "hash"}
+	must(db.ResetModuleStreak(ctx, streakKey))
+	for i := 1; i <= 2; i++ {
+		s, err := db.AddModuleFailure(ctx, streakKey, "PANIC", job.ID(), 2, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Count != i || s.Skipped(time.Now()) != (i == 2) {
+			t.Errorf("failure %d: got %+v", i, s)
+		}
+	}
+	skipped, err := db.ListSkippedModules(ctx, streakKey.BinaryVersion, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(skipped) != 1 || skipped[0].Key() != streakKey {
+		t.Errorf("got skipped %+v, want %+v", skipped, streakKey)
+	}
+	// Other binaries still scan it.
+	skipped, err = db.ListSkippedModules(ctx, "other", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(skipped) != 0 {
+		t.Errorf("other binary: got skipped %+v, want none", skipped)
+	}
+	must(db.ResetModuleStreak(ctx, streakKey))
+	if s, err := db.GetModuleStreak(ctx, streakKey); err != nil || s != nil {
+		t.Errorf("after reset: got %+v, %v; want nil, nil", s, err)
+	}
 }
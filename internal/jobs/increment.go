@@ -0,0 +1,158 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobs
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"golang.org/x/pkgsite-metrics/internal/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Increments are amounts to add to the counters of a job.
+type Increments struct {
+	// Counters maps names of Job fields, like "NumSucceeded", to amounts.
+	Counters map[string]int
+	// ErrorCategories maps error categories to amounts to add to
+	// the job's ErrorCategories.
+	ErrorCategories map[string]int
+}
+
+// Add adds n to the counter named name.
+func (in *Increments) Add(name string, n int) {
+	if in.Counters == nil {
+		in.Counters = map[string]int{}
+	}
+	in.Counters[name] += n
+}
+
+// AddErrorCategory adds n to the count of the error category.
+func (in *Increments) AddErrorCategory(category string, n int) {
+	if in.ErrorCategories == nil {
+		in.ErrorCategories = map[string]int{}
+	}
+	in.ErrorCategories[category] += n
+}
+
+// merge adds the amounts of in2 to in.
+func (in *Increments) merge(in2 *Increments) {
+	for name, n := range in2.Counters {
+		in.Add(name, n)
+	}
+	for c, n := range in2.ErrorCategories {
+		in.AddErrorCategory(c, n)
+	}
+}
+
+func (in *Increments) empty() bool {
+	return len(in.Counters) == 0 && len(in.ErrorCategories) == 0
+}
+
+// updates returns the Firestore updates for in, in a deterministic order.
+func (in *Increments) updates() []firestore.Update {
+	var us []firestore.Update
+	for name, n := range in.Counters {
+		us = append(us, firestore.Update{Path: name, Value: firestore.Increment(n)})
+	}
+	for c, n := range in.ErrorCategories {
+		// A FieldPath, unlike a Path, allows any characters in the category.
+		us = append(us, firestore.Update{FieldPath: firestore.FieldPath{"ErrorCategories", c}, Value: firestore.Increment(n)})
+	}
+	key := func(u firestore.Update) string {
+		if u.Path != "" {
+			return u.Path
+		}
+		return u.FieldPath[0] + "." + u.FieldPath[1]
+	}
+	sort.Slice(us, func(i, j int) bool { return key(us[i]) < key(us[j]) })
+	return us
+}
+
+// An Aggregator combines the increments to a job's counters made during a
+// short window into a single write, reducing contention on the job's
+// document when many of its tasks run at once.
+type Aggregator struct {
+	db     batchIncrementer
+	window time.Duration
+
+	mu      sync.Mutex
+	batches map[string]*batch // job ID to the batch being collected
+}
+
+type batchIncrementer interface {
+	BatchIncrement(ctx context.Context, id string, in *Increments) error
+}
+
+// A batch is the increments to a job collected during a window.
+type batch struct {
+	in   Increments
+	done chan struct{} // closed when the batch is written
+	err  error         // the result of writing the batch
+}
+
+// NewAggregator returns an Aggregator that writes increments to db,
+// collecting them for the duration of window.
+func NewAggregator(db batchIncrementer, window time.Duration) *Aggregator {
+	return &Aggregator{db: db, window: window, batches: map[string]*batch{}}
+}
+
+// Increment adds in to the job's counters. It waits until the increments
+// collected with in have been written, so that once it returns the
+// counters include in, and returns the error from writing them.
+// If ctx is done first, Increment returns, but the increments are
+// still written.
+func (a *Aggregator) Increment(ctx context.Context, id string, in *Increments) error {
+	a.mu.Lock()
+	b := a.batches[id]
+	if b == nil {
+		b = &batch{done: make(chan struct{})}
+		a.batches[id] = b
+		time.AfterFunc(a.window, func() { a.flush(id, b) })
+	}
+	b.in.merge(in)
+	a.mu.Unlock()
+
+	select {
+	case <-b.done:
+		return b.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Retries of contended writes.
+const (
+	maxIncrementRetries = 5
+	minIncrementBackoff = 50 * time.Millisecond
+)
+
+// flush writes the batch for the job. New increments for the job
+// go into a new batch.
+func (a *Aggregator) flush(id string, b *batch) {
+	a.mu.Lock()
+	delete(a.batches, id)
+	a.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	// There can be contention on the job's document, in which case
+	// we retry the write a few times.
+	for retries := 0; ; retries++ {
+		b.err = a.db.BatchIncrement(ctx, id, &b.in)
+		if status.Code(b.err) != codes.Aborted || retries >= maxIncrementRetries {
+			break
+		}
+		time.Sleep(minIncrementBackoff * (1 << retries))
+	}
+	if b.err != nil {
+		log.Errorf(ctx, b.err, "failed to update counters of job %q", id)
+	}
+	close(b.done)
+}
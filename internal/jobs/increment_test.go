@@ -0,0 +1,111 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobs
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestIncrementsUpdates(t *testing.T) {
+	var in Increments
+	in.Add("NumSucceeded", 1)
+	in.AddErrorCategory("LOAD", 2)
+	in.Add("NumStarted", 1)
+	in.Add("NumSucceeded", 1)
+	got := in.updates()
+	want := []firestore.Update{
+		{FieldPath: firestore.FieldPath{"ErrorCategories", "LOAD"}, Value: firestore.Increment(2)},
+		{Path: "NumStarted", Value: firestore.Increment(1)},
+		{Path: "NumSucceeded", Value: firestore.Increment(2)},
+	}
+	// cmp cannot compare the unexported transforms of increments.
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+// fakeIncrementer records batch increments.
+type fakeIncrementer struct {
+	mu       sync.Mutex
+	writes   map[string][]Increments // job ID to increments written
+	failures int                     // number of writes to abort
+	err      error                   // error to return from writes
+}
+
+func (f *fakeIncrementer) BatchIncrement(_ context.Context, id string, in *Increments) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failures > 0 {
+		f.failures--
+		return status.Error(codes.Aborted, "contention")
+	}
+	if f.err != nil {
+		return f.err
+	}
+	if f.writes == nil {
+		f.writes = map[string][]Increments{}
+	}
+	f.writes[id] = append(f.writes[id], *in)
+	return nil
+}
+
+func TestAggregator(t *testing.T) {
+	ctx := context.Background()
+	db := &fakeIncrementer{failures: 1}
+	a := NewAggregator(db, 50*time.Millisecond)
+
+	// Concurrent increments to a job are written together.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		for _, id := range []string{"j1", "j2"} {
+			wg.Add(1)
+			go func(id string, i int) {
+				defer wg.Done()
+				var in Increments
+				in.Add("NumSucceeded", 1)
+				if i%2 == 0 {
+					in.AddErrorCategory("LOAD", 1)
+				}
+				if err := a.Increment(ctx, id, &in); err != nil {
+					t.Error(err)
+				}
+			}(id, i)
+		}
+	}
+	wg.Wait()
+	want := map[string][]Increments{
+		"j1": {{Counters: map[string]int{"NumSucceeded": 10}, ErrorCategories: map[string]int{"LOAD": 5}}},
+		"j2": {{Counters: map[string]int{"NumSucceeded": 10}, ErrorCategories: map[string]int{"LOAD": 5}}},
+	}
+	if diff := cmp.Diff(want, db.writes); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	// Later increments go into a new batch.
+	var in Increments
+	in.Add("NumStarted", 1)
+	if err := a.Increment(ctx, "j1", &in); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(db.writes["j1"]); got != 2 {
+		t.Errorf("got %d writes, want 2", got)
+	}
+
+	// Errors are returned to all the callers in the batch.
+	db.err = errors.New("bad")
+	if err := a.Increment(ctx, "j1", &in); !errors.Is(err, db.err) {
+		t.Errorf("got %v, want %v", err, db.err)
+	}
+}
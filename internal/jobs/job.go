@@ -6,7 +6,10 @@
 package jobs
 
 import (
+	"fmt"
 	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/derrors"
 )
 
 // A Job is a set of related scan tasks enqueued at the same time.
@@ -15,9 +18,24 @@ type Job struct {
 	StartedAt     time.Time
 	URL           string // The URL that initiated the job.
 	Binary        string // Name of binary.
-	BinaryVersion string // Hex-encoded hash of binary.
+	BinaryVersion string // Hex-encoded SHA-256 hash of binary.
 	BinaryArgs    string // The args to the binary.
-	Canceled      bool   // The job was canceled.
+	Command       string // The command line run in each module's directory, with its environment.
+	ClientVersion string // Version of the client that started the job, if known.
+	Analyzers     string // Canonical list of analyzers enabled, or empty for the binary's default.
+	BuildTags     string // Canonical build tags, or empty for none.
+	GoFlags       string // Canonical flags for the go command, or empty for none.
+	GoVersion     string // Canonical Go toolchain, or empty for the worker's.
+	DepSnapshot   bool   // Binaries ran on read-only snapshots of the modules' dependencies.
+	Table         string // Job table of the results, if not the shared analysis table.
+	// TableMerged is true once the rows of the job table have been copied
+	// to the analysis table, and TableDropped once the job table has been
+	// deleted. After that, the job's results are its rows in the analysis
+	// table, if any.
+	TableMerged  bool
+	TableDropped bool
+	Notify       string // Webhook URL or mailto: address to send the summary to when finalized.
+	Canceled     bool   // The job was canceled.
 	// Counts of tasks.
 	NumEnqueued  int // Written by enqueue endpoint.
 	NumStarted   int // Incremented at the start of a scan.
@@ -25,6 +43,25 @@ type Job struct {
 	NumFailed    int // The HTTP request failed (status != 200)
 	NumErrored   int // The HTTP request succeeded, but the scan resulted in an error.
 	NumSucceeded int
+	NumDeleted   int // Deleted from the queue when the job was canceled.
+	// NumShared counts the modules that were not enqueued, because another
+	// unfinished job with the same work had already enqueued them.
+	// SharedWith holds the IDs of those jobs. The tasks of those jobs
+	// record their outcomes for this job too, and write copies of their
+	// result rows with its ID.
+	NumShared  int
+	SharedWith []string
+	// SoftSkipped lists the modules that were not enqueued because their
+	// scans kept failing pathologically; see ModuleStreak.
+	SoftSkipped []ModuleSkip
+	// Counts of failed and errored tasks by error category.
+	ErrorCategories map[string]int
+	// Finalized is true once the job's summary has been written to BigQuery.
+	Finalized bool
+	// StaleReason, if non-empty, says why the job was marked stale: its
+	// tasks stopped updating it before all of them finished, as when they
+	// are lost from the queue. A stale job is finalized as it is.
+	StaleReason string
 }
 
 // NewJob creates a new Job.
@@ -49,3 +86,132 @@ func (j *Job) ID() string {
 func (j *Job) NumFinished() int {
 	return j.NumSkipped + j.NumFailed + j.NumErrored + j.NumSucceeded
 }
+
+// NumExpected returns the number of tasks whose outcomes the job expects:
+// its own, and those of other jobs that it shares.
+func (j *Job) NumExpected() int {
+	return j.NumEnqueued + j.NumShared
+}
+
+// Finished reports whether all of the job's tasks have finished,
+// including the tasks of other jobs that it shares.
+func (j *Job) Finished() bool {
+	return j.NumExpected() > 0 && j.NumFinished() >= j.NumExpected()
+}
+
+// Done reports whether no more of the job's tasks are expected to finish:
+// either all of them have, or the job is stale.
+func (j *Job) Done() bool {
+	return j.Finished() || j.StaleReason != ""
+}
+
+// The states of a job, as returned by Job.State.
+const (
+	StateRunning  = "running"  // tasks are still expected to finish
+	StateFinished = "finished" // all tasks finished
+	StateCanceled = "canceled" // canceled, whether or not its tasks finished
+	StateStale    = "stale"    // given up on, with some tasks unfinished
+)
+
+// State returns the state of the job.
+func (j *Job) State() string {
+	switch {
+	case j.Canceled:
+		return StateCanceled
+	case j.StaleReason != "":
+		return StateStale
+	case j.Finished():
+		return StateFinished
+	default:
+		return StateRunning
+	}
+}
+
+// CheckState returns an error if state is not a job state.
+func CheckState(state string) error {
+	switch state {
+	case StateRunning, StateFinished, StateCanceled, StateStale:
+		return nil
+	}
+	return fmt.Errorf("%w: unknown job state %q", derrors.InvalidArgument, state)
+}
+
+// CheckStale returns why the job is stale at time now, given the time it
+// was last updated, or "" if it is not. A job that is not finished,
+// canceled, or already stale becomes stale when it has not been updated
+// for the duration after.
+func (j *Job) CheckStale(lastUpdate, now time.Time, after time.Duration) string {
+	if j.Finished() || j.Canceled || j.StaleReason != "" {
+		return ""
+	}
+	idle := now.Sub(lastUpdate)
+	if idle < after {
+		return ""
+	}
+	return fmt.Sprintf("no updates for %s, with %d of %d tasks finished",
+		idle.Round(time.Minute), j.NumFinished(), j.NumExpected())
+}
+
+// RowCounts are the numbers of module versions for which a job's
+// tasks stored result rows.
+type RowCounts struct {
+	Rows      int `bigquery:"num_rows"`      // rows, including duplicates from retried tasks
+	Succeeded int `bigquery:"num_succeeded"` // module versions with a row without an error
+	Errored   int `bigquery:"num_errored"`   // module versions with a row with an error
+}
+
+// Discrepancies compares the job's counts of finished tasks with the
+// result rows its tasks stored, and describes each difference.
+// Fewer rows than tasks may mean that results were lost.
+func (j *Job) Discrepancies(rc *RowCounts) []string {
+	var ds []string
+	check := func(name string, tasks, rows int) {
+		switch {
+		case rows < tasks:
+			ds = append(ds, fmt.Sprintf("%s is %d, but only %d rows were stored", name, tasks, rows))
+		case rows > tasks:
+			ds = append(ds, fmt.Sprintf("%s is %d, but %d rows were stored", name, tasks, rows))
+		}
+	}
+	check("NumSucceeded", j.NumSucceeded, rc.Succeeded)
+	check("NumErrored", j.NumErrored, rc.Errored)
+	return ds
+}
+
+// A Filter selects jobs to list. Its zero value selects all of them.
+type Filter struct {
+	User  string // only jobs started by User, if non-empty
+	State string // only jobs in State, if non-empty
+	// Only jobs started at or after Since and before Until, if they are
+	// not zero.
+	Since, Until time.Time
+	// After is the ID of a job. If it is non-empty, only the jobs listed
+	// after it are listed: it is the token of the next page of a listing.
+	After string
+}
+
+// Match reports whether the job matches the filter's user, state and
+// time range. It does not consider After.
+func (f *Filter) Match(j *Job) bool {
+	return (f.User == "" || j.User == f.User) &&
+		(f.State == "" || j.State() == f.State) &&
+		(f.Since.IsZero() || !j.StartedAt.Before(f.Since)) &&
+		(f.Until.IsZero() || j.StartedAt.Before(f.Until))
+}
+
+// A Page is one page of a listing of jobs.
+type Page struct {
+	Jobs []*Job
+	// NextPageToken, if non-empty, is the value of Filter.After that
+	// lists the next page.
+	NextPageToken string `json:",omitempty"`
+}
+
+// A Description is a job together with the counts of its stored result rows.
+type Description struct {
+	*Job
+	// Rows is nil if the rows could not be counted.
+	Rows *RowCounts `json:",omitempty"`
+	// Discrepancies between the job's counts and Rows.
+	Discrepancies []string `json:",omitempty"`
+}
@@ -0,0 +1,150 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobs
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDiscrepancies(t *testing.T) {
+	j := &Job{NumSucceeded: 1000, NumErrored: 5}
+	for _, test := range []struct {
+		rc   RowCounts
+		want []string
+	}{
+		{RowCounts{Rows: 1005, Succeeded: 1000, Errored: 5}, nil},
+		{RowCounts{Rows: 995, Succeeded: 990, Errored: 5}, []string{"NumSucceeded is 1000, but only 990 rows were stored"}},
+		{RowCounts{Rows: 1007, Succeeded: 1000, Errored: 7}, []string{"NumErrored is 5, but 7 rows were stored"}},
+	} {
+		got := j.Discrepancies(&test.rc)
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("%+v: mismatch (-want, +got):\n%s", test.rc, diff)
+		}
+	}
+}
+
+func TestDescriptionJSON(t *testing.T) {
+	// A Description decodes as a Job, for clients that don't need the rows.
+	j := NewJob("user", time.Date(2023, 3, 11, 1, 2, 3, 0, time.UTC), "url", "bin", "hash", "args")
+	j.NumSucceeded = 3
+	data, err := json.Marshal(&Description{Job: j, Rows: &RowCounts{Rows: 2, Succeeded: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Job
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(j, &got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestCheckStale(t *testing.T) {
+	now := time.Date(2023, 3, 12, 0, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		name string
+		job  Job
+		idle time.Duration
+		want string
+	}{
+		{"in progress", Job{NumEnqueued: 10, NumSucceeded: 4}, time.Hour, ""},
+		{"idle", Job{NumEnqueued: 10, NumSucceeded: 4}, 30 * time.Hour, "no updates for 30h0m0s, with 4 of 10 tasks finished"},
+		{"finished", Job{NumEnqueued: 10, NumSucceeded: 10}, 30 * time.Hour, ""},
+		{"canceled", Job{NumEnqueued: 10, Canceled: true}, 30 * time.Hour, ""},
+		{"already stale", Job{NumEnqueued: 10, StaleReason: "lost"}, 30 * time.Hour, ""},
+	} {
+		got := test.job.CheckStale(now.Add(-test.idle), now, 24*time.Hour)
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+	if j := (Job{NumEnqueued: 10, StaleReason: "lost"}); !j.Done() {
+		t.Error("stale job: got Done false, want true")
+	}
+}
+
+func TestFinished(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		job  Job
+		want bool
+	}{
+		{"not enqueued", Job{}, false},
+		{"in progress", Job{NumEnqueued: 2, NumSucceeded: 1}, false},
+		{"done", Job{NumEnqueued: 2, NumSucceeded: 1, NumErrored: 1}, true},
+		{"all shared, unfinished", Job{NumShared: 3, NumSucceeded: 2}, false},
+		{"all shared, finished", Job{NumShared: 3, NumSucceeded: 2, NumSkipped: 1}, true},
+		{"own tasks finished", Job{NumEnqueued: 2, NumShared: 3, NumSucceeded: 2}, false},
+		{"some shared, finished", Job{NumEnqueued: 2, NumShared: 3, NumSucceeded: 4, NumFailed: 1}, true},
+	} {
+		if got := test.job.Finished(); got != test.want {
+			t.Errorf("%s: got %t, want %t", test.name, got, test.want)
+		}
+	}
+}
+
+func TestState(t *testing.T) {
+	for _, test := range []struct {
+		job  Job
+		want string
+	}{
+		{Job{NumEnqueued: 2, NumSucceeded: 1}, StateRunning},
+		{Job{NumEnqueued: 2, NumSucceeded: 2}, StateFinished},
+		{Job{NumEnqueued: 2, NumSucceeded: 2, Canceled: true}, StateCanceled},
+		{Job{NumEnqueued: 2, StaleReason: "no updates"}, StateStale},
+	} {
+		if got := test.job.State(); got != test.want {
+			t.Errorf("%+v: got %q, want %q", test.job, got, test.want)
+		}
+		if err := CheckState(test.want); err != nil {
+			t.Error(err)
+		}
+	}
+	if err := CheckState("lost"); err == nil {
+		t.Error("CheckState(lost): got nil, want error")
+	}
+}
+
+func TestModuleStreak(t *testing.T) {
+	now := time.Date(2023, 3, 12, 0, 0, 0, 0, time.UTC)
+	var s *ModuleStreak
+	if s.Skipped(now) {
+		t.Error("nil streak: got Skipped true, want false")
+	}
+	s = &ModuleStreak{Module: "a.com/m"}
+	s.AddFailure("PANIC", "j1", now, 3, 24*time.Hour)
+	s.AddFailure("TIMEOUT", "j2", now, 3, 24*time.Hour)
+	if s.Skipped(now) {
+		t.Fatal("skipped after 2 failures, want 3")
+	}
+	s.AddFailure("MEM_LIMIT_EXCEEDED", "j3", now, 3, 24*time.Hour)
+	if !s.Skipped(now.Add(time.Hour)) || s.Skipped(now.Add(25*time.Hour)) {
+		t.Errorf("got skip until %s, want a day after %s", s.SkipUntil, now)
+	}
+	if want := "3 consecutive pathological failures, the last MEM_LIMIT_EXCEEDED"; s.SkipReason != want {
+		t.Errorf("got reason %q, want %q", s.SkipReason, want)
+	}
+
+	// After the skip expires, the next failure skips the module again.
+	later := now.Add(48 * time.Hour)
+	s.AddFailure("PANIC", "j4", later, 3, 24*time.Hour)
+	if !s.Skipped(later) || s.Count != 4 {
+		t.Errorf("got %+v, want skipped with 4 failures", s)
+	}
+
+	// A limit of zero never skips.
+	s = &ModuleStreak{}
+	for i := 0; i < 10; i++ {
+		s.AddFailure("PANIC", "", now, 0, time.Hour)
+	}
+	if s.Skipped(now) {
+		t.Error("limit 0: got skipped")
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobs
+
+import (
+	"fmt"
+	"time"
+)
+
+// A ModuleStreak records the consecutive pathological failures of the
+// scans of a module version by one analysis binary, whatever jobs they
+// belonged to. Pathological failures, like timeouts and running out of
+// memory, are likely to recur when the same binary scans the same code,
+// and waste resources each time. After enough of them, the module
+// version is skipped for a while by jobs that run that binary.
+type ModuleStreak struct {
+	Module        string
+	Version       string
+	BinaryVersion string // Hash of the analysis binary.
+	Count         int    // Consecutive pathological failures.
+	LastError     string // Error code of the last failure, like "MEM_LIMIT_EXCEEDED".
+	LastJobID     string // Job of the last failure, if any.
+	UpdatedAt     time.Time
+	// SkipUntil is when the module stops being skipped, or zero if it
+	// was never skipped. SkipReason says why it was skipped.
+	SkipUntil  time.Time
+	SkipReason string
+}
+
+// A StreakKey identifies a streak: the module version, and the hash of
+// the binary that scans it.
+type StreakKey struct {
+	Module, Version, BinaryVersion string
+}
+
+// Key returns the key of the streak.
+func (s *ModuleStreak) Key() StreakKey {
+	return StreakKey{Module: s.Module, Version: s.Version, BinaryVersion: s.BinaryVersion}
+}
+
+// Skipped reports whether the module is skipped at time now.
+func (s *ModuleStreak) Skipped(now time.Time) bool {
+	return s != nil && now.Before(s.SkipUntil)
+}
+
+// AddFailure records a pathological failure with the error code, of a
+// task of the job with ID jobID, at time now. If the streak reaches limit
+// failures, and the module is not already skipped, it is skipped for the
+// duration skipFor. A limit of zero never skips.
+//
+// The streak continues after the skip expires, so a module that fails
+// again is skipped again right away.
+func (s *ModuleStreak) AddFailure(code, jobID string, now time.Time, limit int, skipFor time.Duration) {
+	s.Count++
+	s.LastError = code
+	s.LastJobID = jobID
+	s.UpdatedAt = now
+	if limit > 0 && s.Count >= limit && !s.Skipped(now) {
+		s.SkipUntil = now.Add(skipFor)
+		s.SkipReason = fmt.Sprintf("%d consecutive pathological failures, the last %s", s.Count, code)
+	}
+}
+
+// A ModuleSkip is a module version that a job did not scan, and why.
+type ModuleSkip struct {
+	Module  string
+	Version string
+	Reason  string
+}
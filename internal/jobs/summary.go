@@ -0,0 +1,109 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobs
+
+import (
+	"sort"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+)
+
+// TableName is the BigQuery table of job summaries.
+const TableName = "jobs"
+
+// Note: before modifying Summary, make sure the change
+// is a valid schema modification.
+// The only supported changes are:
+//   - adding a nullable or repeated column
+//   - dropping a column
+//   - changing a column from required to nullable.
+// See https://cloud.google.com/bigquery/docs/managing-table-schemas for details.
+// TestSchemaChanges in internal/bigquery enforces this.
+
+// Summary is a row in the BigQuery jobs table. It is written once,
+// when the job is finalized, and keeps a permanent record of the job.
+type Summary struct {
+	CreatedAt     time.Time     `bigquery:"created_at"`
+	JobID         string        `bigquery:"job_id"`
+	User          string        `bigquery:"user"`
+	URL           string        `bigquery:"url"`
+	Binary        string        `bigquery:"binary"`
+	BinaryVersion string        `bigquery:"binary_version"`
+	BinaryArgs    string        `bigquery:"binary_args"`
+	Analyzers     bq.NullString `bigquery:"analyzers"`
+	BuildTags     bq.NullString `bigquery:"build_tags"`
+	GoFlags       bq.NullString `bigquery:"goflags"`
+	GoVersion     bq.NullString `bigquery:"go_version"`
+	Command       bq.NullString `bigquery:"command"`
+	ClientVersion bq.NullString `bigquery:"client_version"`
+	StartedAt     time.Time     `bigquery:"started_at"`
+	FinishedAt    time.Time     `bigquery:"finished_at"`
+	// DurationSeconds is the time from the start of the job
+	// until its last task finished.
+	DurationSeconds float64 `bigquery:"duration_seconds"`
+	NumEnqueued     int     `bigquery:"num_enqueued"`
+	NumStarted      int     `bigquery:"num_started"`
+	NumSkipped      int     `bigquery:"num_skipped"`
+	NumFailed       int     `bigquery:"num_failed"`
+	NumErrored      int     `bigquery:"num_errored"`
+	NumSucceeded    int     `bigquery:"num_succeeded"`
+	// Errors counts the failed and errored tasks by error category.
+	Errors []*ErrorCount `bigquery:"errors"`
+	// StaleReason is why the job was finalized before all of its tasks
+	// finished, or null if they all finished.
+	StaleReason bq.NullString `bigquery:"stale_reason"`
+}
+
+// ErrorCount is the number of tasks of a job with an error category.
+type ErrorCount struct {
+	Category string `bigquery:"category"`
+	Count    int    `bigquery:"count"`
+}
+
+func (s *Summary) SetUploadTime(t time.Time) { s.CreatedAt = t }
+
+// NewSummary returns a summary of j, which finished at the given time.
+// A stale job finishes when it is finalized.
+func NewSummary(j *Job, finished time.Time) *Summary {
+	s := &Summary{
+		JobID:           j.ID(),
+		User:            j.User,
+		URL:             j.URL,
+		Binary:          j.Binary,
+		BinaryVersion:   j.BinaryVersion,
+		BinaryArgs:      j.BinaryArgs,
+		Analyzers:       bq.NullString{StringVal: j.Analyzers, Valid: j.Analyzers != ""},
+		BuildTags:       bq.NullString{StringVal: j.BuildTags, Valid: j.BuildTags != ""},
+		GoFlags:         bq.NullString{StringVal: j.GoFlags, Valid: j.GoFlags != ""},
+		GoVersion:       bq.NullString{StringVal: j.GoVersion, Valid: j.GoVersion != ""},
+		Command:         bq.NullString{StringVal: j.Command, Valid: j.Command != ""},
+		ClientVersion:   bq.NullString{StringVal: j.ClientVersion, Valid: j.ClientVersion != ""},
+		StartedAt:       j.StartedAt,
+		FinishedAt:      finished,
+		DurationSeconds: finished.Sub(j.StartedAt).Seconds(),
+		NumEnqueued:     j.NumEnqueued,
+		NumStarted:      j.NumStarted,
+		NumSkipped:      j.NumSkipped,
+		NumFailed:       j.NumFailed,
+		NumErrored:      j.NumErrored,
+		NumSucceeded:    j.NumSucceeded,
+		StaleReason:     bq.NullString{StringVal: j.StaleReason, Valid: j.StaleReason != ""},
+	}
+	for c, n := range j.ErrorCategories {
+		s.Errors = append(s.Errors, &ErrorCount{Category: c, Count: n})
+	}
+	sort.Slice(s.Errors, func(i, k int) bool { return s.Errors[i].Category < s.Errors[k].Category })
+	return s
+}
+
+func init() {
+	s, err := bigquery.InferSchema(Summary{})
+	if err != nil {
+		panic(err)
+	}
+	bigquery.AddTable(TableName, s)
+}
@@ -30,8 +30,30 @@ func gcpReplaceAttr(groups []string, a slog.Attr) slog.Attr {
 		a.Key = "message"
 	case "level":
 		a.Key = "severity"
+		if l, ok := a.Value.Any().(slog.Level); ok {
+			a.Value = slog.StringValue(gcpSeverity(l))
+		}
 	case "traceID":
 		a.Key = "logging.googleapis.com/trace"
 	}
 	return a
 }
+
+// gcpSeverity returns the Cloud Logging severity for a level. Levels
+// between the named ones map to the severity of the named level below,
+// and levels above error to CRITICAL.
+// See https://cloud.google.com/logging/docs/reference/v2/rest/v2/LogEntry#logseverity.
+func gcpSeverity(l slog.Level) string {
+	switch {
+	case l < slog.LevelInfo:
+		return "DEBUG"
+	case l < slog.LevelWarn:
+		return "INFO"
+	case l < slog.LevelError:
+		return "WARNING"
+	case l == slog.LevelError:
+		return "ERROR"
+	default:
+		return "CRITICAL"
+	}
+}
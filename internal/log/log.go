@@ -28,17 +28,29 @@ func FromContext(ctx context.Context) *slog.Logger {
 	return slog.Default()
 }
 
-func Debug(ctx context.Context, msg string, args ...any) { FromContext(ctx).Debug(msg, args...) }
-func Info(ctx context.Context, msg string, args ...any)  { FromContext(ctx).Info(msg, args...) }
-func Warn(ctx context.Context, msg string, args ...any)  { FromContext(ctx).Warn(msg, args...) }
+func Debug(ctx context.Context, msg string, args ...any) {
+	bundleFromContext(ctx).record(slog.LevelDebug, msg)
+	FromContext(ctx).Debug(msg, args...)
+}
+func Info(ctx context.Context, msg string, args ...any) {
+	bundleFromContext(ctx).record(slog.LevelInfo, msg)
+	FromContext(ctx).Info(msg, args...)
+}
+func Warn(ctx context.Context, msg string, args ...any) {
+	bundleFromContext(ctx).record(slog.LevelWarn, msg)
+	FromContext(ctx).Warn(msg, args...)
+}
 func Error(ctx context.Context, msg string, err error, args ...any) {
+	bundleFromContext(ctx).record(slog.LevelError, fmt.Sprintf("%s: %v", msg, err))
 	FromContext(ctx).Error(msg, err, args...)
 }
 
 func Logf(ctx context.Context, level slog.Level, format string, args ...any) {
 	l := FromContext(ctx)
 	if l.Enabled(ctx, level) {
-		l.Log(ctx, level, fmt.Sprintf(format, args...))
+		msg := fmt.Sprintf(format, args...)
+		bundleFromContext(ctx).record(level, msg)
+		l.Log(ctx, level, msg)
 	}
 }
 
@@ -56,6 +68,8 @@ func Errorf(ctx context.Context, err error, format string, args ...any) {
 	level := slog.LevelError
 	l := FromContext(ctx)
 	if l.Enabled(ctx, level) {
-		l.Log(ctx, level, fmt.Sprintf(format, args...), slog.ErrorKey, err)
+		msg := fmt.Sprintf(format, args...)
+		bundleFromContext(ctx).record(level, fmt.Sprintf("%s: %v", msg, err))
+		l.Log(ctx, level, msg, slog.ErrorKey, err)
 	}
 }
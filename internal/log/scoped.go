@@ -0,0 +1,150 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// With returns a context whose logger adds the given attributes
+// (alternating keys and values, as in slog.Logger.With) to every
+// log record.
+//
+// Use it to tag all the logs of a request with the job ID and the
+// module version being processed, so they can be correlated.
+func With(ctx context.Context, args ...any) context.Context {
+	return NewContext(ctx, FromContext(ctx).With(args...))
+}
+
+// A Bundle collects statistics about the log records written with
+// a context, so that a single summary record can be written at the end
+// of a unit of work like a scan.
+type Bundle struct {
+	start time.Time
+
+	mu       sync.Mutex
+	counts   map[slog.Level]int
+	firstErr string
+}
+
+type bundleKey struct{}
+
+// StartBundle returns a context that records log statistics in
+// a new Bundle, and the Bundle.
+func StartBundle(ctx context.Context) (context.Context, *Bundle) {
+	b := &Bundle{start: time.Now(), counts: map[slog.Level]int{}}
+	return context.WithValue(ctx, bundleKey{}, b), b
+}
+
+func bundleFromContext(ctx context.Context) *Bundle {
+	b, _ := ctx.Value(bundleKey{}).(*Bundle)
+	return b
+}
+
+func (b *Bundle) record(level slog.Level, msg string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.counts[level]++
+	if level >= slog.LevelError && b.firstErr == "" {
+		b.firstErr = msg
+	}
+}
+
+// Emit writes a summary record for the bundle at info level, using the
+// logger in ctx. The record includes the duration since the bundle started,
+// the number of records at each level, and the first error message, if any.
+func (b *Bundle) Emit(ctx context.Context, msg string, args ...any) {
+	b.mu.Lock()
+	args = append(args,
+		"duration", time.Since(b.start),
+		"debugs", b.counts[slog.LevelDebug],
+		"infos", b.counts[slog.LevelInfo],
+		"warnings", b.counts[slog.LevelWarn],
+		"errors", b.counts[slog.LevelError])
+	if b.firstErr != "" {
+		args = append(args, "firstError", b.firstErr)
+	}
+	b.mu.Unlock()
+	FromContext(ctx).Info(msg, args...)
+}
+
+// SamplingHandler is a slog.Handler that limits the number of debug
+// records passed to another handler. Records at higher levels are
+// always passed on.
+type SamplingHandler struct {
+	h     slog.Handler
+	state *samplingState
+}
+
+type samplingState struct {
+	max int // maximum debug records per second
+
+	mu      sync.Mutex
+	window  time.Time // start of current one-second window
+	n       int       // debug records seen in the current window
+	dropped int       // debug records dropped in the current window
+	now     func() time.Time
+}
+
+// NewSamplingHandler returns a handler that passes at most maxPerSecond
+// debug records per second to h. When records are dropped, the first
+// record of the next window notes how many.
+// If maxPerSecond is not positive, all records are passed.
+func NewSamplingHandler(h slog.Handler, maxPerSecond int) *SamplingHandler {
+	return &SamplingHandler{h: h, state: &samplingState{max: maxPerSecond, now: time.Now}}
+}
+
+func (h *SamplingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.h.Enabled(ctx, level)
+}
+
+func (h *SamplingHandler) WithGroup(name string) slog.Handler {
+	return &SamplingHandler{h: h.h.WithGroup(name), state: h.state}
+}
+
+func (h *SamplingHandler) WithAttrs(as []slog.Attr) slog.Handler {
+	return &SamplingHandler{h: h.h.WithAttrs(as), state: h.state}
+}
+
+func (h *SamplingHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level > slog.LevelDebug || h.state.max <= 0 {
+		return h.h.Handle(ctx, r)
+	}
+	ok, dropped := h.state.sample()
+	if !ok {
+		return nil
+	}
+	if dropped > 0 {
+		r.AddAttrs(slog.Int("droppedDebugRecords", dropped))
+	}
+	return h.h.Handle(ctx, r)
+}
+
+// sample reports whether a debug record should be passed on, and if so,
+// how many were dropped in the previous window.
+func (s *samplingState) sample() (ok bool, dropped int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if now.Sub(s.window) >= time.Second {
+		dropped = s.dropped
+		s.window = now
+		s.n = 0
+		s.dropped = 0
+	}
+	if s.n >= s.max {
+		s.dropped++
+		return false, 0
+	}
+	s.n++
+	return true, dropped
+}
@@ -0,0 +1,68 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+func TestWithAndBundle(t *testing.T) {
+	var buf bytes.Buffer
+	ctx := NewContext(context.Background(), slog.New(NewLineHandler(&buf)))
+	ctx = With(ctx, "jobID", "j1", "module", "m@v1.0.0")
+	ctx, b := StartBundle(ctx)
+	Infof(ctx, "starting")
+	Debugf(ctx, "detail")
+	Errorf(ctx, errors.New("boom"), "failed")
+	b.Emit(ctx, "scan finished")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("got %d lines, want 4:\n%s", len(lines), buf.String())
+	}
+	for _, line := range lines {
+		if !strings.Contains(line, `jobID="j1" module="m@v1.0.0"`) {
+			t.Errorf("line missing request attrs: %s", line)
+		}
+	}
+	summary := lines[3]
+	for _, want := range []string{"debugs=1", "infos=1", "errors=1", `firstError="failed: boom"`} {
+		if !strings.Contains(summary, want) {
+			t.Errorf("summary missing %q: %s", want, summary)
+		}
+	}
+}
+
+func TestSamplingHandler(t *testing.T) {
+	var buf bytes.Buffer
+	sh := NewSamplingHandler(NewLineHandler(&buf), 2)
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	sh.state.now = func() time.Time { return now }
+	l := slog.New(sh)
+	for i := 0; i < 5; i++ {
+		l.Debug("d")
+	}
+	l.Info("i")
+	now = now.Add(time.Second)
+	l.Debug("next")
+
+	got := buf.String()
+	if n := strings.Count(got, "DEBUG d"); n != 2 {
+		t.Errorf("got %d debug records in first window, want 2", n)
+	}
+	if !strings.Contains(got, "INFO  i") {
+		t.Error("info record was dropped")
+	}
+	if !strings.Contains(got, "DEBUG next droppedDebugRecords=3") {
+		t.Errorf("missing dropped count:\n%s", got)
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/exp/slog"
+)
+
+// SeverityOptions configure a SeverityHandler.
+type SeverityOptions struct {
+	// MinLevel is the lowest level of the records passed on.
+	MinLevel slog.Level
+	// Categorize returns the category of an error logged with
+	// Error or Errorf. It is needed only for ErrorSampling.
+	Categorize func(error) string
+	// ErrorSampling maps error categories to sampling rates: of the error
+	// records whose error is in a category with rate N, only one in N is
+	// passed on at error level. The others are passed on at debug level,
+	// so that expected failures do not flood error reporting.
+	// A category also covers its subcategories, whose names extend it
+	// after an underscore: LOAD covers LOAD_NO_GOMOD. A subcategory
+	// with a rate of its own is sampled separately.
+	ErrorSampling map[string]int
+}
+
+// SeverityHandler is a slog.Handler that drops records below a minimum
+// level and lowers the level of most error records of the sampled
+// categories, before passing them to another handler.
+type SeverityHandler struct {
+	h     slog.Handler
+	opts  SeverityOptions
+	state *severityState
+}
+
+type severityState struct {
+	mu     sync.Mutex
+	counts map[string]int // error records seen, by ErrorSampling key
+}
+
+// NewSeverityHandler returns a handler that passes records to h as
+// described by opts.
+func NewSeverityHandler(h slog.Handler, opts SeverityOptions) *SeverityHandler {
+	return &SeverityHandler{h: h, opts: opts, state: &severityState{counts: map[string]int{}}}
+}
+
+func (h *SeverityHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.opts.MinLevel && h.h.Enabled(ctx, level)
+}
+
+func (h *SeverityHandler) WithGroup(name string) slog.Handler {
+	return &SeverityHandler{h: h.h.WithGroup(name), opts: h.opts, state: h.state}
+}
+
+func (h *SeverityHandler) WithAttrs(as []slog.Attr) slog.Handler {
+	return &SeverityHandler{h: h.h.WithAttrs(as), opts: h.opts, state: h.state}
+}
+
+func (h *SeverityHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level >= slog.LevelError && len(h.opts.ErrorSampling) > 0 && h.opts.Categorize != nil {
+		if category, rate := h.samplingRate(r); rate > 1 && !h.state.sample(category, rate) {
+			r = r.Clone()
+			r.AddAttrs(slog.String("sampledLevel", r.Level.String()))
+			r.Level = slog.LevelDebug
+		}
+	}
+	if r.Level < h.opts.MinLevel {
+		return nil
+	}
+	return h.h.Handle(ctx, r)
+}
+
+// samplingRate returns the ErrorSampling key that covers the category of
+// the error in r, and its sampling rate, or zero if it is not sampled.
+func (h *SeverityHandler) samplingRate(r slog.Record) (key string, rate int) {
+	var category string
+	r.Attrs(func(a slog.Attr) {
+		if a.Key != slog.ErrorKey || category != "" {
+			return
+		}
+		if err, ok := a.Value.Any().(error); ok {
+			category = h.opts.Categorize(err)
+		}
+	})
+	if category == "" {
+		return "", 0
+	}
+	// Use the most specific key: LOAD_NO_GOMOD, then LOAD.
+	for key = category; ; {
+		if rate, ok := h.opts.ErrorSampling[key]; ok {
+			return key, rate
+		}
+		i := strings.LastIndexByte(key, '_')
+		if i < 0 {
+			return "", 0
+		}
+		key = key[:i]
+	}
+}
+
+// sample reports whether an error record of the category, sampled at the
+// given rate, should keep its level. The first of every rate records does.
+func (s *severityState) sample(category string, rate int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.counts[category]
+	s.counts[category] = n + 1
+	return n%rate == 0
+}
+
+// ParseLevel parses a level name, like "debug" or "WARN", ignoring case.
+func ParseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(s)); err != nil {
+		return 0, err
+	}
+	return l, nil
+}
+
+// ParseErrorSampling parses error sampling rates written as a
+// comma-separated list of CATEGORY=N, like "LOAD=100,PROXY=10".
+func ParseErrorSampling(s string) (map[string]int, error) {
+	rates := map[string]int{}
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		category, n, ok := strings.Cut(f, "=")
+		if !ok {
+			return nil, fmt.Errorf("error sampling %q: missing '='", f)
+		}
+		rate, err := strconv.Atoi(n)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("error sampling %q: rate must be a positive integer", f)
+		}
+		rates[category] = rate
+	}
+	return rates, nil
+}
@@ -0,0 +1,127 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/exp/slog"
+)
+
+var (
+	errLoad  = errors.New("load")
+	errOther = errors.New("other")
+)
+
+func TestSeverityHandler(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewSeverityHandler(NewLineHandler(&buf), SeverityOptions{
+		MinLevel: slog.LevelDebug,
+		Categorize: func(err error) string {
+			if errors.Is(err, errLoad) {
+				return "LOAD_NO_GOMOD"
+			}
+			return "MISC"
+		},
+		ErrorSampling: map[string]int{"LOAD": 3},
+	})
+	ctx := NewContext(context.Background(), slog.New(h))
+	for i := 0; i < 7; i++ {
+		Errorf(ctx, errLoad, "load failed")
+	}
+	Errorf(ctx, errOther, "other failed")
+
+	got := buf.String()
+	if n := strings.Count(got, "ERROR load failed"); n != 3 {
+		t.Errorf("got %d load errors at error level, want 3:\n%s", n, got)
+	}
+	if n := strings.Count(got, `DEBUG load failed err=load sampledLevel="ERROR"`); n != 4 {
+		t.Errorf("got %d load errors at debug level, want 4:\n%s", n, got)
+	}
+	if !strings.Contains(got, "ERROR other failed") {
+		t.Errorf("unsampled error was not logged at error level:\n%s", got)
+	}
+
+	// With a minimum level of info, sampled errors are dropped.
+	buf.Reset()
+	h = NewSeverityHandler(NewLineHandler(&buf), SeverityOptions{
+		MinLevel:      slog.LevelInfo,
+		Categorize:    func(error) string { return "LOAD" },
+		ErrorSampling: map[string]int{"LOAD": 2},
+	})
+	ctx = NewContext(context.Background(), slog.New(h))
+	Debugf(ctx, "detail")
+	Infof(ctx, "info")
+	Errorf(ctx, errLoad, "first")
+	Errorf(ctx, errLoad, "second")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], "INFO  info") || !strings.Contains(lines[1], "ERROR first") {
+		t.Errorf("got:\n%s\nwant the info record and the first error", buf.String())
+	}
+}
+
+func TestSamplingRate(t *testing.T) {
+	h := NewSeverityHandler(NewLineHandler(&bytes.Buffer{}), SeverityOptions{
+		Categorize:    func(err error) string { return err.Error() },
+		ErrorSampling: map[string]int{"LOAD": 100, "LOAD_NO_GOMOD": 5},
+	})
+	for _, test := range []struct {
+		category string
+		wantKey  string
+		wantRate int
+	}{
+		{"LOAD", "LOAD", 100},
+		{"LOAD_NO_GOSUM", "LOAD", 100},
+		{"LOAD_NO_GOMOD", "LOAD_NO_GOMOD", 5},
+		{"LOADER", "", 0},
+		{"MISC", "", 0},
+	} {
+		r := slog.NewRecord(time.Time{}, slog.LevelError, "msg", 0)
+		r.AddAttrs(slog.Any(slog.ErrorKey, errors.New(test.category)))
+		if key, rate := h.samplingRate(r); key != test.wantKey || rate != test.wantRate {
+			t.Errorf("%s: got %q, %d; want %q, %d", test.category, key, rate, test.wantKey, test.wantRate)
+		}
+	}
+}
+
+func TestParseErrorSampling(t *testing.T) {
+	got, err := ParseErrorSampling("LOAD=100, PROXY=10,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(map[string]int{"LOAD": 100, "PROXY": 10}, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+	for _, bad := range []string{"LOAD", "LOAD=x", "LOAD=0"} {
+		if _, err := ParseErrorSampling(bad); err == nil {
+			t.Errorf("%q: got no error", bad)
+		}
+	}
+}
+
+func TestGCPSeverity(t *testing.T) {
+	for _, test := range []struct {
+		level slog.Level
+		want  string
+	}{
+		{slog.LevelDebug - 4, "DEBUG"},
+		{slog.LevelDebug, "DEBUG"},
+		{slog.LevelInfo, "INFO"},
+		{slog.LevelWarn, "WARNING"},
+		{slog.LevelWarn + 2, "WARNING"},
+		{slog.LevelError, "ERROR"},
+		{slog.LevelError + 4, "CRITICAL"},
+	} {
+		if got := gcpSeverity(test.level); got != test.want {
+			t.Errorf("%v: got %q, want %q", test.level, got, test.want)
+		}
+	}
+}
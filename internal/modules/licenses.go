@@ -0,0 +1,107 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package modules
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// UnknownLicense is the license type of a license file that
+// DetectLicenses does not recognize.
+const UnknownLicense = "UNKNOWN"
+
+// licenseFileNames are the base names, lower-cased and without extension,
+// of files considered to hold a module's license.
+var licenseFileNames = map[string]bool{
+	"license":   true,
+	"licence":   true,
+	"copying":   true,
+	"unlicense": true,
+}
+
+// licenseMatchers identify license types by phrases in the license text.
+// The text is lower-cased and its white space collapsed before matching.
+// Matchers are tried in order and the first match wins, so more specific
+// licenses come before the ones whose text they contain.
+// All of these licenses permit redistribution.
+var licenseMatchers = []struct {
+	typ     string
+	phrases []string
+}{
+	{"AGPL-3.0", []string{"gnu affero general public license", "version 3"}},
+	{"LGPL-3.0", []string{"gnu lesser general public license", "version 3"}},
+	{"LGPL-2.1", []string{"gnu lesser general public license", "version 2.1"}},
+	{"GPL-3.0", []string{"gnu general public license", "version 3"}},
+	{"GPL-2.0", []string{"gnu general public license", "version 2"}},
+	{"Apache-2.0", []string{"apache license", "version 2.0"}},
+	{"MPL-2.0", []string{"mozilla public license", "version 2.0"}},
+	{"EPL-2.0", []string{"eclipse public license", "v 2.0"}},
+	{"BSD-3-Clause", []string{"redistribution and use in source and binary forms", "neither the name"}},
+	{"BSD-2-Clause", []string{"redistribution and use in source and binary forms"}},
+	{"MIT", []string{"permission is hereby granted, free of charge"}},
+	{"ISC", []string{"permission to use, copy, modify, and/or distribute this software for any purpose"}},
+	{"BSL-1.0", []string{"boost software license"}},
+	{"Unlicense", []string{"this is free and unencumbered software released into the public domain"}},
+	{"CC0-1.0", []string{"cc0 1.0 universal"}},
+	{"Zlib", []string{"this software is provided 'as-is', without any express or implied warranty", "altered source versions must be plainly marked"}},
+}
+
+// DetectLicenses returns the sorted, distinct types of the license files at
+// the top level of the module in dir, and whether those licenses permit
+// redistribution of the module's source.
+// A module is redistributable only if it has at least one license file
+// and every license file is of a recognized type.
+func DetectLicenses(dir string) (types []string, redistributable bool, err error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, false, err
+	}
+	seen := map[string]bool{}
+	for _, e := range entries {
+		if !e.Type().IsRegular() || !isLicenseFile(e.Name()) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, false, err
+		}
+		seen[licenseType(string(data))] = true
+	}
+	for t := range seen {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types, len(types) > 0 && !seen[UnknownLicense], nil
+}
+
+// isLicenseFile reports whether name, a file base name, looks like
+// a license file, such as "LICENSE" or "COPYING.md".
+func isLicenseFile(name string) bool {
+	name = strings.ToLower(name)
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	return licenseFileNames[name]
+}
+
+// licenseType returns the type of the license with the given text,
+// or UnknownLicense.
+func licenseType(text string) string {
+	text = strings.Join(strings.Fields(strings.ToLower(text)), " ")
+	for _, m := range licenseMatchers {
+		match := true
+		for _, p := range m.phrases {
+			if !strings.Contains(text, p) {
+				match = false
+				break
+			}
+		}
+		if match {
+			return m.typ
+		}
+	}
+	return UnknownLicense
+}
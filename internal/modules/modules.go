@@ -9,24 +9,60 @@ package modules
 import (
 	"archive/zip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
 
+	modpkg "golang.org/x/mod/module"
+	"golang.org/x/mod/sumdb/dirhash"
 	"golang.org/x/pkgsite-metrics/internal/derrors"
 	"golang.org/x/pkgsite-metrics/internal/log"
 	"golang.org/x/pkgsite-metrics/internal/proxy"
 )
 
-// Download fetches module at version via proxyClient and writes the modules
-// down to disk at dir.
-func Download(ctx context.Context, module, version, dir string, proxyClient *proxy.Client) error {
+// Download fetches module at version via proxyClient, verifies it against
+// the checksum database sumDB, and writes the modules down to disk at dir.
+// It returns the size of the module zip, as computed by ZipSize, and the
+// result of the verification, as returned by SumDB.Verify, even if the
+// verification fails. If sumDB is nil, the module is not verified.
+func Download(ctx context.Context, module, version, dir string, proxyClient *proxy.Client, sumDB *SumDB) (zipSize int64, verification string, err error) {
 	zipr, err := proxyClient.Zip(ctx, module, version)
 	if err != nil {
-		return fmt.Errorf("%v: %w", err, derrors.ProxyError)
+		return 0, "", fmt.Errorf("%v: %w", err, derrors.ProxyError)
 	}
+	return Extract(ctx, zipr, module, version, dir, sumDB)
+}
+
+// Extract is like Download, but the zip of module at version has already
+// been downloaded and is read by zipr.
+func Extract(ctx context.Context, zipr *zip.Reader, module, version, dir string, sumDB *SumDB) (zipSize int64, verification string, err error) {
+	verification, err = sumDB.Verify(ctx, zipr, module, version)
+	if err != nil {
+		return 0, verification, err
+	}
+	if err := Unzip(ctx, zipr, module, version, dir); err != nil {
+		return 0, "", err
+	}
+	return ZipSize(zipr), verification, nil
+}
+
+// ZipSize returns the approximate size in bytes of the zip read by zipr:
+// the compressed size of its files, without the zip's metadata.
+func ZipSize(zipr *zip.Reader) int64 {
+	var n int64
+	for _, f := range zipr.File {
+		n += int64(f.CompressedSize64)
+	}
+	return n
+}
+
+// Unzip writes the files of zipr, the zip of module at version in the
+// format served by Go module proxies, down to disk at dir.
+func Unzip(ctx context.Context, zipr *zip.Reader, module, version, dir string) error {
 	log.Debugf(ctx, "writing module zip: %s@%s", module, version)
 	stripPrefix := module + "@" + version + "/"
 	if err := writeZip(zipr, dir, stripPrefix); err != nil {
@@ -35,6 +71,52 @@ func Download(ctx context.Context, module, version, dir string, proxyClient *pro
 	return nil
 }
 
+// ZipFile returns the path of the zip of module at version in a directory
+// laid out like a Go module proxy, such as "example.com/!m/@v/v1.0.0.zip".
+func ZipFile(module, version string) (string, error) {
+	epath, err := modpkg.EscapePath(module)
+	if err != nil {
+		return "", err
+	}
+	evers, err := modpkg.EscapeVersion(version)
+	if err != nil {
+		return "", err
+	}
+	return epath + "/@v/" + evers + ".zip", nil
+}
+
+// ContentHash returns a hash of the contents of module at version, as served
+// by the proxy: its go.mod file mod and the files in its zip, read by zipr.
+// Unlike the hashes in go.sum, it does not depend on the version, so two
+// versions with identical contents, like pseudo-versions of a synthetic
+// module that has not changed, have the same content hash. The version must
+// be resolved.
+func ContentHash(module, version string, mod []byte, zipr *zip.Reader) (_ string, err error) {
+	defer derrors.Wrap(&err, "ContentHash(%q, %q)", module, version)
+
+	stripPrefix := module + "@" + version + "/"
+	var names []string
+	files := map[string]*zip.File{}
+	for _, f := range zipr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		name := strings.TrimPrefix(f.Name, stripPrefix)
+		names = append(names, name)
+		files[name] = f
+	}
+	zipHash, err := dirhash.Hash1(names, func(name string) (io.ReadCloser, error) {
+		return files[name].Open()
+	})
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "mod %x\n", sha256.Sum256(mod))
+	fmt.Fprintf(h, "zip %s\n", zipHash)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
 func writeZip(r *zip.Reader, destination, stripPrefix string) error {
 	for _, f := range r.File {
 		name := strings.TrimPrefix(f.Name, stripPrefix)
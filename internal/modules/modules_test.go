@@ -7,9 +7,13 @@ package modules
 import (
 	"archive/zip"
 	"bytes"
+	"context"
 	"os"
 	"path/filepath"
 	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/pkgsite-metrics/internal/proxy/proxytest"
 )
 
 func TestWriteZip(t *testing.T) {
@@ -46,6 +50,10 @@ func TestWriteZip(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	if n := ZipSize(r); n <= 0 || n > int64(buf.Len()) {
+		t.Errorf("ZipSize = %d, want in (0, %d]", n, buf.Len())
+	}
+
 	tempDir := t.TempDir()
 	if err := writeZip(r, tempDir, "golang.org@v0.0.0/"); err != nil {
 		t.Error(err)
@@ -61,3 +69,113 @@ func TestWriteZip(t *testing.T) {
 		}
 	}
 }
+
+func TestZipFile(t *testing.T) {
+	for _, test := range []struct {
+		module, version, want string
+	}{
+		{"golang.org/x/text", "v0.3.0", "golang.org/x/text/@v/v0.3.0.zip"},
+		{"example.com/Upper", "v1.0.0-RC1", "example.com/!upper/@v/v1.0.0-!r!c1.zip"},
+	} {
+		got, err := ZipFile(test.module, test.version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("ZipFile(%q, %q) = %q, want %q", test.module, test.version, got, test.want)
+		}
+	}
+	if _, err := ZipFile("example.com/m", "v1.0.0/../x"); err == nil {
+		t.Error("bad version: got nil, want error")
+	}
+}
+
+func TestContentHash(t *testing.T) {
+	const modulePath = "example.com/m"
+	m := &proxytest.Module{
+		ModulePath: modulePath,
+		Version:    "v0.0.0-20230101000000-000000000000",
+		Files: map[string]string{
+			"go.mod": "module " + modulePath,
+			"a.go":   "package a",
+		},
+	}
+	proxyClient, cleanup := proxytest.SetupTestClient(t, []*proxytest.Module{
+		m,
+		m.ChangeVersion("v0.0.0-20230202000000-111111111111"),
+		m.ChangeVersion("v0.0.0-20230303000000-222222222222").AddFile("b.go", "package a"),
+	})
+	defer cleanup()
+
+	ctx := context.Background()
+	hash := func(version string) string {
+		t.Helper()
+		mod, err := proxyClient.Mod(ctx, modulePath, version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zipr, err := proxyClient.Zip(ctx, modulePath, version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h, err := ContentHash(modulePath, version, mod, zipr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	h1 := hash("v0.0.0-20230101000000-000000000000")
+	h2 := hash("v0.0.0-20230202000000-111111111111")
+	h3 := hash("v0.0.0-20230303000000-222222222222")
+	if h1 != h2 {
+		t.Errorf("same contents, different versions: got different hashes %s and %s", h1, h2)
+	}
+	if h1 == h3 {
+		t.Errorf("different contents: got same hash %s", h1)
+	}
+}
+
+func TestDetectLicenses(t *testing.T) {
+	const (
+		mit = `Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software")...`
+		bsd3 = `Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+   * Neither the name of Google Inc. nor the names of its
+contributors may be used to endorse or promote products...`
+		apache = `                                 Apache License
+                           Version 2.0, January 2004`
+	)
+	for _, test := range []struct {
+		name                string
+		files               map[string]string
+		wantTypes           []string
+		wantRedistributable bool
+	}{
+		{"none", map[string]string{"go.mod": "module m"}, nil, false},
+		{"mit", map[string]string{"LICENSE": mit}, []string{"MIT"}, true},
+		{"bsd", map[string]string{"LICENSE.md": bsd3}, []string{"BSD-3-Clause"}, true},
+		{"two", map[string]string{"LICENSE": apache, "COPYING.txt": mit}, []string{"Apache-2.0", "MIT"}, true},
+		{"unknown", map[string]string{"LICENSE": mit, "LICENCE": "All rights reserved."}, []string{"MIT", UnknownLicense}, false},
+		{"not license", map[string]string{"LICENSES.go": mit}, nil, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range test.files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			gotTypes, gotRedist, err := DetectLicenses(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.wantTypes, gotTypes); diff != "" {
+				t.Errorf("types mismatch (-want, +got):\n%s", diff)
+			}
+			if gotRedist != test.wantRedistributable {
+				t.Errorf("got redistributable %t, want %t", gotRedist, test.wantRedistributable)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package modules
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/mod/sumdb"
+	"golang.org/x/mod/sumdb/dirhash"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/log"
+)
+
+// The results of verifying a module zip against the checksum database,
+// as recorded in result rows.
+const (
+	// The zip's hash matches the one in the checksum database.
+	Verified = "verified"
+	// The zip's hash differs from the one in the checksum database, or
+	// the database's response failed verification, as when its tree is
+	// inconsistent with the one it served before. The zip is not used,
+	// and the scan fails with derrors.ChecksumMismatch.
+	Mismatch = "mismatch"
+	// The zip was not checked, because there is no checksum database
+	// configured or the module did not come from the proxy.
+	Unverified = "unverified"
+	// The checksum database could not be consulted, because it does not
+	// know the module or could not be reached.
+	LookupFailed = "lookup-failed"
+)
+
+// SumGolangOrgKey is the verifier key of sum.golang.org.
+const SumGolangOrgKey = "sum.golang.org+033de0ae+Ac4zctda0e5eza+HJyk9SxEdh+s3Ne6+cnaBbcvEBsq4vcCQw5OLZVKh"
+
+// A SumDB verifies module zips against a Go checksum database, such as
+// sum.golang.org. It checks the proofs served by the database, so it can
+// be reached through an untrusted proxy. It is safe for concurrent use.
+type SumDB struct {
+	client *sumdb.Client
+}
+
+// NewSumDB returns a SumDB for the checksum database with the given
+// verifier key, served at url. The url may be that of the database itself,
+// like https://sum.golang.org, or of a proxy for it, like
+// https://proxy.golang.org/sumdb/sum.golang.org.
+func NewSumDB(url, key string, httpClient *http.Client) *SumDB {
+	return &SumDB{client: sumdb.NewClient(&sumdbOps{
+		url:        strings.TrimRight(url, "/"),
+		key:        key,
+		httpClient: httpClient,
+		config:     map[string][]byte{},
+	})}
+}
+
+// ZipHash returns the hash of module at version recorded in the checksum
+// database, in the format of go.sum, like "h1:...".
+func (s *SumDB) ZipHash(module, version string) (_ string, err error) {
+	defer derrors.Wrap(&err, "SumDB.ZipHash(%q, %q)", module, version)
+	lines, err := s.client.Lookup(module, version)
+	if err != nil {
+		return "", err
+	}
+	prefix := module + " " + version + " "
+	for _, line := range lines {
+		if h, ok := strings.CutPrefix(line, prefix); ok {
+			return h, nil
+		}
+	}
+	return "", fmt.Errorf("no hash in %q", lines)
+}
+
+// Verify checks the zip read by zipr, that of module at version, against
+// the checksum database, and returns the result. If s is nil, it returns
+// Unverified. If the hashes differ, or the database's response cannot be
+// verified, it returns Mismatch and an error wrapping
+// derrors.ChecksumMismatch. Failures to reach the database are logged,
+// not returned.
+func (s *SumDB) Verify(ctx context.Context, zipr *zip.Reader, module, version string) (string, error) {
+	if s == nil {
+		return Unverified, nil
+	}
+	want, err := s.ZipHash(module, version)
+	if err != nil {
+		// The client keeps only the text of the errors of ReadRemote.
+		if strings.Contains(err.Error(), errUnavailable.Error()) {
+			log.Warnf(ctx, "checksum database lookup: %v", err)
+			return LookupFailed, nil
+		}
+		return Mismatch, fmt.Errorf("%w: %s@%s: checksum database: %v",
+			derrors.ChecksumMismatch, module, version, err)
+	}
+	got, err := HashZip(zipr)
+	if err != nil {
+		return "", err
+	}
+	if got != want {
+		return Mismatch, fmt.Errorf("%w: %s@%s: zip has hash %s, checksum database has %s",
+			derrors.ChecksumMismatch, module, version, got, want)
+	}
+	return Verified, nil
+}
+
+// HashZip returns the hash of the module zip read by zipr, as recorded in
+// go.sum files and the checksum database. It is the same as that of
+// dirhash.HashZip, which reads a zip file.
+func HashZip(zipr *zip.Reader) (string, error) {
+	var names []string
+	files := map[string]*zip.File{}
+	for _, f := range zipr.File {
+		names = append(names, f.Name)
+		files[f.Name] = f
+	}
+	return dirhash.Hash1(names, func(name string) (io.ReadCloser, error) {
+		return files[name].Open()
+	})
+}
+
+// sumdbOps implements sumdb.ClientOps. It keeps the configuration in
+// memory, so each SumDB starts from an empty tree, and caches nothing: the
+// client itself caches the records and tiles it has already verified.
+type sumdbOps struct {
+	url        string
+	key        string
+	httpClient *http.Client
+
+	mu     sync.Mutex
+	config map[string][]byte // configuration files, other than the key
+}
+
+// errUnavailable is wrapped by the errors of ReadRemote, which mean that
+// the checksum database could not be consulted, rather than that it
+// misbehaved.
+var errUnavailable = errors.New("checksum database unavailable")
+
+func (o *sumdbOps) ReadRemote(path string) ([]byte, error) {
+	resp, err := o.httpClient.Get(o.url + path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errUnavailable, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errUnavailable, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: GET %s%s: %s: %s", errUnavailable, o.url, path, resp.Status, bytes.TrimSpace(data))
+	}
+	return data, nil
+}
+
+func (o *sumdbOps) ReadConfig(file string) ([]byte, error) {
+	if file == "key" {
+		return []byte(o.key), nil
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.config[file], nil
+}
+
+func (o *sumdbOps) WriteConfig(file string, old, new []byte) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if !bytes.Equal(o.config[file], old) {
+		return sumdb.ErrWriteConflict
+	}
+	o.config[file] = new
+	return nil
+}
+
+func (o *sumdbOps) ReadCache(file string) ([]byte, error) { return nil, fs.ErrNotExist }
+
+func (o *sumdbOps) WriteCache(file string, data []byte) {}
+
+func (o *sumdbOps) Log(msg string) {
+	log.Infof(context.Background(), "sumdb: %s", msg)
+}
+
+func (o *sumdbOps) SecurityError(msg string) {
+	// The client returns sumdb.ErrSecurity from the operation.
+	log.Errorf(context.Background(), sumdb.ErrSecurity, "sumdb: %s", msg)
+}
@@ -0,0 +1,170 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package modules
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/mod/sumdb"
+	"golang.org/x/mod/sumdb/dirhash"
+	"golang.org/x/mod/sumdb/note"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+)
+
+// makeZip returns a module zip with the given files, named relative to the
+// module root.
+func makeZip(t *testing.T, module, version string, files map[string]string) *zip.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, body := range files {
+		f, err := w.Create(module + "@" + version + "/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zipr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return zipr
+}
+
+func TestHashZip(t *testing.T) {
+	const module, version = "example.com/m", "v1.0.0"
+	zipr := makeZip(t, module, version, map[string]string{
+		"go.mod": "module example.com/m\n",
+		"m.go":   "package m\n",
+	})
+	got, err := HashZip(zipr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Compare with dirhash.HashZip, which reads a zip file.
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, f := range zipr.File {
+		if err := w.Copy(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "m.zip")
+	if err := os.WriteFile(file, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	want, err := dirhash.HashZip(file, dirhash.Hash1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestSumDBVerify(t *testing.T) {
+	ctx := context.Background()
+	const version = "v1.0.0"
+	files := map[string]string{"go.mod": "module example.com/m\n", "m.go": "package m\n"}
+	good := makeZip(t, "example.com/m", version, files)
+	goodHash, err := HashZip(good)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files["m.go"] = "package m // tampered\n"
+	bad := makeZip(t, "example.com/m", version, files)
+
+	// Serve a checksum database that knows only example.com/m.
+	skey, vkey, err := note.GenerateKey(rand.Reader, "sumdb.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gosum := func(path, vers string) ([]byte, error) {
+		if path != "example.com/m" || vers != version {
+			return nil, errors.New("not found")
+		}
+		return []byte(fmt.Sprintf("%s %s %s\n%[1]s %[2]s/go.mod h1:unused\n", path, vers, goodHash)), nil
+	}
+	srv := httptest.NewServer(sumdb.NewServer(sumdb.NewTestServer(skey, gosum)))
+	defer srv.Close()
+
+	db := NewSumDB(srv.URL, vkey, http.DefaultClient)
+	// A database whose responses are not signed with its key fails
+	// verification.
+	_, otherKey, err := note.GenerateKey(rand.Reader, "sumdb.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := NewSumDB(srv.URL, otherKey, http.DefaultClient)
+	unreachable := NewSumDB("http://127.0.0.1:0", vkey, http.DefaultClient)
+	for _, test := range []struct {
+		name    string
+		sumDB   *SumDB
+		zipr    *zip.Reader
+		module  string
+		want    string
+		wantErr error
+	}{
+		{"verified", db, good, "example.com/m", Verified, nil},
+		{"mismatch", db, bad, "example.com/m", Mismatch, derrors.ChecksumMismatch},
+		{"unknown module", db, good, "example.com/other", LookupFailed, nil},
+		{"unreachable", unreachable, good, "example.com/m", LookupFailed, nil},
+		{"bad signature", forged, good, "example.com/m", Mismatch, derrors.ChecksumMismatch},
+		{"no sumdb", nil, bad, "example.com/m", Unverified, nil},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := test.sumDB.Verify(ctx, test.zipr, test.module, version)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("got error %v, want %v", err, test.wantErr)
+			}
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+
+	// A database that serves a tree inconsistent with the one it served
+	// before, as a forked log would, fails verification.
+	fork := sumdb.NewServer(sumdb.NewTestServer(skey, func(path, vers string) ([]byte, error) {
+		return []byte(fmt.Sprintf("%s %s h1:forked\n", path, vers)), nil
+	}))
+	var forked atomic.Bool
+	forkSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if forked.Load() {
+			fork.ServeHTTP(w, r)
+		} else {
+			srv.Config.Handler.ServeHTTP(w, r)
+		}
+	}))
+	defer forkSrv.Close()
+	forkDB := NewSumDB(forkSrv.URL, vkey, http.DefaultClient)
+	if got, err := forkDB.Verify(ctx, good, "example.com/m", version); err != nil || got != Verified {
+		t.Fatalf("before the fork: got %q, %v; want %q", got, err, Verified)
+	}
+	forked.Store(true)
+	got, err := forkDB.Verify(ctx, good, "example.com/n", version)
+	if !errors.Is(err, derrors.ChecksumMismatch) || got != Mismatch {
+		t.Errorf("after the fork: got %q, %v; want %q and ChecksumMismatch", got, err, Mismatch)
+	}
+}
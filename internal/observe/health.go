@@ -0,0 +1,41 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package observe
+
+import "time"
+
+// Health summarizes the state of a worker instance, as served by the
+// worker's /health endpoint. Values that are not available, like the
+// container memory outside Cloud Run, are zero.
+type Health struct {
+	VersionID string    // the version of the worker
+	Started   time.Time // when the instance started
+	Now       time.Time // when the summary was made, on the instance's clock
+
+	ActiveScans  int    // scans in progress
+	StalledScans int    // scans in progress that have stopped reporting progress
+	Requests     uint64 // scan requests received since the instance started
+	// RequestLimit is the number of requests after which the instance
+	// restarts itself.
+	RequestLimit int
+
+	HeapBytes                 uint64
+	ContainerMemoryBytes      int64 `json:",omitempty"`
+	ContainerMemoryLimitBytes int64 `json:",omitempty"`
+
+	// QueueBacklog estimates the number of tasks that are queued or
+	// running. For the Cloud Tasks queue, it counts the unfinished tasks
+	// of unfinished jobs, so tasks enqueued without a job are missing.
+	QueueBacklog int
+	// StartsLastHour and StartsLastDay count the starts of all the
+	// worker's instances, including restarts and scaling up.
+	StartsLastHour int
+	StartsLastDay  int
+}
+
+// Uptime returns how long the instance had been running.
+func (h *Health) Uptime() time.Duration {
+	return h.Now.Sub(h.Started)
+}
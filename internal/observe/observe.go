@@ -70,10 +70,13 @@ func NewObserver(ctx context.Context, projectID, serverName string) (_ *Observer
 }
 
 // Observe adds metrics and tracing to an http.Handler.
+// Metrics are always accumulated in Metrics; if o is nil, they are not
+// exported anywhere else, and there is no tracing.
 func (o *Observer) Observe(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if o == nil {
-			h.ServeHTTP(w, r.WithContext(r.Context()))
+			exporter := event.NewExporter(Metrics, nil)
+			h.ServeHTTP(w, r.WithContext(event.WithExporter(r.Context(), exporter)))
 			return
 		}
 		exporter := event.NewExporter(o, nil)
@@ -87,5 +90,6 @@ func (o *Observer) Observe(h http.Handler) http.Handler {
 // Event implements event.Handler.
 func (o *Observer) Event(ctx context.Context, ev *event.Event) context.Context {
 	ctx = o.traceHandler.Event(ctx, ev)
+	ctx = Metrics.Event(ctx, ev)
 	return o.metricHandler.Event(ctx, ev)
 }
@@ -0,0 +1,179 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package observe
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/exp/event"
+)
+
+// Metrics accumulates the metric events of all requests handled with
+// Observe, for serving in the Prometheus text format.
+var Metrics = NewRegistry()
+
+// A Registry is an event.Handler that accumulates the values of metric
+// events. Counters are summed, gauges keep their last value, and
+// durations are summarized by their count and sum in seconds.
+type Registry struct {
+	mu     sync.Mutex
+	series map[string]*series // by name and labels
+}
+
+// A series holds the value of a metric with particular labels.
+type series struct {
+	name   string // Prometheus name
+	help   string
+	typ    string // Prometheus type
+	labels string // formatted as {k="v",...}, or empty
+	value  float64
+	count  int64 // for summaries
+}
+
+// A Gauge is a value computed when metrics are written, like the
+// number of active scans.
+type Gauge struct {
+	Name  string // Prometheus name
+	Help  string
+	Value float64
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{series: map[string]*series{}}
+}
+
+// Event implements event.Handler.
+func (r *Registry) Event(ctx context.Context, ev *event.Event) context.Context {
+	if ev.Kind != event.MetricKind {
+		return ctx
+	}
+	mv, ok := event.MetricKey.Find(ev)
+	if !ok {
+		return ctx
+	}
+	m := mv.(event.Metric)
+	val := ev.Find(event.MetricVal)
+	if !val.HasValue() {
+		return ctx
+	}
+	opts := m.Options()
+	name := promName(opts.Namespace + "/" + m.Name())
+	labels := promLabels(ev.Labels)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch m.(type) {
+	case *event.Counter:
+		r.get(name+"_total", opts.Description, "counter", labels).value += float64(val.Int64())
+	case *event.FloatGauge:
+		r.get(name, opts.Description, "gauge", labels).value = val.Float64()
+	case *event.DurationDistribution:
+		s := r.get(name+"_seconds", opts.Description, "summary", labels)
+		s.value += val.Duration().Seconds()
+		s.count++
+	case *event.IntDistribution:
+		s := r.get(name, opts.Description, "summary", labels)
+		s.value += float64(val.Int64())
+		s.count++
+	}
+	return ctx
+}
+
+// get returns the series with the given name and labels, creating it
+// if necessary. r.mu must be held.
+func (r *Registry) get(name, help, typ, labels string) *series {
+	key := name + labels
+	s := r.series[key]
+	if s == nil {
+		s = &series{name: name, help: help, typ: typ, labels: labels}
+		r.series[key] = s
+	}
+	return s
+}
+
+// WritePrometheus writes the accumulated metrics and the gauges to w in
+// the Prometheus text exposition format.
+func (r *Registry) WritePrometheus(w io.Writer, gauges []Gauge) error {
+	r.mu.Lock()
+	all := make([]series, 0, len(r.series)+len(gauges))
+	for _, s := range r.series {
+		all = append(all, *s)
+	}
+	r.mu.Unlock()
+	for _, g := range gauges {
+		all = append(all, series{name: promName(g.Name), help: g.Help, typ: "gauge", value: g.Value})
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].name != all[j].name {
+			return all[i].name < all[j].name
+		}
+		return all[i].labels < all[j].labels
+	})
+
+	var b strings.Builder
+	for i, s := range all {
+		if i == 0 || all[i-1].name != s.name {
+			if s.help != "" {
+				fmt.Fprintf(&b, "# HELP %s %s\n", s.name, strings.ReplaceAll(s.help, "\n", " "))
+			}
+			fmt.Fprintf(&b, "# TYPE %s %s\n", s.name, s.typ)
+		}
+		if s.typ == "summary" {
+			fmt.Fprintf(&b, "%s_sum%s %g\n", s.name, s.labels, s.value)
+			fmt.Fprintf(&b, "%s_count%s %d\n", s.name, s.labels, s.count)
+		} else {
+			fmt.Fprintf(&b, "%s%s %g\n", s.name, s.labels, s.value)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// promName converts a metric name like "ecosystem/worker/disk-limit-exceeded"
+// into a valid Prometheus name, like "ecosystem_worker_disk_limit_exceeded".
+func promName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// promLabels formats the labels of a metric event, except the metric
+// itself and its value, sorted by name.
+func promLabels(ls []event.Label) string {
+	var parts []string
+	for _, l := range ls {
+		if l.Name == string(event.MetricKey) || l.Name == string(event.MetricVal) {
+			continue
+		}
+		var v string
+		switch {
+		case l.IsString():
+			v = l.String()
+		case l.IsInt64():
+			v = fmt.Sprint(l.Int64())
+		case l.IsFloat64():
+			v = fmt.Sprint(l.Float64())
+		case l.IsBool():
+			v = fmt.Sprint(l.Bool())
+		default:
+			v = fmt.Sprint(l.Interface())
+		}
+		parts = append(parts, fmt.Sprintf("%s=%q", promName(l.Name), v))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	sort.Strings(parts)
+	return "{" + strings.Join(parts, ",") + "}"
+}
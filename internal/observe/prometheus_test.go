@@ -0,0 +1,56 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package observe
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/exp/event"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	ctx := event.WithExporter(context.Background(), event.NewExporter(r, nil))
+
+	counter := event.NewCounter("scan-requests", &event.MetricOptions{Namespace: "eco/worker", Description: "Scan requests."})
+	gauge := event.NewFloatGauge("load", &event.MetricOptions{Namespace: "eco/worker"})
+	latency := event.NewDuration("latency", &event.MetricOptions{Namespace: "eco/proxy", Description: "Latency\nof requests."})
+
+	counter.Record(ctx, 1, event.String("status", "ok"))
+	counter.Record(ctx, 2, event.String("status", "ok"))
+	counter.Record(ctx, 1, event.String("status", `bad "x"`), event.Int64("code", 500))
+	gauge.Record(ctx, 0.5)
+	gauge.Record(ctx, 0.25)
+	latency.Record(ctx, time.Second)
+	latency.Record(ctx, 500*time.Millisecond)
+	// Non-metric events are ignored.
+	event.Log(ctx, "hello")
+
+	var b strings.Builder
+	if err := r.WritePrometheus(&b, []Gauge{{Name: "eco/worker/active-scans", Help: "Active scans.", Value: 3}}); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP eco_proxy_latency_seconds Latency of requests.
+# TYPE eco_proxy_latency_seconds summary
+eco_proxy_latency_seconds_sum 1.5
+eco_proxy_latency_seconds_count 2
+# HELP eco_worker_active_scans Active scans.
+# TYPE eco_worker_active_scans gauge
+eco_worker_active_scans 3
+# TYPE eco_worker_load gauge
+eco_worker_load 0.25
+# HELP eco_worker_scan_requests_total Scan requests.
+# TYPE eco_worker_scan_requests_total counter
+eco_worker_scan_requests_total{code="500",status="bad \"x\""} 1
+eco_worker_scan_requests_total{status="ok"} 3
+`
+	if diff := cmp.Diff(want, b.String()); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
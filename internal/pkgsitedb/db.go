@@ -13,8 +13,9 @@ import (
 	"database/sql"
 	"fmt"
 	"regexp"
+	"strings"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 
 	"golang.org/x/pkgsite-metrics/internal"
 	"golang.org/x/pkgsite-metrics/internal/config"
@@ -64,6 +65,49 @@ func ModuleSpecs(ctx context.Context, db *sql.DB, minImportedByCount int) (specs
 	if err != nil {
 		return nil, err
 	}
+	return scanModuleSpecs(rows)
+}
+
+// ImporterModuleSpecs retrieves the modules with packages that import any of
+// pkgPaths, or any package in modulePaths, and that contain packages imported
+// by minImportedByCount or more packages.
+// It looks for the information in the imports_unique and search_documents
+// tables of the given pkgsite DB.
+func ImporterModuleSpecs(ctx context.Context, db *sql.DB, pkgPaths, modulePaths []string, minImportedByCount int) (specs []scan.ModuleSpec, err error) {
+	defer derrors.Wrap(&err, "ImporterModuleSpecs")
+	var prefixes []string
+	for _, m := range modulePaths {
+		pkgPaths = append(pkgPaths, m)
+		prefixes = append(prefixes, likeEscape(m)+"/%")
+	}
+	query := `
+		SELECT module_path, version, max(imported_by_count)
+		FROM search_documents
+		WHERE module_path IN (
+			SELECT from_module_path
+			FROM imports_unique
+			WHERE to_path = ANY($1) OR to_path LIKE ANY($2)
+		)
+		GROUP BY module_path, version
+		HAVING max(imported_by_count) >= $3
+		ORDER by max(imported_by_count) desc`
+	rows, err := db.QueryContext(ctx, query, pq.Array(pkgPaths), pq.Array(prefixes), minImportedByCount)
+	if err != nil {
+		return nil, err
+	}
+	return scanModuleSpecs(rows)
+}
+
+// likeEscape escapes the characters of s that are special in a LIKE pattern.
+func likeEscape(s string) string {
+	return likeReplacer.Replace(s)
+}
+
+var likeReplacer = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// scanModuleSpecs returns the module specs in rows, which must have
+// columns for the module path, version and imported-by count.
+func scanModuleSpecs(rows *sql.Rows) (specs []scan.ModuleSpec, err error) {
 	defer rows.Close()
 	for rows.Next() {
 		var spec scan.ModuleSpec
@@ -24,3 +24,7 @@ func Open(ctx context.Context, cfg *config.Config) (_ *sql.DB, err error) {
 func ModuleSpecs(ctx context.Context, db *sql.DB, minImportedByCount int) (specs []scan.ModuleSpec, err error) {
 	return nil, errDoesNotCompile
 }
+
+func ImporterModuleSpecs(ctx context.Context, db *sql.DB, pkgPaths, modulePaths []string, minImportedByCount int) (specs []scan.ModuleSpec, err error) {
+	return nil, errDoesNotCompile
+}
@@ -62,3 +62,17 @@ func TestModuleSpecs(t *testing.T) {
 		fmt.Printf("%s  %s\n", g.Path, g.Version)
 	}
 }
+
+func TestLikeEscape(t *testing.T) {
+	for _, test := range []struct {
+		in, want string
+	}{
+		{"golang.org/x/text", "golang.org/x/text"},
+		{"example.com/a_b", `example.com/a\_b`},
+		{`example.com/100%\x`, `example.com/100\%\\x`},
+	} {
+		if got := likeEscape(test.in); got != test.want {
+			t.Errorf("likeEscape(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
@@ -37,7 +37,8 @@ type Client struct {
 	// Whether fetch should be disabled.
 	disableFetch bool
 
-	cache *cache
+	cache     *cache
+	diskCache *diskCache
 }
 
 // A VersionInfo contains metadata about a given version of a module.
@@ -82,6 +83,17 @@ func (c *Client) WithCache() *Client {
 	return &c2
 }
 
+// WithDiskCache returns a new client that caches the responses of Info and
+// Mod in files of dir, so that they survive restarts of the process.
+// Entries expire after ttl, if it is positive, and the oldest are removed
+// when the files take more than maxBytes. Only the info of resolved
+// versions is cached, since that of queries like "latest" changes.
+func (c *Client) WithDiskCache(dir string, maxBytes int64, ttl time.Duration) *Client {
+	c2 := *c
+	c2.diskCache = newDiskCache(dir, maxBytes, ttl)
+	return &c2
+}
+
 // Info makes a request to $GOPROXY/<module>/@v/<requestedVersion>.info and
 // transforms that data into a *VersionInfo.
 // If requestedVersion is internal.LatestVersion, it uses the proxy's @latest
@@ -101,14 +113,21 @@ func (c *Client) Info(ctx context.Context, modulePath, requestedVersion string)
 	if v := c.cache.getInfo(modulePath, requestedVersion); v != nil {
 		return v, nil
 	}
-	data, err := c.readBody(ctx, modulePath, requestedVersion, "info")
-	if err != nil {
-		return nil, err
+	data := c.diskCache.get(modulePath, requestedVersion, "info")
+	cached := data != nil
+	if !cached {
+		data, err = c.readBody(ctx, modulePath, requestedVersion, "info")
+		if err != nil {
+			return nil, err
+		}
 	}
 	var v VersionInfo
 	if err := json.Unmarshal(data, &v); err != nil {
 		return nil, err
 	}
+	if !cached && v.Version == requestedVersion {
+		c.diskCache.put(modulePath, requestedVersion, "info", data)
+	}
 	c.cache.putInfo(modulePath, requestedVersion, &v)
 	return &v, nil
 }
@@ -120,9 +139,13 @@ func (c *Client) Mod(ctx context.Context, modulePath, resolvedVersion string) (_
 	if b := c.cache.getMod(modulePath, resolvedVersion); b != nil {
 		return b, nil
 	}
-	b, err := c.readBody(ctx, modulePath, resolvedVersion, "mod")
-	if err != nil {
-		return nil, err
+	b := c.diskCache.get(modulePath, resolvedVersion, "mod")
+	if b == nil {
+		b, err = c.readBody(ctx, modulePath, resolvedVersion, "mod")
+		if err != nil {
+			return nil, err
+		}
+		c.diskCache.put(modulePath, resolvedVersion, "mod", b)
 	}
 	c.cache.putMod(modulePath, resolvedVersion, b)
 	return b, nil
@@ -160,12 +183,16 @@ func (c *Client) ZipSize(ctx context.Context, modulePath, resolvedVersion string
 	if err != nil {
 		return 0, err
 	}
+	start := time.Now()
 	res, err := ctxhttp.Head(ctx, c.HTTPClient, url)
 	if err != nil {
+		recordRequest(ctx, endpointZipSize, start, err)
 		return 0, fmt.Errorf("ctxhttp.Head(ctx, client, %q): %v", url, err)
 	}
 	defer res.Body.Close()
-	if err := responseError(res, false); err != nil {
+	err = responseError(res, false)
+	recordRequest(ctx, endpointZipSize, start, err)
+	if err != nil {
 		return 0, err
 	}
 	if res.ContentLength < 0 {
@@ -205,7 +232,8 @@ func (c *Client) readBody(ctx context.Context, modulePath, requestedVersion, suf
 		return nil, err
 	}
 	var data []byte
-	err = c.executeRequest(ctx, u, func(body io.Reader) error {
+	// The suffix is also the name of the endpoint.
+	err = c.executeRequest(ctx, suffix, u, func(body io.Reader) error {
 		var err error
 		data, err = io.ReadAll(body)
 		return err
@@ -233,19 +261,22 @@ func (c *Client) Versions(ctx context.Context, modulePath string) (_ []string, e
 		}
 		return scanner.Err()
 	}
-	if err := c.executeRequest(ctx, u, collect); err != nil {
+	if err := c.executeRequest(ctx, endpointList, u, collect); err != nil {
 		return nil, err
 	}
 	return versions, nil
 }
 
 // executeRequest executes an HTTP GET request for u, then calls the bodyFunc
-// on the response body, if no error occurred.
-func (c *Client) executeRequest(ctx context.Context, u string, bodyFunc func(body io.Reader) error) (err error) {
+// on the response body, if no error occurred. The request is recorded in
+// the metrics for endpoint.
+func (c *Client) executeRequest(ctx context.Context, endpoint, u string, bodyFunc func(body io.Reader) error) (err error) {
+	start := time.Now()
 	defer func() {
 		if ctx.Err() != nil {
 			err = fmt.Errorf("%v: %w", err, derrors.ProxyTimedOut)
 		}
+		recordRequest(ctx, endpoint, start, err)
 		derrors.WrapStack(&err, "executeRequest(ctx, %q)", u)
 	}()
 
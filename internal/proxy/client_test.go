@@ -386,3 +386,79 @@ func TestCache(t *testing.T) {
 		t.Errorf("got %+v first, then %+v", got, got2)
 	}
 }
+
+func TestDiskCache(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	c1, teardownProxy := proxytest.SetupTestClient(t, []*proxytest.Module{testModule})
+
+	c := c1.WithDiskCache(dir, 1<<20, time.Hour)
+	info, err := c.Info(ctx, testModulePath, testVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod, err := c.Mod(ctx, testModulePath, testVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Info(ctx, testModulePath, version.Latest); err != nil {
+		t.Fatal(err)
+	}
+	teardownProxy()
+
+	// A new client, as after a restart, doesn't need the server for
+	// cached requests.
+	c = c1.WithDiskCache(dir, 1<<20, time.Hour)
+	info2, err := c.Info(ctx, testModulePath, testVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(info, info2) {
+		t.Errorf("got %+v first, then %+v", info, info2)
+	}
+	mod2, err := c.Mod(ctx, testModulePath, testVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(mod) != string(mod2) {
+		t.Errorf("got %q first, then %q", mod, mod2)
+	}
+	// The info of "latest" is not cached.
+	if _, err := c.Info(ctx, testModulePath, version.Latest); err == nil {
+		t.Error("latest: got nil, want error")
+	}
+}
+
+func TestStats(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	client, teardownProxy := proxytest.SetupTestClient(t, []*proxytest.Module{testModule})
+	defer teardownProxy()
+
+	proxy.TakeStats() // discard stats from other tests
+	if _, err := client.Info(ctx, testModulePath, testVersion); err != nil {
+		t.Fatal(err)
+	}
+	// "Not found" is not an error.
+	if _, err := client.Info(ctx, "example.com/missing", testVersion); !errors.Is(err, derrors.NotFound) {
+		t.Fatalf("got %v, want NotFound", err)
+	}
+	if _, err := client.Mod(ctx, testModulePath, testVersion); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, s := range proxy.TakeStats() {
+		if s.MaxLatency <= 0 || s.MeanLatency() > s.MaxLatency {
+			t.Errorf("%s: bad latencies: mean %s, max %s", s.Endpoint, s.MeanLatency(), s.MaxLatency)
+		}
+		got = append(got, fmt.Sprintf("%s %d %d", s.Endpoint, s.Requests, s.Errors))
+	}
+	want := []string{"info 2 0", "mod 1 0"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+	if got := proxy.TakeStats(); len(got) != 0 {
+		t.Errorf("second TakeStats: got %v, want none", got)
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// diskCache caches proxy responses in files of a directory, so that they
+// outlive the process. The worker restarts often, and scans of the same
+// job ask for the same .info and .mod files over and over.
+//
+// Entries expire after a time to live. When the files take more than a
+// maximum size, the oldest are removed. Failures to read or write the
+// cache are treated as misses: the response is fetched from the proxy.
+type diskCache struct {
+	dir      string
+	maxBytes int64
+	ttl      time.Duration
+
+	mu   sync.Mutex
+	size int64 // total size of the files in dir, or -1 if not yet known
+}
+
+func newDiskCache(dir string, maxBytes int64, ttl time.Duration) *diskCache {
+	return &diskCache{dir: dir, maxBytes: maxBytes, ttl: ttl, size: -1}
+}
+
+// file returns the name of the file that caches the response of the
+// given suffix, like "info", for modulePath at version.
+func (c *diskCache) file(modulePath, version, suffix string) string {
+	h := sha256.Sum256([]byte(modulePath + "@" + version))
+	return filepath.Join(c.dir, hex.EncodeToString(h[:])+"."+suffix)
+}
+
+// get returns the cached response, or nil if there is none or it has
+// expired.
+func (c *diskCache) get(modulePath, version, suffix string) []byte {
+	if c == nil {
+		return nil
+	}
+	file := c.file(modulePath, version, suffix)
+	fi, err := os.Stat(file)
+	if err != nil {
+		return nil
+	}
+	if c.ttl > 0 && time.Since(fi.ModTime()) > c.ttl {
+		c.remove(file, fi.Size())
+		return nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// put caches the response data, removing the oldest entries if the cache
+// grows beyond its maximum size.
+func (c *diskCache) put(modulePath, version, suffix string, data []byte) {
+	if c == nil || int64(len(data)) > c.maxBytes {
+		return
+	}
+	if err := os.MkdirAll(c.dir, 0o755); err != nil {
+		return
+	}
+	file := c.file(modulePath, version, suffix)
+	// Write to a temporary file and rename, so that a restart in the
+	// middle of a write doesn't leave a truncated entry.
+	tmp, err := os.CreateTemp(c.dir, "tmp-*")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(data)
+	err = errors.Join(err, tmp.Close())
+	if err == nil {
+		err = os.Rename(tmp.Name(), file)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.size < 0 {
+		c.size = c.scanSize()
+	} else {
+		// Rewriting an existing entry counts it twice, until the next
+		// prune recomputes the size.
+		c.size += int64(len(data))
+	}
+	if c.size > c.maxBytes {
+		c.prune()
+	}
+}
+
+// remove removes an expired entry of the given size.
+func (c *diskCache) remove(file string, size int64) {
+	if os.Remove(file) != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.size >= 0 {
+		c.size -= size
+	}
+}
+
+// entries returns the cache files, oldest first.
+// c.mu must be held.
+func (c *diskCache) entries() []fs.FileInfo {
+	des, err := os.ReadDir(c.dir)
+	if err != nil {
+		return nil
+	}
+	var fis []fs.FileInfo
+	for _, de := range des {
+		if strings.HasPrefix(de.Name(), "tmp-") {
+			continue
+		}
+		if fi, err := de.Info(); err == nil && fi.Mode().IsRegular() {
+			fis = append(fis, fi)
+		}
+	}
+	sort.Slice(fis, func(i, j int) bool { return fis[i].ModTime().Before(fis[j].ModTime()) })
+	return fis
+}
+
+// scanSize returns the total size of the cache files.
+// c.mu must be held.
+func (c *diskCache) scanSize() int64 {
+	var size int64
+	for _, fi := range c.entries() {
+		size += fi.Size()
+	}
+	return size
+}
+
+// prune removes the oldest cache files until they take at most three
+// quarters of the maximum size, so that it is not needed on every put.
+// c.mu must be held.
+func (c *diskCache) prune() {
+	fis := c.entries()
+	var size int64
+	for _, fi := range fis {
+		size += fi.Size()
+	}
+	for _, fi := range fis {
+		if size <= c.maxBytes/4*3 {
+			break
+		}
+		if os.Remove(filepath.Join(c.dir, fi.Name())) == nil {
+			size -= fi.Size()
+		}
+	}
+	c.size = size
+}
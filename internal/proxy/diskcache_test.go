@@ -0,0 +1,58 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proxy
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDiskCachePrune(t *testing.T) {
+	c := newDiskCache(t.TempDir(), 100, time.Hour)
+	data := make([]byte, 30)
+	for i, v := range []string{"v1.0.0", "v1.1.0", "v1.2.0", "v1.3.0"} {
+		c.put("example.com/m", v, "mod", data)
+		// Give the files distinct times, oldest first.
+		mtime := time.Now().Add(time.Duration(i-10) * time.Minute)
+		if err := os.Chtimes(c.file("example.com/m", v, "mod"), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The fourth put exceeded 100 bytes, so the oldest entries were
+	// removed until at most 75 bytes remained.
+	for v, want := range map[string]bool{"v1.0.0": false, "v1.1.0": false, "v1.2.0": true, "v1.3.0": true} {
+		if got := c.get("example.com/m", v, "mod") != nil; got != want {
+			t.Errorf("%s cached: got %t, want %t", v, got, want)
+		}
+	}
+	if c.size != 60 {
+		t.Errorf("size: got %d, want 60", c.size)
+	}
+	// Entries too large for the cache are not stored.
+	c.put("example.com/big", "v1.0.0", "mod", make([]byte, 101))
+	if c.get("example.com/big", "v1.0.0", "mod") != nil {
+		t.Error("large entry was cached")
+	}
+}
+
+func TestDiskCacheTTL(t *testing.T) {
+	c := newDiskCache(t.TempDir(), 100, time.Hour)
+	c.put("example.com/m", "v1.0.0", "info", []byte("{}"))
+	if c.get("example.com/m", "v1.0.0", "info") == nil {
+		t.Fatal("entry not cached")
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	file := c.file("example.com/m", "v1.0.0", "info")
+	if err := os.Chtimes(file, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if c.get("example.com/m", "v1.0.0", "info") != nil {
+		t.Error("expired entry was returned")
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("expired entry was not removed: %v", err)
+	}
+}
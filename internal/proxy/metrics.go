@@ -0,0 +1,124 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/event"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+)
+
+// Names of proxy endpoints in metrics, besides "info", "mod" and "zip",
+// which are named by their URL suffixes.
+const (
+	endpointZipSize = "zip-size"
+	endpointList    = "list"
+)
+
+const metricNamespace = "ecosystem/proxy"
+
+var (
+	// requestLatency is the latency of proxy requests, labeled by endpoint.
+	requestLatency = event.NewDuration("proxy-request-latency", &event.MetricOptions{
+		Namespace:   metricNamespace,
+		Description: "latency of module proxy requests",
+	})
+	// requestErrors counts failed proxy requests, labeled by endpoint and
+	// kind of error.
+	requestErrors = event.NewCounter("proxy-request-errors", &event.MetricOptions{
+		Namespace:   metricNamespace,
+		Description: "failed module proxy requests",
+	})
+)
+
+// EndpointStats are statistics about the requests to a proxy endpoint.
+type EndpointStats struct {
+	Endpoint     string
+	Requests     int
+	Errors       int // failed requests, not counting "not found" responses
+	TotalLatency time.Duration
+	MaxLatency   time.Duration
+}
+
+// MeanLatency returns the mean latency of the requests.
+func (s *EndpointStats) MeanLatency() time.Duration {
+	if s.Requests == 0 {
+		return 0
+	}
+	return s.TotalLatency / time.Duration(s.Requests)
+}
+
+func (s *EndpointStats) String() string {
+	return fmt.Sprintf("%s: %d requests, %d errors, mean latency %s, max %s",
+		s.Endpoint, s.Requests, s.Errors,
+		s.MeanLatency().Round(time.Millisecond), s.MaxLatency.Round(time.Millisecond))
+}
+
+// stats accumulates EndpointStats for all clients in the process.
+var stats = struct {
+	mu sync.Mutex
+	m  map[string]*EndpointStats
+}{m: map[string]*EndpointStats{}}
+
+// TakeStats returns the statistics of the proxy requests made since the
+// previous call, sorted by endpoint.
+func TakeStats() []*EndpointStats {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	var ss []*EndpointStats
+	for _, s := range stats.m {
+		ss = append(ss, s)
+	}
+	stats.m = map[string]*EndpointStats{}
+	sort.Slice(ss, func(i, j int) bool { return ss[i].Endpoint < ss[j].Endpoint })
+	return ss
+}
+
+// recordRequest records a request to the endpoint that began at start
+// and returned err.
+func recordRequest(ctx context.Context, endpoint string, start time.Time, err error) {
+	latency := time.Since(start)
+	ep := event.String("endpoint", endpoint)
+	requestLatency.Record(ctx, latency, ep)
+	kind := errorKind(err)
+	if kind != "" {
+		requestErrors.Record(ctx, 1, ep, event.String("error", kind))
+	}
+
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	s := stats.m[endpoint]
+	if s == nil {
+		s = &EndpointStats{Endpoint: endpoint}
+		stats.m[endpoint] = s
+	}
+	s.Requests++
+	s.TotalLatency += latency
+	s.MaxLatency = max(s.MaxLatency, latency)
+	if kind != "" {
+		s.Errors++
+	}
+}
+
+// errorKind classifies err for metrics. It returns the empty string
+// for success, and for "not found" responses, which are normal answers.
+func errorKind(err error) string {
+	switch {
+	case err == nil, errors.Is(err, derrors.NotFound), errors.Is(err, derrors.NotFetched):
+		return ""
+	case errors.Is(err, derrors.ProxyTimedOut):
+		return "timeout"
+	case errors.Is(err, derrors.ProxyError):
+		return "server"
+	default:
+		return "other"
+	}
+}
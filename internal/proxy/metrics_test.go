@@ -0,0 +1,31 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+)
+
+func TestErrorKind(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		want string
+	}{
+		{nil, ""},
+		{fmt.Errorf("x: %w", derrors.NotFound), ""},
+		{derrors.NotFetched, ""},
+		{fmt.Errorf("x: %w", derrors.ProxyTimedOut), "timeout"},
+		{derrors.ProxyError, "server"},
+		{errors.New("unexpected status 429"), "other"},
+	} {
+		if got := errorKind(test.err); got != test.want {
+			t.Errorf("errorKind(%v) = %q, want %q", test.err, got, test.want)
+		}
+	}
+}
@@ -13,7 +13,6 @@ import (
 	"sync"
 	"time"
 
-	"golang.org/x/mod/semver"
 	"golang.org/x/pkgsite-metrics/internal/proxy"
 	"golang.org/x/pkgsite-metrics/internal/testing/testhelper"
 	"golang.org/x/pkgsite-metrics/internal/version"
@@ -39,6 +38,11 @@ func NewServer(modules []*Module) *Server {
 	return s
 }
 
+// ServeHTTP serves the proxy protocol for the server's modules.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
 // handleInfo creates an info endpoint for the specified module version.
 func (s *Server) handleInfo(modulePath, resolvedVersion string, uncached bool) {
 	urlPath := fmt.Sprintf("/%s/@v/%s.info", modulePath, resolvedVersion)
@@ -157,7 +161,7 @@ func (s *Server) addModule(m *Module, hasVersions bool) {
 	s.modules[m.ModulePath] = append(s.modules[m.ModulePath], m)
 	sort.Slice(s.modules[m.ModulePath], func(i, j int) bool {
 		// Return the modules in order of decreasing semver.
-		return semver.Compare(s.modules[m.ModulePath][i].Version, s.modules[m.ModulePath][j].Version) < 0
+		return version.Compare(s.modules[m.ModulePath][i].Version, s.modules[m.ModulePath][j].Version) < 0
 	})
 }
 
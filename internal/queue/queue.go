@@ -9,28 +9,38 @@ package queue
 import (
 	"context"
 	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net/url"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	cloudtasks "cloud.google.com/go/cloudtasks/apiv2"
 	taskspb "cloud.google.com/go/cloudtasks/apiv2/cloudtaskspb"
+	cloudtasksbeta "cloud.google.com/go/cloudtasks/apiv2beta3"
+	taskspbbeta "cloud.google.com/go/cloudtasks/apiv2beta3/cloudtaskspb"
 	"golang.org/x/pkgsite-metrics/internal/config"
 	"golang.org/x/pkgsite-metrics/internal/derrors"
 	"golang.org/x/pkgsite-metrics/internal/log"
+	"google.golang.org/api/iterator"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
 )
 
 // A Task can produce information needed for Cloud Tasks.
 type Task interface {
 	Name() string   // Human-readable string for the task. Need not be unique.
 	Path() string   // URL path
-	Params() string // URL query params
+	Params() string // params, in URL query form; they are sent in the task's payload
 }
 
 // A Queue provides an interface for asynchronous scheduling of fetch actions.
@@ -38,13 +48,54 @@ type Queue interface {
 	// EnqueueScan enqueues a scan request.
 	// It reports whether a new task was actually added.
 	EnqueueScan(context.Context, Task, *Options) (bool, error)
+	// DeleteJobTasks deletes the tasks of the given job that have not
+	// yet started running. Only tasks enqueued with Options.Cancelable
+	// are sure to be deleted. It returns the number of tasks deleted.
+	DeleteJobTasks(ctx context.Context, jobID string) (int, error)
+	// Stats returns the statistics of each of the queue's queues.
+	Stats(ctx context.Context) ([]*Stats, error)
+}
+
+// Stats describes the state of a queue.
+type Stats struct {
+	Name       string   // full name of the queue
+	Priorities []string // priorities whose tasks go on the queue
+	Tasks      int64    // tasks in the queue, including those running
+	Running    int64    // tasks being dispatched to the worker
+	// OldestTask is the estimated arrival time of the oldest task, or
+	// zero if the queue is empty or it is not known.
+	OldestTask time.Time `json:",omitempty"`
+	// ExecutedLastMinute is the number of tasks that the queue attempted
+	// in the last minute, successfully or not.
+	ExecutedLastMinute int64
+	// DispatchRate is the maximum rate at which the queue dispatches
+	// tasks, in tasks per second.
+	DispatchRate float64
+	// AttemptedTasks and FailingTasks are the numbers of tasks at the
+	// front of the queue, up to statsSampleSize of them, that have been
+	// attempted, and whose last attempt failed.
+	AttemptedTasks int
+	FailingTasks   int
+}
+
+// ErrorRate returns the fraction of the attempted tasks whose last attempt
+// failed, or 0 if no tasks were attempted.
+func (s *Stats) ErrorRate() float64 {
+	if s.AttemptedTasks == 0 {
+		return 0
+	}
+	return float64(s.FailingTasks) / float64(s.AttemptedTasks)
 }
 
 // New creates a new Queue with name queueName based on the configuration
 // in cfg. When running locally, Queue uses numWorkers concurrent workers.
 func New(ctx context.Context, cfg *config.Config, processFunc inMemoryProcessFunc) (Queue, error) {
 	if !config.OnCloudRun() {
-		return NewInMemory(ctx, cfg.LocalQueueWorkers, processFunc), nil
+		retry := DefaultRetryPolicy
+		if cfg.LocalQueueMaxAttempts > 0 {
+			retry.MaxAttempts = cfg.LocalQueueMaxAttempts
+		}
+		return NewInMemory(ctx, cfg.LocalQueueWorkers, retry, processFunc), nil
 	}
 	client, err := cloudtasks.NewClient(ctx)
 	if err != nil {
@@ -54,28 +105,95 @@ func New(ctx context.Context, cfg *config.Config, processFunc inMemoryProcessFun
 	if err != nil {
 		return nil, err
 	}
-	log.Infof(ctx, "enqueuing at %s with queueURL=%q", g.queueName, g.queueURL)
+	// Only the beta API reports queue statistics.
+	g.statsClient, err = cloudtasksbeta.NewClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof(ctx, "enqueuing at %v with queueURL=%q", g.queues, g.queueURL)
 	return g, nil
 }
 
+// Task priorities. Tasks of each priority are put on their own Cloud Tasks
+// queue, and are marked with PriorityHeader so the worker can tell them apart.
+const (
+	PriorityHigh   = "high"
+	PriorityNormal = "normal"
+	PriorityLow    = "low"
+)
+
+// PriorityHeader is the HTTP header that holds the priority of a task,
+// if it is not PriorityNormal.
+const PriorityHeader = "X-Ecosystem-Priority"
+
+// CheckPriority returns an error if p is not a valid priority.
+// The empty string is valid, and means PriorityNormal.
+func CheckPriority(p string) error {
+	switch p {
+	case "", PriorityHigh, PriorityNormal, PriorityLow:
+		return nil
+	default:
+		return fmt.Errorf("invalid priority %q: want %s, %s or %s", p, PriorityHigh, PriorityNormal, PriorityLow)
+	}
+}
+
+// normalizePriority returns p, or PriorityNormal if p is empty.
+func normalizePriority(p string) string {
+	if p == "" {
+		return PriorityNormal
+	}
+	return p
+}
+
 // GCP provides a Queue implementation backed by the Google Cloud Tasks API.
 type GCP struct {
-	client    *cloudtasks.Client
-	queueName string // full GCP name of the queue
-	queueURL  string // non-AppEngine URL to post tasks to
+	client      *cloudtasks.Client
+	statsClient *cloudtasksbeta.Client
+	queues      map[string][]*gcpQueue // priority to the queues for its tasks
+	queueURL    string                 // non-AppEngine URL to post tasks to
 	// token holds information that lets the task queue construct an authorized request to the worker.
 	// Since the worker sits behind the IAP, the queue needs an identity token that includes the
 	// identity of a service account that has access, and the client ID for the IAP.
 	// We use the service account of the current process.
 	token *taskspb.HttpRequest_OidcToken
+	now   func() time.Time // for testing
+}
+
+// A gcpQueue is one of the Cloud Tasks queues of a GCP.
+type gcpQueue struct {
+	name   string // full GCP name of the queue
+	weight int    // relative share of tasks
+
+	mu             sync.Mutex
+	unhealthyUntil time.Time // tasks avoid the queue until then
+}
+
+// unhealthyPeriod is how long tasks avoid a queue after it fails to
+// accept one because it is unavailable or out of capacity.
+const unhealthyPeriod = 5 * time.Minute
+
+func (q *gcpQueue) healthy(now time.Time) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return !now.Before(q.unhealthyUntil)
 }
 
+func (q *gcpQueue) markUnhealthy(now time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.unhealthyUntil = now.Add(unhealthyPeriod)
+}
+
+func (q *gcpQueue) String() string { return q.name }
+
 // newGCP returns a new Queue that can be used to enqueue tasks using the
 // cloud tasks API.  The given queueID should be the name of the queue in the
-// cloud tasks console.
+// cloud tasks console. It is used for tasks of all priorities that do
+// not have their own queue in cfg. If cfg.Queues is non-empty, they are
+// used instead of queueID.
 func newGCP(cfg *config.Config, client *cloudtasks.Client, queueID string) (_ *GCP, err error) {
 	defer derrors.Wrap(&err, "newGCP(cfg, client, %q)", queueID)
-	if queueID == "" {
+	if queueID == "" && len(cfg.Queues) == 0 {
 		return nil, errors.New("empty queueID")
 	}
 	if cfg.ProjectID == "" {
@@ -90,15 +208,37 @@ func newGCP(cfg *config.Config, client *cloudtasks.Client, queueID string) (_ *G
 	if cfg.ServiceAccount == "" {
 		return nil, errors.New("empty ServiceAccount")
 	}
+	fullName := func(location, id string) string {
+		return fmt.Sprintf("projects/%s/locations/%s/queues/%s", cfg.ProjectID, location, id)
+	}
+	normal := []*gcpQueue{{name: fullName(cfg.LocationID, queueID), weight: 1}}
+	if len(cfg.Queues) > 0 {
+		normal = nil
+		for _, qc := range cfg.Queues {
+			normal = append(normal, &gcpQueue{name: fullName(qc.Location, qc.Name), weight: max(qc.Weight, 1)})
+		}
+	}
+	queues := map[string][]*gcpQueue{PriorityNormal: normal}
+	for p, id := range map[string]string{
+		PriorityHigh: cfg.HighPriorityQueueName,
+		PriorityLow:  cfg.LowPriorityQueueName,
+	} {
+		if id == "" {
+			queues[p] = normal
+		} else {
+			queues[p] = []*gcpQueue{{name: fullName(cfg.LocationID, id), weight: 1}}
+		}
+	}
 	return &GCP{
-		client:    client,
-		queueName: fmt.Sprintf("projects/%s/locations/%s/queues/%s", cfg.ProjectID, cfg.LocationID, queueID),
-		queueURL:  cfg.QueueURL,
+		client:   client,
+		queues:   queues,
+		queueURL: cfg.QueueURL,
 		token: &taskspb.HttpRequest_OidcToken{
 			OidcToken: &taskspb.OidcToken{
 				ServiceAccountEmail: cfg.ServiceAccount,
 			},
 		},
+		now: time.Now,
 	}, nil
 }
 
@@ -106,6 +246,8 @@ func newGCP(cfg *config.Config, client *cloudtasks.Client, queueID string) (_ *G
 // It returns an error if there was an error hashing the task name, or
 // an error pushing the task to GCP.
 // If the task was a duplicate, it returns (false, nil).
+// If a queue is unavailable or out of capacity, the task goes on the next
+// of the priority's queues, and the queue is avoided for a while.
 func (q *GCP) EnqueueScan(ctx context.Context, task Task, opts *Options) (enqueued bool, err error) {
 	defer derrors.WrapStack(&err, "queue.EnqueueScan(%s, %s, %v)", task.Path(), task.Params(), opts)
 	if opts == nil {
@@ -118,21 +260,192 @@ func (q *GCP) EnqueueScan(ctx context.Context, task Task, opts *Options) (enqueu
 	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
-	req, err := q.newTaskRequest(task, opts)
-	if err != nil {
-		return false, fmt.Errorf("newTaskRequest: %v", err)
+	if err := CheckPriority(opts.Priority); err != nil {
+		return false, err
 	}
-
-	enqueued = true
-	if _, err := q.client.CreateTask(ctx, req); err != nil {
+	var req *taskspb.CreateTaskRequest
+	for _, gq := range q.queuesFor(normalizePriority(opts.Priority), taskID(task, opts)) {
+		req, err = q.newTaskRequest(task, opts, gq.name)
+		if err != nil {
+			return false, fmt.Errorf("newTaskRequest: %v", err)
+		}
+		_, err = q.client.CreateTask(ctx, req)
+		if c := status.Code(err); c != codes.Unavailable && c != codes.ResourceExhausted {
+			break
+		}
+		log.Warnf(ctx, "queue %s did not accept task: %v", gq.name, err)
+		gq.markUnhealthy(q.now())
+	}
+	if err != nil {
 		if status.Code(err) == codes.AlreadyExists {
 			log.Debugf(ctx, "ignoring duplicate task ID %s", req.Task.Name)
-			enqueued = false
-		} else {
-			return false, fmt.Errorf("q.client.CreateTask(ctx, req): %v", err)
+			return false, nil
+		}
+		return false, fmt.Errorf("q.client.CreateTask(ctx, req): %v", err)
+	}
+	return true, nil
+}
+
+// queuesFor returns the queues for a task of the given priority and ID,
+// in the order to try them. The first is chosen by the task ID in
+// proportion to the queues' weights, so that a task enqueued again goes on
+// the same queue and is de-duplicated there. The others follow it in
+// configuration order, with unhealthy queues last.
+func (q *GCP) queuesFor(priority, id string) []*gcpQueue {
+	queues := q.queues[priority]
+	if len(queues) == 1 {
+		return queues
+	}
+	total := 0
+	for _, gq := range queues {
+		total += gq.weight
+	}
+	sum := sha256.Sum256([]byte(id))
+	r := int(binary.BigEndian.Uint64(sum[:8]) % uint64(total))
+	first := 0
+	for i, gq := range queues {
+		if r < gq.weight {
+			first = i
+			break
 		}
+		r -= gq.weight
 	}
-	return enqueued, nil
+	ordered := append(append([]*gcpQueue(nil), queues[first:]...), queues[:first]...)
+	now := q.now()
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].healthy(now) && !ordered[j].healthy(now)
+	})
+	return ordered
+}
+
+// distinctQueues returns the names of the queues, each once, since
+// priorities may share queues, and the priorities of each. Queues are in
+// order of their first priority, from high to low.
+func (q *GCP) distinctQueues() (names []string, priorities map[string][]string) {
+	priorities = map[string][]string{}
+	for _, p := range []string{PriorityHigh, PriorityNormal, PriorityLow} {
+		for _, gq := range q.queues[p] {
+			if priorities[gq.name] == nil {
+				names = append(names, gq.name)
+			}
+			priorities[gq.name] = append(priorities[gq.name], p)
+		}
+	}
+	return names, priorities
+}
+
+// DeleteJobTasks deletes the job's tasks that were enqueued with
+// Options.Cancelable from the Cloud Tasks queues.
+// Tasks that are running are not affected. Since Cloud Tasks cannot filter
+// tasks by name, it lists all the tasks in the queues.
+func (q *GCP) DeleteJobTasks(ctx context.Context, jobID string) (n int, err error) {
+	defer derrors.Wrap(&err, "queue.DeleteJobTasks(%q)", jobID)
+	if jobID == "" {
+		return 0, errors.New("empty job ID")
+	}
+	names, _ := q.distinctQueues()
+	for _, name := range names {
+		m, err := q.deleteJobTasks(ctx, name, jobID)
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// deleteJobTasks deletes the job's tasks from the named queue.
+func (q *GCP) deleteJobTasks(ctx context.Context, queueName, jobID string) (n int, err error) {
+	suffix := jobTaskIDSuffix(jobID)
+	it := q.client.ListTasks(ctx, &taskspb.ListTasksRequest{Parent: queueName})
+	for {
+		t, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return n, err
+		}
+		if !strings.HasSuffix(t.Name, suffix) {
+			continue
+		}
+		if err := q.client.DeleteTask(ctx, &taskspb.DeleteTaskRequest{Name: t.Name}); err != nil {
+			// The task may have run, or been deleted by a concurrent
+			// cancellation, since it was listed.
+			if status.Code(err) == codes.NotFound {
+				continue
+			}
+			return n, err
+		}
+		n++
+	}
+	log.Infof(ctx, "deleted %d tasks of job %s from %s", n, jobID, queueName)
+	return n, nil
+}
+
+// statsSampleSize is the number of tasks at the front of a queue that
+// Stats examines for failed attempts.
+const statsSampleSize = 1000
+
+// Stats returns the statistics of the Cloud Tasks queues. Cloud Tasks
+// reports no error rate, so the tasks at the front of each queue are
+// sampled for failed attempts.
+func (q *GCP) Stats(ctx context.Context) (_ []*Stats, err error) {
+	defer derrors.Wrap(&err, "queue.Stats")
+	if q.statsClient == nil {
+		return nil, errors.New("no client for queue statistics")
+	}
+	names, priorities := q.distinctQueues()
+	var stats []*Stats
+	for _, name := range names {
+		s, err := q.queueStats(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		s.Priorities = priorities[name]
+		stats = append(stats, s)
+	}
+	return stats, nil
+}
+
+// queueStats returns the statistics of the named queue.
+func (q *GCP) queueStats(ctx context.Context, name string) (*Stats, error) {
+	// The statistics are returned only if they are in the mask.
+	qpb, err := q.statsClient.GetQueue(ctx, &taskspbbeta.GetQueueRequest{
+		Name:     name,
+		ReadMask: &fieldmaskpb.FieldMask{Paths: []string{"name", "stats"}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	s := &Stats{Name: name}
+	if st := qpb.GetStats(); st != nil {
+		s.Tasks = st.TasksCount
+		s.Running = st.ConcurrentDispatchesCount
+		s.ExecutedLastMinute = st.ExecutedLastMinuteCount
+		s.DispatchRate = st.EffectiveExecutionRate
+		if t := st.OldestEstimatedArrivalTime; t != nil {
+			s.OldestTask = t.AsTime()
+		}
+	}
+	it := q.client.ListTasks(ctx, &taskspb.ListTasksRequest{Parent: name, PageSize: statsSampleSize})
+	for i := 0; i < statsSampleSize; i++ {
+		t, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if t.DispatchCount == 0 {
+			continue
+		}
+		s.AttemptedTasks++
+		if a := t.LastAttempt; a != nil && a.ResponseStatus != nil && codes.Code(a.ResponseStatus.Code) != codes.OK {
+			s.FailingTasks++
+		}
+	}
+	return s, nil
 }
 
 // Options is used to provide option arguments for a task queue.
@@ -146,53 +459,108 @@ type Options struct {
 	// TaskNameSuffix is appended to the task name to force reprocessing of
 	// tasks that would normally be de-duplicated.
 	TaskNameSuffix string
+
+	// JobID is the ID of the job the task belongs to, if any.
+	JobID string
+
+	// Cancelable reports whether DeleteJobTasks can delete the task.
+	// If so, JobID is encoded in the task name, so the task is not
+	// de-duplicated with the same task of another job.
+	Cancelable bool
+
+	// Priority is the priority of the task: PriorityHigh, PriorityNormal
+	// or PriorityLow. If empty, it is PriorityNormal.
+	Priority string
 }
 
 // maxCloudTasksTimeout is the maximum timeout for HTTP tasks.
 // See https://cloud.google.com/tasks/docs/creating-http-target-tasks.
 const maxCloudTasksTimeout = 30 * time.Minute
 
-const disableProxyFetchParam = "proxyfetch=off"
+const disableProxyFetchParam = "proxyfetch"
+
+// PayloadContentType is the content type of task payloads.
+const PayloadContentType = "application/json"
 
-func (q *GCP) newTaskRequest(task Task, opts *Options) (*taskspb.CreateTaskRequest, error) {
+// newTaskRequest returns the request to create task on the named queue.
+func (q *GCP) newTaskRequest(task Task, opts *Options, queueName string) (*taskspb.CreateTaskRequest, error) {
 	if opts.Namespace == "" {
 		return nil, errors.New("Options.Namespace cannot be empty")
 	}
-	relativeURI := fmt.Sprintf("/%s/scan/%s", opts.Namespace, task.Path())
-	params := task.Params()
-	if opts.DisableProxyFetch {
-		if params == "" {
-			params = disableProxyFetchParam
-		} else {
-			params += "&" + disableProxyFetchParam
-		}
+	if err := CheckPriority(opts.Priority); err != nil {
+		return nil, err
 	}
-	if params != "" {
-		relativeURI += "?" + params
+	priority := normalizePriority(opts.Priority)
+	payload, err := taskPayload(task, opts)
+	if err != nil {
+		return nil, err
+	}
+	httpReq := &taskspb.HttpRequest{
+		HttpMethod:          taskspb.HttpMethod_POST,
+		Url:                 q.queueURL + relativeURI(task, opts),
+		Headers:             map[string]string{"Content-Type": PayloadContentType},
+		Body:                payload,
+		AuthorizationHeader: q.token,
+	}
+	if priority != PriorityNormal {
+		httpReq.Headers[PriorityHeader] = priority
 	}
-
-	taskID := newTaskID(opts.Namespace, task)
 	taskpb := &taskspb.Task{
-		Name:             fmt.Sprintf("%s/tasks/%s", q.queueName, taskID),
+		Name:             fmt.Sprintf("%s/tasks/%s", queueName, taskID(task, opts)),
 		DispatchDeadline: durationpb.New(maxCloudTasksTimeout),
-		MessageType: &taskspb.Task_HttpRequest{
-			HttpRequest: &taskspb.HttpRequest{
-				HttpMethod:          taskspb.HttpMethod_POST,
-				Url:                 q.queueURL + relativeURI,
-				AuthorizationHeader: q.token,
-			},
-		},
+		MessageType:      &taskspb.Task_HttpRequest{HttpRequest: httpReq},
 	}
 	req := &taskspb.CreateTaskRequest{
-		Parent: q.queueName,
+		Parent: queueName,
 		Task:   taskpb,
 	}
+	return req, nil
+}
+
+// relativeURI returns the URI path that the task is sent to.
+func relativeURI(task Task, opts *Options) string {
+	return fmt.Sprintf("/%s/scan/%s", opts.Namespace, task.Path())
+}
+
+// taskPayload returns the body of the request that the task is sent with:
+// a JSON object of the task's params. Values are strings, formatted as in
+// URL queries, so that the worker parses params from payloads and queries
+// the same way. Unlike URL queries, payloads are not limited in length.
+func taskPayload(task Task, opts *Options) ([]byte, error) {
+	values, err := url.ParseQuery(task.Params())
+	if err != nil {
+		return nil, fmt.Errorf("task %s: bad params: %v", task.Name(), err)
+	}
+	payload := map[string]string{}
+	for name, vs := range values {
+		if len(vs) > 0 {
+			payload[name] = vs[0] // as r.FormValue does
+		}
+	}
+	if opts.DisableProxyFetch {
+		payload[disableProxyFetchParam] = "off"
+	}
+	return json.Marshal(payload)
+}
+
+// taskID returns the ID of the task, used for de-duplication.
+func taskID(task Task, opts *Options) string {
+	id := newTaskID(opts.Namespace, task)
 	// If suffix is non-empty, append it to the task name.
 	// This lets us force reprocessing of tasks that would normally be de-duplicated.
 	if opts.TaskNameSuffix != "" {
-		req.Task.Name += "-" + opts.TaskNameSuffix
+		id += "-" + opts.TaskNameSuffix
 	}
-	return req, nil
+	if opts.Cancelable && opts.JobID != "" {
+		id += jobTaskIDSuffix(opts.JobID)
+	}
+	return id
+}
+
+// jobTaskIDSuffix returns the suffix of the IDs of tasks that belong
+// to the job.
+func jobTaskIDSuffix(jobID string) string {
+	return "-job-" + escapeTaskID(jobID)
 }
 
 // newTaskID creates a task ID for the given task.
@@ -234,71 +602,312 @@ func escapeTaskID(s string) string {
 }
 
 // InMemory is a Queue implementation that schedules in-process fetch
-// operations. Unlike the GCP task queue, it will not automatically retry tasks
-// on failure.
+// operations. Like the GCP task queue, it de-duplicates tasks by ID and
+// retries failed tasks according to its RetryPolicy.
 //
 // This should only be used for local development.
 type InMemory struct {
-	queue chan Task
+	queue chan inMemoryTask
 	done  chan struct{}
+	ctx   context.Context // canceled on shutdown
+	retry RetryPolicy
+
+	running atomic.Int64 // tasks being processed
+
+	mu       sync.Mutex
+	closed   bool                 // no more tasks can be enqueued
+	seen     map[string]time.Time // task ID to time enqueued, for de-duplication
+	prunedAt time.Time            // when expired IDs were last deleted from seen
+	pending  map[string]int       // job ID to number of tasks not yet started
+	canceled map[string]bool      // job IDs whose tasks were deleted
+	now      func() time.Time     // for testing
+}
+
+// inMemoryProcessFunc processes a task. The relative URI and payload are
+// the path and body of the request that Cloud Tasks would send the task
+// with.
+type inMemoryProcessFunc func(ctx context.Context, t Task, relativeURI string, payload []byte) (int, error)
+
+type inMemoryTask struct {
+	task        Task
+	relativeURI string
+	payload     []byte
+	jobID       string
+	priority    string
+}
+
+type priorityKey struct{}
+
+// PriorityFromContext returns the priority of the InMemory task being
+// processed with ctx, or the empty string if there is none.
+// It lets a processFunc send the priority along with the task, as
+// Cloud Tasks does with PriorityHeader.
+func PriorityFromContext(ctx context.Context) string {
+	p, _ := ctx.Value(priorityKey{}).(string)
+	return p
+}
+
+// RetryPolicy describes how the InMemory queue retries failed tasks.
+// A task fails if processing it returns an error or a non-2xx status code.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of times a task is run.
+	// Values less than 1 mean 1.
+	MaxAttempts int
+	// MinBackoff is the wait before the first retry. Subsequent waits
+	// double, up to MaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
 }
 
-type inMemoryProcessFunc func(context.Context, Task) (int, error)
+// DefaultRetryPolicy is the RetryPolicy used by New.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	MinBackoff:  time.Second,
+	MaxBackoff:  time.Minute,
+}
 
-// NewInMemory creates a new InMemory that asynchronously fetches
-// from proxyClient and stores in db. It uses workerCount parallelism to
-// execute these fetches.
-func NewInMemory(ctx context.Context, workerCount int, processFunc inMemoryProcessFunc) *InMemory {
+// backoff returns the wait before the given retry (1 for the first retry).
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	d := p.MinBackoff
+	for i := 1; i < retry && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d
+}
+
+// dedupWindow is how long a task ID is remembered for de-duplication.
+// Cloud Tasks remembers IDs for a few hours.
+const dedupWindow = 4 * time.Hour
+
+// seenPruneInterval is how often the task IDs that have been remembered for
+// longer than dedupWindow are forgotten.
+const seenPruneInterval = time.Minute
+
+// NewInMemory creates a new InMemory that asynchronously processes tasks
+// with processFunc. It uses workerCount parallelism to execute them.
+// When ctx is done, the queue stops accepting and running tasks.
+func NewInMemory(ctx context.Context, workerCount int, retry RetryPolicy, processFunc inMemoryProcessFunc) *InMemory {
 	q := &InMemory{
-		queue: make(chan Task, 1000),
-		done:  make(chan struct{}),
+		queue:    make(chan inMemoryTask, 1000),
+		done:     make(chan struct{}),
+		ctx:      ctx,
+		retry:    retry,
+		seen:     map[string]time.Time{},
+		pending:  map[string]int{},
+		canceled: map[string]bool{},
+		now:      time.Now,
 	}
 	sem := make(chan struct{}, workerCount)
 	go func() {
-		for v := range q.queue {
+		defer close(q.done)
+		defer func() {
+			// Wait for running tasks to finish. Their contexts are derived
+			// from ctx, so they will stop promptly on shutdown.
+			for i := 0; i < cap(sem); i++ {
+				sem <- struct{}{}
+			}
+		}()
+		for {
+			var t inMemoryTask
 			select {
 			case <-ctx.Done():
+				log.Infof(ctx, "InMemory queue shutting down: %v", ctx.Err())
+				q.close()
 				return
-			case sem <- struct{}{}:
-			}
-
-			// If a worker is available, make a request to the fetch service inside a
-			// goroutine and wait for it to finish.
-			go func(t Task) {
-				defer func() { <-sem }()
-
-				log.Infof(ctx, "Fetch requested: %v (workerCount = %d)", t, cap(sem))
-
-				fetchCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
-				defer cancel()
-
-				if _, err := processFunc(fetchCtx, t); err != nil {
-					log.Errorf(fetchCtx, err, "processFunc(%v)", t)
+			case v, ok := <-q.queue:
+				if !ok {
+					return
 				}
-			}(v)
-		}
-		for i := 0; i < cap(sem); i++ {
+				t = v
+			}
 			select {
 			case <-ctx.Done():
-				panic(fmt.Sprintf("InMemory queue context done: %v", ctx.Err()))
+				continue // handled at the top of the loop
 			case sem <- struct{}{}:
 			}
+			if !q.start(t) {
+				log.Infof(ctx, "job %s canceled; dropping %s", t.jobID, t.relativeURI)
+				<-sem
+				continue
+			}
+
+			// If a worker is available, process the task inside a goroutine.
+			q.running.Add(1)
+			go func(t inMemoryTask) {
+				defer func() { <-sem }()
+				defer q.running.Add(-1)
+				log.Infof(ctx, "Fetch requested: %s (workerCount = %d)", t.relativeURI, cap(sem))
+				q.process(ctx, t, processFunc)
+			}(t)
 		}
-		close(q.done)
 	}()
 	return q
 }
 
+// process runs processFunc on t, retrying according to the queue's RetryPolicy.
+func (q *InMemory) process(ctx context.Context, t inMemoryTask, processFunc inMemoryProcessFunc) {
+	maxAttempts := max(q.retry.MaxAttempts, 1)
+	for attempt := 1; ; attempt++ {
+		fetchCtx, cancel := context.WithTimeout(context.WithValue(ctx, priorityKey{}, t.priority), 5*time.Minute)
+		code, err := processFunc(fetchCtx, t.task, t.relativeURI, t.payload)
+		cancel()
+		if err == nil && (code == 0 || code >= 200 && code < 300) {
+			return
+		}
+		if err == nil {
+			err = fmt.Errorf("status code %d", code)
+		}
+		if attempt >= maxAttempts {
+			log.Errorf(ctx, err, "processFunc(%s): giving up after %d attempts", t.relativeURI, attempt)
+			return
+		}
+		d := q.retry.backoff(attempt)
+		log.Warnf(ctx, "processFunc(%s): attempt %d failed: %v; retrying in %s", t.relativeURI, attempt, err, d)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(d):
+		}
+	}
+}
+
+// start records that t is about to run, and reports whether it should run:
+// tasks of canceled jobs are dropped.
+func (q *InMemory) start(t inMemoryTask) bool {
+	if t.jobID == "" {
+		return true
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.canceled[t.jobID] {
+		return false
+	}
+	q.pending[t.jobID]--
+	return true
+}
+
+// DeleteJobTasks drops the job's tasks that have not yet started.
+// They are dropped when they reach the front of the queue, and no
+// further tasks are accepted for the job.
+func (q *InMemory) DeleteJobTasks(ctx context.Context, jobID string) (int, error) {
+	if jobID == "" {
+		return 0, errors.New("InMemory.DeleteJobTasks: empty job ID")
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := q.pending[jobID]
+	delete(q.pending, jobID)
+	q.canceled[jobID] = true
+	log.Infof(ctx, "deleted %d tasks of job %s", n, jobID)
+	return n, nil
+}
+
+// Depth returns the number of tasks waiting in the queue. It does not
+// count the task, if any, that is waiting for a worker to run it.
+func (q *InMemory) Depth() int {
+	return len(q.queue)
+}
+
+// Stats returns the statistics of the queue. Only the numbers of tasks
+// are known.
+func (q *InMemory) Stats(ctx context.Context) ([]*Stats, error) {
+	running := q.running.Load()
+	return []*Stats{{
+		Name:       "in-memory",
+		Priorities: []string{PriorityHigh, PriorityNormal, PriorityLow},
+		Tasks:      int64(q.Depth()) + running,
+		Running:    running,
+	}}, nil
+}
+
+// close marks the queue as closed to new tasks, and reports whether
+// it was already closed.
+func (q *InMemory) close() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	wasClosed := q.closed
+	q.closed = true
+	return wasClosed
+}
+
 // EnqueueScan pushes a scan task into the local queue to be processed
-// asynchronously.
-func (q *InMemory) EnqueueScan(ctx context.Context, task Task, _ *Options) (bool, error) {
-	q.queue <- task
+// asynchronously. As with Cloud Tasks, a task whose ID matches that of a
+// recently enqueued task is not added, and EnqueueScan returns (false, nil).
+func (q *InMemory) EnqueueScan(ctx context.Context, task Task, opts *Options) (enqueued bool, err error) {
+	defer derrors.Wrap(&err, "InMemory.EnqueueScan(%s, %s, %v)", task.Path(), task.Params(), opts)
+	if opts == nil {
+		opts = &Options{}
+	}
+	if opts.Namespace == "" {
+		return false, errors.New("Options.Namespace cannot be empty")
+	}
+	if err := CheckPriority(opts.Priority); err != nil {
+		return false, err
+	}
+	id := taskID(task, opts)
+	payload, err := taskPayload(task, opts)
+	if err != nil {
+		return false, err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return false, errors.New("queue is shut down")
+	}
+	if q.canceled[opts.JobID] {
+		return false, fmt.Errorf("job %s was canceled", opts.JobID)
+	}
+	now := q.now()
+	q.pruneSeen(now)
+	if t, ok := q.seen[id]; ok && now.Sub(t) < dedupWindow {
+		log.Debugf(ctx, "ignoring duplicate task ID %s", id)
+		return false, nil
+	}
+	// Holding the lock while sending prevents a concurrent close of q.queue.
+	// The send can block only when the buffer is full.
+	uri := relativeURI(task, opts)
+	select {
+	case q.queue <- inMemoryTask{task, uri, payload, opts.JobID, normalizePriority(opts.Priority)}:
+	case <-ctx.Done():
+		return false, ctx.Err()
+	case <-q.ctx.Done():
+		return false, errors.New("queue is shut down")
+	}
+	q.seen[id] = now
+	if opts.JobID != "" {
+		q.pending[opts.JobID]++
+	}
+	log.Debugf(ctx, "enqueued %s", uri)
 	return true, nil
 }
 
+// pruneSeen deletes the task IDs enqueued more than dedupWindow before now,
+// so that q.seen does not grow without bound. To keep enqueuing cheap, it
+// does so at most once every seenPruneInterval. q.mu must be held.
+func (q *InMemory) pruneSeen(now time.Time) {
+	if now.Sub(q.prunedAt) < seenPruneInterval {
+		return
+	}
+	q.prunedAt = now
+	for id, t := range q.seen {
+		if now.Sub(t) >= dedupWindow {
+			delete(q.seen, id)
+		}
+	}
+}
+
 // WaitForTesting waits for all queued requests to finish. It should only be
 // used by test code.
 func (q *InMemory) WaitForTesting(ctx context.Context) {
-	close(q.queue)
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.queue)
+	}
+	q.mu.Unlock()
 	<-q.done
 }
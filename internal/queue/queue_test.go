@@ -5,7 +5,13 @@
 package queue
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	taskspb "cloud.google.com/go/cloudtasks/apiv2/cloudtaskspb"
 	"github.com/google/go-cmp/cmp"
@@ -60,7 +66,9 @@ func TestNewTaskRequest(t *testing.T) {
 			MessageType: &taskspb.Task_HttpRequest{
 				HttpRequest: &taskspb.HttpRequest{
 					HttpMethod: taskspb.HttpMethod_POST,
-					Url:        "http://1.2.3.4:8000/test/scan/mod@v1.2.3?importedby=0&mode=test&insecure=true",
+					Url:        "http://1.2.3.4:8000/test/scan/mod@v1.2.3",
+					Headers:    map[string]string{"Content-Type": "application/json"},
+					Body:       []byte(`{"importedby":"0","insecure":"true","mode":"test"}`),
 					AuthorizationHeader: &taskspb.HttpRequest_OidcToken{
 						OidcToken: &taskspb.OidcToken{
 							ServiceAccountEmail: "sa",
@@ -83,7 +91,7 @@ func TestNewTaskRequest(t *testing.T) {
 		path:   "mod@v1.2.3",
 		params: "importedby=0&mode=test&insecure=true",
 	}
-	got, err := gcp.newTaskRequest(sreq, opts)
+	got, err := gcp.newTaskRequest(sreq, opts, want.Parent)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -93,8 +101,8 @@ func TestNewTaskRequest(t *testing.T) {
 	}
 
 	opts.DisableProxyFetch = true
-	want.Task.MessageType.(*taskspb.Task_HttpRequest).HttpRequest.Url += "&proxyfetch=off"
-	got, err = gcp.newTaskRequest(sreq, opts)
+	want.Task.MessageType.(*taskspb.Task_HttpRequest).HttpRequest.Body = []byte(`{"importedby":"0","insecure":"true","mode":"test","proxyfetch":"off"}`)
+	got, err = gcp.newTaskRequest(sreq, opts, want.Parent)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -103,3 +111,408 @@ func TestNewTaskRequest(t *testing.T) {
 		t.Errorf("mismatch (-want, +got):\n%s", diff)
 	}
 }
+
+func TestTaskPayload(t *testing.T) {
+	task := &testTask{name: "name", path: "mod@v1.2.3", params: "args=-a+1+-b%3D2&binary=b&args=-c"}
+	got, err := taskPayload(task, &Options{Namespace: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Of repeated params, the first one wins, as with r.FormValue.
+	want := `{"args":"-a 1 -b=2","binary":"b"}`
+	if string(got) != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	task.params = "a=%zz"
+	if _, err := taskPayload(task, &Options{Namespace: "test"}); err == nil {
+		t.Error("bad params: got nil, want error")
+	}
+}
+
+func TestNewTaskRequestPriority(t *testing.T) {
+	cfg := config.Config{
+		ProjectID:            "Project",
+		LocationID:           "us-central1",
+		QueueURL:             "http://1.2.3.4:8000",
+		ServiceAccount:       "sa",
+		LowPriorityQueueName: "low",
+	}
+	gcp, err := newGCP(&cfg, nil, "queueID")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const prefix = "projects/Project/locations/us-central1/queues/"
+	task := &testTask{name: "name", path: "mod@v1.2.3"}
+	for _, test := range []struct {
+		priority   string
+		wantParent string
+		wantHeader string
+	}{
+		{"", prefix + "queueID", ""},
+		{PriorityNormal, prefix + "queueID", ""},
+		{PriorityHigh, prefix + "queueID", PriorityHigh}, // no queue configured
+		{PriorityLow, prefix + "low", PriorityLow},
+	} {
+		opts := &Options{Namespace: "test", Priority: test.priority}
+		req, err := gcp.newTaskRequest(task, opts, gcp.queuesFor(normalizePriority(test.priority), taskID(task, opts))[0].name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req.Parent != test.wantParent {
+			t.Errorf("%q: got parent %s, want %s", test.priority, req.Parent, test.wantParent)
+		}
+		if !strings.HasPrefix(req.Task.Name, test.wantParent+"/") {
+			t.Errorf("%q: task name %s not in queue %s", test.priority, req.Task.Name, test.wantParent)
+		}
+		if got := req.Task.GetHttpRequest().Headers[PriorityHeader]; got != test.wantHeader {
+			t.Errorf("%q: got header %q, want %q", test.priority, got, test.wantHeader)
+		}
+	}
+	if _, err := gcp.newTaskRequest(task, &Options{Namespace: "test", Priority: "urgent"}, prefix+"queueID"); err == nil {
+		t.Error("got nil error for invalid priority")
+	}
+}
+
+func TestQueuesFor(t *testing.T) {
+	cfg := config.Config{
+		ProjectID:            "Project",
+		LocationID:           "us-central1",
+		QueueURL:             "http://1.2.3.4:8000",
+		ServiceAccount:       "sa",
+		LowPriorityQueueName: "low",
+		Queues: []config.QueueConfig{
+			{Location: "us-central1", Name: "q", Weight: 3},
+			{Location: "us-east1", Name: "q", Weight: 1},
+		},
+	}
+	gcp, err := newGCP(&cfg, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	gcp.now = func() time.Time { return now }
+	const (
+		central = "projects/Project/locations/us-central1/queues/q"
+		east    = "projects/Project/locations/us-east1/queues/q"
+	)
+	names := func(priority, id string) []string {
+		var ns []string
+		for _, gq := range gcp.queuesFor(priority, id) {
+			ns = append(ns, gq.name)
+		}
+		return ns
+	}
+
+	// Tasks are spread over the queues by weight, and each task
+	// always goes on the same queue.
+	counts := map[string]int{}
+	const n = 1000
+	for i := 0; i < n; i++ {
+		id := fmt.Sprintf("task%d", i)
+		got := names(PriorityNormal, id)
+		if len(got) != 2 || got[0] == got[1] {
+			t.Fatalf("%s: got %v, want both queues", id, got)
+		}
+		if again := names(PriorityNormal, id); again[0] != got[0] {
+			t.Fatalf("%s: got %s, then %s", id, got[0], again[0])
+		}
+		counts[got[0]]++
+	}
+	if c := counts[central]; c < n*2/3 || c > n*5/6 {
+		t.Errorf("%d of %d tasks on %s, want about 3/4", c, n, central)
+	}
+	// High-priority tasks have no queue of their own.
+	if got := names(PriorityHigh, "task0"); len(got) != 2 {
+		t.Errorf("high priority: got %v, want the normal queues", got)
+	}
+	if got, want := names(PriorityLow, "task0"), []string{"projects/Project/locations/us-central1/queues/low"}; !cmp.Equal(got, want) {
+		t.Errorf("low priority: got %v, want %v", got, want)
+	}
+
+	// An unhealthy queue is tried last, until it recovers.
+	gcp.queues[PriorityNormal][0].markUnhealthy(now)
+	for i := 0; i < 10; i++ {
+		if got := names(PriorityNormal, fmt.Sprintf("task%d", i)); got[0] != east {
+			t.Errorf("with %s unhealthy: got %v", central, got)
+		}
+	}
+	now = now.Add(unhealthyPeriod)
+	counts = map[string]int{}
+	for i := 0; i < 100; i++ {
+		counts[names(PriorityNormal, fmt.Sprintf("task%d", i))[0]]++
+	}
+	if counts[central] == 0 {
+		t.Errorf("%s not used after recovery", central)
+	}
+	// The configured order is unchanged.
+	if got := gcp.queues[PriorityNormal][0].name; got != central {
+		t.Errorf("first configured queue is now %s", got)
+	}
+}
+
+func TestInMemoryPriority(t *testing.T) {
+	got := make(chan string, 1)
+	q := NewInMemory(context.Background(), 1, RetryPolicy{}, func(ctx context.Context, _ Task, _ string, _ []byte) (int, error) {
+		got <- PriorityFromContext(ctx)
+		return 200, nil
+	})
+	ctx := context.Background()
+	for _, p := range []string{PriorityLow, ""} {
+		if _, err := q.EnqueueScan(ctx, &testTask{name: "m" + p, path: "m" + p}, &Options{Namespace: "ns", Priority: p}); err != nil {
+			t.Fatal(err)
+		}
+		want := p
+		if want == "" {
+			want = PriorityNormal
+		}
+		if g := <-got; g != want {
+			t.Errorf("got priority %q, want %q", g, want)
+		}
+	}
+	if _, err := q.EnqueueScan(ctx, &testTask{name: "x", path: "x"}, &Options{Namespace: "ns", Priority: "urgent"}); err == nil {
+		t.Error("got nil error for invalid priority")
+	}
+}
+
+func TestInMemoryDedup(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		names []string
+		uris  = map[string]bool{}
+	)
+	q := NewInMemory(context.Background(), 2, RetryPolicy{}, func(_ context.Context, t Task, uri string, _ []byte) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		names = append(names, t.Name())
+		uris[uri] = true
+		return 200, nil
+	})
+	ctx := context.Background()
+	task := &testTask{name: "m@v1", path: "m@v1"}
+	enqueue := func(opts *Options, want bool) {
+		t.Helper()
+		got, err := q.EnqueueScan(ctx, task, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("EnqueueScan(%+v) = %t, want %t", opts, got, want)
+		}
+	}
+	enqueue(&Options{Namespace: "ns"}, true)
+	enqueue(&Options{Namespace: "ns"}, false)
+	enqueue(&Options{Namespace: "ns", TaskNameSuffix: "x"}, true)
+	enqueue(&Options{Namespace: "other"}, true)
+	if _, err := q.EnqueueScan(ctx, task, nil); err == nil {
+		t.Error("got nil error for empty namespace")
+	}
+	// After the de-duplication window, the task can be enqueued again.
+	q.now = func() time.Time { return time.Now().Add(dedupWindow) }
+	enqueue(&Options{Namespace: "ns"}, true)
+	// The expired IDs are forgotten.
+	q.mu.Lock()
+	if got, want := len(q.seen), 1; got != want {
+		t.Errorf("remembered %d task IDs, want %d", got, want)
+	}
+	q.mu.Unlock()
+
+	q.WaitForTesting(ctx)
+	if got, want := len(names), 4; got != want {
+		t.Errorf("processed %d tasks, want %d", got, want)
+	}
+	for _, want := range []string{"/ns/scan/m@v1", "/other/scan/m@v1"} {
+		if !uris[want] {
+			t.Errorf("no task processed with URI %s; got %v", want, uris)
+		}
+	}
+	if _, err := q.EnqueueScan(ctx, task, &Options{Namespace: "new"}); err == nil {
+		t.Error("got nil error after shutdown")
+	}
+}
+
+func TestInMemoryRetry(t *testing.T) {
+	for _, test := range []struct {
+		name         string
+		failures     int
+		maxAttempts  int
+		wantAttempts int
+	}{
+		{"success", 0, 3, 1},
+		{"retry", 2, 3, 3},
+		{"give up", 5, 3, 3},
+		{"no retry", 5, 0, 1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			attempts := 0
+			retry := RetryPolicy{MaxAttempts: test.maxAttempts, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+			q := NewInMemory(context.Background(), 1, retry, func(context.Context, Task, string, []byte) (int, error) {
+				attempts++
+				if attempts <= test.failures {
+					if attempts%2 == 0 {
+						return 500, nil
+					}
+					return 0, errors.New("fail")
+				}
+				return 200, nil
+			})
+			if _, err := q.EnqueueScan(context.Background(), &testTask{name: "t"}, &Options{Namespace: "ns"}); err != nil {
+				t.Fatal(err)
+			}
+			q.WaitForTesting(context.Background())
+			if attempts != test.wantAttempts {
+				t.Errorf("got %d attempts, want %d", attempts, test.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{MinBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	for retry, want := range []time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 5: 5 * time.Second} {
+		if retry == 0 {
+			continue
+		}
+		if got := p.backoff(retry); got != want {
+			t.Errorf("backoff(%d) = %s, want %s", retry, got, want)
+		}
+	}
+}
+
+func TestInMemoryShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	q := NewInMemory(ctx, 1, RetryPolicy{}, func(ctx context.Context, _ Task, _ string, _ []byte) (int, error) {
+		close(started)
+		<-ctx.Done()
+		return 0, ctx.Err()
+	})
+	if _, err := q.EnqueueScan(ctx, &testTask{name: "t"}, &Options{Namespace: "ns"}); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	cancel()
+	// The queue shuts down without panicking, and waits for the running task.
+	<-q.done
+	if _, err := q.EnqueueScan(context.Background(), &testTask{name: "u"}, &Options{Namespace: "ns"}); err == nil {
+		t.Error("got nil error after shutdown")
+	}
+}
+
+func TestInMemoryDeleteJobTasks(t *testing.T) {
+	ctx := context.Background()
+	release := make(chan struct{})
+	var (
+		mu   sync.Mutex
+		uris []string
+	)
+	q := NewInMemory(ctx, 1, RetryPolicy{}, func(_ context.Context, _ Task, uri string, _ []byte) (int, error) {
+		<-release
+		mu.Lock()
+		defer mu.Unlock()
+		uris = append(uris, uri)
+		return 200, nil
+	})
+	enqueue := func(name, jobID string) {
+		t.Helper()
+		if _, err := q.EnqueueScan(ctx, &testTask{name: name, path: name}, &Options{Namespace: "ns", JobID: jobID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The single worker blocks on the first task, so the rest stay queued.
+	enqueue("a", "job1")
+	enqueue("b", "job1")
+	enqueue("c", "job1")
+	enqueue("d", "job2")
+	enqueue("e", "")
+
+	// Wait for the first task to start.
+	for {
+		q.mu.Lock()
+		n := q.pending["job1"]
+		q.mu.Unlock()
+		if n == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// The second task may have been taken from the queue to wait for the worker.
+	if got := q.Depth(); got != 3 && got != 4 {
+		t.Errorf("Depth() = %d, want 3 or 4", got)
+	}
+	n, err := q.DeleteJobTasks(ctx, "job1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("deleted %d tasks, want 2", n)
+	}
+	if _, err := q.EnqueueScan(ctx, &testTask{name: "f", path: "f"}, &Options{Namespace: "ns", JobID: "job1"}); err == nil {
+		t.Error("got nil error enqueuing task of canceled job")
+	}
+	close(release)
+	q.WaitForTesting(ctx)
+	want := []string{"/ns/scan/a", "/ns/scan/d", "/ns/scan/e"}
+	if diff := cmp.Diff(want, uris); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestDistinctQueues(t *testing.T) {
+	cfg := config.Config{
+		ProjectID:            "Project",
+		LocationID:           "us-central1",
+		QueueURL:             "http://1.2.3.4:8000",
+		ServiceAccount:       "sa",
+		LowPriorityQueueName: "low",
+		Queues: []config.QueueConfig{
+			{Location: "us-central1", Name: "q"},
+			{Location: "us-east1", Name: "q"},
+		},
+	}
+	gcp, err := newGCP(&cfg, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const prefix = "projects/Project/locations/"
+	names, priorities := gcp.distinctQueues()
+	wantNames := []string{prefix + "us-central1/queues/q", prefix + "us-east1/queues/q", prefix + "us-central1/queues/low"}
+	if diff := cmp.Diff(wantNames, names); diff != "" {
+		t.Errorf("names mismatch (-want, +got):\n%s", diff)
+	}
+	// High-priority tasks share the normal queues.
+	wantPriorities := map[string][]string{
+		prefix + "us-central1/queues/q":   {PriorityHigh, PriorityNormal},
+		prefix + "us-east1/queues/q":      {PriorityHigh, PriorityNormal},
+		prefix + "us-central1/queues/low": {PriorityLow},
+	}
+	if diff := cmp.Diff(wantPriorities, priorities); diff != "" {
+		t.Errorf("priorities mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestStatsErrorRate(t *testing.T) {
+	if got := (&Stats{}).ErrorRate(); got != 0 {
+		t.Errorf("no attempts: got %g, want 0", got)
+	}
+	if got := (&Stats{AttemptedTasks: 8, FailingTasks: 2}).ErrorRate(); got != 0.25 {
+		t.Errorf("got %g, want 0.25", got)
+	}
+}
+
+func TestJobTaskID(t *testing.T) {
+	task := &testTask{name: "m@v1", path: "m@v1"}
+	opts := &Options{Namespace: "ns", TaskNameSuffix: "x", JobID: "user-230102-030405", Cancelable: true}
+	id := taskID(task, opts)
+	if want := "-x-job-user-230102-030405"; !strings.HasSuffix(id, want) {
+		t.Errorf("got ID %q, want suffix %q", id, want)
+	}
+	if !strings.HasSuffix(id, jobTaskIDSuffix("user-230102-030405")) {
+		t.Errorf("ID %q does not end in job suffix", id)
+	}
+	// Without Cancelable, the task is de-duplicated across jobs.
+	opts.Cancelable = false
+	if got, want := taskID(task, opts), taskID(task, &Options{Namespace: "ns", TaskNameSuffix: "x"}); got != want {
+		t.Errorf("not cancelable: got ID %q, want %q", got, want)
+	}
+}
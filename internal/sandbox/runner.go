@@ -6,7 +6,9 @@
 
 // Package main defines a program that runs another program
 // provided on standard input, prints its standard output, then
-// terminates. It logs to stderr.
+// terminates. It logs to stderr, and copies the program's stderr there.
+// If the program fails, the runner exits with the program's exit code,
+// so that callers can tell a crash (exit code 2) from other failures.
 //
 // The input is expected to be json content encoding an exec.Cmd
 // structure extended with a boolean AppendToEnv field.
@@ -42,14 +44,24 @@ func main() {
 		cmd.Env = append(os.Environ(), cmd.Env...)
 	}
 	log.Printf("cmd: %+v", cmd)
+	// Pass the program's standard error through as it is written, so that
+	// the caller sees progress as it happens, and also keep it for the
+	// failure message.
+	var stderr bytes.Buffer
+	cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
 	out, err := cmd.Output()
 	if err != nil {
 		s := err.Error()
 		var eerr *exec.ExitError
 		if errors.As(err, &eerr) {
-			s += ": " + string(bytes.TrimSpace(eerr.Stderr))
+			s += ": " + string(bytes.TrimSpace(stderr.Bytes()))
 		}
-		log.Fatalf("%v failed with %s", cmd.Args, s)
+		log.Printf("%v failed with %s", cmd.Args, s)
+		code := 1
+		if eerr != nil && eerr.ExitCode() > 0 {
+			code = eerr.ExitCode()
+		}
+		os.Exit(code)
 	}
 	if _, err := os.Stdout.Write(out); err != nil {
 		log.Fatal(err)
@@ -8,7 +8,9 @@ package sandbox
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -70,6 +72,11 @@ type Cmd struct {
 	// If Dir is the empty string, Run runs the command in the
 	// root of the sandbox filesystem.
 	Dir string
+
+	// Stderr, if non-nil, receives the standard error of the sandbox
+	// as it is written. It includes the standard error of the command.
+	// It is not sent to the sandbox.
+	Stderr io.Writer `json:"-"`
 }
 
 // Command creates a *Cmd to run path in the sandbox.
@@ -106,8 +113,17 @@ func (c *Cmd) Output() (_ []byte, err error) {
 		stdinPipe.Close()
 		ch <- err
 	}()
+	var stderr bytes.Buffer
+	if c.Stderr != nil {
+		// Keep a copy for the ExitError, as Output would.
+		cmd.Stderr = io.MultiWriter(&stderr, c.Stderr)
+	}
 	out, err := cmd.Output()
 	if err != nil {
+		var eerr *exec.ExitError
+		if c.Stderr != nil && errors.As(err, &eerr) {
+			eerr.Stderr = stderr.Bytes()
+		}
 		return nil, err
 	}
 	if err := <-ch; err != nil {
@@ -0,0 +1,150 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package sarif defines the parts of the Static Analysis Results
+// Interchange Format (SARIF), version 2.1.0, that are needed to report
+// the results of scans to tools that consume SARIF.
+//
+// See https://docs.oasis-open.org/sarif/sarif/v2.1.0/sarif-v2.1.0.html.
+package sarif
+
+const (
+	// Version is the version of SARIF of a Log.
+	Version = "2.1.0"
+	// Schema is the JSON schema of a Log.
+	Schema = "https://json.schemastore.org/sarif-2.1.0.json"
+)
+
+// The levels of a Result.
+const (
+	LevelError   = "error"
+	LevelWarning = "warning"
+	LevelNote    = "note"
+	LevelNone    = "none"
+)
+
+// SrcRoot is the URI base ID of locations relative to the root of the
+// scanned module.
+const SrcRoot = "%SRCROOT%"
+
+// A Log is the top-level SARIF object.
+type Log struct {
+	Version string `json:"version"`
+	Schema  string `json:"$schema"`
+	Runs    []*Run `json:"runs"`
+}
+
+// NewLog returns a Log of the runs.
+func NewLog(runs ...*Run) *Log {
+	if runs == nil {
+		runs = []*Run{}
+	}
+	return &Log{Version: Version, Schema: Schema, Runs: runs}
+}
+
+// A Run is one invocation of a tool, like the scan of a module.
+type Run struct {
+	Tool        Tool          `json:"tool"`
+	Invocations []*Invocation `json:"invocations,omitempty"`
+	// Results is never nil in a valid run, since an empty list means
+	// that the tool found nothing.
+	Results    []*Result      `json:"results"`
+	Properties map[string]any `json:"properties,omitempty"`
+}
+
+// NewRun returns a Run of the tool with no results.
+func NewRun(driver Driver) *Run {
+	return &Run{Tool: Tool{Driver: driver}, Results: []*Result{}}
+}
+
+// AddRule adds rule to the rules of the run's tool, unless it already
+// has a rule with the same ID.
+func (r *Run) AddRule(rule *Rule) {
+	for _, ru := range r.Tool.Driver.Rules {
+		if ru.ID == rule.ID {
+			return
+		}
+	}
+	r.Tool.Driver.Rules = append(r.Tool.Driver.Rules, rule)
+}
+
+// A Tool describes the tool of a run.
+type Tool struct {
+	Driver Driver `json:"driver"`
+}
+
+// A Driver is the component of a tool that runs the analysis.
+type Driver struct {
+	Name           string  `json:"name"`
+	Version        string  `json:"version,omitempty"`
+	InformationURI string  `json:"informationUri,omitempty"`
+	Rules          []*Rule `json:"rules,omitempty"`
+}
+
+// A Rule describes what a tool checks, like an analyzer or a
+// vulnerability.
+type Rule struct {
+	ID               string   `json:"id"`
+	ShortDescription *Message `json:"shortDescription,omitempty"`
+	FullDescription  *Message `json:"fullDescription,omitempty"`
+	HelpURI          string   `json:"helpUri,omitempty"`
+}
+
+// An Invocation describes whether a run succeeded.
+type Invocation struct {
+	ExecutionSuccessful        bool            `json:"executionSuccessful"`
+	ToolExecutionNotifications []*Notification `json:"toolExecutionNotifications,omitempty"`
+}
+
+// A Notification is a message about a run, like the reason it failed.
+type Notification struct {
+	Level   string  `json:"level,omitempty"`
+	Message Message `json:"message"`
+}
+
+// A Result is a problem found by a tool.
+type Result struct {
+	RuleID              string            `json:"ruleId"`
+	Level               string            `json:"level,omitempty"`
+	Message             Message           `json:"message"`
+	Locations           []*Location       `json:"locations,omitempty"`
+	PartialFingerprints map[string]string `json:"partialFingerprints,omitempty"`
+}
+
+// A Message is plain text.
+type Message struct {
+	Text string `json:"text"`
+}
+
+// A Location is where a result was found: a place in a file, or a
+// program element, or both.
+type Location struct {
+	PhysicalLocation *PhysicalLocation  `json:"physicalLocation,omitempty"`
+	LogicalLocations []*LogicalLocation `json:"logicalLocations,omitempty"`
+}
+
+// A PhysicalLocation is a region of a file.
+type PhysicalLocation struct {
+	ArtifactLocation ArtifactLocation `json:"artifactLocation"`
+	Region           *Region          `json:"region,omitempty"`
+}
+
+// An ArtifactLocation is the location of a file. If URIBaseID is set,
+// URI is relative to it.
+type ArtifactLocation struct {
+	URI       string `json:"uri"`
+	URIBaseID string `json:"uriBaseId,omitempty"`
+}
+
+// A Region is a position in a file. Lines and columns start at 1.
+type Region struct {
+	StartLine   int `json:"startLine,omitempty"`
+	StartColumn int `json:"startColumn,omitempty"`
+}
+
+// A LogicalLocation is a program element, like a function or a package.
+type LogicalLocation struct {
+	FullyQualifiedName string `json:"fullyQualifiedName"`
+	Kind               string `json:"kind,omitempty"`
+}
@@ -0,0 +1,173 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scan
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"google.golang.org/api/iterator"
+)
+
+const gcsScheme = "gs://"
+
+// ReadLines reads and returns the lines from the files named by pattern,
+// filtered as in ReadFileLines.
+//
+// The pattern is either a local file path or a GCS object of the form
+// gs://bucket/object. Either may contain the wildcards of path.Match in
+// its final element, like gs://bucket/corpus/shard-*.txt. The files
+// matching a wildcard are read in lexical order of their names, so that
+// corpora split into shards are concatenated deterministically.
+// It is an error if nothing matches.
+func ReadLines(ctx context.Context, pattern string) (lines []string, err error) {
+	defer derrors.Wrap(&err, "ReadLines(%q)", pattern)
+	if strings.HasPrefix(pattern, gcsScheme) {
+		return readGCSLines(ctx, pattern)
+	}
+	if !hasMeta(pattern) {
+		return ReadFileLines(pattern)
+	}
+	filenames, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(filenames) == 0 {
+		return nil, errors.New("no files match")
+	}
+	sort.Strings(filenames)
+	for _, f := range filenames {
+		ls, err := ReadFileLines(f)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, ls...)
+	}
+	return lines, nil
+}
+
+func readGCSLines(ctx context.Context, url string) (_ []string, err error) {
+	bucketName, object, err := SplitGCSURL(url)
+	if err != nil {
+		return nil, err
+	}
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	bucket := client.Bucket(bucketName)
+
+	objects := []string{object}
+	if hasMeta(object) {
+		objects, err = matchGCSObjects(ctx, bucket, object)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var lines []string
+	for _, o := range objects {
+		ls, err := readGCSObjectLines(ctx, bucket, o)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, ls...)
+	}
+	return lines, nil
+}
+
+// SplitGCSURL splits a URL of the form gs://bucket/object into its bucket
+// and object.
+func SplitGCSURL(url string) (bucket, object string, err error) {
+	rest, ok := strings.CutPrefix(url, gcsScheme)
+	if !ok {
+		return "", "", errors.New("missing gs:// prefix")
+	}
+	bucket, object, ok = strings.Cut(rest, "/")
+	if !ok || bucket == "" || object == "" {
+		return "", "", errors.New("want gs://bucket/object")
+	}
+	if hasMeta(bucket) {
+		return "", "", errors.New("wildcards not allowed in bucket name")
+	}
+	return bucket, object, nil
+}
+
+// matchGCSObjects returns the sorted names of the objects in bucket that
+// match pattern.
+func matchGCSObjects(ctx context.Context, bucket *storage.BucketHandle, pattern string) ([]string, error) {
+	// Check the pattern up front; path.Match only reports a bad pattern
+	// when it gets far enough to notice.
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	var names []string
+	iter := bucket.Objects(ctx, &storage.Query{Prefix: globPrefix(pattern)})
+	for {
+		attrs, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if ok, _ := path.Match(pattern, attrs.Name); ok {
+			names = append(names, attrs.Name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, errors.New("no objects match")
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func readGCSObjectLines(ctx context.Context, bucket *storage.BucketHandle, object string) (_ []string, err error) {
+	defer derrors.Wrap(&err, "readGCSObjectLines(%q)", object)
+	r, err := bucket.Object(object).NewReader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readLines(r)
+}
+
+// globPrefix returns the part of pattern before its first wildcard.
+func globPrefix(pattern string) string {
+	if i := strings.IndexAny(pattern, `*?[\`); i >= 0 {
+		return pattern[:i]
+	}
+	return pattern
+}
+
+// hasMeta reports whether s contains any of the wildcards of path.Match.
+func hasMeta(s string) bool {
+	return strings.ContainsAny(s, `*?[\`)
+}
+
+// readLines reads lines from r, filtered as in ReadFileLines.
+func readLines(r io.Reader) ([]string, error) {
+	var lines []string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if s.Err() != nil {
+		return nil, s.Err()
+	}
+	return lines, nil
+}
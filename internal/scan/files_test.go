@@ -0,0 +1,76 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scan
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReadLinesGlob(t *testing.T) {
+	dir := t.TempDir()
+	for name, contents := range map[string]string{
+		"shard-10.txt": "c\n",
+		"shard-02.txt": "# comment\nb\n\n",
+		"shard-01.txt": "a\n",
+		"other.txt":    "x\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := context.Background()
+	got, err := ReadLines(ctx, filepath.Join(dir, "shard-*.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a", "b", "c"}; !cmp.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if _, err := ReadLines(ctx, filepath.Join(dir, "none-*.txt")); err == nil {
+		t.Error("got nil error for pattern without matches")
+	}
+}
+
+func TestSplitGCSURL(t *testing.T) {
+	for _, test := range []struct {
+		url, bucket, object string
+		wantErr             bool
+	}{
+		{url: "gs://b/o.txt", bucket: "b", object: "o.txt"},
+		{url: "gs://b/dir/shard-*.txt", bucket: "b", object: "dir/shard-*.txt"},
+		{url: "gs://b", wantErr: true},
+		{url: "gs://b/", wantErr: true},
+		{url: "gs://b*/o", wantErr: true},
+		{url: "/local/file", wantErr: true},
+	} {
+		bucket, object, err := SplitGCSURL(test.url)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: got error %v, want error: %t", test.url, err, test.wantErr)
+			continue
+		}
+		if bucket != test.bucket || object != test.object {
+			t.Errorf("%s: got (%q, %q), want (%q, %q)", test.url, bucket, object, test.bucket, test.object)
+		}
+	}
+}
+
+func TestGlobPrefix(t *testing.T) {
+	for _, test := range []struct{ pattern, want string }{
+		{"dir/shard-*.txt", "dir/shard-"},
+		{"dir/s?.txt", "dir/s"},
+		{"dir/[ab].txt", "dir/"},
+		{"dir/file.txt", "dir/file.txt"},
+	} {
+		if got := globPrefix(test.pattern); got != test.want {
+			t.Errorf("globPrefix(%q) = %q, want %q", test.pattern, got, test.want)
+		}
+	}
+}
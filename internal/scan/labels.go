@@ -0,0 +1,58 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scan
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// A Label is a key and value that an enqueue attaches to every result row
+// of its scans, so that the results of an experiment can be sliced by its
+// dimensions, like "arm:control" or "toolchain:go1.22".
+type Label struct {
+	Key   string `bigquery:"key" json:"key"`
+	Value string `bigquery:"value" json:"value"`
+}
+
+var labelKeyRegexp = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_.-]*$`)
+
+// ParseLabels parses labels of the form "key:value", as in the labels
+// param of an enqueue, and returns them sorted by key. Keys must be
+// distinct, and values non-empty.
+func ParseLabels(labels []string) ([]*Label, error) {
+	var ls []*Label
+	seen := map[string]bool{}
+	for _, l := range labels {
+		k, v, ok := strings.Cut(l, ":")
+		if !ok || !labelKeyRegexp.MatchString(k) || v == "" {
+			return nil, fmt.Errorf("bad label %q: want KEY:VALUE", l)
+		}
+		if seen[k] {
+			return nil, fmt.Errorf("duplicate label key %q", k)
+		}
+		seen[k] = true
+		ls = append(ls, &Label{Key: k, Value: v})
+	}
+	sort.Slice(ls, func(i, j int) bool { return ls[i].Key < ls[j].Key })
+	return ls, nil
+}
+
+// FormatLabels returns labels, as returned by ParseLabels, in the form of
+// the labels param of an enqueue, like "arm:control,toolchain:go1.22".
+// Labels sorted by key format the same however they were given, so the
+// result identifies them.
+func FormatLabels(labels []*Label) string {
+	var b strings.Builder
+	for i, l := range labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(l.Key + ":" + l.Value)
+	}
+	return b.String()
+}
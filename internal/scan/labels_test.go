@@ -0,0 +1,46 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scan
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseLabels(t *testing.T) {
+	got, err := ParseLabels([]string{"toolchain:go1.22", "arm:control", "url:https://x.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*Label{
+		{Key: "arm", Value: "control"},
+		{Key: "toolchain", Value: "go1.22"},
+		{Key: "url", Value: "https://x.com"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+	if got, want := FormatLabels(got), "arm:control,toolchain:go1.22,url:https://x.com"; got != want {
+		t.Errorf("FormatLabels: got %q, want %q", got, want)
+	}
+
+	if got, err := ParseLabels(nil); got != nil || err != nil {
+		t.Errorf("no labels: got (%v, %v), want (nil, nil)", got, err)
+	}
+
+	for _, labels := range [][]string{
+		{"arm"},
+		{"arm:"},
+		{":control"},
+		{"1arm:control"},
+		{"a m:control"},
+		{"arm:control", "arm:treatment"},
+	} {
+		if _, err := ParseLabels(labels); err == nil {
+			t.Errorf("%q: got nil, want error", labels)
+		}
+	}
+}
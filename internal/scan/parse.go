@@ -6,20 +6,28 @@
 package scan
 
 import (
-	"bufio"
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"reflect"
 	"strconv"
 	"strings"
+	"time"
 
 	"golang.org/x/pkgsite-metrics/internal/derrors"
 	"golang.org/x/pkgsite-metrics/internal/version"
 )
 
 func ParseOptionalBoolParam(r *http.Request, name string, def bool) (bool, error) {
+	if err := parsePayload(r); err != nil {
+		return false, err
+	}
 	s := r.FormValue(name)
 	if s == "" {
 		return def, nil
@@ -32,12 +40,30 @@ type ModuleSpec struct {
 	ImportedBy    int
 }
 
-func ParseCorpusFile(filename string, minImportedByCount int) (ms []ModuleSpec, err error) {
+// ParseCorpusFile reads module specs from the files named by filename,
+// which may be a GCS URL or a glob as described in ReadLines.
+// Only modules imported by at least minImportedByCount others are returned.
+func ParseCorpusFile(ctx context.Context, filename string, minImportedByCount int) (ms []ModuleSpec, err error) {
 	defer derrors.Wrap(&err, "parseCorpusFile(%q)", filename)
-	lines, err := ReadFileLines(filename)
+	lines, err := ReadLines(ctx, filename)
 	if err != nil {
 		return nil, err
 	}
+	return parseCorpusLines(lines, minImportedByCount)
+}
+
+// ParseCorpus reads module specs in the format of a corpus file from r.
+// Only modules imported by at least minImportedByCount others are returned.
+func ParseCorpus(r io.Reader, minImportedByCount int) (_ []ModuleSpec, err error) {
+	defer derrors.Wrap(&err, "ParseCorpus")
+	lines, err := readLines(r)
+	if err != nil {
+		return nil, err
+	}
+	return parseCorpusLines(lines, minImportedByCount)
+}
+
+func parseCorpusLines(lines []string, minImportedByCount int) (ms []ModuleSpec, err error) {
 	for _, line := range lines {
 		fields := strings.Fields(line)
 		var path, vers, imps string
@@ -67,26 +93,14 @@ func ParseCorpusFile(filename string, minImportedByCount int) (ms []ModuleSpec,
 // ReadFileLines reads and returns the lines from a file.
 // Whitespace on each line is trimmed.
 // Blank lines and lines beginning with '#' are ignored.
-func ReadFileLines(filename string) (lines []string, err error) {
+func ReadFileLines(filename string) (_ []string, err error) {
 	defer derrors.Wrap(&err, "readFileLines(%q)", filename)
 	f, err := os.Open(filename)
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
-
-	s := bufio.NewScanner(f)
-	for s.Scan() {
-		line := strings.TrimSpace(s.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-		lines = append(lines, line)
-	}
-	if s.Err() != nil {
-		return nil, s.Err()
-	}
-	return lines, nil
+	return readLines(f)
 }
 
 // A ModuleURLPath holds the components of a URL path parsed
@@ -138,14 +152,32 @@ func (m ModuleURLPath) Path() string {
 	return p
 }
 
+// CheckSubdir checks the subdir parameter of a scan request, which names
+// the directory of the module's download to scan, for repos whose Go
+// module is not at the root. It must be a clean, slash-separated path
+// inside the download, like "go" or "sdk/go".
+func CheckSubdir(subdir string) error {
+	if subdir == "" {
+		return nil
+	}
+	if path.IsAbs(subdir) || path.Clean(subdir) != subdir || subdir == "." ||
+		subdir == ".." || strings.HasPrefix(subdir, "../") || strings.Contains(subdir, `\`) {
+		return fmt.Errorf("bad subdir %q: want a relative, slash-separated path within the module", subdir)
+	}
+	return nil
+}
+
 // ParseParams populates the fields of pstruct, which must a pointer to a struct,
-// with the form and query parameters of r.
+// with the form and query parameters of r, and the parameters in its JSON
+// payload, if any (see parsePayload).
 //
 // The fields of pstruct must be exported, and each field must be a string, an
-// int or a bool. If there is a request parameter corresponding to the
-// lower-cased field name, it is parsed according to the field's type and
-// assigned to the field. If there is no matching parameter (or it is the empty
-// string), the field is not assigned.
+// int, an int64, a float64, a bool, a time.Duration or a []string. If there is
+// a request parameter corresponding to the lower-cased field name, it is parsed
+// according to the field's type and assigned to the field. Durations are
+// parsed with time.ParseDuration, like "25m", and a []string is parsed from a
+// comma-separated list, like "symbol,package". If there is no matching
+// parameter (or it is the empty string), the field is not assigned.
 //
 // For default values or to detect missing parameters, set the struct field
 // before calling ParseParams; if there is no matching parameter, the field will
@@ -153,6 +185,9 @@ func (m ModuleURLPath) Path() string {
 func ParseParams(r *http.Request, pstruct any) (err error) {
 	defer derrors.Wrap(&err, "ParseParams(%q)", r.URL)
 
+	if err := parsePayload(r); err != nil {
+		return err
+	}
 	v := reflect.ValueOf(pstruct)
 	t := v.Type()
 	if t.Kind() != reflect.Pointer || t.Elem().Kind() != reflect.Struct {
@@ -169,30 +204,86 @@ func ParseParams(r *http.Request, pstruct any) (err error) {
 			// If param is missing, do not set field.
 			continue
 		}
-		pval, err := parseParam(paramValue, f.Type.Kind())
+		pval, err := parseParam(paramValue, f.Type)
 		if err != nil {
 			return fmt.Errorf("param %s: %v", paramName, err)
 		}
-		v.Field(i).Set(reflect.ValueOf(pval))
+		v.Field(i).Set(reflect.ValueOf(pval).Convert(f.Type))
 	}
 	return nil
 }
 
-func parseParam(param string, kind reflect.Kind) (any, error) {
-	switch kind {
+// parsePayload adds the parameters in the payload of r to its form values,
+// replacing those of the same name in the URL query, if r is a POST with a
+// JSON body. The payload is an object with string values, formatted as in
+// URL queries, like the payloads of tasks sent by package queue. Requests
+// may also pass their parameters in the URL query, as tasks enqueued by
+// earlier versions of the worker do.
+//
+// parsePayload consumes the body of r, so calling it again has no effect.
+func parsePayload(r *http.Request) error {
+	if r.Method != http.MethodPost || r.Body == nil {
+		return nil
+	}
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "application/json" {
+		return nil
+	}
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	var payload map[string]string
+	err := json.NewDecoder(r.Body).Decode(&payload)
+	r.Body = http.NoBody
+	if err == io.EOF {
+		// Empty, or already parsed.
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("bad JSON payload: %v", err)
+	}
+	for name, value := range payload {
+		r.Form.Set(name, value)
+	}
+	return nil
+}
+
+var (
+	durationType    = reflect.TypeOf(time.Duration(0))
+	stringSliceType = reflect.TypeOf([]string(nil))
+)
+
+func parseParam(param string, t reflect.Type) (any, error) {
+	switch t {
+	case durationType:
+		return time.ParseDuration(param)
+	case stringSliceType:
+		var ss []string
+		for _, s := range strings.Split(param, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				ss = append(ss, s)
+			}
+		}
+		return ss, nil
+	}
+	switch t.Kind() {
 	case reflect.String:
 		return param, nil
 	case reflect.Int:
 		return strconv.Atoi(param)
+	case reflect.Int64:
+		return strconv.ParseInt(param, 10, 64)
+	case reflect.Float64:
+		return strconv.ParseFloat(param, 64)
 	case reflect.Bool:
 		return strconv.ParseBool(param)
 	default:
-		return nil, fmt.Errorf("cannot parse kind %s", kind)
+		return nil, fmt.Errorf("cannot parse kind %s", t.Kind())
 	}
 }
 
 // FormatParams takes a struct or struct pointer, and returns
-// a URL query-param string with the struct field values.
+// a URL query-param string with the struct field values,
+// which ParseParams parses back into the struct.
 func FormatParams(s any) string {
 	v := reflect.ValueOf(s)
 	t := v.Type()
@@ -206,7 +297,16 @@ func FormatParams(s any) string {
 	var params []string
 	for i := 0; i < t.NumField(); i++ {
 		f := t.Field(i)
-		val := url.QueryEscape(fmt.Sprint(v.Field(i)))
+		fv := v.Field(i)
+		val := fmt.Sprint(fv)
+		if fv.Type() == stringSliceType {
+			var ss []string
+			for j := 0; j < fv.Len(); j++ {
+				ss = append(ss, fv.Index(j).String())
+			}
+			val = strings.Join(ss, ",")
+		}
+		val = url.QueryEscape(val)
 		params = append(params,
 			fmt.Sprintf("%s=%s", strings.ToLower(f.Name), val))
 	}
@@ -5,10 +5,12 @@
 package scan
 
 import (
+	"context"
 	"net/http"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"golang.org/x/pkgsite-metrics/internal/version"
@@ -94,9 +96,22 @@ func TestModuleURLPathError(t *testing.T) {
 	}
 }
 
+func TestCheckSubdir(t *testing.T) {
+	for _, subdir := range []string{"", "go", "sdk/go", "a..b"} {
+		if err := CheckSubdir(subdir); err != nil {
+			t.Errorf("%q: got %v, want nil", subdir, err)
+		}
+	}
+	for _, subdir := range []string{".", "..", "../x", "/x", "x/", "x//y", "x/../y", `x\y`} {
+		if err := CheckSubdir(subdir); err == nil {
+			t.Errorf("%q: got nil, want error", subdir)
+		}
+	}
+}
+
 func TestParseCorpusFile(t *testing.T) {
 	const file = "testdata/modules.txt"
-	got, err := ParseCorpusFile(file, 1)
+	got, err := ParseCorpusFile(context.Background(), file, 1)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -110,7 +125,7 @@ func TestParseCorpusFile(t *testing.T) {
 		t.Errorf("\n got %v\nwant %v", got, want)
 	}
 
-	got, err = ParseCorpusFile(file, 10)
+	got, err = ParseCorpusFile(context.Background(), file, 10)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -120,10 +135,36 @@ func TestParseCorpusFile(t *testing.T) {
 	}
 }
 
+func TestParseCorpus(t *testing.T) {
+	const corpus = `
+# private modules
+example.com/a v0.0.0-private 0
+example.com/b v1.2.3 4
+`
+	got, err := ParseCorpus(strings.NewReader(corpus), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ModuleSpec{
+		{"example.com/a", "v0.0.0-private", 0},
+		{"example.com/b", "v1.2.3", 4},
+	}
+	if !cmp.Equal(got, want) {
+		t.Errorf("\n got %v\nwant %v", got, want)
+	}
+	if _, err := ParseCorpus(strings.NewReader("example.com/a v1 v2 3"), 0); err == nil {
+		t.Error("got nil, want error")
+	}
+}
+
 type params struct {
-	Str  string
-	Int  int
-	Bool bool
+	Str      string
+	Int      int
+	Bool     bool
+	Int64    int64
+	Float    float64
+	Duration time.Duration
+	List     []string
 }
 
 func TestParseParams(t *testing.T) {
@@ -144,6 +185,14 @@ func TestParseParams(t *testing.T) {
 				"int=3&bool=t&str=", // empty string is same as default
 				params{Str: "d", Int: 3, Bool: true},
 			},
+			{
+				"int64=12345678901&float=1.5&duration=25m&list=symbol,package",
+				params{Str: "d", Int: 17, Int64: 12345678901, Float: 1.5, Duration: 25 * time.Minute, List: []string{"symbol", "package"}},
+			},
+			{
+				"list=a,,+b+,", // empty elements are dropped
+				params{Str: "d", Int: 17, List: []string{"a", "b"}},
+			},
 		} {
 			r, err := http.NewRequest("GET", "https://path?"+test.params, nil)
 			if err != nil {
@@ -167,7 +216,10 @@ func TestParseParams(t *testing.T) {
 			{3, "", "struct pointer"},
 			{&params{}, "int=foo", "invalid syntax"},
 			{&params{}, "bool=foo", "invalid syntax"},
-			{&struct{ F float64 }{}, "f=1.1", "cannot parse kind"},
+			{&params{}, "float=x", "invalid syntax"},
+			{&params{}, "duration=25", "missing unit"},
+			{&struct{ F float32 }{}, "f=1.1", "cannot parse kind"},
+			{&struct{ F []int }{}, "f=1", "cannot parse kind"},
 		} {
 			r, err := http.NewRequest("GET", "https://path?"+test.params, nil)
 			if err != nil {
@@ -185,10 +237,65 @@ func TestParseParams(t *testing.T) {
 	})
 }
 
+func TestParseParamsPayload(t *testing.T) {
+	newRequest := func(query, body string) *http.Request {
+		t.Helper()
+		r, err := http.NewRequest("POST", "https://path?"+query, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", "application/json")
+		return r
+	}
+
+	// Payload params replace query params.
+	r := newRequest("str=q&int=1", `{"str":"a b","bool":"true","list":"x,y"}`)
+	want := params{Str: "a b", Int: 1, Bool: true, List: []string{"x", "y"}}
+	for i := 0; i < 2; i++ { // parsing again gives the same result
+		var got params
+		if err := ParseParams(r, &got); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got  %+v\nwant %+v", got, want)
+		}
+	}
+	if b, err := ParseOptionalBoolParam(r, "bool", false); err != nil || !b {
+		t.Errorf("ParseOptionalBoolParam: got %t, %v; want true, nil", b, err)
+	}
+
+	// An empty body is allowed.
+	var got params
+	if err := ParseParams(newRequest("int=2", ""), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Int != 2 {
+		t.Errorf("got Int %d, want 2", got.Int)
+	}
+
+	// Payload values must be strings.
+	if err := ParseParams(newRequest("", `{"int":3}`), &got); err == nil {
+		t.Error("non-string value: got nil, want error")
+	}
+}
+
 func TestFormatParams(t *testing.T) {
-	got := FormatParams(params{Str: "foo bar", Int: 17, Bool: true})
-	want := "str=foo+bar&int=17&bool=true"
+	p := params{Str: "foo bar", Int: 17, Bool: true, Int64: 5, Float: 0.5, Duration: 90 * time.Second, List: []string{"a", "b"}}
+	got := FormatParams(p)
+	want := "str=foo+bar&int=17&bool=true&int64=5&float=0.5&duration=1m30s&list=a%2Cb"
 	if got != want {
 		t.Errorf("got %q, want %q", got, want)
 	}
+	// ParseParams inverts FormatParams.
+	r, err := http.NewRequest("GET", "https://path?"+got, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p2 params
+	if err := ParseParams(r, &p2); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(p2, p) {
+		t.Errorf("round trip: got %+v, want %+v", p2, p)
+	}
 }
@@ -6,10 +6,14 @@
 package version
 
 import (
+	"errors"
 	"fmt"
 	"regexp"
+	"sort"
 	"strings"
+	"time"
 
+	"golang.org/x/mod/module"
 	"golang.org/x/mod/semver"
 )
 
@@ -53,6 +57,39 @@ func IsPseudo(v string) bool {
 	return strings.Count(v, "-") >= 2 && pseudoVersionRE.MatchString(v)
 }
 
+// Pseudo returns the pseudo-version of a revision made at time t in a
+// module with no tagged versions, like "v0.0.0-20230101120000-abcdefabcdef".
+// The revision identifier rev, typically a commit hash, is shortened to 12
+// characters as the go command does. Pseudo-versions are also given to
+// synthetic modules, which have no versions of their own.
+func Pseudo(t time.Time, rev string) string {
+	return PseudoAfter("", t, rev)
+}
+
+// PseudoAfter is like Pseudo, but returns a pseudo-version that sorts
+// after older, a tagged version of the module, and before the next
+// release. If older is empty, it is the same as Pseudo.
+func PseudoAfter(older string, t time.Time, rev string) string {
+	major := "v0"
+	if older != "" {
+		major = semver.Major(older)
+	}
+	if len(rev) > 12 {
+		rev = rev[:12]
+	}
+	return module.PseudoVersion(major, older, t, rev)
+}
+
+// PseudoTime returns the time of the revision of pseudo-version v.
+func PseudoTime(v string) (time.Time, error) {
+	return module.PseudoVersionTime(v)
+}
+
+// PseudoRev returns the revision identifier of pseudo-version v.
+func PseudoRev(v string) (string, error) {
+	return module.PseudoVersionRev(v)
+}
+
 // IsIncompatible reports whether a valid version v is an incompatible version.
 func IsIncompatible(v string) bool {
 	return strings.HasSuffix(v, "+incompatible")
@@ -140,6 +177,60 @@ loop:
 	return string(bytes)
 }
 
+// FromSorting returns the version that ForSorting encodes as sortVersion,
+// like the sort_version columns of result tables. Build metadata, which
+// ForSorting drops, is not restored: the sort version of
+// "v2.0.0+incompatible" decodes to "v2.0.0".
+func FromSorting(sortVersion string) (_ string, err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("FromSorting(%q): %w", sortVersion, err)
+		}
+	}()
+	s, release := strings.CutSuffix(sortVersion, "~")
+	parts := strings.Split(s, ",")
+	if len(parts) < 3 || (release && len(parts) != 3) {
+		return "", errors.New("wrong number of parts")
+	}
+	for i, p := range parts {
+		if rest, ok := strings.CutPrefix(p, "~"); ok {
+			parts[i] = rest
+		} else {
+			parts[i] = strings.TrimLeft(p, "abcdefghijklmnopqrstuvwxyz")
+		}
+	}
+	v := "v" + strings.Join(parts[:3], ".")
+	if !release {
+		v += "-" + strings.Join(parts[3:], ".")
+	}
+	if !semver.IsValid(v) || ForSorting(v) != sortVersion {
+		return "", errors.New("not a sort version")
+	}
+	return v, nil
+}
+
+// Compare returns -1, 0 or +1 as v1 is less than, equal to or greater
+// than v2 in semver precedence, in which a pseudo-version sorts between
+// the versions that it falls between in the module's history. It is
+// consistent with comparing the ForSorting encodings of the versions, and
+// so with ordering rows by their sort_version. An invalid version is less
+// than every valid one.
+func Compare(v1, v2 string) int {
+	return semver.Compare(v1, v2)
+}
+
+// Sort sorts versions in increasing order by Compare. Versions that
+// compare equal, like "v1.0.0" and "v1.0.0+incompatible", are ordered as
+// strings, so that the order is deterministic.
+func Sort(versions []string) {
+	sort.Slice(versions, func(i, j int) bool {
+		if c := Compare(versions[i], versions[j]); c != 0 {
+			return c < 0
+		}
+		return versions[i] < versions[j]
+	})
+}
+
 // appendNumericPrefix appends a string representing n to dst.
 // n is the length of a digit string; the value we append is a prefix for the
 // digit string s such that
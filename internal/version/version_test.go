@@ -6,7 +6,9 @@ package version
 
 import (
 	"testing"
+	"time"
 
+	"github.com/google/go-cmp/cmp"
 	"golang.org/x/mod/semver"
 )
 
@@ -256,3 +258,94 @@ func TestLatest(t *testing.T) {
 		})
 	}
 }
+
+func TestPseudo(t *testing.T) {
+	tm := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	const rev = "abcdef0123456789"
+	for _, test := range []struct {
+		older, want string
+	}{
+		{"", "v0.0.0-20230102030405-abcdef012345"},
+		{"v1.2.3", "v1.2.4-0.20230102030405-abcdef012345"},
+		{"v1.2.3-rc.1", "v1.2.3-rc.1.0.20230102030405-abcdef012345"},
+		{"v2.0.0", "v2.0.1-0.20230102030405-abcdef012345"},
+	} {
+		got := PseudoAfter(test.older, tm, rev)
+		if got != test.want {
+			t.Errorf("PseudoAfter(%q) = %q, want %q", test.older, got, test.want)
+			continue
+		}
+		if !IsPseudo(got) {
+			t.Errorf("%q: not a pseudo-version", got)
+		}
+		if test.older != "" && Compare(test.older, got) >= 0 {
+			t.Errorf("%q does not sort after %q", got, test.older)
+		}
+		if gt, err := PseudoTime(got); err != nil || !gt.Equal(tm) {
+			t.Errorf("PseudoTime(%q) = %v, %v, want %v", got, gt, err, tm)
+		}
+		if gr, err := PseudoRev(got); err != nil || gr != rev[:12] {
+			t.Errorf("PseudoRev(%q) = %q, %v, want %q", got, gr, err, rev[:12])
+		}
+	}
+	if got, want := Pseudo(tm, "private"), "v0.0.0-20230102030405-private"; got != want {
+		t.Errorf("Pseudo: got %q, want %q", got, want)
+	}
+}
+
+func TestFromSorting(t *testing.T) {
+	for _, v := range []string{
+		"v0.0.0-20180713131340-b395d2d6f5ee",
+		"v0.0.0",
+		"v1.0.0-alpha.1",
+		"v1.0.0-beta.11",
+		"v1.2.3-rc.20150901.-",
+		"v12.48.301",
+		"v2.0.0-z-",
+		"v1.2.4-0.20230102030405-abcdef012345",
+	} {
+		got, err := FromSorting(ForSorting(v))
+		if err != nil || got != v {
+			t.Errorf("FromSorting(ForSorting(%q)) = %q, %v", v, got, err)
+		}
+	}
+	if got, err := FromSorting(ForSorting("v2.0.0+incompatible")); err != nil || got != "v2.0.0" {
+		t.Errorf("incompatible: got %q, %v, want v2.0.0", got, err)
+	}
+	for _, bad := range []string{"", "1,2~", "1,2,3,4~", "1,2,a3~", "1,2,3,~a~b", "v1.2.3"} {
+		if got, err := FromSorting(bad); err == nil {
+			t.Errorf("FromSorting(%q) = %q, want error", bad, got)
+		}
+	}
+}
+
+func TestSort(t *testing.T) {
+	vs := []string{
+		"v1.10.0",
+		"v1.2.0+incompatible",
+		"v1.2.0",
+		"v1.2.1-0.20230102030405-abcdef012345",
+		"v1.2.1-rc.1",
+		"v0.0.0-20230102030405-abcdef012345",
+		"v1.2.1",
+	}
+	Sort(vs)
+	want := []string{
+		"v0.0.0-20230102030405-abcdef012345",
+		"v1.2.0",
+		"v1.2.0+incompatible",
+		"v1.2.1-0.20230102030405-abcdef012345",
+		"v1.2.1-rc.1",
+		"v1.2.1",
+		"v1.10.0",
+	}
+	if diff := cmp.Diff(want, vs); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+	// Sort agrees with the order of the sort versions.
+	for i := 1; i < len(vs); i++ {
+		if ForSorting(vs[i-1]) > ForSorting(vs[i]) {
+			t.Errorf("sort version of %s is greater than that of %s", vs[i-1], vs[i])
+		}
+	}
+}
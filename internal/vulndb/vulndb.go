@@ -10,9 +10,12 @@ import (
 	"context"
 	"time"
 
+	bq "cloud.google.com/go/bigquery"
+	"golang.org/x/mod/semver"
 	"golang.org/x/pkgsite-metrics/internal/bigquery"
 	"golang.org/x/pkgsite-metrics/internal/derrors"
 	"golang.org/x/pkgsite-metrics/internal/osv"
+	"golang.org/x/pkgsite-metrics/internal/version"
 )
 
 // Definitions for BigQuery.
@@ -50,6 +53,11 @@ type Entry struct {
 	// Modules can in principle have multiple entries
 	// with the same path.
 	Modules []Module `bigquery:"modules"`
+
+	// Affected holds the full osv.Affected records of the entry:
+	// the affected version ranges, and the affected packages and
+	// symbols of each module.
+	Affected []Affected `bigquery:"affected"`
 }
 
 func (e *Entry) SetUploadTime(t time.Time) { e.CreatedAt = t }
@@ -73,12 +81,48 @@ type Range struct {
 	Fixed      string `bigquery:"fixed"`
 }
 
+// Affected plays the role of osv.Affected.
+type Affected struct {
+	Module    string `bigquery:"module"`
+	Ecosystem string `bigquery:"ecosystem"`
+	// Ranges are the version ranges in which the module is affected.
+	Ranges []AffectedRange `bigquery:"ranges"`
+	// Packages are the affected packages of the module, from the
+	// ecosystem-specific imports.
+	Packages []Package `bigquery:"packages"`
+}
+
+// AffectedRange is an affected range of module versions: those at or
+// after Introduced and before Fixed. Unlike a Range, it holds both ends
+// of the range.
+//
+// The versions are as in the OSV entry, without a "v" prefix. The
+// sort fields encode them with version.ForSorting, so they can be
+// compared to the sort_version columns of other tables in SQL.
+type AffectedRange struct {
+	// Introduced is empty if the range starts at the first version.
+	Introduced     string `bigquery:"introduced"`
+	IntroducedSort string `bigquery:"introduced_sort"`
+	// Fixed is empty if no version is fixed.
+	Fixed     string `bigquery:"fixed"`
+	FixedSort string `bigquery:"fixed_sort"`
+}
+
+// Package plays the role of osv.Package.
+type Package struct {
+	Path    string   `bigquery:"path"`
+	GOOS    []string `bigquery:"goos"`
+	GOARCH  []string `bigquery:"goarch"`
+	Symbols []string `bigquery:"symbols"`
+}
+
 func Convert(oe *osv.Entry) *Entry {
 	e := &Entry{
 		ID:            oe.ID,
 		ModifiedTime:  oe.Modified,
 		PublishedTime: oe.Published,
 		Modules:       modules(oe),
+		Affected:      affected(oe),
 	}
 	if oe.Withdrawn != nil {
 		e.WithdrawnTime = *oe.Withdrawn
@@ -110,9 +154,79 @@ func ranges(a osv.Affected) []Range {
 	return rs
 }
 
+func affected(oe *osv.Entry) []Affected {
+	var as []Affected
+	for _, a := range oe.Affected {
+		aff := Affected{
+			Module:    a.Module.Path,
+			Ecosystem: string(a.Module.Ecosystem),
+			Ranges:    affectedRanges(a),
+		}
+		for _, p := range a.EcosystemSpecific.Packages {
+			aff.Packages = append(aff.Packages, Package{
+				Path:    p.Path,
+				GOOS:    p.GOOS,
+				GOARCH:  p.GOARCH,
+				Symbols: p.Symbols,
+			})
+		}
+		as = append(as, aff)
+	}
+	return as
+}
+
+// affectedRanges pairs the sorted introduced and fixed events of the
+// ranges of a.
+func affectedRanges(a osv.Affected) []AffectedRange {
+	var (
+		rs         []AffectedRange
+		introduced string
+		open       bool // an introduced event has not yet been fixed
+	)
+	add := func(introduced, fixed string) {
+		if introduced == "0" {
+			introduced = ""
+		}
+		rs = append(rs, AffectedRange{
+			Introduced:     introduced,
+			IntroducedSort: sortVersion(introduced),
+			Fixed:          fixed,
+			FixedSort:      sortVersion(fixed),
+		})
+	}
+	for _, r := range a.Ranges {
+		for _, e := range r.Events {
+			switch {
+			case e.Introduced != "":
+				if open {
+					add(introduced, "")
+				}
+				introduced, open = e.Introduced, true
+			case e.Fixed != "":
+				add(introduced, e.Fixed)
+				introduced, open = "", false
+			}
+		}
+		if open {
+			add(introduced, "")
+			introduced, open = "", false
+		}
+	}
+	return rs
+}
+
+// sortVersion returns the version.ForSorting encoding of v, an OSV
+// version, or "" if v is empty or not a valid semantic version.
+func sortVersion(v string) string {
+	if v == "" || !semver.IsValid("v"+v) {
+		return ""
+	}
+	return version.ForSorting("v" + v)
+}
+
 // ReadMostRecentDB returns entries from the table that reflect the
 // most recent state of the vulnerability database at c.
-func ReadMostRecentDB(ctx context.Context, c *bigquery.Client) (entries []*Entry, err error) {
+func ReadMostRecentDB(ctx context.Context, c bigquery.DB) (entries []*Entry, err error) {
 	defer derrors.Wrap(&err, "ReadMostRecentDB")
 
 	// The server does not create vulndb table since it lives
@@ -140,3 +254,33 @@ func ReadMostRecentDB(ctx context.Context, c *bigquery.Client) (entries []*Entry
 	}
 	return entries, nil
 }
+
+// ReadLastModifiedTime returns the most recent modified time of the
+// entries in the table at c, or the zero time if there are none.
+func ReadLastModifiedTime(ctx context.Context, c bigquery.DB) (_ time.Time, err error) {
+	defer derrors.Wrap(&err, "ReadLastModifiedTime")
+
+	// See ReadMostRecentDB.
+	if _, err := c.CreateOrUpdateTable(ctx, TableName); err != nil {
+		return time.Time{}, err
+	}
+	iter, err := c.Query(ctx, lastModifiedQuery(c.FullTableName(TableName)))
+	if err != nil {
+		return time.Time{}, err
+	}
+	type row struct {
+		ModifiedTime bq.NullTimestamp `bigquery:"modified_time"`
+	}
+	rows, err := bigquery.All[row](iter)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(rows) == 0 || !rows[0].ModifiedTime.Valid {
+		return time.Time{}, nil
+	}
+	return rows[0].ModifiedTime.Timestamp, nil
+}
+
+func lastModifiedQuery(fullTableName string) string {
+	return "SELECT MAX(modified_time) AS modified_time FROM `" + fullTableName + "`"
+}
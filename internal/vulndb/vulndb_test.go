@@ -10,9 +10,11 @@ import (
 	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	"golang.org/x/pkgsite-metrics/internal/bigquery"
 	"golang.org/x/pkgsite-metrics/internal/osv"
 	test "golang.org/x/pkgsite-metrics/internal/testing"
+	"golang.org/x/pkgsite-metrics/internal/version"
 )
 
 func TestConvert(t *testing.T) {
@@ -20,7 +22,13 @@ func TestConvert(t *testing.T) {
 		ID: "a",
 		Affected: []osv.Affected{
 			{Module: osv.Module{Path: "example.mod/a"}, Ranges: []osv.Range{{Events: []osv.RangeEvent{{Introduced: "0"}, {Fixed: "0.9.0"}}}}},
-			{Module: osv.Module{Path: "a.example.mod/a"}, Ranges: []osv.Range{{Events: []osv.RangeEvent{{Introduced: "1.0.0"}, {Fixed: "2.0.0"}}}}},
+			{
+				Module: osv.Module{Path: "a.example.mod/a", Ecosystem: osv.GoEcosystem},
+				Ranges: []osv.Range{{Events: []osv.RangeEvent{{Introduced: "1.0.0"}, {Fixed: "2.0.0"}}}},
+				EcosystemSpecific: osv.EcosystemSpecific{Packages: []osv.Package{
+					{Path: "a.example.mod/a/p", GOOS: []string{"windows"}, Symbols: []string{"F", "T.M"}},
+				}},
+			},
 		}}
 	want := &Entry{
 		ID: "a",
@@ -34,6 +42,23 @@ func TestConvert(t *testing.T) {
 				Ranges: []Range{{Introduced: "1.0.0"}, {Fixed: "2.0.0"}},
 			},
 		},
+		Affected: []Affected{
+			{
+				Module: "example.mod/a",
+				Ranges: []AffectedRange{{Fixed: "0.9.0", FixedSort: version.ForSorting("v0.9.0")}},
+			},
+			{
+				Module:    "a.example.mod/a",
+				Ecosystem: "Go",
+				Ranges: []AffectedRange{{
+					Introduced:     "1.0.0",
+					IntroducedSort: version.ForSorting("v1.0.0"),
+					Fixed:          "2.0.0",
+					FixedSort:      version.ForSorting("v2.0.0"),
+				}},
+				Packages: []Package{{Path: "a.example.mod/a/p", GOOS: []string{"windows"}, Symbols: []string{"F", "T.M"}}},
+			},
+		},
 	}
 	got := Convert(oe)
 	if diff := cmp.Diff(want, got); diff != "" {
@@ -41,6 +66,63 @@ func TestConvert(t *testing.T) {
 	}
 }
 
+func TestAffectedRanges(t *testing.T) {
+	events := func(es ...string) osv.Range {
+		var r osv.Range
+		for i, e := range es {
+			if i%2 == 0 {
+				r.Events = append(r.Events, osv.RangeEvent{Introduced: e})
+			} else {
+				r.Events = append(r.Events, osv.RangeEvent{Fixed: e})
+			}
+		}
+		return r
+	}
+	for _, test := range []struct {
+		name   string
+		ranges []osv.Range
+		want   []AffectedRange
+	}{
+		{"none", nil, nil},
+		{"unfixed", []osv.Range{events("1.2.0")}, []AffectedRange{{Introduced: "1.2.0"}}},
+		{"two", []osv.Range{events("0", "1.0.0", "1.1.0", "1.1.3")}, []AffectedRange{
+			{Fixed: "1.0.0"},
+			{Introduced: "1.1.0", Fixed: "1.1.3"},
+		}},
+		{"two ranges", []osv.Range{events("0", "1.0.0"), events("2.0.0")}, []AffectedRange{
+			{Fixed: "1.0.0"},
+			{Introduced: "2.0.0"},
+		}},
+		{"fixed only", []osv.Range{{Events: []osv.RangeEvent{{Fixed: "1.0.0"}}}}, []AffectedRange{{Fixed: "1.0.0"}}},
+		{"introduced twice", []osv.Range{{Events: []osv.RangeEvent{{Introduced: "1.0.0"}, {Introduced: "1.5.0"}}}}, []AffectedRange{
+			{Introduced: "1.0.0"},
+			{Introduced: "1.5.0"},
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got := affectedRanges(osv.Affected{Ranges: test.ranges})
+			if diff := cmp.Diff(test.want, got, cmpopts.IgnoreFields(AffectedRange{}, "IntroducedSort", "FixedSort")); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSortVersion(t *testing.T) {
+	for _, test := range []struct {
+		v, want string
+	}{
+		{"", ""},
+		{"1.2.3", version.ForSorting("v1.2.3")},
+		{"0.0.0-20230101000000-abcdef123456", version.ForSorting("v0.0.0-20230101000000-abcdef123456")},
+		{"not.a.version", ""},
+	} {
+		if got := sortVersion(test.v); got != test.want {
+			t.Errorf("sortVersion(%q) = %q, want %q", test.v, got, test.want)
+		}
+	}
+}
+
 func TestReadMostRecentDB(t *testing.T) {
 	test.NeedsIntegrationEnv(t)
 
@@ -86,4 +168,11 @@ func TestReadMostRecentDB(t *testing.T) {
 			t.Fatalf("want last modified time %v; got %v", lmt, e.ModifiedTime)
 		}
 	}
+	last, err := ReadLastModifiedTime(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := last.Sub(lmt); d < -time.Millisecond || d > time.Millisecond {
+		t.Errorf("ReadLastModifiedTime: got %v, want %v", last, lmt)
+	}
 }
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"time"
 
+	bq "cloud.google.com/go/bigquery"
 	"cloud.google.com/go/civil"
 	"golang.org/x/pkgsite-metrics/internal/bigquery"
 	"golang.org/x/pkgsite-metrics/internal/derrors"
@@ -19,6 +20,8 @@ const (
 	DatasetName             = "vulndb"
 	RequestCountTableName   = "requests"
 	IPRequestCountTableName = "ip-requests"
+	// Per-country counts, from the load balancer's client region.
+	CountryRequestCountTableName = "country-requests"
 )
 
 func init() {
@@ -32,13 +35,22 @@ func init() {
 		panic(err)
 	}
 	bigquery.AddTable(IPRequestCountTableName, s)
+	s, err = bigquery.InferSchema(CountryRequestCount{})
+	if err != nil {
+		panic(err)
+	}
+	bigquery.AddTable(CountryRequestCountTableName, s)
 }
 
 // RequestCount holds the number of requests made on a date.
 type RequestCount struct {
 	CreatedAt time.Time  `bigquery:"created_at"`
 	Date      civil.Date `bigquery:"date"` // year-month-day without a timezone
-	Count     int        `bigquery:"count"`
+	// Count omits requests from known bots and health checkers.
+	Count int `bigquery:"count"`
+	// RawCount is the number of all requests, including those omitted
+	// from Count. It is null for dates computed before exclusions existed.
+	RawCount bq.NullInt64 `bigquery:"raw_count"`
 }
 
 // SetUploadTime is used by Client.Upload.
@@ -55,8 +67,19 @@ type IPRequestCount struct {
 // SetUploadTime is used by Client.Upload.
 func (r *IPRequestCount) SetUploadTime(t time.Time) { r.CreatedAt = t }
 
+// CountryRequestCount holds the number of requests from a single country on a date.
+type CountryRequestCount struct {
+	CreatedAt time.Time  `bigquery:"created_at"`
+	Date      civil.Date `bigquery:"date"`    // year-month-day without a timezone
+	Country   string     `bigquery:"country"` // Unicode CLDR region code, or unknownCountry
+	Count     int        `bigquery:"count"`
+}
+
+// SetUploadTime is used by Client.Upload.
+func (r *CountryRequestCount) SetUploadTime(t time.Time) { r.CreatedAt = t }
+
 // writeToBigQuery writes request counts to BigQuery.
-func writeToBigQuery(ctx context.Context, client *bigquery.Client, rcs []*RequestCount, ircs []*IPRequestCount) (err error) {
+func writeToBigQuery(ctx context.Context, client bigquery.DB, rcs []*RequestCount, ircs []*IPRequestCount, crcs []*CountryRequestCount) (err error) {
 	defer derrors.Wrap(&err, "vulndbreqs.writeToBigQuery")
 	if _, err := client.CreateOrUpdateTable(ctx, RequestCountTableName); err != nil {
 		return err
@@ -67,21 +90,48 @@ func writeToBigQuery(ctx context.Context, client *bigquery.Client, rcs []*Reques
 	if _, err := client.CreateOrUpdateTable(ctx, IPRequestCountTableName); err != nil {
 		return err
 	}
-	return bigquery.UploadMany(ctx, client, IPRequestCountTableName, ircs, 0)
+	if err := bigquery.UploadMany(ctx, client, IPRequestCountTableName, ircs, 0); err != nil {
+		return err
+	}
+	if _, err := client.CreateOrUpdateTable(ctx, CountryRequestCountTableName); err != nil {
+		return err
+	}
+	return bigquery.UploadMany(ctx, client, CountryRequestCountTableName, crcs, 0)
 }
 
 // ReadRequestCountsFromBigQuery returns daily counts for requests to the vuln DB, most recent first.
-func ReadRequestCountsFromBigQuery(ctx context.Context, client *bigquery.Client) (_ []*RequestCount, err error) {
+func ReadRequestCountsFromBigQuery(ctx context.Context, client bigquery.DB) (_ []*RequestCount, err error) {
 	defer derrors.Wrap(&err, "readFromBigQuery")
-	// Select the most recently inserted row for each date.
-	q := fmt.Sprintf("(%s) ORDER BY date DESC", bigquery.PartitionQuery{
-		From:        "`" + client.FullTableName(RequestCountTableName) + "`",
-		PartitionOn: "date",
-		OrderBy:     "created_at DESC",
-	})
-	iter, err := client.Query(ctx, q)
+	iter, err := client.Query(ctx, requestCountsQuery(client.FullTableName(RequestCountTableName), ""))
 	if err != nil {
 		return nil, err
 	}
 	return bigquery.All[RequestCount](iter)
 }
+
+// ReadRequestCounts returns daily counts for requests to the vuln DB
+// on the dates from through to, inclusive, most recent first.
+func ReadRequestCounts(ctx context.Context, client bigquery.DB, from, to civil.Date) (_ []*RequestCount, err error) {
+	defer derrors.Wrap(&err, "ReadRequestCounts(%s, %s)", from, to)
+	q := requestCountsQuery(client.FullTableName(RequestCountTableName), "date BETWEEN @from_date AND @to_date")
+	iter, err := client.Query(ctx, q,
+		bigquery.Param{Name: "from_date", Value: from},
+		bigquery.Param{Name: "to_date", Value: to})
+	if err != nil {
+		return nil, err
+	}
+	return bigquery.All[RequestCount](iter)
+}
+
+// requestCountsQuery returns the query used by ReadRequestCountsFromBigQuery
+// and ReadRequestCounts. If where is non-empty, only rows satisfying it
+// are considered.
+func requestCountsQuery(fullTableName, where string) string {
+	// Select the most recently inserted row for each date.
+	return fmt.Sprintf("(%s) ORDER BY date DESC", bigquery.PartitionQuery{
+		From:        "`" + fullTableName + "`",
+		PartitionOn: "date",
+		Where:       where,
+		OrderBy:     "created_at DESC",
+	})
+}
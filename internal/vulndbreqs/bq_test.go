@@ -6,6 +6,7 @@ package vulndbreqs
 
 import (
 	"context"
+	"strings"
 	"testing"
 	"time"
 
@@ -45,14 +46,17 @@ func TestBigQuery(t *testing.T) {
 		{Date: date(2022, 10, 3), IP: "B", Count: 3},
 		{Date: date(2022, 10, 4), IP: "C", Count: 4},
 	}
-	must(writeToBigQuery(ctx, client, sumRequestCounts(counts), counts))
+	crcs := []*CountryRequestCount{
+		{Date: date(2022, 10, 1), Country: "US", Count: 8},
+	}
+	must(writeToBigQuery(ctx, client, sumRequestCounts(counts), counts, crcs))
 	// Insert duplicates with a later time; we expect to get these, not the originals.
 	time.Sleep(50 * time.Millisecond)
 	for _, row := range counts {
 		row.Count++
 	}
 	want := sumRequestCounts(counts)
-	must(writeToBigQuery(ctx, client, want, counts))
+	must(writeToBigQuery(ctx, client, want, counts, crcs))
 
 	got, err := ReadRequestCountsFromBigQuery(ctx, client)
 	if err != nil {
@@ -62,4 +66,79 @@ func TestBigQuery(t *testing.T) {
 	if diff := cmp.Diff(want, got, cmpopts.IgnoreFields(RequestCount{}, "CreatedAt")); diff != "" {
 		t.Errorf("mismatch (-want, +got):\n%s", diff)
 	}
+
+	got, err = ReadRequestCounts(ctx, client, date(2022, 10, 2), date(2022, 10, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = want[1:2] // only 2022-10-03
+	if diff := cmp.Diff(want, got, cmpopts.IgnoreFields(RequestCount{}, "CreatedAt")); diff != "" {
+		t.Errorf("ReadRequestCounts mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestRequestCountsQuery(t *testing.T) {
+	for _, test := range []struct {
+		where string
+		want  string
+	}{
+		{
+			"",
+			"( SELECT * EXCEPT (rownum) FROM ( SELECT *, ROW_NUMBER() OVER ( PARTITION BY date ORDER BY created_at DESC ) AS rownum FROM `p.d.requests` ) WHERE rownum = 1 ) ORDER BY date DESC",
+		},
+		{
+			"date BETWEEN @from_date AND @to_date",
+			"( SELECT * EXCEPT (rownum) FROM ( SELECT *, ROW_NUMBER() OVER ( PARTITION BY date ORDER BY created_at DESC ) AS rownum FROM `p.d.requests` WHERE date BETWEEN @from_date AND @to_date ) WHERE rownum = 1 ) ORDER BY date DESC",
+		},
+	} {
+		got := strings.Join(strings.Fields(requestCountsQuery("p.d.requests", test.where)), " ")
+		if got != test.want {
+			t.Errorf("where %q:\ngot  %s\nwant %s", test.where, got, test.want)
+		}
+	}
+}
+
+func TestBigQueryFake(t *testing.T) {
+	ctx := context.Background()
+	client := bigquery.NewFake()
+
+	date := func(y, m, d int) civil.Date {
+		return civil.Date{Year: y, Month: time.Month(m), Day: d}
+	}
+	counts := []*IPRequestCount{
+		{Date: date(2022, 10, 1), IP: "A", Count: 1},
+		{Date: date(2022, 10, 3), IP: "B", Count: 3},
+	}
+	crcs := []*CountryRequestCount{
+		{Date: date(2022, 10, 1), Country: "US", Count: 8},
+	}
+	rcs := sumRequestCounts(counts)
+	if err := writeToBigQuery(ctx, client, rcs, counts, crcs); err != nil {
+		t.Fatal(err)
+	}
+	for table, want := range map[string]int{
+		RequestCountTableName:        len(rcs),
+		IPRequestCountTableName:      len(counts),
+		CountryRequestCountTableName: len(crcs),
+	} {
+		if got := len(client.Rows(table)); got != want {
+			t.Errorf("%s: got %d rows, want %d", table, got, want)
+		}
+	}
+
+	from, to := date(2022, 10, 2), date(2022, 10, 3)
+	client.QueryFunc = func(q string, params []bigquery.Param) ([]any, error) {
+		want := []bigquery.Param{{Name: "from_date", Value: from}, {Name: "to_date", Value: to}}
+		if diff := cmp.Diff(want, params); diff != "" {
+			t.Errorf("params mismatch (-want, +got):\n%s", diff)
+		}
+		return []any{rcs[1]}, nil
+	}
+	got, err := ReadRequestCounts(ctx, client, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(rcs[1:2], got); diff != "" {
+		t.Errorf("ReadRequestCounts mismatch (-want, +got):\n%s", diff)
+	}
 }
@@ -19,7 +19,9 @@ import (
 	"sync"
 	"time"
 
+	bq "cloud.google.com/go/bigquery"
 	"cloud.google.com/go/civil"
+	"cloud.google.com/go/logging"
 	"cloud.google.com/go/logging/logadmin"
 	"cloud.google.com/go/storage"
 	"golang.org/x/pkgsite-metrics/internal/bigquery"
@@ -27,6 +29,7 @@ import (
 	"golang.org/x/pkgsite-metrics/internal/log"
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/api/iterator"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
 var (
@@ -40,8 +43,9 @@ var (
 )
 
 // ComputeAndStore computes Vuln DB request counts from the last date we have
-// data for, and writes them to BigQuery.
-func ComputeAndStore(ctx context.Context, vulndbBucketProjectID string, client *bigquery.Client, hmacKey []byte) error {
+// data for, and writes them to BigQuery. Requests excluded by ex are not
+// counted, except in the raw count.
+func ComputeAndStore(ctx context.Context, vulndbBucketProjectID string, client bigquery.DB, hmacKey []byte, ex *Exclusions) error {
 	rcs, err := ReadRequestCountsFromBigQuery(ctx, client)
 	if err != nil {
 		return err
@@ -56,7 +60,7 @@ func ComputeAndStore(ctx context.Context, vulndbBucketProjectID string, client *
 	// Compute one day at a time, so if it fails after a few days we at least make some progress.
 	for d := startDate; d.Before(today); d = d.AddDays(1) {
 		if !have[d] {
-			if err := ComputeAndStoreDate(ctx, vulndbBucketProjectID, client, hmacKey, d); err != nil {
+			if err := ComputeAndStoreDate(ctx, vulndbBucketProjectID, client, hmacKey, ex, d); err != nil {
 				return err
 			}
 		}
@@ -66,20 +70,24 @@ func ComputeAndStore(ctx context.Context, vulndbBucketProjectID string, client *
 
 // ComputeAndStoreDate computes the request counts for the given date and writes them to BigQuery.
 // It does so even if there is already stored information for that date.
-func ComputeAndStoreDate(ctx context.Context, vulndbBucketProjectID string, client *bigquery.Client, hmacKey []byte, date civil.Date) error {
-	ircs, err := Compute(ctx, vulndbBucketProjectID, date, hmacKey)
+func ComputeAndStoreDate(ctx context.Context, vulndbBucketProjectID string, client bigquery.DB, hmacKey []byte, ex *Exclusions, date civil.Date) error {
+	ircs, crcs, raw, err := Compute(ctx, vulndbBucketProjectID, date, hmacKey, ex)
 	if err != nil {
 		return err
 	}
 	if len(ircs) == 0 {
 		ircs = []*IPRequestCount{{Date: date, IP: "NONE", Count: 0}}
 	}
+	if len(crcs) == 0 {
+		crcs = []*CountryRequestCount{{Date: date, Country: unknownCountry, Count: 0}}
+	}
 	count := 0
 	for _, rc := range ircs {
 		count += rc.Count
 	}
-	log.Infof(ctx, "writing request count %d for %s; %d distinct IPs", count, date, len(ircs))
-	return writeToBigQuery(ctx, client, []*RequestCount{{Date: date, Count: count}}, ircs)
+	log.Infof(ctx, "writing request count %d (%d before exclusions) for %s; %d distinct IPs", count, raw, date, len(ircs))
+	rc := &RequestCount{Date: date, Count: count, RawCount: bq.NullInt64{Int64: int64(raw), Valid: true}}
+	return writeToBigQuery(ctx, client, []*RequestCount{rc}, ircs, crcs)
 }
 
 func sumRequestCounts(ircs []*IPRequestCount) []*RequestCount {
@@ -95,29 +103,33 @@ func sumRequestCounts(ircs []*IPRequestCount) []*RequestCount {
 }
 
 // Compute computes counts for all vuln DB requests on the given date.
-// It returns request counts grouped by obfuscated IP address.
-func Compute(ctx context.Context, vulndbBucketProjectID string, date civil.Date, hmacKey []byte) ([]*IPRequestCount, error) {
+// It returns request counts grouped by obfuscated IP address, and
+// grouped by the country of the client, neither of which include the
+// requests excluded by ex. It also returns the raw count of all requests,
+// including excluded ones.
+func Compute(ctx context.Context, vulndbBucketProjectID string, date civil.Date, hmacKey []byte, ex *Exclusions) ([]*IPRequestCount, []*CountryRequestCount, int, error) {
 	if date.Before(gcsStartDate) {
-		return computeFromLogs(ctx, vulndbBucketProjectID, date, hmacKey, 0)
+		return computeFromLogs(ctx, vulndbBucketProjectID, date, hmacKey, ex, 0)
 	}
-	return computeFromStorage(ctx, date, hmacKey, 0)
+	return computeFromStorage(ctx, date, hmacKey, ex, 0)
 }
 
 // computeFromLogs queries the vulndb load balancer logs for all vuln DB
 // requests on the given date. It returns request counts for the date.
 // If limit is positive, it reads no more than limit entries from the log (for testing only).
-func computeFromLogs(ctx context.Context, vulndbBucketProjectID string, date civil.Date, hmacKey []byte, limit int) ([]*IPRequestCount, error) {
+func computeFromLogs(ctx context.Context, vulndbBucketProjectID string, date civil.Date, hmacKey []byte, ex *Exclusions, limit int) ([]*IPRequestCount, []*CountryRequestCount, int, error) {
 	if len(hmacKey) < 16 {
-		return nil, errors.New("HMAC secret must be at least 16 bytes")
+		return nil, nil, 0, errors.New("HMAC secret must be at least 16 bytes")
 	}
 	log.Infof(ctx, "computing request counts for %s from logs", date)
 	client, err := logadmin.NewClient(ctx, vulndbBucketProjectID)
 	if err != nil {
-		return nil, err
+		return nil, nil, 0, err
 	}
 	defer client.Close()
 
-	counts := map[string]int{} // key is obfuscated IP address
+	counts := map[string]int{}        // key is obfuscated IP address
+	countryCounts := map[string]int{} // key is country
 
 	it := newEntryIterator(ctx, client,
 		// This filter has three sections, marked with blank lines. It is more
@@ -157,6 +169,7 @@ func computeFromLogs(ctx context.Context, vulndbBucketProjectID string, date civ
 	// (https://cloud.google.com/logging/docs/reference/v2/rpc/google.logging.v2#google.logging.v2.ListLogEntriesRequest).
 	var logErr error
 	n := 1
+	raw := 0
 	for {
 		entry, err := it.Next()
 		if err != nil {
@@ -165,11 +178,15 @@ func computeFromLogs(ctx context.Context, vulndbBucketProjectID string, date civ
 			}
 			break
 		}
-		ip := "NONE"
-		if r := entry.HTTPRequest; r != nil {
-			ip = obfuscate(r.RemoteIP, hmacKey)
+		raw++
+		if !entryExcluded(entry, ex) {
+			ip := "NONE"
+			if r := entry.HTTPRequest; r != nil {
+				ip = obfuscate(r.RemoteIP, hmacKey)
+			}
+			counts[ip]++
+			countryCounts[entryCountry(entry)]++
 		}
-		counts[ip]++
 		n++
 		if limit > 0 && n > limit {
 			break
@@ -177,45 +194,71 @@ func computeFromLogs(ctx context.Context, vulndbBucketProjectID string, date civ
 	}
 	if logErr != nil {
 		log.Errorf(ctx, logErr, "when reading load balancer logs, no progress")
-		return nil, logErr
+		return nil, nil, 0, logErr
 	}
 
-	return mapToCountSlice(counts, date), nil
+	return mapToCountSlice(counts, date), mapToCountryCountSlice(countryCounts, date), raw, nil
+}
+
+// entryExcluded reports whether ex excludes the request of a load balancer
+// log entry.
+func entryExcluded(entry *logging.Entry, ex *Exclusions) bool {
+	r := entry.HTTPRequest
+	if r == nil {
+		return false
+	}
+	var userAgent string
+	if r.Request != nil {
+		userAgent = r.Request.UserAgent()
+	}
+	return ex.Excludes(userAgent, r.RemoteIP)
+}
+
+// entryCountry returns the client country of a load balancer log entry,
+// or unknownCountry if it is not present.
+func entryCountry(entry *logging.Entry) string {
+	if p, ok := entry.Payload.(*structpb.Struct); ok {
+		if v := p.GetFields()[clientRegionField]; v != nil && v.GetStringValue() != "" {
+			return v.GetStringValue()
+		}
+	}
+	return unknownCountry
 }
 
 // computeFromStorage counts requests for the given date from the files in the
 // vulndb logs bucket.
 // If maxFiles is positive, only that many files are read (for testing).
-func computeFromStorage(ctx context.Context, date civil.Date, hmacKey []byte, maxFiles int) (_ []*IPRequestCount, err error) {
+func computeFromStorage(ctx context.Context, date civil.Date, hmacKey []byte, ex *Exclusions, maxFiles int) (_ []*IPRequestCount, _ []*CountryRequestCount, _ int, err error) {
 	defer derrors.Wrap(&err, "computeFromStorage(%s)", date)
 
 	log.Infof(ctx, "computing request counts for %s from storage bucket", date)
 	client, err := storage.NewClient(ctx)
 	if err != nil {
-		return nil, err
+		return nil, nil, 0, err
 	}
 	defer client.Close()
 	bucketName := os.Getenv("GOOGLE_CLOUD_PROJECT") + bucketSuffix
 	bucket := client.Bucket(bucketName)
 	names, err := objectNamesForDate(ctx, bucket, logPrefix, date)
 	if err != nil {
-		return nil, err
+		return nil, nil, 0, err
 	}
 	if maxFiles > 0 && len(names) > maxFiles {
 		names = names[:maxFiles]
 	}
 
-	byDate, byIP, err := countLogsForObjects(ctx, bucket, names, hmacKey)
+	byDate, byIP, byCountry, err := countLogsForObjects(ctx, bucket, names, hmacKey, ex)
 	if err != nil {
-		return nil, err
+		return nil, nil, 0, err
 	}
 	if len(byDate) != 1 {
-		return nil, fmt.Errorf("got %d dates, want 1", len(byDate))
+		return nil, nil, 0, fmt.Errorf("got %d dates, want 1", len(byDate))
 	}
-	if _, present := byDate[date]; !present {
-		return nil, fmt.Errorf("no data for %s", date)
+	raw, present := byDate[date]
+	if !present {
+		return nil, nil, 0, fmt.Errorf("no data for %s", date)
 	}
-	return mapToCountSlice(byIP, date), nil
+	return mapToCountSlice(byIP, date), mapToCountryCountSlice(byCountry, date), raw, nil
 }
 
 // mapToCountSlice Converts the map to a slice of IPRequestCounts.
@@ -227,23 +270,37 @@ func mapToCountSlice(countsByIP map[string]int, date civil.Date) []*IPRequestCou
 	return ircs
 }
 
+// mapToCountryCountSlice converts the map to a slice of CountryRequestCounts.
+func mapToCountryCountSlice(countsByCountry map[string]int, date civil.Date) []*CountryRequestCount {
+	var crcs []*CountryRequestCount
+	for c, count := range countsByCountry {
+		crcs = append(crcs, &CountryRequestCount{Date: date, Country: c, Count: count})
+	}
+	return crcs
+}
+
 // countLogsForObjects reads the JSON log files given by objNames from the bucket
-// and sums their entries by date and obfuscated IP.
-func countLogsForObjects(ctx context.Context, bucket *storage.BucketHandle, objNames []string, hmacKey []byte) (
-	byDate map[civil.Date]int, byIP map[string]int, err error) {
+// and sums their entries by date, obfuscated IP and country. The counts by
+// date include all entries; the others omit the entries excluded by ex.
+func countLogsForObjects(ctx context.Context, bucket *storage.BucketHandle, objNames []string, hmacKey []byte, ex *Exclusions) (
+	byDate map[civil.Date]int, byIP, byCountry map[string]int, err error) {
 
 	if len(objNames) == 0 {
-		return nil, nil, nil
+		return nil, nil, nil, nil
 	}
 	defer derrors.Wrap(&err, "countLogsForObjects(%q, ...[%d in total])", objNames[0], len(objNames))
 
 	var mu sync.Mutex
 	byDate = map[civil.Date]int{}
 	byIP = map[string]int{}
+	byCountry = map[string]int{}
 	update := func(e *logEntry) error {
 		mu.Lock()
 		byDate[civil.DateOf(e.Timestamp)]++
-		byIP[e.HTTPRequest.RemoteIP]++
+		if !e.excluded {
+			byIP[e.HTTPRequest.RemoteIP]++
+			byCountry[e.country()]++
+		}
 		mu.Unlock()
 		return nil
 	}
@@ -262,7 +319,7 @@ func countLogsForObjects(ctx context.Context, bucket *storage.BucketHandle, objN
 					return err
 				}
 				defer r.Close()
-				if err := readJSONLogEntries(name, r, hmacKey, update); err != nil {
+				if err := readJSONLogEntries(name, r, hmacKey, ex, update); err != nil {
 					return err
 				}
 			}
@@ -270,9 +327,9 @@ func countLogsForObjects(ctx context.Context, bucket *storage.BucketHandle, objN
 		})
 	}
 	if err := g.Wait(); err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
-	return byDate, byIP, nil
+	return byDate, byIP, byCountry, nil
 }
 
 // Suffix to append to project name to get the name of the logs bucket.
@@ -308,14 +365,40 @@ func objectNamesForDate(ctx context.Context, bucket *storage.BucketHandle, logPr
 type logEntry struct {
 	Timestamp   time.Time `json:"timestamp"`
 	HTTPRequest struct {
-		RemoteIP string `json:"remoteIp"`
+		RemoteIP  string `json:"remoteIp"`
+		UserAgent string `json:"userAgent"`
 	} `json:"httpRequest"`
+	JSONPayload struct {
+		ClientRegion string `json:"clientRegion"`
+	} `json:"jsonPayload"`
+
+	// excluded reports whether the request should not be counted.
+	excluded bool
+}
+
+const (
+	// clientRegionField is the field of a load balancer log entry's JSON
+	// payload that holds the client's country, as a Unicode CLDR region
+	// code like "US". Only the country is recorded; nothing finer-grained.
+	clientRegionField = "clientRegion"
+
+	// unknownCountry is used for requests without a client region.
+	unknownCountry = "UNKNOWN"
+)
+
+// country returns the client country of the entry, or unknownCountry.
+func (e *logEntry) country() string {
+	if e.JSONPayload.ClientRegion == "" {
+		return unknownCountry
+	}
+	return e.JSONPayload.ClientRegion
 }
 
 // readJSONLogEntries reads the contents of r, which is named name and must consist of a sequence
 // of JSON objects each of which has the fields of a logEntry.
-// For each entry, after obfuscating the IP using hmacKey, it calls fn on the entry.
-func readJSONLogEntries(name string, r io.Reader, hmacKey []byte, fn func(e *logEntry) error) (err error) {
+// For each entry, after marking whether ex excludes it and obfuscating the IP
+// using hmacKey, it calls fn on the entry.
+func readJSONLogEntries(name string, r io.Reader, hmacKey []byte, ex *Exclusions, fn func(e *logEntry) error) (err error) {
 	defer derrors.Wrap(&err, "readJSONLogEntries(%s)", name)
 	dec := json.NewDecoder(r)
 	for dec.More() {
@@ -323,6 +406,7 @@ func readJSONLogEntries(name string, r io.Reader, hmacKey []byte, fn func(e *log
 		if err := dec.Decode(&e); err != nil {
 			return err
 		}
+		e.excluded = ex.Excludes(e.HTTPRequest.UserAgent, e.HTTPRequest.RemoteIP)
 		e.HTTPRequest.RemoteIP = obfuscate(e.HTTPRequest.RemoteIP, hmacKey)
 		if err := fn(&e); err != nil {
 			return err
@@ -32,7 +32,7 @@ func TestComputeFromLogs(t *testing.T) {
 	// Assume there are more than 10 requests a day.
 	yesterday := civil.DateOf(time.Now()).AddDays(-1)
 	const n = 10
-	igot, err := computeFromLogs(context.Background(), projID, yesterday, testHMACKey, n)
+	igot, _, _, err := computeFromLogs(context.Background(), projID, yesterday, testHMACKey, nil, n)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -51,7 +51,7 @@ func TestComputeFromStorage(t *testing.T) {
 
 	// Compute one day's counts, reading only 1 file.
 	// The file is always the same.
-	got, err := computeFromStorage(context.Background(), testDate, testHMACKey, 1)
+	got, _, _, err := computeFromStorage(context.Background(), testDate, testHMACKey, nil, 1)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -83,6 +83,11 @@ var (
 		obfuscate("5.6.7.8", testHMACKey):    2,
 		obfuscate("9.10.11.12", testHMACKey): 8,
 	}
+	testFileCountries = map[string]int{
+		"US":           3,
+		"DE":           2,
+		unknownCountry: 8,
+	}
 )
 
 func TestReadJSONLogEntries(t *testing.T) {
@@ -94,9 +99,11 @@ func TestReadJSONLogEntries(t *testing.T) {
 
 	gotDates := map[civil.Date]int{}
 	gotIPs := map[string]int{}
-	err = readJSONLogEntries("logfile.json", f, testHMACKey, func(e *logEntry) error {
+	gotCountries := map[string]int{}
+	err = readJSONLogEntries("logfile.json", f, testHMACKey, nil, func(e *logEntry) error {
 		gotDates[civil.DateOf(e.Timestamp)]++
 		gotIPs[e.HTTPRequest.RemoteIP]++
+		gotCountries[e.country()]++
 		return nil
 	})
 	if err != nil {
@@ -108,6 +115,39 @@ func TestReadJSONLogEntries(t *testing.T) {
 	if !maps.Equal(gotIPs, testFileIPs) {
 		t.Errorf("IPs:\ngot  %v\nwant %v", gotIPs, testFileIPs)
 	}
+	if !maps.Equal(gotCountries, testFileCountries) {
+		t.Errorf("countries:\ngot  %v\nwant %v", gotCountries, testFileCountries)
+	}
+}
+
+func TestReadJSONLogEntriesExclusions(t *testing.T) {
+	f, err := os.Open(filepath.Join("testdata", "logfile.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	ex, err := ParseExclusions([]string{"cidr 1.2.3.0/24"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, excluded := 0, 0
+	err = readJSONLogEntries("logfile.json", f, testHMACKey, ex, func(e *logEntry) error {
+		all++
+		if e.excluded {
+			excluded++
+			if want := obfuscate("1.2.3.4", testHMACKey); e.HTTPRequest.RemoteIP != want {
+				t.Errorf("excluded IP %s, want only %s", e.HTTPRequest.RemoteIP, want)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if all != 13 || excluded != 3 {
+		t.Errorf("got %d entries with %d excluded, want 13 with 3 excluded", all, excluded)
+	}
 }
 
 func TestCountFiles(t *testing.T) {
@@ -148,7 +188,7 @@ func TestCountFiles(t *testing.T) {
 	t.Run("CountLogsForObjects", func(t *testing.T) {
 		// The two files with the testPrefix are both copies of testdata/logfile.json.
 		objNames := []string{wantPrefix + "logfile1.json", wantPrefix + "logfile2.json"}
-		gotDates, gotIPs, err := countLogsForObjects(ctx, bucket, objNames, testHMACKey)
+		gotDates, gotIPs, _, err := countLogsForObjects(ctx, bucket, objNames, testHMACKey, nil)
 		if err != nil {
 			t.Fatal(err)
 		}
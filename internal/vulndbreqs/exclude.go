@@ -0,0 +1,105 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vulndbreqs
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strings"
+
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/scan"
+)
+
+// Exclusions describe requests that should not be counted, like those
+// of monitoring probes, health checkers and crawlers.
+// A nil *Exclusions excludes nothing.
+type Exclusions struct {
+	userAgents []*regexp.Regexp
+	prefixes   []netip.Prefix
+}
+
+// ParseExclusions parses exclusions from lines, each of which is one of
+//
+//	useragent REGEXP
+//	cidr PREFIX
+//
+// A request is excluded if its user agent matches any REGEXP, or its
+// remote IP is in any PREFIX, like 192.0.2.0/24 or 2001:db8::/32.
+func ParseExclusions(lines []string) (_ *Exclusions, err error) {
+	defer derrors.Wrap(&err, "ParseExclusions")
+
+	e := &Exclusions{}
+	for _, line := range lines {
+		kind, arg, ok := strings.Cut(line, " ")
+		arg = strings.TrimSpace(arg)
+		if !ok || arg == "" {
+			return nil, fmt.Errorf("bad exclusion %q: want KIND ARG", line)
+		}
+		switch kind {
+		case "useragent":
+			re, err := regexp.Compile(arg)
+			if err != nil {
+				return nil, err
+			}
+			e.userAgents = append(e.userAgents, re)
+		case "cidr":
+			p, err := netip.ParsePrefix(arg)
+			if err != nil {
+				return nil, err
+			}
+			e.prefixes = append(e.prefixes, p.Masked())
+		default:
+			return nil, fmt.Errorf("bad exclusion %q: unknown kind %q", line, kind)
+		}
+	}
+	return e, nil
+}
+
+// ReadExclusions reads and parses the exclusions in the local file or
+// gs://bucket/object URL at location. Blank lines and lines beginning
+// with '#' are ignored. If location is empty, it returns nil, which
+// excludes nothing.
+func ReadExclusions(ctx context.Context, location string) (_ *Exclusions, err error) {
+	defer derrors.Wrap(&err, "ReadExclusions(%q)", location)
+
+	if location == "" {
+		return nil, nil
+	}
+	lines, err := scan.ReadLines(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+	return ParseExclusions(lines)
+}
+
+// Excludes reports whether a request from the given user agent and
+// remote IP address should not be counted.
+func (e *Exclusions) Excludes(userAgent, remoteIP string) bool {
+	if e == nil {
+		return false
+	}
+	for _, re := range e.userAgents {
+		if re.MatchString(userAgent) {
+			return true
+		}
+	}
+	if len(e.prefixes) == 0 {
+		return false
+	}
+	addr, err := netip.ParseAddr(remoteIP)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range e.prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vulndbreqs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExcludes(t *testing.T) {
+	ex, err := ParseExclusions([]string{
+		"useragent ^GoogleHC/",
+		"useragent (?i)bot\\b",
+		"cidr 192.0.2.0/24",
+		"cidr 2001:db8::/32",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		userAgent, ip string
+		want          bool
+	}{
+		{"Go-http-client/2.0", "1.2.3.4", false},
+		{"GoogleHC/1.0", "1.2.3.4", true},
+		{"Robotics-client/1.0", "1.2.3.4", false},
+		{"Mozilla/5.0 (compatible; SomeBot 1.0)", "1.2.3.4", true},
+		{"Go-http-client/2.0", "192.0.2.77", true},
+		{"Go-http-client/2.0", "::ffff:192.0.2.77", true},
+		{"Go-http-client/2.0", "192.0.3.1", false},
+		{"Go-http-client/2.0", "2001:db8::1", true},
+		{"Go-http-client/2.0", "not-an-ip", false},
+	} {
+		if got := ex.Excludes(test.userAgent, test.ip); got != test.want {
+			t.Errorf("Excludes(%q, %q) = %t, want %t", test.userAgent, test.ip, got, test.want)
+		}
+	}
+
+	var none *Exclusions
+	if none.Excludes("GoogleHC/1.0", "192.0.2.1") {
+		t.Error("nil Exclusions excludes a request")
+	}
+}
+
+func TestParseExclusionsErrors(t *testing.T) {
+	for _, line := range []string{
+		"useragent",
+		"cidr 1.2.3.4",
+		"useragent (",
+		"ip 1.2.3.4",
+	} {
+		if _, err := ParseExclusions([]string{line}); err == nil {
+			t.Errorf("%q: got nil error, want error", line)
+		}
+	}
+}
+
+func TestReadExclusions(t *testing.T) {
+	ctx := context.Background()
+	ex, err := ReadExclusions(ctx, "")
+	if err != nil || ex != nil {
+		t.Fatalf("got (%v, %v), want (nil, nil)", ex, err)
+	}
+
+	file := filepath.Join(t.TempDir(), "exclusions.txt")
+	if err := os.WriteFile(file, []byte("# probes\nuseragent ^GoogleHC/\n\ncidr 192.0.2.0/24\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ex, err = ReadExclusions(ctx, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ex.userAgents) != 1 || len(ex.prefixes) != 1 {
+		t.Errorf("got %d user agents and %d prefixes, want 1 and 1", len(ex.userAgents), len(ex.prefixes))
+	}
+}
@@ -6,6 +6,7 @@ package worker
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
@@ -18,31 +19,42 @@ import (
 	"os/exec"
 	"path"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	bq "cloud.google.com/go/bigquery"
 	"cloud.google.com/go/storage"
+	"golang.org/x/exp/maps"
 	"golang.org/x/pkgsite-metrics/internal/analysis"
+	"golang.org/x/pkgsite-metrics/internal/audit"
 	"golang.org/x/pkgsite-metrics/internal/derrors"
 	"golang.org/x/pkgsite-metrics/internal/jobs"
 	"golang.org/x/pkgsite-metrics/internal/log"
+	"golang.org/x/pkgsite-metrics/internal/modules"
 	"golang.org/x/pkgsite-metrics/internal/queue"
 	"golang.org/x/pkgsite-metrics/internal/sandbox"
 	"golang.org/x/pkgsite-metrics/internal/scan"
 	"golang.org/x/pkgsite-metrics/internal/version"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 )
 
 type analysisServer struct {
 	*Server
 	openFile           openFileFunc // Used to open binary files from GCS, except for testing.
 	storedWorkVersions map[analysis.WorkVersionKey]analysis.WorkVersion
+	metadata           map[string]*analysis.Metadata // binary hash to metadata
 }
 
 func newAnalysisServer(ctx context.Context, s *Server) (*analysisServer, error) {
+	if s.cfg.LocalDir != "" {
+		return &analysisServer{
+			Server:             s,
+			openFile:           localOpenFileFunc(s.cfg.LocalDir),
+			storedWorkVersions: make(map[analysis.WorkVersionKey]analysis.WorkVersion),
+		}, nil
+	}
 	if s.cfg.BinaryBucket == "" {
 		return nil, errors.New("missing binary bucket (define GO_ECOSYSTEM_BINARY_BUCKET)")
 	}
@@ -68,6 +80,22 @@ func (s *analysisServer) handleScan(w http.ResponseWriter, r *http.Request) (err
 	if err != nil {
 		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
 	}
+	table := analysis.TableName
+	if req.Table != "" {
+		if !analysis.IsJobTable(req.Table) {
+			return fmt.Errorf("%w: analysis: %q is not a job table", derrors.InvalidArgument, req.Table)
+		}
+		// The table's schema may not be registered on this instance yet.
+		analysis.RegisterJobTable(req.Table)
+		table = req.Table
+	}
+	ctx = log.With(ctx, "jobID", req.JobID, "module", req.Module+"@"+req.Version, "binary", req.Binary)
+	ctx, bundle := log.StartBundle(ctx)
+	defer func() { bundle.Emit(ctx, "analysis scan finished", "success", err == nil) }()
+	// Analysis binaries do not report progress, so only their phases are
+	// known, and a long run is not a stall.
+	ctx, done := runningTasks.start(ctx, fmt.Sprintf("%s@%s analysis %s", req.Module, req.Version, req.Binary), false)
+	defer done()
 
 	// If there is a job and it's canceled, return immediately.
 	if req.JobID != "" && s.jobDB != nil {
@@ -80,49 +108,82 @@ func (s *analysisServer) handleScan(w http.ResponseWriter, r *http.Request) (err
 		}
 	}
 
-	// incrementJob increments name value by 1 for the current job.
+	// incrementJob adds in to the counters of the current job.
 	// If there is an error, it logs it instead of failing.
-	incrementJob := func(name string) {
-		if req.JobID != "" && s.jobDB != nil {
-			// There can be contention on updating job stats,
-			// in which case we retry it a few times.
-			retries := 0
-			for {
-				if err := s.jobDB.Increment(ctx, req.JobID, name, 1); err != nil {
-					if e := status.Code(err); e == codes.Aborted && retries < 5 {
-						time.Sleep(50 * time.Millisecond * (1 << retries))
-						retries++
-						continue
-					}
-					log.Errorf(ctx, err, "failed to update job for id %q", req.JobID)
-				}
-				return
-			}
+	incrementJob := func(in *jobs.Increments) {
+		if req.JobID == "" || s.jobCounters == nil {
+			return
+		}
+		if err := s.jobCounters.Increment(ctx, req.JobID, in); err != nil {
+			log.Errorf(ctx, err, "failed to update job for id %q", req.JobID)
 		}
 	}
 
-	incrementJob("NumStarted")
+	// The result row of the scan, if any, copied for the jobs that share
+	// the work.
+	var sharedRow *analysis.Result
+
+	// finishJobTask records the outcome of the task for the current job by
+	// incrementing name, and the count of errorCategory if it is non-empty.
+	// If this was the job's last task, it finalizes the job. It does the
+	// same for the jobs that share the task.
+	finishJobTask := func(name, errorCategory string) {
+		if req.JobID == "" || s.jobDB == nil {
+			return
+		}
+		// Both counts change in the same write, so the category is
+		// included if another task finalizes the job right after it.
+		var in jobs.Increments
+		in.Add(name, 1)
+		if errorCategory != "" {
+			in.AddErrorCategory(errorCategory, 1)
+		}
+		incrementJob(&in)
+		if _, err := s.finalizeJob(ctx, s.jobDB, req.JobID); err != nil {
+			log.Errorf(ctx, err, "failed to finalize job %q", req.JobID)
+		}
+		if req.Share {
+			s.finishSharedTask(ctx, req, table, sharedRow, &in)
+		}
+	}
+
+	var started jobs.Increments
+	started.Add("NumStarted", 1)
+	incrementJob(&started)
 
 	// Handle errors here.
 	defer func() {
 		if err != nil {
-			incrementJob("NumFailed")
+			finishJobTask("NumFailed", derrors.CodeOf(err).Category())
 		}
 	}()
 
 	if req.Suffix != "" {
 		return fmt.Errorf("%w: analysis: only implemented for whole modules (no suffix)", derrors.InvalidArgument)
 	}
-	if req.Binary == "" {
-		return fmt.Errorf("%w: analysis: missing binary", derrors.InvalidArgument)
+
+	// Shed the tasks of modules whose scans keep failing pathologically.
+	// Modules of private corpora are not those of the proxy, so they have
+	// no streaks.
+	trackStreak := req.JobID != "" && req.PrivateCorpus == "" && s.jobDB != nil
+	if limit, _ := s.softSkipConfig(); limit == 0 {
+		trackStreak = false
 	}
-	if req.Binary != path.Base(req.Binary) {
-		return fmt.Errorf("%w: analysis: binary name contains slashes (must be a basename)", derrors.InvalidArgument)
+	streakKey := jobs.StreakKey{Module: req.Module, Version: req.Version, BinaryVersion: req.BinaryVersion}
+	var streak *jobs.ModuleStreak
+	if trackStreak {
+		streak, err = s.jobDB.GetModuleStreak(ctx, streakKey)
+		if err != nil {
+			log.Errorf(ctx, err, "reading the failure streak of %s", req.Module)
+		} else if streak.Skipped(time.Now()) {
+			log.Infof(ctx, "skipping (%s)", streak.SkipReason)
+			finishJobTask("NumSkipped", "")
+			return nil
+		}
 	}
-	localBinaryPath := path.Join(s.cfg.BinaryDir, req.Binary)
-	srcPath := path.Join(analysisBinariesBucketDir, req.Binary)
-	const executable = true
-	if err := copyToLocalFile(localBinaryPath, executable, srcPath, s.openFile); err != nil {
+
+	localBinaryPath, err := s.copyBinary(req.Binary)
+	if err != nil {
 		return err
 	}
 	defer derrors.Cleanup(&err, func() error { return os.Remove(localBinaryPath) })
@@ -135,46 +196,185 @@ func (s *analysisServer) handleScan(w http.ResponseWriter, r *http.Request) (err
 		return fmt.Errorf("%w: analysis: for binary %s, hash of download file %s does not match hash in request %s",
 			derrors.InvalidArgument, req.Binary, binaryHash, req.BinaryVersion)
 	}
-	wv := analysis.WorkVersion{
-		BinaryArgs:    req.Args,
-		WorkerVersion: s.cfg.VersionID,
-		SchemaVersion: analysis.SchemaVersion,
-		BinaryVersion: binaryHash,
-	}
-
-	if err := s.readWorkVersion(ctx, req.Module, req.Version, req.Binary); err != nil {
+	wv, err := s.workVersion(req, binaryHash)
+	if err != nil {
 		return err
 	}
-	key := analysis.WorkVersionKey{Module: req.Module, Version: req.Version, Binary: req.Binary}
-	if wv == s.storedWorkVersions[key] {
-		log.Infof(ctx, "skipping (work version unchanged): %+v", key)
-		incrementJob("NumSkipped")
-		return nil
+
+	// Work versions are those of the analysis table, so a job with a table
+	// of its own scans every module. They are recorded per module, not per
+	// subdirectory, so scans of a subdirectory are never skipped.
+	if table == analysis.TableName && req.Subdir == "" {
+		// The labels were checked by ParseScanRequest.
+		labels, _ := scan.ParseLabels(req.Labels)
+		key := analysis.WorkVersionKey{Module: req.Module, Version: req.Version, Binary: req.Binary, Labels: scan.FormatLabels(labels)}
+		if err := s.readWorkVersion(ctx, key); err != nil {
+			return err
+		}
+		if wv == s.storedWorkVersions[key] {
+			log.Infof(ctx, "skipping (work version unchanged): %+v", key)
+			finishJobTask("NumSkipped", "")
+			return nil
+		}
 	}
 
 	row := s.scan(ctx, req, localBinaryPath, wv)
-	if err := writeResult(ctx, req.Serve, w, s.bqClient, analysis.TableName, row); err != nil {
+	if trackStreak {
+		var code derrors.ErrorCode
+		if row.Error != "" {
+			code = derrors.ErrorCode(row.ErrorCode.Int64)
+		}
+		s.updateModuleStreak(ctx, s.jobDB, streak, streakKey, req.JobID, code)
+	}
+	if err := writeResult(ctx, req.Serve, w, s.bqClient, s.localResults, table, row); err != nil {
 		return err
 	}
+	sharedRow = row
 	if row.Error != "" {
-		incrementJob("NumErrored")
+		finishJobTask("NumErrored", row.ErrorCategory)
 	} else {
-		incrementJob("NumSucceeded")
+		finishJobTask("NumSucceeded", "")
+	}
+	return nil
+}
+
+// finishSharedTask records the outcome of the task of req, the increments
+// in, for the jobs that share its work, and writes a copy of its result
+// row, if any, with the ID of each of them. It records them only once, so
+// a retried task records only its first outcome. Failures are only logged.
+func (s *analysisServer) finishSharedTask(ctx context.Context, req *analysis.ScanRequest, table string, row *analysis.Result, in *jobs.Increments) {
+	sharers, err := s.jobDB.FinishClaim(ctx, req.WorkKey(), req.JobID)
+	if err != nil {
+		log.Errorf(ctx, err, "finishing the shared work of job %q", req.JobID)
+		return
+	}
+	for _, id := range sharers {
+		if row != nil {
+			r := *row
+			r.JobID = bq.NullString{StringVal: id, Valid: true}
+			if err := writeResult(ctx, false, nil, s.bqClient, s.localResults, table, &r); err != nil {
+				log.Errorf(ctx, err, "writing the result of the shared work of job %q", id)
+			}
+		}
+		if s.jobCounters != nil {
+			if err := s.jobCounters.Increment(ctx, id, in); err != nil {
+				log.Errorf(ctx, err, "failed to update job for id %q", id)
+			}
+		}
+		if _, err := s.finalizeJob(ctx, s.jobDB, id); err != nil {
+			log.Errorf(ctx, err, "failed to finalize job %q", id)
+		}
+	}
+}
+
+// handleRun scans a single module synchronously, without a job or
+// the task queue, and without checking for previous work.
+// It is meant for debugging the behavior of an analysis binary.
+func (s *analysisServer) handleRun(w http.ResponseWriter, r *http.Request) (err error) {
+	defer derrors.Wrap(&err, "analysisServer.handleRun")
+	ctx := r.Context()
+
+	var params analysis.RunParams
+	if err := scan.ParseParams(r, &params); err != nil {
+		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+	}
+	if params.Module == "" || params.Version == "" {
+		return fmt.Errorf("%w: analysis: need module and version", derrors.InvalidArgument)
+	}
+	if _, err := analysis.SplitArgs(params.Args); err != nil {
+		return fmt.Errorf("%w: analysis: args: %v", derrors.InvalidArgument, err)
+	}
+	req := params.ScanRequest()
+	ctx = log.With(ctx, "module", req.Module+"@"+req.Version, "binary", req.Binary)
+
+	localBinaryPath, err := s.copyBinary(req.Binary)
+	if err != nil {
+		return err
+	}
+	defer derrors.Cleanup(&err, func() error { return os.Remove(localBinaryPath) })
+
+	binaryHash, err := hashFile(localBinaryPath)
+	if err != nil {
+		return err
+	}
+	wv, err := s.workVersion(req, binaryHash)
+	if err != nil {
+		return err
+	}
+	row := s.scan(ctx, req, localBinaryPath, wv)
+	return writeResult(ctx, params.Serve, w, s.bqClient, s.localResults, analysis.TableName, row)
+}
+
+// copyBinary validates the name of an analysis binary and copies it
+// from the bucket to a local file, returning the file's path.
+func (s *analysisServer) copyBinary(binary string) (string, error) {
+	if binary == "" {
+		return "", fmt.Errorf("%w: analysis: missing binary", derrors.InvalidArgument)
+	}
+	if binary != path.Base(binary) {
+		return "", fmt.Errorf("%w: analysis: binary name contains slashes (must be a basename)", derrors.InvalidArgument)
+	}
+	localBinaryPath := path.Join(s.cfg.BinaryDir, binary)
+	srcPath := path.Join(analysisBinariesBucketDir, binary)
+	const executable = true
+	if err := copyToLocalFile(localBinaryPath, executable, srcPath, s.openFile); err != nil {
+		return "", err
+	}
+	return localBinaryPath, nil
+}
+
+// canonicalBuildConfig replaces the build tags, go flags and Go toolchain
+// with their canonical forms.
+func canonicalBuildConfig(buildTags, goflags, goVersion *string) (err error) {
+	*buildTags, err = analysis.CanonicalBuildTags(*buildTags)
+	if err != nil {
+		return fmt.Errorf("%w: analysis: %v", derrors.InvalidArgument, err)
+	}
+	*goflags, err = analysis.CanonicalGoFlags(*goflags)
+	if err != nil {
+		return fmt.Errorf("%w: analysis: %v", derrors.InvalidArgument, err)
+	}
+	*goVersion, err = analysis.CanonicalGoVersion(*goVersion)
+	if err != nil {
+		return fmt.Errorf("%w: analysis: %v", derrors.InvalidArgument, err)
 	}
 	return nil
 }
 
-func (s *analysisServer) readWorkVersion(ctx context.Context, module_path, version, binary string) error {
+// workVersion canonicalizes the analyzers and build configuration of req and returns the
+// WorkVersion for scanning it with a binary whose hash is binaryHash.
+func (s *analysisServer) workVersion(req *analysis.ScanRequest, binaryHash string) (analysis.WorkVersion, error) {
+	analyzers, err := analysis.CanonicalAnalyzers(req.Analyzers)
+	if err != nil {
+		return analysis.WorkVersion{}, fmt.Errorf("%w: analysis: %v", derrors.InvalidArgument, err)
+	}
+	req.Analyzers = analyzers
+	if err := canonicalBuildConfig(&req.BuildTags, &req.GoFlags, &req.Go); err != nil {
+		return analysis.WorkVersion{}, err
+	}
+	return analysis.WorkVersion{
+		BinaryArgs:    req.Args,
+		Analyzers:     bq.NullString{StringVal: analyzers, Valid: analyzers != ""},
+		BuildTags:     bq.NullString{StringVal: req.BuildTags, Valid: req.BuildTags != ""},
+		GoFlags:       bq.NullString{StringVal: req.GoFlags, Valid: req.GoFlags != ""},
+		GoVersion:     bq.NullString{StringVal: req.Go, Valid: req.Go != ""},
+		DepSnapshot:   bq.NullBool{Bool: req.DepSnapshot, Valid: req.DepSnapshot},
+		WorkerVersion: s.cfg.VersionID,
+		SchemaVersion: analysis.SchemaVersion,
+		BinaryVersion: binaryHash,
+	}, nil
+}
+
+func (s *analysisServer) readWorkVersion(ctx context.Context, key analysis.WorkVersionKey) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	key := analysis.WorkVersionKey{Module: module_path, Version: version, Binary: binary}
 	if _, ok := s.storedWorkVersions[key]; ok {
 		return nil
 	}
 	if s.bqClient == nil {
 		return nil
 	}
-	wv, err := analysis.ReadWorkVersion(ctx, s.bqClient, module_path, version, binary)
+	wv, err := analysis.ReadWorkVersion(ctx, s.bqClient, key)
 	if err != nil {
 		return err
 	}
@@ -189,10 +389,15 @@ func (s *analysisServer) scan(ctx context.Context, req *analysis.ScanRequest, lo
 		ModulePath:  req.Module,
 		Version:     req.Version,
 		BinaryName:  req.Binary,
+		ImportedBy:  bq.NullInt64{Int64: int64(req.ImportedBy), Valid: true},
+		JobID:       bq.NullString{StringVal: req.JobID, Valid: req.JobID != ""},
 		WorkVersion: wv,
+		Subdir:      bq.NullString{StringVal: req.Subdir, Valid: req.Subdir != ""},
 	}
+	// The labels were checked by ParseScanRequest.
+	row.Labels, _ = scan.ParseLabels(req.Labels)
 	hasGoMod := true
-	err := doScan(ctx, req.Module, req.Version, req.Insecure, func() (err error) {
+	err := doScan(ctx, s.scanLimits, req.Module, req.Version, req.Insecure, func(ctx context.Context) (err error) {
 		// Create a module directory. scanInternal will write the module contents there,
 		// and both the analysis binary and addSource will read them.
 		mdir := moduleDir(req.Module, req.Version)
@@ -200,19 +405,36 @@ func (s *analysisServer) scan(ctx context.Context, req *analysis.ScanRequest, lo
 
 		hasGoMod = fileExists(filepath.Join(mdir, "go.mod")) // for precise error breakdown
 
-		jsonTree, err := s.scanInternal(ctx, req, localBinaryPath, mdir)
+		jsonTree, err := s.scanInternal(ctx, req, localBinaryPath, wv.BinaryVersion, mdir, row)
 		if err != nil {
 			return err
 		}
-		info, err := s.proxyClient.Info(ctx, req.Module, req.Version)
-		if err != nil {
-			return fmt.Errorf("%w: %v", derrors.ProxyError, err)
+		// Modules of private corpora are not on the proxy, and have no
+		// commit time.
+		if req.PrivateCorpus == "" {
+			info, err := s.proxyClient.Info(ctx, req.Module, req.Version)
+			if err != nil {
+				return fmt.Errorf("%w: %v", derrors.ProxyError, err)
+			}
+			row.Version = info.Version
+			row.CommitTime = info.Time
 		}
-		row.Version = info.Version
-		row.CommitTime = info.Time
 		row.Diagnostics = analysis.JSONTreeToDiagnostics(jsonTree)
-		return addSource(ctx, row.Diagnostics, 1)
+		licenses, redist, err := modules.DetectLicenses(mdir)
+		if err != nil {
+			return fmt.Errorf("%v: %w", err, derrors.ScanModuleOSError)
+		}
+		row.Licenses = licenses
+		row.Redistributable = bq.NullBool{Bool: redist, Valid: true}
+		if err := addSource(ctx, row.Diagnostics, 1); err != nil {
+			return err
+		}
+		analysis.SetFingerprints(row.Diagnostics)
+		return nil
 	})
+	if perr := (*binaryPanicError)(nil); errors.As(err, &perr) {
+		row.CrashOutput = bq.NullString{StringVal: perr.output, Valid: true}
+	}
 	if err != nil {
 		// The errors are classified as to explicitly make a distinction
 		// between misc errors for modules and non-modules. The intended
@@ -222,6 +444,10 @@ func (s *analysisServer) scan(ctx context.Context, req *analysis.ScanRequest, lo
 		// wrong with their analysis, while in fact it can be the case
 		// that synthetic (non-modules) are just outdated.
 		switch {
+		case errors.Is(err, derrors.ScanModuleDiskLimitExceeded),
+			errors.Is(err, derrors.AnalysisBinaryPanicError),
+			errors.Is(err, derrors.ChecksumMismatch):
+			// Already classified.
 		case isNoModulesSpecified(err):
 			// We try to turn every non-module project into a module, so this
 			// branch should never be reached. We keep this for sanity and to
@@ -254,16 +480,127 @@ func (s *analysisServer) scan(ctx context.Context, req *analysis.ScanRequest, lo
 	return row
 }
 
-func (s *analysisServer) scanInternal(ctx context.Context, req *analysis.ScanRequest, binaryPath, moduleDir string) (jt analysis.JSONTree, err error) {
-	if err := prepareModule(ctx, req.Module, req.Version, moduleDir, s.proxyClient, req.Insecure, !req.SkipInit); err != nil {
+// scanInternal prepares the module in moduleDir and runs the binary on it,
+// adapting to the binary's metadata, which it records in row.
+func (s *analysisServer) scanInternal(ctx context.Context, req *analysis.ScanRequest, binaryPath, binaryHash, moduleDir string, row *analysis.Result) (jt analysis.JSONTree, err error) {
+	goflags := analysis.GoFlagsEnv(req.BuildTags, req.GoFlags)
+	goroot := ""
+	if req.Go != "" {
+		goroot, err = provisionToolchain(ctx, req.Go, req.Insecure)
+		if err != nil {
+			return nil, err
+		}
+	}
+	src, err := s.moduleSource(req.PrivateCorpus)
+	if err != nil {
 		return nil, err
 	}
+	stats, err := prepareModule(ctx, req.Module, req.Version, moduleDir, req.Subdir, src, req.Insecure, !req.SkipInit, goflags)
+	if stats.verification != "" {
+		row.ChecksumVerification = bq.NullString{StringVal: stats.verification, Valid: true}
+	}
+	if stats.prepStrategy != "" {
+		row.PrepStrategy = bq.NullString{StringVal: stats.prepStrategy, Valid: true}
+	}
+	if err != nil {
+		return nil, err
+	}
+	// From here on, the module is in the requested subdirectory, if any.
+	moduleDir = stats.dir
 	var sbox *sandbox.Sandbox
 	if !req.Insecure {
 		sbox = sandbox.New("/bundle")
 		sbox.Runsc = "/usr/local/bin/runsc"
 	}
-	return runAnalysisBinary(sbox, binaryPath, req.Args, moduleDir)
+	row.Network = bq.NullString{StringVal: networkAccess(sbox), Valid: true}
+	md, err := s.binaryMetadata(ctx, sbox, binaryPath, binaryHash, moduleDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := row.SetMetadata(md); err != nil {
+		return nil, err
+	}
+	if err := md.CheckAnalyzers(req.Analyzers); err != nil {
+		return nil, fmt.Errorf("%w: analysis: %v", derrors.InvalidArgument, err)
+	}
+	modCache := ""
+	if req.DepSnapshot || md.NeedsDeps {
+		modCache = modCacheSnapshotDir(req.Module, req.Version)
+		defer derrors.Cleanup(&err, func() error { return os.RemoveAll(modCache) })
+		if err := snapshotModCache(ctx, moduleDir, modCache, req.Insecure, goflags); err != nil {
+			return nil, err
+		}
+	}
+	setFingerprint(ctx, row, analysisGoEnv(goflags, req.Go, modCache != ""), moduleDir, stats.moduleHash)
+	batchSize, err := analysisBatchSize(ctx, req.BatchSize, s.analysisBatchThreshold, moduleDir)
+	if err != nil {
+		return nil, err
+	}
+	if batchSize > 0 {
+		return runAnalysisBinaryBatches(ctx, sbox, binaryPath, req.Args, req.Analyzers, goflags, goroot, modCache, moduleDir, req.Insecure, batchSize)
+	}
+	return runAnalysisBinary(sbox, binaryPath, req.Args, req.Analyzers, goflags, goroot, modCache, moduleDir)
+}
+
+// networkAccess returns the network access of a binary run in sbox, or
+// outside the sandbox if sbox is nil. The sandbox always runs runsc with
+// -network=none.
+func networkAccess(sbox *sandbox.Sandbox) string {
+	if sbox == nil {
+		return analysis.NetworkHost
+	}
+	return analysis.NetworkOff
+}
+
+// moduleSource returns the source of the modules to scan: the private
+// corpus with the given name, or the proxy if it is empty.
+func (s *analysisServer) moduleSource(privateCorpus string) (moduleSource, error) {
+	if privateCorpus == "" {
+		return proxySource{client: s.proxyClient, sumDB: s.sumDB}, nil
+	}
+	return newPrivateCorpusSource(privateCorpus, s.openFile)
+}
+
+// binaryMetadata returns the metadata of the binary whose hash is
+// binaryHash, running it in dir if it is not cached.
+// See the description of the driver protocol in internal/analysis.
+func (s *analysisServer) binaryMetadata(ctx context.Context, sbox *sandbox.Sandbox, binaryPath, binaryHash, dir string) (*analysis.Metadata, error) {
+	s.mu.Lock()
+	md := s.metadata[binaryHash]
+	s.mu.Unlock()
+	if md != nil {
+		return md, nil
+	}
+	md, err := readBinaryMetadata(sbox, binaryPath, dir)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf(ctx, "binary %s speaks driver protocol version %d", binaryPath, md.ProtocolVersion)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.metadata == nil {
+		s.metadata = map[string]*analysis.Metadata{}
+	}
+	s.metadata[binaryHash] = md
+	return md, nil
+}
+
+// readBinaryMetadata runs the binary in dir to ask for its metadata.
+// A binary that fails speaks version 1 of the driver protocol.
+func readBinaryMetadata(sbox *sandbox.Sandbox, binaryPath, dir string) (*analysis.Metadata, error) {
+	out, err := runBinaryInDir(sbox, binaryPath, []string{analysis.MetadataFlag}, nil, dir)
+	if err != nil {
+		var eerr *exec.ExitError
+		if errors.As(err, &eerr) {
+			return analysis.V1Metadata(), nil
+		}
+		return nil, fmt.Errorf("running analysis binary %s: %s", binaryPath, derrors.IncludeStderr(err))
+	}
+	md, err := analysis.ParseMetadata(out)
+	if err != nil {
+		return nil, fmt.Errorf("%w: analysis: binary %s: %v", derrors.InvalidArgument, binaryPath, err)
+	}
+	return md, nil
 }
 
 func hashFile(filename string) (_ string, err error) {
@@ -284,13 +621,32 @@ func hashReader(r io.Reader) (string, error) {
 	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
-// runAnalysisBinary runs the binary on the module.
-func runAnalysisBinary(sbox *sandbox.Sandbox, binaryPath, reqArgs, moduleDir string) (analysis.JSONTree, error) {
-	args := []string{"-json"}
-	args = append(args, strings.Fields(reqArgs)...)
-	args = append(args, "./...")
-	out, err := runBinaryInDir(sbox, binaryPath, args, moduleDir)
+// runAnalysisBinary runs the binary on the packages of the module
+// matching patterns, or all of them if there are no patterns.
+// If analyzers is non-empty, it is passed to the binary
+// with the -analyzers flag. If goflags is non-empty, it is
+// the value of GOFLAGS in the binary's environment, so that
+// packages are loaded with the same configuration as they were
+// prepared. If goroot is non-empty, the binary runs the Go toolchain
+// there instead of the default one. If modCache is non-empty, the binary
+// uses it as the module cache, and cannot download modules.
+func runAnalysisBinary(sbox *sandbox.Sandbox, binaryPath, reqArgs, analyzers, goflags, goroot, modCache, moduleDir string, patterns ...string) (analysis.JSONTree, error) {
+	args := analysisArgs(reqArgs, analyzers, patterns...)
+	var env []string
+	if goflags != "" {
+		env = append(env, "GOFLAGS="+goflags)
+	}
+	if goroot != "" {
+		env = append(env, toolchainEnv(goroot, sbox == nil)...)
+	}
+	if modCache != "" {
+		env = append(env, "GOMODCACHE="+modCache, "GOPROXY=off")
+	}
+	out, err := runBinaryInDir(sbox, binaryPath, args, env, moduleDir)
 	if err != nil {
+		if perr := binaryPanic(binaryPath, err); perr != nil {
+			return nil, perr
+		}
 		return nil, fmt.Errorf("running analysis binary %s: %s", binaryPath, derrors.IncludeStderr(err))
 	}
 	var tree analysis.JSONTree
@@ -300,14 +656,126 @@ func runAnalysisBinary(sbox *sandbox.Sandbox, binaryPath, reqArgs, moduleDir str
 	return tree, nil
 }
 
-func runBinaryInDir(sbox *sandbox.Sandbox, path string, args []string, dir string) ([]byte, error) {
+// maxCrashOutput bounds the size of the crash output of a binary
+// recorded in a row.
+const maxCrashOutput = 16 * 1024
+
+var (
+	// crashStartRegexp matches the first line of the output of a Go
+	// program that panicked or hit a fatal runtime error.
+	crashStartRegexp = regexp.MustCompile(`(?m)^(panic|fatal error): `)
+	// goroutineRegexp matches the header of a goroutine's stack trace.
+	goroutineRegexp = regexp.MustCompile(`(?m)^goroutine \d+ \[`)
+)
+
+// A binaryPanicError reports that an analysis binary crashed with a
+// stack trace.
+type binaryPanicError struct {
+	binary string
+	msg    string // the first line of the crash output
+	output string // the crash output, truncated to maxCrashOutput
+}
+
+func (e *binaryPanicError) Error() string {
+	return fmt.Sprintf("analysis binary %s crashed: %s", e.binary, e.msg)
+}
+
+func (e *binaryPanicError) Unwrap() error {
+	return derrors.AnalysisBinaryPanicError
+}
+
+// binaryPanic returns a *binaryPanicError if err is the exit of the
+// analysis binary after a panic or fatal error of the Go runtime, which
+// exits with status 2 after printing the message and the stacks of the
+// goroutines. It returns nil otherwise.
+func binaryPanic(binaryPath string, err error) *binaryPanicError {
+	var eerr *exec.ExitError
+	if !errors.As(err, &eerr) || eerr.ExitCode() != 2 {
+		return nil
+	}
+	stderr := eerr.Stderr
+	loc := crashStartRegexp.FindIndex(stderr)
+	if loc == nil || !goroutineRegexp.Match(stderr[loc[0]:]) {
+		return nil
+	}
+	// Skip what the binary logged before it crashed.
+	crash := stderr[loc[0]:]
+	msg, _, _ := bytes.Cut(crash, []byte("\n"))
+	if len(crash) > maxCrashOutput {
+		crash = crash[:maxCrashOutput]
+	}
+	return &binaryPanicError{
+		binary: binaryPath,
+		msg:    string(msg),
+		output: string(crash),
+	}
+}
+
+// analysisArgs returns the arguments with which runAnalysisBinary
+// runs a binary on the package patterns, or on ./... if there are none.
+func analysisArgs(reqArgs, analyzers string, patterns ...string) []string {
+	args := []string{"-json"}
+	if analyzers != "" {
+		args = append(args, "-analyzers="+analyzers)
+	}
+	reqFields, err := analysis.SplitArgs(reqArgs)
+	if err != nil {
+		// The args were checked when the request was made.
+		reqFields = strings.Fields(reqArgs)
+	}
+	args = append(args, reqFields...)
+	if len(patterns) == 0 {
+		patterns = []string{"./..."}
+	}
+	return append(args, patterns...)
+}
+
+// analysisCommandLine returns the command line that runAnalysisBinary
+// runs in each module's directory for a job, preceded by the variables
+// it adds to the environment. The location of a snapshot of the
+// module's dependencies varies by module, so it is shown as SNAPSHOT,
+// and a pinned Go toolchain is shown as the GOTOOLCHAIN setting that
+// selects it.
+func analysisCommandLine(binary, reqArgs, analyzers, goflags, goVersion string, depSnapshot bool) string {
+	var words []string
+	if goflags != "" {
+		words = append(words, "GOFLAGS="+shellQuote(goflags))
+	}
+	if goVersion != "" {
+		words = append(words, "GOTOOLCHAIN="+goVersion)
+	}
+	if depSnapshot {
+		words = append(words, "GOMODCACHE=SNAPSHOT", "GOPROXY=off")
+	}
+	words = append(words, binary)
+	for _, a := range analysisArgs(reqArgs, analyzers) {
+		words = append(words, shellQuote(a))
+	}
+	return strings.Join(words, " ")
+}
+
+// shellQuote quotes s for a POSIX shell if it contains whitespace.
+func shellQuote(s string) string {
+	if !strings.ContainsAny(s, " \t") {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// runBinaryInDir runs the binary in dir, with env added to its environment.
+func runBinaryInDir(sbox *sandbox.Sandbox, path string, args, env []string, dir string) ([]byte, error) {
 	if sbox == nil {
 		cmd := exec.Command(path, args...)
 		cmd.Dir = dir
+		if len(env) > 0 {
+			cmd.Env = append(cmd.Environ(), env...)
+		}
 		return cmd.Output()
 	}
 	cmd := sbox.Command(path, args...)
 	cmd.Dir = dir
+	cmd.Env = env
+	cmd.AppendToEnv = true
 	return cmd.Output()
 }
 
@@ -417,7 +885,7 @@ func readSource(file string, line int, nContext int) (_ string, err error) {
 func (s *analysisServer) handleEnqueue(w http.ResponseWriter, r *http.Request) (err error) {
 	defer derrors.Wrap(&err, "analysisServer.handleEnqueue")
 	ctx := r.Context()
-	params := &analysis.EnqueueParams{Min: defaultMinImportedByCount}
+	params := &analysis.EnqueueParams{Min: s.dynamic.Get().MinImportedBy}
 	if err := scan.ParseParams(r, params); err != nil {
 		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
 	}
@@ -427,6 +895,41 @@ func (s *analysisServer) handleEnqueue(w http.ResponseWriter, r *http.Request) (
 	if params.Binary != path.Base(params.Binary) {
 		return fmt.Errorf("%w: analysis: binary name contains slashes (must be a basename)", derrors.InvalidArgument)
 	}
+	if _, err := analysis.SplitArgs(params.Args); err != nil {
+		return fmt.Errorf("%w: analysis: args: %v", derrors.InvalidArgument, err)
+	}
+	if err := queue.CheckPriority(params.Priority); err != nil {
+		return fmt.Errorf("%w: analysis: %v", derrors.InvalidArgument, err)
+	}
+	if params.IdempotencyKey != "" && params.User == "" {
+		return fmt.Errorf("%w: analysis: idempotencykey requires user", derrors.InvalidArgument)
+	}
+	if _, err := scan.ParseLabels(params.Labels); err != nil {
+		return fmt.Errorf("%w: analysis: %v", derrors.InvalidArgument, err)
+	}
+	if params.OwnTable {
+		if params.User == "" {
+			return fmt.Errorf("%w: analysis: owntable requires user", derrors.InvalidArgument)
+		}
+		if s.bqClient == nil {
+			return &serverError{err: errors.New("owntable: BigQuery not configured"), status: http.StatusNotImplemented}
+		}
+	}
+	if params.Notify != "" {
+		if params.User == "" {
+			return fmt.Errorf("%w: analysis: notify requires user", derrors.InvalidArgument)
+		}
+		if err := checkNotify(params.Notify, s.notifyEmailURL()); err != nil {
+			return fmt.Errorf("%w: analysis: %v", derrors.InvalidArgument, err)
+		}
+	}
+	params.Analyzers, err = analysis.CanonicalAnalyzers(params.Analyzers)
+	if err != nil {
+		return fmt.Errorf("%w: analysis: %v", derrors.InvalidArgument, err)
+	}
+	if err := canonicalBuildConfig(&params.BuildTags, &params.GoFlags, &params.Go); err != nil {
+		return err
+	}
 	srcPath := path.Join(analysisBinariesBucketDir, params.Binary)
 	rc, err := s.openFile(srcPath)
 	if err != nil {
@@ -437,9 +940,35 @@ func (s *analysisServer) handleEnqueue(w http.ResponseWriter, r *http.Request) (
 	if err != nil {
 		return err
 	}
-	mods, err := readModules(ctx, s.cfg, params.File, params.Min)
-	if err != nil {
-		return err
+	if params.BinarySHA256 != "" && params.BinarySHA256 != binaryHash {
+		return fmt.Errorf("%w: analysis: binary %s has hash %s, not %s; was it replaced after upload?",
+			derrors.InvalidArgument, params.Binary, binaryHash, params.BinarySHA256)
+	}
+	var mods []scan.ModuleSpec
+	if params.PrivateCorpus != "" {
+		if params.File != "" || params.CorpusQuery != "" {
+			return fmt.Errorf("%w: analysis: privatecorpus cannot be used with file or corpusquery", derrors.InvalidArgument)
+		}
+		src, err := newPrivateCorpusSource(params.PrivateCorpus, s.openFile)
+		if err != nil {
+			return err
+		}
+		mods, err = src.modules()
+		if err != nil {
+			return err
+		}
+	} else {
+		mods, err = readModules(ctx, s.cfg, s.dynamic.Get(), s.bqClient, params.File, params.CorpusQuery, params.Min)
+		if err != nil {
+			return err
+		}
+	}
+	var softSkipped []jobs.ModuleSkip
+	if limit, _ := s.softSkipConfig(); limit > 0 && params.PrivateCorpus == "" && s.jobDB != nil {
+		mods, softSkipped, err = softSkipModules(ctx, s.jobDB, mods, binaryHash, time.Now())
+		if err != nil {
+			log.Errorf(ctx, err, "reading modules skipped for failing")
+		}
 	}
 
 	// If a user was provided, create a Job.
@@ -447,32 +976,222 @@ func (s *analysisServer) handleEnqueue(w http.ResponseWriter, r *http.Request) (
 	sj := ""
 	if params.User != "" {
 		job := jobs.NewJob(params.User, time.Now(), r.URL.String(), params.Binary, binaryHash, params.Args)
+		job.Analyzers = params.Analyzers
+		job.BuildTags = params.BuildTags
+		job.GoFlags = params.GoFlags
+		job.GoVersion = params.Go
+		job.DepSnapshot = params.DepSnapshot
+		job.Command = analysisCommandLine(params.Binary, params.Args, params.Analyzers,
+			analysis.GoFlagsEnv(params.BuildTags, params.GoFlags), params.Go, params.DepSnapshot)
+		job.ClientVersion = params.ClientVersion
+		job.Notify = params.Notify
+		job.SoftSkipped = softSkipped
 		jobID = job.ID()
+		if params.IdempotencyKey != "" {
+			prev, err := s.jobDB.ReserveEnqueueKey(ctx, params.User, params.IdempotencyKey, jobID)
+			if err != nil {
+				return err
+			}
+			if prev != nil {
+				fmt.Fprintln(w, repeatedEnqueueMessage(params.IdempotencyKey, prev))
+				return nil
+			}
+		}
+		if params.OwnTable {
+			job.Table = analysis.JobTableName(jobID)
+			if err := s.createJobTable(ctx, job.Table); err != nil {
+				if params.IdempotencyKey != "" {
+					if err := s.jobDB.ReleaseEnqueueKey(ctx, params.User, params.IdempotencyKey); err != nil {
+						log.Errorf(ctx, err, "failed to release idempotency key upon unsuccessful enqueuing")
+					}
+				}
+				return err
+			}
+		}
 		if err := s.jobDB.CreateJob(ctx, job); err != nil {
 			sj = fmt.Sprintf(", but could not create job: %v", err)
 		} else {
 			sj = ", job ID is " + jobID
 		}
+		if job.Table != "" {
+			sj += ", results table is " + job.Table
+		}
 	}
 
 	tasks := createAnalysisQueueTasks(params, jobID, binaryHash, mods)
+	var (
+		numShared  int
+		sharedWith []string
+	)
+	if jobID != "" && !params.NoShare && !params.OwnTable {
+		var shared map[string]int
+		tasks, shared = shareTasks(ctx, s.jobDB, jobID, tasks)
+		if len(shared) > 0 {
+			sharedWith = maps.Keys(shared)
+			sort.Strings(sharedWith)
+			for _, id := range sharedWith {
+				numShared += shared[id]
+			}
+			sj += fmt.Sprintf("; %d modules are shared with %s", numShared, strings.Join(sharedWith, ", "))
+		}
+	}
+	if len(softSkipped) > 0 {
+		sj += fmt.Sprintf("; %d modules are skipped for failing repeatedly", len(softSkipped))
+	}
 	err = enqueueTasks(ctx, tasks, s.queue,
-		&queue.Options{Namespace: "analysis", TaskNameSuffix: params.Suffix})
+		&queue.Options{Namespace: "analysis", TaskNameSuffix: params.Suffix, JobID: jobID, Cancelable: jobID != "", Priority: params.Priority})
 	if err != nil {
 		if err := s.jobDB.DeleteJob(ctx, jobID); err != nil {
 			log.Errorf(ctx, err, "failed to delete job upon unsuccessful enqueuing")
 		}
+		if params.IdempotencyKey != "" {
+			if err := s.jobDB.ReleaseEnqueueKey(ctx, params.User, params.IdempotencyKey); err != nil {
+				log.Errorf(ctx, err, "failed to release idempotency key upon unsuccessful enqueuing")
+			}
+		}
 		return fmt.Errorf("enequeue failed: %w", err)
 	}
+	if params.IdempotencyKey != "" {
+		if err := s.jobDB.FinishEnqueueKey(ctx, params.User, params.IdempotencyKey, len(tasks)); err != nil {
+			log.Errorf(ctx, err, "recording idempotency key of job %q", jobID)
+		}
+	}
 	if jobID != "" {
-		s.jobDB.Increment(ctx, jobID, "NumEnqueued", len(tasks))
+		// The shared tasks are recorded with the job's own, so that the
+		// job is not finished before both are.
+		err := s.jobDB.UpdateJob(ctx, jobID, func(j *jobs.Job) error {
+			j.NumEnqueued += len(tasks)
+			j.NumShared += numShared
+			j.SharedWith = sharedWith
+			return nil
+		})
+		if err != nil {
+			log.Errorf(ctx, err, "recording the tasks of job %q", jobID)
+		}
+		// All the tasks may have finished already.
+		if _, err := s.finalizeJob(ctx, s.jobDB, jobID); err != nil {
+			log.Errorf(ctx, err, "failed to finalize job %q", jobID)
+		}
 	}
+	s.recordAction(ctx, r, audit.Enqueue, jobID, fmt.Sprintf("%d analysis tasks", len(tasks)))
 	// Communicate enqueue status for better usability.
 	fmt.Fprintf(w, "enqueued %d analysis tasks successfully%s\n", len(tasks), sj)
 	return nil
 }
 
+// repeatedEnqueueMessage is the reply to an enqueue whose idempotency key
+// was already used by the enqueue described by prev.
+func repeatedEnqueueMessage(key string, prev *jobs.EnqueueRecord) string {
+	if !prev.Done {
+		return fmt.Sprintf("enqueue with idempotency key %q is in progress, job ID is %s", key, prev.JobID)
+	}
+	return fmt.Sprintf("enqueued %d analysis tasks successfully, job ID is %s (repeated enqueue with idempotency key %q)",
+		prev.NumEnqueued, prev.JobID, key)
+}
+
+// handlePlan serves the modules that an enqueue with the same corpus
+// parameters would scan, without enqueueing anything.
+func (s *analysisServer) handlePlan(w http.ResponseWriter, r *http.Request) (err error) {
+	defer derrors.Wrap(&err, "analysisServer.handlePlan")
+	ctx := r.Context()
+	params := &analysis.PlanParams{Min: s.dynamic.Get().MinImportedBy}
+	if err := scan.ParseParams(r, params); err != nil {
+		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+	}
+	mods, err := readModules(ctx, s.cfg, s.dynamic.Get(), s.bqClient, params.File, params.CorpusQuery, params.Min)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, &analysis.Plan{NumModules: len(mods), Modules: mods})
+}
+
+// handleDiff serves, as JSON, a summary of how the diagnostics of an
+// analysis binary changed between two of its versions.
+//
+// analysis/diff?binary=B&old=HASH1&new=HASH2
+func (s *analysisServer) handleDiff(w http.ResponseWriter, r *http.Request) (err error) {
+	defer derrors.Wrap(&err, "analysisServer.handleDiff")
+	params := &analysis.DiffParams{}
+	if err := scan.ParseParams(r, params); err != nil {
+		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+	}
+	if params.Binary == "" || params.Old == "" || params.New == "" {
+		return fmt.Errorf("%w: analysis: need binary, old and new", derrors.InvalidArgument)
+	}
+	if params.Old == params.New {
+		return fmt.Errorf("%w: analysis: old and new are the same version", derrors.InvalidArgument)
+	}
+	if s.bqClient == nil {
+		return &serverError{err: errors.New("BigQuery not configured"), status: http.StatusNotImplemented}
+	}
+	d, err := analysis.ReadBinaryDiff(r.Context(), s.bqClient, params.Binary, params.Old, params.New)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return writeJSON(w, d)
+}
+
+// A workClaimer claims the work of tasks for jobs.
+type workClaimer interface {
+	ClaimWork(ctx context.Context, key, jobID string, request []byte) (owner string, err error)
+}
+
+// shareTasks claims the work of each of tasks for the job with ID jobID.
+// It returns the tasks whose work the job claimed, and the number of the
+// other tasks that are shared with each job that had already claimed
+// their work. A task whose work could not be claimed because of an error
+// is returned, so that at worst it is scanned twice.
+func shareTasks(ctx context.Context, db workClaimer, jobID string, tasks []queue.Task) (own []queue.Task, shared map[string]int) {
+	// Claim concurrently, like enqueueTasks.
+	const concurrentClaims = 20
+	owners := make([]string, len(tasks))
+	sem := make(chan struct{}, concurrentClaims)
+	for i, t := range tasks {
+		sreq, ok := t.(*analysis.ScanRequest)
+		if !ok {
+			continue
+		}
+		key := sreq.WorkKey()
+		if key == "" {
+			continue
+		}
+		request, err := json.Marshal(sreq)
+		if err != nil {
+			log.Errorf(ctx, err, "encoding %s for job %s", sreq.Name(), jobID)
+			continue
+		}
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem }()
+			owner, err := db.ClaimWork(ctx, key, jobID, request)
+			if err != nil {
+				log.Errorf(ctx, err, "claiming %s for job %s", sreq.Name(), jobID)
+				return
+			}
+			owners[i] = owner
+		}()
+	}
+	// Wait for goroutines to finish.
+	for i := 0; i < concurrentClaims; i++ {
+		sem <- struct{}{}
+	}
+	shared = map[string]int{}
+	for i, t := range tasks {
+		if o := owners[i]; o != "" && o != jobID {
+			shared[o]++
+		} else {
+			own = append(own, t)
+		}
+	}
+	return own, shared
+}
+
 func createAnalysisQueueTasks(params *analysis.EnqueueParams, jobID string, binaryVersion string, mods []scan.ModuleSpec) []queue.Task {
+	var table string
+	if params.OwnTable && jobID != "" {
+		table = analysis.JobTableName(jobID)
+	}
 	var tasks []queue.Task
 	for _, mod := range mods {
 		tasks = append(tasks, &analysis.ScanRequest{
@@ -484,12 +1203,32 @@ func createAnalysisQueueTasks(params *analysis.EnqueueParams, jobID string, bina
 				Binary:        params.Binary,
 				BinaryVersion: binaryVersion,
 				Args:          params.Args,
+				Analyzers:     params.Analyzers,
 				ImportedBy:    mod.ImportedBy,
 				Insecure:      params.Insecure,
 				JobID:         jobID,
 				SkipInit:      params.SkipInit,
+				BuildTags:     params.BuildTags,
+				GoFlags:       params.GoFlags,
+				Go:            params.Go,
+				DepSnapshot:   params.DepSnapshot,
+				BatchSize:     params.BatchSize,
+				PrivateCorpus: params.PrivateCorpus,
+				Table:         table,
+				Labels:        params.Labels,
+				Share:         !params.NoShare && jobID != "" && table == "",
 			},
 		})
 	}
 	return tasks
 }
+
+// createJobTable registers and creates the job table tableID.
+func (s *analysisServer) createJobTable(ctx context.Context, tableID string) error {
+	analysis.RegisterJobTable(tableID)
+	if _, err := s.bqClient.CreateOrUpdateTable(ctx, tableID); err != nil {
+		return err
+	}
+	log.Infof(ctx, "created job table %s", tableID)
+	return nil
+}
@@ -0,0 +1,105 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/pkgsite-metrics/internal/analysis"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/log"
+	"golang.org/x/pkgsite-metrics/internal/sandbox"
+)
+
+// Analyzing all the packages of a very large module at once can use more
+// memory than a scan has. So modules whose source is larger than
+// Server.analysisBatchThreshold are analyzed a batch of packages at a time, and
+// the results of the batches are merged. Analyzers see the same packages
+// either way, but a batch that shares dependencies with another analyzes
+// them again, so batching is slower.
+
+// defaultAnalysisBatchSize is the number of packages in a batch when a
+// module is split because of its size.
+const defaultAnalysisBatchSize = 100
+
+// analysisBatchSize returns the number of packages of the module in
+// moduleDir to analyze at a time, or 0 to analyze them all at once.
+// A positive requested size is always used. Otherwise, the module is
+// analyzed in batches if its source is larger than threshold bytes,
+// unless threshold is zero.
+func analysisBatchSize(ctx context.Context, requested int, threshold int64, moduleDir string) (int, error) {
+	if requested > 0 || threshold <= 0 {
+		return requested, nil
+	}
+	size, err := dirSize(moduleDir)
+	if err != nil {
+		return 0, err
+	}
+	if size <= threshold {
+		return 0, nil
+	}
+	log.Infof(ctx, "module source is %d MB, analyzing in batches of %d packages", size>>20, defaultAnalysisBatchSize)
+	return defaultAnalysisBatchSize, nil
+}
+
+// runAnalysisBinaryBatches is like runAnalysisBinary, but runs the binary
+// on batchSize packages of the module at a time, and merges the results.
+func runAnalysisBinaryBatches(ctx context.Context, sbox *sandbox.Sandbox, binaryPath, reqArgs, analyzers, goflags, goroot, modCache, moduleDir string, insecure bool, batchSize int) (_ analysis.JSONTree, err error) {
+	defer derrors.Wrap(&err, "runAnalysisBinaryBatches(%q, %d)", moduleDir, batchSize)
+
+	pkgs, err := listModulePackages(moduleDir, insecure, goflags)
+	if err != nil {
+		return nil, err
+	}
+	tree := analysis.JSONTree{}
+	for start := 0; start < len(pkgs); start += batchSize {
+		batch := pkgs[start:min(start+batchSize, len(pkgs))]
+		log.Debugf(ctx, "analyzing packages %d to %d of %d", start+1, start+len(batch), len(pkgs))
+		t, err := runAnalysisBinary(sbox, binaryPath, reqArgs, analyzers, goflags, goroot, modCache, moduleDir, batch...)
+		if err != nil {
+			return nil, err
+		}
+		mergeJSONTrees(tree, t)
+	}
+	return tree, nil
+}
+
+// listModulePackages returns the import paths of the packages of the
+// module in dir, as matched by ./..., using the module cache that
+// prepareModule populated.
+func listModulePackages(dir string, insecure bool, goflags string) ([]string, error) {
+	env := []string{"GOPROXY=off"}
+	if !insecure {
+		env = append(env, "GOMODCACHE="+filepath.Join(sandboxRoot, sandboxGoModCache))
+	}
+	if goflags != "" {
+		env = append(env, "GOFLAGS="+goflags)
+	}
+	out, err := goOutput(dir, env, "list", "-e", "-f", "{{.ImportPath}}", "./...")
+	if err != nil {
+		return nil, err
+	}
+	pkgs := strings.Fields(string(out))
+	if len(pkgs) == 0 {
+		return nil, fmt.Errorf("%w: no packages in %s", derrors.BadModule, dir)
+	}
+	return pkgs, nil
+}
+
+// mergeJSONTrees adds the diagnostics and errors of src to dst.
+func mergeJSONTrees(dst, src analysis.JSONTree) {
+	for pkg, byAnalyzer := range src {
+		if dst[pkg] == nil {
+			dst[pkg] = byAnalyzer
+			continue
+		}
+		for a, de := range byAnalyzer {
+			dst[pkg][a] = de
+		}
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/pkgsite-metrics/internal/analysis"
+	"golang.org/x/pkgsite-metrics/internal/buildtest"
+)
+
+func TestRunAnalysisBinaryBatches(t *testing.T) {
+	binPath := buildtest.GoBuild(t, "testdata/analyzer", "")
+
+	// A module with five packages, each of which calls Fact.
+	dir := t.TempDir()
+	writeFile := func(name, contents string) {
+		t.Helper()
+		name = filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("go.mod", "module example.com/m\n\ngo 1.21\n")
+	for i := 1; i <= 5; i++ {
+		writeFile(fmt.Sprintf("p%d/p.go", i), fmt.Sprintf("package p%d\n\nfunc Fact(n int) int { return n }\n\nvar X = Fact(%d)\n", i, i))
+	}
+
+	want, err := runAnalysisBinary(nil, binPath, "-name Fact", "", "", "", "", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(want) != 5 {
+		t.Fatalf("got results for %d packages, want 5", len(want))
+	}
+	for _, batchSize := range []int{1, 2, 5, 10} {
+		got, err := runAnalysisBinaryBatches(context.Background(), nil, binPath, "-name Fact", "", "", "", "", dir, true, batchSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("batch size %d: mismatch (-want, +got):\n%s", batchSize, diff)
+		}
+	}
+}
+
+func TestAnalysisBatchSize(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.go"), make([]byte, 1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, tt := range []struct {
+		threshold int64
+		requested int
+		want      int
+	}{
+		{0, 0, 0},
+		{0, 7, 7},
+		{2000, 0, 0},
+		{500, 0, defaultAnalysisBatchSize},
+		{500, 7, 7},
+	} {
+		got, err := analysisBatchSize(ctx, tt.requested, tt.threshold, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("threshold %d, requested %d: got %d, want %d", tt.threshold, tt.requested, got, tt.want)
+		}
+	}
+}
+
+func TestMergeJSONTrees(t *testing.T) {
+	diags := func(msg string) analysis.DiagnosticsOrError {
+		return analysis.DiagnosticsOrError{Diagnostics: []analysis.JSONDiagnostic{{Message: msg}}}
+	}
+	dst := analysis.JSONTree{"p1": {"a": diags("1a")}}
+	mergeJSONTrees(dst, analysis.JSONTree{"p1": {"b": diags("1b")}, "p2": {"a": diags("2a")}})
+	want := analysis.JSONTree{
+		"p1": {"a": diags("1a"), "b": diags("1b")},
+		"p2": {"a": diags("2a")},
+	}
+	if diff := cmp.Diff(want, dst); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
@@ -5,28 +5,41 @@
 package worker
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 
 	bq "cloud.google.com/go/bigquery"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"golang.org/x/pkgsite-metrics/internal/analysis"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
 	"golang.org/x/pkgsite-metrics/internal/buildtest"
 	"golang.org/x/pkgsite-metrics/internal/config"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/jobs"
+	"golang.org/x/pkgsite-metrics/internal/modules"
 	"golang.org/x/pkgsite-metrics/internal/proxy/proxytest"
 	"golang.org/x/pkgsite-metrics/internal/queue"
+	"golang.org/x/pkgsite-metrics/internal/sandbox"
 	"golang.org/x/pkgsite-metrics/internal/scan"
 )
 
 func TestRunAnalysisBinary(t *testing.T) {
 	binPath := buildtest.GoBuild(t, "testdata/analyzer", "")
 
-	got, err := runAnalysisBinary(nil, binPath, "-name Fact", "testdata/module")
+	got, err := runAnalysisBinary(nil, binPath, "-name Fact", "", "", "", "", "testdata/module")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -64,16 +77,98 @@ func TestRunAnalysisBinary(t *testing.T) {
 	}
 }
 
+func TestRunAnalysisBinaryPanic(t *testing.T) {
+	binPath := buildtest.GoBuild(t, "testdata/analyzerpanic", "")
+
+	_, err := runAnalysisBinary(nil, binPath, "", "", "", "", "", "testdata/module")
+	if !errors.Is(err, derrors.AnalysisBinaryPanicError) {
+		t.Fatalf("got %v, want an AnalysisBinaryPanicError", err)
+	}
+	if got, want := derrors.CodeOf(err), derrors.CodeAnalysisBinaryPanic; got != want {
+		t.Errorf("code: got %s, want %s", got, want)
+	}
+	var perr *binaryPanicError
+	if !errors.As(err, &perr) {
+		t.Fatalf("got %T, want a *binaryPanicError", err)
+	}
+	if got, want := perr.msg, "panic: analyzer bug"; got != want {
+		t.Errorf("message: got %q, want %q", got, want)
+	}
+	if !strings.HasPrefix(perr.output, "panic: analyzer bug\n") || !strings.Contains(perr.output, "main.main()") {
+		t.Errorf("unexpected crash output:\n%s", perr.output)
+	}
+}
+
+// TestRunAnalysisBinaryPanicRunner checks that a crash of the binary is
+// detected when it runs in the sandbox, where the runner program starts
+// it. The runner is run directly, without runsc.
+func TestRunAnalysisBinaryPanicRunner(t *testing.T) {
+	binPath := buildtest.GoBuild(t, "testdata/analyzerpanic", "")
+	runner := filepath.Join(t.TempDir(), "runner")
+	if out, err := exec.Command("go", "build", "-o", runner, "../sandbox/runner.go").CombinedOutput(); err != nil {
+		t.Fatalf("building runner: %v\n%s", err, out)
+	}
+	dir, err := filepath.Abs("testdata/module")
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, err := json.Marshal(&sandbox.Cmd{Path: binPath, Args: []string{binPath, "-json", "./..."}, Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(runner)
+	cmd.Stdin = bytes.NewReader(in)
+	_, err = cmd.Output()
+	var eerr *exec.ExitError
+	if !errors.As(err, &eerr) {
+		t.Fatalf("got %v, want an *exec.ExitError", err)
+	}
+	if got, want := eerr.ExitCode(), 2; got != want {
+		t.Fatalf("exit code: got %d, want %d", got, want)
+	}
+	perr := binaryPanic(binPath, err)
+	if perr == nil {
+		t.Fatalf("no crash detected in output:\n%s", eerr.Stderr)
+	}
+	if got, want := perr.msg, "panic: analyzer bug"; got != want {
+		t.Errorf("message: got %q, want %q", got, want)
+	}
+}
+
+func TestAnalysisCommandLine(t *testing.T) {
+	for _, tt := range []struct {
+		args, analyzers, goflags, goVersion string
+		depSnapshot                         bool
+		want                                string
+	}{
+		{"", "", "", "", false, "bin -json ./..."},
+		{"-name  Fact", "a,b", "", "", false, "bin -json -analyzers=a,b -name Fact ./..."},
+		{`-msg "two words"`, "", "", "", false, "bin -json -msg 'two words' ./..."},
+		{"", "", "-mod=mod -tags=x", "", true, "GOFLAGS='-mod=mod -tags=x' GOMODCACHE=SNAPSHOT GOPROXY=off bin -json ./..."},
+		{"", "", "-tags=x", "", false, "GOFLAGS=-tags=x bin -json ./..."},
+		{"", "", "-tags=x", "go1.22.3", false, "GOFLAGS=-tags=x GOTOOLCHAIN=go1.22.3 bin -json ./..."},
+	} {
+		got := analysisCommandLine("bin", tt.args, tt.analyzers, tt.goflags, tt.goVersion, tt.depSnapshot)
+		if got != tt.want {
+			t.Errorf("analysisCommandLine(%q, %q, %q, %q, %t) = %q, want %q", tt.args, tt.analyzers, tt.goflags, tt.goVersion, tt.depSnapshot, got, tt.want)
+		}
+	}
+}
+
 func TestCreateAnalysisQueueTasks(t *testing.T) {
 	mods := []scan.ModuleSpec{
 		{Path: "a.com/a", Version: "v1.2.3", ImportedBy: 1},
 		{Path: "b.com/b", Version: "v1.0.0", ImportedBy: 2},
 	}
 	got := createAnalysisQueueTasks(&analysis.EnqueueParams{
-		Binary:   "bin",
-		Args:     "args",
-		Insecure: true,
-		Suffix:   "suff",
+		Binary:    "bin",
+		Args:      "args",
+		Analyzers: "nilness,printf",
+		Insecure:  true,
+		Suffix:    "suff",
+		BuildTags: "integration",
+		GoFlags:   "-mod=mod",
+		Labels:    []string{"arm:control"},
 	}, "jobID", "binVersion", mods)
 	want := []queue.Task{
 		&analysis.ScanRequest{
@@ -82,9 +177,14 @@ func TestCreateAnalysisQueueTasks(t *testing.T) {
 				Binary:        "bin",
 				BinaryVersion: "binVersion",
 				Args:          "args",
+				Analyzers:     "nilness,printf",
 				ImportedBy:    1,
 				Insecure:      true,
 				JobID:         "jobID",
+				BuildTags:     "integration",
+				GoFlags:       "-mod=mod",
+				Labels:        []string{"arm:control"},
+				Share:         true,
 			},
 		},
 		&analysis.ScanRequest{
@@ -93,9 +193,14 @@ func TestCreateAnalysisQueueTasks(t *testing.T) {
 				Binary:        "bin",
 				BinaryVersion: "binVersion",
 				Args:          "args",
+				Analyzers:     "nilness,printf",
 				ImportedBy:    2,
 				Insecure:      true,
 				JobID:         "jobID",
+				BuildTags:     "integration",
+				GoFlags:       "-mod=mod",
+				Labels:        []string{"arm:control"},
+				Share:         true,
 			},
 		},
 	}
@@ -104,6 +209,88 @@ func TestCreateAnalysisQueueTasks(t *testing.T) {
 	}
 }
 
+// fakeClaimer is a workClaimer whose claims are held in memory.
+type fakeClaimer struct {
+	mu       sync.Mutex
+	claims   map[string]string // from work key to job ID
+	requests map[string][]byte // from work key to request of the claim
+	fail     string            // work key whose claim fails
+}
+
+func (c *fakeClaimer) ClaimWork(_ context.Context, key, jobID string, request []byte) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.fail != "" && key == c.fail {
+		return "", errors.New("claim failed")
+	}
+	if owner, ok := c.claims[key]; ok {
+		return owner, nil
+	}
+	c.claims[key] = jobID
+	c.requests[key] = request
+	return jobID, nil
+}
+
+func TestShareTasks(t *testing.T) {
+	ctx := context.Background()
+	params := &analysis.EnqueueParams{Binary: "bin", Args: "args"}
+	mods := func(paths ...string) []scan.ModuleSpec {
+		var ms []scan.ModuleSpec
+		for _, p := range paths {
+			ms = append(ms, scan.ModuleSpec{Path: p, Version: "v1.0.0"})
+		}
+		return ms
+	}
+	names := func(tasks []queue.Task) []string {
+		var ns []string
+		for _, t := range tasks {
+			ns = append(ns, t.Name())
+		}
+		return ns
+	}
+
+	c := &fakeClaimer{claims: map[string]string{}, requests: map[string][]byte{}}
+	own, shared := shareTasks(ctx, c, "j1", createAnalysisQueueTasks(params, "j1", "h", mods("a.com/a", "b.com/b")))
+	if len(own) != 2 || len(shared) != 0 {
+		t.Fatalf("first job: got %v, shared %v; want both tasks", names(own), shared)
+	}
+	// The claim records the task, to be enqueued for another job if j1
+	// does not finish it.
+	sreq := own[0].(*analysis.ScanRequest)
+	var got analysis.ScanRequest
+	if err := json.Unmarshal(c.requests[sreq.WorkKey()], &got); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(sreq, &got); diff != "" {
+		t.Errorf("claimed request mismatch (-want, +got):\n%s", diff)
+	}
+	if !got.Share {
+		t.Error("got Share false, want true")
+	}
+
+	tasks := createAnalysisQueueTasks(params, "j2", "h", mods("b.com/b", "c.com/c", "d.com/d"))
+	c.fail = tasks[2].(*analysis.ScanRequest).WorkKey()
+	own, shared = shareTasks(ctx, c, "j2", tasks)
+	if diff := cmp.Diff([]string{"bin_c.com/c@v1.0.0", "bin_d.com/d@v1.0.0"}, names(own)); diff != "" {
+		t.Errorf("second job: mismatch (-want, +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(map[string]int{"j1": 1}, shared); diff != "" {
+		t.Errorf("second job: shared mismatch (-want, +got):\n%s", diff)
+	}
+
+	// A job with a table of its own scans every module itself.
+	ownParams := *params
+	ownParams.OwnTable = true
+	tasks = createAnalysisQueueTasks(&ownParams, "j3", "h", mods("a.com/a"))
+	if got, want := tasks[0].(*analysis.ScanRequest).Table, analysis.JobTableName("j3"); got != want {
+		t.Errorf("own table: got table %q, want %q", got, want)
+	}
+	own, shared = shareTasks(ctx, c, "j3", tasks)
+	if len(own) != 1 || len(shared) != 0 {
+		t.Errorf("own table: got %v, shared %v; want the task", names(own), shared)
+	}
+}
+
 func TestAnalysisScan(t *testing.T) {
 	const (
 		modulePath = "a.com/m"
@@ -115,7 +302,8 @@ func TestAnalysisScan(t *testing.T) {
 			ModulePath: modulePath,
 			Version:    version,
 			Files: map[string]string{
-				"go.mod": `module ` + modulePath,
+				"go.mod":  `module ` + modulePath,
+				"LICENSE": "Permission is hereby granted, free of charge, to any person",
 				"a.go": `
 package p
 func F()  { G() }
@@ -127,8 +315,10 @@ func G() {}
 
 	diff := func(want, got *analysis.Result) {
 		t.Helper()
+		// The Go environment depends on the machine running the test.
 		d := cmp.Diff(want, got,
-			cmpopts.IgnoreFields(analysis.Diagnostic{}, "Position"))
+			cmpopts.IgnoreFields(analysis.Diagnostic{}, "Position", "Fingerprint"),
+			cmpopts.IgnoreFields(analysis.Result{}, "GoEnv"))
 		if d != "" {
 			t.Errorf("mismatch (-want, +got)\n%s", d)
 		}
@@ -155,14 +345,23 @@ func G() {}
 	wv := analysis.WorkVersion{BinaryArgs: "-name G", BinaryVersion: "bv", SchemaVersion: "sv"}
 	got := s.scan(context.Background(), req, binaryPath, wv)
 	want := &analysis.Result{
-		ModulePath:    modulePath,
-		Version:       version,
-		SortVersion:   "1,2,3~",
-		CommitTime:    proxytest.CommitTime,
-		BinaryName:    "analyzer",
-		WorkVersion:   wv,
-		Error:         "",
-		ErrorCategory: "",
+		ModulePath:      modulePath,
+		Version:         version,
+		SortVersion:     "1,2,3~",
+		CommitTime:      proxytest.CommitTime,
+		BinaryName:      "analyzer",
+		ImportedBy:      bq.NullInt64{Valid: true},
+		Licenses:        []string{"MIT"},
+		Redistributable: bq.NullBool{Bool: true, Valid: true},
+		JobID:           bq.NullString{StringVal: "jid", Valid: true},
+		WorkVersion:     wv,
+		Error:           "",
+		ErrorCategory:   "",
+		DriverProtocol:  bq.NullInt64{Int64: analysis.ProtocolV1, Valid: true},
+		// The test proxy is not checked against the checksum database.
+		ChecksumVerification: bq.NullString{StringVal: modules.Unverified, Valid: true},
+		Network:              bq.NullString{StringVal: analysis.NetworkHost, Valid: true},
+		ModuleHash:           bq.NullString{StringVal: "h1:fw5GwsF22KfKO/UIjzrtHV0B9n8OF3/3dsBbm0V0XDs=", Valid: true},
 		Diagnostics: []*analysis.Diagnostic{
 			{
 				PackageID:    "a.com/m",
@@ -176,9 +375,13 @@ func G() {}
 		},
 	}
 	diff(want, got)
+	if !got.GoEnv.Valid {
+		t.Error("no Go environment in the fingerprint")
+	}
 
 	// Test that errors are put into the Result.
 	req.Binary = "bad"
+	wv.BinaryVersion = "bad"
 	got = s.scan(context.Background(), req, "yyy", wv)
 	// Trim varying part of error. The error is expected to be of the form
 	// "...executable file not found in $PATH: scan synthetic module error."
@@ -194,17 +397,156 @@ func G() {}
 	}
 
 	want = &analysis.Result{
-		ModulePath:    modulePath,
-		Version:       version,
-		SortVersion:   "1,2,3~",
-		BinaryName:    "bad",
-		WorkVersion:   wv,
-		ErrorCategory: "SYNTHETIC - MISC",
-		Error:         "executable file not found in",
+		ModulePath:           modulePath,
+		Version:              version,
+		SortVersion:          "1,2,3~",
+		BinaryName:           "bad",
+		ImportedBy:           bq.NullInt64{Valid: true},
+		JobID:                bq.NullString{StringVal: "jid", Valid: true},
+		WorkVersion:          wv,
+		ErrorCategory:        "SYNTHETIC - MISC",
+		ErrorCode:            bq.NullInt64{Int64: int64(derrors.CodeSyntheticModuleMisc), Valid: true},
+		Error:                "executable file not found in",
+		ChecksumVerification: bq.NullString{StringVal: modules.Unverified, Valid: true},
+		Network:              bq.NullString{StringVal: analysis.NetworkHost, Valid: true},
 	}
 	diff(want, got)
 }
 
+func TestAnalysisRun(t *testing.T) {
+	const (
+		modulePath = "a.com/m"
+		version    = "v1.2.3"
+	)
+	binaryPath := buildtest.GoBuild(t, "testdata/analyzer", "")
+	proxyClient, cleanup := proxytest.SetupTestClient(t, []*proxytest.Module{
+		{
+			ModulePath: modulePath,
+			Version:    version,
+			Files: map[string]string{
+				"go.mod": `module ` + modulePath,
+				"a.go": `
+package p
+func F()  { G() }
+func G() {}
+`},
+		},
+	})
+	defer cleanup()
+
+	s := &analysisServer{
+		Server: &Server{
+			proxyClient: proxyClient,
+			cfg:         &config.Config{BinaryDir: t.TempDir()},
+		},
+		openFile: func(string) (io.ReadCloser, error) { return os.Open(binaryPath) },
+	}
+	run := func(query string) (*analysis.Result, error) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/analysis/run?"+query, nil)
+		if err := s.handleRun(w, r); err != nil {
+			return nil, err
+		}
+		var got analysis.Result
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			return nil, err
+		}
+		return &got, nil
+	}
+
+	got, err := run("module=a.com/m&version=v1.2.3&binary=analyzer&args=-name+G&insecure=true&serve=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Error != "" {
+		t.Fatalf("got error %q", got.Error)
+	}
+	if got.ModulePath != modulePath || got.Version != version || got.BinaryArgs != "-name G" || got.BinaryVersion == "" {
+		t.Errorf("got %+v", got)
+	}
+	if len(got.Diagnostics) != 1 || got.Diagnostics[0].Message != "call of G(...)" {
+		t.Errorf("got diagnostics %+v, want one call of G", got.Diagnostics)
+	}
+
+	for _, query := range []string{
+		"version=v1.2.3&binary=analyzer",
+		"module=a.com/m&binary=analyzer",
+		"module=a.com/m&version=v1.2.3",
+		"module=a.com/m&version=v1.2.3&binary=a/b",
+		"module=a.com/m&version=v1.2.3&binary=analyzer&analyzers=bad-name!",
+	} {
+		if _, err := run(query); !errors.Is(err, derrors.InvalidArgument) {
+			t.Errorf("%s: got %v, want InvalidArgument", query, err)
+		}
+	}
+}
+
+func TestBinaryMetadata(t *testing.T) {
+	v1 := buildtest.GoBuild(t, "testdata/analyzer", "")
+	v2 := buildtest.GoBuild(t, "testdata/analyzerv2", "")
+	dir := t.TempDir()
+
+	got, err := readBinaryMetadata(nil, v1, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(analysis.V1Metadata(), got); diff != "" {
+		t.Errorf("v1: mismatch (-want, +got):\n%s", diff)
+	}
+
+	s := &analysisServer{Server: &Server{}}
+	want := &analysis.Metadata{
+		ProtocolVersion: analysis.ProtocolV2,
+		Analyzers:       []string{"findcall"},
+		OutputSchema:    analysis.OutputJSONTree,
+	}
+	for i := 0; i < 2; i++ { // the second time, from the cache
+		got, err = s.binaryMetadata(context.Background(), nil, v2, "h2", dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("v2: mismatch (-want, +got):\n%s", diff)
+		}
+		v2 = "missing"
+	}
+}
+
+func TestAnalysisPlan(t *testing.T) {
+	s := &analysisServer{Server: &Server{cfg: &config.Config{}}}
+	plan := func(query string) (*analysis.Plan, error) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/analysis/plan?"+query, nil)
+		if err := s.handlePlan(w, r); err != nil {
+			return nil, err
+		}
+		var got analysis.Plan
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			return nil, err
+		}
+		return &got, nil
+	}
+
+	got, err := plan("file=testdata/modules.txt&min=15")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &analysis.Plan{
+		NumModules: 2,
+		Modules: []scan.ModuleSpec{
+			{Path: "std", Version: "v1.19.4", ImportedBy: 2025760},
+			{Path: "golang.org/x/net", Version: "v0.4.0", ImportedBy: 20},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	if _, err := plan("file=testdata/modules.txt&min=x"); !errors.Is(err, derrors.InvalidArgument) {
+		t.Errorf("got %v, want InvalidArgument", err)
+	}
+}
+
 func TestParsePosition(t *testing.T) {
 	for _, test := range []struct {
 		pos      string
@@ -259,3 +601,89 @@ func TestReadSource(t *testing.T) {
 		})
 	}
 }
+
+func TestWorkVersionBuildConfig(t *testing.T) {
+	s := &analysisServer{Server: &Server{cfg: &config.Config{VersionID: "wv"}}}
+	req := &analysis.ScanRequest{ScanParams: analysis.ScanParams{
+		BuildTags: "linux, integration",
+		GoFlags:   " -mod=mod  -trimpath",
+	}}
+	got, err := s.workVersion(req, "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := analysis.WorkVersion{
+		BinaryVersion: "hash",
+		BuildTags:     bq.NullString{StringVal: "integration,linux", Valid: true},
+		GoFlags:       bq.NullString{StringVal: "-mod=mod -trimpath", Valid: true},
+		WorkerVersion: "wv",
+		SchemaVersion: analysis.SchemaVersion,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	req.GoFlags = "-tags=x"
+	if _, err := s.workVersion(req, "hash"); !errors.Is(err, derrors.InvalidArgument) {
+		t.Errorf("got %v, want InvalidArgument", err)
+	}
+}
+
+func TestEnqueueIdempotencyKey(t *testing.T) {
+	s := &analysisServer{Server: &Server{cfg: &config.Config{}, dynamic: config.NewDynamicConfig()}}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/analysis/enqueue?binary=b&idempotencykey=k", nil)
+	if err := s.handleEnqueue(w, r); !errors.Is(err, derrors.InvalidArgument) {
+		t.Errorf("key without user: got %v, want InvalidArgument", err)
+	}
+
+	for _, test := range []struct {
+		prev *jobs.EnqueueRecord
+		want string
+	}{
+		{
+			&jobs.EnqueueRecord{JobID: "j1"},
+			`enqueue with idempotency key "k" is in progress, job ID is j1`,
+		},
+		{
+			&jobs.EnqueueRecord{JobID: "j1", Done: true, NumEnqueued: 12},
+			`enqueued 12 analysis tasks successfully, job ID is j1 (repeated enqueue with idempotency key "k")`,
+		},
+	} {
+		if got := repeatedEnqueueMessage("k", test.prev); got != test.want {
+			t.Errorf("%+v:\ngot  %s\nwant %s", test.prev, got, test.want)
+		}
+	}
+}
+
+func TestAnalysisDiff(t *testing.T) {
+	fake := bigquery.NewFake()
+	s := &analysisServer{Server: &Server{bqClient: fake}}
+	diff := func(query string) (*analysis.BinaryDiff, error) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/analysis/diff?"+query, nil)
+		if err := s.handleDiff(w, r); err != nil {
+			return nil, err
+		}
+		var got analysis.BinaryDiff
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			return nil, err
+		}
+		return &got, nil
+	}
+
+	got, err := diff("binary=b&old=h1&new=h2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &analysis.BinaryDiff{Binary: "b", OldVersion: "h1", NewVersion: "h2", Analyzers: []*analysis.AnalyzerDiff{}}
+	if d := cmp.Diff(want, got); d != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", d)
+	}
+
+	for _, q := range []string{"binary=b&old=h1", "old=h1&new=h2", "binary=b&old=h1&new=h1"} {
+		if _, err := diff(q); !errors.Is(err, derrors.InvalidArgument) {
+			t.Errorf("%q: got %v, want InvalidArgument", q, err)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	bq "cloud.google.com/go/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/audit"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/log"
+)
+
+// defaultAuditLimit is the default number of actions served by /audit.
+const defaultAuditLimit = 50
+
+// recordAction writes an administrative action requested by r to the audit
+// table. Failing to record it does not undo the action, so errors are only
+// logged.
+func (s *Server) recordAction(ctx context.Context, r *http.Request, action, jobID, details string) {
+	a := newAction(r, action, jobID, details)
+	log.Infof(ctx, "audit: %s by %q: %s", a.Action, a.User, a.URL)
+	if s.bqClient == nil {
+		return
+	}
+	if err := s.bqClient.Upload(ctx, audit.TableName, a); err != nil {
+		log.Errorf(ctx, err, "recording %s action", action)
+	}
+}
+
+// newAction returns the audit row for an action requested by r.
+func newAction(r *http.Request, action, jobID, details string) *audit.Action {
+	url := *r.URL
+	url.Scheme = ""
+	url.Host = ""
+	user := r.FormValue("user")
+	return &audit.Action{
+		Action:       action,
+		User:         requestUser(r),
+		RequestedFor: bq.NullString{StringVal: user, Valid: user != ""},
+		URL:          url.String(),
+		JobID:        bq.NullString{StringVal: jobID, Valid: jobID != ""},
+		Details:      bq.NullString{StringVal: details, Valid: details != ""},
+	}
+}
+
+// requestUser returns the account that made the request r: the email in
+// its identity token, or else the empty string. The request's "user"
+// parameter is not consulted, since anyone can set it.
+//
+// The token is not verified here: Cloud Run has already done that
+// before the request reaches the worker.
+func requestUser(r *http.Request) string {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return ""
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ""
+	}
+	var claims struct {
+		Email string `json:"email"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return ""
+	}
+	return claims.Email
+}
+
+// handleAudit serves the most recent administrative actions as JSON,
+// newest first.
+//
+// audit?limit=N	serve at most N actions (default 50)
+func (s *Server) handleAudit(w http.ResponseWriter, r *http.Request) (err error) {
+	defer derrors.Wrap(&err, "Server.handleAudit")
+	if s.bqClient == nil {
+		return &serverError{err: errors.New("BigQuery not configured"), status: http.StatusNotImplemented}
+	}
+	limit := defaultAuditLimit
+	if l := r.FormValue("limit"); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit <= 0 {
+			return fmt.Errorf("bad limit %q: %w", l, derrors.InvalidArgument)
+		}
+	}
+	actions, err := audit.ReadRecent(r.Context(), s.bqClient, limit)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return writeJSON(w, actions)
+}
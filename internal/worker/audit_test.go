@@ -0,0 +1,85 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http/httptest"
+	"testing"
+
+	bq "cloud.google.com/go/bigquery"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"golang.org/x/pkgsite-metrics/internal/audit"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+)
+
+func TestRequestUser(t *testing.T) {
+	token := func(payload string) string {
+		return "Bearer header." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".sig"
+	}
+	for _, test := range []struct {
+		url, auth string
+		want      string
+	}{
+		{"/jobs/cancel?jobid=j", "", ""},
+		{"/jobs/cancel?jobid=j&user=alice", "", ""},
+		{"/jobs/cancel?jobid=j&user=alice", token(`{"email":"bob@example.com"}`), "bob@example.com"},
+		{"/jobs/cancel?jobid=j", token(`{"email":"bob@example.com"}`), "bob@example.com"},
+		{"/jobs/cancel?jobid=j", token(`not json`), ""},
+		{"/jobs/cancel?jobid=j", "Bearer opaque", ""},
+		{"/jobs/cancel?jobid=j", "Basic xyz", ""},
+	} {
+		r := httptest.NewRequest("GET", test.url, nil)
+		if test.auth != "" {
+			r.Header.Set("Authorization", test.auth)
+		}
+		if got := requestUser(r); got != test.want {
+			t.Errorf("%s, %q: got %q, want %q", test.url, test.auth, got, test.want)
+		}
+	}
+}
+
+func TestRecordAction(t *testing.T) {
+	ctx := context.Background()
+	fake := bigquery.NewFake()
+	if _, err := fake.CreateOrUpdateTable(ctx, audit.TableName); err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{bqClient: fake}
+	r := httptest.NewRequest("GET", "http://worker/jobs/cancel?jobid=alice-123&user=alice", nil)
+	r.Header.Set("Authorization", "Bearer header."+base64.RawURLEncoding.EncodeToString([]byte(`{"email":"bob@example.com"}`))+".sig")
+	s.recordAction(ctx, r, audit.Cancel, "alice-123", "")
+	rows := fake.Rows(audit.TableName)
+	if len(rows) != 1 {
+		t.Fatalf("got %d rows, want 1", len(rows))
+	}
+	want := &audit.Action{
+		Action:       audit.Cancel,
+		User:         "bob@example.com",
+		RequestedFor: bq.NullString{StringVal: "alice", Valid: true},
+		URL:          "/jobs/cancel?jobid=alice-123&user=alice",
+		JobID:        bq.NullString{StringVal: "alice-123", Valid: true},
+	}
+	if diff := cmp.Diff(want, rows[0], cmpopts.IgnoreFields(audit.Action{}, "CreatedAt")); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestHandleAuditLimit(t *testing.T) {
+	s := &Server{bqClient: bigquery.NewFake()}
+	for _, l := range []string{"0", "-1", "x"} {
+		r := httptest.NewRequest("GET", "/audit?limit="+l, nil)
+		if err := s.handleAudit(httptest.NewRecorder(), r); err == nil {
+			t.Errorf("limit=%s: got nil error", l)
+		}
+	}
+	r := httptest.NewRequest("GET", "/audit", nil)
+	w := httptest.NewRecorder()
+	if err := s.handleAudit(w, r); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Limits on the number of concurrent scans of each kind.
+//
+// Cloud Run limits the concurrency of all endpoints of an instance
+// equally, but analysis scans need much more memory and CPU than
+// govulncheck scans. Each scan endpoint has its own limit, and requests
+// beyond it are rejected with 429 Too Many Requests, so that Cloud Tasks
+// backs off and retries them later, possibly on another instance.
+
+package worker
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// scanRetryAfter is the delay suggested to Cloud Tasks when a scan is
+// rejected because too many scans of its kind are running.
+const scanRetryAfter = 30 * time.Second
+
+// A scanLimiter bounds the number of concurrent scans of one kind.
+// A nil *scanLimiter has no bound.
+type scanLimiter struct {
+	name string        // kind of scan, for messages
+	sem  chan struct{} // holds a value for each running scan
+}
+
+// newScanLimiter returns a limiter allowing max concurrent scans.
+// If max is not positive, it returns nil.
+func newScanLimiter(name string, max int) *scanLimiter {
+	if max <= 0 {
+		return nil
+	}
+	return &scanLimiter{name: name, sem: make(chan struct{}, max)}
+}
+
+// tryStart records the start of a scan, if fewer than the maximum are
+// running, and reports whether it did. If so, call the returned function
+// when the scan is done.
+func (l *scanLimiter) tryStart() (func(), bool) {
+	if l == nil {
+		return func() {}, true
+	}
+	select {
+	case l.sem <- struct{}{}:
+		return func() { <-l.sem }, true
+	default:
+		return nil, false
+	}
+}
+
+// limitHandler wraps a scan handler so that requests beyond the limit of
+// l are rejected with a Retry-After header.
+func limitHandler(l *scanLimiter, h func(http.ResponseWriter, *http.Request) error) func(http.ResponseWriter, *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		done, ok := l.tryStart()
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(scanRetryAfter.Seconds())))
+			return &serverError{
+				status: http.StatusTooManyRequests,
+				err:    fmt.Errorf("already running %d %s scans, the maximum", cap(l.sem), l.name),
+			}
+		}
+		defer done()
+		return h(w, r)
+	}
+}
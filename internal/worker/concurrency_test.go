@@ -0,0 +1,64 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLimitHandler(t *testing.T) {
+	l := newScanLimiter("analysis", 1)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	h := limitHandler(l, func(http.ResponseWriter, *http.Request) error {
+		started <- struct{}{}
+		<-release
+		return nil
+	})
+	call := func() (*httptest.ResponseRecorder, error) {
+		w := httptest.NewRecorder()
+		return w, h(w, httptest.NewRequest("GET", "/analysis/scan/m@v1.0.0", nil))
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := call()
+		errc <- err
+	}()
+	<-started
+
+	// A second scan is rejected while the first is running.
+	w, err := call()
+	var serr *serverError
+	if !errors.As(err, &serr) || serr.status != http.StatusTooManyRequests {
+		t.Fatalf("got %v, want a 429 error", err)
+	}
+	if got, want := w.Header().Get("Retry-After"), "30"; got != want {
+		t.Errorf("Retry-After: got %q, want %q", got, want)
+	}
+
+	close(release)
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	// A scan is accepted once the first is done.
+	go func() { <-started }()
+	if _, err := call(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNoScanLimit(t *testing.T) {
+	l := newScanLimiter("govulncheck", 0)
+	for i := 0; i < 3; i++ {
+		if _, ok := l.tryStart(); !ok {
+			t.Fatalf("scan %d rejected without a limit", i)
+		}
+	}
+}
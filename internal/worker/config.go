@@ -0,0 +1,109 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"cloud.google.com/go/firestore"
+	"golang.org/x/pkgsite-metrics/internal/audit"
+	"golang.org/x/pkgsite-metrics/internal/config"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/scan"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The Firestore document in the server's namespace that holds the dynamic
+// configuration. See config.Dynamic for its fields.
+const (
+	dynamicConfigCollection = "Config"
+	dynamicConfigDoc        = "worker"
+)
+
+// handleConfig serves the effective configuration of the worker: the
+// configuration from its environment, and the current dynamic configuration.
+func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/json")
+	return writeJSON(w, struct {
+		Static  *config.Config
+		Dynamic config.DynamicState
+	}{s.cfg, s.dynamic.State()})
+}
+
+// handleSkipModules edits the list of skipped modules in the dynamic
+// configuration, and records the change in the audit table. Each running
+// instance picks up the new list when it sees the document change.
+//
+// config/skip?add=PATH&remove=PATH&user=USER
+//
+// The add and remove parameters may be repeated.
+func (s *Server) handleSkipModules(w http.ResponseWriter, r *http.Request) (err error) {
+	defer derrors.Wrap(&err, "Server.handleSkipModules")
+	ctx := r.Context()
+	if err := r.ParseForm(); err != nil {
+		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+	}
+	add, remove := r.Form["add"], r.Form["remove"]
+	if len(add) == 0 && len(remove) == 0 {
+		return fmt.Errorf("%w: need add or remove", derrors.InvalidArgument)
+	}
+	if s.fsNamespace == nil {
+		return &serverError{err: errors.New("Firestore not configured"), status: http.StatusNotImplemented}
+	}
+	old, cur, err := editSkipModules(ctx, s.fsNamespace.Client(),
+		s.fsNamespace.Collection(dynamicConfigCollection).Doc(dynamicConfigDoc), add, remove)
+	if err != nil {
+		return err
+	}
+	s.recordAction(ctx, r, audit.SkipModules, "", fmt.Sprintf("%q -> %q", old, cur))
+	w.Header().Set("Content-Type", "application/json")
+	return writeJSON(w, cur)
+}
+
+// editSkipModules edits the skip list in the dynamic configuration document
+// doc, which need not exist, as config.EditSkipModules does. It returns the
+// list before and after the edit. Other fields of the document are left
+// alone.
+func editSkipModules(ctx context.Context, client *firestore.Client, doc *firestore.DocumentRef, add, remove []string) (old, cur []string, err error) {
+	defer derrors.Wrap(&err, "editSkipModules")
+	err = client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		d := config.DefaultDynamic()
+		ds, err := tx.Get(doc)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err == nil {
+			if err := ds.DataTo(d); err != nil {
+				return err
+			}
+		}
+		old = d.SkipModules
+		cur = config.EditSkipModules(old, add, remove)
+		return tx.Set(doc, map[string]any{"skip_modules": cur}, firestore.MergeAll)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return old, cur, nil
+}
+
+// skipModules returns the modules in modspecs that are not skipped by the
+// dynamic configuration d.
+func skipModules(d *config.Dynamic, modspecs []scan.ModuleSpec) []scan.ModuleSpec {
+	if len(d.SkipModules) == 0 {
+		return modspecs
+	}
+	var ms []scan.ModuleSpec
+	for _, m := range modspecs {
+		if !d.Skip(m.Path) {
+			ms = append(ms, m)
+		}
+	}
+	return ms
+}
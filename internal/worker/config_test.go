@@ -0,0 +1,60 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/pkgsite-metrics/internal/config"
+	"golang.org/x/pkgsite-metrics/internal/scan"
+)
+
+func TestSkipModules(t *testing.T) {
+	mods := []scan.ModuleSpec{{Path: "a.com/m"}, {Path: "b.com/x/y"}, {Path: "c.com/m"}}
+	d := &config.Dynamic{SkipModules: []string{"a.com/m", "b.com/..."}}
+	got := skipModules(d, mods)
+	want := []scan.ModuleSpec{{Path: "c.com/m"}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestReqMonitorMaxConcurrency(t *testing.T) {
+	s := &Server{dynamic: config.NewDynamicConfig()}
+	s.dynamic.Set(&config.Dynamic{RequestLimit: 100, MaxConcurrency: 1}, time.Now())
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	h := reqMonitorHandler(s, func(w http.ResponseWriter, r *http.Request) error {
+		close(started)
+		<-release
+		return nil
+	})
+	req := httptest.NewRequest("GET", "/govulncheck/scan/a.com/m@v1.0.0", nil)
+	errc := make(chan error)
+	go func() { errc <- h(httptest.NewRecorder(), req) }()
+	<-started
+
+	// A second scan is rejected while the first is running.
+	var serr *serverError
+	if err := h(httptest.NewRecorder(), req); !errors.As(err, &serr) || serr.status != http.StatusTooManyRequests {
+		t.Errorf("got %v, want status %d", err, http.StatusTooManyRequests)
+	}
+	close(release)
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if got := s.reqs.Load(); got != 1 {
+		t.Errorf("got %d requests, want 1", got)
+	}
+	if got := s.inflight.Load(); got != 0 {
+		t.Errorf("got %d requests in flight, want 0", got)
+	}
+}
@@ -0,0 +1,280 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Disk usage of scans.
+//
+// A scan is charged for the size of its module directory plus the growth
+// of the Go caches while it runs. If that exceeds the quota, the scan fails
+// with derrors.ScanModuleDiskLimitExceeded. Usage is checked after the
+// module is downloaded and periodically while it is scanned, so a scan
+// that runs away is stopped early. Before a scan starts, module
+// directories left behind by earlier scans are removed, least recently used
+// first, to make room for it.
+
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/event"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/log"
+)
+
+// diskLimitExceededCounter counts scans that exceeded their disk quota.
+var diskLimitExceededCounter = event.NewCounter("disk-limit-exceeded", &event.MetricOptions{Namespace: metricNamespace})
+
+// freeDiskSpace returns the number of bytes available on the file system
+// holding dir. It is overridden with a Unix-specific function.
+var freeDiskSpace = func(dir string) (int64, error) {
+	return 0, errors.ErrUnsupported
+}
+
+// dirSize returns the total size in bytes of the files in the given
+// directories. Missing directories have size zero.
+func dirSize(dirs ...string) (int64, error) {
+	var size int64
+	for _, dir := range dirs {
+		err := filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					// Missing, or removed while we walk.
+					return nil
+				}
+				return err
+			}
+			if d.Type().IsRegular() {
+				info, err := d.Info()
+				if err != nil {
+					if errors.Is(err, fs.ErrNotExist) {
+						return nil
+					}
+					return err
+				}
+				size += info.Size()
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return size, nil
+}
+
+// A diskQuota measures the disk usage of a scan against a limit.
+type diskQuota struct {
+	limit      int64    // in bytes; zero means no limit
+	dir        string   // module directory of the scan
+	cacheDirs  []string // shared directories whose growth is charged to the scan
+	cacheStart int64    // size of cacheDirs when the scan started
+}
+
+// newDiskQuota returns a quota of limit bytes for a scan whose module is in
+// dir. Growth in the size of cacheDirs is charged to the scan. If other
+// scans are running concurrently, some of their growth will be charged too.
+func newDiskQuota(limit int64, dir string, cacheDirs ...string) (*diskQuota, error) {
+	q := &diskQuota{limit: limit, dir: dir, cacheDirs: cacheDirs}
+	if limit <= 0 {
+		return q, nil
+	}
+	var err error
+	q.cacheStart, err = dirSize(cacheDirs...)
+	if err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// usage returns the number of bytes used by the scan.
+func (q *diskQuota) usage() (int64, error) {
+	n, err := dirSize(q.dir)
+	if err != nil {
+		return 0, err
+	}
+	c, err := dirSize(q.cacheDirs...)
+	if err != nil {
+		return 0, err
+	}
+	if c > q.cacheStart {
+		n += c - q.cacheStart
+	}
+	return n, nil
+}
+
+// check returns an error wrapping derrors.ScanModuleDiskLimitExceeded
+// if the scan uses more than its quota.
+func (q *diskQuota) check(ctx context.Context) error {
+	if q.limit <= 0 {
+		return nil
+	}
+	n, err := q.usage()
+	if err != nil {
+		// Don't fail the scan because it can't be measured.
+		log.Errorf(ctx, err, "measuring disk usage of %s", q.dir)
+		return nil
+	}
+	log.Debugf(ctx, "scan disk usage: %dMB of %dMB", n>>20, q.limit>>20)
+	if n > q.limit {
+		diskLimitExceededCounter.Record(ctx, 1)
+		return fmt.Errorf("%w: using %dMB, quota is %dMB", derrors.ScanModuleDiskLimitExceeded, n>>20, q.limit>>20)
+	}
+	return nil
+}
+
+// diskQuotaInterval is how often the disk quota of a running scan is checked.
+var diskQuotaInterval = 15 * time.Second
+
+// watch returns a context derived from ctx that is canceled, with the
+// quota error as its cause, when a periodic check finds the scan over its
+// quota. Call stop when the scan is done.
+func (q *diskQuota) watch(ctx context.Context) (_ context.Context, stop func()) {
+	if q.limit <= 0 {
+		return ctx, func() {}
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(diskQuotaInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := q.check(ctx); err != nil {
+					cancel(err)
+					return
+				}
+			}
+		}
+	}()
+	return ctx, func() {
+		close(done)
+		cancel(nil)
+	}
+}
+
+type diskQuotaKey struct{}
+
+// checkDiskQuota checks the disk quota of the scan running in ctx, if any.
+func checkDiskQuota(ctx context.Context) error {
+	if q, ok := ctx.Value(diskQuotaKey{}).(*diskQuota); ok {
+		return q.check(ctx)
+	}
+	return nil
+}
+
+// scanDirs holds the module directories of the running scans,
+// with the number of scans using each.
+var scanDirs = struct {
+	mu   sync.Mutex
+	dirs map[string]int
+}{dirs: map[string]int{}}
+
+// useScanDir records that a scan is using dir, so that it isn't removed by
+// makeRoom. Call the returned function when the scan is done.
+func useScanDir(dir string) func() {
+	scanDirs.mu.Lock()
+	defer scanDirs.mu.Unlock()
+	scanDirs.dirs[dir]++
+	return func() {
+		scanDirs.mu.Lock()
+		defer scanDirs.mu.Unlock()
+		scanDirs.dirs[dir]--
+		if scanDirs.dirs[dir] == 0 {
+			delete(scanDirs.dirs, dir)
+		}
+	}
+}
+
+// makeRoom removes module directories under root that are not in use,
+// least recently used first, until the file system holding root has at
+// least need bytes free.
+func makeRoom(ctx context.Context, root string, need int64) error {
+	free, err := freeDiskSpace(root)
+	if errors.Is(err, errors.ErrUnsupported) || errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if free >= need {
+		return nil
+	}
+	dirs, err := unusedModuleDirs(root)
+	if err != nil {
+		return err
+	}
+	for _, d := range dirs {
+		if free >= need {
+			break
+		}
+		size, err := dirSize(d.path)
+		if err != nil {
+			return err
+		}
+		if err := os.RemoveAll(d.path); err != nil {
+			return err
+		}
+		log.Infof(ctx, "removed %s (%dMB, last used %s) to free disk space", d.path, size>>20, d.lastUsed.Format(time.RFC3339))
+		free += size
+	}
+	if free < need {
+		log.Warnf(ctx, "only %dMB of disk space free, want %dMB", free>>20, need>>20)
+	}
+	return nil
+}
+
+// A moduleDirUse is a module directory and when it was last used.
+type moduleDirUse struct {
+	path     string
+	lastUsed time.Time
+}
+
+// unusedModuleDirs returns the module directories under root that are not
+// used by a running scan, least recently used first. A directory's last use
+// is its modification time.
+func unusedModuleDirs(root string) ([]moduleDirUse, error) {
+	scanDirs.mu.Lock()
+	defer scanDirs.mu.Unlock()
+
+	var dirs []moduleDirUse
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() || !strings.Contains(d.Name(), "@") {
+			return nil
+		}
+		// A module directory, as returned by moduleDir.
+		if scanDirs.dirs[path] == 0 {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			dirs = append(dirs, moduleDirUse{path, info.ModTime()})
+		}
+		return filepath.SkipDir
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(dirs, func(i, j int) bool { return dirs[i].lastUsed.Before(dirs[j].lastUsed) })
+	return dirs, nil
+}
@@ -0,0 +1,124 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+)
+
+func writeFileSize(t *testing.T, path string, size int) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, make([]byte, size), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDirSize(t *testing.T) {
+	dir := t.TempDir()
+	writeFileSize(t, filepath.Join(dir, "a"), 10)
+	writeFileSize(t, filepath.Join(dir, "b", "c"), 20)
+	got, err := dirSize(dir, filepath.Join(dir, "missing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(30); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
+
+func TestDiskQuota(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	mdir := filepath.Join(tmp, "m@v1.0.0")
+	cache := filepath.Join(tmp, "cache")
+	// Existing cache contents are not charged to the scan.
+	writeFileSize(t, filepath.Join(cache, "old"), 1000)
+
+	q, err := newDiskQuota(100, mdir, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFileSize(t, filepath.Join(mdir, "go.mod"), 40)
+	writeFileSize(t, filepath.Join(cache, "new"), 50)
+	if err := checkDiskQuota(context.WithValue(ctx, diskQuotaKey{}, q)); err != nil {
+		t.Fatalf("under quota: got %v", err)
+	}
+	writeFileSize(t, filepath.Join(cache, "newer"), 20)
+	if err := checkDiskQuota(context.WithValue(ctx, diskQuotaKey{}, q)); !errors.Is(err, derrors.ScanModuleDiskLimitExceeded) {
+		t.Errorf("over quota: got %v, want ScanModuleDiskLimitExceeded", err)
+	}
+	if got := derrors.CategorizeError(q.check(ctx)); got != "DISK LIMIT EXCEEDED" {
+		t.Errorf("got category %q", got)
+	}
+	// No quota in the context, or no limit.
+	if err := checkDiskQuota(ctx); err != nil {
+		t.Errorf("no quota: got %v", err)
+	}
+	if err := (&diskQuota{dir: mdir}).check(ctx); err != nil {
+		t.Errorf("no limit: got %v", err)
+	}
+}
+
+func TestDiskQuotaWatch(t *testing.T) {
+	defer func(d time.Duration) { diskQuotaInterval = d }(diskQuotaInterval)
+	diskQuotaInterval = time.Millisecond
+
+	mdir := filepath.Join(t.TempDir(), "m@v1.0.0")
+	q, err := newDiskQuota(100, mdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, stop := q.watch(context.Background())
+	defer stop()
+	writeFileSize(t, filepath.Join(mdir, "big"), 200)
+	<-ctx.Done()
+	if err := context.Cause(ctx); !errors.Is(err, derrors.ScanModuleDiskLimitExceeded) {
+		t.Errorf("got cause %v, want ScanModuleDiskLimitExceeded", err)
+	}
+}
+
+func TestMakeRoom(t *testing.T) {
+	root := t.TempDir()
+	now := time.Now()
+	for i, dir := range []string{"a.com/old@v1.0.0", "b.com/newer@v1.0.0", "a.com/used@v1.0.0", "c.com/newest@v1.0.0"} {
+		path := filepath.Join(root, dir)
+		writeFileSize(t, filepath.Join(path, "f"), 100)
+		mtime := now.Add(time.Duration(i) * time.Hour)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer useScanDir(filepath.Join(root, "a.com/used@v1.0.0"))()
+
+	free := int64(50)
+	defer func(f func(string) (int64, error)) { freeDiskSpace = f }(freeDiskSpace)
+	freeDiskSpace = func(string) (int64, error) { return free, nil }
+
+	// Need 250 bytes: removing the two least recently used,
+	// unused directories frees enough.
+	if err := makeRoom(context.Background(), root, 250); err != nil {
+		t.Fatal(err)
+	}
+	for dir, wantExist := range map[string]bool{
+		"a.com/old@v1.0.0":    false,
+		"b.com/newer@v1.0.0":  false,
+		"a.com/used@v1.0.0":   true,
+		"c.com/newest@v1.0.0": true,
+	} {
+		if got := fileExists(filepath.Join(root, dir)); got != wantExist {
+			t.Errorf("%s: exists = %t, want %t", dir, got, wantExist)
+		}
+	}
+}
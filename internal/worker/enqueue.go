@@ -5,26 +5,185 @@
 package worker
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
 	"sync"
+	"unicode"
 
+	bq "cloud.google.com/go/bigquery"
+	"golang.org/x/mod/semver"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
 	"golang.org/x/pkgsite-metrics/internal/config"
 	"golang.org/x/pkgsite-metrics/internal/derrors"
 	"golang.org/x/pkgsite-metrics/internal/log"
 	"golang.org/x/pkgsite-metrics/internal/pkgsitedb"
 	"golang.org/x/pkgsite-metrics/internal/queue"
 	"golang.org/x/pkgsite-metrics/internal/scan"
+	"golang.org/x/pkgsite-metrics/internal/version"
 )
 
-const defaultMinImportedByCount = 10
-
-func readModules(ctx context.Context, cfg *config.Config, file string, minImpCount int) ([]scan.ModuleSpec, error) {
-	if file != "" {
+// readModules reads the modules to enqueue. If file is non-empty, they are
+// read from that file. Otherwise, if corpusQuery is non-empty, they are read
+// from that BigQuery table or view, which must be one of
+// cfg.CorpusTables. Otherwise, they are read from the pkgsite DB.
+// Modules skipped by the dynamic configuration dyn are omitted.
+func readModules(ctx context.Context, cfg *config.Config, dyn *config.Dynamic, bqClient bigquery.DB, file, corpusQuery string, minImpCount int) (_ []scan.ModuleSpec, err error) {
+	var mods []scan.ModuleSpec
+	switch {
+	case file != "":
 		log.Infof(ctx, "reading modules from file %s", file)
-		return scan.ParseCorpusFile(file, minImpCount)
+		mods, err = scan.ParseCorpusFile(ctx, file, minImpCount)
+	case corpusQuery != "":
+		if !slices.Contains(cfg.CorpusTables, corpusQuery) {
+			return nil, fmt.Errorf("%w: corpusquery %q is not a corpus table of the worker", derrors.InvalidArgument, corpusQuery)
+		}
+		log.Infof(ctx, "reading modules from BigQuery table %s", corpusQuery)
+		mods, err = readFromBigQuery(ctx, bqClient, corpusQuery, minImpCount)
+	default:
+		log.Infof(ctx, "reading modules from DB %s", cfg.PkgsiteDBName)
+		mods, err = readFromDB(ctx, cfg, minImpCount)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return skipModules(dyn, mods), nil
+}
+
+// readFromBigQuery reads module specs from a BigQuery table or view with
+// the columns of corpusRow. See corpusQueryString.
+// A module is included if its imported-by count is missing or at least minImportedByCount.
+func readFromBigQuery(ctx context.Context, client bigquery.DB, table string, minImportedByCount int) (_ []scan.ModuleSpec, err error) {
+	defer derrors.Wrap(&err, "readFromBigQuery")
+	if client == nil {
+		return nil, errors.New("BigQuery is disabled")
+	}
+	q, err := corpusQueryString(table)
+	if err != nil {
+		return nil, err
+	}
+	iter, err := client.Query(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := bigquery.All[corpusRow](iter)
+	if err != nil {
+		return nil, err
+	}
+	return corpusRowsToModuleSpecs(rows, minImportedByCount), nil
+}
+
+// corpusRow is a row of a corpus query.
+type corpusRow struct {
+	ModulePath string        `bigquery:"module_path"`
+	Version    bq.NullString `bigquery:"version"`     // if null, use the latest version
+	ImportedBy bq.NullInt64  `bigquery:"imported_by"` // if null, do not filter by imported-by count
+}
+
+// corpusTableRegexp matches the full name of a BigQuery table or view,
+// optionally quoted in backticks.
+var corpusTableRegexp = regexp.MustCompile("^`?([a-zA-Z0-9_-]+[.:])?[a-zA-Z0-9_]+\\.[a-zA-Z0-9_-]+`?$")
+
+// corpusQueryString returns the query that reads the modules of the table
+// or view with the given full name. Table names cannot be query
+// parameters, so the name is checked to be nothing more than a name.
+func corpusQueryString(table string) (string, error) {
+	if !corpusTableRegexp.MatchString(table) {
+		return "", fmt.Errorf("%w: bad corpus table name %q", derrors.InvalidArgument, table)
+	}
+	return "SELECT module_path, version, imported_by FROM `" + strings.Trim(table, "`") + "`", nil
+}
+
+func corpusRowsToModuleSpecs(rows []*corpusRow, minImportedByCount int) []scan.ModuleSpec {
+	var mss []scan.ModuleSpec
+	for _, r := range rows {
+		ms := scan.ModuleSpec{Path: r.ModulePath, Version: version.Latest}
+		if r.Version.Valid && r.Version.StringVal != "" {
+			ms.Version = r.Version.StringVal
+		}
+		if r.ImportedBy.Valid {
+			if int(r.ImportedBy.Int64) < minImportedByCount {
+				continue
+			}
+			ms.ImportedBy = int(r.ImportedBy.Int64)
+		}
+		mss = append(mss, ms)
+	}
+	return mss
+}
+
+// A bodyModule is a module to enqueue, as listed in the body of a request.
+type bodyModule struct {
+	Module     string `json:"module"`
+	Version    string `json:"version"`
+	ImportedBy int    `json:"importedBy"`
+}
+
+// maxModulesBodySize is the maximum size of a module list in a request
+// body. Larger corpora should be read from a file.
+const maxModulesBodySize = 10 << 20
+
+// readBodyModules returns the modules in the body of r, if r is a POST
+// whose body is a JSON array of bodyModules. Otherwise it returns nil and
+// leaves the body unread, so that scan.ParseParams can read parameters
+// from it.
+func readBodyModules(r *http.Request) (_ []scan.ModuleSpec, err error) {
+	defer derrors.Wrap(&err, "readBodyModules")
+	if r.Method != http.MethodPost || r.Body == nil {
+		return nil, nil
+	}
+	br := bufio.NewReader(io.LimitReader(r.Body, maxModulesBodySize+1))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{br, r.Body}
+	if c, err := firstNonSpace(br); err != nil || c != '[' {
+		return nil, nil
+	}
+	data, err := io.ReadAll(br)
+	r.Body = http.NoBody
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxModulesBodySize {
+		return nil, fmt.Errorf("%w: module list larger than %d bytes; use a file", derrors.InvalidArgument, maxModulesBodySize)
+	}
+	var bms []bodyModule
+	if err := json.Unmarshal(data, &bms); err != nil {
+		return nil, fmt.Errorf("%w: bad module list: %v", derrors.InvalidArgument, err)
+	}
+	if len(bms) == 0 {
+		return nil, fmt.Errorf("%w: empty module list", derrors.InvalidArgument)
+	}
+	mods := make([]scan.ModuleSpec, len(bms))
+	for i, m := range bms {
+		if m.Module == "" || !semver.IsValid(m.Version) {
+			return nil, fmt.Errorf("%w: bad module %q at version %q", derrors.InvalidArgument, m.Module, m.Version)
+		}
+		mods[i] = scan.ModuleSpec{Path: m.Module, Version: m.Version, ImportedBy: m.ImportedBy}
+	}
+	return mods, nil
+}
+
+// firstNonSpace returns the first byte read by br that is not white space,
+// without consuming it.
+func firstNonSpace(br *bufio.Reader) (byte, error) {
+	for {
+		c, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if !unicode.IsSpace(rune(c)) {
+			return c, br.UnreadByte()
+		}
 	}
-	log.Infof(ctx, "reading modules from DB %s", cfg.PkgsiteDBName)
-	return readFromDB(ctx, cfg, minImpCount)
 }
 
 func readFromDB(ctx context.Context, cfg *config.Config, minImportedByCount int) ([]scan.ModuleSpec, error) {
@@ -0,0 +1,130 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	bq "cloud.google.com/go/bigquery"
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/config"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+	"golang.org/x/pkgsite-metrics/internal/scan"
+)
+
+func TestCorpusQueryString(t *testing.T) {
+	for _, test := range []struct {
+		in, want string
+	}{
+		{
+			"proj.dataset.view",
+			"SELECT module_path, version, imported_by FROM `proj.dataset.view`",
+		},
+		{
+			"`proj.dataset.view`",
+			"SELECT module_path, version, imported_by FROM `proj.dataset.view`",
+		},
+		{
+			"dataset.table_1",
+			"SELECT module_path, version, imported_by FROM `dataset.table_1`",
+		},
+	} {
+		got, err := corpusQueryString(test.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("%q:\ngot  %s\nwant %s", test.in, got, test.want)
+		}
+	}
+
+	for _, in := range []string{
+		"SELECT DISTINCT module_path FROM t",
+		"proj.dataset.view`; DROP TABLE x; --",
+		"proj.dataset.view) UNION ALL (SELECT 1",
+		"view",
+	} {
+		if _, err := corpusQueryString(in); !errors.Is(err, derrors.InvalidArgument) {
+			t.Errorf("%q: got %v, want InvalidArgument", in, err)
+		}
+	}
+}
+
+func TestReadModulesCorpusTables(t *testing.T) {
+	cfg := &config.Config{CorpusTables: []string{"proj.dataset.corpus"}}
+	_, err := readModules(context.Background(), cfg, nil, nil, "", "proj.dataset.other", 0)
+	if !errors.Is(err, derrors.InvalidArgument) {
+		t.Errorf("got %v, want InvalidArgument", err)
+	}
+}
+
+func TestCorpusRowsToModuleSpecs(t *testing.T) {
+	rows := []*corpusRow{
+		{ModulePath: "a.com/a", Version: bigquery.NullString("v1.0.0"), ImportedBy: bq.NullInt64{Int64: 20, Valid: true}},
+		{ModulePath: "b.com/b", Version: bigquery.NullString("v1.2.0"), ImportedBy: bq.NullInt64{Int64: 2, Valid: true}},
+		{ModulePath: "c.com/c"},
+	}
+	got := corpusRowsToModuleSpecs(rows, 10)
+	want := []scan.ModuleSpec{
+		{Path: "a.com/a", Version: "v1.0.0", ImportedBy: 20},
+		{Path: "c.com/c", Version: "latest", ImportedBy: 0},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestReadBodyModules(t *testing.T) {
+	post := func(body string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/govulncheck/enqueue?mode=govulncheck", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		return r
+	}
+
+	r := post(` [{"module": "example.com/a", "version": "v1.2.3", "importedBy": 4}, {"module": "example.com/b", "version": "v0.1.0"}]`)
+	got, err := readBodyModules(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []scan.ModuleSpec{
+		{Path: "example.com/a", Version: "v1.2.3", ImportedBy: 4},
+		{Path: "example.com/b", Version: "v0.1.0"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	// A JSON object body holds parameters, which ParseParams can still read.
+	r = post(`{"suffix": "x"}`)
+	got, err = readBodyModules(r)
+	if err != nil || got != nil {
+		t.Fatalf("object body: got %v, %v, want nil, nil", got, err)
+	}
+	var params govulncheck.EnqueueQueryParams
+	if err := scan.ParseParams(r, &params); err != nil {
+		t.Fatal(err)
+	}
+	if params.Suffix != "x" || params.Mode != "govulncheck" {
+		t.Errorf("got params %+v, want suffix x and mode govulncheck", params)
+	}
+
+	for _, body := range []string{
+		`[]`,
+		`[{"module": "example.com/a"}]`,
+		`[{"module": "example.com/a", "version": "latest"}]`,
+		`[{"module": "example.com/a", "version": "v1.0.0"}`,
+	} {
+		if _, err := readBodyModules(post(body)); !errors.Is(err, derrors.InvalidArgument) {
+			t.Errorf("%s: got %v, want InvalidArgument", body, err)
+		}
+	}
+}
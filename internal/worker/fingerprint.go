@@ -0,0 +1,90 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	bq "cloud.google.com/go/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/analysis"
+	"golang.org/x/pkgsite-metrics/internal/log"
+)
+
+// fingerprintEnvVars are the variables of the worker's environment that
+// affect how the Go command loads packages, and so are recorded in
+// fingerprints when they are set.
+var fingerprintEnvVars = []string{
+	"CGO_ENABLED", "GOAMD64", "GOEXPERIMENT", "GOFLAGS", "GONOSUMDB",
+	"GOPRIVATE", "GOPROXY", "GOTOOLCHAIN",
+}
+
+// defaultGoVersion returns the version of the worker's default Go
+// toolchain, like "go1.22.3", or the empty string if it is not known.
+var defaultGoVersion = sync.OnceValue(func() string {
+	out, err := exec.Command("go", "env", "GOVERSION").Output()
+	if err != nil {
+		log.Warnf(context.Background(), "go env GOVERSION: %v", err)
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+})
+
+// analysisGoEnv returns the Go environment that an analysis binary runs
+// in, as set up by runAnalysisBinary: the relevant variables of the
+// worker's environment, overridden by goflags, the Go toolchain version
+// goVersion, if it is not empty, and whether the module cache is a
+// snapshot.
+func analysisGoEnv(goflags, goVersion string, modCacheSnapshot bool) map[string]string {
+	env := map[string]string{
+		"GOOS":   runtime.GOOS,
+		"GOARCH": runtime.GOARCH,
+	}
+	for _, k := range fingerprintEnvVars {
+		if v := os.Getenv(k); v != "" {
+			env[k] = v
+		}
+	}
+	if goflags != "" {
+		env["GOFLAGS"] = goflags
+	}
+	if goVersion != "" {
+		env["GOTOOLCHAIN"] = "local"
+		env["GOVERSION"] = goVersion
+	} else if v := defaultGoVersion(); v != "" {
+		env["GOVERSION"] = v
+	}
+	if modCacheSnapshot {
+		env["GOPROXY"] = "off"
+	}
+	return env
+}
+
+// setFingerprint records in row the fingerprint of the scan of the module
+// prepared in moduleDir: its Go environment env, the hash of its go.sum
+// file, and moduleHash, the hash of its files as downloaded.
+func setFingerprint(ctx context.Context, row *analysis.Result, env map[string]string, moduleDir, moduleHash string) {
+	if data, err := json.Marshal(env); err == nil {
+		row.GoEnv = bq.NullString{StringVal: string(data), Valid: true}
+	}
+	row.ModuleHash = bq.NullString{StringVal: moduleHash, Valid: moduleHash != ""}
+	goSum := filepath.Join(moduleDir, "go.sum")
+	if !fileExists(goSum) {
+		// A module without dependencies has no go.sum file.
+		return
+	}
+	h, err := hashFile(goSum)
+	if err != nil {
+		log.Warnf(ctx, "fingerprint: %v", err)
+		return
+	}
+	row.GoSumHash = bq.NullString{StringVal: h, Valid: true}
+}
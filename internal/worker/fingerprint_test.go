@@ -0,0 +1,73 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"golang.org/x/pkgsite-metrics/internal/analysis"
+)
+
+func TestAnalysisGoEnv(t *testing.T) {
+	t.Setenv("CGO_ENABLED", "0")
+	t.Setenv("GOFLAGS", "-mod=readonly")
+	t.Setenv("GOPROXY", "https://proxy.golang.org")
+
+	env := analysisGoEnv("-tags=integration", "go1.22.3", true)
+	for k, want := range map[string]string{
+		"GOOS":        runtime.GOOS,
+		"GOARCH":      runtime.GOARCH,
+		"CGO_ENABLED": "0",
+		"GOFLAGS":     "-tags=integration",
+		"GOTOOLCHAIN": "local",
+		"GOVERSION":   "go1.22.3",
+		"GOPROXY":     "off",
+	} {
+		if got := env[k]; got != want {
+			t.Errorf("%s: got %q, want %q", k, got, want)
+		}
+	}
+
+	env = analysisGoEnv("", "", false)
+	if got, want := env["GOFLAGS"], "-mod=readonly"; got != want {
+		t.Errorf("GOFLAGS from the environment: got %q, want %q", got, want)
+	}
+	if got, want := env["GOPROXY"], "https://proxy.golang.org"; got != want {
+		t.Errorf("GOPROXY from the environment: got %q, want %q", got, want)
+	}
+}
+
+func TestSetFingerprint(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	env := map[string]string{"GOOS": "linux", "GOFLAGS": "-tags=x"}
+
+	var row analysis.Result
+	setFingerprint(ctx, &row, env, dir, "h1:abc=")
+	if got, want := row.GoEnv.StringVal, `{"GOFLAGS":"-tags=x","GOOS":"linux"}`; got != want {
+		t.Errorf("GoEnv: got %s, want %s", got, want)
+	}
+	if got, want := row.ModuleHash.StringVal, "h1:abc="; got != want {
+		t.Errorf("ModuleHash: got %s, want %s", got, want)
+	}
+	if row.GoSumHash.Valid {
+		t.Errorf("GoSumHash without go.sum: got %s, want null", row.GoSumHash.StringVal)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "go.sum"), []byte("golang.org/x/mod v0.14.0 h1:xyz=\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	setFingerprint(ctx, &row, env, dir, "")
+	if !row.GoSumHash.Valid || len(row.GoSumHash.StringVal) != 64 {
+		t.Errorf("GoSumHash: got %v, want a SHA-256 hash", row.GoSumHash)
+	}
+	if row.ModuleHash.Valid {
+		t.Errorf("ModuleHash: got %s, want null", row.ModuleHash.StringVal)
+	}
+}
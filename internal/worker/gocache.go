@@ -0,0 +1,320 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Cleanup of the Go caches.
+//
+// Scans fill the Go build and module caches. Emptying them whenever the
+// worker becomes idle throws away warm state that closely spaced tasks
+// could reuse, so they are cleaned only when no scans are running and they
+// are larger than their limits. The build cache is then emptied. The module
+// cache loses its least recently used modules until it is under its limit.
+
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/exp/event"
+	"golang.org/x/mod/module"
+	"golang.org/x/pkgsite-metrics/internal/config"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/log"
+)
+
+var (
+	// goCacheCleanupCounter counts cleanups, by cache ("build" or "mod").
+	goCacheCleanupCounter = event.NewCounter("go-cache-cleanups", &event.MetricOptions{Namespace: metricNamespace})
+	// goModCacheEvictionCounter counts modules evicted from the module cache.
+	goModCacheEvictionCounter = event.NewCounter("go-mod-cache-evictions", &event.MetricOptions{Namespace: metricNamespace})
+	// goModCacheHitCounter and goModCacheMissCounter count scans that
+	// found the module they scan in the module cache, and scans that
+	// downloaded it. Their ratio is the hit rate of the module cache.
+	goModCacheHitCounter  = event.NewCounter("go-mod-cache-hits", &event.MetricOptions{Namespace: metricNamespace})
+	goModCacheMissCounter = event.NewCounter("go-mod-cache-misses", &event.MetricOptions{Namespace: metricNamespace})
+)
+
+// goBuildCacheSize and goModCacheSize are the sizes in bytes of the caches
+// after they were last checked for cleanup, for /metrics.
+var goBuildCacheSize, goModCacheSize atomic.Int64
+
+// accessTime returns the time the file described by info was last used: the
+// later of its access and modification times, if the access time is known.
+// It is overridden on Linux.
+var accessTime = func(info fs.FileInfo) time.Time {
+	return info.ModTime()
+}
+
+// goCacheDirs returns the build and module cache directories used by
+// scans.
+func goCacheDirs(insecure bool) (build, mod string, err error) {
+	if !insecure {
+		return filepath.Join(sandboxRoot, sandboxGoCache), filepath.Join(sandboxRoot, sandboxGoModCache), nil
+	}
+	return hostGoCacheDirs()
+}
+
+var hostGoCaches struct {
+	once       sync.Once
+	build, mod string
+	err        error
+}
+
+// hostGoCacheDirs returns the cache directories of the go command that runs
+// insecure scans.
+func hostGoCacheDirs() (build, mod string, err error) {
+	c := &hostGoCaches
+	c.once.Do(func() {
+		var err1, err2 error
+		c.build, err1 = goEnv("GOCACHE")
+		c.mod, err2 = goEnv("GOMODCACHE")
+		c.err = errors.Join(err1, err2)
+	})
+	return c.build, c.mod, c.err
+}
+
+func goEnv(name string) (string, error) {
+	out, err := goOutput("", nil, "env", name)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// cleanGoCaches cleans the Go build and module caches that are larger than
+// their limits. It is called when no scans are running.
+func cleanGoCaches(ctx context.Context, insecure bool, limits scanLimits) {
+	if insecure && !config.OnCloudRun() {
+		// Avoid cleaning the developer's local caches.
+		log.Infof(ctx, "not on Cloud Run, so not cleaning caches")
+		return
+	}
+	// The caches of secure scans are created and populated outside of the
+	// sandbox. We cannot clear them from within the sandbox since "any
+	// modifications to the root filesystem are destroyed with the container"
+	// (https://gvisor.dev/docs/user_guide/filesystem/). We hence clean them
+	// from the outside.
+	buildDir, modDir, err := goCacheDirs(insecure)
+	if err != nil {
+		log.Errorf(ctx, err, "finding Go caches")
+		return
+	}
+	if err := cleanGoBuildCache(ctx, buildDir, limits.goBuildCache); err != nil {
+		log.Errorf(ctx, err, "cleaning Go build cache")
+	}
+	if err := cleanGoModCache(ctx, modDir, limits.goModCache); err != nil {
+		log.Errorf(ctx, err, "cleaning Go module cache")
+	}
+}
+
+// cleanGoBuildCache empties the build cache in dir if it is larger than
+// limit bytes.
+func cleanGoBuildCache(ctx context.Context, dir string, limit int64) (err error) {
+	defer derrors.Wrap(&err, "cleanGoBuildCache(%q)", dir)
+	size, err := dirSize(dir)
+	if err != nil {
+		return err
+	}
+	goBuildCacheSize.Store(size)
+	if limit > 0 && size <= limit {
+		log.Debugf(ctx, "Go build cache is %dMB, limit %dMB; not cleaning", size>>20, limit>>20)
+		return nil
+	}
+	if err := goClean("-cache", "GOCACHE="+dir); err != nil {
+		return err
+	}
+	goCacheCleanupCounter.Record(ctx, 1, event.String("cache", "build"))
+	goBuildCacheSize.Store(0)
+	log.Infof(ctx, "cleaned Go build cache of %dMB", size>>20)
+	return nil
+}
+
+// cleanGoModCache removes the least recently used modules from the module
+// cache in dir until it is at most limit bytes. If limit is zero, it
+// empties the cache.
+func cleanGoModCache(ctx context.Context, dir string, limit int64) (err error) {
+	defer derrors.Wrap(&err, "cleanGoModCache(%q)", dir)
+	if limit <= 0 {
+		if err := goClean("-modcache", "GOMODCACHE="+dir); err != nil {
+			return err
+		}
+		goCacheCleanupCounter.Record(ctx, 1, event.String("cache", "mod"))
+		goModCacheSize.Store(0)
+		log.Infof(ctx, "cleaned Go module cache")
+		return nil
+	}
+	size, err := dirSize(dir)
+	if err != nil {
+		return err
+	}
+	goModCacheSize.Store(size)
+	if size <= limit {
+		log.Debugf(ctx, "Go module cache is %dMB, limit %dMB; not cleaning", size>>20, limit>>20)
+		return nil
+	}
+	mods, err := modCacheEntries(dir)
+	if err != nil {
+		return err
+	}
+	n := 0
+	before := size
+	for _, m := range mods {
+		if size <= limit {
+			break
+		}
+		freed, err := evictModule(dir, m.path)
+		if err != nil {
+			return err
+		}
+		size -= freed
+		n++
+	}
+	goCacheCleanupCounter.Record(ctx, 1, event.String("cache", "mod"))
+	goModCacheEvictionCounter.Record(ctx, int64(n))
+	goModCacheSize.Store(size)
+	log.Infof(ctx, "evicted %d modules from Go module cache: %dMB to %dMB", n, before>>20, size>>20)
+	return nil
+}
+
+// goClean runs 'go clean' with the given flag and environment variable
+// setting.
+func goClean(flag, env string) error {
+	c := exec.Command("go", "clean", flag)
+	c.Env = append(os.Environ(), env)
+	if out, err := c.CombinedOutput(); err != nil {
+		return fmt.Errorf("'go clean %s' failed: %s: %s", flag, derrors.IncludeStderr(err), out)
+	}
+	return nil
+}
+
+// modCacheEntries returns the module directories of the module cache in dir,
+// as paths relative to dir, least recently used first.
+// A module is used when it is extracted, or when the go command reads its
+// go.mod file in the download cache. (The access times of directories are
+// no use: measuring the size of the cache reads them all.)
+func modCacheEntries(dir string) ([]moduleDirUse, error) {
+	var mods []moduleDirUse
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if rel == "cache" {
+			// The download cache; its files are removed with their modules.
+			return filepath.SkipDir
+		}
+		if !strings.Contains(d.Name(), "@") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		last := info.ModTime()
+		if modPath, version, ok := splitModCacheDir(rel); ok {
+			if info, err := os.Stat(filepath.Join(dir, "cache", "download", modPath, "@v", version+".mod")); err == nil {
+				if t := accessTime(info); t.After(last) {
+					last = t
+				}
+			}
+		}
+		mods = append(mods, moduleDirUse{rel, last})
+		return filepath.SkipDir
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(mods, func(i, j int) bool { return mods[i].lastUsed.Before(mods[j].lastUsed) })
+	return mods, nil
+}
+
+// splitModCacheDir splits the relative path of a module directory in the
+// module cache, like "golang.org/x/text@v0.3.0", into its escaped module
+// path and version.
+func splitModCacheDir(rel string) (modPath, version string, ok bool) {
+	i := strings.LastIndex(rel, "@")
+	if i < 0 {
+		return "", "", false
+	}
+	return rel[:i], rel[i+1:], true
+}
+
+// evictModule removes the module directory rel from the module cache in dir,
+// with its zip file in the download cache. Its go.mod and info files are
+// kept; they are small, and needed to load the module graphs of modules that
+// require it. It returns the number of bytes freed.
+func evictModule(dir, rel string) (int64, error) {
+	mdir := filepath.Join(dir, rel)
+	paths := []string{mdir}
+	if modPath, version, ok := splitModCacheDir(rel); ok {
+		vdir := filepath.Join(dir, "cache", "download", modPath, "@v")
+		paths = append(paths, filepath.Join(vdir, version+".zip"), filepath.Join(vdir, version+".ziphash"))
+	}
+	size, err := dirSize(paths...)
+	if err != nil {
+		return 0, err
+	}
+	// The go command makes module directories read-only.
+	err = filepath.WalkDir(mdir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return os.Chmod(path, 0o755)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return 0, err
+	}
+	for _, p := range paths {
+		if err := os.RemoveAll(p); err != nil {
+			return 0, err
+		}
+	}
+	return size, nil
+}
+
+// modCacheHasZip reports whether the download cache of the module cache in
+// dir has the zip file of modulePath@version. Checking only the scanned
+// module, and not its dependencies, avoids walking the whole cache on every
+// scan.
+func modCacheHasZip(dir, modulePath, version string) bool {
+	ep, err1 := module.EscapePath(modulePath)
+	ev, err2 := module.EscapeVersion(version)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(dir, "cache", "download", ep, "@v", ev+".zip"))
+	return err == nil
+}
+
+// recordModCacheUse records whether a scan of a module found its zip file in
+// the module cache, or downloaded it.
+func recordModCacheUse(ctx context.Context, hit bool) {
+	if hit {
+		goModCacheHitCounter.Record(ctx, 1)
+	} else {
+		goModCacheMissCounter.Record(ctx, 1)
+	}
+}
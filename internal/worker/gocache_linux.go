@@ -0,0 +1,25 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package worker
+
+import (
+	"io/fs"
+	"syscall"
+	"time"
+)
+
+func init() {
+	accessTime = func(info fs.FileInfo) time.Time {
+		t := info.ModTime()
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			if a := time.Unix(st.Atim.Unix()); a.After(t) {
+				t = a
+			}
+		}
+		return t
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// writeModCacheEntry writes a module to the module cache in dir, as the go
+// command would, with size bytes of source and a zip of size bytes. The
+// module was last used at time t.
+func writeModCacheEntry(t *testing.T, dir, modPath, version string, size int, used time.Time) {
+	t.Helper()
+	mdir := filepath.Join(dir, modPath+"@"+version)
+	writeFileSize(t, filepath.Join(mdir, "go.mod"), size)
+	vdir := filepath.Join(dir, "cache", "download", modPath, "@v")
+	for _, ext := range []string{".zip", ".mod", ".info", ".ziphash"} {
+		n := 1
+		if ext == ".zip" {
+			n = size
+		}
+		writeFileSize(t, filepath.Join(vdir, version+ext), n)
+	}
+	for _, p := range []string{mdir, filepath.Join(vdir, version+".mod")} {
+		if err := os.Chtimes(p, used, used); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Module directories are read-only.
+	if err := os.Chmod(mdir, 0o555); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestModCacheEntries(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	writeModCacheEntry(t, dir, "example.com/a", "v1.0.0", 10, now.Add(-time.Hour))
+	writeModCacheEntry(t, dir, "example.com/b", "v1.0.0", 10, now.Add(-3*time.Hour))
+	writeModCacheEntry(t, dir, "example.com/!upper/c", "v0.1.0", 10, now.Add(-2*time.Hour))
+
+	mods, err := modCacheEntries(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, m := range mods {
+		got = append(got, m.path)
+	}
+	want := []string{"example.com/b@v1.0.0", "example.com/!upper/c@v0.1.0", "example.com/a@v1.0.0"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestCleanGoModCache(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	now := time.Now()
+	writeModCacheEntry(t, dir, "example.com/a", "v1.0.0", 1000, now.Add(-time.Hour))
+	writeModCacheEntry(t, dir, "example.com/b", "v1.0.0", 1000, now.Add(-3*time.Hour))
+	writeModCacheEntry(t, dir, "example.com/c", "v1.0.0", 1000, now.Add(-2*time.Hour))
+
+	// Under the limit: nothing is removed.
+	if err := cleanGoModCache(ctx, dir, 100_000); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []string{"example.com/a", "example.com/b", "example.com/c"} {
+		if !modCacheHasZip(dir, m, "v1.0.0") {
+			t.Fatalf("%s: zip missing", m)
+		}
+	}
+
+	// Each module takes about 2000 bytes. Evicting the least recently
+	// used, b and then c, gets under the limit.
+	if err := cleanGoModCache(ctx, dir, 2500); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		modPath string
+		want    bool
+	}{
+		{"example.com/a", true},
+		{"example.com/b", false},
+		{"example.com/c", false},
+	} {
+		_, err := os.Stat(filepath.Join(dir, test.modPath+"@v1.0.0"))
+		if got := err == nil; got != test.want {
+			t.Errorf("%s: got present=%t, want %t", test.modPath, got, test.want)
+		}
+		_, err = os.Stat(filepath.Join(dir, "cache", "download", test.modPath, "@v", "v1.0.0.zip"))
+		if got := err == nil; got != test.want {
+			t.Errorf("%s zip: got present=%t, want %t", test.modPath, got, test.want)
+		}
+		// The go.mod file is kept.
+		if _, err := os.Stat(filepath.Join(dir, "cache", "download", test.modPath, "@v", "v1.0.0.mod")); err != nil {
+			t.Errorf("%s: %v", test.modPath, err)
+		}
+	}
+	if got := goModCacheSize.Load(); got > 2500 {
+		t.Errorf("recorded size %d, want at most 2500", got)
+	}
+}
+
+func TestSplitModCacheDir(t *testing.T) {
+	for _, test := range []struct {
+		in            string
+		wantPath, ver string
+		wantOK        bool
+	}{
+		{"golang.org/x/text@v0.3.0", "golang.org/x/text", "v0.3.0", true},
+		{"example.com/!upper@v1.0.0-!r!c1", "example.com/!upper", "v1.0.0-!r!c1", true},
+		{"example.com/m", "", "", false},
+	} {
+		p, v, ok := splitModCacheDir(test.in)
+		if p != test.wantPath || v != test.ver || ok != test.wantOK {
+			t.Errorf("%q: got %q, %q, %t; want %q, %q, %t", test.in, p, v, ok, test.wantPath, test.ver, test.wantOK)
+		}
+	}
+}
+
+func TestModCacheHasZip(t *testing.T) {
+	dir := t.TempDir()
+	writeModCacheEntry(t, dir, "example.com/!upper", "v1.0.0", 10, time.Now())
+	if !modCacheHasZip(dir, "example.com/Upper", "v1.0.0") {
+		t.Error("example.com/Upper@v1.0.0: got false, want true")
+	}
+	if modCacheHasZip(dir, "example.com/Upper", "v1.1.0") {
+		t.Error("example.com/Upper@v1.1.0: got true, want false")
+	}
+}
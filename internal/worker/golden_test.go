@@ -0,0 +1,136 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/pkgsite-metrics/internal/analysis"
+	"golang.org/x/pkgsite-metrics/internal/buildtest"
+	"golang.org/x/pkgsite-metrics/internal/config"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+	"golang.org/x/pkgsite-metrics/internal/proxy/proxytest"
+	"golang.org/x/pkgsite-metrics/internal/scan"
+	test "golang.org/x/pkgsite-metrics/internal/testing"
+)
+
+// The golden tests run the scan pipeline on the modules in
+// testdata/golden/modules and compare the rows it produces with the
+// files in testdata/golden/analysis and testdata/golden/govulncheck.
+// Run them with -update-golden after an intended change to the rows.
+
+const goldenDir = "testdata/golden"
+
+func TestAnalysisGolden(t *testing.T) {
+	binaryPath := buildtest.GoBuild(t, "testdata/analyzer", "")
+	proxyClient, cleanup := proxytest.SetupTestClient(t, proxytest.LoadTestModules(filepath.Join(goldenDir, "modules")))
+	defer cleanup()
+
+	s := &analysisServer{
+		Server: &Server{
+			proxyClient: proxyClient,
+			cfg: &config.Config{
+				BinaryBucket: "unused",
+				BinaryDir:    t.TempDir(),
+			},
+		},
+	}
+	wv := analysis.WorkVersion{BinaryArgs: "-name G", BinaryVersion: "bv", SchemaVersion: "sv"}
+	for _, mod := range []string{"calls", "clean", "broken"} {
+		t.Run(mod, func(t *testing.T) {
+			req := &analysis.ScanRequest{
+				ModuleURLPath: scan.ModuleURLPath{Module: "example.com/" + mod, Version: "v1.0.0"},
+				ScanParams: analysis.ScanParams{
+					Binary:   "analyzer",
+					Args:     "-name G",
+					Insecure: true,
+					JobID:    "jid",
+				},
+			}
+			row := s.scan(context.Background(), req, binaryPath, wv)
+			normalizeAnalysisResult(row, binaryPath)
+			test.CheckGolden(t, filepath.Join(goldenDir, "analysis", mod+"@v1.0.0.json"), row)
+		})
+	}
+}
+
+func TestGovulncheckGolden(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test that uses internet in short mode")
+	}
+
+	govulncheckPath, err := buildtest.BuildGovulncheck(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	vulndb, err := filepath.Abs("../testdata/vulndb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyClient, cleanup := proxytest.SetupTestClient(t, proxytest.LoadTestModules(filepath.Join(goldenDir, "modules")))
+	defer cleanup()
+
+	s := &scanner{
+		proxyClient:     proxyClient,
+		workVersion:     &govulncheck.WorkVersion{WorkerVersion: "wv", SchemaVersion: "sv"},
+		insecure:        true,
+		govulncheckPath: govulncheckPath,
+		vulnDBDir:       vulndb,
+	}
+	for _, mod := range []string{"vuln", "clean", "broken"} {
+		t.Run(mod, func(t *testing.T) {
+			req := &govulncheck.Request{
+				ModuleURLPath: scan.ModuleURLPath{Module: "example.com/" + mod, Version: "v1.0.0"},
+				QueryParams: govulncheck.QueryParams{
+					Mode:     ModeGovulncheck,
+					Insecure: true,
+					Serve:    true,
+				},
+			}
+			w := httptest.NewRecorder()
+			if _, err := s.ScanModule(context.Background(), w, req); err != nil {
+				t.Fatal(err)
+			}
+			var rows []*govulncheck.Result
+			if err := json.Unmarshal(w.Body.Bytes(), &rows); err != nil {
+				t.Fatal(err)
+			}
+			for _, r := range rows {
+				normalizeGovulncheckResult(r)
+			}
+			test.CheckGolden(t, filepath.Join(goldenDir, "govulncheck", mod+"@v1.0.0.json"), rows)
+		})
+	}
+}
+
+// normalizeAnalysisResult removes the parts of r that vary from run to run.
+func normalizeAnalysisResult(r *analysis.Result, binaryPath string) {
+	r.Error = strings.ReplaceAll(normalizePaths(r.Error), binaryPath, "BINARY")
+	// The Go environment depends on the machine.
+	if r.GoEnv.Valid {
+		r.GoEnv.StringVal = "ENV"
+	}
+	for _, d := range r.Diagnostics {
+		d.Position = normalizePaths(d.Position)
+	}
+}
+
+// normalizeGovulncheckResult removes the parts of r that vary from run to run.
+func normalizeGovulncheckResult(r *govulncheck.Result) {
+	r.ScanSeconds = 0
+	r.ScanMemory = 0
+	r.Error = normalizePaths(r.Error)
+}
+
+// normalizePaths makes the paths of downloaded modules in s independent
+// of where modules are downloaded.
+func normalizePaths(s string) string {
+	return strings.ReplaceAll(s, modulesDir+"/", "")
+}
@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"golang.org/x/pkgsite-metrics/internal"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
 	"golang.org/x/pkgsite-metrics/internal/derrors"
 	"golang.org/x/pkgsite-metrics/internal/govulncheck"
 	"golang.org/x/pkgsite-metrics/internal/log"
@@ -40,11 +41,16 @@ func (h *GovulncheckServer) getWorkVersion(ctx context.Context) (_ *govulncheck.
 		if err != nil {
 			return nil, err
 		}
+		hash, err := hashFile(filepath.Join(h.cfg.BinaryDir, "govulncheck"))
+		if err != nil {
+			return nil, err
+		}
 		h.workVersion = &govulncheck.WorkVersion{
 			GoVersion:          goEnv["GOVERSION"],
 			VulnDBLastModified: lmt,
 			WorkerVersion:      h.cfg.VersionID,
 			SchemaVersion:      govulncheck.SchemaVersion,
+			GovulncheckHash:    bigquery.NullString(hash),
 		}
 		log.Infof(ctx, "govulncheck work version: %+v", h.workVersion)
 	}
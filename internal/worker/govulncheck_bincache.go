@@ -0,0 +1,145 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// The cache of binaries built by compare-mode scans.
+//
+// Comparing govulncheck versions scans the same module versions again and
+// again, and building their binaries costs about as much as scanning them.
+// The binaries are kept in GCS, under a directory for each module version,
+// in files named by buildbinary.CacheKey, which covers the main package and
+// the Go version. Before a compare-mode scan, the worker downloads the
+// module version's directory into a local one that govulncheck_compare
+// uses as its buildbinary.Cache; afterwards, it uploads the binaries that
+// govulncheck_compare built and added there.
+
+package worker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"cloud.google.com/go/storage"
+	"golang.org/x/exp/event"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+	"golang.org/x/pkgsite-metrics/internal/scan"
+)
+
+var (
+	// binaryCacheHitCounter and binaryCacheMissCounter count the binaries
+	// of compare-mode scans that were and were not in the cache.
+	binaryCacheHitCounter  = event.NewCounter("compare-binary-cache-hits", &event.MetricOptions{Namespace: metricNamespace})
+	binaryCacheMissCounter = event.NewCounter("compare-binary-cache-misses", &event.MetricOptions{Namespace: metricNamespace})
+)
+
+// A binaryCache is the cache of binaries built by compare-mode scans.
+type binaryCache struct {
+	bucket *storage.BucketHandle
+	prefix string // directory in bucket
+}
+
+// newBinaryCache returns the binaryCache at the gs:// URL url.
+func newBinaryCache(ctx context.Context, url string) (_ *binaryCache, err error) {
+	defer derrors.Wrap(&err, "newBinaryCache(%q)", url)
+	bucketName, prefix, err := scan.SplitGCSURL(url)
+	if err != nil {
+		return nil, err
+	}
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &binaryCache{bucket: client.Bucket(bucketName), prefix: prefix}, nil
+}
+
+// moduleDir returns the directory in the bucket of the binaries of
+// modulePath at version, ending in a slash.
+func (c *binaryCache) moduleDir(modulePath, version string) string {
+	return path.Join(c.prefix, modulePath+"@"+version) + "/"
+}
+
+// download copies the cached binaries of modulePath at version to dir,
+// and returns the names of the files it wrote.
+func (c *binaryCache) download(ctx context.Context, modulePath, version, dir string) (_ map[string]bool, err error) {
+	defer derrors.Wrap(&err, "binaryCache.download(%q, %q)", modulePath, version)
+	if _, err := downloadGCSDir(ctx, c.bucket, c.moduleDir(modulePath, version), dir); err != nil && !errors.Is(err, derrors.NotFound) {
+		return nil, err
+	}
+	return dirFiles(dir)
+}
+
+// upload copies the files of dir, other than those in have, to the cached
+// binaries of modulePath at version. It returns the number of files
+// copied.
+func (c *binaryCache) upload(ctx context.Context, modulePath, version, dir string, have map[string]bool) (n int, err error) {
+	defer derrors.Wrap(&err, "binaryCache.upload(%q, %q)", modulePath, version)
+	files, err := dirFiles(dir)
+	if err != nil {
+		return 0, err
+	}
+	for name := range files {
+		if have[name] {
+			continue
+		}
+		obj := c.bucket.Object(c.moduleDir(modulePath, version) + name)
+		if err := copyFileToGCS(ctx, obj, filepath.Join(dir, name)); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// dirFiles returns the names of the regular files in dir, which need not
+// exist.
+func dirFiles(dir string) (map[string]bool, error) {
+	des, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]bool{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	files := map[string]bool{}
+	for _, de := range des {
+		if de.Type().IsRegular() {
+			files[de.Name()] = true
+		}
+	}
+	return files, nil
+}
+
+// copyFileToGCS copies the file filename to the GCS object.
+func copyFileToGCS(ctx context.Context, obj *storage.ObjectHandle, filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := obj.NewWriter(ctx)
+	if _, err := io.Copy(w, f); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// countBinaryCacheUse records the binaries of response that were and were
+// not in the cache.
+func countBinaryCacheUse(ctx context.Context, response *govulncheck.CompareResponse) {
+	for _, pair := range response.FindingsForMod {
+		if pair.Error != "" {
+			continue
+		}
+		if pair.BinaryResults.Stats.BinaryCached {
+			binaryCacheHitCounter.Record(ctx, 1)
+		} else {
+			binaryCacheMissCounter.Record(ctx, 1)
+		}
+	}
+}
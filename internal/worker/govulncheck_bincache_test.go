@@ -0,0 +1,43 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestBinaryCacheModuleDir(t *testing.T) {
+	c := &binaryCache{prefix: "compare/bins"}
+	if got, want := c.moduleDir("example.com/m", "v1.2.3"), "compare/bins/example.com/m@v1.2.3/"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestDirFiles(t *testing.T) {
+	dir := t.TempDir()
+	got, err := dirFiles(filepath.Join(dir, "missing"))
+	if err != nil || len(got) != 0 {
+		t.Errorf("missing dir: got %v, %v; want no files", got, err)
+	}
+	for _, name := range []string{"a", "b"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	got, err = dirFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(map[string]bool{"a": true, "b": true}, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
@@ -6,15 +6,26 @@ package worker
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/fs"
 	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
 	"sort"
 	"strings"
 
+	"golang.org/x/pkgsite-metrics/internal/audit"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
 	"golang.org/x/pkgsite-metrics/internal/config"
 	"golang.org/x/pkgsite-metrics/internal/derrors"
 	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+	"golang.org/x/pkgsite-metrics/internal/log"
+	"golang.org/x/pkgsite-metrics/internal/osv"
+	"golang.org/x/pkgsite-metrics/internal/pkgsitedb"
 	"golang.org/x/pkgsite-metrics/internal/queue"
 	"golang.org/x/pkgsite-metrics/internal/scan"
 )
@@ -31,20 +42,58 @@ func (h *GovulncheckServer) handleEnqueueAll(w http.ResponseWriter, r *http.Requ
 
 func (h *GovulncheckServer) enqueue(r *http.Request, allModes bool) error {
 	ctx := r.Context()
-	params := &govulncheck.EnqueueQueryParams{Min: defaultMinImportedByCount}
+	dyn := h.dynamic.Get()
+	// Read the module list in the body, if any, before ParseParams reads
+	// parameters from it.
+	bodyMods, err := readBodyModules(r)
+	if err != nil {
+		return err
+	}
+	params := &govulncheck.EnqueueQueryParams{Min: dyn.MinImportedBy}
 	if err := scan.ParseParams(r, params); err != nil {
 		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
 	}
+	if bodyMods != nil && (params.File != "" || params.CorpusQuery != "") {
+		return fmt.Errorf("%w: a module list in the body cannot be used with file or corpusquery", derrors.InvalidArgument)
+	}
+	if err := queue.CheckPriority(params.Priority); err != nil {
+		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+	}
 	modes, err := listModes(params.Mode, allModes)
 	if err != nil {
 		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
 	}
-	tasks, err := createGovulncheckQueueTasks(ctx, h.cfg, params, modes)
+	if params.VulnDB != "" {
+		// Resolve dates now, so that all tasks use the same snapshot.
+		params.VulnDB, err = vulnDBSnapshotURL(params.VulnDB, h.cfg.VulnDBSnapshots)
+		if err != nil {
+			return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+		}
+	}
+	for _, p := range params.Platforms {
+		if _, err := govulncheck.PlatformEnv(p); err != nil {
+			return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+		}
+	}
+	if params.MaxBinaries < 0 {
+		return fmt.Errorf("%w: negative maxbinaries", derrors.InvalidArgument)
+	}
+	if _, err := scan.ParseLabels(params.Labels); err != nil {
+		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+	}
+	if (params.MaxBinaries > 0 || len(params.Binaries) > 0) && !slices.Contains(modes, ModeCompare) {
+		return fmt.Errorf("%w: maxbinaries and binaries require mode %s", derrors.InvalidArgument, ModeCompare)
+	}
+	tasks, err := createGovulncheckQueueTasks(ctx, h.cfg, dyn, h.bqClient, params, modes, bodyMods)
 	if err != nil {
 		return err
 	}
-	return enqueueTasks(ctx, tasks, h.queue,
-		&queue.Options{Namespace: "govulncheck", TaskNameSuffix: params.Suffix})
+	if err := enqueueTasks(ctx, tasks, h.queue,
+		&queue.Options{Namespace: "govulncheck", TaskNameSuffix: params.Suffix, Priority: params.Priority}); err != nil {
+		return err
+	}
+	h.recordAction(ctx, r, audit.Enqueue, "", fmt.Sprintf("%d govulncheck tasks", len(tasks)))
+	return nil
 }
 
 // listModes lists all applicable modes depending on who called it. If enqueue did (allModes=false),
@@ -72,22 +121,38 @@ func listModes(modeParam string, allModes bool) ([]string, error) {
 	return []string{mode}, nil
 }
 
-func createGovulncheckQueueTasks(ctx context.Context, cfg *config.Config, params *govulncheck.EnqueueQueryParams, modes []string) (_ []queue.Task, err error) {
+// createGovulncheckQueueTasks returns the tasks that scan the modules in
+// the given modes. The modules are those of mods, minus the skipped ones,
+// if mods is non-nil, so there are no tasks if all are skipped; otherwise
+// they are read as params describe.
+func createGovulncheckQueueTasks(ctx context.Context, cfg *config.Config, dyn *config.Dynamic, bqClient bigquery.DB, params *govulncheck.EnqueueQueryParams, modes []string, mods []scan.ModuleSpec) (_ []queue.Task, err error) {
 	defer derrors.Wrap(&err, "createGovulncheckQueueTasks(%v)", modes)
 	var (
 		tasks    []queue.Task
 		modspecs []scan.ModuleSpec
 	)
-	for _, mode := range modes {
-		if modspecs == nil {
-			modspecs, err = readModules(ctx, cfg, params.File, params.Min)
-			if err != nil {
-				return nil, err
-			}
+	// The listed modules replace the corpus even if all are skipped.
+	if mods != nil {
+		modspecs = skipModules(dyn, mods)
+	} else {
+		modspecs, err = readModules(ctx, cfg, dyn, bqClient, params.File, params.CorpusQuery, params.Min)
+		if err != nil {
+			return nil, err
 		}
-		reqs := moduleSpecsToGovulncheckScanRequests(modspecs, mode)
+	}
+	for _, mode := range modes {
+		reqs := moduleSpecsToGovulncheckScanRequests(modspecs, mode, params.VulnDB, params.Platforms)
 		for _, req := range reqs {
 			if req.Module != "std" { // ignore the standard library
+				if mode == ModeCompare {
+					req.MaxBinaries = params.MaxBinaries
+					req.Binaries = params.Binaries
+				}
+				if mode == ModeGovulncheck {
+					req.Unaffected = params.Unaffected
+					req.Tests = params.Tests
+				}
+				req.Labels = params.Labels
 				tasks = append(tasks, req)
 			}
 		}
@@ -95,7 +160,7 @@ func createGovulncheckQueueTasks(ctx context.Context, cfg *config.Config, params
 	return tasks, nil
 }
 
-func moduleSpecsToGovulncheckScanRequests(modspecs []scan.ModuleSpec, mode string) []*govulncheck.Request {
+func moduleSpecsToGovulncheckScanRequests(modspecs []scan.ModuleSpec, mode, vulnDB string, platforms []string) []*govulncheck.Request {
 	var sreqs []*govulncheck.Request
 	for _, ms := range modspecs {
 		sreqs = append(sreqs, &govulncheck.Request{
@@ -106,12 +171,108 @@ func moduleSpecsToGovulncheckScanRequests(modspecs []scan.ModuleSpec, mode strin
 			QueryParams: govulncheck.QueryParams{
 				ImportedBy: ms.ImportedBy,
 				Mode:       mode,
+				VulnDB:     vulnDB,
+				Platforms:  platforms,
 			},
 		})
 	}
 	return sreqs
 }
 
+// handleEnqueueOSV enqueues govulncheck scans of the modules that import
+// packages affected by a single OSV entry, so that the impact of a new
+// vulnerability can be measured quickly. The resulting rows are tagged with
+// the OSV ID.
+func (h *GovulncheckServer) handleEnqueueOSV(w http.ResponseWriter, r *http.Request) (err error) {
+	defer derrors.Wrap(&err, "handleEnqueueOSV")
+
+	ctx := r.Context()
+	dyn := h.dynamic.Get()
+	params := &govulncheck.EnqueueOSVParams{Min: dyn.MinImportedBy}
+	if err := scan.ParseParams(r, params); err != nil {
+		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+	}
+	if !osvIDRegexp.MatchString(params.ID) {
+		return fmt.Errorf("%w: bad or missing id %q", derrors.InvalidArgument, params.ID)
+	}
+	if err := queue.CheckPriority(params.Priority); err != nil {
+		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+	}
+	entry, err := readOSVEntry(h.cfg.VulnDBDir, params.ID)
+	if err != nil {
+		return err
+	}
+	pkgs, mods := affectedImports(entry)
+	log.Infof(ctx, "%s affects packages %v and modules %v", params.ID, pkgs, mods)
+	db, err := pkgsitedb.Open(ctx, h.cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	modspecs, err := pkgsitedb.ImporterModuleSpecs(ctx, db, pkgs, mods, params.Min)
+	if err != nil {
+		return err
+	}
+	tasks := createOSVQueueTasks(params.ID, skipModules(dyn, modspecs))
+	if err := enqueueTasks(ctx, tasks, h.queue,
+		&queue.Options{Namespace: "govulncheck", TaskNameSuffix: params.ID, Priority: params.Priority}); err != nil {
+		return err
+	}
+	h.recordAction(ctx, r, audit.Enqueue, "", fmt.Sprintf("%d govulncheck tasks for %s", len(tasks), params.ID))
+	fmt.Fprintf(w, "enqueued %d modules importing packages affected by %s\n", len(tasks), params.ID)
+	return nil
+}
+
+// osvIDRegexp matches the IDs of entries in the Go vulnerability database.
+var osvIDRegexp = regexp.MustCompile(`^GO-\d{4}-\d{4,}$`)
+
+// readOSVEntry reads the OSV entry with the given ID from the vulnerability
+// database in vulnDBDir, the one govulncheck scans use.
+func readOSVEntry(vulnDBDir, id string) (_ *osv.Entry, err error) {
+	defer derrors.Wrap(&err, "readOSVEntry(%q)", id)
+	data, err := os.ReadFile(filepath.Join(vulnDBDir, "ID", id+".json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s is not in the vulnerability database at %s", derrors.NotFound, id, vulnDBDir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var e osv.Entry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// affectedImports returns the affected packages of the entry, and the
+// affected modules that list no packages, all of whose packages are
+// affected.
+func affectedImports(e *osv.Entry) (pkgs, mods []string) {
+	for _, a := range e.Affected {
+		if len(a.EcosystemSpecific.Packages) == 0 {
+			mods = append(mods, a.Module.Path)
+			continue
+		}
+		for _, p := range a.EcosystemSpecific.Packages {
+			pkgs = append(pkgs, p.Path)
+		}
+	}
+	return pkgs, mods
+}
+
+// createOSVQueueTasks returns govulncheck scan tasks for modspecs, tagged
+// with the OSV ID.
+func createOSVQueueTasks(id string, modspecs []scan.ModuleSpec) []queue.Task {
+	var tasks []queue.Task
+	for _, req := range moduleSpecsToGovulncheckScanRequests(modspecs, ModeGovulncheck, "", nil) {
+		if req.Module != "std" { // ignore the standard library
+			req.OSV = id
+			tasks = append(tasks, req)
+		}
+	}
+	return tasks
+}
+
 func govulncheckMode(mode string) (string, error) {
 	if mode == "" {
 		// ModeGovulncheck is the default mode.
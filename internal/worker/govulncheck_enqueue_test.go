@@ -6,12 +6,16 @@ package worker
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
 	"golang.org/x/pkgsite-metrics/internal/config"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
 	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+	"golang.org/x/pkgsite-metrics/internal/osv"
 	"golang.org/x/pkgsite-metrics/internal/queue"
 	"golang.org/x/pkgsite-metrics/internal/scan"
 )
@@ -25,7 +29,7 @@ func TestCreateQueueTasks(t *testing.T) {
 	}
 
 	params := &govulncheck.EnqueueQueryParams{Min: 8, File: "testdata/modules.txt"}
-	gotTasks, err := createGovulncheckQueueTasks(context.Background(), &config.Config{}, params, []string{ModeGovulncheck})
+	gotTasks, err := createGovulncheckQueueTasks(context.Background(), &config.Config{}, config.DefaultDynamic(), nil, params, []string{ModeGovulncheck}, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -42,7 +46,7 @@ func TestCreateQueueTasks(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	gotTasks, err = createGovulncheckQueueTasks(context.Background(), &config.Config{}, params, allModes)
+	gotTasks, err = createGovulncheckQueueTasks(context.Background(), &config.Config{}, config.DefaultDynamic(), nil, params, allModes, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -55,6 +59,80 @@ func TestCreateQueueTasks(t *testing.T) {
 	if diff := cmp.Diff(wantTasks, gotTasks, cmp.AllowUnexported(govulncheck.Request{})); diff != "" {
 		t.Errorf("mismatch (-want, +got):\n%s", diff)
 	}
+
+	// Each task scans for all the platforms.
+	platforms := []string{"linux/amd64", "windows/arm64"}
+	params.Platforms = platforms
+	gotTasks, err = createGovulncheckQueueTasks(context.Background(), &config.Config{}, config.DefaultDynamic(), nil, params, []string{ModeGovulncheck}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, task := range wantTasks {
+		task.(*govulncheck.Request).Platforms = platforms
+	}
+	if diff := cmp.Diff(wantTasks, gotTasks, cmp.AllowUnexported(govulncheck.Request{})); diff != "" {
+		t.Errorf("platforms: mismatch (-want, +got):\n%s", diff)
+	}
+	// Modules listed in the request are scanned instead of the corpus,
+	// whatever their imported-by counts, except for skipped ones.
+	params.Platforms = nil
+	mods := []scan.ModuleSpec{{Path: "example.com/a", Version: "v1.0.0"}, {Path: "example.com/skip", Version: "v1.0.0"}}
+	dyn := config.DefaultDynamic()
+	dyn.SkipModules = []string{"example.com/skip"}
+	gotTasks, err = createGovulncheckQueueTasks(context.Background(), &config.Config{}, dyn, nil, params, []string{ModeGovulncheck}, mods)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTasks = []queue.Task{vreq("example.com/a", "v1.0.0", ModeGovulncheck, 0)}
+	if diff := cmp.Diff(wantTasks, gotTasks, cmp.AllowUnexported(govulncheck.Request{})); diff != "" {
+		t.Errorf("listed modules: mismatch (-want, +got):\n%s", diff)
+	}
+	// If all of them are skipped, nothing is scanned.
+	gotTasks, err = createGovulncheckQueueTasks(context.Background(), &config.Config{}, dyn, nil, params, []string{ModeGovulncheck}, mods[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gotTasks) != 0 {
+		t.Errorf("all listed modules skipped: got %d tasks, want none", len(gotTasks))
+	}
+
+	// The binary selection applies only to compare mode.
+	params.Platforms = nil
+	params.MaxBinaries = 2
+	params.Binaries = []string{"golang.org/x/net/cmd/x"}
+	gotTasks, err = createGovulncheckQueueTasks(context.Background(), &config.Config{}, config.DefaultDynamic(), nil, params, []string{ModeCompare, ModeGovulncheck}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, task := range gotTasks {
+		req := task.(*govulncheck.Request)
+		gotSel := req.MaxBinaries != 0 || req.Binaries != nil
+		if wantSel := req.Mode == ModeCompare; gotSel != wantSel {
+			t.Errorf("%s %s: got maxbinaries=%d, binaries=%q", req.Module, req.Mode, req.MaxBinaries, req.Binaries)
+		}
+	}
+
+	// The labels apply to every task, and recording unaffected OSVs and
+	// scanning tests only to govulncheck mode.
+	params.Labels = []string{"arm:control"}
+	params.Unaffected = true
+	params.Tests = true
+	gotTasks, err = createGovulncheckQueueTasks(context.Background(), &config.Config{}, config.DefaultDynamic(), nil, params, []string{ModeCompare, ModeGovulncheck}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, task := range gotTasks {
+		req := task.(*govulncheck.Request)
+		if !slices.Equal(req.Labels, params.Labels) {
+			t.Errorf("%s %s: got labels %q, want %q", req.Module, req.Mode, req.Labels, params.Labels)
+		}
+		if want := req.Mode == ModeGovulncheck; req.Unaffected != want {
+			t.Errorf("%s %s: got unaffected %t, want %t", req.Module, req.Mode, req.Unaffected, want)
+		}
+		if want := req.Mode == ModeGovulncheck; req.Tests != want {
+			t.Errorf("%s %s: got tests %t, want %t", req.Module, req.Mode, req.Tests, want)
+		}
+	}
 }
 
 func TestListModes(t *testing.T) {
@@ -79,3 +157,41 @@ func TestListModes(t *testing.T) {
 		})
 	}
 }
+
+func TestEnqueueOSV(t *testing.T) {
+	e, err := readOSVEntry("../testdata/vulndb", "GO-2020-0015")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkgs, mods := affectedImports(e)
+	if want := []string{"golang.org/x/text/encoding/unicode", "golang.org/x/text/transform"}; !cmp.Equal(pkgs, want) {
+		t.Errorf("got packages %v, want %v", pkgs, want)
+	}
+	if len(mods) != 0 {
+		t.Errorf("got modules %v, want none", mods)
+	}
+	// A module without affected packages is affected in full.
+	pkgs, mods = affectedImports(&osv.Entry{Affected: []osv.Affected{{Module: osv.Module{Path: "example.com/m"}}}})
+	if len(pkgs) != 0 || !cmp.Equal(mods, []string{"example.com/m"}) {
+		t.Errorf("got %v, %v, want no packages and module example.com/m", pkgs, mods)
+	}
+
+	if _, err := readOSVEntry("../testdata/vulndb", "GO-2099-0001"); !errors.Is(err, derrors.NotFound) {
+		t.Errorf("missing entry: got %v, want NotFound", err)
+	}
+
+	got := createOSVQueueTasks("GO-2020-0015", []scan.ModuleSpec{
+		{Path: "example.com/a", Version: "v1.0.0", ImportedBy: 50},
+		{Path: "std", Version: "v1.21.0", ImportedBy: 100},
+	})
+	want := []queue.Task{&govulncheck.Request{
+		ModuleURLPath: scan.ModuleURLPath{Module: "example.com/a", Version: "v1.0.0"},
+		QueryParams:   govulncheck.QueryParams{Mode: ModeGovulncheck, ImportedBy: 50, OSV: "GO-2020-0015"},
+	}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+	if got, want := got[0].Params(), "importedby=50&mode=GOVULNCHECK&insecure=false&serve=false&osv=GO-2020-0015&vulndb=&platforms=&format=&maxbinaries=0&binaries=&subdir=&labels=&unaffected=false&tests=false"; got != want {
+		t.Errorf("got params %q, want %q", got, want)
+	}
+}
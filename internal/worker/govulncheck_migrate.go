@@ -0,0 +1,66 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+	"golang.org/x/pkgsite-metrics/internal/log"
+	"golang.org/x/pkgsite-metrics/internal/scan"
+)
+
+// migrateParams are the query params of /govulncheck/migrate-legacy.
+type migrateParams struct {
+	DryRun bool // report how many rows would be copied, but do not copy them
+}
+
+// A migrateReport describes the rows of the legacy vulncheck table that a
+// migration copied to the govulncheck table, or would copy in a dry run.
+type migrateReport struct {
+	DryRun bool  `json:"dry_run"`
+	Rows   int64 `json:"rows"`
+}
+
+// handleMigrateLegacy copies the rows of the legacy vulncheck table that
+// have not been copied yet to the govulncheck table, as described in
+// internal/govulncheck/legacy.go, and serves a JSON migrateReport.
+func (h *GovulncheckServer) handleMigrateLegacy(w http.ResponseWriter, r *http.Request) (err error) {
+	defer derrors.Wrap(&err, "handleMigrateLegacy")
+
+	ctx := r.Context()
+	var params migrateParams
+	if err := scan.ParseParams(r, &params); err != nil {
+		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+	}
+	if h.bqClient == nil {
+		return errors.New("BigQuery is disabled")
+	}
+	if _, err := h.bqClient.CreateOrUpdateTable(ctx, govulncheck.MigratedTableName); err != nil {
+		return err
+	}
+	q := govulncheck.LegacyPendingQuery(h.bqClient.FullTableName)
+	if !params.DryRun {
+		q, err = govulncheck.LegacyMigrationQuery(h.bqClient.FullTableName)
+		if err != nil {
+			return err
+		}
+	}
+	iter, err := h.bqClient.Query(ctx, q)
+	if err != nil {
+		return err
+	}
+	var row struct {
+		N int64 `bigquery:"n"`
+	}
+	if err := iter.Next(&row); err != nil {
+		return err
+	}
+	log.Infof(ctx, "legacy migration, dry run %t: %d rows", params.DryRun, row.N)
+	return writeJSON(w, &migrateReport{DryRun: params.DryRun, Rows: row.N})
+}
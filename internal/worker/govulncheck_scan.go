@@ -11,18 +11,24 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
+	bq "cloud.google.com/go/bigquery"
 	"cloud.google.com/go/storage"
 	"golang.org/x/exp/event"
 	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/buildbinary"
 	"golang.org/x/pkgsite-metrics/internal/derrors"
 	"golang.org/x/pkgsite-metrics/internal/fstore"
 	"golang.org/x/pkgsite-metrics/internal/govulncheck"
 	"golang.org/x/pkgsite-metrics/internal/govulncheckapi"
 	"golang.org/x/pkgsite-metrics/internal/log"
+	"golang.org/x/pkgsite-metrics/internal/modules"
 	"golang.org/x/pkgsite-metrics/internal/proxy"
 	"golang.org/x/pkgsite-metrics/internal/sandbox"
+	"golang.org/x/pkgsite-metrics/internal/sarif"
+	"golang.org/x/pkgsite-metrics/internal/scan"
 	"golang.org/x/pkgsite-metrics/internal/version"
 )
 
@@ -76,6 +82,14 @@ const (
 	sandboxGoCache = "root/.cache/go-build"
 )
 
+// scanModeLevels maps the source scan modes to the govulncheck scan levels
+// of their findings.
+var scanModeLevels = map[string]govulncheckapi.ScanLevel{
+	scanModeSourceSymbol:  govulncheckapi.ScanLevelSymbol,
+	scanModeSourcePackage: govulncheckapi.ScanLevelPackage,
+	scanModeSourceModule:  govulncheckapi.ScanLevelModule,
+}
+
 var (
 	// gReqCounter counts requests to govulncheck handleScan
 	gReqCounter = event.NewCounter("govulncheck-requests", &event.MetricOptions{Namespace: metricNamespace})
@@ -108,6 +122,16 @@ func (h *GovulncheckServer) handleScan(w http.ResponseWriter, r *http.Request) (
 	if sreq.Mode == "" {
 		sreq.Mode = ModeGovulncheck
 	}
+	if sreq.Format == govulncheck.FormatSARIF && sreq.Mode != ModeGovulncheck {
+		return fmt.Errorf("%w: format %q is not supported in mode %s", derrors.InvalidArgument, sreq.Format, sreq.Mode)
+	}
+	ctx = log.With(ctx, "module", sreq.Module+"@"+sreq.Version, "mode", sreq.Mode)
+	ctx, bundle := log.StartBundle(ctx)
+	defer func() {
+		bundle.Emit(ctx, "govulncheck scan finished", "success", err == nil, "skipped", skip)
+	}()
+	ctx, done := runningTasks.start(ctx, fmt.Sprintf("%s@%s %s", sreq.Module, sreq.Version, sreq.Mode), true)
+	defer done()
 	scanner, err := newScanner(ctx, h)
 	if err != nil {
 		return err
@@ -116,9 +140,29 @@ func (h *GovulncheckServer) handleScan(w http.ResponseWriter, r *http.Request) (
 	if sreq.Insecure {
 		scanner.insecure = sreq.Insecure
 	}
-	skip, err = scanner.canSkip(ctx, sreq, h.fsNamespace)
-	if err != nil {
-		return err
+	if sreq.VulnDB != "" {
+		url, err := vulnDBSnapshotURL(sreq.VulnDB, h.cfg.VulnDBSnapshots)
+		if err != nil {
+			return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+		}
+		dir, release, err := vulnDBSnapshots.get(ctx, url)
+		if err != nil {
+			return err
+		}
+		defer release()
+		if err := scanner.useVulnDBSnapshot(url, dir); err != nil {
+			return err
+		}
+	}
+	var contentHash string
+	if sreq.OSV == "" && len(sreq.Platforms) == 0 && sreq.Subdir == "" && !sreq.Tests {
+		// Scans for a new OSV must produce rows tagged with it, and
+		// platform, subdirectory and test scans are not recorded in the
+		// work state.
+		skip, contentHash, err = scanner.canSkip(ctx, sreq, h.fsNamespace)
+		if err != nil {
+			return err
+		}
 	}
 	if skip {
 		log.Infof(ctx, "skipping (work version unchanged or unrecoverable error): %s@%s", sreq.Module, sreq.Version)
@@ -131,6 +175,7 @@ func (h *GovulncheckServer) handleScan(w http.ResponseWriter, r *http.Request) (
 	if workState == nil {
 		return nil
 	}
+	workState.ContentHash = contentHash
 	// We can't upload the row to bigquery and write the WorkState to Firestore atomically.
 	// But that's OK: if we fail before writing the WorkState, then we'll just re-do the scan
 	// the next time.
@@ -138,26 +183,87 @@ func (h *GovulncheckServer) handleScan(w http.ResponseWriter, r *http.Request) (
 		// Don't fail if there's an error, because we'd just re-run the task.
 		log.Errorf(ctx, err, "SetWorkState")
 	}
+	if contentHash != "" {
+		if err := govulncheck.SetContentWorkState(ctx, h.fsNamespace, sreq.Module, workState); err != nil {
+			log.Errorf(ctx, err, "SetContentWorkState")
+		}
+	}
 	return nil
 }
 
-func (s *scanner) canSkip(ctx context.Context, sreq *govulncheck.Request, fsn *fstore.Namespace) (bool, error) {
+// canSkip reports whether the scan of sreq can be skipped, because the
+// module version, or another version of the module with the same contents,
+// was already scanned with the same work version.
+// It also returns the content hash of the module, or "" if it was not computed.
+func (s *scanner) canSkip(ctx context.Context, sreq *govulncheck.Request, fsn *fstore.Namespace) (skip bool, contentHash string, err error) {
+	// The labels were checked by ParseRequest.
+	ls, _ := scan.ParseLabels(sreq.Labels)
+	labels := scan.FormatLabels(ls)
 	ws, err := govulncheck.GetWorkState(ctx, fsn, sreq.Module, sreq.Version)
 	if err != nil {
-		return false, err
+		return false, "", err
+	}
+	if ws != nil {
+		log.Infof(ctx, "read work version for %s@%s", sreq.Module, sreq.Version)
+		if s.skipWorkState(ws, labels) {
+			return true, "", nil
+		}
+	}
+	// Versions can change while contents do not; for example, the
+	// pseudo-versions of synthetic modules change on every corpus refresh.
+	// So also look for a previous scan of the same contents.
+	contentHash, err = s.contentHash(ctx, sreq)
+	if err != nil {
+		// Not fatal: the scan will report any proxy errors.
+		log.Warnf(ctx, "computing content hash: %v", err)
+		return false, "", nil
 	}
-	if ws == nil {
-		// Not scanned before.
-		return false, nil
+	cws, err := govulncheck.GetContentWorkState(ctx, fsn, sreq.Module, contentHash)
+	if err != nil {
+		return false, "", err
+	}
+	if cws != nil {
+		log.Infof(ctx, "read work version for %s with content hash %s", sreq.Module, contentHash)
+		if s.skipWorkState(cws, labels) {
+			return true, contentHash, nil
+		}
+	}
+	return false, contentHash, nil
+}
+
+// skipWorkState reports whether a scan with labels, as formatted by
+// scan.FormatLabels, whose previous work state is ws can be skipped.
+func (s *scanner) skipWorkState(ws *govulncheck.WorkState, labels string) bool {
+	if ws.Labels != labels {
+		// The earlier scan's rows don't have the labels of this one.
+		return false
 	}
-	log.Infof(ctx, "read work version for %s@%s", sreq.Module, sreq.Version)
 	if s.workVersion.Equal(ws.WorkVersion) {
 		// If the work version has not changed, skip analyzing the module
-		return true, nil
+		return true
 	}
-	// Otherwise, skip if the error is not recoverable. The version of the
-	// module has not changed, so we'll get the same error anyhow.
-	return unrecoverableError(ws.ErrorCategory), nil
+	// Otherwise, skip if the error is not recoverable. The contents of
+	// the module have not changed, so we'll get the same error anyhow.
+	return unrecoverableError(ws.ErrorCategory)
+}
+
+// contentHash returns the content hash of the module in sreq. It keeps the
+// module's zip in s.zip, so that the scan does not download it again.
+func (s *scanner) contentHash(ctx context.Context, sreq *govulncheck.Request) (string, error) {
+	info, err := s.proxyClient.Info(ctx, sreq.Module, sreq.Version)
+	if err != nil {
+		return "", err
+	}
+	mod, err := s.proxyClient.Mod(ctx, sreq.Module, info.Version)
+	if err != nil {
+		return "", err
+	}
+	zipr, err := s.proxyClient.Zip(ctx, sreq.Module, info.Version)
+	if err != nil {
+		return "", err
+	}
+	s.zip = &moduleZip{modulePath: sreq.Module, version: info.Version, r: zipr}
+	return modules.ContentHash(sreq.Module, info.Version, mod, zipr)
 }
 
 // unrecoverableError returns true iff errorCategory encodes that
@@ -175,10 +281,15 @@ func unrecoverableError(errorCategory string) bool {
 // A scanner holds state for scanning modules.
 type scanner struct {
 	proxyClient *proxy.Client
-	bqClient    *bigquery.Client
+	sumDB       *modules.SumDB
+	bqClient    bigquery.DB
+	local       *localResultsDir // receives results if bqClient is nil
 	workVersion *govulncheck.WorkVersion
 	gcsBucket   *storage.BucketHandle
+	binaryCache *binaryCache // nil if compare-mode binaries are not cached
+	zip         *moduleZip   // zip of the requested module, if canSkip downloaded it
 	insecure    bool
+	limits      scanLimits
 	sbox        *sandbox.Sandbox
 	binaryDir   string
 
@@ -199,14 +310,25 @@ func newScanner(ctx context.Context, h *GovulncheckServer) (*scanner, error) {
 		}
 		bucket = c.Bucket(h.cfg.BinaryBucket)
 	}
+	var bcache *binaryCache
+	if h.cfg.CompareBinaryCache != "" {
+		bcache, err = newBinaryCache(ctx, h.cfg.CompareBinaryCache)
+		if err != nil {
+			return nil, err
+		}
+	}
 	sbox := sandbox.New("/bundle")
 	sbox.Runsc = "/usr/local/bin/runsc"
 	return &scanner{
 		proxyClient:     h.proxyClient,
+		sumDB:           h.sumDB,
 		bqClient:        h.bqClient,
+		local:           h.localResults,
 		workVersion:     workVersion,
 		gcsBucket:       bucket,
+		binaryCache:     bcache,
 		insecure:        h.cfg.Insecure,
+		limits:          h.scanLimits,
 		sbox:            sbox,
 		binaryDir:       h.cfg.BinaryDir,
 		govulncheckPath: filepath.Join(h.cfg.BinaryDir, "govulncheck"),
@@ -233,31 +355,65 @@ func (s scanError) Unwrap() error {
 // binary within the module.
 func (s *scanner) CompareModule(ctx context.Context, w http.ResponseWriter, sreq *govulncheck.Request, baseRow *govulncheck.Result) (err error) {
 	defer derrors.Wrap(&err, "CompareModule")
-	err = doScan(ctx, baseRow.ModulePath, baseRow.Version, s.insecure, func() (err error) {
+	err = doScan(ctx, s.limits, baseRow.ModulePath, baseRow.Version, s.insecure, func(ctx context.Context) (err error) {
 		inputPath := moduleDir(baseRow.ModulePath, baseRow.Version)
 		defer derrors.Cleanup(&err, func() error { return os.RemoveAll(inputPath) })
 		const init = true
-		if err := prepareModule(ctx, baseRow.ModulePath, baseRow.Version, inputPath, s.proxyClient, s.insecure, init); err != nil {
+		stats, err := prepareModule(ctx, baseRow.ModulePath, baseRow.Version, inputPath, sreq.Subdir, proxySource{s.proxyClient, s.sumDB, s.zip}, s.insecure, init, "")
+		if err != nil {
 			log.Errorf(ctx, err, "error trying to prepare module %s", baseRow.ModulePath)
 			return nil
 		}
+		stats.setRow(baseRow)
 
-		smdir := strings.TrimPrefix(inputPath, sandboxRoot)
+		smdir := strings.TrimPrefix(stats.dir, sandboxRoot)
 		err = s.sbox.Validate()
 		log.Debugf(ctx, "sandbox Validate returned %v", err)
 
-		response, err := s.runGovulncheckCompareSandbox(ctx, smdir)
+		sel := buildbinary.Selection{Max: sreq.MaxBinaries, Packages: sreq.Binaries}
+		var (
+			cacheDir string
+			cached   map[string]bool
+		)
+		if s.binaryCache != nil {
+			cacheDir = inputPath + "-binaries"
+			defer derrors.Cleanup(&err, func() error { return os.RemoveAll(cacheDir) })
+			cached, err = s.binaryCache.download(ctx, baseRow.ModulePath, baseRow.Version, cacheDir)
+			if err != nil {
+				// Build the binaries instead.
+				log.Warnf(ctx, "%v", err)
+				cached = nil
+			}
+		}
+		response, err := s.runGovulncheckCompareSandbox(ctx, smdir, strings.TrimPrefix(cacheDir, sandboxRoot), sel)
 		if err != nil {
 			return err
 		}
-		log.Infof(ctx, "scanner.runGovulncheckCompare found %d compilable binaries in %s:", len(response.FindingsForMod), sreq.Path())
+		if s.binaryCache != nil {
+			countBinaryCacheUse(ctx, response)
+			if n, err := s.binaryCache.upload(ctx, baseRow.ModulePath, baseRow.Version, cacheDir, cached); err != nil {
+				log.Warnf(ctx, "%v", err)
+			} else if n > 0 {
+				log.Infof(ctx, "cached %d binaries of %s", n, sreq.Path())
+			}
+		}
+		log.Infof(ctx, "scanner.runGovulncheckCompare built %d of %d binaries in %s:", len(response.FindingsForMod), response.NumMainPackages, sreq.Path())
+		if d := sel.String(); d != "" {
+			baseRow.BinarySelection = bigquery.NullString(d)
+		}
 
 		var rows []bigquery.Row
+		summary := govulncheck.NewCompareSummary(baseRow)
+		summary.NumMainPackages = bigquery.NullInt(response.NumMainPackages)
 		for pkg, results := range response.FindingsForMod {
 			if results.Error != "" {
 				// Just log error if binary failed to build or the analysis failed.
 				// TODO: should we save those rows? This would complicate clients, namely the dashboards.
 				log.Errorf(ctx, errors.New(results.Error), "building/analyzing binary failed: %s %s", pkg, sreq.Path())
+				// A failed build still cost its time.
+				if results.BinaryResults.Stats.BuildTime > 0 {
+					summary.AddBuild(results.BinaryResults.Stats)
+				}
 				continue
 			}
 
@@ -265,12 +421,25 @@ func (s *scanner) CompareModule(ctx context.Context, w http.ResponseWriter, sreq
 			srcRow := createComparisonRow(pkg, &results.SourceResults, baseRow, false)
 			log.Infof(ctx, "found %d vulns in binary mode and %d vulns in source mode for package %s (module: %s)", len(binRow.Vulns), len(srcRow.Vulns), pkg, sreq.Path())
 			rows = append(rows, binRow, srcRow)
+			summary.Add(binRow.Vulns, srcRow.Vulns)
+			summary.AddBuild(results.BinaryResults.Stats)
 		}
 
+		// The summary is written even if no binary could be compared, so
+		// that the cost of failed builds is recorded.
+		if len(rows) == 0 && !summary.BuildSeconds.Valid {
+			return nil
+		}
+		if sreq.Serve {
+			// Serve a single JSON value holding the rows of both tables.
+			return serveJSON(ctx, servedComparison{Results: rows, Summary: summary}, w)
+		}
 		if len(rows) > 0 {
-			return writeResults(ctx, sreq.Serve, w, s.bqClient, govulncheck.TableName, rows)
+			if err := writeResults(ctx, false, w, s.bqClient, s.local, govulncheck.TableName, rows); err != nil {
+				return err
+			}
 		}
-		return nil
+		return writeResult(ctx, false, w, s.bqClient, s.local, govulncheck.CompareSummaryTableName, summary)
 	})
 
 	if err != nil {
@@ -279,12 +448,42 @@ func (s *scanner) CompareModule(ctx context.Context, w http.ResponseWriter, sreq
 	return nil
 }
 
+// servedComparison is the response of a compare-mode scan with the serve
+// param: the rows it would write to the govulncheck table, and its summary.
+type servedComparison struct {
+	Results []bigquery.Row              `json:"results"`
+	Summary *govulncheck.CompareSummary `json:"summary"`
+}
+
+// setRow records the statistics of the module in row.
+func (s moduleStats) setRow(row *govulncheck.Result) {
+	if s.zipSize > 0 {
+		row.ModuleSize = bigquery.NullInt(int(s.zipSize))
+	}
+	if s.verification != "" {
+		row.ChecksumVerification = bigquery.NullString(s.verification)
+	}
+	if s.prepStrategy != "" {
+		row.PrepStrategy = bigquery.NullString(s.prepStrategy)
+	}
+	if s.depsKnown {
+		row.NumDirectDeps = bigquery.NullInt(s.numDirectDeps)
+		row.NumIndirectDeps = bigquery.NullInt(s.numIndirectDeps)
+	}
+}
+
 func createComparisonRow(pkg string, response *govulncheck.AnalysisResponse, baseRow *govulncheck.Result, binary bool) *govulncheck.Result {
 	row := *baseRow
 	row.Suffix = pkg
 	if binary {
 		row.ScanMode = scanModeCompareBinary
-		row.BinaryBuildSeconds = bigquery.NullFloat(response.Stats.BuildTime.Seconds())
+		row.BinarySize = bigquery.NullInt(int(response.Stats.BinarySize))
+		row.BinaryCached = bq.NullBool{Bool: response.Stats.BinaryCached, Valid: true}
+		if !response.Stats.BinaryCached {
+			row.BinaryBuildSeconds = bigquery.NullFloat(response.Stats.BuildTime.Seconds())
+			row.BinaryBuildMemory = bigquery.NullInt(int(response.Stats.BuildMemory))
+			row.BuildArtifactsSize = bigquery.NullInt(int(response.Stats.BuildArtifactsSize))
+		}
 	} else {
 		row.ScanMode = scanModeCompareSource
 	}
@@ -292,6 +491,7 @@ func createComparisonRow(pkg string, response *govulncheck.AnalysisResponse, bas
 	row.Vulns = vulnsForScanMode(response, scanModeSourceSymbol) // we want vulns at the symbol level, binary or source
 	row.ScanMemory = int64(response.Stats.ScanMemory)
 	row.ScanSeconds = response.Stats.ScanSeconds
+	row.SetProgress(response.Stats.Progress)
 	return &row
 }
 
@@ -306,20 +506,28 @@ func (s *scanner) ScanModule(ctx context.Context, w http.ResponseWriter, sreq *g
 		Suffix:      sreq.Suffix,
 		WorkVersion: *s.workVersion,
 		ImportedBy:  sreq.ImportedBy,
+		OSV:         bq.NullString{StringVal: sreq.OSV, Valid: sreq.OSV != ""},
+		Subdir:      bq.NullString{StringVal: sreq.Subdir, Valid: sreq.Subdir != ""},
 	}
+	// The labels were checked by ParseRequest.
+	baseRow.Labels, _ = scan.ParseLabels(sreq.Labels)
 	baseRow.VulnDBLastModified = s.workVersion.VulnDBLastModified
 
 	log.Debugf(ctx, "fetching proxy info: %s@%s", sreq.Path(), sreq.Version)
 	info, err := s.proxyClient.Info(ctx, sreq.Module, sreq.Version)
 	if err != nil {
 		log.Infof(ctx, "proxy error: %s@%s %v", sreq.Path(), sreq.Version, err)
+		if sreq.Format == govulncheck.FormatSARIF {
+			run := govulncheck.FailedSARIFRun(fmt.Errorf("%v: %w", err, derrors.ProxyError))
+			return nil, serveJSON(ctx, sarif.NewLog(run), w)
+		}
 		rows := createRows(sreq.Mode, func(sm string) *govulncheck.Result {
 			row := *baseRow
 			row.ScanMode = sm
 			row.AddError(fmt.Errorf("%v: %w", err, derrors.ProxyError))
 			return &row
 		})
-		return nil, writeResults(ctx, sreq.Serve, w, s.bqClient, govulncheck.TableName, rows)
+		return nil, writeResults(ctx, sreq.Serve, w, s.bqClient, s.local, govulncheck.TableName, rows)
 	}
 	baseRow.Version = info.Version
 	baseRow.SortVersion = version.ForSorting(info.Version)
@@ -338,100 +546,164 @@ func (s *scanner) ScanModule(ctx context.Context, w http.ResponseWriter, sreq *g
 // analysis is conducted. For binary analysis, see CompareModule.
 func (s *scanner) CheckModule(ctx context.Context, w http.ResponseWriter, sreq *govulncheck.Request, baseRow *govulncheck.Result) (*govulncheck.WorkState, error) {
 	log.Infof(ctx, "running scanner.runScanModule: %s@%s", sreq.Path(), sreq.Version)
-	response, err := s.runScanModule(ctx, sreq.Module, baseRow.Version, sreq.Mode)
-	// classify scan error first
-	if err != nil {
-		switch {
-		case isModVendor(err):
-			err = fmt.Errorf("%v: %w", err, derrors.LoadVendorError)
-		case isGovulncheckLoadError(err) || isBuildIssue(err):
-			err = fmt.Errorf("%v: %w", err, derrors.LoadPackagesError)
-		case isNoRequiredModule(err):
-			// Should be subsumed by LoadPackagesError, kept for sanity
-			// and to catch unexpected changes in govulncheck output.
-			err = fmt.Errorf("%v: %w", err, derrors.LoadPackagesNoRequiredModuleError)
-		case isMissingGoSumEntry(err):
-			// Should be subsumed by LoadPackagesError, kept for sanity.
-			// and to catch unexpected changes in govulncheck output.
-			err = fmt.Errorf("%v: %w", err, derrors.LoadPackagesMissingGoSumEntryError)
-		case isReplacingWithLocalPath(err):
-			// Should be subsumed by LoadPackagesError, kept for sanity.
-			// and to catch unexpected changes in govulncheck output.
-			err = fmt.Errorf("%v: %w", err, derrors.LoadPackagesImportedLocalError)
-		case isMissingGoMod(err) || isNoModulesSpecified(err):
-			// Should be subsumed by LoadPackagesError, kept for sanity
-			// and to catch unexpected changes in govulncheck output.
-			err = fmt.Errorf("%v: %w", err, derrors.LoadPackagesNoGoModError)
-		case isTooManyFiles(err):
-			err = fmt.Errorf("%v: %w", err, derrors.ScanModuleTooManyOpenFiles)
-		case isProxyCacheMiss(err):
-			err = fmt.Errorf("%v: %w", err, derrors.ProxyError)
-		case isSandboxRelatedIssue(err):
-			err = fmt.Errorf("%v: %w", err, derrors.ScanModuleSandboxError)
-		default:
-			err = fmt.Errorf("%v: %w", err, derrors.ScanModuleGovulncheckError)
-		}
-	}
+	scans, stats, err := s.runScanModule(ctx, sreq.Module, baseRow.Version, sreq.Subdir, sreq.Mode, sreq.Platforms, sreq.Tests)
+	stats.setRow(baseRow)
 
-	rows := createRows(sreq.Mode, func(sm string) *govulncheck.Result {
-		row := *baseRow
-		row.ScanMode = sm
+	var rows []bigquery.Row
+	for _, ps := range scans {
+		serr := err
+		if serr == nil {
+			serr = ps.err
+		}
+		if serr != nil {
+			serr = classifyScanError(serr)
+		}
+		response := ps.response
+		rows = append(rows, createRows(sreq.Mode, func(sm string) *govulncheck.Result {
+			row := *baseRow
+			row.ScanMode = sm
+			if ps.platform != "" {
+				row.Platform = bigquery.NullString(ps.platform)
+			}
 
-		if err != nil {
-			row.AddError(err)
-			log.Infof(ctx, "scanner.runScanModule returned err=%v for %s in scan mode=%s", err, sreq.Path(), sm)
-		} else {
-			// We use govulncheck command execution time as the approx. time for symbol level analysis.
-			// We currently don't have a way of approximating time for measuring time for module and
-			// package level scans. We could run govulncheck with -scan package and -scan module, but
-			// that would put more pressure on the pipeline and use more resources.
-			if sm == ModeGovulncheck {
-				row.ScanSeconds = response.Stats.ScanSeconds
-				row.ScanMemory = int64(response.Stats.ScanMemory)
+			if serr != nil {
+				row.AddError(serr)
+				log.Infof(ctx, "scanner.runScanModule returned err=%v for %s in scan mode=%s", serr, sreq.Path(), sm)
+			} else {
+				// We use govulncheck command execution time as the approx. time for symbol level analysis.
+				// We currently don't have a way of approximating time for measuring time for module and
+				// package level scans. We could run govulncheck with -scan package and -scan module, but
+				// that would put more pressure on the pipeline and use more resources.
+				if sm == ModeGovulncheck {
+					row.ScanSeconds = response.Stats.ScanSeconds
+					row.ScanMemory = int64(response.Stats.ScanMemory)
+					row.SetProgress(response.Stats.Progress)
+				}
+				row.Vulns = vulnsForScanMode(response, sm)
+				if sreq.Unaffected {
+					row.Unaffected = response.Unaffected(scanModeLevels[sm])
+				}
+				log.Infof(ctx, "scanner.runScanModule returned %d findings for %s with row.Vulns=%d in scan mode=%s", len(response.Findings), sreq.Path(), len(row.Vulns), sm)
 			}
-			row.Vulns = vulnsForScanMode(response, sm)
-			log.Infof(ctx, "scanner.runScanModule returned %d findings for %s with row.Vulns=%d in scan mode=%s", len(response.Findings), sreq.Path(), len(row.Vulns), sm)
-		}
-		return &row
-	})
+			return &row
+		})...)
+	}
 
-	if err := writeResults(ctx, sreq.Serve, w, s.bqClient, govulncheck.TableName, rows); err != nil {
+	if sreq.Format == govulncheck.FormatSARIF {
+		err = serveJSON(ctx, s.sarifLog(scans, err, sreq.Module, baseRow.Version, sreq.Subdir), w)
+	} else {
+		err = writeResults(ctx, sreq.Serve, w, s.bqClient, s.local, govulncheck.TableName, rows)
+	}
+	if err != nil {
 		return nil, err
 	}
+	if len(sreq.Platforms) > 0 || sreq.Subdir != "" || sreq.Tests {
+		// The work state records scans of the whole module, without its
+		// tests, for the worker's platform only.
+		return nil, nil
+	}
 	// all of the rows share the same work state
 	return baseRow.WorkState(), nil
 }
 
+// sarifLog returns a SARIF log with a run for each of the scans of the
+// module version, or of its subdirectory subdir if it is not empty.
+// If err is not nil, the module could not be scanned.
+func (s *scanner) sarifLog(scans []platformScan, err error, modulePath, version, subdir string) *sarif.Log {
+	// Positions in the results are in the directory where govulncheck
+	// ran the scan.
+	dir := filepath.Join(moduleDir(modulePath, version), filepath.FromSlash(subdir))
+	if !s.insecure {
+		dir = strings.TrimPrefix(dir, sandboxRoot)
+	}
+	var runs []*sarif.Run
+	for _, ps := range scans {
+		var run *sarif.Run
+		switch {
+		case err != nil:
+			run = govulncheck.FailedSARIFRun(classifyScanError(err))
+		case ps.err != nil:
+			run = govulncheck.FailedSARIFRun(classifyScanError(ps.err))
+		default:
+			run = ps.response.SARIFRun(dir)
+		}
+		run.Properties = map[string]any{"module": modulePath, "version": version}
+		if ps.platform != "" {
+			run.Properties["platform"] = ps.platform
+		}
+		runs = append(runs, run)
+	}
+	return sarif.NewLog(runs...)
+}
+
+// classifyScanError wraps err, an error from runScanModule, with the
+// derrors error that describes its category.
+func classifyScanError(err error) error {
+	switch {
+	case errors.Is(err, derrors.ScanModuleDiskLimitExceeded),
+		errors.Is(err, derrors.ChecksumMismatch):
+		// Already classified.
+		return err
+	case isModVendor(err):
+		return fmt.Errorf("%v: %w", err, derrors.LoadVendorError)
+	case isGovulncheckLoadError(err) || isBuildIssue(err):
+		return fmt.Errorf("%v: %w", err, derrors.LoadPackagesError)
+	case isNoRequiredModule(err):
+		// Should be subsumed by LoadPackagesError, kept for sanity
+		// and to catch unexpected changes in govulncheck output.
+		return fmt.Errorf("%v: %w", err, derrors.LoadPackagesNoRequiredModuleError)
+	case isMissingGoSumEntry(err):
+		// Should be subsumed by LoadPackagesError, kept for sanity.
+		// and to catch unexpected changes in govulncheck output.
+		return fmt.Errorf("%v: %w", err, derrors.LoadPackagesMissingGoSumEntryError)
+	case isReplacingWithLocalPath(err):
+		// Should be subsumed by LoadPackagesError, kept for sanity.
+		// and to catch unexpected changes in govulncheck output.
+		return fmt.Errorf("%v: %w", err, derrors.LoadPackagesImportedLocalError)
+	case isMissingGoMod(err) || isNoModulesSpecified(err):
+		// Should be subsumed by LoadPackagesError, kept for sanity
+		// and to catch unexpected changes in govulncheck output.
+		return fmt.Errorf("%v: %w", err, derrors.LoadPackagesNoGoModError)
+	case isTooManyFiles(err):
+		return fmt.Errorf("%v: %w", err, derrors.ScanModuleTooManyOpenFiles)
+	case isProxyCacheMiss(err):
+		return fmt.Errorf("%v: %w", err, derrors.ProxyError)
+	case isSandboxRelatedIssue(err):
+		return fmt.Errorf("%v: %w", err, derrors.ScanModuleSandboxError)
+	default:
+		return fmt.Errorf("%v: %w", err, derrors.ScanModuleGovulncheckError)
+	}
+}
+
 // vulnsForScanMode produces Vulns from findings at the specified
 // govulncheck scan mode.
 func vulnsForScanMode(response *govulncheck.AnalysisResponse, scanMode string) []*govulncheck.Vuln {
 	var modeFindings []*govulncheckapi.Finding
+	want := scanModeLevels[scanMode]
 	for _, f := range response.Findings {
-		fr := f.Trace[0]
-		switch scanMode {
-		case scanModeSourceSymbol:
-			if fr.Function != "" {
-				modeFindings = append(modeFindings, f)
-			}
-		case scanModeSourcePackage:
-			if fr.Package != "" && fr.Function == "" {
-				modeFindings = append(modeFindings, f)
-			}
-		case scanModeSourceModule:
-			if fr.Package == "" && fr.Function == "" { // fr.Module is always set
-				modeFindings = append(modeFindings, f)
-			}
+		if want != "" && f.Level() == want {
+			modeFindings = append(modeFindings, f)
 		}
 	}
 
 	var vulns []*govulncheck.Vuln
-	seen := make(map[govulncheck.Vuln]bool) // avoid duplicates
+	// Avoid duplicates. A vulnerable package with several findings, like
+	// one per symbol, is reported once, with the details of its first finding.
+	// It is test-only if all of its findings are.
+	seen := make(map[govulncheck.Vuln]*govulncheck.Vuln)
 	for _, f := range modeFindings {
 		v := govulncheck.ConvertGovulncheckFinding(f, response.OSVs[f.OSV])
-		if seen[*v] {
+		testOnly, known := response.TestOnly(f)
+		if prev := seen[v.Key()]; prev != nil {
+			if known && !testOnly {
+				prev.TestOnly = bq.NullBool{Bool: false, Valid: true}
+			}
 			continue
 		}
-		seen[*v] = true
+		if known {
+			v.TestOnly = bq.NullBool{Bool: testOnly, Valid: true}
+		}
+		seen[v.Key()] = v
 		vulns = append(vulns, v)
 	}
 	return vulns
@@ -454,40 +726,79 @@ func createRows(mode string, f func(string) *govulncheck.Result) []bigquery.Row
 	return rows
 }
 
+// A platformScan is the result of scanning a module for one platform.
+type platformScan struct {
+	platform string // GOOS/GOARCH, or "" for the worker's platform
+	response *govulncheck.AnalysisResponse
+	err      error
+}
+
 // runScanModule fetches the module version from the proxy, and analyzes its source
 // code for vulnerabilities. The analysis of binaries is done in CompareModule.
-func (s *scanner) runScanModule(ctx context.Context, modulePath, version, mode string) (response *govulncheck.AnalysisResponse, err error) {
-	err = doScan(ctx, modulePath, version, s.insecure, func() (err error) {
+//
+// The module is the subdirectory subdir of the download, if it is not empty.
+// Its tests are scanned too if tests is true.
+// It is analyzed once for each of platforms, or once for the worker's
+// platform if there are none. A scan that fails for one platform does not stop
+// the others; runScanModule returns an error only if the module could not be
+// scanned at all.
+func (s *scanner) runScanModule(ctx context.Context, modulePath, version, subdir, mode string, platforms []string, tests bool) (scans []platformScan, stats moduleStats, err error) {
+	if len(platforms) == 0 {
+		platforms = []string{""}
+	}
+	for _, p := range platforms {
+		scans = append(scans, platformScan{platform: p})
+	}
+	err = doScan(ctx, s.limits, modulePath, version, s.insecure, func(ctx context.Context) (err error) {
 		// Download the module first.
 		inputPath := moduleDir(modulePath, version)
 		defer derrors.Cleanup(&err, func() error { return os.RemoveAll(inputPath) })
 		const init = true
-		if err := prepareModule(ctx, modulePath, version, inputPath, s.proxyClient, s.insecure, init); err != nil {
+		stats, err = prepareModule(ctx, modulePath, version, inputPath, subdir, proxySource{s.proxyClient, s.sumDB, s.zip}, s.insecure, init, "")
+		if err != nil {
 			return err
 		}
 
-		if s.insecure {
-			response, err = s.runGovulncheckScanInsecure(inputPath, mode)
-		} else {
-			response, err = s.runGovulncheckScanSandbox(ctx, inputPath, mode)
+		for i := range scans {
+			ps := &scans[i]
+			var env []string
+			if ps.platform != "" {
+				env, ps.err = govulncheck.PlatformEnv(ps.platform)
+				if ps.err != nil {
+					continue
+				}
+				log.Infof(ctx, "scanning for platform %s", ps.platform)
+			}
+			if s.insecure {
+				ps.response, ps.err = s.runGovulncheckScanInsecure(ctx, stats.dir, mode, tests, env)
+			} else {
+				ps.response, ps.err = s.runGovulncheckScanSandbox(ctx, stats.dir, mode, tests, env)
+			}
+			if ps.response != nil && ps.response.ProductionError != "" {
+				log.Warnf(ctx, "listing packages without tests: %s", ps.response.ProductionError)
+			}
+			if ps.response != nil {
+				log.Debugf(ctx, "govulncheck stats: %dkb | %vs", ps.response.Stats.ScanMemory, ps.response.Stats.ScanSeconds)
+			}
 		}
-		if response != nil {
-			log.Debugf(ctx, "govulncheck stats: %dkb | %vs", response.Stats.ScanMemory, response.Stats.ScanSeconds)
+		if len(scans) == 1 {
+			// Report the error of a single scan like any other scan error.
+			err, scans[0].err = scans[0].err, nil
 		}
 		return err
 	})
-	return response, err
+	return scans, stats, err
 }
 
-func (s *scanner) runGovulncheckScanSandbox(ctx context.Context, inputPath, mode string) (_ *govulncheck.AnalysisResponse, err error) {
+func (s *scanner) runGovulncheckScanSandbox(ctx context.Context, inputPath, mode string, tests bool, env []string) (_ *govulncheck.AnalysisResponse, err error) {
 	smdir := strings.TrimPrefix(inputPath, sandboxRoot)
 	err = s.sbox.Validate()
 	log.Debugf(ctx, "sandbox Validate returned %v", err)
 
-	return s.runGovulncheckSandbox(ctx, mode, smdir)
+	return s.runGovulncheckSandbox(ctx, mode, smdir, tests, env)
 }
 
-func (s *scanner) runGovulncheckSandbox(ctx context.Context, mode, arg string) (*govulncheck.AnalysisResponse, error) {
+func (s *scanner) runGovulncheckSandbox(ctx context.Context, mode, arg string, tests bool, env []string) (*govulncheck.AnalysisResponse, error) {
 	goOut, err := s.sbox.Command("/usr/local/go/bin/go", "version").Output()
 	if err != nil {
 		log.Debugf(ctx, "running go version error: %v", err)
@@ -496,7 +807,18 @@ func (s *scanner) runGovulncheckSandbox(ctx context.Context, mode, arg string) (
 	}
 	log.Infof(ctx, "running govulncheck in sandbox: mode %s, arg %q", mode, arg)
 	// currently, only source analysis is done in govulncheck_sandbox (binary is done elsewhere)
-	cmd := s.sbox.Command(filepath.Join(s.binaryDir, "govulncheck_sandbox"), s.govulncheckPath, govulncheck.FlagSource, arg, s.vulnDBDir)
+	var args []string
+	if tests {
+		args = append(args, "-test")
+	}
+	args = append(args, s.govulncheckPath, govulncheck.FlagSource, arg, s.vulnDBDir)
+	cmd := s.sbox.Command(filepath.Join(s.binaryDir, "govulncheck_sandbox"), args...)
+	if len(env) > 0 {
+		// govulncheck_sandbox passes its environment on to govulncheck.
+		cmd.Env = env
+		cmd.AppendToEnv = true
+	}
+	cmd.Stderr = govulncheck.NewProgressWriter(func(p govulncheck.Progress) { reportProgress(ctx, p) })
 	stdout, err := cmd.Output()
 	log.Infof(ctx, "govulncheck in sandbox finished with err=%v", err)
 	if err != nil {
@@ -505,8 +827,8 @@ func (s *scanner) runGovulncheckSandbox(ctx context.Context, mode, arg string) (
 	return govulncheck.UnmarshalAnalysisResponse(stdout)
 }
 
-func (s *scanner) runGovulncheckCompareSandbox(ctx context.Context, arg string) (*govulncheck.CompareResponse, error) {
-	cmd := s.sbox.Command(filepath.Join(s.binaryDir, "govulncheck_compare"), s.govulncheckPath, arg, s.vulnDBDir)
+func (s *scanner) runGovulncheckCompareSandbox(ctx context.Context, arg, cacheDir string, sel buildbinary.Selection) (*govulncheck.CompareResponse, error) {
+	cmd := s.sbox.Command(filepath.Join(s.binaryDir, "govulncheck_compare"), compareArgs(s.govulncheckPath, arg, s.vulnDBDir, cacheDir, sel)...)
 	log.Infof(ctx, "running govulncheck_compare: arg %q", arg)
 	stdout, err := cmd.Output()
 	log.Infof(ctx, "govulncheck_compare in sandbox finished with err=%v", err)
@@ -516,9 +838,27 @@ func (s *scanner) runGovulncheckCompareSandbox(ctx context.Context, arg string)
 	return govulncheck.UnmarshalCompareResponse(stdout)
 }
 
-func (s *scanner) runGovulncheckScanInsecure(inputPath, mode string) (_ *govulncheck.AnalysisResponse, err error) {
+// compareArgs returns the arguments of govulncheck_compare, which scans the
+// module in moduleDir with the vuln DB in vulnDBDir, building the binaries
+// that sel selects, or taking them from cacheDir if it is not empty.
+func compareArgs(govulncheckPath, moduleDir, vulnDBDir, cacheDir string, sel buildbinary.Selection) []string {
+	var args []string
+	if cacheDir != "" {
+		args = append(args, "-cache", cacheDir)
+	}
+	if sel.Max > 0 {
+		args = append(args, "-max", strconv.Itoa(sel.Max))
+	}
+	if len(sel.Packages) > 0 {
+		args = append(args, "-pkgs", strings.Join(sel.Packages, ","))
+	}
+	return append(args, govulncheckPath, moduleDir, vulnDBDir)
+}
+
+func (s *scanner) runGovulncheckScanInsecure(ctx context.Context, inputPath, mode string, tests bool, env []string) (_ *govulncheck.AnalysisResponse, err error) {
 	// currently, only source analysis is done individually (binary is done in compare mode)
-	return govulncheck.RunGovulncheckCmd(s.govulncheckPath, govulncheck.FlagSource, "./...", inputPath, s.vulnDBDir)
+	return govulncheck.RunGovulncheckCmdWithProgress(s.govulncheckPath, govulncheck.FlagSource, "./...", inputPath, s.vulnDBDir, tests, env,
+		func(p govulncheck.Progress) { reportProgress(ctx, p) })
 }
 
 func isGovulncheckLoadError(err error) bool {
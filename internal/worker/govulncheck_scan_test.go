@@ -5,6 +5,7 @@
 package worker
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -13,6 +14,9 @@ import (
 	"strings"
 	"testing"
 
+	bq "cloud.google.com/go/bigquery"
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/pkgsite-metrics/internal/buildbinary"
 	"golang.org/x/pkgsite-metrics/internal/buildtest"
 	"golang.org/x/pkgsite-metrics/internal/govulncheck"
 	"golang.org/x/pkgsite-metrics/internal/govulncheckapi"
@@ -62,6 +66,59 @@ func TestVulnsForMode(t *testing.T) {
 	}
 }
 
+func TestVulnsForModeTestOnly(t *testing.T) {
+	findings := []*govulncheckapi.Finding{
+		{Trace: []*govulncheckapi.Frame{{Module: "M1", Package: "P1", Function: "F1"}, {Function: "TestX", Position: &govulncheckapi.Position{Filename: "x_test.go"}}}},
+		{Trace: []*govulncheckapi.Frame{{Module: "M1", Package: "P1", Function: "F2"}, {Function: "X", Position: &govulncheckapi.Position{Filename: "x.go"}}}},
+		{Trace: []*govulncheckapi.Frame{{Module: "M2", Package: "P2", Function: "F3"}, {Function: "TestY", Position: &govulncheckapi.Position{Filename: "y_test.go"}}}},
+	}
+	resp := &govulncheck.AnalysisResponse{
+		Findings:   findings,
+		Production: &govulncheck.PackageGraph{Packages: []string{"P1"}, Modules: []string{"M1", "M2"}},
+	}
+	got := map[string]bq.NullBool{}
+	for _, v := range vulnsForScanMode(resp, scanModeSourceSymbol) {
+		got[v.PackagePath] = v.TestOnly
+	}
+	want := map[string]bq.NullBool{
+		// P1 is imported without tests, even if F1 is only reached from one.
+		"P1": {Bool: false, Valid: true},
+		// P2 is only imported by tests.
+		"P2": {Bool: true, Valid: true},
+	}
+	if !cmp.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	resp.Production = nil
+	for _, v := range vulnsForScanMode(resp, scanModeSourceSymbol) {
+		if v.TestOnly.Valid {
+			t.Errorf("%s: got TestOnly %v without a package graph, want null", v.PackagePath, v.TestOnly)
+		}
+	}
+}
+
+func TestCompareArgs(t *testing.T) {
+	for _, test := range []struct {
+		cacheDir string
+		sel      buildbinary.Selection
+		want     []string
+	}{
+		{"", buildbinary.Selection{}, []string{"gvc", "mod", "db"}},
+		{"", buildbinary.Selection{Max: 3}, []string{"-max", "3", "gvc", "mod", "db"}},
+		{
+			"", buildbinary.Selection{Packages: []string{"a/cmd/x", "a/cmd/y"}, Max: 1},
+			[]string{"-max", "1", "-pkgs", "a/cmd/x,a/cmd/y", "gvc", "mod", "db"},
+		},
+		{"bins", buildbinary.Selection{Max: 3}, []string{"-cache", "bins", "-max", "3", "gvc", "mod", "db"}},
+	} {
+		got := compareArgs("gvc", "mod", "db", test.cacheDir, test.sel)
+		if !cmp.Equal(got, test.want) {
+			t.Errorf("%q, %+v: got %q, want %q", test.cacheDir, test.sel, got, test.want)
+		}
+	}
+}
+
 func TestUnrecoverableError(t *testing.T) {
 	for _, e := range []struct {
 		ec   string
@@ -77,6 +134,28 @@ func TestUnrecoverableError(t *testing.T) {
 	}
 }
 
+func TestSkipWorkState(t *testing.T) {
+	wv := &govulncheck.WorkVersion{GoVersion: "go1.22", WorkerVersion: "1"}
+	s := &scanner{workVersion: wv}
+	for _, test := range []struct {
+		ws     *govulncheck.WorkState
+		labels string
+		want   bool
+	}{
+		{&govulncheck.WorkState{WorkVersion: wv}, "", true},
+		{&govulncheck.WorkState{WorkVersion: wv, Labels: "arm:control"}, "arm:control", true},
+		// The earlier scan's rows don't have the labels.
+		{&govulncheck.WorkState{WorkVersion: wv}, "arm:control", false},
+		{&govulncheck.WorkState{WorkVersion: wv, Labels: "arm:control"}, "arm:treatment", false},
+		{&govulncheck.WorkState{WorkVersion: &govulncheck.WorkVersion{GoVersion: "go1.21"}, ErrorCategory: "LOAD"}, "", true},
+		{&govulncheck.WorkState{WorkVersion: &govulncheck.WorkVersion{GoVersion: "go1.21"}, ErrorCategory: "LOAD"}, "arm:control", false},
+	} {
+		if got := s.skipWorkState(test.ws, test.labels); got != test.want {
+			t.Errorf("%+v, %q: got %t, want %t", test.ws, test.labels, got, test.want)
+		}
+	}
+}
+
 // TODO: can we have a test for sandbox? We do test the sandbox
 // and unmarshalling in cmd/govulncheck_sandbox, so what would be
 // left here is checking that runsc is initiated properly. It is
@@ -98,7 +177,7 @@ func TestRunScanModuleInsecure(t *testing.T) {
 
 	s := &scanner{insecure: true, govulncheckPath: govulncheckPath, vulnDBDir: vulndb}
 
-	response, err := s.runGovulncheckScanInsecure("../testdata/module", ModeGovulncheck)
+	response, err := s.runGovulncheckScanInsecure(context.Background(), "../testdata/module", ModeGovulncheck, false, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -0,0 +1,92 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/audit"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+	"golang.org/x/pkgsite-metrics/internal/log"
+	"golang.org/x/pkgsite-metrics/internal/scan"
+)
+
+// deleteWorkStatesParams are the query params of
+// /govulncheck/workstates/delete.
+type deleteWorkStatesParams struct {
+	Before        string // delete the work states written before this date, YYYY-MM-DD or RFC 3339
+	WorkerVersion string // delete the work states written by this worker version
+	DryRun        bool   // count the work states, but do not delete them
+}
+
+// A deleteWorkStatesReport describes the work states deleted by
+// /govulncheck/workstates/delete, or that would be deleted in a dry run.
+type deleteWorkStatesReport struct {
+	Before        *time.Time `json:"before,omitempty"`
+	WorkerVersion string     `json:"worker_version,omitempty"`
+	DryRun        bool       `json:"dry_run"`
+	WorkStates    int        `json:"work_states"`
+}
+
+// handleDeleteWorkStates deletes the govulncheck work states selected by
+// the request, so that their modules are scanned again the next time they
+// are enqueued, and serves a JSON deleteWorkStatesReport. At least one of
+// the before and workerversion params is required.
+func (h *GovulncheckServer) handleDeleteWorkStates(w http.ResponseWriter, r *http.Request) (err error) {
+	defer derrors.Wrap(&err, "handleDeleteWorkStates")
+
+	ctx := r.Context()
+	var params deleteWorkStatesParams
+	if err := scan.ParseParams(r, &params); err != nil {
+		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+	}
+	f, err := workStateFilter(&params)
+	if err != nil {
+		return err
+	}
+	if h.fsNamespace == nil {
+		return errors.New("Firestore is disabled")
+	}
+	n, err := govulncheck.DeleteWorkStates(ctx, h.fsNamespace, f, params.DryRun)
+	if err != nil {
+		return err
+	}
+	log.Infof(ctx, "deleted %d work states (%+v, dry run %t)", n, f, params.DryRun)
+	if !params.DryRun {
+		h.recordAction(ctx, r, audit.WorkStates, "", fmt.Sprintf("%d work states", n))
+	}
+	rep := &deleteWorkStatesReport{
+		WorkerVersion: f.WorkerVersion,
+		DryRun:        params.DryRun,
+		WorkStates:    n,
+	}
+	if !f.Before.IsZero() {
+		rep.Before = &f.Before
+	}
+	return writeJSON(w, rep)
+}
+
+// workStateFilter returns the filter of the work states selected by params.
+func workStateFilter(params *deleteWorkStatesParams) (*govulncheck.WorkStateFilter, error) {
+	f := &govulncheck.WorkStateFilter{WorkerVersion: params.WorkerVersion}
+	if params.Before != "" {
+		t, err := time.Parse(time.DateOnly, params.Before)
+		if err != nil {
+			t, err = time.Parse(time.RFC3339, params.Before)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad before %q: want YYYY-MM-DD or RFC 3339", derrors.InvalidArgument, params.Before)
+		}
+		f.Before = t
+	}
+	if f.IsZero() {
+		return nil, fmt.Errorf("%w: need before or workerversion", derrors.InvalidArgument)
+	}
+	return f, nil
+}
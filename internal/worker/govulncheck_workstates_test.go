@@ -0,0 +1,53 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+)
+
+func TestWorkStateFilter(t *testing.T) {
+	for _, test := range []struct {
+		params deleteWorkStatesParams
+		want   *govulncheck.WorkStateFilter
+	}{
+		{
+			deleteWorkStatesParams{Before: "2023-06-01"},
+			&govulncheck.WorkStateFilter{Before: time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)},
+		},
+		{
+			deleteWorkStatesParams{Before: "2023-06-01T12:00:00Z", WorkerVersion: "w1"},
+			&govulncheck.WorkStateFilter{Before: time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC), WorkerVersion: "w1"},
+		},
+		{
+			deleteWorkStatesParams{WorkerVersion: "w1", DryRun: true},
+			&govulncheck.WorkStateFilter{WorkerVersion: "w1"},
+		},
+	} {
+		got, err := workStateFilter(&test.params)
+		if err != nil {
+			t.Fatalf("%+v: %v", test.params, err)
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("%+v: mismatch (-want, +got):\n%s", test.params, diff)
+		}
+	}
+
+	for _, params := range []deleteWorkStatesParams{
+		{},
+		{DryRun: true},
+		{Before: "June 1"},
+	} {
+		if _, err := workStateFilter(&params); !errors.Is(err, derrors.InvalidArgument) {
+			t.Errorf("%+v: got %v, want InvalidArgument", params, err)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"runtime"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"golang.org/x/pkgsite-metrics/internal/config"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/fstore"
+	"golang.org/x/pkgsite-metrics/internal/jobs"
+	"golang.org/x/pkgsite-metrics/internal/log"
+	"golang.org/x/pkgsite-metrics/internal/observe"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The Firestore document holding the recent starts of worker instances.
+const (
+	healthCollection = "Health"
+	startsDoc        = "starts"
+)
+
+// startsWindow is how long starts of worker instances are remembered.
+const startsWindow = 24 * time.Hour
+
+// backlogJobWindow bounds the jobs whose unfinished tasks are counted in
+// the queue backlog: jobs that started earlier are assumed to be done or
+// stale.
+const backlogJobWindow = 7 * 24 * time.Hour
+
+// instanceStart is when this worker instance started.
+var instanceStart = time.Now()
+
+// instanceStarts is the content of the starts document.
+type instanceStarts struct {
+	Times []time.Time
+}
+
+// add adds t to the starts, forgetting those before the window.
+func (st *instanceStarts) add(t time.Time) {
+	var ts []time.Time
+	for _, u := range st.Times {
+		if t.Sub(u) < startsWindow {
+			ts = append(ts, u)
+		}
+	}
+	st.Times = append(ts, t)
+}
+
+// since returns the number of starts at or after t.
+func (st *instanceStarts) since(t time.Time) int {
+	n := 0
+	for _, u := range st.Times {
+		if !u.Before(t) {
+			n++
+		}
+	}
+	return n
+}
+
+// recordStart records the start of this instance in Firestore, so that
+// /health can report how often instances start.
+func recordStart(ctx context.Context, ns *fstore.Namespace) (err error) {
+	defer derrors.Wrap(&err, "recordStart")
+	ref := ns.Collection(healthCollection).Doc(startsDoc)
+	return ns.Client().RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		st, err := getStarts(tx.Get(ref))
+		if err != nil {
+			return err
+		}
+		st.add(instanceStart)
+		return tx.Set(ref, st)
+	})
+}
+
+// getStarts decodes the starts document, which may not exist.
+func getStarts(ds *firestore.DocumentSnapshot, err error) (*instanceStarts, error) {
+	if status.Code(err) == codes.NotFound {
+		return &instanceStarts{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fstore.Decode[instanceStarts](ds)
+}
+
+// handleHealth serves a summary of the health of this instance as JSON.
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	h := s.health(time.Now())
+	if err := s.addBacklog(ctx, h); err != nil {
+		log.Warnf(ctx, "estimating queue backlog: %v", err)
+	}
+	if s.fsNamespace != nil {
+		st, err := getStarts(s.fsNamespace.Collection(healthCollection).Doc(startsDoc).Get(ctx))
+		if err != nil {
+			log.Warnf(ctx, "reading instance starts: %v", err)
+		} else {
+			h.StartsLastHour = st.since(h.Now.Add(-time.Hour))
+			h.StartsLastDay = st.since(h.Now.Add(-startsWindow))
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return writeJSON(w, h)
+}
+
+// handleQueueStats serves the statistics of the task queues as JSON:
+// their depth, dispatch rate and error rate.
+func (s *Server) handleQueueStats(w http.ResponseWriter, r *http.Request) (err error) {
+	defer derrors.Wrap(&err, "Server.handleQueueStats")
+	if s.queue == nil {
+		return &serverError{err: errors.New("queue not configured"), status: http.StatusNotImplemented}
+	}
+	stats, err := s.queue.Stats(r.Context())
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return writeJSON(w, stats)
+}
+
+// health returns the state of this instance at time now that it knows
+// without asking other services.
+func (s *Server) health(now time.Time) *observe.Health {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	h := &observe.Health{
+		Started:     instanceStart,
+		Now:         now,
+		ActiveScans: int(activeScans.Load()),
+		Requests:    s.reqs.Load(),
+		HeapBytes:   ms.HeapAlloc,
+	}
+	if s.cfg != nil {
+		h.VersionID = s.cfg.VersionID
+	}
+	if s.dynamic != nil {
+		h.RequestLimit = s.dynamic.Get().RequestLimit
+	}
+	for _, tp := range runningTasks.list(now) {
+		if tp.Stalled {
+			h.StalledScans++
+		}
+	}
+	if config.OnCloudRun() {
+		if cur, max, err := cgroupMemory(); err == nil {
+			h.ContainerMemoryBytes = int64(cur)
+			h.ContainerMemoryLimitBytes = int64(max)
+		}
+	}
+	return h
+}
+
+// errOldJob stops the listing of jobs in addBacklog.
+var errOldJob = errors.New("job is too old")
+
+// addBacklog sets the queue backlog of h: the depth of the in-memory
+// queue, or else the number of unfinished tasks of recent jobs.
+func (s *Server) addBacklog(ctx context.Context, h *observe.Health) error {
+	if q, ok := s.queue.(interface{ Depth() int }); ok {
+		h.QueueBacklog = q.Depth()
+		return nil
+	}
+	if s.jobDB == nil {
+		return nil
+	}
+	n := 0
+	err := s.jobDB.ListJobs(ctx, nil, func(j *jobs.Job, _ time.Time) error {
+		if h.Now.Sub(j.StartedAt) > backlogJobWindow {
+			return errOldJob
+		}
+		n += jobBacklog(j)
+		return nil
+	})
+	if err != nil && !errors.Is(err, errOldJob) {
+		return err
+	}
+	h.QueueBacklog = n
+	return nil
+}
+
+// jobBacklog returns the number of tasks of j that are queued or running.
+func jobBacklog(j *jobs.Job) int {
+	if j.Done() || j.Canceled {
+		return 0
+	}
+	return max(j.NumEnqueued-j.NumFinished(), 0)
+}
@@ -0,0 +1,79 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/config"
+	"golang.org/x/pkgsite-metrics/internal/jobs"
+	"golang.org/x/pkgsite-metrics/internal/queue"
+)
+
+func TestInstanceStarts(t *testing.T) {
+	now := time.Date(2023, 3, 12, 12, 0, 0, 0, time.UTC)
+	st := &instanceStarts{}
+	for _, ago := range []time.Duration{30 * time.Hour, 5 * time.Hour, 2 * time.Hour, 30 * time.Minute} {
+		st.add(now.Add(-ago))
+	}
+	st.add(now)
+	// The start 30 hours ago is forgotten once the later ones are added.
+	if got, want := len(st.Times), 4; got != want {
+		t.Errorf("got %d starts, want %d", got, want)
+	}
+	if got, want := st.since(now.Add(-time.Hour)), 2; got != want {
+		t.Errorf("last hour: got %d, want %d", got, want)
+	}
+	if got, want := st.since(now.Add(-startsWindow)), 4; got != want {
+		t.Errorf("last day: got %d, want %d", got, want)
+	}
+}
+
+func TestJobBacklog(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		job  jobs.Job
+		want int
+	}{
+		{"running", jobs.Job{NumEnqueued: 10, NumSucceeded: 3, NumFailed: 1}, 6},
+		{"finished", jobs.Job{NumEnqueued: 10, NumSucceeded: 10}, 0},
+		{"canceled", jobs.Job{NumEnqueued: 10, NumSucceeded: 3, Canceled: true}, 0},
+		{"stale", jobs.Job{NumEnqueued: 10, StaleReason: "lost"}, 0},
+	} {
+		if got := jobBacklog(&test.job); got != test.want {
+			t.Errorf("%s: got %d, want %d", test.name, got, test.want)
+		}
+	}
+}
+
+// depthQueue is a queue.Queue that only knows its depth.
+type depthQueue struct {
+	queue.Queue
+	depth int
+}
+
+func (q *depthQueue) Depth() int { return q.depth }
+
+func TestHealth(t *testing.T) {
+	s := &Server{
+		cfg:     &config.Config{VersionID: "v1"},
+		dynamic: config.NewDynamicConfig(),
+		queue:   &depthQueue{depth: 7},
+	}
+	s.reqs.Store(3)
+	now := instanceStart.Add(time.Hour)
+	h := s.health(now)
+	if err := s.addBacklog(context.Background(), h); err != nil {
+		t.Fatal(err)
+	}
+	if h.VersionID != "v1" || h.Requests != 3 || h.QueueBacklog != 7 || h.Uptime() != time.Hour {
+		t.Errorf("got %+v", h)
+	}
+	if h.RequestLimit != s.dynamic.Get().RequestLimit {
+		t.Errorf("got request limit %d, want %d", h.RequestLimit, s.dynamic.Get().RequestLimit)
+	}
+}
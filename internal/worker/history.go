@@ -0,0 +1,95 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/analysis"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+	"golang.org/x/pkgsite-metrics/internal/scan"
+)
+
+// historyParams are the query params of /history.
+type historyParams struct {
+	Module string        // module path
+	Since  time.Duration // how far back to look
+	Limit  int           // maximum number of results of each kind
+}
+
+const (
+	defaultHistorySince = 90 * 24 * time.Hour
+	defaultHistoryLimit = 100
+	maxHistoryLimit     = 1000
+)
+
+// A moduleHistory is the timeline of the results for a module.
+type moduleHistory struct {
+	Module      string                      `json:"module"`
+	Since       time.Time                   `json:"since"`
+	Govulncheck []*govulncheck.HistoryEntry `json:"govulncheck"`
+	Analysis    []*analysis.HistoryEntry    `json:"analysis"`
+}
+
+// handleHistory serves the recent govulncheck and analysis results for the
+// module in the module query param, most recent first, as a JSON
+// moduleHistory. The results are summarized: they have the work version,
+// error, number of findings and, for govulncheck, the scan's duration and
+// memory, but not the findings themselves.
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) (err error) {
+	defer derrors.Wrap(&err, "handleHistory")
+
+	ctx := r.Context()
+	params, err := parseHistoryParams(r)
+	if err != nil {
+		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+	}
+	if s.bqClient == nil {
+		return errors.New("BigQuery is disabled")
+	}
+	h := &moduleHistory{
+		Module: params.Module,
+		Since:  time.Now().Add(-params.Since).UTC(),
+	}
+	h.Govulncheck, err = govulncheck.ReadHistory(ctx, s.bqClient, params.Module, h.Since, params.Limit)
+	if err != nil {
+		return err
+	}
+	h.Analysis, err = analysis.ReadHistory(ctx, s.bqClient, params.Module, h.Since, params.Limit)
+	if err != nil {
+		return err
+	}
+	// Serve empty lists rather than nulls.
+	if h.Govulncheck == nil {
+		h.Govulncheck = []*govulncheck.HistoryEntry{}
+	}
+	if h.Analysis == nil {
+		h.Analysis = []*analysis.HistoryEntry{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return writeJSON(w, h)
+}
+
+// parseHistoryParams parses and checks the query params of /history.
+func parseHistoryParams(r *http.Request) (*historyParams, error) {
+	params := &historyParams{Since: defaultHistorySince, Limit: defaultHistoryLimit}
+	if err := scan.ParseParams(r, params); err != nil {
+		return nil, err
+	}
+	if params.Module == "" {
+		return nil, errors.New("missing module")
+	}
+	if params.Since <= 0 {
+		return nil, fmt.Errorf("since must be positive, not %s", params.Since)
+	}
+	if params.Limit <= 0 || params.Limit > maxHistoryLimit {
+		return nil, fmt.Errorf("limit must be between 1 and %d, not %d", maxHistoryLimit, params.Limit)
+	}
+	return params, nil
+}
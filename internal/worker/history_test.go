@@ -0,0 +1,89 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+)
+
+func TestParseHistoryParams(t *testing.T) {
+	for _, test := range []struct {
+		query string
+		want  *historyParams // nil for error
+	}{
+		{"module=example.com/m", &historyParams{Module: "example.com/m", Since: defaultHistorySince, Limit: defaultHistoryLimit}},
+		{"module=example.com/m&since=48h&limit=5", &historyParams{Module: "example.com/m", Since: 48 * time.Hour, Limit: 5}},
+		{"", nil},
+		{"module=example.com/m&since=-1h", nil},
+		{"module=example.com/m&limit=0", nil},
+		{"module=example.com/m&limit=1001", nil},
+		{"module=example.com/m&since=forever", nil},
+	} {
+		r := httptest.NewRequest("GET", "/history?"+test.query, nil)
+		got, err := parseHistoryParams(r)
+		if test.want == nil {
+			if err == nil {
+				t.Errorf("%q: got %+v, want error", test.query, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.query, err)
+			continue
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("%q: mismatch (-want, +got):\n%s", test.query, diff)
+		}
+	}
+}
+
+func TestHandleHistory(t *testing.T) {
+	fake := bigquery.NewFake()
+	fake.QueryFunc = func(q string, params []bigquery.Param) ([]any, error) {
+		switch {
+		case strings.Contains(q, fake.FullTableName(govulncheck.TableName)):
+			return []any{
+				&govulncheck.HistoryEntry{Version: "v1.1.0", ScanMode: ModeGovulncheck, NumVulns: 2},
+				&govulncheck.HistoryEntry{Version: "v1.0.0", ScanMode: ModeGovulncheck, Error: "boom", ErrorCategory: "MISC"},
+			}, nil
+		default:
+			return nil, nil
+		}
+	}
+	s := &Server{bqClient: fake}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/history?module=example.com/m&limit=5", nil)
+	if err := s.handleHistory(w, r); err != nil {
+		t.Fatal(err)
+	}
+	var got moduleHistory
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Module != "example.com/m" {
+		t.Errorf("got module %q, want example.com/m", got.Module)
+	}
+	var versions []string
+	for _, e := range got.Govulncheck {
+		versions = append(versions, e.Version)
+	}
+	if diff := cmp.Diff([]string{"v1.1.0", "v1.0.0"}, versions); diff != "" {
+		t.Errorf("govulncheck versions mismatch (-want, +got):\n%s", diff)
+	}
+	if got.Analysis == nil || len(got.Analysis) != 0 {
+		t.Errorf("got analysis %v, want empty list", got.Analysis)
+	}
+	if n := len(fake.Queries()); n != 2 {
+		t.Errorf("got %d queries, want 2", n)
+	}
+}
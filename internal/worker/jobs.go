@@ -4,11 +4,18 @@
 
 // Handlers for jobs.
 //
-// jobs/describe?jobid=xxx		describe a job
-
-// TODO:
-// jobs/list					list all jobs
-// jobs/cancel?jobid=xxx		cancel a job
+// jobs/describe?jobid=xxx		describe a job, with the counts of its rows in BigQuery
+// jobs/cancel?jobid=xxx		cancel a job and delete its queued tasks; recorded in the audit table
+// jobs/rank?jobid=xxx&limit=N	rank a job's diagnostics by importers of affected modules
+// jobs/tasks					list the scans running on this instance, with their progress
+// jobs/finalize?jobid=xxx		write the summary of a finished job to BigQuery, if not already written
+// jobs/progress?jobid=xxx		stream the job as JSON each time it changes, until it is done
+// jobs/results?jobid=xxx&format=sarif	the analysis results of a job, as JSON rows (the default) or a SARIF log
+// jobs/merge?jobid=xxx		copy the rows of a done job's own table to the analysis table, then drop it; recorded in the audit table
+// jobs/droptable?jobid=xxx	drop the own table of a done job, with its rows; recorded in the audit table
+// jobs/reap					mark the jobs without updates for longer than the configured time stale, and finalize them
+// jobs/fingerprint?jobid=xxx&module=M	the environment of the job's latest scan of module M, to reproduce it
+// jobs/list?user=U&state=S&since=T&until=T&limit=N&pagetoken=P	list the matching jobs, most recent first, a page of N at a time
 
 package worker
 
@@ -20,12 +27,19 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
+	"golang.org/x/mod/module"
 	"golang.org/x/pkgsite-metrics/internal/analysis"
+	"golang.org/x/pkgsite-metrics/internal/audit"
 	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/fstore"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
 	"golang.org/x/pkgsite-metrics/internal/jobs"
+	"golang.org/x/pkgsite-metrics/internal/log"
+	"golang.org/x/pkgsite-metrics/internal/queue"
 )
 
 func (s *Server) handleJobs(w http.ResponseWriter, r *http.Request) (err error) {
@@ -36,19 +50,46 @@ func (s *Server) handleJobs(w http.ResponseWriter, r *http.Request) (err error)
 		return &serverError{err: errors.New("jobs DB not configured"), status: http.StatusNotImplemented}
 	}
 
+	if err := s.processJobRequest(ctx, w, r, s.jobDB); err != nil {
+		return err
+	}
 	jobID := r.FormValue("jobid")
-	return s.processJobRequest(ctx, w, r.URL.Path, jobID, s.jobDB)
+	switch strings.TrimPrefix(r.URL.Path, "/jobs/") {
+	case "cancel":
+		s.recordAction(ctx, r, audit.Cancel, jobID, "")
+	case "merge":
+		s.recordAction(ctx, r, audit.MergeTable, jobID, "")
+	case "droptable":
+		s.recordAction(ctx, r, audit.DropTable, jobID, "")
+	}
+	return nil
 }
 
 type jobDB interface {
 	CreateJob(ctx context.Context, j *jobs.Job) error
 	GetJob(ctx context.Context, id string) (*jobs.Job, error)
 	UpdateJob(ctx context.Context, id string, f func(*jobs.Job) error) error
-	ListJobs(context.Context, func(*jobs.Job, time.Time) error) error
+	ListJobs(context.Context, *jobs.Filter, func(*jobs.Job, time.Time) error) error
+	WatchJob(ctx context.Context, id string, f func(*jobs.Job) error) error
+	ReleaseClaims(ctx context.Context, jobID string) ([]*jobs.ClaimTransfer, error)
 }
 
-func (s *Server) processJobRequest(ctx context.Context, w io.Writer, path, jobID string, db jobDB) error {
-	path = strings.TrimPrefix(path, "/jobs/")
+// jobCountersWindow is how long increments to a job's counters are
+// collected before they are written together.
+const jobCountersWindow = time.Second
+
+// defaultJobStaleAfter is how long a job can go without updates before
+// jobs/reap marks it stale, if the configuration doesn't say.
+const defaultJobStaleAfter = 24 * time.Hour
+
+// defaultRankLimit is the default number of diagnostics returned by jobs/rank.
+const defaultRankLimit = 100
+
+// processJobRequest serves the jobs request r, reading and writing jobs
+// in db. Each endpoint parses the query params it uses.
+func (s *Server) processJobRequest(ctx context.Context, w io.Writer, r *http.Request, db jobDB) error {
+	path := strings.TrimPrefix(r.URL.Path, "/jobs/")
+	jobID := r.FormValue("jobid")
 	switch path {
 	case "describe": // describe one job
 		if jobID == "" {
@@ -58,32 +99,108 @@ func (s *Server) processJobRequest(ctx context.Context, w io.Writer, path, jobID
 		if err != nil {
 			return err
 		}
-		return writeJSON(w, job)
+		return writeJSON(w, s.describeJob(ctx, job))
 
 	case "cancel":
 		if jobID == "" {
 			return fmt.Errorf("missing jobid: %w", derrors.InvalidArgument)
 		}
-		return db.UpdateJob(ctx, jobID, func(j *jobs.Job) error {
+		// Mark the job canceled first, so that tasks which are already
+		// running or about to run exit early.
+		err := db.UpdateJob(ctx, jobID, func(j *jobs.Job) error {
 			j.Canceled = true
 			return nil
 		})
-
-	case "list":
-		var joblist []*jobs.Job
-		err := db.ListJobs(ctx, func(j *jobs.Job, _ time.Time) error {
-			joblist = append(joblist, j)
+		if err != nil {
+			return err
+		}
+		if s.queue == nil {
 			return nil
-		})
+		}
+		// Hand the work that other jobs share to one of them before
+		// the tasks that would have done it are deleted.
+		s.releaseClaims(ctx, db, jobID)
+		// Delete the queued tasks, so they don't start instances
+		// only to check the flag and exit.
+		n, err := s.queue.DeleteJobTasks(ctx, jobID)
 		if err != nil {
 			return err
 		}
-		return writeJSON(w, joblist)
+		if err := db.UpdateJob(ctx, jobID, func(j *jobs.Job) error {
+			j.NumDeleted += n
+			return nil
+		}); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "deleted %d queued tasks\n", n)
+		return nil
 
 	case "results":
 		if jobID == "" {
 			return fmt.Errorf("missing jobid: %w", derrors.InvalidArgument)
 		}
+		filter := resultFilter(r)
+		format := r.FormValue("format")
+		switch format {
+		case "", govulncheck.FormatJSON, govulncheck.FormatSARIF:
+		default:
+			return fmt.Errorf("bad format %q: %w", format, derrors.InvalidArgument)
+		}
+		job, err := db.GetJob(ctx, jobID)
+		if err != nil {
+			return err
+		}
+		if s.bqClient == nil && s.localResults != nil {
+			results, err := s.localResults.readAnalysisResults(job)
+			if err != nil {
+				return err
+			}
+			return writeResultsFormat(w, filter.Apply(results), format)
+		}
+		if s.bqClient == nil {
+			return errors.New("bq client is nil")
+		}
+		results, err := analysis.ReadResults(ctx, s.bqClient, resultsTable(job), job, filter)
+		if err != nil {
+			return err
+		}
+		return writeResultsFormat(w, results, format)
+
+	case "rank":
+		if jobID == "" {
+			return fmt.Errorf("missing jobid: %w", derrors.InvalidArgument)
+		}
+		limit := defaultRankLimit
+		if l := r.FormValue("limit"); l != "" {
+			var err error
+			limit, err = strconv.Atoi(l)
+			if err != nil || limit <= 0 {
+				return fmt.Errorf("bad limit %q: %w", l, derrors.InvalidArgument)
+			}
+		}
+		job, err := db.GetJob(ctx, jobID)
+		if err != nil {
+			return err
+		}
+		if s.bqClient == nil {
+			return errors.New("bq client is nil")
+		}
+		ranks, err := analysis.ReadDiagnosticRanks(ctx, s.bqClient, resultsTable(job), job, limit)
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, ranks)
+
+	case "fingerprint":
+		// Unlike that of jobs/results, the module param is the exact path
+		// of a module, not a prefix.
+		modulePath := r.FormValue("module")
+		if jobID == "" || modulePath == "" {
+			return fmt.Errorf("missing jobid or module: %w", derrors.InvalidArgument)
+		}
+		if err := module.CheckPath(modulePath); err != nil {
+			return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+		}
 		job, err := db.GetJob(ctx, jobID)
 		if err != nil {
 			return err
@@ -91,17 +208,296 @@ func (s *Server) processJobRequest(ctx context.Context, w io.Writer, path, jobID
 		if s.bqClient == nil {
 			return errors.New("bq client is nil")
 		}
-		results, err := analysis.ReadResults(ctx, s.bqClient, job.Binary, job.BinaryVersion, job.BinaryArgs)
+		fp, err := analysis.ReadFingerprint(ctx, s.bqClient, resultsTable(job), jobID, modulePath)
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, fp)
+
+	case "finalize":
+		if jobID == "" {
+			return fmt.Errorf("missing jobid: %w", derrors.InvalidArgument)
+		}
+		sum, err := s.finalizeJob(ctx, db, jobID)
+		if err != nil {
+			return err
+		}
+		if sum == nil {
+			fmt.Fprintf(w, "job %s is not finished, or already finalized\n", jobID)
+			return nil
+		}
+		return writeJSON(w, sum)
+
+	case "merge", "droptable":
+		if jobID == "" {
+			return fmt.Errorf("missing jobid: %w", derrors.InvalidArgument)
+		}
+		if s.bqClient == nil {
+			return errors.New("bq client is nil")
+		}
+		return s.releaseJobTable(ctx, w, db, jobID, path == "merge")
+
+	case "reap":
+		staleAfter := defaultJobStaleAfter
+		if s.cfg != nil && s.cfg.JobStaleAfter > 0 {
+			staleAfter = s.cfg.JobStaleAfter
+		}
+		reaped, err := s.reapStaleJobs(ctx, db, time.Now(), staleAfter)
 		if err != nil {
 			return err
 		}
-		return writeJSON(w, results)
+		return writeJSON(w, reaped)
+
+	case "list":
+		filter, pageSize, err := parseJobFilter(r)
+		if err != nil {
+			return err
+		}
+		return listJobs(ctx, w, db, filter, pageSize)
+
+	case "progress":
+		if jobID == "" {
+			return fmt.Errorf("missing jobid: %w", derrors.InvalidArgument)
+		}
+		return streamJobProgress(ctx, w, db, jobID)
+
+	case "tasks":
+		// Only scans on the instance serving this request are listed.
+		return writeJSON(w, runningTasks.list(time.Now()))
 
 	default:
 		return fmt.Errorf("unknown path %q: %w", path, derrors.InvalidArgument)
 	}
 }
 
+// resultFilter returns the filter of jobs/results given by the query
+// params of r.
+func resultFilter(r *http.Request) analysis.ResultFilter {
+	return analysis.ResultFilter{
+		ModulePrefix: r.FormValue("module"),
+		Category:     r.FormValue("category"),
+		Analyzer:     r.FormValue("analyzer"),
+	}
+}
+
+// streamJobProgress writes the job to w as JSON, and again each time it
+// changes, until it is done or canceled or ctx is done. Each write is
+// flushed, if w supports it, so that clients see updates as they happen.
+func streamJobProgress(ctx context.Context, w io.Writer, db jobDB, jobID string) error {
+	err := db.WatchJob(ctx, jobID, func(j *jobs.Job) error {
+		if err := writeJSON(w, j); err != nil {
+			return err
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		if j.Done() || j.Canceled {
+			return fstore.StopWatch
+		}
+		return nil
+	})
+	if ctx.Err() != nil {
+		// The client went away.
+		return nil
+	}
+	return err
+}
+
+var errNotFinalizable = errors.New("job not finalizable")
+
+// finalizeJob writes a summary of the job to the jobs table and marks it
+// finalized, if it is done and has not already been finalized. It returns
+// the summary, or nil if the job was not finalized.
+func (s *Server) finalizeJob(ctx context.Context, db jobDB, jobID string) (_ *jobs.Summary, err error) {
+	defer derrors.Wrap(&err, "finalizeJob(%q)", jobID)
+	var job *jobs.Job
+	// Marking the job finalized in a transaction ensures that only one of
+	// the tasks that finish last writes the summary.
+	err = db.UpdateJob(ctx, jobID, func(j *jobs.Job) error {
+		if j.Finalized || !j.Done() {
+			return errNotFinalizable
+		}
+		j.Finalized = true
+		job = j
+		return nil
+	})
+	if errors.Is(err, errNotFinalizable) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sum := jobs.NewSummary(job, time.Now())
+	if err := writeResult(ctx, false, nil, s.bqClient, s.localResults, jobs.TableName, sum); err != nil {
+		// Unmark the job, so jobs/finalize can try again.
+		uerr := db.UpdateJob(ctx, jobID, func(j *jobs.Job) error {
+			j.Finalized = false
+			return nil
+		})
+		return nil, errors.Join(err, uerr)
+	}
+	log.Infof(ctx, "finalized job %s", jobID)
+	s.notifyJob(ctx, job, sum)
+	s.releaseClaims(ctx, db, jobID)
+	return sum, nil
+}
+
+// releaseClaims releases the job's claims on the work of its tasks, and
+// enqueues a task for each job that unfinished work was handed to.
+// A stale job's tasks may never finish, and a canceled job's are deleted.
+// Failures are only logged.
+func (s *Server) releaseClaims(ctx context.Context, db jobDB, jobID string) {
+	transfers, err := db.ReleaseClaims(ctx, jobID)
+	if err != nil {
+		log.Errorf(ctx, err, "releasing the claims of job %q", jobID)
+	}
+	for _, t := range transfers {
+		req := &analysis.ScanRequest{}
+		if err := json.Unmarshal(t.Request, req); err != nil {
+			log.Errorf(ctx, err, "decoding a task of job %q", jobID)
+			continue
+		}
+		req.JobID = t.JobID
+		if s.queue == nil {
+			log.Warnf(ctx, "no queue: %s is not scanned for job %q", req.Name(), t.JobID)
+			continue
+		}
+		if _, err := s.queue.EnqueueScan(ctx, req, &queue.Options{Namespace: "analysis", JobID: t.JobID, Cancelable: true}); err != nil {
+			log.Errorf(ctx, err, "enqueuing %s for job %q", req.Name(), t.JobID)
+		}
+	}
+}
+
+var errNotStale = errors.New("job not stale")
+
+// parseJobFilter parses the parameters of a jobs/list request: the filter
+// of the jobs to list, and the maximum number of jobs on a page, or zero
+// for all of them.
+func parseJobFilter(r *http.Request) (_ *jobs.Filter, pageSize int, err error) {
+	f := &jobs.Filter{
+		User:  r.FormValue("user"),
+		State: r.FormValue("state"),
+		After: r.FormValue("pagetoken"),
+	}
+	if f.State != "" {
+		if err := jobs.CheckState(f.State); err != nil {
+			return nil, 0, err
+		}
+	}
+	parseTime := func(name string) (time.Time, error) {
+		v := r.FormValue(name)
+		if v == "" {
+			return time.Time{}, nil
+		}
+		if t, err := time.Parse(time.DateOnly, v); err == nil {
+			return t, nil
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("bad %s %q: want YYYY-MM-DD or RFC 3339: %w", name, v, derrors.InvalidArgument)
+		}
+		return t, nil
+	}
+	if f.Since, err = parseTime("since"); err != nil {
+		return nil, 0, err
+	}
+	if f.Until, err = parseTime("until"); err != nil {
+		return nil, 0, err
+	}
+	if l := r.FormValue("limit"); l != "" {
+		pageSize, err = strconv.Atoi(l)
+		if err != nil || pageSize <= 0 {
+			return nil, 0, fmt.Errorf("bad limit %q: %w", l, derrors.InvalidArgument)
+		}
+	}
+	return f, pageSize, nil
+}
+
+// errPageFull stops listing jobs when a page is full.
+var errPageFull = errors.New("page full")
+
+// listJobs writes the jobs that match filter, most recently started first.
+// If the listing is paged, because pageSize is positive or filter.After is
+// set, it writes them as a JSON jobs.Page, which has at most pageSize jobs
+// and the token of the next page if there are more. Otherwise it writes all
+// of them as a JSON array, as it did before paging existed.
+func listJobs(ctx context.Context, w io.Writer, db jobDB, filter *jobs.Filter, pageSize int) error {
+	page := jobs.Page{Jobs: []*jobs.Job{}}
+	err := db.ListJobs(ctx, filter, func(j *jobs.Job, _ time.Time) error {
+		if pageSize > 0 && len(page.Jobs) == pageSize {
+			// There is at least one more job.
+			page.NextPageToken = page.Jobs[pageSize-1].ID()
+			return errPageFull
+		}
+		page.Jobs = append(page.Jobs, j)
+		return nil
+	})
+	if err != nil && !errors.Is(err, errPageFull) {
+		return err
+	}
+	if pageSize <= 0 && filter.After == "" {
+		return writeJSON(w, page.Jobs)
+	}
+	return writeJSON(w, page)
+}
+
+// reapStaleJobs marks the jobs that have not been updated for the duration
+// staleAfter as stale, recording why, and finalizes them. Their tasks were
+// probably lost, so the jobs would otherwise stay in progress forever.
+// It returns the jobs it marked.
+func (s *Server) reapStaleJobs(ctx context.Context, db jobDB, now time.Time, staleAfter time.Duration) (_ []*jobs.Job, err error) {
+	defer derrors.Wrap(&err, "reapStaleJobs")
+
+	stale := map[string]string{} // job ID to reason
+	var ids []string             // most recently started first
+	err = db.ListJobs(ctx, nil, func(j *jobs.Job, lastUpdate time.Time) error {
+		if reason := j.CheckStale(lastUpdate, now, staleAfter); reason != "" {
+			stale[j.ID()] = reason
+			ids = append(ids, j.ID())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	reaped := []*jobs.Job{}
+	for _, id := range ids {
+		var job *jobs.Job
+		err := db.UpdateJob(ctx, id, func(j *jobs.Job) error {
+			// A task may have finished the job since it was listed.
+			if j.Finished() || j.Canceled || j.StaleReason != "" {
+				return errNotStale
+			}
+			j.StaleReason = stale[id]
+			job = j
+			return nil
+		})
+		if errors.Is(err, errNotStale) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		log.Warnf(ctx, "job %s is stale: %s", id, job.StaleReason)
+		reaped = append(reaped, job)
+		if _, err := s.finalizeJob(ctx, db, id); err != nil {
+			// The job is marked stale, so the next reap can't finalize
+			// it; jobs/finalize can.
+			log.Errorf(ctx, err, "finalizing stale job %s", id)
+		}
+	}
+	return reaped, nil
+}
+
+// writeResultsFormat writes analysis results to w in format, as JSON rows
+// or as a SARIF log.
+func writeResultsFormat(w io.Writer, results []*analysis.Result, format string) error {
+	if format == govulncheck.FormatSARIF {
+		return writeJSON(w, analysis.SARIFLog(results))
+	}
+	return writeJSON(w, results)
+}
+
 // writeJSON JSON-marshals v and writes it to w.
 // Marshal failures do not result in partial writes.
 func writeJSON(w io.Writer, v any) error {
@@ -114,3 +510,93 @@ func writeJSON(w io.Writer, v any) error {
 	_, err := w.Write(buf.Bytes())
 	return err
 }
+
+// resultsTable returns the BigQuery table holding the job's results.
+func resultsTable(job *jobs.Job) string {
+	if job.Table != "" && !job.TableDropped {
+		return job.Table
+	}
+	return analysis.TableName
+}
+
+// releaseJobTable drops the table of the job's own results, after copying
+// its rows to the analysis table if merge is true. The job must be done or
+// canceled, so that no more rows are written to the table. Afterwards the
+// job's results are its rows in the analysis table. Each step is recorded
+// on the job, so that a release that fails can be repeated.
+func (s *Server) releaseJobTable(ctx context.Context, w io.Writer, db jobDB, jobID string, merge bool) error {
+	job, err := db.GetJob(ctx, jobID)
+	if err != nil {
+		return err
+	}
+	if job.Table == "" {
+		return fmt.Errorf("job %s has no table of its own: %w", jobID, derrors.InvalidArgument)
+	}
+	if !job.Done() && !job.Canceled {
+		return fmt.Errorf("job %s is not done: %w", jobID, derrors.InvalidArgument)
+	}
+	if job.TableDropped {
+		if merge && !job.TableMerged {
+			return fmt.Errorf("table %s of job %s was dropped without merging: %w", job.Table, jobID, derrors.InvalidArgument)
+		}
+		fmt.Fprintf(w, "table %s was already dropped\n", job.Table)
+		return nil
+	}
+	// Rows of finished tasks may still be buffered.
+	if s.uploads != nil {
+		if err := s.uploads.Flush(ctx); err != nil {
+			return err
+		}
+	}
+	analysis.RegisterJobTable(job.Table)
+	if merge && !job.TableMerged {
+		// Replacing the job's rows makes repeating the copy harmless.
+		n, err := s.bqClient.ReplaceRows(ctx, job.Table, analysis.TableName, "job_id", jobID)
+		if err != nil {
+			return err
+		}
+		if err := db.UpdateJob(ctx, jobID, func(j *jobs.Job) error {
+			j.TableMerged = true
+			return nil
+		}); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "copied %d rows from %s to %s\n", n, job.Table, analysis.TableName)
+	}
+	if err := s.bqClient.DeleteTable(ctx, job.Table); err != nil {
+		return err
+	}
+	if err := db.UpdateJob(ctx, jobID, func(j *jobs.Job) error {
+		j.TableDropped = true
+		return nil
+	}); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "dropped table %s\n", job.Table)
+	return nil
+}
+
+// describeJob returns a description of the job. If BigQuery is available,
+// the description includes the counts of the rows that the job's tasks
+// stored and, once the job is finished, any discrepancies between those
+// counts and the job's.
+func (s *Server) describeJob(ctx context.Context, job *jobs.Job) *jobs.Description {
+	d := &jobs.Description{Job: job}
+	if s.bqClient == nil {
+		return d
+	}
+	rc, err := analysis.ReadJobRowCounts(ctx, s.bqClient, resultsTable(job), job.ID())
+	if err != nil {
+		// The job is still worth describing.
+		log.Errorf(ctx, err, "counting rows of job %q", job.ID())
+		return d
+	}
+	d.Rows = rc
+	if job.Finished() {
+		d.Discrepancies = job.Discrepancies(rc)
+		for _, m := range d.Discrepancies {
+			log.Warnf(ctx, "job %s: %s", job.ID(), m)
+		}
+	}
+	return d
+}
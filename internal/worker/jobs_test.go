@@ -8,15 +8,25 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
 	"time"
 
+	bq "cloud.google.com/go/bigquery"
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	"golang.org/x/exp/maps"
 	"golang.org/x/exp/slices"
+	"golang.org/x/pkgsite-metrics/internal/analysis"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
 	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/fstore"
 	"golang.org/x/pkgsite-metrics/internal/jobs"
 )
 
@@ -30,7 +40,7 @@ func TestJobs(t *testing.T) {
 	}
 	s := &Server{}
 	var buf bytes.Buffer
-	if err := s.processJobRequest(ctx, &buf, "/jobs/describe", job.ID(), db); err != nil {
+	if err := s.processJobRequest(ctx, &buf, jobRequest("describe", job.ID()), db); err != nil {
 		t.Fatal(err)
 	}
 
@@ -42,7 +52,7 @@ func TestJobs(t *testing.T) {
 		t.Errorf("got\n%+v\nwant\n%+v", got, job)
 	}
 
-	if err := s.processJobRequest(ctx, &buf, "/jobs/cancel", job.ID(), db); err != nil {
+	if err := s.processJobRequest(ctx, &buf, jobRequest("cancel", job.ID()), db); err != nil {
 		t.Fatal(err)
 	}
 
@@ -55,7 +65,7 @@ func TestJobs(t *testing.T) {
 	}
 
 	buf.Reset()
-	if err := s.processJobRequest(ctx, &buf, "/jobs/list", "", db); err != nil {
+	if err := listJobs(ctx, &buf, db, &jobs.Filter{}, 0); err != nil {
 		t.Fatal(err)
 	}
 	// Don't check for specific output, just make sure there's something
@@ -64,6 +74,340 @@ func TestJobs(t *testing.T) {
 	if !strings.Contains(got3, job.User) {
 		t.Errorf("got\n%q\nwhich does not contain the job user %q", got3, job.User)
 	}
+
+	buf.Reset()
+	if err := s.processJobRequest(ctx, &buf, jobRequest("progress", job.ID()), db); err != nil {
+		t.Fatal(err)
+	}
+	var got4 jobs.Job
+	if err := json.Unmarshal(buf.Bytes(), &got4); err != nil {
+		t.Fatal(err)
+	}
+	if !got4.Canceled {
+		t.Error("progress: got canceled false, want true")
+	}
+}
+
+func TestFinalizeJob(t *testing.T) {
+	ctx := context.Background()
+	db := &testJobDB{map[string]*jobs.Job{}}
+	tm := time.Date(2023, 3, 11, 1, 2, 3, 0, time.UTC)
+	job := jobs.NewJob("user", tm, "url", "bin", "<hash>", "args")
+	job.NumEnqueued = 4
+	job.NumSucceeded = 1
+	job.NumErrored = 2
+	job.ErrorCategories = map[string]int{"MISC": 1, "LOAD": 1}
+	if err := db.CreateJob(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{}
+	finalize := func() *jobs.Summary {
+		t.Helper()
+		sum, err := s.finalizeJob(ctx, db, job.ID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sum
+	}
+
+	// Not finished.
+	if sum := finalize(); sum != nil {
+		t.Fatalf("got %+v for unfinished job, want nil", sum)
+	}
+
+	db.jobs[job.ID()].NumSkipped = 1
+	sum := finalize()
+	if sum == nil {
+		t.Fatal("finished job not finalized")
+	}
+	want := &jobs.Summary{
+		JobID:         job.ID(),
+		User:          "user",
+		URL:           "url",
+		Binary:        "bin",
+		BinaryVersion: "<hash>",
+		BinaryArgs:    "args",
+		StartedAt:     tm,
+		NumEnqueued:   4,
+		NumSkipped:    1,
+		NumErrored:    2,
+		NumSucceeded:  1,
+		Errors:        []*jobs.ErrorCount{{Category: "LOAD", Count: 1}, {Category: "MISC", Count: 1}},
+	}
+	if diff := cmp.Diff(want, sum, cmpopts.IgnoreFields(jobs.Summary{}, "FinishedAt", "DurationSeconds")); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+	if !db.jobs[job.ID()].Finalized {
+		t.Error("job not marked finalized")
+	}
+
+	// Already finalized.
+	if sum := finalize(); sum != nil {
+		t.Errorf("got %+v for finalized job, want nil", sum)
+	}
+}
+
+func TestListJobs(t *testing.T) {
+	ctx := context.Background()
+	db := &testJobDB{map[string]*jobs.Job{}}
+	tm := time.Date(2023, 3, 11, 1, 2, 3, 0, time.UTC)
+	var ids []string // most recent first
+	for i, user := range []string{"ann", "bob", "ann", "ann"} {
+		j := jobs.NewJob(user, tm.Add(time.Duration(-i)*time.Hour), "url", "bin", "<hash>", "args")
+		j.Canceled = i == 1
+		if err := db.CreateJob(ctx, j); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, j.ID())
+	}
+
+	list := func(query string) jobs.Page {
+		t.Helper()
+		r := httptest.NewRequest("GET", "/jobs/list?"+query, nil)
+		filter, pageSize, err := parseJobFilter(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := listJobs(ctx, &buf, db, filter, pageSize); err != nil {
+			t.Fatal(err)
+		}
+		var page jobs.Page
+		if pageSize == 0 && filter.After == "" {
+			// Unpaged listings are plain arrays.
+			err = json.Unmarshal(buf.Bytes(), &page.Jobs)
+		} else {
+			err = json.Unmarshal(buf.Bytes(), &page)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return page
+	}
+	pageIDs := func(p jobs.Page) []string {
+		var ids []string
+		for _, j := range p.Jobs {
+			ids = append(ids, j.ID())
+		}
+		return ids
+	}
+
+	for _, test := range []struct {
+		query string
+		want  []string
+	}{
+		{"", ids},
+		{"user=ann", []string{ids[0], ids[2], ids[3]}},
+		{"state=canceled", []string{ids[1]}},
+		{"user=ann&state=canceled", nil},
+		{"since=" + tm.Add(-90*time.Minute).Format(time.RFC3339), ids[:2]},
+		{"until=" + tm.Add(-90*time.Minute).Format(time.RFC3339), ids[2:]},
+		{"since=2023-03-10&until=2023-03-11", ids[2:]},
+	} {
+		if got := pageIDs(list(test.query)); !cmp.Equal(got, test.want) {
+			t.Errorf("%q: got %v, want %v", test.query, got, test.want)
+		}
+	}
+
+	// Page through all the jobs, three at a time.
+	p := list("limit=3")
+	if got := pageIDs(p); !cmp.Equal(got, ids[:3]) || p.NextPageToken != ids[2] {
+		t.Fatalf("first page: got %v, next %q", got, p.NextPageToken)
+	}
+	p = list("limit=3&pagetoken=" + p.NextPageToken)
+	if got := pageIDs(p); !cmp.Equal(got, ids[3:]) || p.NextPageToken != "" {
+		t.Fatalf("second page: got %v, next %q", got, p.NextPageToken)
+	}
+
+	for _, query := range []string{"state=lost", "since=yesterday", "limit=0"} {
+		r := httptest.NewRequest("GET", "/jobs/list?"+query, nil)
+		if _, _, err := parseJobFilter(r); !errors.Is(err, derrors.InvalidArgument) {
+			t.Errorf("%q: got %v, want InvalidArgument", query, err)
+		}
+	}
+}
+
+func TestJobTable(t *testing.T) {
+	ctx := context.Background()
+	db := &testJobDB{map[string]*jobs.Job{}}
+	job := jobs.NewJob("user", time.Date(2023, 3, 11, 1, 2, 3, 0, time.UTC), "url", "bin", "<hash>", "args")
+	job.Table = analysis.JobTableName(job.ID())
+	job.NumEnqueued = 2
+	if err := db.CreateJob(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	fake := bigquery.NewFake()
+	analysis.RegisterJobTable(job.Table)
+	for _, table := range []string{analysis.TableName, job.Table} {
+		if _, err := fake.CreateOrUpdateTable(ctx, table); err != nil {
+			t.Fatal(err)
+		}
+	}
+	jobIDCol := bq.NullString{StringVal: job.ID(), Valid: true}
+	for _, m := range []string{"a.com/m", "b.com/m"} {
+		row := &analysis.Result{ModulePath: m, Version: "v1.0.0", JobID: jobIDCol}
+		if err := fake.Upload(ctx, job.Table, row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A row of another job.
+	if err := fake.Upload(ctx, analysis.TableName, &analysis.Result{ModulePath: "c.com/m", Version: "v1.0.0"}); err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{bqClient: fake}
+	merge := func() error {
+		return s.processJobRequest(ctx, io.Discard, jobRequest("merge", job.ID()), db)
+	}
+
+	// Not done.
+	if err := merge(); !errors.Is(err, derrors.InvalidArgument) {
+		t.Fatalf("unfinished job: got %v, want InvalidArgument", err)
+	}
+
+	db.jobs[job.ID()].NumSucceeded = 2
+	// A merge that copied the rows but failed before recording it.
+	if _, err := fake.ReplaceRows(ctx, job.Table, analysis.TableName, "job_id", job.ID()); err != nil {
+		t.Fatal(err)
+	}
+	if err := merge(); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(fake.Rows(analysis.TableName)); got != 3 {
+		t.Errorf("got %d rows in the analysis table, want 3", got)
+	}
+	if err := fake.Upload(ctx, job.Table, &analysis.Result{}); !errors.Is(err, derrors.NotFound) {
+		t.Errorf("upload to merged table: got %v, want NotFound", err)
+	}
+	got := db.jobs[job.ID()]
+	if !got.TableMerged || !got.TableDropped {
+		t.Errorf("after merge: got merged %t, dropped %t; want both", got.TableMerged, got.TableDropped)
+	}
+	if rt := resultsTable(got); rt != analysis.TableName {
+		t.Errorf("after merge: results table is %q, want %q", rt, analysis.TableName)
+	}
+
+	// Merging again does nothing.
+	if err := merge(); err != nil {
+		t.Errorf("second merge: %v", err)
+	}
+	if got := len(fake.Rows(analysis.TableName)); got != 3 {
+		t.Errorf("after second merge: got %d rows in the analysis table, want 3", got)
+	}
+
+	// A table dropped without merging cannot be merged.
+	db.jobs[job.ID()].TableMerged = false
+	if err := merge(); !errors.Is(err, derrors.InvalidArgument) {
+		t.Errorf("merge after drop: got %v, want InvalidArgument", err)
+	}
+}
+
+func TestJobFingerprint(t *testing.T) {
+	ctx := context.Background()
+	db := &testJobDB{map[string]*jobs.Job{}}
+	job := jobs.NewJob("user", time.Date(2023, 3, 11, 1, 2, 3, 0, time.UTC), "url", "bin", "<hash>", "args")
+	if err := db.CreateJob(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	fake := bigquery.NewFake()
+	want := &analysis.Fingerprint{
+		ModulePath: "a.com/m",
+		Version:    "v1.0.0",
+		GoEnv:      bq.NullString{StringVal: `{"GOOS":"linux"}`, Valid: true},
+		ModuleHash: bq.NullString{StringVal: "h1:abc=", Valid: true},
+	}
+	fake.QueryFunc = func(q string, params []bigquery.Param) ([]any, error) {
+		if params[0].Value != job.ID() || params[1].Value != "a.com/m" {
+			return nil, nil
+		}
+		return []any{want}, nil
+	}
+	s := &Server{bqClient: fake}
+	fingerprint := func(module string) (string, error) {
+		var buf bytes.Buffer
+		err := s.processJobRequest(ctx, &buf, jobRequest("fingerprint", job.ID(), "module", module), db)
+		return buf.String(), err
+	}
+
+	got, err := fingerprint("a.com/m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fp analysis.Fingerprint
+	if err := json.Unmarshal([]byte(got), &fp); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, &fp); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+	if _, err := fingerprint("b.com/m"); !errors.Is(err, derrors.NotFound) {
+		t.Errorf("unscanned module: got %v, want NotFound", err)
+	}
+	if _, err := fingerprint(""); !errors.Is(err, derrors.InvalidArgument) {
+		t.Errorf("no module: got %v, want InvalidArgument", err)
+	}
+	if _, err := fingerprint("a.com/m/"); !errors.Is(err, derrors.InvalidArgument) {
+		t.Errorf("bad module path: got %v, want InvalidArgument", err)
+	}
+}
+
+func TestReapStaleJobs(t *testing.T) {
+	ctx := context.Background()
+	db := &testJobDB{map[string]*jobs.Job{}}
+	tm := time.Date(2023, 3, 11, 1, 2, 3, 0, time.UTC)
+	newJob := func(user string, finished bool, canceled bool) *jobs.Job {
+		t.Helper()
+		j := jobs.NewJob(user, tm, "url", "bin", "<hash>", "args")
+		j.NumEnqueued = 4
+		j.NumSucceeded = 1
+		if finished {
+			j.NumSucceeded = 4
+		}
+		j.Canceled = canceled
+		if err := db.CreateJob(ctx, j); err != nil {
+			t.Fatal(err)
+		}
+		return j
+	}
+	lost := newJob("lost", false, false)
+	newJob("finished", true, false)
+	newJob("canceled", false, true)
+
+	// testJobDB reports jobs as last updated at the zero time, so all
+	// unfinished jobs are stale.
+	s := &Server{}
+	reaped, err := s.reapStaleJobs(ctx, db, tm.Add(time.Hour), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reaped) != 1 || reaped[0].ID() != lost.ID() {
+		t.Fatalf("got %v, want only job %s", reaped, lost.ID())
+	}
+	got := db.jobs[lost.ID()]
+	if !strings.Contains(got.StaleReason, "1 of 4 tasks finished") {
+		t.Errorf("got stale reason %q", got.StaleReason)
+	}
+	if !got.Finalized {
+		t.Error("stale job not finalized")
+	}
+
+	// Stale jobs are reaped once.
+	reaped, err = s.reapStaleJobs(ctx, db, tm.Add(time.Hour), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reaped) != 0 {
+		t.Errorf("second reap: got %v, want none", reaped)
+	}
+}
+
+// jobRequest returns a request to the jobs endpoint for the job, with the
+// given names and values of other query params.
+func jobRequest(endpoint, jobID string, nameVals ...string) *http.Request {
+	q := url.Values{"jobid": {jobID}}
+	for i := 0; i+1 < len(nameVals); i += 2 {
+		q.Set(nameVals[i], nameVals[i+1])
+	}
+	return httptest.NewRequest("GET", "/jobs/"+endpoint+"?"+q.Encode(), nil)
 }
 
 type testJobDB struct {
@@ -106,13 +450,41 @@ func (d *testJobDB) UpdateJob(ctx context.Context, id string, f func(*jobs.Job)
 	return nil
 }
 
-func (d *testJobDB) ListJobs(ctx context.Context, f func(*jobs.Job, time.Time) error) error {
+// WatchJob calls f on the current state of the job. The test DB does not
+// change on its own, so there are no further calls.
+func (d *testJobDB) WatchJob(ctx context.Context, id string, f func(*jobs.Job) error) error {
+	j, err := d.GetJob(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := f(j); err != nil && !errors.Is(err, fstore.StopWatch) {
+		return err
+	}
+	return nil
+}
+
+func (d *testJobDB) ReleaseClaims(ctx context.Context, jobID string) ([]*jobs.ClaimTransfer, error) {
+	return nil, nil
+}
+
+func (d *testJobDB) ListJobs(ctx context.Context, filter *jobs.Filter, f func(*jobs.Job, time.Time) error) error {
+	if filter == nil {
+		filter = &jobs.Filter{}
+	}
 	jobslice := maps.Values(d.jobs)
 	// Sort by StartedAt descending.
 	slices.SortFunc(jobslice, func(j1, j2 *jobs.Job) bool {
 		return j1.StartedAt.After(j2.StartedAt)
 	})
+	after := filter.After == ""
 	for _, j := range jobslice {
+		if !after {
+			after = j.ID() == filter.After
+			continue
+		}
+		if !filter.Match(j) {
+			continue
+		}
 		if err := f(j, time.Time{}); err != nil {
 			return err
 		}
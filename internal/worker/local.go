@@ -0,0 +1,145 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Support for running the worker locally, end to end.
+
+package worker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/analysis"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/jobs"
+	"golang.org/x/pkgsite-metrics/internal/log"
+	"golang.org/x/pkgsite-metrics/internal/queue"
+)
+
+// A localResultsDir stores result rows in a directory, one file of
+// JSON lines per table.
+type localResultsDir struct {
+	dir string
+	mu  sync.Mutex
+}
+
+func (d *localResultsDir) filename(table string) string {
+	return filepath.Join(d.dir, table+".jsonl")
+}
+
+// write appends rows to the file for table, setting their upload time.
+func (d *localResultsDir) write(table string, rows ...bigquery.Row) (err error) {
+	defer derrors.Wrap(&err, "localResultsDir.write(%q)", table)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	f, err := os.OpenFile(d.filename(table), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer derrors.Cleanup(&err, f.Close)
+	now := time.Now()
+	enc := json.NewEncoder(f)
+	for _, row := range rows {
+		row.SetUploadTime(now)
+		if err := enc.Encode(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readAnalysisResults reads the analysis results for the job, keeping only
+// the most recent result for each module version, like analysis.ReadResults.
+func (d *localResultsDir) readAnalysisResults(job *jobs.Job) (_ []*analysis.Result, err error) {
+	defer derrors.Wrap(&err, "localResultsDir.readAnalysisResults(%q)", job.ID())
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	f, err := os.Open(d.filename(analysis.TableName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	latest := map[string]*analysis.Result{} // key is module@version
+	var keys []string                       // in order of first appearance
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 64*1024*1024)
+	for sc.Scan() {
+		var r analysis.Result
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			return nil, err
+		}
+		if !r.MatchesJob(job) {
+			continue
+		}
+		key := r.ModulePath + "@" + r.Version
+		prev, ok := latest[key]
+		if !ok {
+			keys = append(keys, key)
+		}
+		if !ok || !r.CreatedAt.Before(prev.CreatedAt) {
+			latest[key] = &r
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	var rs []*analysis.Result
+	for _, k := range keys {
+		rs = append(rs, latest[k])
+	}
+	return rs, nil
+}
+
+// localOpenFileFunc returns an openFileFunc that opens files under dir.
+// In local mode, it replaces the binary bucket.
+func localOpenFileFunc(dir string) openFileFunc {
+	return func(name string) (io.ReadCloser, error) {
+		return os.Open(filepath.Join(dir, filepath.FromSlash(name)))
+	}
+}
+
+// localProcessFunc returns a function for the in-memory queue that sends
+// each task to the server at baseURL, as Cloud Tasks would.
+func localProcessFunc(baseURL string) func(context.Context, queue.Task, string, []byte) (int, error) {
+	return func(ctx context.Context, t queue.Task, relativeURI string, payload []byte) (int, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+relativeURI, bytes.NewReader(payload))
+		if err != nil {
+			return 0, err
+		}
+		req.Header.Set("Content-Type", queue.PayloadContentType)
+		if p := queue.PriorityFromContext(ctx); p != "" && p != queue.PriorityNormal {
+			req.Header.Set(queue.PriorityHeader, p)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return 0, err
+		}
+		defer res.Body.Close()
+		// Read the body so the connection can be reused.
+		if _, err := io.Copy(io.Discard, res.Body); err != nil {
+			log.Warnf(ctx, "reading response for %s: %v", relativeURI, err)
+		}
+		if res.StatusCode != http.StatusOK {
+			return res.StatusCode, fmt.Errorf("%s: %s", relativeURI, res.Status)
+		}
+		return res.StatusCode, nil
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/analysis"
+	"golang.org/x/pkgsite-metrics/internal/jobs"
+	"golang.org/x/pkgsite-metrics/internal/scan"
+)
+
+func TestLocalResultsDir(t *testing.T) {
+	d := &localResultsDir{dir: t.TempDir()}
+	wv := analysis.WorkVersion{BinaryVersion: "bv", BinaryArgs: "-x", Analyzers: bq.NullString{StringVal: "a", Valid: true}}
+	row := func(mod, version, binary string) *analysis.Result {
+		return &analysis.Result{ModulePath: mod, Version: version, BinaryName: binary, WorkVersion: wv}
+	}
+	if err := d.write(analysis.TableName, row("m1", "v1", "bin"), row("m2", "v1", "bin"), row("m1", "v1", "other")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond) // ensure a later upload time
+	later := row("m1", "v1", "bin")
+	later.Error = "later"
+	if err := d.write(analysis.TableName, later); err != nil {
+		t.Fatal(err)
+	}
+
+	job := &jobs.Job{Binary: "bin", BinaryVersion: "bv", BinaryArgs: "-x", Analyzers: "a"}
+	got, err := d.readAnalysisResults(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d results, want 2", len(got))
+	}
+	if got[0].ModulePath != "m1" || got[0].Error != "later" {
+		t.Errorf("got %+v, want latest result for m1", got[0])
+	}
+	if got[1].ModulePath != "m2" {
+		t.Errorf("got %+v, want m2", got[1])
+	}
+
+	// A job with no results.
+	got, err = (&localResultsDir{dir: t.TempDir()}).readAnalysisResults(job)
+	if err != nil || len(got) != 0 {
+		t.Errorf("got (%v, %v), want no results", got, err)
+	}
+}
+
+func TestLocalProcessFunc(t *testing.T) {
+	var gotMethod, gotURI, gotBinary string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod = r.Method
+		gotURI = r.URL.RequestURI()
+		var p struct{ Binary string }
+		if err := scan.ParseParams(r, &p); err != nil {
+			t.Error(err)
+		}
+		gotBinary = p.Binary
+		if r.URL.Path == "/fail" {
+			http.Error(w, "fail", http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	process := localProcessFunc(srv.URL)
+	code, err := process(context.Background(), nil, "/analysis/scan/m@v1", []byte(`{"binary":"b"}`))
+	if err != nil || code != http.StatusOK {
+		t.Fatalf("got (%d, %v), want (200, nil)", code, err)
+	}
+	if gotMethod != http.MethodPost || gotURI != "/analysis/scan/m@v1" || gotBinary != "b" {
+		t.Errorf("got %s %s, binary %q", gotMethod, gotURI, gotBinary)
+	}
+	if code, err := process(context.Background(), nil, "/fail", nil); err == nil || code != http.StatusInternalServerError {
+		t.Errorf("got (%d, %v), want (500, error)", code, err)
+	}
+}
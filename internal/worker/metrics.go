@@ -0,0 +1,49 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"net/http"
+	"runtime"
+
+	"golang.org/x/pkgsite-metrics/internal/config"
+	"golang.org/x/pkgsite-metrics/internal/observe"
+)
+
+// handleMetrics serves the metrics recorded by the worker's handlers,
+// along with the state of the worker, in the Prometheus text format.
+// Cloud Monitoring receives the same metrics through the Observer;
+// this endpoint is for scraping dev environments and local runs.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	return observe.Metrics.WritePrometheus(w, s.gauges())
+}
+
+// gauges returns the current values of the worker's state for /metrics.
+func (s *Server) gauges() []observe.Gauge {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	gs := []observe.Gauge{
+		{Name: metricNamespace + "/active-scans", Help: "Number of scans in progress.", Value: float64(activeScans.Load())},
+		{Name: metricNamespace + "/heap-bytes", Help: "Bytes of allocated heap objects.", Value: float64(ms.HeapAlloc)},
+		{Name: metricNamespace + "/goroutines", Help: "Number of goroutines.", Value: float64(runtime.NumGoroutine())},
+		{Name: metricNamespace + "/go-build-cache-bytes", Help: "Size of the Go build cache when last checked for cleanup.", Value: float64(goBuildCacheSize.Load())},
+		{Name: metricNamespace + "/go-mod-cache-bytes", Help: "Size of the Go module cache when last checked for cleanup.", Value: float64(goModCacheSize.Load())},
+	}
+	// Only the in-memory queue knows its depth cheaply.
+	if q, ok := s.queue.(interface{ Depth() int }); ok {
+		gs = append(gs, observe.Gauge{Name: metricNamespace + "/queue-depth", Help: "Number of tasks waiting in the queue.", Value: float64(q.Depth())})
+	}
+	// The container's memory, which includes the memory of the scanning
+	// subprocesses, is only available on Cloud Run.
+	if config.OnCloudRun() {
+		if cur, max, err := cgroupMemory(); err == nil {
+			gs = append(gs,
+				observe.Gauge{Name: metricNamespace + "/container-memory-bytes", Help: "Memory used by the container.", Value: float64(cur)},
+				observe.Gauge{Name: metricNamespace + "/container-memory-limit-bytes", Help: "Memory limit of the container.", Value: float64(max)})
+		}
+	}
+	return gs
+}
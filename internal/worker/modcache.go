@@ -0,0 +1,317 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Snapshots of the module cache.
+//
+// Some analyzers load the source of all of a module's dependencies, but the
+// shared module cache can lose it: the caches are cleaned after scans. When
+// a scan asks for a dependency snapshot, the module's dependency closure is
+// linked out of the module cache into a directory of its own, which the
+// sandbox mounts read-only. Before the binary runs, the go command must load
+// the module's packages from the snapshot exactly as it does from the module
+// cache, without network access.
+
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/log"
+)
+
+// modCacheSnapshotsDir is the directory holding module cache snapshots.
+// The sandbox mounts it read-only to the same path internally.
+const modCacheSnapshotsDir = "/tmp/modcache-snapshots"
+
+// modCacheSnapshotDir returns the path of the module cache snapshot for
+// the scan of modulePath@version.
+func modCacheSnapshotDir(modulePath, version string) string {
+	return filepath.Join(modCacheSnapshotsDir, modulePath+"@"+version)
+}
+
+// snapshotModCache writes a snapshot of the module cache holding the
+// dependency closure of the module in dir to snapDir, and checks that the
+// module's packages load from the snapshot as they do from the module cache.
+// The module must have been prepared by prepareModule. The go commands run
+// outside the sandbox, so goflags must be built from flags checked by
+// analysis.CanonicalGoFlags.
+func snapshotModCache(ctx context.Context, dir, snapDir string, insecure bool, goflags string) (err error) {
+	defer derrors.Wrap(&err, "snapshotModCache(%q, %q)", dir, snapDir)
+
+	env := []string{"GOPROXY=off"}
+	if !insecure {
+		env = append(env, "GOMODCACHE="+filepath.Join(sandboxRoot, sandboxGoModCache))
+	}
+	if goflags != "" {
+		env = append(env, "GOFLAGS="+goflags)
+	}
+	out, err := goOutput(dir, env, "env", "GOMODCACHE")
+	if err != nil {
+		return err
+	}
+	cacheDir := strings.TrimSpace(string(out))
+	wantErrs, mods, err := listPackages(dir, env)
+	if err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(snapDir); err != nil {
+		return err
+	}
+	// The go command reads the go.mod file of every module version in the
+	// module graph, whether or not it is selected. Graph pruning means some
+	// of them are never downloaded.
+	graph, err := goOutput(dir, env, "mod", "graph")
+	if err != nil {
+		return err
+	}
+	mvs, err := parseModGraph(graph)
+	if err != nil {
+		return err
+	}
+	for _, mv := range mvs {
+		base, err := downloadBase(mv)
+		if err != nil {
+			return err
+		}
+		for _, ext := range []string{".mod", ".info"} {
+			err := linkTree(filepath.Join(cacheDir, base+ext), filepath.Join(snapDir, base+ext))
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	// The modules providing packages also need their source.
+	nsrc := 0
+	for _, m := range mods {
+		if m.Main {
+			continue
+		}
+		if m.Replace != nil {
+			if m.Replace.Version == "" {
+				// Replaced by a directory outside the module cache.
+				continue
+			}
+			m = m.Replace
+		}
+		if m.Dir == "" {
+			// Not in the module cache; the check below reports it.
+			continue
+		}
+		rel, err := filepath.Rel(cacheDir, m.Dir)
+		if err != nil {
+			return err
+		}
+		if err := linkTree(m.Dir, filepath.Join(snapDir, rel)); err != nil {
+			return err
+		}
+		// The go command checks extracted source against the zip's hash.
+		base, err := downloadBase(module.Version{Path: m.Path, Version: m.Version})
+		if err != nil {
+			return err
+		}
+		err = linkTree(filepath.Join(cacheDir, base+".ziphash"), filepath.Join(snapDir, base+".ziphash"))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		nsrc++
+	}
+
+	if err := checkModCacheSnapshot(dir, env, snapDir, strings.ReplaceAll(wantErrs, cacheDir, snapDir)); err != nil {
+		return err
+	}
+	log.Debugf(ctx, "module cache snapshot of %d module versions, %d with source, in %s", len(mvs), nsrc, snapDir)
+	return nil
+}
+
+// checkModCacheSnapshot checks that the packages of the module in dir load
+// from the module cache snapshot in snapDir with errors wantErrs, as
+// returned by listPackages. The go command runs with env added to the
+// environment.
+func checkModCacheSnapshot(dir string, env []string, snapDir, wantErrs string) error {
+	snapEnv := append(env[:len(env):len(env)], "GOMODCACHE="+snapDir)
+	gotErrs, _, err := listPackages(dir, snapEnv)
+	if err != nil {
+		return fmt.Errorf("module cache snapshot is incomplete: %w", err)
+	}
+	if gotErrs != wantErrs {
+		return fmt.Errorf("module cache snapshot is incomplete: loading packages reports\n%s\ninstead of\n%s", gotErrs, wantErrs)
+	}
+	return nil
+}
+
+// goOutput runs `go args...` in dir, with env added to the environment,
+// and returns its standard output.
+func goOutput(dir string, env []string, args ...string) ([]byte, error) {
+	cmd := exec.Command("go", args...)
+	cmd.Dir = dir
+	cmd.Env = append(cmd.Environ(), env...)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("'go %s': %s", strings.Join(args, " "), derrors.IncludeStderr(err))
+	}
+	return out, nil
+}
+
+// listPackages loads the packages of the module in dir, their dependencies
+// and tests. It returns the errors loading them, one per line, and the
+// modules that provide them.
+func listPackages(dir string, env []string) (errs string, mods []*listedModule, err error) {
+	// Listing only these fields avoids looking up module info, which
+	// may not be cached.
+	out, err := goOutput(dir, env, "list", "-e", "-deps", "-test", "-json=ImportPath,Error,DepsErrors,Module", "./...")
+	if err != nil {
+		return "", nil, err
+	}
+	var b strings.Builder
+	seen := map[module.Version]bool{}
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for {
+		var p struct {
+			ImportPath string
+			Error      *packageError
+			DepsErrors []*packageError
+			Module     *listedModule
+		}
+		if err := dec.Decode(&p); err == io.EOF {
+			break
+		} else if err != nil {
+			return "", nil, err
+		}
+		for _, e := range append(p.DepsErrors, p.Error) {
+			if e != nil {
+				fmt.Fprintf(&b, "%s: %s\n", p.ImportPath, e.Err)
+			}
+		}
+		if m := p.Module; m != nil && !seen[module.Version{Path: m.Path, Version: m.Version}] {
+			seen[module.Version{Path: m.Path, Version: m.Version}] = true
+			mods = append(mods, m)
+		}
+	}
+	return b.String(), mods, nil
+}
+
+// parseModGraph returns the module versions in the output of `go mod graph`,
+// sorted. The main module and the go and toolchain versions are omitted.
+func parseModGraph(graph []byte) ([]module.Version, error) {
+	seen := map[module.Version]bool{}
+	for _, line := range strings.Split(string(graph), "\n") {
+		for _, f := range strings.Fields(line) {
+			path, version, ok := strings.Cut(f, "@")
+			if !ok {
+				// The main module has no version.
+				continue
+			}
+			if path == "go" || path == "toolchain" {
+				continue
+			}
+			if path == "" || version == "" {
+				return nil, fmt.Errorf("bad module version %q in module graph", f)
+			}
+			seen[module.Version{Path: path, Version: version}] = true
+		}
+	}
+	var mvs []module.Version
+	for mv := range seen {
+		mvs = append(mvs, mv)
+	}
+	sort.Slice(mvs, func(i, j int) bool {
+		if mvs[i].Path != mvs[j].Path {
+			return mvs[i].Path < mvs[j].Path
+		}
+		return mvs[i].Version < mvs[j].Version
+	})
+	return mvs, nil
+}
+
+// downloadBase returns the path of mv's files in the download cache,
+// relative to the module cache and without an extension.
+func downloadBase(mv module.Version) (string, error) {
+	path, err := module.EscapePath(mv.Path)
+	if err != nil {
+		return "", err
+	}
+	version, err := module.EscapeVersion(mv.Version)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join("cache", "download", filepath.FromSlash(path), "@v", version), nil
+}
+
+type packageError struct {
+	Err string
+}
+
+// A listedModule is a module, as described by `go list -json`.
+type listedModule struct {
+	Path    string
+	Version string
+	Main    bool
+	Dir     string // directory holding the module's source, if any
+	Replace *listedModule
+}
+
+// linkTree makes dst a copy of the file or directory tree src. Files are
+// hard links to the originals where possible, so the snapshot takes little
+// space and survives the removal of the originals.
+func linkTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			// The module cache's directories are read-only; keep ours
+			// writable so the snapshot can be removed.
+			return os.MkdirAll(target, 0o755)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		if err := os.Link(path, target); err == nil {
+			return nil
+		}
+		return copyFile(path, target)
+	})
+}
+
+// copyFile copies the regular file src to dst, with the same permissions.
+func copyFile(src, dst string) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer derrors.Cleanup(&err, out.Close)
+	_, err = io.Copy(out, in)
+	return err
+}
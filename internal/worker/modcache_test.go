@@ -0,0 +1,149 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/mod/module"
+	modzip "golang.org/x/mod/zip"
+)
+
+func TestParseModGraph(t *testing.T) {
+	graph := `example.com/m example.com/a@v1.0.0
+example.com/m go@1.21
+example.com/a@v1.0.0 example.com/B@v1.2.0
+example.com/a@v1.0.0 toolchain@go1.21.0
+example.com/m example.com/B@v1.3.0
+`
+	got, err := parseModGraph([]byte(graph))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []module.Version{
+		{Path: "example.com/B", Version: "v1.2.0"},
+		{Path: "example.com/B", Version: "v1.3.0"},
+		{Path: "example.com/a", Version: "v1.0.0"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	base, err := downloadBase(got[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.FromSlash("cache/download/example.com/!b/@v/v1.2.0"); base != want {
+		t.Errorf("got %q, want %q", base, want)
+	}
+}
+
+func TestLinkTree(t *testing.T) {
+	src := t.TempDir()
+	writeFileSize(t, filepath.Join(src, "a"), 10)
+	writeFileSize(t, filepath.Join(src, "d", "b"), 20)
+	dst := filepath.Join(t.TempDir(), "snap")
+	if err := linkTree(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	// The copy survives the removal of the original.
+	if err := os.RemoveAll(src); err != nil {
+		t.Fatal(err)
+	}
+	got, err := dirSize(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 30 {
+		t.Errorf("got size %d, want 30", got)
+	}
+}
+
+func TestSnapshotModCache(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("no go command")
+	}
+	tmp := t.TempDir()
+	modCache := filepath.Join(tmp, "modcache")
+	t.Setenv("GOMODCACHE", modCache)
+	t.Setenv("GOFLAGS", "-modcacherw")
+	t.Setenv("GOSUMDB", "off")
+	t.Setenv("GOTOOLCHAIN", "local")
+
+	// A proxy serving a single dependency.
+	proxyDir := filepath.Join(tmp, "proxy")
+	dep := module.Version{Path: "example.com/dep", Version: "v1.0.0"}
+	depSrc := filepath.Join(tmp, "depsrc")
+	writeFile(t, filepath.Join(depSrc, "go.mod"), "module example.com/dep\n\ngo 1.21\n")
+	writeFile(t, filepath.Join(depSrc, "dep.go"), "package dep\n\nconst X = 1\n")
+	vdir := filepath.Join(proxyDir, "example.com", "dep", "@v")
+	writeFile(t, filepath.Join(vdir, "list"), "v1.0.0\n")
+	writeFile(t, filepath.Join(vdir, "v1.0.0.info"), `{"Version":"v1.0.0","Time":"2023-01-01T00:00:00Z"}`)
+	writeFile(t, filepath.Join(vdir, "v1.0.0.mod"), "module example.com/dep\n\ngo 1.21\n")
+	zf, err := os.Create(filepath.Join(vdir, "v1.0.0.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := modzip.CreateFromDir(zf, dep, depSrc); err != nil {
+		t.Fatal(err)
+	}
+	if err := zf.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The module to scan.
+	mdir := filepath.Join(tmp, "m")
+	writeFile(t, filepath.Join(mdir, "go.mod"), "module example.com/m\n\ngo 1.21\n\nrequire example.com/dep v1.0.0\n")
+	writeFile(t, filepath.Join(mdir, "m.go"), "package m\n\nimport \"example.com/dep\"\n\nvar Y = dep.X\n")
+	cmd := exec.Command("go", "mod", "tidy")
+	cmd.Dir = mdir
+	cmd.Env = append(cmd.Environ(), "GOPROXY=file://"+filepath.ToSlash(proxyDir))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("go mod tidy: %v\n%s", err, out)
+	}
+
+	snap := filepath.Join(tmp, "snap")
+	if err := snapshotModCache(context.Background(), mdir, snap, true, ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{
+		"cache/download/example.com/dep/@v/v1.0.0.mod",
+		"cache/download/example.com/dep/@v/v1.0.0.ziphash",
+		"example.com/dep@v1.0.0/dep.go",
+	} {
+		if !fileExists(filepath.Join(snap, filepath.FromSlash(f))) {
+			t.Errorf("%s missing from snapshot", f)
+		}
+	}
+	// The snapshot holds only the closure, not the zip.
+	if fileExists(filepath.Join(snap, "cache/download/example.com/dep/@v/v1.0.0.zip")) {
+		t.Error("zip in snapshot")
+	}
+
+	// A snapshot missing a dependency is detected.
+	if err := os.RemoveAll(filepath.Join(snap, "example.com", "dep@v1.0.0")); err != nil {
+		t.Fatal(err)
+	}
+	err = checkModCacheSnapshot(mdir, []string{"GOPROXY=off"}, snap, "")
+	if err == nil || !strings.Contains(err.Error(), "incomplete") {
+		t.Errorf("got %v, want incomplete snapshot", err)
+	}
+}
+
+func writeFile(t *testing.T, path, contents string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
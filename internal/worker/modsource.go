@@ -0,0 +1,117 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/modules"
+	"golang.org/x/pkgsite-metrics/internal/proxy"
+	"golang.org/x/pkgsite-metrics/internal/scan"
+)
+
+// A moduleSource provides the files of the modules to scan.
+type moduleSource interface {
+	// download writes the files of module at version to dir, and returns
+	// the size of the module zip in bytes and the result of verifying it
+	// against the checksum database, as modules.Download does.
+	download(ctx context.Context, modulePath, version, dir string) (zipSize int64, verification string, err error)
+}
+
+// proxySource is a moduleSource that downloads modules from a Go module
+// proxy, and verifies them with sumDB if it is not nil.
+type proxySource struct {
+	client *proxy.Client
+	sumDB  *modules.SumDB
+	// zip, if not nil, is a zip that was already downloaded from the
+	// proxy. It is used instead of downloading its module again.
+	zip *moduleZip
+}
+
+// A moduleZip is the zip of a module version, read by r.
+type moduleZip struct {
+	modulePath, version string
+	r                   *zip.Reader
+}
+
+func (s proxySource) download(ctx context.Context, modulePath, version, dir string) (int64, string, error) {
+	if z := s.zip; z != nil && z.modulePath == modulePath && z.version == version {
+		return modules.Extract(ctx, z.r, modulePath, version, dir, s.sumDB)
+	}
+	return modules.Download(ctx, modulePath, version, dir, s.client, s.sumDB)
+}
+
+// privateCorporaBucketDir is the directory of the binary bucket that holds
+// private corpora: modules that are not on the proxy, uploaded by
+// "ejobs start -corpusdir". Each corpus is a directory USER/NAME laid out
+// like a Go module proxy, with an index of its modules in the format of a
+// corpus file.
+const privateCorporaBucketDir = "private-corpora"
+
+// privateCorpusIndex is the name of the index file of a private corpus.
+const privateCorpusIndex = "modules.txt"
+
+// privateCorpusSource is a moduleSource that reads module zips from a
+// private corpus. They are not in the checksum database, so they are never
+// verified.
+type privateCorpusSource struct {
+	dir      string // directory of the corpus, as passed to openFile
+	openFile openFileFunc
+}
+
+// newPrivateCorpusSource returns a source for the private corpus with the
+// given name, of the form USER/NAME.
+func newPrivateCorpusSource(name string, openFile openFileFunc) (*privateCorpusSource, error) {
+	if name == "" || path.Clean(name) != name || path.IsAbs(name) || strings.HasPrefix(name, "..") || strings.Count(name, "/") != 1 {
+		return nil, fmt.Errorf("%w: bad private corpus name %q: want USER/NAME", derrors.InvalidArgument, name)
+	}
+	return &privateCorpusSource{
+		dir:      path.Join(privateCorporaBucketDir, name),
+		openFile: openFile,
+	}, nil
+}
+
+func (s *privateCorpusSource) download(ctx context.Context, modulePath, version, dir string) (_ int64, _ string, err error) {
+	defer derrors.Wrap(&err, "privateCorpusSource.download(%q, %q)", modulePath, version)
+	name, err := modules.ZipFile(modulePath, version)
+	if err != nil {
+		return 0, "", fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+	}
+	rc, err := s.openFile(path.Join(s.dir, name))
+	if err != nil {
+		return 0, "", err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return 0, "", err
+	}
+	zipr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return 0, "", err
+	}
+	if err := modules.Unzip(ctx, zipr, modulePath, version, dir); err != nil {
+		return 0, "", err
+	}
+	return int64(len(data)), modules.Unverified, nil
+}
+
+// modules returns the modules of the corpus, read from its index.
+func (s *privateCorpusSource) modules() (_ []scan.ModuleSpec, err error) {
+	defer derrors.Wrap(&err, "privateCorpusSource.modules(%q)", s.dir)
+	rc, err := s.openFile(path.Join(s.dir, privateCorpusIndex))
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return scan.ParseCorpus(rc, 0)
+}
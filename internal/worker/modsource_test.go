@@ -0,0 +1,138 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/pkgsite-metrics/internal/modules"
+	"golang.org/x/pkgsite-metrics/internal/scan"
+)
+
+func TestPrivateCorpusSource(t *testing.T) {
+	ctx := context.Background()
+	bucket := t.TempDir()
+	corpus := filepath.Join(bucket, privateCorporaBucketDir, "user", "exp")
+	writeFile := func(name, contents string) {
+		t.Helper()
+		p := filepath.Join(corpus, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(privateCorpusIndex, "example.com/Private v0.0.0-private 0\n")
+
+	zipFile := filepath.Join(corpus, "example.com", "!private", "@v", "v0.0.0-private.zip")
+	if err := os.MkdirAll(filepath.Dir(zipFile), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(zipFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for name, contents := range map[string]string{
+		"go.mod":  "module example.com/Private\n",
+		"p/p.go":  "package p\n",
+		"LICENSE": "",
+	} {
+		w, err := zw.Create("example.com/Private@v0.0.0-private/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := newPrivateCorpusSource("user/exp", localOpenFileFunc(bucket))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mods, err := src.modules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []scan.ModuleSpec{{Path: "example.com/Private", Version: "v0.0.0-private"}}
+	if diff := cmp.Diff(want, mods); diff != "" {
+		t.Errorf("modules mismatch (-want, +got):\n%s", diff)
+	}
+
+	dir := t.TempDir()
+	size, verification, err := src.download(ctx, "example.com/Private", "v0.0.0-private", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verification != modules.Unverified {
+		t.Errorf("got verification %q, want %q", verification, modules.Unverified)
+	}
+	if info, err := os.Stat(zipFile); err != nil || info.Size() != size {
+		t.Errorf("got size %d, want size of %s", size, zipFile)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "p", "p.go")); err != nil {
+		t.Error(err)
+	}
+	if _, _, err := src.download(ctx, "example.com/missing", "v1.0.0", t.TempDir()); err == nil {
+		t.Error("missing module: got nil, want error")
+	}
+}
+
+func TestProxySourceDownloadedZip(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("example.com/m@v1.0.0/go.mod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("module example.com/m\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zipr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// There is no proxy, so the module must come from the zip.
+	src := proxySource{zip: &moduleZip{modulePath: "example.com/m", version: "v1.0.0", r: zipr}}
+	dir := t.TempDir()
+	if _, _, err := src.download(context.Background(), "example.com/m", "v1.0.0", dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "go.mod")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNewPrivateCorpusSource(t *testing.T) {
+	for _, name := range []string{"", "user", "user/exp/more", "../exp", "/user/exp", "user/../exp", "user/"} {
+		if _, err := newPrivateCorpusSource(name, nil); err == nil {
+			t.Errorf("%q: got nil, want error", name)
+		}
+	}
+	src, err := newPrivateCorpusSource("user/exp", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := privateCorporaBucketDir + "/user/exp"; src.dir != want {
+		t.Errorf("got dir %q, want %q", src.dir, want)
+	}
+}
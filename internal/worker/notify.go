@@ -0,0 +1,131 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/jobs"
+	"golang.org/x/pkgsite-metrics/internal/log"
+)
+
+// mailtoPrefix begins notification targets that are email addresses.
+const mailtoPrefix = "mailto:"
+
+// notifyClient sends notifications. Its timeout keeps a slow webhook from
+// holding up the task that finalized the job. Tests replace it.
+var notifyClient = &http.Client{Timeout: 10 * time.Second}
+
+// A notification is the JSON body of the request sent when a job with a
+// notification target is finalized.
+type notification struct {
+	JobID   string `json:"job_id"`
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	// To is the email address, in requests to the email provider.
+	To      string        `json:"to,omitempty"`
+	Summary *jobs.Summary `json:"summary"`
+}
+
+// checkNotify checks a notification target: the https URL of a webhook, or
+// an email address after "mailto:", which requires an email provider at
+// emailURL.
+func checkNotify(target, emailURL string) error {
+	if addr, ok := strings.CutPrefix(target, mailtoPrefix); ok {
+		if emailURL == "" {
+			return errors.New("notify: email is not configured on this worker")
+		}
+		if _, err := mail.ParseAddress(addr); err != nil {
+			return fmt.Errorf("notify: %v", err)
+		}
+		return nil
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return fmt.Errorf("notify: %v", err)
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("notify: %q is neither an https URL nor a mailto: address", target)
+	}
+	return nil
+}
+
+// notifyEmailURL returns the URL of the email provider, or "" if there is none.
+func (s *Server) notifyEmailURL() string {
+	if s.cfg == nil {
+		return ""
+	}
+	return s.cfg.NotifyEmailURL
+}
+
+// notifyJob sends the summary of the finalized job to its notification
+// target, if it has one: it POSTs a notification to the webhook, or to the
+// email provider with the address in To. The job is finalized whether or
+// not the notification is sent, so failures are only logged.
+func (s *Server) notifyJob(ctx context.Context, job *jobs.Job, sum *jobs.Summary) {
+	if job.Notify == "" {
+		return
+	}
+	n := &notification{
+		JobID:   job.ID(),
+		Subject: fmt.Sprintf("job %s finished", job.ID()),
+		Text: fmt.Sprintf("%d of %d tasks succeeded, %d errored, %d failed, %d skipped",
+			sum.NumSucceeded, sum.NumEnqueued, sum.NumErrored, sum.NumFailed, sum.NumSkipped),
+		Summary: sum,
+	}
+	if job.StaleReason != "" {
+		n.Subject = fmt.Sprintf("job %s is stale", job.ID())
+		n.Text += "; stale: " + job.StaleReason
+	}
+	target := job.Notify
+	if addr, ok := strings.CutPrefix(job.Notify, mailtoPrefix); ok {
+		target = s.notifyEmailURL()
+		if target == "" {
+			log.Warnf(ctx, "not notifying %s of job %s: email is not configured", job.Notify, job.ID())
+			return
+		}
+		n.To = addr
+	}
+	if err := postNotification(ctx, target, n); err != nil {
+		log.Errorf(ctx, err, "notifying %s of job %s", job.Notify, job.ID())
+		return
+	}
+	log.Infof(ctx, "notified %s of job %s", job.Notify, job.ID())
+}
+
+// postNotification POSTs n as JSON to url.
+func postNotification(ctx context.Context, url string, n *notification) (err error) {
+	defer derrors.Wrap(&err, "postNotification")
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := notifyClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("%s: %s", res.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/config"
+	"golang.org/x/pkgsite-metrics/internal/jobs"
+)
+
+func TestCheckNotify(t *testing.T) {
+	for _, test := range []struct {
+		target, emailURL string
+		wantErr          bool
+	}{
+		{"https://hooks.example.com/x", "", false},
+		{"http://hooks.example.com/x", "", true},
+		{"https:///x", "", true},
+		{"mailto:a@example.com", "https://mail.example.com", false},
+		{"mailto:a@example.com", "", true},
+		{"mailto:nobody", "https://mail.example.com", true},
+		{"a@example.com", "https://mail.example.com", true},
+	} {
+		err := checkNotify(test.target, test.emailURL)
+		if (err != nil) != test.wantErr {
+			t.Errorf("checkNotify(%q, %q): got %v, want error: %t", test.target, test.emailURL, err, test.wantErr)
+		}
+	}
+}
+
+func TestNotifyJob(t *testing.T) {
+	var got []*notification
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n notification
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			t.Error(err)
+		}
+		got = append(got, &n)
+	}))
+	defer srv.Close()
+	defer func(c *http.Client) { notifyClient = c }(notifyClient)
+	notifyClient = srv.Client()
+
+	ctx := context.Background()
+	job := jobs.NewJob("user", time.Date(2023, 3, 11, 1, 2, 3, 0, time.UTC), "url", "bin", "<hash>", "args")
+	job.NumEnqueued = 2
+	job.NumSucceeded = 2
+	sum := jobs.NewSummary(job, time.Now())
+	s := &Server{cfg: &config.Config{NotifyEmailURL: srv.URL + "/email"}}
+
+	job.Notify = srv.URL + "/hook"
+	s.notifyJob(ctx, job, sum)
+	job.Notify = "mailto:user@example.com"
+	s.notifyJob(ctx, job, sum)
+	job.Notify = ""
+	s.notifyJob(ctx, job, sum)
+
+	if len(got) != 2 {
+		t.Fatalf("got %d notifications, want 2", len(got))
+	}
+	want := "job " + job.ID() + " finished"
+	for _, n := range got {
+		if n.Subject != want || n.Summary == nil || n.Summary.NumSucceeded != 2 {
+			t.Errorf("got %+v, want subject %q and summary", n, want)
+		}
+	}
+	if got[0].To != "" || got[1].To != "user@example.com" {
+		t.Errorf("got recipients %q, %q; want none, then user@example.com", got[0].To, got[1].To)
+	}
+}
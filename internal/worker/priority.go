@@ -0,0 +1,108 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Scheduling of scan tasks by priority.
+//
+// Tasks are enqueued with a priority (see queue.Options.Priority), which
+// Cloud Tasks sends in queue.PriorityHeader. While high-priority tasks
+// are running on this instance, low-priority tasks wait for them, so
+// that small latency-sensitive jobs are not slowed down by bulk runs.
+
+package worker
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/event"
+	"golang.org/x/pkgsite-metrics/internal/log"
+	"golang.org/x/pkgsite-metrics/internal/queue"
+)
+
+// lowPriorityMaxWait bounds how long a low-priority task waits for
+// high-priority tasks, so that low-priority work is never starved.
+// It is well below the 30-minute deadline of Cloud Tasks, so that a task
+// that waited still has most of that time to run.
+const lowPriorityMaxWait = 2 * time.Minute
+
+// delayedLowPriorityCounter counts low-priority tasks that waited for
+// high-priority tasks.
+var delayedLowPriorityCounter = event.NewCounter("low-priority-delayed", &event.MetricOptions{Namespace: metricNamespace})
+
+// A priorityLimiter holds back low-priority tasks while high-priority
+// tasks are running.
+type priorityLimiter struct {
+	mu   sync.Mutex
+	high int           // number of high-priority tasks running
+	idle chan struct{} // closed when high drops to zero
+}
+
+// scanPriority is the limiter for the scans of this process.
+var scanPriority = &priorityLimiter{}
+
+// startHigh records the start of a high-priority task.
+// Call the returned function when the task is done.
+func (l *priorityLimiter) startHigh() func() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.high == 0 {
+		l.idle = make(chan struct{})
+	}
+	l.high++
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.high--
+		if l.high == 0 {
+			close(l.idle)
+		}
+	}
+}
+
+// waitLow waits until no high-priority tasks are running, at most maxWait.
+// It reports whether it waited at all.
+func (l *priorityLimiter) waitLow(ctx context.Context, maxWait time.Duration) (bool, error) {
+	l.mu.Lock()
+	high, idle := l.high, l.idle
+	l.mu.Unlock()
+	if high == 0 {
+		return false, nil
+	}
+	timer := time.NewTimer(maxWait)
+	defer timer.Stop()
+	select {
+	case <-idle:
+	case <-timer.C:
+	case <-ctx.Done():
+		return true, ctx.Err()
+	}
+	return true, nil
+}
+
+// priorityHandler wraps a scan handler so that low-priority scans wait
+// while high-priority ones are running. It must wrap the handler that
+// limits concurrent scans, so that a waiting scan does not hold a slot
+// that a high-priority scan could use.
+func priorityHandler(l *priorityLimiter, h func(http.ResponseWriter, *http.Request) error) func(http.ResponseWriter, *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		ctx := r.Context()
+		switch r.Header.Get(queue.PriorityHeader) {
+		case queue.PriorityHigh:
+			defer l.startHigh()()
+		case queue.PriorityLow:
+			start := time.Now()
+			waited, err := l.waitLow(ctx, lowPriorityMaxWait)
+			if err != nil {
+				return err
+			}
+			if waited {
+				log.Infof(ctx, "low-priority task %s waited %s for high-priority tasks", r.URL.Path, time.Since(start).Round(time.Second))
+				delayedLowPriorityCounter.Record(ctx, 1)
+			}
+		}
+		return h(w, r)
+	}
+}
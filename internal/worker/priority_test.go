@@ -0,0 +1,91 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/queue"
+)
+
+func TestPriorityLimiter(t *testing.T) {
+	ctx := context.Background()
+	var l priorityLimiter
+
+	// With no high-priority tasks, low-priority ones don't wait.
+	if waited, err := l.waitLow(ctx, time.Hour); err != nil || waited {
+		t.Fatalf("got (%t, %v), want (false, nil)", waited, err)
+	}
+
+	done1 := l.startHigh()
+	done2 := l.startHigh()
+
+	// The wait is bounded.
+	if waited, err := l.waitLow(ctx, time.Millisecond); err != nil || !waited {
+		t.Fatalf("got (%t, %v), want (true, nil)", waited, err)
+	}
+
+	// A low-priority task proceeds when all high-priority tasks are done.
+	errc := make(chan error, 1)
+	go func() {
+		_, err := l.waitLow(ctx, time.Hour)
+		errc <- err
+	}()
+	done1()
+	select {
+	case <-errc:
+		t.Fatal("waitLow returned while a high-priority task was running")
+	case <-time.After(10 * time.Millisecond):
+	}
+	done2()
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	// Canceling the context stops the wait.
+	defer l.startHigh()()
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := l.waitLow(cctx, time.Hour); err == nil {
+		t.Error("got nil error from canceled wait")
+	}
+}
+
+func TestPriorityHandlerWaitsWithoutSlot(t *testing.T) {
+	var l priorityLimiter
+	sl := newScanLimiter("analysis", 1)
+	h := priorityHandler(&l, limitHandler(sl, func(http.ResponseWriter, *http.Request) error {
+		return nil
+	}))
+
+	done := l.startHigh()
+	errc := make(chan error, 1)
+	go func() {
+		r := httptest.NewRequest("GET", "/analysis/scan/m@v1.0.0", nil)
+		r.Header.Set(queue.PriorityHeader, queue.PriorityLow)
+		errc <- h(httptest.NewRecorder(), r)
+	}()
+	select {
+	case err := <-errc:
+		t.Fatalf("low-priority scan ran while a high-priority one was running: %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// The waiting scan does not hold the only slot.
+	release, ok := sl.tryStart()
+	if !ok {
+		t.Fatal("waiting low-priority scan holds a slot")
+	}
+	release()
+
+	done()
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Progress of the scans running on this worker instance.
+//
+// Scans report the phase they are in as they go, and the sandbox programs
+// write periodic progress events (see govulncheck.Progress). The latest
+// event for each running scan is logged and served by jobs/tasks.
+
+package worker
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+	"golang.org/x/pkgsite-metrics/internal/log"
+)
+
+// stallTimeout is how long a scan can go without a progress event
+// before it is considered stalled. Scans in the scan phase report
+// every govulncheck.ProgressInterval. Scans that never report progress
+// periodically, like those running analysis binaries, cannot stall.
+const stallTimeout = 5 * time.Minute
+
+// A TaskProgress describes a scan running on this instance.
+type TaskProgress struct {
+	Task    string // module@version, and the kind of scan
+	Started time.Time
+	// The most recent progress event.
+	govulncheck.Progress
+	Updated time.Time // when the most recent event was received
+	// Periodic reports whether the scan reports progress periodically.
+	Periodic bool
+	// Stalled reports whether the scan has not made progress for a while,
+	// and so may be stuck. Only scans that report progress periodically
+	// can stall.
+	Stalled bool
+}
+
+// A progressTracker tracks the progress of running scans.
+type progressTracker struct {
+	mu    sync.Mutex
+	tasks map[*TaskProgress]bool
+}
+
+// runningTasks tracks the scans running in this process.
+var runningTasks = &progressTracker{tasks: map[*TaskProgress]bool{}}
+
+type progressKey struct{}
+
+// start starts tracking the progress of a scan described by task.
+// If periodic is true, the scan reports progress periodically, and is
+// stalled when it stops doing so.
+// The returned context carries the scan's progress for reportProgress.
+// Call the returned function when the scan is done.
+func (t *progressTracker) start(ctx context.Context, task string, periodic bool) (context.Context, func()) {
+	now := time.Now()
+	tp := &TaskProgress{Task: task, Started: now, Updated: now, Periodic: periodic}
+	t.mu.Lock()
+	t.tasks[tp] = true
+	t.mu.Unlock()
+	return context.WithValue(ctx, progressKey{}, tp), func() {
+		t.mu.Lock()
+		delete(t.tasks, tp)
+		t.mu.Unlock()
+	}
+}
+
+// list returns the running scans, oldest first.
+func (t *progressTracker) list(now time.Time) []*TaskProgress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var tps []*TaskProgress
+	for tp := range t.tasks {
+		c := *tp
+		c.Stalled = c.Periodic && now.Sub(c.Updated) > stallTimeout
+		tps = append(tps, &c)
+	}
+	sort.Slice(tps, func(i, j int) bool { return tps[i].Started.Before(tps[j].Started) })
+	return tps
+}
+
+// reportProgress records p for the scan whose progress is in ctx, if any,
+// and logs it.
+func reportProgress(ctx context.Context, p govulncheck.Progress) {
+	log.Infof(ctx, "progress: phase %s, %.0fs elapsed, RSS %dKB", p.Phase, p.Elapsed, p.RSS)
+	tp, ok := ctx.Value(progressKey{}).(*TaskProgress)
+	if !ok {
+		return
+	}
+	runningTasks.mu.Lock()
+	defer runningTasks.mu.Unlock()
+	tp.Progress = p
+	tp.Updated = time.Now()
+}
+
+// reportPhase records the start of a phase of the scan whose progress is
+// in ctx.
+func reportPhase(ctx context.Context, phase string) {
+	reportProgress(ctx, govulncheck.Progress{Phase: phase})
+}
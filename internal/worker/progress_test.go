@@ -0,0 +1,53 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+)
+
+func TestProgressTracker(t *testing.T) {
+	ctx := context.Background()
+	// reportProgress without a tracked scan does nothing.
+	reportPhase(ctx, govulncheck.PhaseDownload)
+
+	ctx1, done1 := runningTasks.start(ctx, "a@v1", true)
+	ctx2, done2 := runningTasks.start(ctx, "b@v1", true)
+	defer done2()
+	// Scans that do not report progress periodically never stall.
+	_, done3 := runningTasks.start(ctx, "c@v1", false)
+	reportPhase(ctx1, govulncheck.PhaseBuild)
+	reportProgress(ctx2, govulncheck.Progress{Phase: govulncheck.PhaseScan, Elapsed: 30, RSS: 100})
+
+	got := runningTasks.list(time.Now())
+	if len(got) != 3 {
+		t.Fatalf("got %d tasks, want 3", len(got))
+	}
+	if got[0].Task != "a@v1" || got[0].Phase != govulncheck.PhaseBuild {
+		t.Errorf("got %+v, want a@v1 in build phase", got[0])
+	}
+	if got[1].Task != "b@v1" || got[1].Phase != govulncheck.PhaseScan || got[1].RSS != 100 || got[1].Stalled {
+		t.Errorf("got %+v, want b@v1 in scan phase", got[1])
+	}
+
+	// Without further progress, scans eventually stall.
+	got = runningTasks.list(time.Now().Add(stallTimeout + time.Second))
+	for _, tp := range got {
+		if tp.Stalled != tp.Periodic {
+			t.Errorf("%s: got stalled %t, want %t", tp.Task, tp.Stalled, tp.Periodic)
+		}
+	}
+
+	done1()
+	done3()
+	got = runningTasks.list(time.Now())
+	if len(got) != 1 || got[0].Task != "b@v1" {
+		t.Errorf("after done, got %+v, want only b@v1", got)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+	"golang.org/x/pkgsite-metrics/internal/log"
+	"golang.org/x/pkgsite-metrics/internal/scan"
+)
+
+// The worker keeps results in BigQuery, and the work states that let it
+// skip modules it has already scanned in Firestore. (It keeps no results
+// in GCS.) The retention policy, cfg.Retention, says how long rows of each
+// table are kept. Work states are deleted along with the govulncheck
+// rows, so that modules whose rows are gone are scanned again.
+
+// retentionParams are the query params of /retention.
+type retentionParams struct {
+	DryRun bool // report what would be deleted, but do not delete it
+}
+
+// A retentionReport describes the rows of a table that the retention
+// policy deleted, or would delete in a dry run.
+type retentionReport struct {
+	Table  string    `json:"table"`
+	Cutoff time.Time `json:"cutoff"` // rows uploaded before this are deleted
+	DryRun bool      `json:"dry_run"`
+	Rows   int64     `json:"rows"`
+	// WorkStates is the number of govulncheck work states deleted with
+	// the rows of the govulncheck table.
+	WorkStates int `json:"work_states,omitempty"`
+}
+
+// handleRetention deletes the results older than their retention period,
+// and serves a JSON list of retentionReports.
+func (s *Server) handleRetention(w http.ResponseWriter, r *http.Request) (err error) {
+	defer derrors.Wrap(&err, "handleRetention")
+
+	ctx := r.Context()
+	var params retentionParams
+	if err := scan.ParseParams(r, &params); err != nil {
+		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+	}
+	if s.bqClient == nil {
+		return errors.New("BigQuery is disabled")
+	}
+	reports := retentionPlan(s.cfg.Retention, time.Now(), params.DryRun)
+	for _, rep := range reports {
+		if rep.DryRun {
+			rep.Rows, err = s.bqClient.CountRowsBefore(ctx, rep.Table, rep.Cutoff)
+		} else {
+			rep.Rows, err = s.bqClient.DeleteRowsBefore(ctx, rep.Table, rep.Cutoff)
+		}
+		if err != nil {
+			return err
+		}
+		if rep.Table == govulncheck.TableName {
+			rep.WorkStates, err = govulncheck.DeleteWorkStates(ctx, s.fsNamespace, &govulncheck.WorkStateFilter{Before: rep.Cutoff}, rep.DryRun)
+			if err != nil {
+				return err
+			}
+		}
+		log.Infof(ctx, "retention: table %s, cutoff %s, dry run %t: %d rows, %d work states",
+			rep.Table, rep.Cutoff.Format(time.RFC3339), rep.DryRun, rep.Rows, rep.WorkStates)
+	}
+	return writeJSON(w, reports)
+}
+
+// retentionPlan returns a report, sorted by table, for each table in
+// retention, with the cutoff for its rows as of now.
+func retentionPlan(retention map[string]time.Duration, now time.Time, dryRun bool) []*retentionReport {
+	var reports []*retentionReport
+	for table, d := range retention {
+		reports = append(reports, &retentionReport{
+			Table:  table,
+			Cutoff: now.Add(-d).UTC(),
+			DryRun: dryRun,
+		})
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Table < reports[j].Table })
+	return reports
+}
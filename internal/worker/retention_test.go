@@ -0,0 +1,32 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRetentionPlan(t *testing.T) {
+	const day = 24 * time.Hour
+	now := time.Date(2023, 10, 15, 12, 0, 0, 0, time.UTC)
+	got := retentionPlan(map[string]time.Duration{
+		"govulncheck": 365 * day,
+		"analysis":    30 * day,
+	}, now, true)
+	want := []*retentionReport{
+		{Table: "analysis", Cutoff: time.Date(2023, 9, 15, 12, 0, 0, 0, time.UTC), DryRun: true},
+		{Table: "govulncheck", Cutoff: time.Date(2022, 10, 15, 12, 0, 0, 0, time.UTC), DryRun: true},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	if got := retentionPlan(nil, now, false); len(got) != 0 {
+		t.Errorf("got %d reports for no policy, want 0", len(got))
+	}
+}
@@ -13,7 +13,9 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"reflect"
 	"regexp"
 	"runtime/debug"
 	"strconv"
@@ -21,12 +23,13 @@ import (
 	"sync/atomic"
 
 	"cloud.google.com/go/storage"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/sumdb/dirhash"
 	"golang.org/x/pkgsite-metrics/internal/bigquery"
 	"golang.org/x/pkgsite-metrics/internal/config"
 	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
 	"golang.org/x/pkgsite-metrics/internal/log"
-	"golang.org/x/pkgsite-metrics/internal/modules"
-	"golang.org/x/pkgsite-metrics/internal/proxy"
 )
 
 const (
@@ -44,7 +47,22 @@ const (
 
 var activeScans atomic.Int32
 
-func doScan(ctx context.Context, modulePath, version string, insecure bool, f func() error) (err error) {
+// scanLimits bounds the resources used by scans.
+type scanLimits struct {
+	// The sizes in bytes above which the Go build and module caches are
+	// cleaned when no scans are running. If zero, the cache is always
+	// cleaned.
+	goBuildCache, goModCache int64
+	// The number of bytes of disk space that a scan may use.
+	// If zero, there is no limit.
+	diskQuota int64
+}
+
+// doScan runs f to scan the module, whose directory is moduleDir(modulePath, version).
+// The context passed to f carries the scan's disk quota, which is checked after
+// the module is downloaded and prepared, periodically while f runs, and after
+// f returns. A periodic check that fails cancels the context passed to f.
+func doScan(ctx context.Context, limits scanLimits, modulePath, version string, insecure bool, f func(context.Context) error) (err error) {
 	defer derrors.Wrap(&err, "doScan(%q, %q)", modulePath, version)
 
 	defer func() {
@@ -56,66 +74,69 @@ func doScan(ctx context.Context, modulePath, version string, insecure bool, f fu
 	logMemory(ctx, fmt.Sprintf("before scanning %s@%s", modulePath, version))
 	defer logMemory(ctx, fmt.Sprintf("after scanning %s@%s", modulePath, version))
 
-	activeScans.Add(1)
-	defer func() {
-		if activeScans.Add(-1) == 0 {
-			logMemory(ctx, fmt.Sprintf("before 'go clean' for %s@%s", modulePath, version))
-			cleanGoCaches(ctx, insecure)
-			logMemory(ctx, "after 'go clean'")
+	mdir := moduleDir(modulePath, version)
+	if limits.diskQuota > 0 {
+		if err := makeRoom(ctx, modulesDir, limits.diskQuota); err != nil {
+			log.Errorf(ctx, err, "making room for %s@%s", modulePath, version)
 		}
-	}()
-	return f()
-}
-
-func cleanGoCaches(ctx context.Context, insecure bool) {
-	var (
-		out []byte
-		err error
-	)
-
-	logDiskUsage := func(msg string) {
-		log.Debugf(ctx, "sandbox disk usage %s clean:\n%s",
-			msg, diskUsage(filepath.Join(sandboxRoot, "root"), modulesDir))
+	}
+	defer useScanDir(mdir)()
+	var cacheDirs []string
+	if !insecure {
+		cacheDirs = []string{filepath.Join(sandboxRoot, sandboxGoCache), filepath.Join(sandboxRoot, sandboxGoModCache)}
+	}
+	quota, err := newDiskQuota(limits.diskQuota, mdir, cacheDirs...)
+	if err != nil {
+		return err
 	}
 
-	if insecure {
-		if !config.OnCloudRun() {
-			// Avoid cleaning the developer's local caches.
-			log.Infof(ctx, "not on Cloud Run, so not cleaning caches")
-			return
-		}
-		out, err = exec.Command("go", "clean", "-cache", "-modcache").CombinedOutput()
-	} else {
-		logDiskUsage("before")
-		// We need to clear Go caches after a scan to avoid memory issues. The caches
-		// are created and populated outside of the sandbox. We cannot clear them from
-		// within the sandbox since "any modifications to the root filesystem are destroyed
-		// with the container" (https://gvisor.dev/docs/user_guide/filesystem/). We hence
-		// also clean the caches from the outside.
-		c := exec.Command("go", "clean", "-cache", "-modcache")
-		c.Env = append(os.Environ(), "GOCACHE=/bundle/rootfs/"+sandboxGoCache, "GOMODCACHE=/bundle/rootfs/"+sandboxGoModCache)
-		out, err = c.CombinedOutput()
-		if err == nil {
-			logDiskUsage("after")
+	if !insecure || config.OnCloudRun() {
+		if _, modDir, err := goCacheDirs(insecure); err == nil {
+			hit := modCacheHasZip(modDir, modulePath, version)
+			defer recordModCacheUse(ctx, hit)
 		}
 	}
 
-	output := ""
-	if len(out) > 0 {
-		output = fmt.Sprintf(" with output %s", out)
+	activeScans.Add(1)
+	defer func() {
+		if activeScans.Add(-1) == 0 {
+			logMemory(ctx, fmt.Sprintf("before cleaning caches for %s@%s", modulePath, version))
+			cleanGoCaches(ctx, insecure, limits)
+			logMemory(ctx, "after cleaning caches")
+		}
+	}()
+	fctx, stop := quota.watch(context.WithValue(ctx, diskQuotaKey{}, quota))
+	err = f(fctx)
+	stop()
+	if cause := context.Cause(fctx); errors.Is(cause, derrors.ScanModuleDiskLimitExceeded) {
+		// f may have failed only because its context was canceled.
+		return cause
 	}
 	if err != nil {
-		log.Errorf(ctx, errors.New(derrors.IncludeStderr(err)), "'go clean' failed%s", output)
-	} else {
-		log.Infof(ctx, "'go clean' succeeded%s", output)
+		return err
 	}
+	// The module directory may be gone, but the caches have
+	// the scan's build artifacts.
+	return quota.check(ctx)
 }
 
 func logMemory(ctx context.Context, prefix string) {
 	if !config.OnCloudRun() {
 		return
 	}
+	cur, max, err := cgroupMemory()
+	if err != nil {
+		log.Errorf(ctx, err, "reading memory usage")
+	}
+
+	const G float64 = 1024 * 1024 * 1024
+
+	log.Infof(ctx, "%s: using %.1fG out of %.1fG", prefix, float64(cur)/G, float64(max)/G)
+}
 
+// cgroupMemory returns the memory usage and limit of the container, in bytes,
+// from the cgroup files available on Cloud Run.
+func cgroupMemory() (cur, max int, err error) {
 	readIntFile := func(filename string) (int, error) {
 		data, err := os.ReadFile(filename)
 		if err != nil {
@@ -129,39 +150,28 @@ func logMemory(ctx context.Context, prefix string) {
 		maxFilename = "/sys/fs/cgroup/memory/memory.limit_in_bytes"
 	)
 
-	cur, err := readIntFile(curFilename)
-	if err != nil {
-		log.Errorf(ctx, err, "reading %s", curFilename)
-	}
-	max, err := readIntFile(maxFilename)
-	if err != nil {
-		log.Errorf(ctx, err, "reading %s", maxFilename)
-	}
-
-	const G float64 = 1024 * 1024 * 1024
-
-	log.Infof(ctx, "%s: using %.1fG out of %.1fG", prefix, float64(cur)/G, float64(max)/G)
-}
-
-// diskUsage runs the du command to determine how much disk space the given
-// directories occupy.
-func diskUsage(dirs ...string) string {
-	out, err := exec.Command("du", append([]string{"-h", "-s"}, dirs...)...).Output()
-	if err != nil {
-		return fmt.Sprintf("ERROR: %s", derrors.IncludeStderr(err))
-	}
-	return strings.TrimSpace(string(out))
+	cur, err1 := readIntFile(curFilename)
+	max, err2 := readIntFile(maxFilename)
+	return cur, max, errors.Join(err1, err2)
 }
 
-func writeResult(ctx context.Context, serve bool, w http.ResponseWriter, client *bigquery.Client, table string, row bigquery.Row) (err error) {
+// writeResult writes row to the table using client. If client is nil, the row
+// is written to local, if that is not nil.
+func writeResult(ctx context.Context, serve bool, w http.ResponseWriter, client bigquery.DB, local *localResultsDir, table string, row bigquery.Row) (err error) {
 	defer derrors.Wrap(&err, "writeResult")
 
 	if serve {
 		// Write the result to the client instead of uploading to BigQuery.
 		return serveJSON(ctx, row, w)
 	}
+	if len(validateRows(ctx, table, []bigquery.Row{row})) == 0 {
+		return nil
+	}
 	// Upload to BigQuery.
 	if client == nil {
+		if local != nil {
+			return local.write(table, row)
+		}
 		log.Infof(ctx, "bigquery disabled, not uploading")
 		return nil
 	}
@@ -169,32 +179,72 @@ func writeResult(ctx context.Context, serve bool, w http.ResponseWriter, client
 }
 
 // writeResults is like writeResult but stores multiple rows in a single transaction.
-func writeResults(ctx context.Context, serve bool, w http.ResponseWriter, client *bigquery.Client, table string, rows []bigquery.Row) (err error) {
+func writeResults(ctx context.Context, serve bool, w http.ResponseWriter, client bigquery.DB, local *localResultsDir, table string, rows []bigquery.Row) (err error) {
 	defer derrors.Wrap(&err, "writeResults")
 
 	if serve {
 		// Write the results to the client instead of uploading to BigQuery.
 		return serveJSON(ctx, rows, w)
 	}
+	rows = validateRows(ctx, table, rows)
+	if len(rows) == 0 {
+		return nil
+	}
 	// Upload to BigQuery.
 	if client == nil {
+		if local != nil {
+			return local.write(table, rows...)
+		}
 		log.Infof(ctx, "bigquery disabled, not uploading")
 		return nil
 	}
 	return bigquery.UploadMany(ctx, client, table, rows, 0)
 }
 
+// serveJSON writes content to the client as indented JSON, compressed
+// if the client accepts it. A slice, like the rows of a compare-mode scan,
+// is streamed one element at a time, so that it need not be held in
+// memory in its entirety.
 func serveJSON(ctx context.Context, content interface{}, w http.ResponseWriter) error {
 	log.Infof(ctx, "serving result to client")
-	data, err := json.MarshalIndent(content, "", "    ")
-	if err != nil {
-		return fmt.Errorf("marshaling result: %w", err)
+	if rw, ok := w.(*responseWriter); ok {
+		rw.compress()
 	}
-	_, err = w.Write(data)
-	if err != nil {
+	if err := writeIndentedJSON(w, content); err != nil {
 		log.Errorf(ctx, err, "writing to client")
 	}
-	return nil // No point serving an error, the write already happened.
+	return nil // No point serving an error, part of the result may have been written.
+}
+
+// writeIndentedJSON writes v to w as json.MarshalIndent(v, "", "    ")
+// would, but if v is a non-empty slice, it marshals one element at a time.
+func writeIndentedJSON(w io.Writer, v any) error {
+	const indent = "    "
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice || rv.Type().Elem().Kind() == reflect.Uint8 || rv.Len() == 0 {
+		data, err := json.MarshalIndent(v, "", indent)
+		if err != nil {
+			return fmt.Errorf("marshaling result: %w", err)
+		}
+		_, err = w.Write(data)
+		return err
+	}
+	sep := "[\n" + indent
+	for i := 0; i < rv.Len(); i++ {
+		data, err := json.MarshalIndent(rv.Index(i).Interface(), indent, indent)
+		if err != nil {
+			return fmt.Errorf("marshaling result %d: %w", i, err)
+		}
+		if _, err := io.WriteString(w, sep); err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+		sep = ",\n" + indent
+	}
+	_, err := io.WriteString(w, "\n]")
+	return err
 }
 
 type openFileFunc func(filename string) (io.ReadCloser, error)
@@ -238,17 +288,45 @@ func gcsOpenFileFunc(ctx context.Context, bucket *storage.BucketHandle) openFile
 	}
 }
 
-// prepareModule prepares a module for scanning. It downloads the module to the given
+// prepareModule prepares a module for scanning. It downloads the module from src to the given
 // directory and takes other actions that increase the chance that package loading will succeed.
 // If init is true, those other actions include calling `go mod init` and `go mod tidy` on modules
 // that don't have go.mod files.
-func prepareModule(ctx context.Context, modulePath, version, dir string, proxyClient *proxy.Client, insecure, init bool) error {
+// If subdir is not empty, the module to prepare is in that subdirectory of the download, and
+// a go.mod file it lacks is given the module path of the subdirectory.
+// It returns statistics about the module, including the directory of the prepared module.
+// They are partial if it returns an error.
+func prepareModule(ctx context.Context, modulePath, version, dir, subdir string, src moduleSource, insecure, init bool, goflags string) (stats moduleStats, err error) {
 	log.Debugf(ctx, "downloading %s@%s to %s", modulePath, version, dir)
-	if err := modules.Download(ctx, modulePath, version, dir, proxyClient); err != nil {
+	reportPhase(ctx, govulncheck.PhaseDownload)
+	stats.zipSize, stats.verification, err = src.download(ctx, modulePath, version, dir)
+	if err != nil {
 		log.Debugf(ctx, "download error: %v (%[1]T)", err)
-		return err
+		return stats, err
+	}
+	// Fail early on a module that is too large by itself.
+	if err := checkDiskQuota(ctx); err != nil {
+		return stats, err
+	}
+	// Hash the files before preparing the module adds go.mod or go.sum.
+	if h, err := dirhash.HashDir(dir, modulePath+"@"+version, dirhash.Hash1); err != nil {
+		log.Warnf(ctx, "hashing module: %v", err)
+	} else {
+		stats.moduleHash = h
 	}
 
+	// Monorepos may keep their Go module in a subdirectory.
+	name := modulePath
+	if subdir != "" {
+		dir = filepath.Join(dir, filepath.FromSlash(subdir))
+		if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+			return stats, fmt.Errorf("%w: %s@%s has no directory %s", derrors.BadModule, modulePath, version, subdir)
+		}
+		name = path.Join(modulePath, subdir)
+	}
+	stats.dir = dir
+
+	reportPhase(ctx, govulncheck.PhaseBuild)
 	hasGoMod := fileExists(filepath.Join(dir, "go.mod"))
 	if !init || hasGoMod {
 		// Download all dependencies, using the given directory for the Go module cache
@@ -256,14 +334,69 @@ func prepareModule(ctx context.Context, modulePath, version, dir string, proxyCl
 		opts := &goCommandOptions{
 			dir:      dir,
 			insecure: insecure,
+			goflags:  goflags,
+		}
+		if err := runGoCommand(ctx, modulePath, version, opts, "mod", "download"); err != nil {
+			return stats, err
+		}
+	} else {
+		// Run `go mod init`, then add the requirements.
+		if err := goModInit(ctx, modulePath, version, dir, name, insecure); err != nil {
+			return stats, err
+		}
+		stats.prepStrategy, err = addRequirements(ctx, modulePath, version, dir, insecure, goflags)
+		if err != nil {
+			return stats, err
 		}
-		return runGoCommand(ctx, modulePath, version, opts, "mod", "download")
 	}
-	// Run `go mod init` and `go mod tidy`.
-	if err := goModInit(ctx, modulePath, version, dir, modulePath, insecure); err != nil {
-		return err
+	stats.countDeps(ctx, dir)
+	return stats, checkDiskQuota(ctx)
+}
+
+// moduleStats describes a module prepared for scanning.
+type moduleStats struct {
+	// The directory of the prepared module: the download directory, or
+	// its subdirectory.
+	dir string
+	// The strategy that added the requirements of a module without a
+	// go.mod file, like prepTidy, or empty if the module had one.
+	prepStrategy string
+	zipSize      int64 // size of the module zip in bytes, or 0 if unknown
+	// The result of verifying the module zip against the checksum
+	// database, like modules.Verified, or empty if it was not downloaded.
+	verification string
+	// The hash of the module's files as downloaded, in the format of
+	// go.sum, or empty if it is not known.
+	moduleHash string
+	// The numbers of direct and indirect requirements in the go.mod file
+	// of the prepared module, if depsKnown.
+	numDirectDeps, numIndirectDeps int
+	depsKnown                      bool
+}
+
+// countDeps counts the requirements in the go.mod file of the module in dir.
+// If it cannot read the file, it logs the error and leaves the counts
+// unknown.
+func (s *moduleStats) countDeps(ctx context.Context, dir string) {
+	file := filepath.Join(dir, "go.mod")
+	data, err := os.ReadFile(file)
+	if err != nil {
+		log.Errorf(ctx, err, "counting dependencies")
+		return
+	}
+	f, err := modfile.ParseLax(file, data, nil)
+	if err != nil {
+		log.Errorf(ctx, err, "counting dependencies")
+		return
 	}
-	return goModTidy(ctx, modulePath, version, dir, insecure)
+	for _, r := range f.Require {
+		if r.Indirect {
+			s.numIndirectDeps++
+		} else {
+			s.numDirectDeps++
+		}
+	}
+	s.depsKnown = true
 }
 
 // moduleDir returns a the path of a directory where the module can be downloaded.
@@ -275,18 +408,60 @@ func goModInit(ctx context.Context, modulePath, version, dir, name string, insec
 	return runGoCommand(ctx, modulePath, version, &goCommandOptions{dir: dir, insecure: insecure}, "mod", "init", name)
 }
 
-// goModTidy runs "go mod tidy" on a module in dir.
-func goModTidy(ctx context.Context, modulePath, version, dir string, insecure bool) error {
+// The strategies for adding the requirements of a module whose go.mod file
+// was just created by `go mod init`, in the order they are tried.
+const (
+	prepTidy       = "tidy"    // go mod tidy
+	prepTidyErrors = "tidy -e" // go mod tidy -e, which skips the packages it cannot load
+	// No tidy: load the packages with -mod=mod, which downloads and
+	// adds the requirements they need, without the pruning of tidy.
+	prepModMod = "-mod=mod"
+)
+
+// prepStrategies are the go commands that implement the strategies.
+var prepStrategies = []struct {
+	name string
+	args []string
+}{
+	{prepTidy, []string{"mod", "tidy"}},
+	{prepTidyErrors, []string{"mod", "tidy", "-e"}},
+	{prepModMod, []string{"list", "-e", "-mod=mod", "-deps", "./..."}},
+}
+
+// addRequirements adds the requirements of the module in dir, whose
+// go.mod file was just created, trying each strategy until one succeeds.
+// It returns the name of that strategy. If none succeeds, it returns the
+// error of the first, which explains the failure best.
+func addRequirements(ctx context.Context, modulePath, version, dir string, insecure bool, goflags string) (string, error) {
 	opts := &goCommandOptions{
 		dir:      dir,
 		insecure: insecure,
+		goflags:  goflags,
+	}
+	var firstErr error
+	for _, s := range prepStrategies {
+		err := runGoCommand(ctx, modulePath, version, opts, s.args...)
+		if err == nil {
+			if firstErr != nil {
+				log.Infof(ctx, "prepared %s@%s with fallback strategy %q", modulePath, version, s.name)
+			}
+			return s.name, nil
+		}
+		log.Warnf(ctx, "preparation strategy %q failed: %v", s.name, err)
+		if firstErr == nil {
+			firstErr = err
+		}
 	}
-	return runGoCommand(ctx, modulePath, version, opts, "mod", "tidy")
+	return "", firstErr
 }
 
 type goCommandOptions struct {
 	dir      string
 	insecure bool
+	// Value of GOFLAGS, if non-empty. The command runs outside the
+	// sandbox, so the flags must have been checked by
+	// analysis.CanonicalGoFlags.
+	goflags string
 }
 
 // runGoModCommand runs the command `go args...`.
@@ -307,6 +482,9 @@ func runGoCommand(ctx context.Context, modulePath, version string, opts *goComma
 		// Use sandbox mod cache.
 		cmd.Env = append(cmd.Env, "GOMODCACHE="+filepath.Join(sandboxRoot, sandboxGoModCache))
 	}
+	if opts.goflags != "" {
+		cmd.Env = append(cmd.Env, "GOFLAGS="+opts.goflags)
+	}
 	if _, err := cmd.Output(); err != nil {
 		return fmt.Errorf("%w: 'go %s' for %s@%s returned %s",
 			derrors.BadModule, argstring, modulePath, version, derrors.IncludeStderr(err))
@@ -5,15 +5,26 @@
 package worker
 
 import (
+	"compress/gzip"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"golang.org/x/exp/slices"
 	"golang.org/x/exp/slog"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
 	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
 	"golang.org/x/pkgsite-metrics/internal/log"
+	"golang.org/x/pkgsite-metrics/internal/modules"
 	"golang.org/x/pkgsite-metrics/internal/proxy"
 	test "golang.org/x/pkgsite-metrics/internal/testing"
 )
@@ -29,30 +40,220 @@ func TestPrepareModule(t *testing.T) {
 	}
 
 	for _, test := range []struct {
-		modulePath, version string
-		init                bool
-		want                error
+		modulePath, version, subdir string
+		init                        bool
+		want                        error
 	}{
 		// Bad version; proxy should return an error.
-		{"rsc.io/quote", "x", true, derrors.ProxyError},
+		{"rsc.io/quote", "x", "", true, derrors.ProxyError},
 		// This module has a go.mod file...
-		{"rsc.io/quote", "v1.0.0", false, nil},
+		{"rsc.io/quote", "v1.0.0", "", false, nil},
 		// ...so it doesn't matter if we pass true for init.
-		{"rsc.io/quote", "v1.0.0", true, nil},
+		{"rsc.io/quote", "v1.0.0", "", true, nil},
 		// This module doesn't have a go.mod file...
-		{"github.com/pkg/errors", "v0.9.1", false, derrors.BadModule},
+		{"github.com/pkg/errors", "v0.9.1", "", false, derrors.BadModule},
 		// ... but passing init will make it work.
-		{"github.com/pkg/errors", "v0.9.1", true, nil},
+		{"github.com/pkg/errors", "v0.9.1", "", true, nil},
 		// This module has a dependency (github.com/decred/blake256) for which
-		// the proxy returns 404 when fetch is disabled.
-		{"github.com/decred/gominer", "v1.0.0", true, derrors.BadModule},
+		// the proxy returns 404 when fetch is disabled, so `go mod tidy`
+		// fails, but `go mod tidy -e` succeeds.
+		{"github.com/decred/gominer", "v1.0.0", "", true, nil},
+		// A subdirectory without a go.mod file is made a module of its own...
+		{"rsc.io/quote", "v1.5.2", "buggy", true, nil},
+		// ...if it exists.
+		{"rsc.io/quote", "v1.5.2", "nosuchdir", true, derrors.BadModule},
 	} {
-		t.Run(fmt.Sprintf("%s@%s,%t", test.modulePath, test.version, test.init), func(t *testing.T) {
+		t.Run(fmt.Sprintf("%s@%s,%s,%t", test.modulePath, test.version, test.subdir, test.init), func(t *testing.T) {
 			dir := t.TempDir()
-			err := prepareModule(ctx, test.modulePath, test.version, dir, proxyClient, insecure, test.init)
+			stats, err := prepareModule(ctx, test.modulePath, test.version, dir, test.subdir, proxySource{client: proxyClient}, insecure, test.init, "")
 			if !errors.Is(err, test.want) {
 				t.Errorf("got %v, want %v", err, test.want)
 			}
+			if err == nil && (stats.zipSize <= 0 || !stats.depsKnown) {
+				t.Errorf("got stats %+v, want zip size and dependencies", stats)
+			}
+			if want := filepath.Join(dir, test.subdir); err == nil && stats.dir != want {
+				t.Errorf("got dir %q, want %q", stats.dir, want)
+			}
 		})
 	}
 }
+
+func TestAddRequirements(t *testing.T) {
+	test.NeedsIntegrationEnv(t)
+	ctx := context.Background()
+	const insecure = true
+
+	for _, test := range []struct {
+		name, src string
+		want      string
+	}{
+		{"clean", `package a; import _ "fmt"`, prepTidy},
+		// The proxy has no such module, so tidy fails unless it ignores the
+		// error.
+		{"missing", `package a; import _ "example.com/nosuchmodule/b"`, prepTidyErrors},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "a.go"), []byte(test.src), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := goModInit(ctx, "example.com/a", "v1.0.0", dir, "example.com/a", insecure); err != nil {
+				t.Fatal(err)
+			}
+			got, err := addRequirements(ctx, "example.com/a", "v1.0.0", dir, insecure, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got strategy %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestModuleStats(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	const gomod = `module example.com/m
+
+go 1.21
+
+require (
+	golang.org/x/mod v0.22.0
+	golang.org/x/text v0.3.0 // indirect
+	rsc.io/quote v1.5.2
+)
+
+require rsc.io/sampler v1.3.0 // indirect
+require golang.org/x/tools v0.1.0 // indirect
+`
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte(gomod), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stats := moduleStats{zipSize: 1234, verification: modules.Verified}
+	stats.countDeps(ctx, dir)
+	want := moduleStats{zipSize: 1234, verification: modules.Verified, numDirectDeps: 2, numIndirectDeps: 3, depsKnown: true}
+	if stats != want {
+		t.Errorf("got %+v, want %+v", stats, want)
+	}
+	var row govulncheck.Result
+	stats.setRow(&row)
+	if row.ModuleSize.Int64 != 1234 || row.NumDirectDeps.Int64 != 2 || row.NumIndirectDeps.Int64 != 3 {
+		t.Errorf("got row stats %v, %v, %v", row.ModuleSize, row.NumDirectDeps, row.NumIndirectDeps)
+	}
+	if row.ChecksumVerification.StringVal != modules.Verified {
+		t.Errorf("got checksum verification %v, want %q", row.ChecksumVerification, modules.Verified)
+	}
+
+	// Without a go.mod file, the dependencies are unknown.
+	stats = moduleStats{}
+	stats.countDeps(ctx, t.TempDir())
+	row = govulncheck.Result{}
+	stats.setRow(&row)
+	if row.ModuleSize.Valid || row.NumDirectDeps.Valid || row.NumIndirectDeps.Valid || row.ChecksumVerification.Valid {
+		t.Errorf("got row stats %v, %v, %v; want all missing", row.ModuleSize, row.NumDirectDeps, row.NumIndirectDeps)
+	}
+}
+
+func TestWriteIndentedJSON(t *testing.T) {
+	type row struct {
+		A string
+		B []int
+	}
+	for _, v := range []any{
+		nil,
+		[]*row(nil),
+		[]*row{},
+		[]*row{{A: "x", B: []int{1, 2}}},
+		[]any{&row{A: "x"}, 3, &row{B: []int{4}}},
+		&row{A: "y"},
+		[]byte("bytes"),
+	} {
+		want, err := json.MarshalIndent(v, "", "    ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b strings.Builder
+		if err := writeIndentedJSON(&b, v); err != nil {
+			t.Fatal(err)
+		}
+		if got := b.String(); got != string(want) {
+			t.Errorf("%#v:\ngot\n%s\nwant\n%s", v, got, want)
+		}
+	}
+}
+
+func TestServeJSONCompression(t *testing.T) {
+	rows := []int{1, 2, 3}
+	for _, tt := range []struct {
+		acceptEncoding string
+		wantGzip       bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"deflate, gzip;q=0.5", true},
+		{"gzip;q=0", false},
+		{"br", false},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		rec := httptest.NewRecorder()
+		w := &responseWriter{ResponseWriter: rec, acceptsGzip: acceptsGzip(r)}
+		if err := serveJSON(context.Background(), rows, w); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.close(); err != nil {
+			t.Fatal(err)
+		}
+		gotGzip := rec.Header().Get("Content-Encoding") == "gzip"
+		if gotGzip != tt.wantGzip {
+			t.Errorf("Accept-Encoding %q: got gzip %t, want %t", tt.acceptEncoding, gotGzip, tt.wantGzip)
+		}
+		var body io.Reader = rec.Body
+		if gotGzip {
+			zr, err := gzip.NewReader(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body = zr
+		}
+		var got []int
+		if err := json.NewDecoder(body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(rows) {
+			t.Errorf("Accept-Encoding %q: got %v, want %v", tt.acceptEncoding, got, rows)
+		}
+	}
+}
+
+func TestWriteResults(t *testing.T) {
+	ctx := context.Background()
+	fake := bigquery.NewFake()
+	if _, err := fake.CreateOrUpdateTable(ctx, govulncheck.TableName); err != nil {
+		t.Fatal(err)
+	}
+	good := &govulncheck.Result{ModulePath: "example.com/a", Version: "v1.0.0"}
+	bad := &govulncheck.Result{ModulePath: "", Version: "v1.0.0"} // rejected by validation
+	if err := writeResult(ctx, false, nil, fake, nil, govulncheck.TableName, good); err != nil {
+		t.Fatal(err)
+	}
+	more := &govulncheck.Result{ModulePath: "example.com/b", Version: "v0.1.0"}
+	if err := writeResults(ctx, false, nil, fake, nil, govulncheck.TableName, []bigquery.Row{bad, more}); err != nil {
+		t.Fatal(err)
+	}
+	rows := fake.Rows(govulncheck.TableName)
+	var got []string
+	for _, r := range rows {
+		res := r.(*govulncheck.Result)
+		if res.CreatedAt.IsZero() {
+			t.Errorf("%s: upload time not set", res.ModulePath)
+		}
+		got = append(got, res.ModulePath)
+	}
+	want := []string{"example.com/a", "example.com/b"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got rows for %v, want %v", got, want)
+	}
+}
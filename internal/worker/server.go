@@ -6,18 +6,22 @@
 package worker
 
 import (
+	"compress/gzip"
 	"context"
 	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"cloud.google.com/go/errorreporting"
+	"golang.org/x/exp/event"
 	"golang.org/x/pkgsite-metrics/internal/analysis"
+	"golang.org/x/pkgsite-metrics/internal/audit"
 	"golang.org/x/pkgsite-metrics/internal/bigquery"
 	"golang.org/x/pkgsite-metrics/internal/config"
 	"golang.org/x/pkgsite-metrics/internal/derrors"
@@ -25,24 +29,48 @@ import (
 	"golang.org/x/pkgsite-metrics/internal/govulncheck"
 	"golang.org/x/pkgsite-metrics/internal/jobs"
 	"golang.org/x/pkgsite-metrics/internal/log"
+	"golang.org/x/pkgsite-metrics/internal/modules"
 	"golang.org/x/pkgsite-metrics/internal/observe"
 	"golang.org/x/pkgsite-metrics/internal/proxy"
 	"golang.org/x/pkgsite-metrics/internal/queue"
 )
 
 type Server struct {
-	cfg         *config.Config
-	observer    *observe.Observer
-	bqClient    *bigquery.Client
-	proxyClient *proxy.Client
-	queue       queue.Queue
-	jobDB       *jobs.DB
+	cfg      *config.Config
+	observer *observe.Observer
+	bqClient bigquery.DB
+	// localResults, if not nil, receives result rows instead of BigQuery.
+	// It is set in local mode.
+	localResults *localResultsDir
+	uploads      *bigquery.Buffer // batches the rows written to bqClient, if not nil
+	proxyClient  *proxy.Client
+	queue        queue.Queue
+	jobDB        *jobs.DB
+	// Verifies downloaded modules against the checksum database, if not nil.
+	sumDB *modules.SumDB
+	// Combines the increments to job counters made by concurrent tasks.
+	jobCounters *jobs.Aggregator
 	// Firestore namespace for storing work versions.
 	fsNamespace *fstore.Namespace
+	// Cache of vuln DB request counts served by /vulndbreqs/counts.
+	requestCounts requestCountsCache
+	// Configuration that can change without a redeploy.
+	dynamic *config.DynamicConfig
 
 	// reqs is the number of incoming scan requests, both analysis and
 	// govulncheck. Used for monitoring, debugging, and server restart.
 	reqs atomic.Uint64
+	// inflight is the number of scan requests being handled.
+	inflight atomic.Int32
+	// Limits on the number of concurrent scans of each kind.
+	analysisScans    *scanLimiter
+	govulncheckScans *scanLimiter
+	// The size in bytes of a module's source above which its packages
+	// are analyzed in batches, or zero to analyze them in batches only
+	// if the scan request asks for it.
+	analysisBatchThreshold int64
+	// Limits on the resources used by each scan.
+	scanLimits scanLimits
 
 	devMode bool
 	mu      sync.Mutex
@@ -56,33 +84,57 @@ func (s *Server) Info() string {
 func NewServer(ctx context.Context, cfg *config.Config) (_ *Server, err error) {
 	defer derrors.WrapAndReport(&err, "NewServer")
 
-	var bq *bigquery.Client
-	if strings.EqualFold(cfg.BigQueryDataset, "disable") {
+	var (
+		bq      bigquery.DB
+		uploads *bigquery.Buffer
+		local   *localResultsDir
+	)
+	nsName := cfg.BigQueryDataset
+	if cfg.LocalDir != "" {
+		log.Infof(ctx, "local mode: BigQuery disabled, writing results to %s", cfg.LocalDir)
+		if err := os.MkdirAll(cfg.LocalDir, 0755); err != nil {
+			return nil, err
+		}
+		local = &localResultsDir{dir: cfg.LocalDir}
+		nsName = "local"
+	} else if strings.EqualFold(cfg.BigQueryDataset, "disable") {
 		log.Infof(ctx, "BigQuery disabled")
 	} else {
-		bq, err = bigquery.NewClientCreate(ctx, cfg.ProjectID, cfg.BigQueryDataset)
+		client, err := bigquery.NewClientCreate(ctx, cfg.ProjectID, cfg.BigQueryDataset)
 		if err != nil {
 			return nil, err
 		}
+		bq = client
+		if cfg.UploadBufferRows > 0 {
+			uploads, err = bigquery.NewBuffer(client, bigquery.BufferOptions{
+				MaxRows: cfg.UploadBufferRows,
+				MaxAge:  cfg.UploadBufferAge,
+			})
+			if err != nil {
+				return nil, err
+			}
+			bq = uploads
+		}
 	}
 
 	// Use the same name for the namespace as the BQ dataset.
-	ns, err := fstore.OpenNamespace(ctx, cfg.ProjectID, cfg.BigQueryDataset)
+	ns, err := fstore.OpenNamespace(ctx, cfg.ProjectID, nsName)
 	if err != nil {
 		return nil, err
 	}
 
-	q, err := queue.New(ctx, cfg,
-		func(ctx context.Context, t queue.Task) (int, error) {
-			// When running locally, only the module path and version are
-			// printed for now.
-			log.Infof(ctx, "enqueuing %s?%s", t.Path(), t.Params())
-			return 0, nil
-		})
-	log.Debugf(ctx, "queue.New returned err %v", err)
-	if err != nil {
-		return nil, err
+	processFunc := func(ctx context.Context, t queue.Task, _ string, _ []byte) (int, error) {
+		// When running locally, only the module path and version are
+		// printed for now.
+		log.Infof(ctx, "enqueuing %s?%s", t.Path(), t.Params())
+		return 0, nil
+	}
+	if cfg.LocalDir != "" {
+		// In local mode, send tasks back to this server.
+		processFunc = localProcessFunc(cfg.LocalURL)
 	}
+	q, err := queue.New(ctx, cfg, processFunc)
+	log.Debugf(ctx, "queue.New returned err %v", err)
 	if err != nil {
 		return nil, err
 	}
@@ -91,23 +143,53 @@ func NewServer(ctx context.Context, cfg *config.Config) (_ *Server, err error) {
 	if err != nil {
 		return nil, err
 	}
+	if cfg.ProxyCacheDir != "" {
+		proxyClient = proxyClient.WithDiskCache(cfg.ProxyCacheDir, int64(cfg.ProxyCacheLimitMB)<<20, cfg.ProxyCacheTTL)
+	}
+
+	var sumDB *modules.SumDB
+	if cfg.SumDBURL != "off" {
+		sumDB = modules.NewSumDB(cfg.SumDBURL, modules.SumGolangOrgKey, http.DefaultClient)
+	}
 
 	var jdb *jobs.DB
 	if cfg.ProjectID != "" {
 		var err error
-		jdb, err = jobs.NewDB(ctx, cfg.ProjectID, cfg.BigQueryDataset)
+		jdb, err = jobs.NewDB(ctx, cfg.ProjectID, nsName)
 		if err != nil {
 			return nil, err
 		}
 	}
 	s := &Server{
-		cfg:         cfg,
-		bqClient:    bq,
-		queue:       q,
-		proxyClient: proxyClient,
-		devMode:     cfg.DevMode,
-		jobDB:       jdb,
-		fsNamespace: ns,
+		cfg:          cfg,
+		bqClient:     bq,
+		localResults: local,
+		uploads:      uploads,
+		queue:        q,
+		proxyClient:  proxyClient,
+		sumDB:        sumDB,
+		devMode:      cfg.DevMode,
+		jobDB:        jdb,
+		fsNamespace:  ns,
+		dynamic:      config.NewDynamicConfig(),
+
+		analysisScans:    newScanLimiter("analysis", cfg.MaxAnalysisScans),
+		govulncheckScans: newScanLimiter("govulncheck", cfg.MaxGovulncheckScans),
+
+		analysisBatchThreshold: int64(cfg.AnalysisBatchThresholdMB) << 20,
+		scanLimits: scanLimits{
+			goBuildCache: int64(cfg.GoBuildCacheLimitMB) << 20,
+			goModCache:   int64(cfg.GoModCacheLimitMB) << 20,
+			diskQuota:    int64(cfg.ScanDiskQuotaMB) << 20,
+		},
+	}
+	go s.dynamic.Watch(ctx, ns.Collection(dynamicConfigCollection).Doc(dynamicConfigDoc))
+	if err := recordStart(ctx, ns); err != nil {
+		// Only /health depends on it.
+		log.Errorf(ctx, err, "recording instance start")
+	}
+	if jdb != nil {
+		s.jobCounters = jobs.NewAggregator(jdb, jobCountersWindow)
 	}
 
 	if cfg.ProjectID != "" && cfg.ServiceID != "" {
@@ -135,6 +217,9 @@ func NewServer(ctx context.Context, cfg *config.Config) (_ *Server, err error) {
 	if err := ensureTable(ctx, bq, govulncheck.TableName); err != nil {
 		return nil, err
 	}
+	if err := ensureTable(ctx, bq, govulncheck.CompareSummaryTableName); err != nil {
+		return nil, err
+	}
 	s.registerGovulncheckHandlers()
 	if err := ensureTable(ctx, bq, analysis.TableName); err != nil {
 		return nil, err
@@ -147,11 +232,38 @@ func NewServer(ctx context.Context, cfg *config.Config) (_ *Server, err error) {
 	s.handle("/vulndb", s.handleVulnDB)
 	// compute missing vuln.go.dev request counts
 	s.handle("/compute-requests", s.handleComputeRequests)
+	// serve vuln.go.dev request counts to dashboards
+	s.handle("/vulndbreqs/counts", s.handleVulnDBReqsCounts)
+	// delete results older than their retention period
+	s.handle("/retention", s.handleRetention)
+	// serve metrics in the Prometheus text format
+	s.handle("/metrics", s.handleMetrics)
+	// serve the effective configuration
+	s.handle("/config", s.handleConfig)
+	// edit the modules skipped by the dynamic configuration
+	s.handle("/config/skip", s.handleSkipModules)
+	// serve a summary of the health of the instance
+	s.handle("/health", s.handleHealth)
+	// serve the statistics of the task queues
+	s.handle("/queue/stats", s.handleQueueStats)
+	// serve the recent results for a module
+	s.handle("/history", s.handleHistory)
+	if err := ensureTable(ctx, bq, jobs.TableName); err != nil {
+		return nil, err
+	}
 	s.handle("/jobs/", s.handleJobs)
+	if err := ensureTable(ctx, bq, audit.TableName); err != nil {
+		return nil, err
+	}
+	// serve the recent administrative actions
+	s.handle("/audit", s.handleAudit)
+	if err := ensureViews(ctx, bq); err != nil {
+		return nil, err
+	}
 	return s, nil
 }
 
-func ensureTable(ctx context.Context, bq *bigquery.Client, name string) error {
+func ensureTable(ctx context.Context, bq bigquery.DB, name string) error {
 	if bq == nil {
 		return nil
 	}
@@ -167,6 +279,24 @@ func ensureTable(ctx context.Context, bq *bigquery.Client, name string) error {
 	return nil
 }
 
+// ensureViews creates or updates the views recorded with bigquery.AddView.
+// It must be called after the tables they refer to are created.
+func ensureViews(ctx context.Context, bq bigquery.DB) error {
+	if bq == nil {
+		return nil
+	}
+	for _, name := range bigquery.ViewNames() {
+		created, err := bq.CreateOrUpdateView(ctx, name)
+		if err != nil {
+			return err
+		}
+		if created {
+			log.Infof(ctx, "created view %s", name)
+		}
+	}
+	return nil
+}
+
 const metricNamespace = "ecosystem/worker"
 
 type handlerFunc func(w http.ResponseWriter, r *http.Request) error
@@ -189,11 +319,14 @@ func (s *Server) handle(pattern string, handler handlerFunc) {
 		urlString := url2.String()
 
 		log.Infof(ctx, "starting %s", urlString)
-		w2 := &responseWriter{ResponseWriter: w}
+		w2 := &responseWriter{ResponseWriter: w, acceptsGzip: acceptsGzip(r)}
 		if err := handler(w2, r); err != nil {
 			derrors.Report(err)
 			s.serveError(ctx, w2, r, err)
 		}
+		if err := w2.close(); err != nil {
+			log.Errorf(ctx, err, "finishing compressed response")
+		}
 		logger.Info(fmt.Sprintf("ending %s", urlString),
 			"latency", time.Since(start),
 			"status", translateStatus(w2.status))
@@ -205,7 +338,10 @@ func (s *Server) registerGovulncheckHandlers() {
 	h := newGovulncheckServer(s)
 	s.handle("/govulncheck/enqueueall", h.handleEnqueueAll)
 	s.handle("/govulncheck/enqueue", h.handleEnqueue)
-	s.handle("/govulncheck/scan/", reqMonitorHandler(s, h.handleScan))
+	s.handle("/govulncheck/enqueue-osv", h.handleEnqueueOSV)
+	s.handle("/govulncheck/workstates/delete", h.handleDeleteWorkStates)
+	s.handle("/govulncheck/scan/", priorityHandler(scanPriority, limitHandler(s.govulncheckScans, reqMonitorHandler(s, h.handleScan))))
+	s.handle("/govulncheck/migrate-legacy", h.handleMigrateLegacy)
 }
 
 func (s *Server) registerAnalysisHandlers(ctx context.Context) error {
@@ -213,29 +349,57 @@ func (s *Server) registerAnalysisHandlers(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-	s.handle("/analysis/scan/", reqMonitorHandler(s, h.handleScan))
+	s.handle("/analysis/scan/", priorityHandler(scanPriority, limitHandler(s.analysisScans, reqMonitorHandler(s, h.handleScan))))
 	s.handle("/analysis/enqueue", h.handleEnqueue)
+	s.handle("/analysis/plan", h.handlePlan)
+	s.handle("/analysis/diff", h.handleDiff)
+	s.handle("/analysis/run", h.handleRun)
 	return nil
 }
 
-// reqMonitorHandler creates a handler with h that 1) updates server request statistics
-// and 2) resets the server after a certain number of incoming server requests. The
-// incoming request that triggers the reset will be disregarded. Cloud Run will retry
-// that request.
+// scanRequestCounter counts the scan requests that the server accepted.
+var scanRequestCounter = event.NewCounter("scan-requests", &event.MetricOptions{Namespace: metricNamespace, Description: "Number of scan requests accepted."})
+
+// reqMonitorHandler creates a handler with h that 1) updates server request statistics,
+// 2) rejects requests beyond the maximum number of concurrent scans and 3) resets the
+// server after a certain number of incoming server requests. The incoming request that
+// triggers the reset will be disregarded. Cloud Run will retry that request, as Cloud
+// Tasks retries rejected ones. The limits are from the dynamic configuration.
 func reqMonitorHandler(s *Server, h func(http.ResponseWriter, *http.Request) error) func(http.ResponseWriter, *http.Request) error {
-	const reqLimit = 250 // experimentally shown to be a good threshold
 	return func(w http.ResponseWriter, r *http.Request) error {
+		dyn := s.dynamic.Get()
 		// Reset the server after a certain number of requests due to a process leak.
 		// TODO(#65215): why does this happen? It seems to be due to gvisor.
-		if s.reqs.Load() > reqLimit {
-			log.Infof(r.Context(), "resetting server after %d requests, just before: %v", reqLimit, r.URL.Path)
+		if s.reqs.Load() > uint64(dyn.RequestLimit) {
+			log.Infof(r.Context(), "resetting server after %d requests, just before: %v", dyn.RequestLimit, r.URL.Path)
+			if err := s.FlushUploads(r.Context()); err != nil {
+				log.Errorf(r.Context(), err, "flushing uploads")
+			}
 			os.Exit(0)
 		}
+		n := s.inflight.Add(1)
+		defer s.inflight.Add(-1)
+		if dyn.MaxConcurrency > 0 && int(n) > dyn.MaxConcurrency {
+			return &serverError{
+				status: http.StatusTooManyRequests,
+				err:    fmt.Errorf("already running %d scans, the maximum", dyn.MaxConcurrency),
+			}
+		}
 		s.reqs.Add(1)
+		scanRequestCounter.Record(r.Context(), 1)
 		return h(w, r)
 	}
 }
 
+// FlushUploads writes the result rows that are waiting to be uploaded to
+// BigQuery in a batch.
+func (s *Server) FlushUploads(ctx context.Context) error {
+	if s.uploads == nil {
+		return nil
+	}
+	return s.uploads.Flush(ctx)
+}
+
 type serverError struct {
 	status int   // HTTP status code
 	err    error // wrapped error
@@ -269,14 +433,75 @@ func (s *Server) serveError(ctx context.Context, w http.ResponseWriter, _ *http.
 
 type responseWriter struct {
 	http.ResponseWriter
-	status int
+	status      int
+	started     bool         // the header has been written
+	acceptsGzip bool         // the client accepts gzip-compressed responses
+	gz          *gzip.Writer // if non-nil, compresses the rest of the response
 }
 
 func (rw *responseWriter) WriteHeader(code int) {
 	rw.status = code
+	rw.started = true
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	rw.started = true
+	if rw.gz != nil {
+		return rw.gz.Write(b)
+	}
+	return rw.ResponseWriter.Write(b)
+}
+
+// Flush sends the response written so far to the client.
+func (rw *responseWriter) Flush() {
+	if rw.gz != nil {
+		rw.gz.Flush()
+	}
+	// The underlying writer may be wrapped by the observer.
+	_ = http.NewResponseController(rw.ResponseWriter).Flush()
+}
+
+// compress arranges for the response to be compressed with gzip, if the
+// client accepts it and the header has not been written yet.
+func (rw *responseWriter) compress() {
+	if !rw.acceptsGzip || rw.started || rw.gz != nil {
+		return
+	}
+	h := rw.Header()
+	h.Set("Content-Encoding", "gzip")
+	h.Add("Vary", "Accept-Encoding")
+	h.Del("Content-Length")
+	rw.gz = gzip.NewWriter(rw.ResponseWriter)
+}
+
+// close completes a compressed response.
+func (rw *responseWriter) close() error {
+	if rw.gz == nil {
+		return nil
+	}
+	return rw.gz.Close()
+}
+
+// acceptsGzip reports whether the Accept-Encoding header of r allows
+// gzip, ignoring any preferences among encodings it expresses.
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(enc, ";")
+		if strings.TrimSpace(name) != "gzip" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		return q > 0
+	}
+	return false
+}
+
 func translateStatus(code int) int64 {
 	if code == 0 {
 		return http.StatusOK
@@ -0,0 +1,97 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/jobs"
+	"golang.org/x/pkgsite-metrics/internal/log"
+	"golang.org/x/pkgsite-metrics/internal/scan"
+)
+
+// pathologicalCodes are the error codes of the failures that count toward
+// a streak: those that are likely to recur, and that are costly each time.
+// A panic of the analysis binary is a bug in the binary, not in the
+// module, so it does not count. See jobs.ModuleStreak.
+var pathologicalCodes = map[derrors.ErrorCode]bool{
+	derrors.CodeTimeout:          true,
+	derrors.CodeMemLimitExceeded: true,
+	derrors.CodePanic:            true,
+}
+
+// streakDB is the part of jobs.DB that tracks module streaks.
+type streakDB interface {
+	GetModuleStreak(ctx context.Context, key jobs.StreakKey) (*jobs.ModuleStreak, error)
+	AddModuleFailure(ctx context.Context, key jobs.StreakKey, code, jobID string, limit int, skipFor time.Duration) (*jobs.ModuleStreak, error)
+	ResetModuleStreak(ctx context.Context, key jobs.StreakKey) error
+	ListSkippedModules(ctx context.Context, binaryVersion string, now time.Time) ([]*jobs.ModuleStreak, error)
+}
+
+// softSkipConfig returns the number of consecutive pathological failures
+// after which a module is skipped, and for how long. The number is zero
+// if modules are never skipped for failing.
+func (s *Server) softSkipConfig() (limit int, skipFor time.Duration) {
+	if s.cfg == nil {
+		return 0, 0
+	}
+	return s.cfg.SoftSkipAfter, s.cfg.SoftSkipFor
+}
+
+// softSkipModules returns the modules of mods that are not skipped at time
+// now for failing pathologically when scanned by the binary with the given
+// hash, and the skipped ones with the reasons. If it cannot read the
+// skipped modules, it returns mods and the error.
+func softSkipModules(ctx context.Context, db streakDB, mods []scan.ModuleSpec, binaryVersion string, now time.Time) ([]scan.ModuleSpec, []jobs.ModuleSkip, error) {
+	streaks, err := db.ListSkippedModules(ctx, binaryVersion, now)
+	if err != nil {
+		return mods, nil, err
+	}
+	if len(streaks) == 0 {
+		return mods, nil, nil
+	}
+	reasons := map[jobs.StreakKey]string{}
+	for _, s := range streaks {
+		reasons[s.Key()] = s.SkipReason
+	}
+	var kept []scan.ModuleSpec
+	var skipped []jobs.ModuleSkip
+	for _, m := range mods {
+		key := jobs.StreakKey{Module: m.Path, Version: m.Version, BinaryVersion: binaryVersion}
+		if r, ok := reasons[key]; ok {
+			skipped = append(skipped, jobs.ModuleSkip{Module: m.Path, Version: m.Version, Reason: r})
+		} else {
+			kept = append(kept, m)
+		}
+	}
+	return kept, skipped, nil
+}
+
+// updateModuleStreak updates the streak with the key after a scan for the
+// job that failed with the error code, or succeeded if code is zero. The
+// streak read before the scan, prev, saves a write when there is nothing
+// to reset. Failures to update the streak are only logged.
+func (s *Server) updateModuleStreak(ctx context.Context, db streakDB, prev *jobs.ModuleStreak, key jobs.StreakKey, jobID string, code derrors.ErrorCode) {
+	limit, skipFor := s.softSkipConfig()
+	if !pathologicalCodes[code] {
+		if prev != nil {
+			if err := db.ResetModuleStreak(ctx, key); err != nil {
+				log.Errorf(ctx, err, "resetting the failure streak of %s@%s", key.Module, key.Version)
+			}
+		}
+		return
+	}
+	now := time.Now()
+	st, err := db.AddModuleFailure(ctx, key, code.String(), jobID, limit, skipFor)
+	if err != nil {
+		log.Errorf(ctx, err, "recording a %s failure of %s@%s", code, key.Module, key.Version)
+		return
+	}
+	if st.Skipped(now) && !prev.Skipped(now) {
+		log.Warnf(ctx, "skipping %s@%s until %s: %s", key.Module, key.Version, st.SkipUntil.Format(time.RFC3339), st.SkipReason)
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/pkgsite-metrics/internal/config"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/jobs"
+	"golang.org/x/pkgsite-metrics/internal/scan"
+)
+
+// fakeStreakDB is a streakDB whose streaks are held in memory.
+type fakeStreakDB struct {
+	streaks map[jobs.StreakKey]*jobs.ModuleStreak
+	resets  int
+}
+
+func (d *fakeStreakDB) GetModuleStreak(_ context.Context, key jobs.StreakKey) (*jobs.ModuleStreak, error) {
+	return d.streaks[key], nil
+}
+
+func (d *fakeStreakDB) AddModuleFailure(_ context.Context, key jobs.StreakKey, code, jobID string, limit int, skipFor time.Duration) (*jobs.ModuleStreak, error) {
+	s := d.streaks[key]
+	if s == nil {
+		s = &jobs.ModuleStreak{Module: key.Module, Version: key.Version, BinaryVersion: key.BinaryVersion}
+		d.streaks[key] = s
+	}
+	s.AddFailure(code, jobID, time.Now(), limit, skipFor)
+	return s, nil
+}
+
+func (d *fakeStreakDB) ResetModuleStreak(_ context.Context, key jobs.StreakKey) error {
+	delete(d.streaks, key)
+	d.resets++
+	return nil
+}
+
+func (d *fakeStreakDB) ListSkippedModules(_ context.Context, binaryVersion string, now time.Time) ([]*jobs.ModuleStreak, error) {
+	var ss []*jobs.ModuleStreak
+	for _, s := range d.streaks {
+		if s.BinaryVersion == binaryVersion && s.Skipped(now) {
+			ss = append(ss, s)
+		}
+	}
+	return ss, nil
+}
+
+func TestModuleStreaks(t *testing.T) {
+	ctx := context.Background()
+	db := &fakeStreakDB{streaks: map[jobs.StreakKey]*jobs.ModuleStreak{}}
+	s := &Server{cfg: &config.Config{SoftSkipAfter: 2, SoftSkipFor: time.Hour}}
+	scanModule := func(module, version, binary string, err error) {
+		var code derrors.ErrorCode
+		if err != nil {
+			code = derrors.CodeOf(err)
+		}
+		key := jobs.StreakKey{Module: module, Version: version, BinaryVersion: binary}
+		prev, _ := db.GetModuleStreak(ctx, key)
+		s.updateModuleStreak(ctx, db, prev, key, "j", code)
+	}
+
+	scanModule("a.com/oom", "v1.0.0", "bin1", derrors.ScanModuleMemoryLimitExceeded)
+	scanModule("a.com/oom", "v1.0.0", "bin1", derrors.ScanModuleMemoryLimitExceeded)
+	// Failures of other versions or binaries are other streaks.
+	scanModule("a.com/oom", "v1.1.0", "bin1", derrors.ScanModuleMemoryLimitExceeded)
+	scanModule("a.com/oom", "v1.0.0", "bin2", derrors.ScanModuleMemoryLimitExceeded)
+	scanModule("b.com/flaky", "v1.0.0", "bin1", derrors.ScanModulePanicError)
+	scanModule("b.com/flaky", "v1.0.0", "bin1", nil)
+	// Ordinary failures and panics of the binary do not count.
+	scanModule("c.com/load", "v1.0.0", "bin1", derrors.LoadPackagesError)
+	scanModule("c.com/load", "v1.0.0", "bin1", errors.New("boom"))
+	scanModule("c.com/load", "v1.0.0", "bin1", derrors.AnalysisBinaryPanicError)
+	scanModule("c.com/load", "v1.0.0", "bin1", derrors.AnalysisBinaryPanicError)
+	scanModule("d.com/slow", "v1.0.0", "bin1", context.DeadlineExceeded)
+
+	if db.resets != 1 {
+		t.Errorf("got %d resets, want 1", db.resets)
+	}
+	mods := []scan.ModuleSpec{
+		{Path: "a.com/oom", Version: "v1.0.0"},
+		{Path: "a.com/oom", Version: "v1.1.0"},
+		{Path: "b.com/flaky", Version: "v1.0.0"},
+		{Path: "c.com/load", Version: "v1.0.0"},
+		{Path: "d.com/slow", Version: "v1.0.0"},
+	}
+	kept, skipped, err := softSkipModules(ctx, db, mods, "bin1", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantKept := mods[1:]
+	if diff := cmp.Diff(wantKept, kept); diff != "" {
+		t.Errorf("kept mismatch (-want, +got):\n%s", diff)
+	}
+	wantSkipped := []jobs.ModuleSkip{{
+		Module:  "a.com/oom",
+		Version: "v1.0.0",
+		Reason:  "2 consecutive pathological failures, the last MEM_LIMIT_EXCEEDED",
+	}}
+	if diff := cmp.Diff(wantSkipped, skipped); diff != "" {
+		t.Errorf("skipped mismatch (-want, +got):\n%s", diff)
+	}
+	kept, _, err = softSkipModules(ctx, db, mods, "bin2", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(mods, kept); diff != "" {
+		t.Errorf("bin2: kept mismatch (-want, +got):\n%s", diff)
+	}
+}
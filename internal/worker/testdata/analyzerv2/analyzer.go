@@ -0,0 +1,22 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// This analyzer speaks version 2 of the driver protocol.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/tools/go/analysis/passes/findcall"
+	"golang.org/x/tools/go/analysis/singlechecker"
+)
+
+func main() {
+	if len(os.Args) == 2 && os.Args[1] == "-metadata" {
+		fmt.Println(`{"protocol_version": 2, "analyzers": ["findcall"]}`)
+		return
+	}
+	singlechecker.Main(findcall.Analyzer)
+}
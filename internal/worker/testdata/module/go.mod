@@ -1,2 +1 @@
 module test_module
-
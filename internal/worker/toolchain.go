@@ -0,0 +1,75 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/log"
+)
+
+// toolchainsDir is the directory of the sandbox holding the pre-provisioned
+// Go toolchains, each in a subdirectory named for its version, like
+// "go1.22.3". The worker's Dockerfile populates it.
+const toolchainsDir = "/toolchains"
+
+// sandboxPath is the value of PATH in the sandbox, from config.json.
+const sandboxPath = "/usr/local/go/bin:/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// provisionToolchain returns the GOROOT of the Go toolchain goVersion, like
+// "go1.22.3", as seen by an analysis binary: in the sandbox, unless
+// insecure. It uses a pre-provisioned toolchain if there is one, and
+// otherwise downloads the toolchain into the sandbox's module cache, as the
+// go command does for GOTOOLCHAIN. If it can do neither, it returns an
+// error wrapping derrors.ToolchainUnavailable.
+func provisionToolchain(ctx context.Context, goVersion string, insecure bool) (goroot string, err error) {
+	defer derrors.Wrap(&err, "provisionToolchain(%q)", goVersion)
+
+	root := ""
+	if !insecure {
+		root = sandboxRoot
+	}
+	dir := filepath.Join(root, toolchainsDir, goVersion)
+	if fileExists(filepath.Join(dir, "bin", "go")) {
+		return filepath.Join(toolchainsDir, goVersion), nil
+	}
+	log.Infof(ctx, "downloading Go toolchain %s", goVersion)
+	env := []string{"GOTOOLCHAIN=" + goVersion, "GOPROXY=https://proxy.golang.org/cached-only"}
+	if !insecure {
+		env = append(env, "GOMODCACHE="+filepath.Join(sandboxRoot, sandboxGoModCache))
+	}
+	out, err := goOutput(os.TempDir(), env, "env", "GOROOT")
+	if err != nil {
+		return "", fmt.Errorf("%w: %s: %v", derrors.ToolchainUnavailable, goVersion, err)
+	}
+	goroot = strings.TrimSpace(string(out))
+	if !insecure {
+		var ok bool
+		goroot, ok = strings.CutPrefix(goroot, sandboxRoot)
+		if !ok {
+			return "", fmt.Errorf("%w: %s: GOROOT %s is outside the sandbox", derrors.ToolchainUnavailable, goVersion, goroot)
+		}
+	}
+	return goroot, nil
+}
+
+// toolchainEnv returns the variables to add to the environment of an
+// analysis binary so that it runs the Go toolchain in goroot, and only that
+// one, wherever the binary looks for the go command.
+func toolchainEnv(goroot string, insecure bool) []string {
+	path := sandboxPath
+	if insecure {
+		path = os.Getenv("PATH")
+	}
+	return []string{
+		"PATH=" + filepath.Join(goroot, "bin") + string(filepath.ListSeparator) + path,
+		"GOTOOLCHAIN=local",
+	}
+}
@@ -0,0 +1,40 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestToolchainEnv(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a POSIX shell")
+	}
+	out, err := exec.Command("go", "env", "GOROOT").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	goroot := strings.TrimSpace(string(out))
+	env := toolchainEnv(goroot, true)
+	if !slices.Contains(env, "GOTOOLCHAIN=local") {
+		t.Errorf("got %q, want GOTOOLCHAIN=local", env)
+	}
+	// Commands that look up the go command, like analysis binaries,
+	// find the toolchain in goroot.
+	cmd := exec.Command("sh", "-c", "command -v go")
+	cmd.Env = append(os.Environ(), env...)
+	out, err = cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.TrimSpace(string(out)), goroot+"/bin/go"; got != want {
+		t.Errorf("go command: got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Validation of result rows before they are written.
+
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	bq "cloud.google.com/go/bigquery"
+	"golang.org/x/exp/event"
+	"golang.org/x/mod/semver"
+	"golang.org/x/pkgsite-metrics/internal/analysis"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+	"golang.org/x/pkgsite-metrics/internal/log"
+)
+
+var (
+	// repairedRowCounter counts result rows that were repaired before writing.
+	repairedRowCounter = event.NewCounter("result-rows-repaired", &event.MetricOptions{Namespace: metricNamespace})
+	// rejectedRowCounter counts result rows that were too malformed to write.
+	rejectedRowCounter = event.NewCounter("result-rows-rejected", &event.MetricOptions{Namespace: metricNamespace})
+)
+
+// validateRows validates rows destined for table, repairing what problems
+// it can. It logs and counts the repaired and rejected rows, and returns
+// the rows that should be written.
+func validateRows(ctx context.Context, table string, rows []bigquery.Row) []bigquery.Row {
+	var valid []bigquery.Row
+	for _, row := range rows {
+		var v validation
+		v.check(row)
+		if len(v.rejects) > 0 {
+			log.Errorf(ctx, errors.New(strings.Join(v.rejects, "; ")), "rejecting %s row %s", table, rowName(row))
+			rejectedRowCounter.Record(ctx, 1, event.String("table", table))
+			continue
+		}
+		if len(v.repairs) > 0 {
+			log.Warnf(ctx, "repaired %s row %s: %s", table, rowName(row), strings.Join(v.repairs, "; "))
+			repairedRowCounter.Record(ctx, 1, event.String("table", table))
+		}
+		valid = append(valid, row)
+	}
+	return valid
+}
+
+// rowName returns a description of row for messages.
+func rowName(row bigquery.Row) string {
+	switch r := row.(type) {
+	case *analysis.Result:
+		return r.ModulePath + "@" + r.Version
+	case *govulncheck.Result:
+		return r.ModulePath + "@" + r.Version
+	default:
+		return fmt.Sprintf("%T", row)
+	}
+}
+
+// A validation records the problems found in a row.
+type validation struct {
+	repairs []string // problems that were repaired
+	rejects []string // problems that make the row unusable
+}
+
+func (v *validation) repair(format string, args ...any) {
+	v.repairs = append(v.repairs, fmt.Sprintf(format, args...))
+}
+
+func (v *validation) reject(format string, args ...any) {
+	v.rejects = append(v.rejects, fmt.Sprintf(format, args...))
+}
+
+// check validates row. Rows of types it doesn't know about are not checked.
+func (v *validation) check(row bigquery.Row) {
+	switch r := row.(type) {
+	case *analysis.Result:
+		v.checkModule(r.ModulePath, r.Version, r.Error)
+		v.checkError(&r.Error, &r.ErrorCategory, &r.ErrorCode)
+		for i, d := range r.Diagnostics {
+			v.checkDiagnostic(i, d)
+		}
+	case *govulncheck.Result:
+		v.checkModule(r.ModulePath, r.Version, r.Error)
+		v.checkError(&r.Error, &r.ErrorCategory, &r.ErrorCode)
+	}
+}
+
+// checkModule checks the module path and version of a row.
+// A row that records an error may have a version that was never resolved,
+// like "latest".
+func (v *validation) checkModule(modulePath, version, errString string) {
+	if modulePath == "" {
+		v.reject("empty module path")
+	}
+	if !semver.IsValid(version) && errString == "" {
+		v.reject("invalid version %q", version)
+	}
+}
+
+// checkError makes a row's error, category and code consistent: a row with
+// an error must have a category and a code, and one without must have neither.
+func (v *validation) checkError(errString, category *string, code *bq.NullInt64) {
+	v.checkUTF8("error", errString)
+	if *errString == "" {
+		if *category != "" || code.Valid {
+			v.repair("category %q or code without error", *category)
+			*category = ""
+			*code = bq.NullInt64{}
+		}
+		return
+	}
+	if *category == "" {
+		v.repair("error without category")
+		*category = derrors.CodeMisc.Category()
+		*code = bq.NullInt64{Int64: int64(derrors.CodeMisc), Valid: true}
+	}
+	if !code.Valid {
+		c, ok := derrors.CodeForCategory(*category)
+		if !ok {
+			c = derrors.CodeMisc
+		}
+		v.repair("error without code")
+		*code = bq.NullInt64{Int64: int64(c), Valid: true}
+	}
+}
+
+// checkDiagnostic checks the i'th diagnostic of an analysis row.
+func (v *validation) checkDiagnostic(i int, d *analysis.Diagnostic) {
+	if d.Position != "" {
+		if _, _, _, err := parsePosition(d.Position); err != nil {
+			v.repair("diagnostic %d: bad position %q", i, d.Position)
+			d.Position = ""
+		}
+	}
+	v.checkUTF8(fmt.Sprintf("diagnostic %d message", i), &d.Message)
+	v.checkUTF8(fmt.Sprintf("diagnostic %d error", i), &d.Error)
+	if d.Source.Valid {
+		v.checkUTF8(fmt.Sprintf("diagnostic %d source", i), &d.Source.StringVal)
+	}
+}
+
+// checkUTF8 replaces invalid UTF-8 in *s.
+func (v *validation) checkUTF8(what string, s *string) {
+	if !utf8.ValidString(*s) {
+		v.repair("%s: invalid UTF-8", what)
+		*s = strings.ToValidUTF8(*s, string(utf8.RuneError))
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"testing"
+
+	bq "cloud.google.com/go/bigquery"
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/pkgsite-metrics/internal/analysis"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+)
+
+func TestValidateRows(t *testing.T) {
+	miscCode := bq.NullInt64{Int64: int64(derrors.CodeMisc), Valid: true}
+	loadCode := bq.NullInt64{Int64: int64(derrors.CodeLoad), Valid: true}
+	loadCategory := derrors.CodeLoad.Category()
+
+	for _, test := range []struct {
+		name        string
+		in          bigquery.Row
+		want        bigquery.Row // nil if rejected
+		wantRepairs int
+	}{
+		{
+			name: "valid",
+			in:   &analysis.Result{ModulePath: "m", Version: "v1.0.0"},
+			want: &analysis.Result{ModulePath: "m", Version: "v1.0.0"},
+		},
+		{
+			name: "empty module path",
+			in:   &govulncheck.Result{Version: "v1.0.0"},
+		},
+		{
+			name: "bad version",
+			in:   &analysis.Result{ModulePath: "m", Version: "latest"},
+		},
+		{
+			name: "bad version with error",
+			in: &govulncheck.Result{ModulePath: "m", Version: "latest",
+				Error: "e", ErrorCategory: loadCategory, ErrorCode: loadCode},
+			want: &govulncheck.Result{ModulePath: "m", Version: "latest",
+				Error: "e", ErrorCategory: loadCategory, ErrorCode: loadCode},
+		},
+		{
+			name:        "category without error",
+			in:          &analysis.Result{ModulePath: "m", Version: "v1.0.0", ErrorCategory: loadCategory, ErrorCode: loadCode},
+			want:        &analysis.Result{ModulePath: "m", Version: "v1.0.0"},
+			wantRepairs: 1,
+		},
+		{
+			name:        "error without category",
+			in:          &analysis.Result{ModulePath: "m", Version: "v1.0.0", Error: "e"},
+			want:        &analysis.Result{ModulePath: "m", Version: "v1.0.0", Error: "e", ErrorCategory: derrors.CodeMisc.Category(), ErrorCode: miscCode},
+			wantRepairs: 1,
+		},
+		{
+			name:        "error without code",
+			in:          &govulncheck.Result{ModulePath: "m", Version: "v1.0.0", Error: "e", ErrorCategory: loadCategory},
+			want:        &govulncheck.Result{ModulePath: "m", Version: "v1.0.0", Error: "e", ErrorCategory: loadCategory, ErrorCode: loadCode},
+			wantRepairs: 1,
+		},
+		{
+			name: "diagnostics",
+			in: &analysis.Result{ModulePath: "m", Version: "v1.0.0", Diagnostics: []*analysis.Diagnostic{
+				{Position: "a.go:1:2", Message: "ok"},
+				{Position: "a.go", Message: "bad\xffmessage"},
+				{Error: "e"},
+			}},
+			want: &analysis.Result{ModulePath: "m", Version: "v1.0.0", Diagnostics: []*analysis.Diagnostic{
+				{Position: "a.go:1:2", Message: "ok"},
+				{Position: "", Message: "bad�message"},
+				{Error: "e"},
+			}},
+			wantRepairs: 2,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var v validation
+			v.check(test.in)
+			if test.want == nil {
+				if len(v.rejects) == 0 {
+					t.Fatal("row not rejected")
+				}
+			} else {
+				if len(v.rejects) > 0 {
+					t.Fatalf("row rejected: %v", v.rejects)
+				}
+				if diff := cmp.Diff(test.want, test.in); diff != "" {
+					t.Errorf("mismatch (-want, +got):\n%s", diff)
+				}
+			}
+			if got := len(v.repairs); got != test.wantRepairs {
+				t.Errorf("got %d repairs (%v), want %d", got, v.repairs, test.wantRepairs)
+			}
+		})
+	}
+
+	// validateRows drops rejected rows.
+	rows := []bigquery.Row{
+		&analysis.Result{ModulePath: "m", Version: "v1.0.0"},
+		&analysis.Result{Version: "v1.0.0"},
+	}
+	if got := validateRows(context.Background(), analysis.TableName, rows); len(got) != 1 || got[0] != rows[0] {
+		t.Errorf("validateRows: got %v, want only the first row", got)
+	}
+}
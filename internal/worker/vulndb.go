@@ -11,11 +11,13 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"fmt"
 	"net/http"
 
+	"cloud.google.com/go/civil"
 	"cloud.google.com/go/storage"
 	"google.golang.org/api/iterator"
 
@@ -24,6 +26,7 @@ import (
 	"golang.org/x/pkgsite-metrics/internal/derrors"
 	"golang.org/x/pkgsite-metrics/internal/log"
 	"golang.org/x/pkgsite-metrics/internal/osv"
+	"golang.org/x/pkgsite-metrics/internal/scan"
 	"golang.org/x/pkgsite-metrics/internal/vulndb"
 	"golang.org/x/pkgsite-metrics/internal/vulndbreqs"
 )
@@ -43,7 +46,11 @@ func (s *Server) handleComputeRequests(w http.ResponseWriter, r *http.Request) (
 	if err != nil {
 		return err
 	}
-	err = vulndbreqs.ComputeAndStore(ctx, s.cfg.VulnDBBucketProjectID, vClient, []byte(hmacKey))
+	ex, err := vulndbreqs.ReadExclusions(ctx, s.cfg.VulnDBExclusions)
+	if err != nil {
+		return err
+	}
+	err = vulndbreqs.ComputeAndStore(ctx, s.cfg.VulnDBBucketProjectID, vClient, []byte(hmacKey), ex)
 	if err != nil {
 		return err
 	}
@@ -51,10 +58,125 @@ func (s *Server) handleComputeRequests(w http.ResponseWriter, r *http.Request) (
 	return nil
 }
 
+// handleVulnDBReqsCounts serves the daily counts of requests to the vuln DB
+// as JSON, most recent first. The from and to query params are dates in
+// YYYY-MM-DD form, inclusive. By default, to is today (UTC) and from is
+// defaultRequestCountsDays days earlier. Responses are cached for
+// requestCountsTTL.
+// Like the worker's other endpoints, it requires an identity token
+// authorized by Cloud Run IAM, so dashboards don't need BigQuery access.
+func (s *Server) handleVulnDBReqsCounts(w http.ResponseWriter, r *http.Request) (err error) {
+	defer derrors.Wrap(&err, "handleVulnDBReqsCounts")
+
+	ctx := r.Context()
+	from, to, err := parseDateRange(r, civil.DateOf(time.Now().UTC()))
+	if err != nil {
+		return fmt.Errorf("%w: %v", derrors.InvalidArgument, err)
+	}
+	rcs, err := s.requestCounts.get(from, to, time.Now(), func() ([]*vulndbreqs.RequestCount, error) {
+		// Don't use the Server's BigQuery client: it's for the wrong
+		// dataset.
+		vClient, err := bigquery.NewClientCreate(ctx, s.cfg.ProjectID, vulndbreqs.DatasetName)
+		if err != nil {
+			return nil, err
+		}
+		defer vClient.Close()
+		return vulndbreqs.ReadRequestCounts(ctx, vClient, from, to)
+	})
+	if err != nil {
+		return err
+	}
+	if rcs == nil {
+		rcs = []*vulndbreqs.RequestCount{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return writeJSON(w, rcs)
+}
+
+// defaultRequestCountsDays is the number of days before the to date
+// that handleVulnDBReqsCounts serves if there is no from date.
+const defaultRequestCountsDays = 30
+
+// parseDateRange parses the from and to query params of r.
+// If to is missing, it is today. If from is missing, it is
+// defaultRequestCountsDays before to.
+func parseDateRange(r *http.Request, today civil.Date) (from, to civil.Date, err error) {
+	to = today
+	if v := r.FormValue("to"); v != "" {
+		to, err = civil.ParseDate(v)
+		if err != nil {
+			return from, to, fmt.Errorf("to: %v", err)
+		}
+	}
+	from = to.AddDays(-defaultRequestCountsDays)
+	if v := r.FormValue("from"); v != "" {
+		from, err = civil.ParseDate(v)
+		if err != nil {
+			return from, to, fmt.Errorf("from: %v", err)
+		}
+	}
+	if to.Before(from) {
+		return from, to, fmt.Errorf("from (%s) is after to (%s)", from, to)
+	}
+	return from, to, nil
+}
+
+// requestCountsTTL is how long request counts read from BigQuery are cached.
+// They are computed once a day, so an hour is plenty fresh.
+const requestCountsTTL = time.Hour
+
+// requestCountsCache caches request counts by date range.
+// The zero value is ready to use.
+type requestCountsCache struct {
+	mu      sync.Mutex
+	entries map[[2]civil.Date]requestCountsEntry
+}
+
+type requestCountsEntry struct {
+	counts  []*vulndbreqs.RequestCount
+	fetched time.Time
+}
+
+// get returns the cached request counts for the dates from through to, if
+// they were fetched less than requestCountsTTL before now. Otherwise it
+// calls read and caches the result, if read succeeds.
+// Only one read happens at a time, so concurrent requests for the same
+// range don't all go to BigQuery.
+func (c *requestCountsCache) get(from, to civil.Date, now time.Time, read func() ([]*vulndbreqs.RequestCount, error)) ([]*vulndbreqs.RequestCount, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := [2]civil.Date{from, to}
+	if e, ok := c.entries[key]; ok && now.Sub(e.fetched) < requestCountsTTL {
+		return e.counts, nil
+	}
+	counts, err := read()
+	if err != nil {
+		return nil, err
+	}
+	if c.entries == nil {
+		c.entries = map[[2]civil.Date]requestCountsEntry{}
+	}
+	// Drop expired entries, so the cache doesn't grow without bound.
+	for k, e := range c.entries {
+		if now.Sub(e.fetched) >= requestCountsTTL {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = requestCountsEntry{counts, now}
+	return counts, nil
+}
+
+// handleVulnDB stores the entries of the vulnerability database in BigQuery.
+// By default, it reads only the OSV files that were updated after the most
+// recent modified time in BigQuery. With force=true, it reads all of them.
 func (s *Server) handleVulnDB(w http.ResponseWriter, r *http.Request) (err error) {
 	defer derrors.Wrap(&err, "handleVulnDB")
 
 	ctx := r.Context()
+	force, err := scan.ParseOptionalBoolParam(r, "force", false)
+	if err != nil {
+		return fmt.Errorf("%w: force: %v", derrors.InvalidArgument, err)
+	}
 	dbClient, err := bigquery.NewClientCreate(ctx, s.cfg.ProjectID, vulndb.DatasetName)
 	if err != nil {
 		return err
@@ -73,7 +195,18 @@ func (s *Server) handleVulnDB(w http.ResponseWriter, r *http.Request) (err error
 	if err != nil {
 		return err
 	}
-	entries, err := vulndbEntries(ctx, bucket)
+	var since time.Time
+	if !force {
+		last, err := vulndb.ReadLastModifiedTime(ctx, dbClient)
+		if err != nil {
+			return err
+		}
+		if !last.IsZero() {
+			since = last.Add(-syncSlack)
+		}
+	}
+	log.Infof(ctx, "syncing vulndb entries updated after %s (force=%t)", since, force)
+	entries, err := vulndbEntries(ctx, bucket, since)
 	if err != nil {
 		return err
 	}
@@ -85,7 +218,7 @@ func (s *Server) handleVulnDB(w http.ResponseWriter, r *http.Request) (err error
 			log.Infof(ctx, "skipping entry %s, it has not been modified", e.ID)
 			continue
 		}
-		if err = writeResult(ctx, false, w, dbClient, vulndb.TableName, e); err != nil {
+		if err = writeResult(ctx, false, w, dbClient, nil, vulndb.TableName, e); err != nil {
 			return err
 		}
 	}
@@ -93,8 +226,15 @@ func (s *Server) handleVulnDB(w http.ResponseWriter, r *http.Request) (err error
 	return nil
 }
 
-func vulndbEntries(ctx context.Context, bucket *storage.BucketHandle) ([]*vulndb.Entry, error) {
-	osvEntries, err := allVulnerabilities(ctx, bucket)
+// syncSlack is subtracted from the last modified time when syncing,
+// to allow for files whose upload time is a little earlier than their
+// modified time. Entries that have not changed are skipped anyway.
+const syncSlack = time.Hour
+
+// vulndbEntries returns the entries of the OSV files in bucket updated
+// after since. If since is zero, it returns all entries.
+func vulndbEntries(ctx context.Context, bucket *storage.BucketHandle, since time.Time) ([]*vulndb.Entry, error) {
+	osvEntries, err := allVulnerabilities(ctx, bucket, since)
 	if err != nil {
 		return nil, err
 	}
@@ -109,8 +249,9 @@ func vulndbEntries(ctx context.Context, bucket *storage.BucketHandle) ([]*vulndb
 // files with OSV entries are located.
 const gcsOSVPrefix = "ID"
 
-// allVulnerabilities fetches all osv.Entries from GCS bucket located at ID/*.json paths.
-func allVulnerabilities(ctx context.Context, bucket *storage.BucketHandle) ([]*osv.Entry, error) {
+// allVulnerabilities fetches all osv.Entries from GCS bucket located at ID/*.json paths
+// whose objects were updated after since.
+func allVulnerabilities(ctx context.Context, bucket *storage.BucketHandle, since time.Time) ([]*osv.Entry, error) {
 	var entries []*osv.Entry
 	query := &storage.Query{Prefix: gcsOSVPrefix}
 	it := bucket.Objects(ctx, query)
@@ -122,8 +263,7 @@ func allVulnerabilities(ctx context.Context, bucket *storage.BucketHandle) ([]*o
 		if err != nil {
 			return nil, err
 		}
-		// Skip zip files and index.json.
-		if !strings.HasSuffix(attrs.Name, ".json") || strings.HasSuffix(attrs.Name, "index.json") {
+		if !shouldSync(attrs, since) {
 			continue
 		}
 
@@ -136,6 +276,16 @@ func allVulnerabilities(ctx context.Context, bucket *storage.BucketHandle) ([]*o
 	return entries, nil
 }
 
+// shouldSync reports whether the object with attrs is an OSV entry
+// updated after since.
+func shouldSync(attrs *storage.ObjectAttrs, since time.Time) bool {
+	// Skip zip files and index.json.
+	if !strings.HasSuffix(attrs.Name, ".json") || strings.HasSuffix(attrs.Name, "index.json") {
+		return false
+	}
+	return attrs.Updated.After(since)
+}
+
 func readEntry(ctx context.Context, bucket *storage.BucketHandle, gcsPath string) (*osv.Entry, error) {
 	localPath := filepath.Join(os.TempDir(), "binary")
 	if err := copyToLocalFile(localPath, false, gcsPath, gcsOpenFileFunc(ctx, bucket)); err != nil {
@@ -152,7 +302,7 @@ func readEntry(ctx context.Context, bucket *storage.BucketHandle, gcsPath string
 	return &entry, nil
 }
 
-func lastModified(ctx context.Context, c *bigquery.Client) (map[string]time.Time, error) {
+func lastModified(ctx context.Context, c bigquery.DB) (map[string]time.Time, error) {
 	es, err := vulndb.ReadMostRecentDB(ctx, c)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,271 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Snapshots of the vulnerability database.
+//
+// Reproducible experiments and counterfactual analyses need to scan with
+// the vulnerability database as it was at some point in the past, rather
+// than with the one in the worker's image. Such snapshots are directories
+// in GCS with the layout of the database (index/db.json, ID/*.json and so
+// on). A scan that asks for one downloads it once per worker instance into
+// a directory that the sandbox mounts read-only, and records its URL in
+// the work version. Since that directory is in memory on Cloud Run, only
+// the most recently used snapshots are kept.
+
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/log"
+	"golang.org/x/pkgsite-metrics/internal/scan"
+	"google.golang.org/api/iterator"
+)
+
+// vulnDBSnapshotsDir is the directory holding downloaded snapshots of the
+// vulnerability database. The sandbox mounts it read-only to the same path
+// internally, so its paths work for both secure and insecure modes.
+const vulnDBSnapshotsDir = "/tmp/vulndb-snapshots"
+
+// vulnDBSnapshotURL returns the gs:// URL of the vulnerability database
+// snapshot named by param, which is either a gs:// URL or a date like
+// 2024-01-01, naming a snapshot in the directory snapshotsURL.
+func vulnDBSnapshotURL(param, snapshotsURL string) (string, error) {
+	if strings.HasPrefix(param, "gs://") {
+		if _, _, err := scan.SplitGCSURL(param); err != nil {
+			return "", fmt.Errorf("bad vuln DB snapshot %q: %v", param, err)
+		}
+		return strings.TrimSuffix(param, "/"), nil
+	}
+	if _, err := time.Parse(time.DateOnly, param); err != nil {
+		return "", fmt.Errorf("bad vuln DB snapshot %q: want a date like 2024-01-01 or a gs:// URL", param)
+	}
+	if snapshotsURL == "" {
+		return "", fmt.Errorf("vuln DB snapshot %q: no snapshot location is configured; use a gs:// URL", param)
+	}
+	return strings.TrimSuffix(snapshotsURL, "/") + "/" + param, nil
+}
+
+// maxVulnDBSnapshots is the number of downloaded vuln DB snapshots that
+// are kept when no scan uses them. A snapshot takes a few hundred MB.
+const maxVulnDBSnapshots = 3
+
+// A vulnDBSnapshotCache holds downloaded vuln DB snapshots. It keeps at
+// most max of them, removing the least recently used ones that no scan is
+// using.
+type vulnDBSnapshotCache struct {
+	dir string // directory holding the snapshots
+	max int
+	// download copies the objects under the gs:// URL url to dir, and
+	// returns their number.
+	download func(ctx context.Context, url, dir string) (int, error)
+
+	// mu serializes downloads, so that concurrent scans with the same
+	// snapshot download it only once.
+	mu        sync.Mutex
+	snapshots map[string]*vulnDBSnapshot // by local directory
+}
+
+// A vulnDBSnapshot is a downloaded vuln DB snapshot.
+type vulnDBSnapshot struct {
+	users    int // number of scans using the snapshot
+	lastUsed time.Time
+}
+
+// vulnDBSnapshots holds the vuln DB snapshots of this process.
+var vulnDBSnapshots = &vulnDBSnapshotCache{
+	dir:      vulnDBSnapshotsDir,
+	max:      maxVulnDBSnapshots,
+	download: downloadGCSURL,
+}
+
+// get downloads the vulnerability database snapshot at the gs:// URL url,
+// unless it was already downloaded, and returns the local directory holding
+// it. The snapshot is not removed until the returned function is called.
+func (c *vulnDBSnapshotCache) get(ctx context.Context, url string) (_ string, release func(), err error) {
+	defer derrors.Wrap(&err, "vulnDBSnapshotCache.get(%q)", url)
+
+	bucketName, prefix, err := scan.SplitGCSURL(url)
+	if err != nil {
+		return "", nil, err
+	}
+	dir := filepath.Join(c.dir, bucketName, filepath.FromSlash(prefix))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, err := os.Stat(filepath.Join(dir, "index", "db.json")); err != nil {
+		if err := c.downloadTo(ctx, url, dir); err != nil {
+			return "", nil, err
+		}
+	}
+	if c.snapshots == nil {
+		c.snapshots = map[string]*vulnDBSnapshot{}
+	}
+	snap := c.snapshots[dir]
+	if snap == nil {
+		snap = &vulnDBSnapshot{}
+		c.snapshots[dir] = snap
+	}
+	snap.users++
+	snap.lastUsed = time.Now()
+	c.evict(ctx)
+	return dir, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		snap.users--
+		snap.lastUsed = time.Now()
+		c.evict(ctx)
+	}, nil
+}
+
+// downloadTo downloads the snapshot at url to dir.
+func (c *vulnDBSnapshotCache) downloadTo(ctx context.Context, url, dir string) error {
+	// Download into a temporary directory and rename it, so that a failed
+	// download never leaves a partial snapshot behind.
+	if err := os.MkdirAll(c.dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(c.dir, "download-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	start := time.Now()
+	n, err := c.download(ctx, url, tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(tmp, "index", "db.json")); err != nil {
+		return fmt.Errorf("%w: %s is not a vulnerability database", derrors.NotFound, url)
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), 0o755); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		return err
+	}
+	log.Infof(ctx, "downloaded vuln DB snapshot %s (%d files) to %s in %s", url, n, dir, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// evict removes the least recently used snapshots that are not in use
+// until at most c.max remain, or all remaining ones are in use.
+// c.mu must be held.
+func (c *vulnDBSnapshotCache) evict(ctx context.Context) {
+	for len(c.snapshots) > c.max {
+		var oldest string
+		for dir, snap := range c.snapshots {
+			if snap.users == 0 && (oldest == "" || snap.lastUsed.Before(c.snapshots[oldest].lastUsed)) {
+				oldest = dir
+			}
+		}
+		if oldest == "" {
+			return
+		}
+		delete(c.snapshots, oldest)
+		if err := os.RemoveAll(oldest); err != nil {
+			log.Errorf(ctx, err, "removing vuln DB snapshot %s", oldest)
+			continue
+		}
+		log.Infof(ctx, "removed vuln DB snapshot %s", oldest)
+	}
+}
+
+// downloadGCSURL copies the objects under the gs:// URL url to dir, and
+// returns their number.
+func downloadGCSURL(ctx context.Context, url, dir string) (int, error) {
+	bucketName, prefix, err := scan.SplitGCSURL(url)
+	if err != nil {
+		return 0, err
+	}
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer client.Close()
+	return downloadGCSDir(ctx, client.Bucket(bucketName), prefix+"/", dir)
+}
+
+// downloadGCSDir copies the objects in bucket whose names begin with
+// prefix to dir, preserving the rest of their names as relative paths.
+// It returns the number of objects copied.
+func downloadGCSDir(ctx context.Context, bucket *storage.BucketHandle, prefix, dir string) (int, error) {
+	n := 0
+	iter := bucket.Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		rel := strings.TrimPrefix(attrs.Name, prefix)
+		if rel == "" || strings.HasSuffix(rel, "/") {
+			// Directory placeholder.
+			continue
+		}
+		if !filepath.IsLocal(rel) {
+			return 0, fmt.Errorf("bad object name %q", attrs.Name)
+		}
+		if err := downloadGCSObject(ctx, bucket, attrs.Name, filepath.Join(dir, filepath.FromSlash(rel))); err != nil {
+			return 0, err
+		}
+		n++
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("%w: no objects under %s", derrors.NotFound, prefix)
+	}
+	return n, nil
+}
+
+func downloadGCSObject(ctx context.Context, bucket *storage.BucketHandle, object, filename string) (err error) {
+	defer derrors.Wrap(&err, "downloadGCSObject(%q)", object)
+	if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+		return err
+	}
+	r, err := bucket.Object(object).NewReader(ctx)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer derrors.Cleanup(&err, f.Close)
+	_, err = io.Copy(f, r)
+	return err
+}
+
+// useVulnDBSnapshot makes s scan with the vulnerability database snapshot
+// at url, downloaded to dir, and records the snapshot in s's work version.
+func (s *scanner) useVulnDBSnapshot(url, dir string) error {
+	lmt, err := dbLastModified(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%w: %s is not a vulnerability database", derrors.NotFound, url)
+		}
+		return err
+	}
+	// The work version is shared by all scans; copy it.
+	wv := *s.workVersion
+	wv.VulnDBLastModified = lmt
+	wv.VulnDBSnapshot = bigquery.NullString(url)
+	s.workVersion = &wv
+	s.vulnDBDir = dir
+	return nil
+}
@@ -0,0 +1,142 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package worker
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/pkgsite-metrics/internal/bigquery"
+	"golang.org/x/pkgsite-metrics/internal/derrors"
+	"golang.org/x/pkgsite-metrics/internal/govulncheck"
+)
+
+func TestVulnDBSnapshotURL(t *testing.T) {
+	for _, test := range []struct {
+		param, snapshots string
+		want             string // empty for error
+	}{
+		{"2024-01-01", "gs://snaps", "gs://snaps/2024-01-01"},
+		{"2024-01-01", "gs://snaps/vulndb/", "gs://snaps/vulndb/2024-01-01"},
+		{"gs://b/2024-01-01", "", "gs://b/2024-01-01"},
+		{"gs://b/dir/2024-01-01/", "gs://snaps", "gs://b/dir/2024-01-01"},
+		{"2024-01-01", "", ""},
+		{"2024-13-01", "gs://snaps", ""},
+		{"latest", "gs://snaps", ""},
+		{"gs://b", "", ""},
+		{"/local/dir", "", ""},
+	} {
+		got, err := vulnDBSnapshotURL(test.param, test.snapshots)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("%q, %q: got %q, want error", test.param, test.snapshots, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q, %q: %v", test.param, test.snapshots, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%q, %q: got %q, want %q", test.param, test.snapshots, got, test.want)
+		}
+	}
+}
+
+func TestUseVulnDBSnapshot(t *testing.T) {
+	wv := &govulncheck.WorkVersion{
+		WorkerVersion:      "1",
+		VulnDBLastModified: time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC),
+	}
+	s := &scanner{workVersion: wv, vulnDBDir: "/app/go-vulndb"}
+
+	dir := t.TempDir()
+	const url = "gs://b/2024-01-01"
+	if err := s.useVulnDBSnapshot(url, dir); !errors.Is(err, derrors.NotFound) {
+		t.Fatalf("got %v, want NotFound", err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, "index"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "index", "db.json"), []byte(`{"modified":"2023-12-31T10:00:00Z"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.useVulnDBSnapshot(url, dir); err != nil {
+		t.Fatal(err)
+	}
+	if s.vulnDBDir != dir {
+		t.Errorf("got vulnDBDir %q, want %q", s.vulnDBDir, dir)
+	}
+	want := govulncheck.WorkVersion{
+		WorkerVersion:      "1",
+		VulnDBLastModified: time.Date(2023, 12, 31, 10, 0, 0, 0, time.UTC),
+		VulnDBSnapshot:     bigquery.NullString(url),
+	}
+	if !s.workVersion.Equal(&want) {
+		t.Errorf("got work version %+v, want %+v", s.workVersion, want)
+	}
+	// The server's work version is unchanged.
+	if wv.VulnDBSnapshot.Valid || !wv.VulnDBLastModified.Equal(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("shared work version changed: %+v", wv)
+	}
+}
+
+func TestVulnDBSnapshotCache(t *testing.T) {
+	ctx := context.Background()
+	downloads := 0
+	c := &vulnDBSnapshotCache{
+		dir: t.TempDir(),
+		max: 1,
+		download: func(_ context.Context, url, dir string) (int, error) {
+			downloads++
+			if err := os.MkdirAll(filepath.Join(dir, "index"), 0o755); err != nil {
+				return 0, err
+			}
+			return 1, os.WriteFile(filepath.Join(dir, "index", "db.json"), []byte(`{}`), 0o644)
+		},
+	}
+	exists := func(dir string) bool {
+		_, err := os.Stat(dir)
+		return err == nil
+	}
+
+	dir1, release1, err := c.get(ctx, "gs://b/2024-01-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A snapshot is downloaded once.
+	_, release, err := c.get(ctx, "gs://b/2024-01-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if downloads != 1 {
+		t.Errorf("got %d downloads, want 1", downloads)
+	}
+
+	// Snapshots in use are kept, even beyond the maximum.
+	dir2, release2, err := c.get(ctx, "gs://b/2024-02-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists(dir1) || !exists(dir2) {
+		t.Fatal("snapshot in use was removed")
+	}
+
+	// The least recently used snapshot is removed when it is released.
+	release1()
+	if exists(dir1) {
+		t.Error("least recently used snapshot was kept")
+	}
+	release2()
+	if !exists(dir2) {
+		t.Error("most recently used snapshot was removed")
+	}
+}
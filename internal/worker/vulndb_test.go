@@ -6,10 +6,16 @@ package worker
 
 import (
 	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
+	"cloud.google.com/go/civil"
 	"cloud.google.com/go/storage"
 	test "golang.org/x/pkgsite-metrics/internal/testing"
+	"golang.org/x/pkgsite-metrics/internal/vulndbreqs"
 )
 
 func TestIntegrationAllVulns(t *testing.T) {
@@ -24,7 +30,7 @@ func TestIntegrationAllVulns(t *testing.T) {
 	if bucket == nil {
 		t.Fatal("failed to create go-vulndb bucket")
 	}
-	es, err := allVulnerabilities(ctx, bucket)
+	es, err := allVulnerabilities(ctx, bucket, time.Time{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -32,3 +38,96 @@ func TestIntegrationAllVulns(t *testing.T) {
 		t.Fatal("want some vulnerabilities; got none")
 	}
 }
+
+func TestShouldSync(t *testing.T) {
+	since := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		name    string
+		updated time.Time
+		since   time.Time
+		want    bool
+	}{
+		{"ID/GO-2023-0001.json", since.Add(time.Hour), since, true},
+		{"ID/GO-2023-0001.json", since.Add(-time.Hour), since, false},
+		{"ID/GO-2023-0001.json", since, since, false},
+		{"ID/GO-2023-0001.json", since.Add(-time.Hour), time.Time{}, true},
+		{"ID/index.json", since.Add(time.Hour), since, false},
+		{"ID/all.zip", since.Add(time.Hour), time.Time{}, false},
+	} {
+		attrs := &storage.ObjectAttrs{Name: test.name, Updated: test.updated}
+		if got := shouldSync(attrs, test.since); got != test.want {
+			t.Errorf("shouldSync(%s, updated %s, since %s) = %t, want %t",
+				test.name, test.updated, test.since, got, test.want)
+		}
+	}
+}
+
+func TestParseDateRange(t *testing.T) {
+	today := civil.Date{Year: 2023, Month: 6, Day: 30}
+	for _, test := range []struct {
+		query            string
+		wantFrom, wantTo string
+		wantErr          bool
+	}{
+		{"", "2023-05-31", "2023-06-30", false},
+		{"to=2023-06-10", "2023-05-11", "2023-06-10", false},
+		{"from=2023-06-01", "2023-06-01", "2023-06-30", false},
+		{"from=2023-06-01&to=2023-06-01", "2023-06-01", "2023-06-01", false},
+		{"from=2023-06-02&to=2023-06-01", "", "", true},
+		{"from=June", "", "", true},
+		{"to=2023-13-01", "", "", true},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/vulndbreqs/counts?"+test.query, nil)
+		from, to, err := parseDateRange(r, today)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%q: got error %v, want error: %t", test.query, err, test.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if from.String() != test.wantFrom || to.String() != test.wantTo {
+			t.Errorf("%q: got (%s, %s), want (%s, %s)", test.query, from, to, test.wantFrom, test.wantTo)
+		}
+	}
+}
+
+func TestRequestCountsCache(t *testing.T) {
+	var c requestCountsCache
+	reads := 0
+	read := func() ([]*vulndbreqs.RequestCount, error) {
+		reads++
+		return []*vulndbreqs.RequestCount{{Count: reads}}, nil
+	}
+	d1 := civil.Date{Year: 2023, Month: 6, Day: 1}
+	d2 := civil.Date{Year: 2023, Month: 6, Day: 2}
+	now := time.Now()
+
+	get := func(from, to civil.Date, now time.Time, want int) {
+		t.Helper()
+		rcs, err := c.get(from, to, now, read)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := rcs[0].Count; got != want {
+			t.Errorf("got count %d, want %d", got, want)
+		}
+	}
+	get(d1, d2, now, 1)
+	get(d1, d2, now.Add(time.Minute), 1)      // cached
+	get(d2, d2, now.Add(time.Minute), 2)      // different range
+	get(d1, d2, now.Add(requestCountsTTL), 3) // expired
+	// Reading a new range drops the expired entries.
+	later := now.Add(3 * requestCountsTTL)
+	get(d1, d1, later, 4)
+	if got := len(c.entries); got != 1 {
+		t.Errorf("got %d entries, want 1", got)
+	}
+
+	// Errors are not cached.
+	_, err := c.get(d2, d2, later, func() ([]*vulndbreqs.RequestCount, error) { return nil, errors.New("bad") })
+	if err == nil {
+		t.Fatal("got nil error")
+	}
+	get(d2, d2, later, 5)
+}
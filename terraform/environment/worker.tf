@@ -57,6 +57,7 @@ locals {
   concurrency         = 1
   container_mem_limit = 32                                     # container memory limit in gigabytes
   go_mem_limit        = floor(local.container_mem_limit * 0.9) # allow 10% for other users of memory
+  scan_disk_quota_mb  = 8192                                   # disk space a single scan may use; /tmp is in memory
 }
 
 resource "google_cloud_run_service" "worker" {
@@ -116,6 +117,18 @@ resource "google_cloud_run_service" "worker" {
           name  = "GO_ECOSYSTEM_QUEUE_NAME"
           value = "${var.env}-worker-tasks"
         }
+        env {
+          name  = "GO_ECOSYSTEM_QUEUE_NAME_HIGH"
+          value = "${var.env}-worker-tasks-high"
+        }
+        env {
+          name  = "GO_ECOSYSTEM_QUEUE_NAME_LOW"
+          value = "${var.env}-worker-tasks-low"
+        }
+        env {
+          name  = "GO_ECOSYSTEM_SCAN_DISK_QUOTA_MB"
+          value = local.scan_disk_quota_mb
+        }
         env {
           name = "GITHUB_ACCESS_TOKEN"
           value_from {
@@ -230,6 +243,53 @@ resource "google_cloud_tasks_queue" "worker_tasks" {
   }
 }
 
+resource "google_cloud_tasks_queue" "worker_tasks_high" {
+  name     = "${var.env}-worker-tasks-high"
+  location = var.region
+  project  = var.project
+
+  rate_limits {
+    max_concurrent_dispatches = 200
+    max_dispatches_per_second = 500
+  }
+
+  retry_config {
+    max_attempts       = 100
+    max_backoff        = "1440s"
+    max_doublings      = 16
+    max_retry_duration = "604800s"
+    min_backoff        = "60s"
+  }
+
+  stackdriver_logging_config {
+    sampling_ratio = 1
+  }
+}
+
+# Bulk corpus runs. Fewer concurrent dispatches leave room for other work.
+resource "google_cloud_tasks_queue" "worker_tasks_low" {
+  name     = "${var.env}-worker-tasks-low"
+  location = var.region
+  project  = var.project
+
+  rate_limits {
+    max_concurrent_dispatches = 50
+    max_dispatches_per_second = 500
+  }
+
+  retry_config {
+    max_attempts       = 100
+    max_backoff        = "1440s"
+    max_doublings      = 16
+    max_retry_duration = "604800s"
+    min_backoff        = "60s"
+  }
+
+  stackdriver_logging_config {
+    sampling_ratio = 1
+  }
+}
+
 resource "google_secret_manager_secret" "github_access_token" {
   secret_id = "${var.env}-github-access-token"
   project   = var.project
@@ -332,3 +392,19 @@ resource "google_cloud_scheduler_job" "gomodstat" {
     }
   }
 }
+
+# jobs.DB.ListJobs filters jobs by user and orders them by start time,
+# which needs a composite index.
+resource "google_firestore_index" "jobs_by_user" {
+  project    = var.project
+  collection = "Jobs"
+
+  fields {
+    field_path = "User"
+    order      = "ASCENDING"
+  }
+  fields {
+    field_path = "StartedAt"
+    order      = "DESCENDING"
+  }
+}